@@ -3,6 +3,7 @@ package broker_core_module
 import (
 	"errors"
 	"net"
+	"net/http"
 	"runtime"
 	"strconv"
 	"sync"
@@ -10,15 +11,54 @@ import (
 
 	"awesomeProject/beacon/general_toolbox/logger"
 
+	"awesomeProject/beacon/mqtt_network/libs/acl"
+	"awesomeProject/beacon/mqtt_network/libs/annotations"
+	"awesomeProject/beacon/mqtt_network/libs/auth"
+	"awesomeProject/beacon/mqtt_network/libs/autosub"
+	"awesomeProject/beacon/mqtt_network/libs/bridge"
+	"awesomeProject/beacon/mqtt_network/libs/certmon"
+	"awesomeProject/beacon/mqtt_network/libs/chaos"
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+	"awesomeProject/beacon/mqtt_network/libs/compress"
+	"awesomeProject/beacon/mqtt_network/libs/computed"
+	"awesomeProject/beacon/mqtt_network/libs/config"
+	"awesomeProject/beacon/mqtt_network/libs/delayed"
+	"awesomeProject/beacon/mqtt_network/libs/gctune"
+	"awesomeProject/beacon/mqtt_network/libs/handoff"
+	"awesomeProject/beacon/mqtt_network/libs/keepalive"
+	"awesomeProject/beacon/mqtt_network/libs/logging"
+	"awesomeProject/beacon/mqtt_network/libs/memacct"
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+	"awesomeProject/beacon/mqtt_network/libs/namespace"
+	"awesomeProject/beacon/mqtt_network/libs/outbound"
+	"awesomeProject/beacon/mqtt_network/libs/plugins"
 	"awesomeProject/beacon/mqtt_network/libs/pool"
+	"awesomeProject/beacon/mqtt_network/libs/qosreport"
+	"awesomeProject/beacon/mqtt_network/libs/quota"
+	"awesomeProject/beacon/mqtt_network/libs/receipts"
+	"awesomeProject/beacon/mqtt_network/libs/relay"
+	"awesomeProject/beacon/mqtt_network/libs/replay"
+	"awesomeProject/beacon/mqtt_network/libs/retaincrdt"
+	"awesomeProject/beacon/mqtt_network/libs/rewrite"
+	"awesomeProject/beacon/mqtt_network/libs/sampling"
+	"awesomeProject/beacon/mqtt_network/libs/schedule"
+	"awesomeProject/beacon/mqtt_network/libs/schema"
+	"awesomeProject/beacon/mqtt_network/libs/seal"
 	"awesomeProject/beacon/mqtt_network/libs/sessions"
+	"awesomeProject/beacon/mqtt_network/libs/statelog"
+	"awesomeProject/beacon/mqtt_network/libs/storecheck"
+	"awesomeProject/beacon/mqtt_network/libs/sysstats"
+	"awesomeProject/beacon/mqtt_network/libs/topiclog"
 	"awesomeProject/beacon/mqtt_network/libs/topics"
 	"awesomeProject/beacon/mqtt_network/libs/topics_p2p"
+	"awesomeProject/beacon/mqtt_network/libs/tracing"
+	"awesomeProject/beacon/mqtt_network/libs/transform"
 
 	p2p "awesomeProject/beacon/p2p_network/core_module"
 	"awesomeProject/beacon/p2p_network/libs/common"
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/quic-go/quic-go"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
@@ -32,6 +72,12 @@ const (
 
 	defaultMQTTBindPort = 1883
 	defaultMQTTBindHost = "127.0.0.1"
+
+	// The number of recently published topics kept for ExpandFilter
+	defaultRecentTopics = 1024
+
+	// How often the expired retained messages are purged
+	defaultRetainSweep = time.Minute
 )
 
 const (
@@ -42,18 +88,31 @@ const (
 )
 
 type Message struct {
-	client *client
-	packet packets.ControlPacket
+	client   *client
+	packet   packets.ControlPacket
+	v5       *mqtt5.Packet
+	received time.Time
+	// The publish holds a slot of the inflight limit of the client until it's processed
+	inflight bool
+	// The size of the packet, accounted to the client until it's processed
+	size int
 }
 
 type Broker struct {
 	mu     sync.Mutex
 	logger *zap.Logger
+	clock  clock.Clock
 
 	fixedWorkPool     *pool.FixedWorkPool
+	tenants           []pool.Tenant
+	tenantPools       *pool.TenantPools
 	topicsManager     *topics.Manager
 	sessionManager    *sessions.Manager
 	topicsManager4P2P *topics_p2p.Manager4P2P
+	transformManager  *transform.Manager
+
+	// The control packets run ahead of the publishes, in bursts of this many, 0 disables it
+	inboundPriorityBurst int
 
 	brokerNode *BrokerP2PNode
 	node       *p2p.Node
@@ -62,10 +121,268 @@ type Broker struct {
 	port uint16
 	addr string
 
-	clients sync.Map
+	clients    sync.Map
+	gatewayHub *gatewayHub
+	namespaces *namespace.Registry
+
+	latencyStats *latencyStats
+	stageLatency *stageLatency
+
+	computedList  []*computed.Computed
+	retainHistory []topics.HistoryDepth
+	recentTopics  int
+	topicsFile    string
+	scheduleFile  string
+	scheduler     *schedule.Scheduler
+
+	annotationsFile string
+	annotations     *annotations.Store
+
+	packetIDFile string
+	packetIDs    *sessions.PacketIDStore
+
+	// The sessions directory of the file provider, the queue bound of the offline clients, and
+	// the offline subscriptions by client id
+	sessionsDir  string
+	offlineQueue int
+	parked       sync.Map
+
+	// The interval of the $SYS statistics, 0 disables them
+	sysInterval time.Duration
+	sysStats    *sysstats.Counters
+	started     time.Time
+
+	// The pacing of the garbage collector, nil keeps the GOGC of the process
+	gcTuning   *gctune.Config
+	gcInterval time.Duration
+	gcTuner    *gctune.Tuner
+
+	// The priorities of the share group members set by config, and the unacknowledged deliveries
+	// saturating a member
+	sharePriorities []SharePriority
+	shareSaturation int
+
+	// How the properties of a 5.0 publish are delivered to the 3.1.1 subscribers
+	downgradePolicy string
+
+	// The delayed will messages, and the delay of the clients setting none
+	wills            *willScheduler
+	defaultWillDelay time.Duration
+
+	// The filters of the node-local topics, never forwarded to the peer brokers
+	localTopics []string
+
+	// The filters of the topics whose publishes are delivered in order, by publisher
+	orderedFilters []string
+
+	// The running wire capture, a *capture.Session nil if there is none
+	capture   atomic.Value
+	captureMu sync.Mutex
+
+	// The prefixes of the command topics owned by one broker per device, nil if there are none
+	commandPrefixes []string
+	commandRouter   *commandRouter
+
+	relayConfig *relay.Config
+	relay       *relay.Relay
+	relayRoutes sync.Map
+
+	samplingDefs []sampling.Definition
+	sampler      *sampling.Sampler
+	sampleSinks  sync.Map
+
+	tlsConfig    *certmon.Config
+	certMonitor  *certmon.Monitor
+	certIdentity *CertIdentity
 
 	listener  net.Listener
 	listening atomic.Bool
+	handedOff atomic.Bool
+
+	// The broker shutting down hands the persistent sessions over to the peer brokers for the
+	// window, 0 hands none. The 5.0 clients are sent to the server reference if it's set.
+	shuttingDown    atomic.Bool
+	handoverWindow  time.Duration
+	serverReference string
+	handovers       sync.Map
+	// closed by Shutdown, it stops the periodic tasks of the broker
+	quit chan struct{}
+
+	storeCheckRepair bool
+	storeReports     []*storecheck.Report
+
+	readOnly    bool
+	chaos       *chaos.Injector
+	batchPolicy BatchPolicyFunc
+
+	replayGuard *replay.Guard
+	replayToken ConnectReplayTokenFunc
+
+	// The number of topic aliases a 5.0 client may set on a connection
+	topicAliasMaximum uint16
+
+	// How long the retained messages published without a message expiry are kept, 0 is forever
+	retainedTTL time.Duration
+
+	// The deliveries, retransmissions and drops per subscription filter
+	qosReport *qosreport.Recorder
+
+	// The timing of the topics provider operations
+	topicsMetrics *topics.StatsSink
+
+	// The retained messages replicated between the brokers, nil if the replication is disabled
+	replicateRetained bool
+	retainReplication *retaincrdt.Map
+
+	// Checks the credentials of the CONNECT packets, nil accepts them all. The token clients are
+	// asked for a fresh token ahead of the expiry by the lead, and kept for the grace after it.
+	authManager *auth.Manager
+	reauthLead  time.Duration
+	reauthGrace time.Duration
+
+	// The options of the in-memory topics provider
+	memTopicsOptions []topics.MemOption
+
+	// The caps of the retained store of the in-memory topics provider, nil caps none
+	retainLimits *topics.RetainLimits
+
+	// The bounds of the subscription filters of the in-memory topics provider, nil bounds none
+	filterLimits *topics.FilterLimits
+
+	// The topic ACL of the publishes and the subscriptions, nil allows them all
+	aclFile string
+	acl     *acl.Engine
+
+	// The rules rewriting the topics of the inbound publishes and subscriptions, nil rewrites none
+	rewriteFile string
+	rewrite     *rewrite.Engine
+
+	// The dead letters of the dropped messages, nil if they're dropped silently
+	deadLetterConfig *DeadLetterConfig
+
+	// The admin clients of the control topics, nil if the control topics are plain topics
+	controlConfig *ControlConfig
+
+	// The topic namespaces of the tenants, nil if the clients share the topics
+	tenantNamespaceConfig *namespace.TenantConfig
+	tenantNamespaces      *namespace.Tenants
+
+	// The outbound queue of each client, nil writes the deliveries in the publishers, and the
+	// messages dropped by the queues and the slow subscribers they disconnected
+	outboundConfig       *outbound.Config
+	outboundDropped      atomic.Uint64
+	outboundDisconnected atomic.Uint64
+
+	// The idle timeout of the connections, and the timer wheels checking it and their keepalive
+	keepaliveConfig keepalive.Config
+	keepalives      *keepalive.Wheels
+
+	// The tracer of the messages, nil traces none, the spans of the publishes being delivered and
+	// the traceparents of the ones waiting to be forwarded to the peer brokers, by packet
+	tracer        tracing.Tracer
+	pendingSpans  sync.Map
+	forwardTraces sync.Map
+
+	// The config file of the broker and the config last read from it, its error is returned by
+	// NewBroker; the level of the logger built from the file, nil if the logger is not
+	configFile    string
+	config        *config.Config
+	configModTime time.Time
+	configErr     error
+	configMu      sync.Mutex
+	logLevel      *zap.AtomicLevel
+
+	// The loggers of the subsystems, and their levels
+	logLevels *logging.Levels
+
+	// The publishes to $delayed/<seconds>/<topic> waiting for their delay, persisted to the file if
+	// it's set
+	delayedFile string
+	maxDelayed  int
+	delayed     *delayed.Queue
+
+	// The memory accounted to the connections and its marks, nil accounts none
+	memoryConfig *memacct.Config
+	memory       *memacct.Accountant
+
+	// The templates of the subscriptions made for each client on CONNECT
+	autoSubscriptions []autosub.Subscription
+	autoSubMu         sync.RWMutex
+
+	// The secret of the cluster sealing the messages between the peer nodes, nil sends them in
+	// clear
+	peerSecret []byte
+	peerSealer *seal.Sealer
+
+	// The owners of the claimed topics, the only clients publishing to them
+	topicClaims []acl.Claim
+	topicOwners *acl.Owners
+
+	// The payloads retained or queued to the offline sessions are compressed over the threshold of
+	// the compressor, nil compresses none
+	compressionConfig *compress.Config
+	compressor        *compress.Compressor
+
+	// The limits of the clients, the auth provider may override them by username; limitsMu guards
+	// them once the config is reloaded
+	limitsMu sync.RWMutex
+	limits   quota.Limits
+
+	// The payload limits by topic filter, for all the clients, nil limits none
+	payloadLimitList []quota.PayloadLimit
+	payloadLimits    *quota.PayloadLimits
+
+	// The schemas of the payloads by topic prefix, nil validates none
+	schemaBindings []schema.Binding
+	schemas        *schema.Set
+
+	// The append-only log of the QoS 1 and 2 publishes on the configured topics, nil logs none
+	topicLogConfig *topiclog.Config
+	topicLog       *topiclog.Log
+
+	// The log of the state changes followed by the mirrors of the broker state, nil records none
+	stateLogConfig *statelog.Config
+	stateLog       *statelog.Log
+
+	// The restores of the persistent sessions waiting for their batch, nil inserts them at once
+	resubscribeRequests chan *resubscribeRequest
+
+	// The registered plugins intercepting the messages, in the order their hooks run, nil runs none
+	pluginNames []string
+	plugins     *plugins.Chain
+
+	// The MQTT over WebSocket listener, nil if it's disabled
+	wsConfig   *WebSocketConfig
+	wsListener net.Listener
+	wsServer   *http.Server
+
+	// The admin HTTP API listener, nil if it's disabled
+	adminConfig   *AdminConfig
+	adminListener net.Listener
+	adminServer   *http.Server
+
+	// The HTTP publish gateway listener, nil if it's disabled
+	gatewayConfig   *GatewayConfig
+	gatewayListener net.Listener
+	gatewayServer   *http.Server
+
+	// The MQTT-SN gateway, nil if it's disabled
+	mqttsnConfig  *MQTTSNConfig
+	mqttsnGateway *mqttsnGateway
+
+	// The receipts of the deliveries of the selected publishes, nil if there are none
+	receiptConfig   *receipts.Config
+	receipts        *receipts.Tracker
+	pendingReceipts sync.Map
+
+	// The bridges to the remote brokers, bridgesMu guards them once the config is reloaded
+	bridgesMu     sync.Mutex
+	bridgeConfigs []bridge.Config
+	bridges       []*brokerBridge
+
+	// The MQTT over QUIC listener, nil if it's disabled
+	quicConfig   *QUICConfig
+	quicListener *quic.EarlyListener
 }
 
 type subscription struct {
@@ -74,18 +391,81 @@ type subscription struct {
 	qos       byte
 	share     bool
 	groupName string
+
+	// The priority and the weight of a shared subscription in its group
+	priority int
+	weight   int
+
+	// The MQTT 5 options of the subscription
+	options topics.SubOptions
+}
+
+// SubscriberKey identifies the subscriptions of the client in the persistent topics providers.
+func (s *subscription) SubscriberKey() string {
+	return s.client.info.clientID
+}
+
+// ID keys the subscription of the client in the subscriber set of its filter, the subscription of
+// a client taking over the session replaces the previous one.
+func (s *subscription) ID() string {
+	return s.client.info.clientID
+}
+
+// OnPublish delivers the message to the client without the 5.0 properties of its publish, the
+// publishing paths of the broker deliver them with deliverProperties.
+func (s *subscription) OnPublish(message *packets.PublishPacket) error {
+	return s.client.deliverExt(message, s.topic, nil, nil)
+}
+
+// internalSubscriber is subscribed to the topics provider by the broker itself rather than by a
+// client, the matched packets are handed to it by the publishing paths.
+type internalSubscriber interface {
+	topics.Subscriber
+	deliver(b *Broker, packet *packets.PublishPacket)
+}
+
+// internalID returns the ID of an internal subscriber, it starts with a NUL that the client ids
+// can't hold, so the subscription of a client never replaces it.
+func internalID(kind string, name string) string {
+	return "\x00" + kind + "/" + name
 }
 
 func NewBroker(opts ...BrokerOption) (*Broker, error) {
 	b := &Broker{
-		mu:   sync.Mutex{},
-		host: net.ParseIP(defaultMQTTBindHost),
-		port: defaultMQTTBindPort,
+		mu:    sync.Mutex{},
+		clock: clock.Real,
+		host:  net.ParseIP(defaultMQTTBindHost),
+		port:  defaultMQTTBindPort,
+
+		gatewayHub:       newGatewayHub(),
+		namespaces:       namespace.NewRegistry(),
+		latencyStats:     newLatencyStats(),
+		stageLatency:     newStageLatency(),
+		recentTopics:     defaultRecentTopics,
+		storeCheckRepair: true,
+
+		inboundPriorityBurst: pool.DefaultPriorityBurst,
+
+		topicAliasMaximum: defaultTopicAliasMaximum,
+		reauthLead:        defaultReauthLead,
+		reauthGrace:       defaultReauthGrace,
+		offlineQueue:      defaultOfflineQueue,
+		sysInterval:       defaultSysInterval,
+		sysStats:          sysstats.New(),
+		shareSaturation:   defaultShareSaturation,
+		downgradePolicy:   mqtt5.DowngradeStrip,
+		wills:             newWillScheduler(),
+		localTopics:       defaultLocalTopics,
+		topicOwners:       acl.NewOwners(),
+		quit:              make(chan struct{}),
 	}
 
 	for _, opt := range opts {
 		opt(b)
 	}
+	if b.configErr != nil {
+		return nil, b.configErr
+	}
 
 	if b.node == nil {
 		err := errors.New("broker need to set node pointer, and cannot be nil, please check it ")
@@ -100,21 +480,92 @@ func NewBroker(opts ...BrokerOption) (*Broker, error) {
 		logger.InitLogger(false, b.brokerNode.brokerID.String())
 		b.logger = logger.Get()
 	}
+	b.initLogLevels()
+	if b.config != nil {
+		for name, level := range b.config.Logging.Levels {
+			if err := b.SetLogLevel(name, level); err != nil {
+				return nil, err
+			}
+		}
+	}
 
 	if b.fixedWorkPool == nil {
 		b.fixedWorkPool = pool.NewFixedWorkPool(uint16(runtime.NumCPU()))
 	}
+	if b.inboundPriorityBurst > 0 {
+		b.fixedWorkPool.SetPriorityBurst(b.inboundPriorityBurst)
+	}
 
 	var err error
 
+	b.tenantPools, err = pool.NewTenantPools(b.tenants)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.topicsManager == nil && len(b.topicsFile) > 0 {
+		if err = topics.RegisterBoltTopicsProvider(b.topicsFile); err != nil {
+			return nil, err
+		}
+		b.topicsManager, err = topics.NewManager("bolt")
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if b.topicsManager == nil {
-		topics.RegisterMemTopicsProvider()
+		if b.retainLimits != nil {
+			if err = b.retainLimits.Validate(); err != nil {
+				return nil, err
+			}
+			b.memTopicsOptions = append(b.memTopicsOptions, topics.WithRetainLimits(*b.retainLimits))
+		}
+		if b.filterLimits != nil {
+			if err = b.filterLimits.Validate(); err != nil {
+				return nil, err
+			}
+			b.memTopicsOptions = append(b.memTopicsOptions, topics.WithFilterLimits(*b.filterLimits))
+		}
+		topics.RegisterMemTopicsProvider(b.memTopicsOptions...)
 		b.topicsManager, err = topics.NewManager("mem")
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	if len(b.retainHistory) > 0 {
+		if err = b.topicsManager.SetRetainHistory(b.retainHistory...); err != nil {
+			return nil, err
+		}
+	}
+
+	b.topicsManager.SetRecentTopics(b.recentTopics)
+	b.topicsManager.SetClock(b.clock)
+	b.topicsManager.SetRetainTTL(b.retainedTTL)
+	if b.compressionConfig != nil {
+		if b.compressor, err = compress.New(*b.compressionConfig); err != nil {
+			return nil, err
+		}
+	}
+	b.topicsManager.SetCompressor(b.compressor)
+	b.notifyDeadLetters()
+	if b.topicLogConfig != nil {
+		if b.topicLog, err = topiclog.Open(*b.topicLogConfig); err != nil {
+			return nil, err
+		}
+	}
+	if b.stateLogConfig != nil {
+		if b.stateLog, err = statelog.Open(*b.stateLogConfig); err != nil {
+			return nil, err
+		}
+	}
+	b.qosReport = qosreport.New(0, b.clock)
+	b.topicsMetrics = topics.NewStatsSink()
+	b.topicsManager.SetMetricsSink(b.topicsMetrics)
+	if b.replicateRetained {
+		b.retainReplication = retaincrdt.New(b.BrokerID().String(), b.clock)
+	}
+
 	if b.topicsManager4P2P == nil {
 		topics_p2p.RegisterMemTopicsProvider4P2P()
 		b.topicsManager4P2P, err = topics_p2p.NewManager4P2P("mem")
@@ -123,6 +574,191 @@ func NewBroker(opts ...BrokerOption) (*Broker, error) {
 		}
 	}
 
+	if b.transformManager == nil {
+		b.transformManager = transform.NewManager()
+	}
+
+	if b.scheduler == nil {
+		b.scheduler, err = schedule.NewScheduler(b.scheduleFile, b.clock)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := autosub.Validate(b.autoSubscriptions); err != nil {
+		return nil, err
+	}
+
+	if b.memoryConfig != nil {
+		if err := b.memoryConfig.Validate(); err != nil {
+			return nil, err
+		}
+		b.memory = memacct.New(*b.memoryConfig)
+	}
+
+	if b.delayed == nil {
+		b.delayed, err = delayed.NewQueue(b.delayedFile, b.maxDelayed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if b.annotations == nil {
+		b.annotations, err = annotations.NewStore(b.annotationsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(b.aclFile) > 0 {
+		b.acl, err = acl.Load(b.aclFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(b.rewriteFile) > 0 {
+		b.rewrite, err = rewrite.Load(b.rewriteFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if b.tenantNamespaceConfig != nil {
+		if b.tenantNamespaces, err = namespace.NewTenants(*b.tenantNamespaceConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	if b.outboundConfig != nil {
+		if err = b.outboundConfig.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if err = b.keepaliveConfig.Validate(); err != nil {
+		return nil, err
+	}
+	b.keepalives = keepalive.New(b.keepaliveConfig, b.clock)
+
+	for _, c := range b.topicClaims {
+		if err = b.topicOwners.Claim(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = b.limits.Validate(); err != nil {
+		return nil, err
+	}
+	if b.payloadLimits, err = quota.NewPayloadLimits(b.payloadLimitList); err != nil {
+		return nil, err
+	}
+	if len(b.schemaBindings) > 0 {
+		if b.schemas, err = schema.New(b.schemaBindings); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(b.pluginNames) > 0 {
+		b.plugins, err = plugins.NewChain(b.pluginNames...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(b.peerSecret) > 0 {
+		if b.peerSealer, err = seal.New(b.peerSecret, PeerSealPurpose); err != nil {
+			return nil, err
+		}
+	}
+
+	if b.relayConfig != nil {
+		b.relay, err = relay.New(*b.relayConfig, b.clock)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if b.tlsConfig != nil {
+		b.certMonitor, err = certmon.New(*b.tlsConfig, b.clock)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(b.samplingDefs) > 0 {
+		b.sampler, err = sampling.New(b.samplingDefs...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if b.certIdentity != nil {
+		if b.tlsConfig == nil || len(b.tlsConfig.ClientCAFile) == 0 {
+			return nil, errors.New("the certificate identity needs the TLS listener with the client CAs")
+		}
+		if err = certmon.CheckIdentity(b.certIdentity.From); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = mqtt5.CheckDowngradePolicy(b.downgradePolicy); err != nil {
+		return nil, err
+	}
+
+	if err = checkLocalTopics(b.localTopics); err != nil {
+		return nil, err
+	}
+
+	if err = checkOrderedFilters(b.orderedFilters); err != nil {
+		return nil, err
+	}
+
+	if err = checkBridges(b.bridgeConfigs); err != nil {
+		return nil, err
+	}
+
+	if b.receiptConfig != nil {
+		if err = b.receiptConfig.Validate(); err != nil {
+			return nil, err
+		}
+		b.receipts = receipts.NewTracker(b.receiptConfig.Timeout, b.publishReceipt)
+	}
+
+	if len(b.commandPrefixes) > 0 {
+		b.commandRouter = newCommandRouter(b.commandPrefixes)
+	}
+
+	if b.gcTuning != nil {
+		if err = b.gcTuning.Validate(); err != nil {
+			return nil, err
+		}
+		b.gcTuner = gctune.New(*b.gcTuning, b.liveMemoryEstimate)
+	}
+
+	if b.wsConfig != nil && b.wsConfig.TLS && b.certMonitor == nil {
+		return nil, errors.New("the websocket listener needs the TLS certificate of the broker for wss")
+	}
+
+	if b.quicConfig != nil && b.certMonitor == nil {
+		return nil, errors.New("the quic listener needs the TLS certificate of the broker")
+	}
+
+	b.initChaos()
+
+	b.packetIDs, err = sessions.NewPacketIDStore(b.packetIDFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.sessionManager == nil && len(b.sessionsDir) > 0 {
+		sessions.UnRegisterFileSessionProvider()
+		if err = sessions.RegisterFileSessionProvider(b.sessionsDir); err != nil {
+			return nil, err
+		}
+		b.sessionManager, err = sessions.NewManager("file")
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if b.sessionManager == nil {
 		sessions.RegisterMemSessionProvider()
 		b.sessionManager, err = sessions.NewManager("mem")
@@ -135,7 +771,17 @@ func NewBroker(opts ...BrokerOption) (*Broker, error) {
 }
 
 func (b *Broker) SubmitWorkTask(msg *Message) {
+	if b.submitPriorityTask(msg) {
+		return
+	}
+	if b.submitTenantTask(msg) {
+		return
+	}
+	if b.submitOrderedTask(msg) {
+		return
+	}
 	b.fixedWorkPool.SubmitTask(func() {
+		b.stageLatency.since(StageQueue, msg.received)
 		ProcessMessage(msg)
 	})
 }
@@ -157,7 +803,12 @@ func (b *Broker) StartListening() error {
 		return err
 	}
 
-	b.listener, err = net.Listen("tcp", net.JoinHostPort(common.NormalizeIP(b.host), strconv.FormatUint(uint64(b.port), 10)))
+	err = b.checkStores()
+	if err != nil {
+		return err
+	}
+
+	b.listener, err = handoff.Listen(handoffListenerName, "tcp", net.JoinHostPort(common.NormalizeIP(b.host), strconv.FormatUint(uint64(b.port), 10)))
 	if err != nil {
 		return err
 	}
@@ -171,6 +822,36 @@ func (b *Broker) StartListening() error {
 	b.host = addr.IP
 	b.port = uint16(addr.Port)
 
+	err = b.startWebSocketListener()
+	if err != nil {
+		_ = b.listener.Close()
+		return err
+	}
+
+	err = b.startAdminListener()
+	if err != nil {
+		_ = b.listener.Close()
+		return err
+	}
+
+	err = b.startGatewayListener()
+	if err != nil {
+		_ = b.listener.Close()
+		return err
+	}
+
+	err = b.startQUICListener()
+	if err != nil {
+		_ = b.listener.Close()
+		return err
+	}
+
+	err = b.startMQTTSNListener()
+	if err != nil {
+		_ = b.listener.Close()
+		return err
+	}
+
 	if b.addr == "" {
 		b.addr = net.JoinHostPort(common.NormalizeIP(b.host), strconv.FormatUint(uint64(b.port), 10))
 	} else {
@@ -199,9 +880,11 @@ func (b *Broker) StartListening() error {
 		}
 	}
 
+	b.started = b.clock.Now()
 	b.listening.Store(true)
 	b.logger.Info("Listening for mqtt broker.",
 		zap.String("bind_addr", addr.String()),
+		zap.Bool("tls", b.certMonitor != nil),
 		zap.String("broker_id", b.BrokerID().String()),
 		zap.String("p2p_addr", b.NodeID().Address),
 		zap.String("p2p_public_key", b.NodeID().PubKey.String()),
@@ -211,12 +894,43 @@ func (b *Broker) StartListening() error {
 	b.startMetricsNotificationTask()
 	b.startCandidateForwardConfirmTask()
 	b.startProcessActionElementListTask()
+	b.startComputedTopicsTask()
+	b.startResubscribeTask()
+	b.parkStoredSessions()
+	b.startScheduleTask()
+	b.startDelayedTask()
+	b.startMemoryTask()
+	b.startReplicaTask()
+	b.startCertificateTask()
+	b.retainCapabilities()
+	b.retainBuildInfo()
+	b.topicsManager.StartRetainSweeper(defaultRetainSweep)
+	b.startRetainReplicationTask()
+	b.startACLTask()
+	b.startRewriteTask()
+	b.startConfigTask()
+	b.keepalives.Start()
+	b.startSysTask()
+	b.startGCTuneTask()
+	b.startBridges()
+	b.startReceiptTask()
+	b.startTopicLogTask()
+	b.storeCheckNotification()
+
+	// Tell the previous process (if upgrading) to stop accepting.
+	if err := handoff.Ready(); err != nil {
+		b.logger.Error("Failed to notify the previous process of the ready broker", zap.Error(err))
+	}
 
 	// Handle connections.
+	listenerAddr := addr.String()
 	tmpDelay := 10 * AcceptMinSleep
 	for {
 		conn, err := b.listener.Accept()
 		if err != nil {
+			if b.stopped() {
+				return nil
+			}
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
 				b.logger.Error("Temporary MQTT client accept error on listening",
 					zap.Error(ne),
@@ -233,22 +947,23 @@ func (b *Broker) StartListening() error {
 			continue
 		}
 		tmpDelay = AcceptMinSleep
-		go b.handleConnection(conn)
+		go b.handleConnection(b.acceptTLS(conn), listenerAddr)
 	}
 }
 
-func (b *Broker) handleConnection(conn net.Conn) {
-	//process connect packet
-	packet, err := packets.ReadPacket(conn)
+// handleConnection serves the client of the connection accepted by the listener until it's gone.
+func (b *Broker) handleConnection(conn net.Conn, listener string) {
+	//process connect packet, of either 3.1.1 or 5.0
+	connect, err := mqtt5.ReadConnect(conn)
 	if err != nil {
 		b.logger.Error("core_module/broker/handleConnection: read connect packet error => ", zap.Error(err))
 		return
 	}
-	if packet == nil {
+	if connect.Control == nil {
 		b.logger.Error("core_module/broker/handleConnection: received nil packet")
 		return
 	}
-	msg, ok := packet.(*packets.ConnectPacket)
+	msg, ok := connect.Control.(*packets.ConnectPacket)
 	if !ok {
 		b.logger.Error("core_module/broker/handleConnection: received msg that was not Connect")
 		return
@@ -257,11 +972,48 @@ func (b *Broker) handleConnection(conn net.Conn) {
 		zap.String("clientID", msg.ClientIdentifier))
 
 	connAck := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
-	connAck.SessionPresent = msg.CleanSession
-	connAck.ReturnCode = msg.Validate()
+	// the session is taken by getSession once the connect is accepted
+	if !msg.CleanSession && len(msg.ClientIdentifier) > 0 {
+		_, err := b.sessionManager.Get(msg.ClientIdentifier)
+		connAck.SessionPresent = err == nil
+	}
+	authStart := time.Now()
+	v5 := msg.ProtocolVersion == mqtt5.ProtocolVersion
+	certAuth, certCode := b.checkCertIdentity(conn, msg)
+	if v5 {
+		connAck.ReturnCode = mqtt5.Validate(msg)
+	} else {
+		connAck.ReturnCode = msg.Validate()
+	}
+	if connAck.ReturnCode == packets.Accepted {
+		connAck.ReturnCode = certCode
+	}
+	if connAck.ReturnCode == packets.Accepted {
+		connAck.ReturnCode = b.checkConnectReplay(msg)
+	}
+	var authExpiry time.Time
+	if connAck.ReturnCode == packets.Accepted && !certAuth {
+		connAck.ReturnCode, authExpiry = b.checkConnectAuth(msg, connect, conn.RemoteAddr())
+	}
+	if connAck.ReturnCode == packets.Accepted {
+		connAck.ReturnCode = b.checkConnectReadOnly(msg)
+	}
+	if connAck.ReturnCode == packets.Accepted {
+		connAck.ReturnCode = b.checkConnectWill(msg, listener)
+	}
+	if connAck.ReturnCode == packets.Accepted {
+		connAck.ReturnCode = b.checkConnectPlugins(msg, conn.RemoteAddr(), listener)
+	}
+	if connAck.ReturnCode == packets.Accepted {
+		connAck.ReturnCode = b.checkConnectShutdown()
+	}
+	if connAck.ReturnCode == packets.Accepted {
+		connAck.ReturnCode = b.checkConnectMemory()
+	}
+	b.stageLatency.since(StageAuth, authStart)
 
 	if connAck.ReturnCode != packets.Accepted {
-		err = connAck.Write(conn)
+		err = writeConnack(conn, connAck, v5, nil)
 		if err != nil {
 			b.logger.Error("core_module/broker/handleConnection: send connAck error, ",
 				zap.Error(err),
@@ -274,7 +1026,17 @@ func (b *Broker) handleConnection(conn net.Conn) {
 
 	// TODO CheckConnectAuth
 
-	err = connAck.Write(conn)
+	limits := b.clientLimits(msg.Username)
+	var connAckProps *mqtt5.Properties
+	if v5 {
+		connAckProps = b.connackProperties(msg)
+		setReceiveMaximum(connAckProps, limits)
+		if tokenAuth(connect) {
+			connAckProps.AuthMethod = TokenAuthMethod
+		}
+	}
+
+	err = writeConnack(conn, connAck, v5, connAckProps)
 	if err != nil {
 		b.logger.Error("core_module/broker/handleConnection: send connAck error, ",
 			zap.Error(err),
@@ -300,6 +1062,13 @@ func (b *Broker) handleConnection(conn net.Conn) {
 		password:    msg.Password,
 		keepalive:   msg.Keepalive,
 		willMessage: willMsg,
+		willDelay:   b.willDelay(connect),
+		listener:    listener,
+
+		protocolVersion: msg.ProtocolVersion,
+	}
+	if v5 {
+		info.sessionExpiry = sessionExpiry(connect)
 	}
 
 	c := &client{
@@ -309,6 +1078,19 @@ func (b *Broker) handleConnection(conn net.Conn) {
 	}
 
 	c.init()
+	c.tenant = b.tenantPools.Lookup(c.info.clientID)
+	c.namespace = b.clientNamespace(c.info.listener, c.info.username)
+	c.limiter = quota.NewLimiter(limits, b.clock)
+	c.logPacket(msg, true)
+	c.capturePacket(msg, connect, true)
+	c.logPacket(connAck, false)
+	c.capturePacket(connAck, &mqtt5.Packet{Properties: connAckProps}, false)
+
+	if b.batchPolicy != nil {
+		if interval := b.batchPolicy(c.info.clientID, c.info.username); interval > 0 {
+			c.enableBatching(interval)
+		}
+	}
 
 	err = b.getSession(c, msg, connAck)
 	if err != nil {
@@ -331,12 +1113,29 @@ func (b *Broker) handleConnection(conn net.Conn) {
 		)
 		ol, ok := old.(*client)
 		if ok {
-			ol.Close()
+			ol.takenOver = true
+			ol.disconnect(mqtt5.SessionTakenOver)
 		}
 	}
+	c.startOutbound()
 	b.clients.Store(cid, c)
+	b.cancelWill(cid)
+	b.handovers.Delete(cid)
 	b.OnlineOfflineNotification(cid, true)
 
+	if connAck.SessionPresent {
+		b.resumeSession(c)
+		b.recordSession(statelog.SessionResumed, c)
+	} else {
+		b.recordSession(statelog.SessionCreated, c)
+	}
+
+	c.autoSubscribe()
+
+	if v5 && tokenAuth(connect) && b.authManager != nil && !certAuth {
+		c.startReauthTask(authExpiry)
+	}
+
 	c.readLoop()
 }
 
@@ -345,7 +1144,7 @@ func (b *Broker) removeClient(c *client) {
 	if clientId != "" {
 		b.clients.Delete(clientId)
 		b.logger.Info("core_module/broker/removeClient: delete client ,",
-			zap.String("ClientID", clientId),
+			logging.ClientID(clientId),
 		)
 	}
 }
@@ -353,11 +1152,17 @@ func (b *Broker) removeClient(c *client) {
 // The PublishPacket is not from the client, may be created by the broker for publishing it to the client.
 // Also, this can be used to forward packets from the peer node to clients.
 func PublishMessageWithBroker(b *Broker, packet *packets.PublishPacket) {
-	var subList []interface{}
+	var subList []topics.Subscriber
 	var qosList []byte
 
+	b.topicsManager.ObservePublish(packet.TopicName, b.clock.Now())
+
 	b.mu.Lock()
+	matchStart := time.Now()
+	matchSpan := b.startSpan(spanMatch, b.traceParent(packet))
 	err := b.topicsManager.Subscribers([]byte(packet.TopicName), packet.Qos, &subList, &qosList)
+	matchSpan.End()
+	b.stageLatency.since(StageMatch, matchStart)
 	b.mu.Unlock()
 
 	if err != nil {
@@ -371,33 +1176,24 @@ func PublishMessageWithBroker(b *Broker, packet *packets.PublishPacket) {
 		return
 	}
 
-	var qSub []int
-	for i, sub := range subList {
-		s, ok := sub.(*subscription)
-		if ok {
-			if s.share {
-				qSub = append(qSub, i)
-			} else {
-				err := s.client.WriterPacket(packet)
-				if err != nil {
-					b.logger.Error("core_module/broker/PublishMessage: Error publish to subscriber => ",
-						zap.Error(err),
-						zap.String("ClientID", s.client.info.clientID),
-					)
-				}
+	live := b.liveDeliveryPacket(packet)
+	defer b.aliasTrace(live, packet)()
+	packet = live
+	shared := sharedDelivery(packet, len(subList))
+
+	// the topics provider returns one member of each share group
+	for _, sub := range subList {
+		switch s := sub.(type) {
+		case *subscription:
+			err := s.client.deliverExt(packet, s.topic, nil, shared)
+			if err != nil {
+				b.logger.Error("core_module/broker/PublishMessage: Error publish to subscriber => ",
+					zap.Error(err),
+					logging.ClientID(s.client.info.clientID),
+				)
 			}
-		}
-	}
-
-	if len(qSub) > 0 {
-		idx := r.Intn(len(qSub))
-		sub := subList[qSub[idx]].(*subscription)
-		err := sub.client.WriterPacket(packet)
-		if err != nil {
-			b.logger.Error("core_module/broker/PublishMessage: Error publish to subscriber [share group random mode] => ",
-				zap.Error(err),
-				zap.String("ClientID", sub.client.info.clientID),
-			)
+		case internalSubscriber:
+			s.deliver(b, packet)
 		}
 	}
 }
@@ -412,6 +1208,18 @@ func (b *Broker) SubmitPublishPacketsWorkTask(packet *packets.PublishPacket) {
 	}
 }
 
+// RetainedHistory returns the kept versions of the retained message of the topic, newest first.
+func (b *Broker) RetainedHistory(topic string) ([]topics.RetainedVersion, error) {
+	return b.topicsManager.RetainHistory([]byte(topic))
+}
+
+// ExpandFilter returns the concrete topics currently known to be matched by the filter, from the
+// retained messages and the recently published topics. It helps to find out why a wildcard
+// subscription receives nothing.
+func (b *Broker) ExpandFilter(filter string) ([]topics.KnownTopic, error) {
+	return b.topicsManager.Expand([]byte(filter))
+}
+
 func (b *Broker) startMetricsNotificationTask() {
 	go func() {
 		ticker := time.NewTicker(1 * time.Minute)
@@ -420,6 +1228,12 @@ func (b *Broker) startMetricsNotificationTask() {
 		for range ticker.C {
 			b.WorkPoolMetricsNotification(b.BrokerID().String(), b.fixedWorkPool.Metrics().MetricsInfo())
 			b.PacketForwardMetricsNotification(b.BrokerID().String(), b.brokerNode.packetForwardMetrics.MetricsInfo())
+			b.StageLatencyMetricsNotification(b.BrokerID().String(), b.stageLatencyMetricsInfo())
+			b.tenantMetricsNotification()
+			b.relayMetricsNotification()
+			b.qosReportNotification()
+			b.retainStatsNotification()
+			b.topicsMetricsNotification()
 		}
 	}()
 }
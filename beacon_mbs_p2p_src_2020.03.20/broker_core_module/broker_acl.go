@@ -0,0 +1,146 @@
+package broker_core_module
+
+import (
+	"errors"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/acl"
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+const defaultACLCheck = 10 * time.Second
+
+// ReloadACL reads the file of the topic ACL again, the rules apply to the connected clients from
+// their next publish or subscribe. The current rules are kept if the file is invalid.
+func (b *Broker) ReloadACL() error {
+	if b.acl == nil {
+		return errors.New("core_module/broker_acl/ReloadACL: the broker has no ACL file")
+	}
+	return b.acl.Reload()
+}
+
+// ClaimTopics registers the claim of a service on the topics of its filter, only the clients of the
+// service publish to them from then on. It fails with an acl.ConflictError if another service
+// claims some of the topics.
+func (b *Broker) ClaimTopics(c acl.Claim) error {
+	if err := b.topicOwners.Claim(c); err != nil {
+		return err
+	}
+	b.logger.Info("core_module/broker_acl/ClaimTopics: the topics are claimed",
+		zap.String("owner", c.Owner),
+		zap.String("filter", c.Filter),
+	)
+	return nil
+}
+
+// ReleaseTopics removes the claim of the owner on the filter, false if there is none.
+func (b *Broker) ReleaseTopics(owner string, filter string) bool {
+	return b.topicOwners.Release(owner, filter)
+}
+
+// TopicClaims returns the claims sorted by filter.
+func (b *Broker) TopicClaims() []acl.Claim {
+	return b.topicOwners.Claims()
+}
+
+// startACLTask reloads the file of the topic ACL once it has changed.
+func (b *Broker) startACLTask() {
+	if b.acl == nil {
+		return
+	}
+
+	go func() {
+		ticker := b.clock.NewTicker(defaultACLCheck)
+		defer ticker.Stop()
+
+		for range ticker.C() {
+			reloaded, err := b.acl.Refresh()
+			if err != nil {
+				b.logger.Error("core_module/broker_acl/startACLTask: reload the ACL error, the current rules are kept => ",
+					zap.Error(err),
+					zap.String("file", b.aclFile),
+				)
+			} else if reloaded {
+				b.logger.Info("core_module/broker_acl/startACLTask: the ACL is reloaded",
+					zap.String("file", b.aclFile),
+				)
+				b.recordConfigChange("acl", b.aclFile)
+			}
+		}
+	}()
+}
+
+// checkACL checks the access of the client against the ACL of the broker, and the ACLs set by the
+// admins of the namespaces owning the client; it's allowed if none of them denies it.
+func (b *Broker) checkACL(clientID string, username string, access acl.Access, topic string) bool {
+	if b.acl != nil && !b.acl.Check(clientID, username, access, topic) {
+		return false
+	}
+	return b.namespaces.Check(clientID, username, access, topic)
+}
+
+// allowPublish checks the topic of the publish against the ACL, the denied publishes are dropped.
+func (c *client) allowPublish(packet *packets.PublishPacket) bool {
+	if isSysTopic(packet.TopicName) {
+		c.logger.Warn("core_module/broker_acl/allowPublish: the $SYS topics are published by the broker only, drop it",
+			zap.String("topic", packet.TopicName),
+		)
+		return false
+	}
+	if isDeadLetterTopic(packet.TopicName) {
+		c.logger.Warn("core_module/broker_acl/allowPublish: the dead letters are published by the broker only, drop it",
+			zap.String("topic", packet.TopicName),
+		)
+		return false
+	}
+	if c.broker == nil {
+		return true
+	}
+	if !c.broker.topicOwners.CheckPublish(c.info.clientID, c.info.username, packet.TopicName) {
+		c.logger.Warn("core_module/broker_acl/allowPublish: the topic is claimed by another owner, drop it",
+			zap.String("username", c.info.username),
+			zap.String("topic", packet.TopicName),
+		)
+		return false
+	}
+	if c.broker.checkACL(c.info.clientID, c.info.username, acl.Publish, packet.TopicName) {
+		return true
+	}
+	c.logger.Warn("core_module/broker_acl/allowPublish: the ACL denies the publish, drop it",
+		zap.String("username", c.info.username),
+		zap.String("topic", packet.TopicName),
+	)
+	return false
+}
+
+// allowSubscribe checks the filter of the subscription, without its share group, against the ACL.
+func (c *client) allowSubscribe(filter string) bool {
+	if c.broker == nil || c.broker.checkACL(c.info.clientID, c.info.username, acl.Subscribe, filter) {
+		return true
+	}
+	c.logger.Warn("core_module/broker_acl/allowSubscribe: the ACL denies the subscription",
+		zap.String("username", c.info.username),
+		zap.String("topic", filter),
+	)
+	return false
+}
+
+// denyPublish acknowledges the denied publish of QoS 1 or 2, so the client doesn't retransmit it, with
+// the not authorized reason for a 5.0 client.
+func (c *client) denyPublish(packet *packets.PublishPacket) {
+	c.acknowledgePublish(packet, mqtt5.NotAuthorized)
+	if c.broker != nil {
+		c.broker.deadLetter(DeadLetterDenied, c.info.clientID, packet)
+	}
+}
+
+// subscribeDeniedCode is the SUBACK return code of a denied subscription.
+func (c *client) subscribeDeniedCode() byte {
+	if c.isV5() {
+		return mqtt5.NotAuthorized
+	}
+	return QosFailure
+}
@@ -0,0 +1,641 @@
+package broker_core_module
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/acl"
+	"awesomeProject/beacon/mqtt_network/libs/handoff"
+	"awesomeProject/beacon/mqtt_network/libs/logging"
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+	"awesomeProject/beacon/mqtt_network/libs/namespace"
+	"awesomeProject/beacon/mqtt_network/libs/outbound"
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+const (
+	handoffAdminName = "admin"
+
+	adminHeaderTimeout = 10 * time.Second
+)
+
+// AdminConfig serves the admin HTTP API on its own listener, the requests carry the token as
+// "Authorization: Bearer <token>".
+type AdminConfig struct {
+	// Addr is the host:port of the listener
+	Addr  string
+	Token string
+}
+
+// AdminClient is a connected client as listed by the admin API.
+type AdminClient struct {
+	ClientID        string              `json:"client_id"`
+	Username        string              `json:"username,omitempty"`
+	RemoteIP        string              `json:"remote_ip"`
+	Listener        string              `json:"listener,omitempty"`
+	ProtocolVersion byte                `json:"protocol_version"`
+	CleanSession    bool                `json:"clean_session"`
+	Subscriptions   []AdminSubscription `json:"subscriptions"`
+	// Outbound is the outbound queue of the client, if the broker queues the deliveries
+	Outbound *outbound.Stats `json:"outbound,omitempty"`
+}
+
+// AdminSubscription is a subscription of the trie, Subscriber is the client id of a client
+// subscription or the type of an internal subscriber.
+type AdminSubscription struct {
+	Filter     string `json:"filter"`
+	Qos        byte   `json:"qos"`
+	Subscriber string `json:"subscriber,omitempty"`
+	// Offline is set for the subscription of a persistent session whose client is offline
+	Offline bool `json:"offline,omitempty"`
+}
+
+// TrieStats counts the state of the subscription trie and of the retained store.
+type TrieStats struct {
+	Subscriptions int `json:"subscriptions"`
+	TopicNodes    int `json:"topic_nodes"`
+	Retained      int `json:"retained"`
+}
+
+// AdminRetained is a retained message, the payload is base64 encoded in JSON.
+type AdminRetained struct {
+	Topic   string `json:"topic"`
+	Qos     byte   `json:"qos"`
+	Payload []byte `json:"payload"`
+}
+
+// PeerInfo is a peer broker of the cluster.
+type PeerInfo struct {
+	BrokerID string `json:"broker_id"`
+	Address  string `json:"address"`
+}
+
+// Clients returns the connected clients with their subscriptions, by client id.
+func (b *Broker) Clients() []AdminClient {
+	var list []AdminClient
+	b.clients.Range(func(key, value interface{}) bool {
+		c, ok := value.(*client)
+		if !ok {
+			return true
+		}
+		ac := AdminClient{
+			ClientID:        c.info.clientID,
+			Username:        c.info.username,
+			RemoteIP:        c.info.remoteIP,
+			Listener:        c.info.listener,
+			ProtocolVersion: c.info.protocolVersion,
+			CleanSession:    c.info.cleanSession,
+			Subscriptions:   c.adminSubscriptions(),
+			Outbound:        c.outboundStats(),
+		}
+		list = append(list, ac)
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool { return list[i].ClientID < list[j].ClientID })
+	return list
+}
+
+// adminSubscriptions returns the subscriptions of the session of the client, by filter.
+func (c *client) adminSubscriptions() []AdminSubscription {
+	subs := []AdminSubscription{}
+	if c.session == nil {
+		return subs
+	}
+	if filters, qosList, err := c.session.Topics(); err == nil {
+		for i, f := range filters {
+			subs = append(subs, AdminSubscription{Filter: f, Qos: qosList[i]})
+		}
+		sort.Slice(subs, func(i, j int) bool { return subs[i].Filter < subs[j].Filter })
+	}
+	return subs
+}
+
+// Kick disconnects the client, a 5.0 client gets the administrative action reason. It returns
+// false if the client is not connected.
+func (b *Broker) Kick(clientID string) bool {
+	v, exist := b.clients.Load(clientID)
+	if !exist {
+		return false
+	}
+	c, ok := v.(*client)
+	if !ok {
+		return false
+	}
+	b.logger.Info("core_module/broker_admin/Kick: kick the client by admin ",
+		logging.ClientID(clientID),
+	)
+	c.disconnect(mqtt5.AdministrativeAction)
+	return true
+}
+
+// Retained returns the retained messages matched by the filter.
+func (b *Broker) Retained(filter string) ([]*packets.PublishPacket, error) {
+	if err := topics.ValidateTopicFilter([]byte(filter)); err != nil {
+		return nil, err
+	}
+	var retainedList []*packets.PublishPacket
+	if err := b.topicsManager.Retained([]byte(filter), &retainedList); err != nil {
+		return nil, err
+	}
+	return retainedList, nil
+}
+
+// ClearRetained removes the retained messages matched by the filter, it returns how many were
+// removed.
+func (b *Broker) ClearRetained(filter string) (int, error) {
+	retainedList, err := b.Retained(filter)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, rm := range retainedList {
+		packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		packet.TopicName = rm.TopicName
+		packet.Retain = true
+		if err := b.topicsManager.Retain(packet); err != nil {
+			return n, err
+		}
+		b.recordRetained("", packet)
+		n++
+	}
+	return n, nil
+}
+
+// Peers returns the peer brokers known to this one, by broker id.
+func (b *Broker) Peers() []PeerInfo {
+	self := b.brokerNode.BrokerID().String()
+	var list []PeerInfo
+	b.brokerNode.nodeIDMap.Range(func(key, value interface{}) bool {
+		id, _ := key.(string)
+		addr, _ := value.(string)
+		if id != self {
+			list = append(list, PeerInfo{BrokerID: id, Address: addr})
+		}
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool { return list[i].BrokerID < list[j].BrokerID })
+	return list
+}
+
+// Subscriptions dumps the subscription trie, the filters in order.
+func (b *Broker) Subscriptions() ([]AdminSubscription, error) {
+	var list []AdminSubscription
+	err := b.topicsManager.WalkSubscriptions(func(filter string, qos byte, sub topics.Subscriber) bool {
+		list = append(list, adminSubscription(filter, qos, sub))
+		return true
+	})
+	return list, err
+}
+
+// FilterSubscriptions returns the subscriptions of the filter, which is not matched: "a/+" returns
+// the subscriptions to "a/+" only.
+func (b *Broker) FilterSubscriptions(filter string) ([]AdminSubscription, error) {
+	infos, err := b.topicsManager.Subscriptions([]byte(filter))
+	if err != nil {
+		return nil, err
+	}
+	list := make([]AdminSubscription, 0, len(infos))
+	for _, info := range infos {
+		list = append(list, adminSubscription(info.Filter, info.Qos, info.Subscriber))
+	}
+	return list, nil
+}
+
+// TrieStats counts the subscriptions, the nodes of the subscription trie and the retained
+// messages, an error if the topics provider cannot count them.
+func (b *Broker) TrieStats() (TrieStats, error) {
+	var s TrieStats
+	var err error
+	if s.Subscriptions, err = b.topicsManager.SubscriptionCount(); err != nil {
+		return s, err
+	}
+	if s.TopicNodes, err = b.topicsManager.TopicNodeCount(); err != nil {
+		return s, err
+	}
+	s.Retained, err = b.topicsManager.RetainedCount()
+	return s, err
+}
+
+func adminSubscription(filter string, qos byte, sub topics.Subscriber) AdminSubscription {
+	s := AdminSubscription{Filter: filter, Qos: qos}
+	switch v := sub.(type) {
+	case *subscription:
+		s.Subscriber = v.client.info.clientID
+	case *offlineSubscription:
+		s.Subscriber, s.Offline = v.clientID, true
+	case topics.PersistentSubscriber:
+		s.Subscriber = v.SubscriberKey()
+	default:
+		s.Subscriber = fmt.Sprintf("%T", sub)
+	}
+	return s
+}
+
+// startAdminListener serves the admin API, the listener is handed off to the new process on
+// upgrade like the MQTT listener.
+func (b *Broker) startAdminListener() error {
+	if b.adminConfig == nil {
+		return nil
+	}
+	if len(b.adminConfig.Token) == 0 {
+		return errors.New("core_module/broker_admin/startAdminListener: the admin API needs a token")
+	}
+
+	var err error
+	b.adminListener, err = handoff.Listen(handoffAdminName, "tcp", b.adminConfig.Addr)
+	if err != nil {
+		return err
+	}
+	b.adminServer = &http.Server{Handler: b.adminHandler(), ReadHeaderTimeout: adminHeaderTimeout}
+
+	b.logger.Info("Listening for the admin API.",
+		zap.String("bind_addr", b.adminListener.Addr().String()),
+	)
+
+	go func() {
+		err := b.adminServer.Serve(b.adminListener)
+		if err != nil && err != http.ErrServerClosed && !b.stopped() {
+			b.logger.Error("Admin API serve error on listening", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// adminHandler routes the admin API:
+//
+//	GET    /clients               the connected clients and their subscriptions
+//	DELETE /clients/<id>          kicks the client
+//	GET    /retained?filter=<f>   the retained messages matched by the filter
+//	DELETE /retained?filter=<f>   removes them
+//	GET    /retained/limits       the size of the retained store and the counters of its limits
+//	GET    /retained/history?topic=<t> the kept versions of the retained message of the topic
+//	GET    /topics?filter=<f>     the known topics matched by the filter
+//	GET    /compression           the bytes saved by the payload compression, by topic prefix
+//	GET    /schemas               the payloads validated and refused by the schemas, by topic prefix
+//	GET    /outbound              the messages dropped by the outbound queues of the clients
+//	GET    /delayed               the delayed publishes waiting
+//	DELETE /delayed/<id>          cancels the delayed publish
+//	GET    /memory?top=<n>        the accounted memory and the n connections holding the most
+//	GET    /peers                 the peer brokers of the cluster
+//	GET    /subscriptions         the subscription trie, the subscriptions of ?filter=<f> only
+//	GET    /subscriptions/stats   the subscriptions, the nodes of the trie and the retained messages
+//	GET    /version               the build info and the feature flags of the broker
+//	GET    /state?since=<seq>     follows the state log, if it's enabled
+//	GET    /log/levels            the log levels of the subsystems
+//	PUT    /log/levels/<name>     changes the log level of the subsystem to ?level=<l>
+//
+// The tokens delegated by the namespaces registry get the routes of scopedAdminHandler instead.
+func (b *Broker) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/clients", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, b.Clients())
+	})
+	mux.HandleFunc("/clients/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !b.Kick(strings.TrimPrefix(r.URL.Path, "/clients/")) {
+			http.Error(w, "client not connected", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/retained", func(w http.ResponseWriter, r *http.Request) {
+		filter := r.URL.Query().Get("filter")
+		if len(filter) == 0 {
+			filter = topics.MWC
+		}
+		switch r.Method {
+		case http.MethodGet:
+			retainedList, err := b.Retained(filter)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			list := make([]AdminRetained, 0, len(retainedList))
+			for _, rm := range retainedList {
+				list = append(list, AdminRetained{Topic: rm.TopicName, Qos: rm.Qos, Payload: rm.Payload})
+			}
+			writeJSON(w, list)
+		case http.MethodDelete:
+			n, err := b.ClearRetained(filter)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, map[string]int{"removed": n})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/retained/history", func(w http.ResponseWriter, r *http.Request) {
+		versions, err := b.RetainedHistory(r.URL.Query().Get("topic"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, versions)
+	})
+	mux.HandleFunc("/topics", func(w http.ResponseWriter, r *http.Request) {
+		known, err := b.ExpandFilter(r.URL.Query().Get("filter"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, known)
+	})
+	mux.HandleFunc("/retained/limits", func(w http.ResponseWriter, r *http.Request) {
+		stats, ok := b.RetainLimitStats()
+		if !ok {
+			http.Error(w, "the retained store has no limit", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, stats)
+	})
+	mux.HandleFunc("/compression", func(w http.ResponseWriter, r *http.Request) {
+		if b.compressor == nil {
+			http.Error(w, "the payloads are not compressed", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, b.CompressionStats())
+	})
+	mux.HandleFunc("/schemas", func(w http.ResponseWriter, r *http.Request) {
+		if b.schemas == nil {
+			http.Error(w, "the payloads are not validated", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, b.SchemaStats())
+	})
+	mux.HandleFunc("/outbound", func(w http.ResponseWriter, r *http.Request) {
+		stats, ok := b.OutboundStats()
+		if !ok {
+			http.Error(w, "the clients have no outbound queue", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, stats)
+	})
+	mux.HandleFunc("/delayed", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, b.DelayedPublishes())
+	})
+	mux.HandleFunc("/delayed/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/delayed/"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		removed, err := b.CancelDelayedPublish(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !removed {
+			http.Error(w, "no delayed publish", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/memory", func(w http.ResponseWriter, r *http.Request) {
+		n := 20
+		if top := r.URL.Query().Get("top"); len(top) > 0 {
+			var err error
+			if n, err = strconv.Atoi(top); err != nil {
+				http.Error(w, "invalid top", http.StatusBadRequest)
+				return
+			}
+		}
+		report, ok := b.MemoryStats(n)
+		if !ok {
+			http.Error(w, "the broker doesn't account the memory", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, report)
+	})
+	mux.HandleFunc("/peers", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, b.Peers())
+	})
+	mux.HandleFunc("/subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		var list []AdminSubscription
+		var err error
+		if filter := r.URL.Query().Get("filter"); len(filter) > 0 {
+			list, err = b.FilterSubscriptions(filter)
+		} else {
+			list, err = b.Subscriptions()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		writeJSON(w, list)
+	})
+	mux.HandleFunc("/subscriptions/stats", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := b.TrieStats()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		writeJSON(w, stats)
+	})
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, b.BuildInfo())
+	})
+	mux.HandleFunc("/log/levels", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, b.LogLevels())
+	})
+	mux.HandleFunc("/log/levels/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		subsystem := strings.TrimPrefix(r.URL.Path, "/log/levels/")
+		if err := b.SetLogLevel(subsystem, r.URL.Query().Get("level")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	if h := b.StateLogHandler(); h != nil {
+		mux.Handle("/state", h)
+	}
+
+	scoped := b.scopedAdminHandler()
+	token := []byte("Bearer " + b.adminConfig.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), token) == 1 {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		if _, err := b.namespaces.Resolve(bearerToken(r)); err == nil {
+			scoped.ServeHTTP(w, r)
+			return
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// scopedAdminHandler routes the admin API of the tenant admins, the routes are the ones of the
+// operator restricted to the namespace of the token, and the ACL of the namespace:
+//
+//	GET    /clients               the connected clients owned by the namespace
+//	DELETE /clients/<id>          kicks the client
+//	GET    /retained?filter=<f>   the retained messages matched by the filter, all of the namespace by default
+//	DELETE /retained?filter=<f>   removes them
+//	GET    /retained/history?topic=<t> the kept versions of the retained message of the topic
+//	GET    /topics?filter=<f>     the known topics matched by the filter
+//	GET    /acl                   the ACL of the namespace
+//	PUT    /acl                   sets the ACL of the namespace from the JSON rules
+func (b *Broker) scopedAdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/clients", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		list, err := b.ScopedClients(bearerToken(r))
+		if err != nil {
+			writeScopedError(w, err)
+			return
+		}
+		writeJSON(w, list)
+	})
+	mux.HandleFunc("/clients/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		kicked, err := b.ScopedKick(bearerToken(r), strings.TrimPrefix(r.URL.Path, "/clients/"))
+		if err != nil {
+			writeScopedError(w, err)
+			return
+		}
+		if !kicked {
+			http.Error(w, "client not connected", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/retained", func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		filter := r.URL.Query().Get("filter")
+		if len(filter) == 0 {
+			ns, err := b.namespaces.Resolve(token)
+			if err != nil {
+				writeScopedError(w, err)
+				return
+			}
+			filter = topics.MWC
+			if len(ns.Prefix) > 0 {
+				filter = ns.Prefix + topics.SEP + topics.MWC
+			}
+		}
+		switch r.Method {
+		case http.MethodGet:
+			retainedList, err := b.ScopedRetained(token, filter)
+			if err != nil {
+				writeScopedError(w, err)
+				return
+			}
+			list := make([]AdminRetained, 0, len(retainedList))
+			for _, rm := range retainedList {
+				list = append(list, AdminRetained{Topic: rm.TopicName, Qos: rm.Qos, Payload: rm.Payload})
+			}
+			writeJSON(w, list)
+		case http.MethodDelete:
+			n, err := b.ScopedClearRetained(token, filter)
+			if err != nil {
+				writeScopedError(w, err)
+				return
+			}
+			writeJSON(w, map[string]int{"removed": n})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/retained/history", func(w http.ResponseWriter, r *http.Request) {
+		versions, err := b.ScopedRetainedHistory(bearerToken(r), r.URL.Query().Get("topic"))
+		if err != nil {
+			writeScopedError(w, err)
+			return
+		}
+		writeJSON(w, versions)
+	})
+	mux.HandleFunc("/topics", func(w http.ResponseWriter, r *http.Request) {
+		known, err := b.ScopedExpandFilter(bearerToken(r), r.URL.Query().Get("filter"))
+		if err != nil {
+			writeScopedError(w, err)
+			return
+		}
+		writeJSON(w, known)
+	})
+	mux.HandleFunc("/acl", func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		switch r.Method {
+		case http.MethodGet:
+			cfg, ok, err := b.ScopedACL(token)
+			if err != nil {
+				writeScopedError(w, err)
+				return
+			}
+			if !ok {
+				http.Error(w, "the namespace has no ACL", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, cfg)
+		case http.MethodPut:
+			var cfg acl.Config
+			if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := b.ScopedSetACL(token, cfg); err != nil {
+				writeScopedError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	return mux
+}
+
+// bearerToken returns the token of the Authorization header of the request.
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// writeScopedError answers the error of a scoped operation, the token may have been revoked since
+// the request was authorized.
+func writeScopedError(w http.ResponseWriter, err error) {
+	switch err {
+	case namespace.ErrUnknownToken:
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case namespace.ErrOutOfScope:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,68 @@
+package broker_core_module
+
+import (
+	"awesomeProject/beacon/mqtt_network/libs/annotations"
+	"awesomeProject/beacon/mqtt_network/libs/namespace"
+)
+
+// The annotations are the device metadata kept by the broker for the topics, so the consumers don't
+// need a lookup in a separate device registry for each message. The MQTT 3.1.1 packets have no user
+// properties to carry them on delivery, they are read through TopicAnnotations until then.
+
+func (b *Broker) Annotations() *annotations.Store {
+	return b.annotations
+}
+
+// SetAnnotation annotates the topics matched by the filter.
+func (b *Broker) SetAnnotation(filter string, key string, value string) error {
+	return b.annotations.Set(filter, key, value)
+}
+
+// DeleteAnnotation removes the key of the filter, all the keys if it's empty.
+func (b *Broker) DeleteAnnotation(filter string, key string) error {
+	return b.annotations.Delete(filter, key)
+}
+
+// TopicAnnotations returns the annotations of the topic merged from all the matching filters.
+func (b *Broker) TopicAnnotations(topic string) map[string]string {
+	return b.annotations.Lookup(topic)
+}
+
+// ScopedSetAnnotation annotates the topics matched by the filter within the namespace.
+func (b *Broker) ScopedSetAnnotation(token string, filter string, key string, value string) error {
+	ns, err := b.namespaces.Resolve(token)
+	if err != nil {
+		return err
+	}
+	if !ns.ContainsFilter(filter) {
+		return namespace.ErrOutOfScope
+	}
+
+	return b.annotations.Set(filter, key, value)
+}
+
+// ScopedDeleteAnnotation removes the key of the filter within the namespace.
+func (b *Broker) ScopedDeleteAnnotation(token string, filter string, key string) error {
+	ns, err := b.namespaces.Resolve(token)
+	if err != nil {
+		return err
+	}
+	if !ns.ContainsFilter(filter) {
+		return namespace.ErrOutOfScope
+	}
+
+	return b.annotations.Delete(filter, key)
+}
+
+// ScopedTopicAnnotations returns the annotations of the topic within the namespace.
+func (b *Broker) ScopedTopicAnnotations(token string, topic string) (map[string]string, error) {
+	ns, err := b.namespaces.Resolve(token)
+	if err != nil {
+		return nil, err
+	}
+	if !ns.ContainsTopic(topic) {
+		return nil, namespace.ErrOutOfScope
+	}
+
+	return b.annotations.Lookup(topic), nil
+}
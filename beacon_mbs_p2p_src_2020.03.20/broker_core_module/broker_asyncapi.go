@@ -0,0 +1,49 @@
+package broker_core_module
+
+import (
+	"awesomeProject/beacon/mqtt_network/libs/asyncapi"
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// ExportAsyncAPI describes the live topic namespace as an AsyncAPI document: the topics having a
+// retained message or published recently, with their annotations, and the payload schema inferred
+// from the retained message. It's generated on each call, so it follows the topics as they come
+// and go.
+func (b *Broker) ExportAsyncAPI() ([]byte, error) {
+	known, err := b.topicsManager.Expand([]byte(topics.MWC))
+	if err != nil {
+		return nil, err
+	}
+
+	var retainedList []*packets.PublishPacket
+	if err := b.topicsManager.Retained([]byte(topics.MWC), &retainedList); err != nil {
+		return nil, err
+	}
+	samples := make(map[string][]byte, len(retainedList))
+	for _, rm := range retainedList {
+		samples[rm.TopicName] = rm.Payload
+	}
+
+	channels := make([]asyncapi.Channel, 0, len(known))
+	for _, kt := range known {
+		channels = append(channels, asyncapi.Channel{
+			Topic:       kt.Topic,
+			Retained:    kt.Retained,
+			LastPublish: kt.LastPublish,
+			Sample:      samples[kt.Topic],
+			Annotations: b.annotations.Lookup(kt.Topic),
+		})
+	}
+
+	servers := map[string]asyncapi.Server{
+		"broker": {URL: "mqtt://" + b.addr, Protocol: "mqtt", ProtocolVersion: "3.1.1"},
+	}
+	info := asyncapi.Info{
+		Title:   "beacon broker " + b.BrokerID().String(),
+		Version: b.clock.Now().UTC().Format("20060102T150405Z"),
+	}
+
+	return asyncapi.Build(info, servers, channels, nil).Marshal()
+}
@@ -0,0 +1,52 @@
+package broker_core_module
+
+import (
+	"net"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/auth"
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// checkConnectAuth asks the auth provider about the credentials of the CONNECT, the token of a
+// token client is its authentication data. The refused credentials get a bad user name or
+// password, and the server is unavailable if the provider cannot decide. It also returns when the
+// accepted credentials expire, zero if they never do.
+func (b *Broker) checkConnectAuth(msg *packets.ConnectPacket, connect *mqtt5.Packet, remoteAddr net.Addr) (byte, time.Time) {
+	if b.authManager == nil {
+		return packets.Accepted, time.Time{}
+	}
+
+	c := auth.Credentials{
+		ClientID: msg.ClientIdentifier,
+		Username: msg.Username,
+		Password: msg.Password,
+	}
+	if tokenAuth(connect) {
+		c.Password = connect.Properties.AuthData
+	}
+	if remoteAddr != nil {
+		c.RemoteAddr = remoteAddr.String()
+	}
+
+	ok, expiry, err := b.authManager.AuthenticateExpiry(c)
+	if err != nil {
+		b.logger.Error("core_module/broker_auth/checkConnectAuth: auth provider error => ",
+			zap.Error(err),
+			zap.String("clientID", msg.ClientIdentifier),
+			zap.String("username", msg.Username),
+		)
+		return packets.ErrRefusedServerUnavailable, time.Time{}
+	}
+	if !ok {
+		b.logger.Warn("core_module/broker_auth/checkConnectAuth: reject the connect with bad credentials",
+			zap.String("clientID", msg.ClientIdentifier),
+			zap.String("username", msg.Username),
+		)
+		return packets.ErrRefusedBadUsernameOrPassword, time.Time{}
+	}
+	return packets.Accepted, expiry
+}
@@ -0,0 +1,72 @@
+package broker_core_module
+
+import (
+	"awesomeProject/beacon/mqtt_network/libs/autosub"
+
+	"go.uber.org/zap"
+)
+
+// AutoSubscriptions returns the templates of the subscriptions made for each client on CONNECT.
+func (b *Broker) AutoSubscriptions() []autosub.Subscription {
+	b.autoSubMu.RLock()
+	defer b.autoSubMu.RUnlock()
+	return b.autoSubscriptions
+}
+
+// SetAutoSubscriptions replaces the templates of the subscriptions made on CONNECT, the connected
+// clients keep theirs.
+func (b *Broker) SetAutoSubscriptions(list []autosub.Subscription) error {
+	if err := autosub.Validate(list); err != nil {
+		return err
+	}
+	b.autoSubMu.Lock()
+	b.autoSubscriptions = list
+	b.autoSubMu.Unlock()
+	return nil
+}
+
+// autoSubscribe subscribes the client to the subscriptions of the broker and to the ones the auth
+// provider tells for it, as if it had sent a SUBSCRIBE but without the SUBACK. The filters of a
+// resumed session are kept as they are. The retained messages are delivered like for a SUBSCRIBE.
+func (c *client) autoSubscribe() {
+	b := c.broker
+	list := b.AutoSubscriptions()
+	if b.authManager != nil {
+		if subs, ok := b.authManager.Subscriptions(c.info.clientID); ok {
+			list = append(list[:len(list):len(list)], subs...)
+		}
+	}
+	if len(list) == 0 {
+		return
+	}
+
+	var topicList []string
+	var qosList []byte
+	for _, s := range autosub.Expand(list, c.info.clientID, c.info.username) {
+		if _, ok := c.subscriptionMap[s.Filter]; ok {
+			continue
+		}
+		topicList = append(topicList, s.Filter)
+		qosList = append(qosList, s.Qos)
+	}
+	if len(topicList) == 0 {
+		return
+	}
+
+	returnCodes, ok := c.subscribeTopics(topicList, qosList, nil)
+	if !ok {
+		return
+	}
+	for i, code := range returnCodes {
+		if code >= QosFailure {
+			c.logger.Warn("core_module/broker_autosub/autoSubscribe: the subscription is refused",
+				zap.String("filter", topicList[i]),
+				zap.Uint8("code", code),
+			)
+		}
+	}
+	c.logger.Info("core_module/broker_autosub/autoSubscribe: subscribed the client on connect",
+		zap.Strings("filters", topicList),
+	)
+	c.deliverRetained()
+}
@@ -0,0 +1,78 @@
+package broker_core_module
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"awesomeProject/beacon/mqtt_network/libs/backup"
+
+	"go.uber.org/zap"
+)
+
+// The names of the stores in the backup archives
+const (
+	BackupStoreTopics      = "topics"
+	BackupStoreSchedule    = "schedule"
+	BackupStoreAnnotations = "annotations"
+	BackupStorePacketIDs   = "packet_ids"
+)
+
+// backupStores returns the stores persisted to a file, the stores kept in memory only are not
+// backed up.
+func (b *Broker) backupStores() []backup.Store {
+	var stores []backup.Store
+	if len(b.topicsFile) > 0 {
+		stores = append(stores, backup.Store{Name: BackupStoreTopics, Snapshotter: b.topicsManager})
+	}
+	if len(b.scheduleFile) > 0 {
+		stores = append(stores, backup.Store{Name: BackupStoreSchedule, Snapshotter: b.scheduler})
+	}
+	if len(b.annotationsFile) > 0 {
+		stores = append(stores, backup.Store{Name: BackupStoreAnnotations, Snapshotter: b.annotations})
+	}
+	if len(b.packetIDFile) > 0 {
+		stores = append(stores, backup.Store{Name: BackupStorePacketIDs, Snapshotter: b.packetIDs})
+	}
+	return stores
+}
+
+// Backup writes a snapshot of the persisted stores to the archive, the broker keeps serving the
+// clients meanwhile.
+func (b *Broker) Backup(w io.Writer) (*backup.Manifest, error) {
+	return backup.Write(w, b.BrokerID().String(), b.clock.Now(), b.backupStores())
+}
+
+// BackupFile writes the archive to a file of the dir named after the broker and the time, the file
+// is renamed once complete so a failed backup never leaves a partial archive.
+func (b *Broker) BackupFile(dir string) (string, error) {
+	path := filepath.Join(dir, fmt.Sprintf("backup-%s-%d.tar.gz", b.BrokerID().String(), b.clock.Now().Unix()))
+	tmp, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return "", err
+	}
+
+	m, err := b.Backup(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		b.logger.Error("core_module/broker_backup/BackupFile: backup error => ", zap.Error(err))
+		return "", err
+	}
+
+	b.logger.Info("core_module/broker_backup/BackupFile: the stores are backed up",
+		zap.String("file", path),
+		zap.Int("stores", len(m.Stores)),
+	)
+	return path, nil
+}
@@ -0,0 +1,171 @@
+package broker_core_module
+
+import (
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/batch"
+	"awesomeProject/beacon/mqtt_network/libs/fanout"
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+const (
+	minBatchInterval = 10 * time.Millisecond
+	maxBatchInterval = time.Minute
+
+	defaultBatchMaxItems = 1000
+	defaultBatchMaxBytes = 256 * 1024
+)
+
+// BatchPolicyFunc returns the batching interval imposed on the client by its identity, 0 for the
+// immediate delivery.
+type BatchPolicyFunc func(clientID string, username string) time.Duration
+
+// deliveryBatch coalesces the messages delivered to the client, they are sent as one container
+// message on the topic batch.Topic(interval) at each interval, or as soon as it's full.
+type deliveryBatch struct {
+	interval time.Duration
+	topic    string
+	batch    *batch.Batch
+	full     chan struct{}
+}
+
+// enableBatching starts the coalesced delivery, it's done once per connection, the first interval
+// requested wins.
+func (c *client) enableBatching(interval time.Duration) {
+	if interval < minBatchInterval {
+		interval = minBatchInterval
+	}
+	if interval > maxBatchInterval {
+		interval = maxBatchInterval
+	}
+
+	c.mu.Lock()
+	if c.batching != nil {
+		c.mu.Unlock()
+		return
+	}
+	db := &deliveryBatch{
+		interval: interval,
+		topic:    batch.Topic(interval),
+		batch:    batch.New(defaultBatchMaxItems, defaultBatchMaxBytes),
+		full:     make(chan struct{}, 1),
+	}
+	c.batching = db
+	c.mu.Unlock()
+
+	c.logger.Info("core_module/broker_batch/enableBatching: coalesce the deliveries of the client ",
+		zap.Duration("interval", interval),
+	)
+
+	go c.flushBatchLoop(db)
+}
+
+// deliver writes the publish to the subscriber, or adds it to the container if the client asked
+// for coalesced delivery. The delivery is counted in the QoS report of the subscription filter.
+func (c *client) deliver(packet *packets.PublishPacket, filter string) error {
+	return c.deliverExt(packet, filter, nil, nil)
+}
+
+// deliverExt delivers the publish with the 5.0 fields in ext, which may be nil, and with the
+// encoding shared by its subscribers if it's not nil. The container of the coalesced delivery
+// carries no properties.
+func (c *client) deliverExt(packet *packets.PublishPacket, filter string, ext *mqtt5.Packet, shared *fanout.Message) error {
+	c.mu.Lock()
+	db, q := c.batching, c.outq
+	c.mu.Unlock()
+
+	if c.broker != nil {
+		c.broker.qosReport.Attempt(filter, packet.Dup)
+	}
+
+	if db == nil && q != nil {
+		return c.enqueueDelivery(q, packet, filter, ext, shared)
+	}
+	if db == nil {
+		span := c.deliverySpan(c.traceParent(packet), filter)
+		defer span.End()
+
+		pkt, err := c.outboundPacket(packet)
+		if err != nil {
+			span.SetAttribute("error", err.Error())
+			c.dropDelivery(filter)
+			return err
+		}
+		if pkt != packet {
+			c.trackInflight(pkt, filter, c.pendingReceipt(packet))
+		}
+		if err := c.writePublish(pkt, ext, shared); err != nil {
+			span.SetAttribute("error", err.Error())
+			if pkt != packet {
+				if d, ok := c.untrackInflight(pkt.MessageID); ok && c.broker != nil {
+					d.receipt.Fail(c.broker.clock.Now())
+				}
+			}
+			c.dropDelivery(filter)
+			return err
+		}
+		c.pluginDeliver(pkt)
+		return nil
+	}
+
+	full := db.batch.Add(batch.Item{
+		Topic:   packet.TopicName,
+		Qos:     packet.Qos,
+		Retain:  packet.Retain,
+		Payload: packet.Payload,
+	})
+	c.pluginDeliver(packet)
+	if full {
+		select {
+		case db.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (c *client) flushBatchLoop(db *deliveryBatch) {
+	ticker := c.broker.clock.NewTicker(db.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C():
+		case <-db.full:
+		}
+		c.flushBatch(db)
+	}
+}
+
+// The container is sent at QoS 0, the QoS of each message is carried in the container only.
+func (c *client) flushBatch(db *deliveryBatch) {
+	items := db.batch.Take()
+	if len(items) == 0 {
+		return
+	}
+
+	payload, err := batch.Encode(items)
+	if err != nil {
+		c.logger.Error("core_module/broker_batch/flushBatch: encode container error => ",
+			zap.Error(err),
+		)
+		return
+	}
+
+	packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	packet.TopicName = db.topic
+	packet.Qos = QosAtMostOnce
+	packet.Payload = payload
+
+	if err := c.WriterPacket(packet); err != nil {
+		c.logger.Error("core_module/broker_batch/flushBatch: Error publish container to subscriber => ",
+			zap.Error(err),
+			zap.Int("messages", len(items)),
+		)
+	}
+}
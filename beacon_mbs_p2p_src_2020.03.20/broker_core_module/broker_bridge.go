@@ -0,0 +1,357 @@
+package broker_core_module
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"awesomeProject/beacon/mqtt_network/libs/bridge"
+	"awesomeProject/beacon/mqtt_network/libs/mqttclient"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// The messages waiting to be published to the remote broker, the newer ones are dropped beyond it
+const bridgeQueue = 1024
+
+// BridgeStats is the state of a bridge to a remote broker.
+type BridgeStats struct {
+	Name      string `json:"name"`
+	Connected bool   `json:"connected"`
+	Sent      uint64 `json:"sent"`
+	Received  uint64 `json:"received"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+type bridgeMessage struct {
+	topic   string
+	qos     byte
+	retain  bool
+	payload []byte
+}
+
+// brokerBridge is the connection to a remote broker, the broker is one of its clients.
+type brokerBridge struct {
+	cfg    bridge.Config
+	client *mqttclient.Client
+	logger *zap.Logger
+	echo   *bridge.EchoGuard
+	out    chan *bridgeMessage
+	// the subscriptions of the local topics, and the channel closed once the bridge is stopped
+	subs []*bridgeSubscription
+	stop chan struct{}
+
+	subscribed atomic.Bool
+	sent       atomic.Uint64
+	received   atomic.Uint64
+	dropped    atomic.Uint64
+}
+
+// bridgeSubscription subscribes the local topics of an outbound rule.
+type bridgeSubscription struct {
+	broker *Broker
+	bridge *brokerBridge
+	rule   *bridge.Rule
+	// the index of the rule in the config of the bridge
+	index int
+}
+
+// ID keys the subscription by its bridge and rule, the rules may share their local filter.
+func (s *bridgeSubscription) ID() string {
+	return internalID("bridge", s.bridge.cfg.Name+"/"+strconv.Itoa(s.index))
+}
+
+func (s *bridgeSubscription) OnPublish(message *packets.PublishPacket) error {
+	s.deliver(s.broker, message)
+	return nil
+}
+
+func (s *bridgeSubscription) deliver(b *Broker, packet *packets.PublishPacket) {
+	if b.LocalTopic(packet.TopicName) {
+		return
+	}
+	topic, ok := s.rule.ToRemote(packet.TopicName)
+	if !ok {
+		return
+	}
+	br := s.bridge
+	now := b.clock.Now()
+	if br.echo.Echo(packet.TopicName, packet.Payload, now) {
+		return
+	}
+
+	qos := packet.Qos
+	if qos > s.rule.Qos {
+		qos = s.rule.Qos
+	}
+	select {
+	case br.out <- &bridgeMessage{topic: topic, qos: qos, retain: packet.Retain, payload: packet.Payload}:
+		br.echo.Forwarded(topic, packet.Payload, now)
+	default:
+		br.dropped.Inc()
+	}
+}
+
+// checkBridges checks the configs of the bridges, their names are unique.
+func checkBridges(configs []bridge.Config) error {
+	names := make(map[string]bool, len(configs))
+	for i := range configs {
+		if err := configs[i].Validate(); err != nil {
+			return err
+		}
+		if names[configs[i].Name] {
+			return fmt.Errorf("core_module/broker_bridge/checkBridges: duplicate bridge %s", configs[i].Name)
+		}
+		names[configs[i].Name] = true
+	}
+	return nil
+}
+
+// startBridges connects to the remote brokers. The first connection is attempted again with the
+// backoff of the bridge until it succeeds, the client reconnects by itself after, and subscribes
+// the remote topics again.
+func (b *Broker) startBridges() {
+	b.bridgesMu.Lock()
+	defer b.bridgesMu.Unlock()
+
+	for _, cfg := range b.bridgeConfigs {
+		b.startBridge(cfg)
+	}
+}
+
+// startBridge connects to the remote broker of the config, and subscribes the local topics of its
+// outbound rules. The caller holds bridgesMu.
+func (b *Broker) startBridge(cfg bridge.Config) {
+	br := &brokerBridge{
+		cfg:    cfg,
+		logger: b.subsystemLogger(LogBridge).With(zap.String("bridge", cfg.Name)),
+		echo:   bridge.NewEchoGuard(0),
+		out:    make(chan *bridgeMessage, bridgeQueue),
+		stop:   make(chan struct{}),
+	}
+	client, err := mqttclient.New(mqttclient.Options{
+		Brokers:      cfg.Addrs,
+		ClientID:     cfg.ClientID,
+		Username:     cfg.Username,
+		Password:     cfg.Password,
+		KeepAlive:    cfg.KeepAlive,
+		CleanSession: cfg.CleanSession,
+		OnConnect: func(*mqttclient.Client) {
+			b.subscribeBridge(br)
+		},
+		OnConnectionLost: func(_ *mqttclient.Client, err error) {
+			br.logger.Warn("core_module/broker_bridge/startBridge: bridge connection lost, reconnecting ",
+				zap.Error(err),
+			)
+		},
+	})
+	if err != nil {
+		br.logger.Error("core_module/broker_bridge/startBridge: create bridge client error => ",
+			zap.Error(err),
+		)
+		return
+	}
+	br.client = client
+
+	for i := range br.cfg.Rules {
+		rule := &br.cfg.Rules[i]
+		if !rule.Outbound() {
+			continue
+		}
+		filter := rule.LocalFilter()
+		sub := &bridgeSubscription{broker: b, bridge: br, rule: rule, index: i}
+		if _, err := b.topicsManager.Subscribe([]byte(filter), rule.Qos, sub); err != nil {
+			br.logger.Error("core_module/broker_bridge/startBridge: subscribe the local topics error => ",
+				zap.Error(err),
+				zap.String("filter", filter),
+			)
+			continue
+		}
+		b.brokerNode.ProcessSubNumMapForAdd(filter)
+		br.subs = append(br.subs, sub)
+	}
+
+	b.bridges = append(b.bridges, br)
+	go b.connectBridge(br)
+}
+
+// stopBridge unsubscribes the local topics of the bridge and disconnects it from the remote
+// broker, the messages waiting to be published are dropped. The caller holds bridgesMu.
+func (b *Broker) stopBridge(br *brokerBridge) {
+	for _, sub := range br.subs {
+		filter := sub.rule.LocalFilter()
+		if err := b.topicsManager.Unsubscribe([]byte(filter), sub); err != nil {
+			br.logger.Error("core_module/broker_bridge/stopBridge: unsubscribe the local topics error => ",
+				zap.Error(err),
+				zap.String("filter", filter),
+			)
+			continue
+		}
+		b.brokerNode.ProcessSubNumMapForDel(filter)
+	}
+	close(br.stop)
+	br.client.Close()
+	br.logger.Info("core_module/broker_bridge/stopBridge: the bridge is stopped")
+}
+
+// reloadBridges stops the bridges removed from the configs or changed, and starts the ones added
+// or changed; the unchanged bridges stay connected.
+func (b *Broker) reloadBridges(configs []bridge.Config) error {
+	if err := checkBridges(configs); err != nil {
+		return err
+	}
+	b.bridgesMu.Lock()
+	defer b.bridgesMu.Unlock()
+
+	wanted := make(map[string]bridge.Config, len(configs))
+	for _, cfg := range configs {
+		wanted[cfg.Name] = cfg
+	}
+	running := make(map[string]bool, len(b.bridges))
+	var kept []*brokerBridge
+	for _, br := range b.bridges {
+		if cfg, ok := wanted[br.cfg.Name]; ok && reflect.DeepEqual(cfg, br.cfg) {
+			kept = append(kept, br)
+			running[br.cfg.Name] = true
+			continue
+		}
+		b.stopBridge(br)
+	}
+	b.bridges = kept
+	b.bridgeConfigs = configs
+
+	for _, cfg := range configs {
+		if !running[cfg.Name] {
+			b.startBridge(cfg)
+		}
+	}
+	return nil
+}
+
+func (b *Broker) connectBridge(br *brokerBridge) {
+	for failures := 1; ; failures++ {
+		err := br.client.Connect()
+		if err == nil {
+			break
+		}
+		delay := br.cfg.Backoff(failures)
+		br.logger.Warn("core_module/broker_bridge/connectBridge: connect to the remote broker error, retry later => ",
+			zap.Error(err),
+			zap.Duration("backoff", delay),
+		)
+		timer := b.clock.NewTimer(delay)
+		select {
+		case <-timer.C():
+		case <-br.stop:
+			timer.Stop()
+			return
+		}
+	}
+	// the bridge may be stopped while it connects
+	select {
+	case <-br.stop:
+		br.client.Close()
+		return
+	default:
+	}
+	br.logger.Info("core_module/broker_bridge/connectBridge: connected to the remote broker ")
+
+	for {
+		var m *bridgeMessage
+		select {
+		case m = <-br.out:
+		case <-br.stop:
+			return
+		}
+		if err := br.client.Publish(m.topic, m.qos, m.retain, m.payload); err != nil {
+			br.dropped.Inc()
+			br.logger.Error("core_module/broker_bridge/connectBridge: publish to the remote broker error => ",
+				zap.Error(err),
+				zap.String("topic", m.topic),
+			)
+			continue
+		}
+		br.sent.Inc()
+	}
+}
+
+// subscribeBridge subscribes the remote topics of the inbound rules once, the client subscribes
+// them again after each reconnection.
+func (b *Broker) subscribeBridge(br *brokerBridge) {
+	if br.subscribed.Load() {
+		return
+	}
+	for i := range br.cfg.Rules {
+		rule := &br.cfg.Rules[i]
+		if !rule.Inbound() {
+			continue
+		}
+		err := br.client.SubscribeMessages(rule.RemoteFilter(), rule.Qos, func(m mqttclient.Message) {
+			b.receiveBridged(br, rule, m)
+		})
+		if err != nil {
+			br.logger.Error("core_module/broker_bridge/subscribeBridge: subscribe the remote topics error => ",
+				zap.Error(err),
+				zap.String("filter", rule.RemoteFilter()),
+			)
+			return
+		}
+	}
+	br.subscribed.Store(true)
+}
+
+// receiveBridged publishes the remote message like a client publish, it's forwarded to the peer
+// brokers and delivered to the local subscribers.
+func (b *Broker) receiveBridged(br *brokerBridge, rule *bridge.Rule, m mqttclient.Message) {
+	topic, ok := rule.ToLocal(m.Topic)
+	if !ok {
+		return
+	}
+	now := b.clock.Now()
+	if br.echo.Echo(m.Topic, m.Payload, now) {
+		return
+	}
+	br.echo.Forwarded(topic, m.Payload, now)
+	br.received.Inc()
+
+	packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	packet.TopicName = topic
+	packet.Qos = m.Qos
+	if packet.Qos > rule.Qos {
+		packet.Qos = rule.Qos
+	}
+	packet.Retain = m.Retained
+	packet.Payload = m.Payload
+
+	if !b.LocalTopic(topic) {
+		b.brokerNode.candidateForwardConfirmChan <- packet
+	}
+	if packet.Retain {
+		if err := b.topicsManager.Retain(packet); err != nil {
+			br.logger.Error("core_module/broker_bridge/receiveBridged: Error retaining message => ",
+				zap.Error(err),
+			)
+		}
+	}
+	b.SubmitPublishPacketsWorkTask(packet)
+}
+
+// Bridges returns the state of the bridges to the remote brokers.
+func (b *Broker) Bridges() []BridgeStats {
+	b.bridgesMu.Lock()
+	defer b.bridgesMu.Unlock()
+
+	list := make([]BridgeStats, 0, len(b.bridges))
+	for _, br := range b.bridges {
+		list = append(list, BridgeStats{
+			Name:      br.cfg.Name,
+			Connected: br.client.IsConnected(),
+			Sent:      br.sent.Load(),
+			Received:  br.received.Load(),
+			Dropped:   br.dropped.Load(),
+		})
+	}
+	return list
+}
@@ -0,0 +1,107 @@
+package broker_core_module
+
+import (
+	"strconv"
+
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// ServerVersion is the version of the broker, it may be set at build time with
+// -ldflags "-X awesomeProject/beacon/mqtt_network/broker_core_module.ServerVersion=<version>".
+var ServerVersion = "2020.03.20"
+
+// The capabilities are retained under this topic, one message each.
+const capabilitiesTopic = "$SYS/broker/capabilities/"
+
+// protocolMaximumPacketSize is the largest packet MQTT can encode, the broker sets no lower limit.
+const protocolMaximumPacketSize = 268435455 + 5
+
+// Capabilities is what the clients may rely on, they are retained under $SYS/broker/capabilities/#
+// and sent in the CONNACK of the 5.0 clients.
+type Capabilities struct {
+	MaximumPacketSize               uint32 `json:"maximum_packet_size"`
+	MaximumQoS                      byte   `json:"maximum_qos"`
+	RetainAvailable                 bool   `json:"retain_available"`
+	WildcardSubscriptionAvailable   bool   `json:"wildcard_subscription_available"`
+	SharedSubscriptionAvailable     bool   `json:"shared_subscription_available"`
+	SubscriptionIdentifierAvailable bool   `json:"subscription_identifier_available"`
+	TopicAliasMaximum               uint16 `json:"topic_alias_maximum"`
+	ServerVersion                   string `json:"server_version"`
+}
+
+// Capabilities returns the capabilities of the broker.
+func (b *Broker) Capabilities() Capabilities {
+	return Capabilities{
+		MaximumPacketSize:               protocolMaximumPacketSize,
+		MaximumQoS:                      QosExactlyOnce,
+		RetainAvailable:                 true,
+		WildcardSubscriptionAvailable:   true,
+		SharedSubscriptionAvailable:     true,
+		SubscriptionIdentifierAvailable: true,
+		TopicAliasMaximum:               b.topicAliasMaximum,
+		ServerVersion:                   ServerVersion,
+	}
+}
+
+func boolByte(v bool) byte {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// setProperties sets the capabilities in the CONNACK properties. The maximum packet size is left
+// out when it's the protocol limit, the property being absent means no limit.
+func (c Capabilities) setProperties(props *mqtt5.Properties) {
+	if c.MaximumPacketSize < protocolMaximumPacketSize {
+		props.MaximumPacketSize = mqtt5.Uint32(c.MaximumPacketSize)
+	}
+	if c.MaximumQoS < QosExactlyOnce {
+		props.MaximumQoS = mqtt5.Byte(c.MaximumQoS)
+	}
+	props.RetainAvailable = mqtt5.Byte(boolByte(c.RetainAvailable))
+	props.WildcardSubscriptionAvailable = mqtt5.Byte(boolByte(c.WildcardSubscriptionAvailable))
+	props.SharedSubscriptionAvailable = mqtt5.Byte(boolByte(c.SharedSubscriptionAvailable))
+	props.SubscriptionIdentifierAvailable = mqtt5.Byte(boolByte(c.SubscriptionIdentifierAvailable))
+	props.User = append(props.User, mqtt5.UserProperty{Key: "server_version", Value: c.ServerVersion})
+}
+
+// topics returns the payload of each capability by topic.
+func (c Capabilities) topics() map[string]string {
+	return map[string]string{
+		capabilitiesTopic + "maximum_packet_size":               strconv.FormatUint(uint64(c.MaximumPacketSize), 10),
+		capabilitiesTopic + "maximum_qos":                       strconv.Itoa(int(c.MaximumQoS)),
+		capabilitiesTopic + "retain_available":                  strconv.FormatBool(c.RetainAvailable),
+		capabilitiesTopic + "wildcard_subscription_available":   strconv.FormatBool(c.WildcardSubscriptionAvailable),
+		capabilitiesTopic + "shared_subscription_available":     strconv.FormatBool(c.SharedSubscriptionAvailable),
+		capabilitiesTopic + "subscription_identifier_available": strconv.FormatBool(c.SubscriptionIdentifierAvailable),
+		capabilitiesTopic + "topic_alias_maximum":               strconv.Itoa(int(c.TopicAliasMaximum)),
+		capabilitiesTopic + "server_version":                    c.ServerVersion,
+	}
+}
+
+// retainCapabilities retains the capabilities of the broker, they never expire. This will be
+// called by StartListening.
+func (b *Broker) retainCapabilities() {
+	b.retainForever("core_module/broker_capabilities/retainCapabilities", b.Capabilities().topics())
+}
+
+// retainForever retains the payloads by topic without expiry, the errors are logged for the caller.
+func (b *Broker) retainForever(caller string, topics map[string]string) {
+	for topic, payload := range topics {
+		packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		packet.TopicName = topic
+		packet.Qos = QosAtMostOnce
+		packet.Retain = true
+		packet.Payload = []byte(payload)
+		if err := b.topicsManager.RetainWithExpiry(packet, -1); err != nil {
+			b.logger.Warn(caller+": retain error, ",
+				zap.Error(err),
+				zap.String("topic", topic),
+			)
+		}
+	}
+}
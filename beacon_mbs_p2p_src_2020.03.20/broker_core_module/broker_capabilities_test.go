@@ -0,0 +1,80 @@
+package broker_core_module
+
+import (
+	"strconv"
+	"testing"
+
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+
+	"github.com/stretchr/testify/require"
+)
+
+// expectRetained returns the payloads of the next n publishes by topic, they must be retained.
+func (c *testClient) expectRetained(n int) map[string]string {
+	c.t.Helper()
+
+	m := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		p := c.expectPublish()
+		require.True(c.t, p.Retain, p.TopicName)
+		m[p.TopicName] = string(p.Payload)
+	}
+	return m
+}
+
+// userProperty returns the value of the user property of the key, false if there's none.
+func userProperty(props *mqtt5.Properties, key string) (string, bool) {
+	for _, u := range props.User {
+		if u.Key == key {
+			return u.Value, true
+		}
+	}
+	return "", false
+}
+
+func TestCapabilities(t *testing.T) {
+	b := newTestBroker(t, WithTopicAliasMaximum(8))
+	require.Equal(t, Capabilities{
+		MaximumPacketSize:               protocolMaximumPacketSize,
+		MaximumQoS:                      QosExactlyOnce,
+		RetainAvailable:                 true,
+		WildcardSubscriptionAvailable:   true,
+		SharedSubscriptionAvailable:     true,
+		SubscriptionIdentifierAvailable: true,
+		TopicAliasMaximum:               8,
+		ServerVersion:                   ServerVersion,
+	}, b.Capabilities())
+
+	c := connectTestClient(t, b, "app", "", false)
+	require.Equal(t, byte(0), c.subscribe(capabilitiesTopic+"#", 0))
+	require.Equal(t, map[string]string{
+		capabilitiesTopic + "maximum_packet_size":               strconv.Itoa(protocolMaximumPacketSize),
+		capabilitiesTopic + "maximum_qos":                       "2",
+		capabilitiesTopic + "retain_available":                  "true",
+		capabilitiesTopic + "wildcard_subscription_available":   "true",
+		capabilitiesTopic + "shared_subscription_available":     "true",
+		capabilitiesTopic + "subscription_identifier_available": "true",
+		capabilitiesTopic + "topic_alias_maximum":               "8",
+		capabilitiesTopic + "server_version":                    ServerVersion,
+	}, c.expectRetained(8))
+	c.expectNothing()
+
+	// a 5.0 client gets them in the CONNACK, the absent limits are the protocol ones
+	v5 := connectTestClient(t, b, "app5", "", true)
+	props := v5.connack.Properties
+	require.NotNil(t, props)
+	require.Nil(t, props.MaximumPacketSize)
+	require.Nil(t, props.MaximumQoS)
+	require.Equal(t, uint16(8), *props.TopicAliasMaximum)
+	for _, available := range []*byte{
+		props.RetainAvailable,
+		props.WildcardSubscriptionAvailable,
+		props.SharedSubscriptionAvailable,
+		props.SubscriptionIdentifierAvailable,
+	} {
+		require.Equal(t, byte(1), *available)
+	}
+	version, ok := userProperty(props, "server_version")
+	require.True(t, ok)
+	require.Equal(t, ServerVersion, version)
+}
@@ -0,0 +1,165 @@
+package broker_core_module
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+
+	"awesomeProject/beacon/mqtt_network/libs/capture"
+	"awesomeProject/beacon/mqtt_network/libs/logging"
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+var errCaptureRunning = errors.New("core_module/broker_capture/StartCapture: a capture is already running")
+
+// The admin API of the wire capture. The packets read from and written to the connected clients
+// are recorded, the CONNECT and CONNACK of the connections opened during the capture included, in
+// a pcap file for Wireshark or in JSON lines. One capture runs at a time.
+
+// StartCapture starts the capture, it's stopped by StopCapture or at its limits.
+func (b *Broker) StartCapture(cfg capture.Config) error {
+	b.captureMu.Lock()
+	defer b.captureMu.Unlock()
+
+	if s := b.captureSession(); s != nil {
+		return errCaptureRunning
+	}
+	s, err := capture.Start(cfg, b.clock.Now())
+	if err != nil {
+		return err
+	}
+	b.capture.Store(s)
+
+	if cfg.Duration > 0 {
+		go func() {
+			timer := b.clock.NewTimer(cfg.Duration)
+			defer timer.Stop()
+			<-timer.C()
+			b.stopCapture(s)
+		}()
+	}
+
+	b.logger.Info("core_module/broker_capture/StartCapture: capture started ",
+		zap.String("Path", cfg.Path),
+		logging.ClientID(cfg.ClientID),
+		zap.String("Filter", cfg.Filter),
+	)
+	return nil
+}
+
+// StopCapture stops the running capture and returns its summary, false if there is none.
+func (b *Broker) StopCapture() (capture.Summary, bool, error) {
+	s := b.captureSession()
+	if s == nil {
+		return capture.Summary{}, false, nil
+	}
+	summary, err := b.stopCapture(s)
+	return summary, true, err
+}
+
+// CaptureStatus returns the summary of the running capture, false if there is none.
+func (b *Broker) CaptureStatus() (capture.Summary, bool) {
+	s := b.captureSession()
+	if s == nil {
+		return capture.Summary{}, false
+	}
+	return s.Summary(), true
+}
+
+func (b *Broker) captureSession() *capture.Session {
+	s, _ := b.capture.Load().(*capture.Session)
+	return s
+}
+
+func (b *Broker) stopCapture(s *capture.Session) (capture.Summary, error) {
+	b.captureMu.Lock()
+	if b.captureSession() == s {
+		b.capture.Store((*capture.Session)(nil))
+	}
+	b.captureMu.Unlock()
+
+	summary, err := s.Stop(b.clock.Now())
+	if err != nil {
+		b.logger.Error("core_module/broker_capture/stopCapture: close capture error => ",
+			zap.Error(err),
+			zap.String("Path", summary.Config.Path),
+		)
+	} else {
+		b.logger.Info("core_module/broker_capture/stopCapture: capture stopped ",
+			zap.String("Path", summary.Config.Path),
+			zap.Int("Packets", summary.Packets),
+			zap.Int64("Bytes", summary.Bytes),
+		)
+	}
+	return summary, err
+}
+
+// capturePacket records the packet of the client if a capture is running and matches it, ext
+// holds the 5.0 fields of the packet. The packet is encoded again in the version of the client.
+func (c *client) capturePacket(packet packets.ControlPacket, ext *mqtt5.Packet, inbound bool) {
+	b := c.broker
+	if b == nil {
+		return
+	}
+	s := b.captureSession()
+	if s == nil {
+		return
+	}
+
+	publish, isPublish := packet.(*packets.PublishPacket)
+	var topic string
+	if isPublish {
+		topic = publish.TopicName
+	}
+	if !s.Match(c.info.clientID, isPublish, topic) {
+		return
+	}
+
+	r := &capture.Record{
+		Time:     b.clock.Now(),
+		ClientID: c.info.clientID,
+		Inbound:  inbound,
+		Type:     reflect.TypeOf(packet).Elem().Name(),
+		Topic:    topic,
+	}
+	if conn := c.conn; conn != nil {
+		r.Client, r.Broker = conn.RemoteAddr(), conn.LocalAddr()
+	}
+	if connect, ok := packet.(*packets.ConnectPacket); ok && len(connect.Password) > 0 {
+		// the passwords are never captured
+		masked := *connect
+		masked.Password = bytes.Repeat([]byte{'x'}, len(connect.Password))
+		packet = &masked
+	}
+	if isPublish {
+		redacted := *publish
+		redacted.Payload = s.Redact(publish.Payload)
+		packet = &redacted
+		r.Qos = publish.Qos
+		r.Payload = redacted.Payload
+	}
+
+	var buf bytes.Buffer
+	var err error
+	if c.isV5() {
+		p := mqtt5.Packet{Control: packet}
+		if ext != nil {
+			p = *ext
+			p.Control = packet
+		}
+		err = mqtt5.Write(&buf, &p)
+	} else {
+		err = packet.Write(&buf)
+	}
+	if err != nil {
+		return
+	}
+	r.Data = buf.Bytes()
+
+	if err := s.Add(r); err != nil {
+		b.stopCapture(s)
+	}
+}
@@ -0,0 +1,143 @@
+package broker_core_module
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/certmon"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+const defaultCertificateCheck = time.Hour
+
+// CertIdentity maps the client certificates to the MQTT identities, so the devices authenticate
+// with their certificate instead of a password.
+type CertIdentity struct {
+	// From is the field of the certificate naming the client, certmon.IdentityCN, IdentityDNS,
+	// IdentityEmail or IdentityURI
+	From string
+	// Username replaces the username of the client with the name
+	Username bool
+	// ClientID replaces the client id of the client with the name
+	ClientID bool
+}
+
+// Certificate returns the state of the certificate of the TLS listener.
+func (b *Broker) Certificate() (certmon.Status, error) {
+	if b.certMonitor == nil {
+		return certmon.Status{}, errors.New("core_module/broker_certificate: the listener has no TLS")
+	}
+	return b.certMonitor.Status(), nil
+}
+
+// acceptTLS wraps the accepted connection if the listener has TLS, the handshake is done by the
+// first read of the CONNECT.
+func (b *Broker) acceptTLS(conn net.Conn) net.Conn {
+	if b.certMonitor == nil {
+		return conn
+	}
+	return tls.Server(conn, b.certMonitor.TLSConfig())
+}
+
+// peerCertificate returns the client certificate verified by the TLS handshake, nil if the
+// connection has no TLS or the client presented none.
+func peerCertificate(conn net.Conn) *x509.Certificate {
+	var state *tls.ConnectionState
+	switch c := conn.(type) {
+	case *tls.Conn:
+		s := c.ConnectionState()
+		state = &s
+	case *wsConn:
+		state = c.tlsState
+	case *quicConn:
+		state = c.tlsState()
+	}
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	return state.PeerCertificates[0]
+}
+
+// checkCertIdentity sets the identity of the certificate of the client into its CONNECT, and
+// reports whether the certificate authenticates the client. A client without a certificate, when
+// the listener accepts them, authenticates with its password. A certificate without the name is
+// refused.
+func (b *Broker) checkCertIdentity(conn net.Conn, msg *packets.ConnectPacket) (bool, byte) {
+	if b.certIdentity == nil {
+		return false, packets.Accepted
+	}
+	cert := peerCertificate(conn)
+	if cert == nil {
+		return false, packets.Accepted
+	}
+
+	name := certmon.Identity(cert, b.certIdentity.From)
+	if len(name) == 0 {
+		b.logger.Warn("core_module/broker_certificate/checkCertIdentity: the client certificate has no identity, reject the connect",
+			zap.String("clientID", msg.ClientIdentifier),
+			zap.String("subject", cert.Subject.String()),
+			zap.String("from", b.certIdentity.From),
+		)
+		return false, packets.ErrRefusedNotAuthorised
+	}
+	if code := b.checkPinnedCertificate(name, cert, msg); code != packets.Accepted {
+		return false, code
+	}
+
+	if b.certIdentity.Username {
+		msg.Username = name
+		msg.UsernameFlag = true
+	}
+	if b.certIdentity.ClientID {
+		msg.ClientIdentifier = name
+	}
+	b.logger.Debug("core_module/broker_certificate/checkCertIdentity: the client is authenticated by its certificate",
+		zap.String("clientID", msg.ClientIdentifier),
+		zap.String("identity", name),
+	)
+	return true, packets.Accepted
+}
+
+// startCertificateTask reloads the renewed certificate files, refreshes the OCSP staple, and
+// reports the certificate and its alerts, at start then every hour.
+func (b *Broker) startCertificateTask() {
+	if b.certMonitor == nil {
+		return
+	}
+
+	go func() {
+		ticker := b.clock.NewTicker(defaultCertificateCheck)
+		defer ticker.Stop()
+
+		for {
+			b.checkCertificate()
+			<-ticker.C()
+		}
+	}()
+}
+
+func (b *Broker) checkCertificate() {
+	if err := b.certMonitor.Refresh(); err != nil {
+		b.logger.Error("core_module/broker_certificate/checkCertificate: refresh certificate error => ", zap.Error(err))
+	}
+
+	brokerIdStr := b.BrokerID().String()
+	status, _ := json.Marshal(b.certMonitor.Status())
+	b.CertificateMetricsNotification(brokerIdStr, string(status))
+
+	for _, alert := range b.certMonitor.Alerts() {
+		b.logger.Warn("core_module/broker_certificate/checkCertificate: certificate alert ",
+			zap.String("kind", alert.Kind),
+			zap.String("subject", alert.Subject),
+			zap.String("message", alert.Message),
+		)
+		info, _ := json.Marshal(alert)
+		b.CertificateAlertNotification(brokerIdStr, alert.Kind, string(info))
+	}
+}
@@ -0,0 +1,41 @@
+package broker_core_module
+
+import (
+	"errors"
+
+	"awesomeProject/beacon/mqtt_network/libs/chaos"
+)
+
+var errChaosNotBuilt = errors.New("core_module/broker_chaos: the broker is not built with the chaos tag")
+
+// The admin API of the fault injection, it fails unless the broker is built with the "chaos" tag.
+
+// SetFault injects the fault, replacing the one with the same ID.
+func (b *Broker) SetFault(f chaos.Fault) error {
+	if !chaosBuilt {
+		return errChaosNotBuilt
+	}
+	return b.chaos.Set(f)
+}
+
+func (b *Broker) RemoveFault(id string) error {
+	if !chaosBuilt {
+		return errChaosNotBuilt
+	}
+	return b.chaos.Remove(id)
+}
+
+func (b *Broker) ClearFaults() error {
+	if !chaosBuilt {
+		return errChaosNotBuilt
+	}
+	b.chaos.Clear()
+	return nil
+}
+
+func (b *Broker) Faults() ([]chaos.Fault, error) {
+	if !chaosBuilt {
+		return nil, errChaosNotBuilt
+	}
+	return b.chaos.Faults(), nil
+}
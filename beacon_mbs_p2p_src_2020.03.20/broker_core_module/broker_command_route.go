@@ -0,0 +1,88 @@
+package broker_core_module
+
+import (
+	"strings"
+	"sync"
+
+	"awesomeProject/beacon/mqtt_network/libs/hashring"
+
+	"go.uber.org/zap"
+)
+
+// commandRouter owns each device of the command topics by one broker of the cluster, by
+// consistent hashing of the device over the known brokers. The ring is built again when the
+// brokers change, only the devices of the joining or leaving brokers move.
+type commandRouter struct {
+	prefixes []string
+
+	mu         sync.Mutex
+	membership uint64
+	ring       *hashring.Ring
+}
+
+func newCommandRouter(prefixes []string) *commandRouter {
+	r := &commandRouter{}
+	for _, p := range prefixes {
+		if !strings.HasSuffix(p, "/") {
+			p += "/"
+		}
+		r.prefixes = append(r.prefixes, p)
+	}
+	return r
+}
+
+// device returns the device of a command topic, the level following the prefix.
+func (r *commandRouter) device(topic string) (string, bool) {
+	for _, p := range r.prefixes {
+		if !strings.HasPrefix(topic, p) {
+			continue
+		}
+		device := topic[len(p):]
+		if i := strings.IndexByte(device, '/'); i >= 0 {
+			device = device[:i]
+		}
+		return device, true
+	}
+	return "", false
+}
+
+// commandOwner returns the broker id owning the command topic, ok is false if the topic is not a
+// command topic. The messages of a device are processed by the subscribers of its owner only, so
+// they are serialized on one broker.
+func (b *Broker) commandOwner(topic string) (string, bool) {
+	r := b.commandRouter
+	if r == nil {
+		return "", false
+	}
+	device, ok := r.device(topic)
+	if !ok {
+		return "", false
+	}
+
+	r.mu.Lock()
+	if membership := b.brokerNode.membership.Load(); r.ring == nil || membership != r.membership {
+		var members []string
+		b.brokerNode.nodeIDMap.Range(func(k, _ interface{}) bool {
+			members = append(members, k.(string))
+			return true
+		})
+		r.ring = hashring.New(hashring.DefaultReplicas, members...)
+		r.membership = membership
+		b.logger.Info("core_module/broker_command_route/commandOwner: the command topics are rebalanced, ",
+			zap.Strings("brokers", r.ring.Members()),
+		)
+	}
+	owner := r.ring.Owner(device)
+	r.mu.Unlock()
+
+	if len(owner) == 0 {
+		owner = b.BrokerID().String()
+	}
+	return owner, true
+}
+
+// CommandOwner returns the broker id owning the device of the command topic, ok is false if the
+// topic is not a command topic.
+func (b *Broker) CommandOwner(topic string) (string, bool) {
+	return b.commandOwner(topic)
+}
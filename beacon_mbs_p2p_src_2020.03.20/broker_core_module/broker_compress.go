@@ -0,0 +1,39 @@
+package broker_core_module
+
+import (
+	"awesomeProject/beacon/mqtt_network/libs/compress"
+	"awesomeProject/beacon/mqtt_network/libs/sessions"
+
+	"go.uber.org/zap"
+)
+
+// CompressionStats returns the counters of the payload compression: the ratio of the compressed
+// payloads and the time spent compressing and decompressing them, with the bytes saved by each
+// policy.
+func (b *Broker) CompressionStats() compress.Stats {
+	return b.compressor.Stats()
+}
+
+// compressQueued compresses the payload of the message kept by the session, if it's over the
+// threshold of the policy of its topic.
+func (b *Broker) compressQueued(m *sessions.Message) {
+	m.Payload, m.Codec = b.compressor.CompressTopic(m.Topic, m.Payload)
+}
+
+// queuedPayload returns the payload of the message kept by the session, decompressed, false if it
+// cannot be and the message is dropped.
+func (c *client) queuedPayload(m sessions.Message) ([]byte, bool) {
+	var comp *compress.Compressor
+	if c.broker != nil {
+		comp = c.broker.compressor
+	}
+	payload, err := comp.Decompress(m.Payload, m.Codec)
+	if err != nil {
+		c.logger.Error("core_module/broker_compress/queuedPayload: decompress error, drop the message => ",
+			zap.Error(err),
+			zap.String("topic", m.Topic),
+		)
+		return nil, false
+	}
+	return payload, true
+}
@@ -0,0 +1,95 @@
+package broker_core_module
+
+import (
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/computed"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// computedSubscription subscribes the source filter of a computed topic, and feeds the
+// matched payloads to its window.
+type computedSubscription struct {
+	broker   *Broker
+	computed *computed.Computed
+}
+
+// ID keys the subscription by its computed topic, the computed topics may share their source.
+func (c *computedSubscription) ID() string {
+	return internalID("computed", c.computed.Definition().Topic)
+}
+
+func (c *computedSubscription) OnPublish(message *packets.PublishPacket) error {
+	c.deliver(c.broker, message)
+	return nil
+}
+
+func (c *computedSubscription) deliver(b *Broker, packet *packets.PublishPacket) {
+	if err := c.computed.Observe(packet.Payload, b.clock.Now()); err != nil {
+		b.logger.Debug("core_module/broker_computed/deliver: skip the payload which has no value",
+			zap.Error(err),
+			zap.String("topic", packet.TopicName),
+			zap.String("computed topic", c.computed.Definition().Topic),
+		)
+	}
+}
+
+// This will be called by StartListening
+func (b *Broker) startComputedTopicsTask() {
+	for _, c := range b.computedList {
+		def := c.Definition()
+		cs := &computedSubscription{broker: b, computed: c}
+
+		if _, err := b.topicsManager.Subscribe([]byte(def.Source), QosAtMostOnce, cs); err != nil {
+			b.logger.Error("core_module/broker_computed/startComputedTopicsTask: subscribe source error, ",
+				zap.Error(err),
+				zap.String("source", def.Source),
+				zap.String("computed topic", def.Topic),
+			)
+			continue
+		}
+
+		// The peer brokers forward the packets of the source topics to this broker too.
+		b.brokerNode.ProcessSubNumMapForAdd(def.Source)
+
+		go b.publishComputedTopic(c)
+	}
+}
+
+// The computed value is published as a retained message, so the subscribers get the latest value
+// at once without waiting for the next interval. It stops once the broker shuts down.
+func (b *Broker) publishComputedTopic(c *computed.Computed) {
+	def := c.Definition()
+	ticker := b.clock.NewTicker(def.Interval)
+	defer ticker.Stop()
+
+	for {
+		var now time.Time
+		select {
+		case <-b.quit:
+			return
+		case now = <-ticker.C():
+		}
+
+		v, ok := c.Evaluate(now)
+		if !ok {
+			continue
+		}
+
+		packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		packet.TopicName = def.Topic
+		packet.Qos = QosAtMostOnce
+		packet.Retain = true
+		packet.Payload = computed.FormatValue(v)
+
+		if err := b.topicsManager.Retain(packet); err != nil {
+			b.logger.Error("core_module/broker_computed/publishComputedTopic: Error retaining message => ",
+				zap.Error(err),
+				zap.String("computed topic", def.Topic),
+			)
+		}
+		b.SubmitPublishPacketsWorkTask(packet)
+	}
+}
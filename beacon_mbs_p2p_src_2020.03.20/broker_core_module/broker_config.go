@@ -0,0 +1,296 @@
+package broker_core_module
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/auth"
+	"awesomeProject/beacon/mqtt_network/libs/config"
+
+	"go.uber.org/zap"
+)
+
+const defaultConfigCheck = 10 * time.Second
+
+// applyConfig sets the broker up with the sections of the config file, the options after
+// WithConfigFile override them.
+func (b *Broker) applyConfig(cfg *config.Config) error {
+	l := cfg.Listeners
+	if len(l.MQTT) > 0 {
+		host, _ := cfg.Host()
+		if host != nil {
+			b.host = host
+		}
+		b.port, _ = cfg.Port()
+	}
+	if l.TLS != nil {
+		tls := *l.TLS
+		b.tlsConfig = &tls
+	}
+	if l.WebSocket != nil {
+		b.wsConfig = &WebSocketConfig{Addr: l.WebSocket.Addr, Path: l.WebSocket.Path, TLS: l.WebSocket.TLS}
+	}
+	if l.QUIC != nil {
+		b.quicConfig = &QUICConfig{
+			Addr:            l.QUIC.Addr,
+			Allow0RTT:       l.QUIC.Allow0RTT,
+			MaxIdleTimeout:  l.QUIC.MaxIdleTimeout,
+			KeepAlivePeriod: l.QUIC.KeepAlivePeriod,
+		}
+	}
+	if l.Admin != nil {
+		b.adminConfig = &AdminConfig{Addr: l.Admin.Addr, Token: l.Admin.Token}
+	}
+	if l.Gateway != nil {
+		b.gatewayConfig = &GatewayConfig{Addr: l.Gateway.Addr, Token: l.Gateway.Token, Username: l.Gateway.Username}
+	}
+	if l.MQTTSN != nil {
+		b.mqttsnConfig = &MQTTSNConfig{
+			Addr:        l.MQTTSN.Addr,
+			Username:    l.MQTTSN.Username,
+			Predefined:  l.MQTTSN.Predefined,
+			MaxBuffered: l.MQTTSN.MaxBuffered,
+		}
+	}
+
+	p := cfg.Providers
+	if p.Topics == config.ProviderBolt {
+		b.topicsFile = p.TopicsFile
+	}
+	if p.Sessions == config.ProviderFile {
+		b.sessionsDir = p.SessionsDir
+	}
+	var err error
+	switch p.Auth {
+	case config.ProviderFile:
+		err = auth.RegisterFileAuthProvider(p.AuthFile)
+	case config.ProviderHTTP:
+		err = auth.RegisterHTTPAuthProvider(auth.HTTPConfig{URL: p.AuthURL, Timeout: p.AuthTimeout})
+	}
+	if err != nil {
+		return err
+	}
+	if len(p.Auth) > 0 {
+		if b.authManager, err = auth.NewManager(p.Auth); err != nil {
+			return err
+		}
+	}
+
+	b.limits = cfg.Limits.Clients
+	b.payloadLimitList = append(b.payloadLimitList, cfg.Limits.Payloads...)
+	if cfg.Limits.Memory != nil {
+		memory := *cfg.Limits.Memory
+		b.memoryConfig = &memory
+	}
+	if cfg.Limits.Filters != nil {
+		filters := *cfg.Limits.Filters
+		b.filterLimits = &filters
+	}
+	if len(cfg.ACLFile) > 0 {
+		b.aclFile = cfg.ACLFile
+	}
+	b.bridgeConfigs = append(b.bridgeConfigs, cfg.Bridges...)
+	b.autoSubscriptions = append(b.autoSubscriptions, cfg.AutoSubscribe...)
+	if cfg.Tenants != nil {
+		tenants := *cfg.Tenants
+		b.tenantNamespaceConfig = &tenants
+	}
+	b.schemaBindings = append(b.schemaBindings, cfg.Schemas...)
+
+	if len(cfg.Logging.Level) > 0 {
+		return b.configLogger(cfg)
+	}
+	return nil
+}
+
+// configLogger replaces the logger of the broker with the one of the logging section, its level is
+// changed once the file is reloaded.
+func (b *Broker) configLogger(cfg *config.Config) error {
+	level, err := cfg.Level()
+	if err != nil {
+		return err
+	}
+	atomicLevel := zap.NewAtomicLevelAt(level)
+
+	zcfg := zap.NewProductionConfig()
+	var opts []zap.Option
+	if cfg.Logging.Development {
+		zcfg = zap.NewDevelopmentConfig()
+	} else {
+		opts = append(opts, zap.AddStacktrace(zap.PanicLevel))
+	}
+	zcfg.Level = atomicLevel
+	logger, err := zcfg.Build(opts...)
+	if err != nil {
+		return err
+	}
+	b.logger = logger
+	b.logLevel = &atomicLevel
+	return nil
+}
+
+// ReloadConfig reads the config file again and applies its reloadable sections to the running
+// broker, the ACL file is read again too. The other sections changed are logged, they apply once
+// the broker restarts. The current config is kept if the file is invalid.
+func (b *Broker) ReloadConfig() error {
+	if len(b.configFile) == 0 {
+		return errors.New("core_module/broker_config/ReloadConfig: the broker has no config file")
+	}
+	b.configMu.Lock()
+	defer b.configMu.Unlock()
+
+	fi, err := os.Stat(b.configFile)
+	if err != nil {
+		return err
+	}
+	// an invalid file is reported once, not on each check
+	b.configModTime = fi.ModTime()
+	cfg, err := config.Load(b.configFile)
+	if err != nil {
+		return err
+	}
+	old := b.config
+	b.config = cfg
+
+	if b.acl != nil {
+		if err := b.acl.Reload(); err != nil {
+			b.logger.Error("core_module/broker_config/ReloadConfig: reload the ACL error, the current rules are kept => ",
+				zap.Error(err),
+				zap.String("file", b.aclFile),
+			)
+		} else {
+			b.recordConfigChange("acl", b.aclFile)
+		}
+	}
+
+	for _, section := range config.Changed(old, cfg) {
+		if !b.reloadSection(section, old, cfg) {
+			b.logger.Warn("core_module/broker_config/ReloadConfig: the section changed, it applies once the broker restarts",
+				zap.String("file", b.configFile),
+				zap.String("section", section),
+			)
+			continue
+		}
+		b.logger.Info("core_module/broker_config/ReloadConfig: the section is reloaded",
+			zap.String("file", b.configFile),
+			zap.String("section", section),
+		)
+		b.recordConfigChange(section, b.configFile)
+	}
+	return nil
+}
+
+// reloadSection applies the changed section to the running broker, false if it can't be.
+func (b *Broker) reloadSection(section string, old, cfg *config.Config) bool {
+	if !config.Reloadable(section) {
+		return false
+	}
+	switch section {
+	case config.SectionLimits:
+		b.limitsMu.Lock()
+		b.limits = cfg.Limits.Clients
+		b.limitsMu.Unlock()
+		// the payload limits are checked on each publish without a lock
+		if !reflect.DeepEqual(old.Limits.Payloads, cfg.Limits.Payloads) {
+			b.logger.Warn("core_module/broker_config/reloadSection: the payload limits apply once the broker restarts",
+				zap.String("file", b.configFile),
+			)
+		}
+		if !reflect.DeepEqual(old.Limits.Filters, cfg.Limits.Filters) {
+			b.logger.Warn("core_module/broker_config/reloadSection: the filter limits apply once the broker restarts",
+				zap.String("file", b.configFile),
+			)
+		}
+		if !reflect.DeepEqual(old.Limits.Memory, cfg.Limits.Memory) {
+			b.logger.Warn("core_module/broker_config/reloadSection: the memory limits apply once the broker restarts",
+				zap.String("file", b.configFile),
+			)
+		}
+	case config.SectionBridges:
+		if err := b.reloadBridges(cfg.Bridges); err != nil {
+			b.logger.Error("core_module/broker_config/reloadSection: reload the bridges error => ",
+				zap.Error(err),
+				zap.String("file", b.configFile),
+			)
+			return false
+		}
+	case config.SectionLogging:
+		return b.reloadLogging(old, cfg)
+	case config.SectionAutoSubscribe:
+		if err := b.SetAutoSubscriptions(cfg.AutoSubscribe); err != nil {
+			b.logger.Error("core_module/broker_config/reloadSection: reload the auto subscriptions error => ",
+				zap.Error(err),
+				zap.String("file", b.configFile),
+			)
+			return false
+		}
+	}
+	return true
+}
+
+// reloadLogging changes the level of the logger of the broker and the ones of the subsystems, the
+// subsystems removed from the file follow the level of the broker again.
+func (b *Broker) reloadLogging(old, cfg *config.Config) bool {
+	if old.Logging.Development != cfg.Logging.Development {
+		return false
+	}
+	if old.Logging.Level != cfg.Logging.Level {
+		if b.logLevel == nil || len(cfg.Logging.Level) == 0 {
+			return false
+		}
+		level, _ := cfg.Level()
+		b.logLevel.SetLevel(level)
+	}
+
+	for name := range old.Logging.Levels {
+		if _, ok := cfg.Logging.Levels[name]; !ok {
+			_ = b.SetLogLevel(name, "default")
+		}
+	}
+	for name, level := range cfg.Logging.Levels {
+		if err := b.SetLogLevel(name, level); err != nil {
+			b.logger.Error("core_module/broker_config/reloadLogging: set the log level error => ",
+				zap.Error(err),
+				zap.String("file", b.configFile),
+			)
+		}
+	}
+	return true
+}
+
+// startConfigTask reloads the config file once it has changed.
+func (b *Broker) startConfigTask() {
+	if len(b.configFile) == 0 {
+		return
+	}
+
+	go func() {
+		ticker := b.clock.NewTicker(defaultConfigCheck)
+		defer ticker.Stop()
+
+		for range ticker.C() {
+			fi, err := os.Stat(b.configFile)
+			if err != nil {
+				b.logger.Error("core_module/broker_config/startConfigTask: stat the config file error => ",
+					zap.Error(err),
+					zap.String("file", b.configFile),
+				)
+				continue
+			}
+			b.configMu.Lock()
+			changed := !fi.ModTime().Equal(b.configModTime)
+			b.configMu.Unlock()
+			if !changed {
+				continue
+			}
+			if err := b.ReloadConfig(); err != nil {
+				b.logger.Error("core_module/broker_config/startConfigTask: reload the config error, the current config is kept => ",
+					zap.Error(err),
+					zap.String("file", b.configFile),
+				)
+			}
+		}
+	}()
+}
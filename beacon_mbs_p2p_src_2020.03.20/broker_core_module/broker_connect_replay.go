@@ -0,0 +1,37 @@
+package broker_core_module
+
+import (
+	"awesomeProject/beacon/mqtt_network/libs/replay"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// ConnectReplayTokenFunc is supplied by the auth provider, it extracts the nonce and the issued time
+// from the CONNECT (such as a signed token carried in the password). The CONNECT without token
+// is not checked.
+type ConnectReplayTokenFunc func(msg *packets.ConnectPacket) (replay.Token, bool)
+
+// checkConnectReplay rejects the CONNECT whose token has been seen already, or whose timestamp is
+// out of the window.
+func (b *Broker) checkConnectReplay(msg *packets.ConnectPacket) byte {
+	if b.replayGuard == nil || b.replayToken == nil {
+		return packets.Accepted
+	}
+
+	token, ok := b.replayToken(msg)
+	if !ok {
+		return packets.Accepted
+	}
+
+	if err := b.replayGuard.Check(token, b.clock.Now()); err != nil {
+		b.logger.Warn("core_module/broker_connect_replay/checkConnectReplay: reject the replayed connect => ",
+			zap.Error(err),
+			zap.String("clientID", msg.ClientIdentifier),
+			zap.String("username", msg.Username),
+		)
+		return packets.ErrRefusedNotAuthorised
+	}
+
+	return packets.Accepted
+}
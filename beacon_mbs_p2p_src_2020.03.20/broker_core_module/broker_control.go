@@ -0,0 +1,200 @@
+package broker_core_module
+
+import (
+	"encoding/json"
+	"strings"
+
+	"awesomeProject/beacon/mqtt_network/libs/logging"
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// ControlPrefix starts the control topics of the clients, $CONTROL/clients/<id>/<action>. Once the
+// control topics are enabled, only the admin clients can publish to them.
+const ControlPrefix = "$CONTROL/clients/"
+
+// The actions of the control topics, the last level of their topic.
+const (
+	// ControlSubscriptions lists the subscriptions of the client, or removes one of them
+	ControlSubscriptions = "subscriptions"
+	// ControlKick disconnects the client
+	ControlKick = "kick"
+)
+
+// The actions of a ControlRequest to the subscriptions of a client.
+const (
+	ControlList        = "list"
+	ControlUnsubscribe = "unsubscribe"
+)
+
+// controlReplySuffix ends the topic of the reply to a control request, after the request topic.
+const controlReplySuffix = "/reply"
+
+// ControlConfig lets the admin clients inspect and manage the clients by publishing to the control
+// topics, like the admin API does over HTTP, for the tooling which speaks MQTT only.
+type ControlConfig struct {
+	// Usernames are the admin clients, the ACL must allow them to publish to the control topics too
+	Usernames []string
+}
+
+// ControlRequest is the JSON payload of a publish to $CONTROL/clients/<id>/subscriptions, an empty
+// payload lists the subscriptions.
+type ControlRequest struct {
+	// Action is ControlList, the default, or ControlUnsubscribe
+	Action string `json:"action"`
+	// Filter is the subscription removed, as listed
+	Filter string `json:"filter"`
+}
+
+// ControlReply is the JSON payload of the reply to a control request, it's delivered to the admin
+// client only, on the request topic followed by /reply.
+type ControlReply struct {
+	ClientID      string              `json:"client_id"`
+	Error         string              `json:"error,omitempty"`
+	Subscriptions []AdminSubscription `json:"subscriptions,omitempty"`
+}
+
+// controlAdmin reports whether the client may use the control topics.
+func (b *Broker) controlAdmin(username string) bool {
+	for _, u := range b.controlConfig.Usernames {
+		if u == username {
+			return true
+		}
+	}
+	return false
+}
+
+// parseControlTopic returns the client id and the action of a control topic, the client id may
+// have several levels.
+func parseControlTopic(topic string) (string, string, bool) {
+	rest := strings.TrimPrefix(topic, ControlPrefix)
+	i := strings.LastIndexByte(rest, '/')
+	if len(rest) == len(topic) || i <= 0 {
+		return "", "", false
+	}
+	return rest[:i], rest[i+1:], true
+}
+
+// processControlRequest runs the control request of an admin client, it reports whether the
+// publish was to a control topic. The publishes of the other clients to the control topics are
+// denied, they never reach the subscribers. An admin client of a tenant controls the clients of its
+// namespace only.
+func (c *client) processControlRequest(packet *packets.PublishPacket) bool {
+	b := c.broker
+	if b == nil || b.controlConfig == nil || !strings.HasPrefix(packet.TopicName, ControlPrefix) {
+		return false
+	}
+	if !b.controlAdmin(c.info.username) || !c.allowPublish(packet) {
+		c.logger.Warn("core_module/broker_control/processControlRequest: the client is not an admin, deny the control request",
+			zap.String("username", c.info.username),
+			zap.String("topic", packet.TopicName),
+		)
+		c.denyPublish(packet)
+		return true
+	}
+
+	clientID, action, ok := parseControlTopic(packet.TopicName)
+	if !ok || (action != ControlSubscriptions && action != ControlKick) {
+		c.acknowledgePublish(packet, mqtt5.TopicNameInvalid)
+		return true
+	}
+	if !c.controlsClient(clientID) {
+		c.logger.Warn("core_module/broker_control/processControlRequest: the client is out of the namespace of the admin, deny the control request",
+			zap.String("username", c.info.username),
+			logging.ClientID(clientID),
+		)
+		c.denyPublish(packet)
+		return true
+	}
+
+	reply := ControlReply{ClientID: clientID}
+	switch action {
+	case ControlKick:
+		c.acknowledgePublish(packet, mqtt5.Success)
+		b.logger.Info("core_module/broker_control/processControlRequest: kick the client by control request ",
+			logging.ClientID(clientID),
+			zap.String("admin", c.info.clientID),
+		)
+		if !b.Kick(clientID) {
+			reply.Error = "client not connected"
+		}
+	case ControlSubscriptions:
+		req := ControlRequest{Action: ControlList}
+		if len(packet.Payload) > 0 {
+			if err := json.Unmarshal(packet.Payload, &req); err != nil || (req.Action != ControlList && req.Action != ControlUnsubscribe) {
+				c.acknowledgePublish(packet, mqtt5.PayloadFormatInvalid)
+				return true
+			}
+		}
+		c.acknowledgePublish(packet, mqtt5.Success)
+		reply = b.controlSubscriptions(clientID, req, c.info.clientID)
+	}
+	c.replyControl(packet, reply)
+	return true
+}
+
+// controlsClient reports whether the admin client may control the client, which is in the same
+// tenant namespace unless the admin has none. The clients not connected are left to the request.
+func (c *client) controlsClient(clientID string) bool {
+	if c.namespace == nil {
+		return true
+	}
+	v, exist := c.broker.clients.Load(clientID)
+	target, ok := v.(*client)
+	if !exist || !ok {
+		return true
+	}
+	return target.namespace == c.namespace
+}
+
+// controlSubscriptions lists the subscriptions of the client connected to this broker, once the
+// subscription of the request is removed if it asks to.
+func (b *Broker) controlSubscriptions(clientID string, req ControlRequest, admin string) ControlReply {
+	reply := ControlReply{ClientID: clientID}
+	v, exist := b.clients.Load(clientID)
+	target, ok := v.(*client)
+	if !exist || !ok {
+		reply.Error = "client not connected"
+		return reply
+	}
+
+	if req.Action == ControlUnsubscribe {
+		if !target.removeSubscription(req.Filter) {
+			reply.Error = "no subscription existed"
+		} else {
+			b.logger.Info("core_module/broker_control/controlSubscriptions: unsubscribe the client by control request ",
+				logging.ClientID(clientID),
+				logging.Topic(req.Filter),
+				zap.String("admin", admin),
+			)
+			if target.persistentSession() {
+				b.saveSession(clientID)
+			}
+		}
+	}
+	reply.Subscriptions = target.adminSubscriptions()
+	return reply
+}
+
+// replyControl delivers the reply to the admin client, at the QoS of the request up to 1.
+func (c *client) replyControl(packet *packets.PublishPacket, reply ControlReply) {
+	payload, err := json.Marshal(reply)
+	if err != nil {
+		return
+	}
+	pkt := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	pkt.TopicName = packet.TopicName + controlReplySuffix
+	pkt.Payload = payload
+	pkt.Qos = packet.Qos
+	if pkt.Qos > QosAtLeastOnce {
+		pkt.Qos = QosAtLeastOnce
+	}
+	if err := c.deliver(pkt, pkt.TopicName); err != nil {
+		c.logger.Warn("core_module/broker_control/replyControl: deliver the reply error => ",
+			zap.Error(err),
+			zap.String("topic", pkt.TopicName),
+		)
+	}
+}
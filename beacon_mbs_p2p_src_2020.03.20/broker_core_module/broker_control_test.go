@@ -0,0 +1,128 @@
+package broker_core_module
+
+import (
+	"encoding/json"
+	"testing"
+
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+)
+
+// controlRequest publishes the request at QoS 1 and returns the reason code of its PUBACK, and the
+// reply if the broker sends one.
+func (c *testClient) controlRequest(topic string, payload string) (byte, *ControlReply) {
+	c.t.Helper()
+
+	p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	p.TopicName = topic
+	p.Payload = []byte(payload)
+	p.Qos = 1
+	p.MessageID = c.nextID()
+	c.write(p)
+
+	var (
+		reason byte
+		acked  bool
+		reply  *ControlReply
+	)
+	for !acked || reply == nil {
+		pkt, err := c.readWithin(testReadTimeout / 4)
+		if err != nil {
+			break
+		}
+		switch cp := pkt.Control.(type) {
+		case *packets.PubackPacket:
+			require.Equal(c.t, p.MessageID, cp.MessageID)
+			reason, acked = pkt.ReasonCode, true
+		case *packets.PublishPacket:
+			require.Equal(c.t, topic+controlReplySuffix, cp.TopicName)
+			reply = &ControlReply{}
+			require.NoError(c.t, json.Unmarshal(cp.Payload, reply))
+		}
+	}
+	require.True(c.t, acked)
+	return reason, reply
+}
+
+func TestControlSubscriptions(t *testing.T) {
+	b := newTestBroker(t, WithControlTopics(ControlConfig{Usernames: []string{"admin"}}))
+
+	admin := connectTestClient(t, b, "admin", "admin", true)
+	dev := connectTestClient(t, b, "dev1", "dev", false)
+	require.Equal(t, byte(1), dev.subscribe("a/+", 1))
+	require.Equal(t, byte(0), dev.subscribe("b/#", 0))
+
+	reason, reply := admin.controlRequest("$CONTROL/clients/dev1/subscriptions", "")
+	require.Equal(t, mqtt5.Success, reason)
+	require.Equal(t, &ControlReply{ClientID: "dev1", Subscriptions: []AdminSubscription{
+		{Filter: "a/+", Qos: 1},
+		{Filter: "b/#", Qos: 0},
+	}}, reply)
+
+	reason, reply = admin.controlRequest("$CONTROL/clients/dev1/subscriptions", `{"action":"unsubscribe","filter":"a/+"}`)
+	require.Equal(t, mqtt5.Success, reason)
+	require.Equal(t, &ControlReply{ClientID: "dev1", Subscriptions: []AdminSubscription{
+		{Filter: "b/#", Qos: 0},
+	}}, reply)
+
+	// the subscription is removed from the trie too
+	admin.publish("a/x", "1", 0, false)
+	admin.publish("b/x", "2", 0, false)
+	p := dev.expectPublish()
+	require.Equal(t, "b/x", p.TopicName)
+	dev.expectNothing()
+
+	_, reply = admin.controlRequest("$CONTROL/clients/dev1/subscriptions", `{"action":"unsubscribe","filter":"a/+"}`)
+	require.Equal(t, "no subscription existed", reply.Error)
+
+	reason, reply = admin.controlRequest("$CONTROL/clients/dev1/subscriptions", `{"action":"drop"}`)
+	require.Equal(t, mqtt5.PayloadFormatInvalid, reason)
+	require.Nil(t, reply)
+
+	// the client is subscribed again as it was
+	require.Equal(t, byte(1), dev.subscribe("a/+", 1))
+	_, reply = admin.controlRequest("$CONTROL/clients/dev1/subscriptions", "")
+	require.Len(t, reply.Subscriptions, 2)
+}
+
+func TestControlKick(t *testing.T) {
+	b := newTestBroker(t, WithControlTopics(ControlConfig{Usernames: []string{"admin"}}))
+
+	admin := connectTestClient(t, b, "admin", "admin", true)
+	dev := connectTestClient(t, b, "dev1", "dev", false)
+	require.Equal(t, byte(1), dev.subscribe("a/+", 1))
+
+	reason, reply := admin.controlRequest("$CONTROL/clients/ghost/kick", "")
+	require.Equal(t, mqtt5.Success, reason)
+	require.Equal(t, &ControlReply{ClientID: "ghost", Error: "client not connected"}, reply)
+	_, reply = admin.controlRequest("$CONTROL/clients/ghost/subscriptions", "")
+	require.Equal(t, &ControlReply{ClientID: "ghost", Error: "client not connected"}, reply)
+
+	reason, reply = admin.controlRequest("$CONTROL/clients/dev1/kick", "")
+	require.Equal(t, mqtt5.Success, reason)
+	require.Equal(t, &ControlReply{ClientID: "dev1"}, reply)
+	dev.expectClosed()
+}
+
+func TestControlNotAdmin(t *testing.T) {
+	b := newTestBroker(t, WithControlTopics(ControlConfig{Usernames: []string{"admin"}}))
+
+	dev := connectTestClient(t, b, "dev1", "dev", false)
+	require.Equal(t, byte(1), dev.subscribe("a/+", 1))
+	other := connectTestClient(t, b, "dev2", "dev", true)
+	// the control topics are denied even to the clients subscribed to them
+	require.Equal(t, byte(0), other.subscribe("$CONTROL/#", 0))
+
+	for _, topic := range []string{"$CONTROL/clients/dev1/kick", "$CONTROL/clients/dev1/subscriptions"} {
+		reason, reply := other.controlRequest(topic, `{"action":"unsubscribe","filter":"a/+"}`)
+		require.Equal(t, mqtt5.NotAuthorized, reason)
+		require.Nil(t, reply)
+	}
+
+	// dev1 is still connected and subscribed
+	other.publish("a/x", "1", 0, false)
+	p := dev.expectPublish()
+	require.Equal(t, "a/x", p.TopicName)
+}
@@ -0,0 +1,117 @@
+package broker_core_module
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/sessions"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// The reasons of the dead letters, the second level of their topic.
+const (
+	// DeadLetterOverflow is a delivery dropped by a full outbound queue or offline queue
+	DeadLetterOverflow = "overflow"
+	// DeadLetterDenied is a publish denied by the ACL, the owner of the topic or a plugin
+	DeadLetterDenied = "denied"
+	// DeadLetterExpired is a retained message dropped once its message expiry or TTL elapsed
+	DeadLetterExpired = "expired"
+	// DeadLetterNoSubscribers is a publish matching no subscriber of the broker
+	DeadLetterNoSubscribers = "no_subscribers"
+	// DeadLetterInvalid is a publish whose payload doesn't match the schema of its topic
+	DeadLetterInvalid = "invalid"
+)
+
+// DeadLetterPrefix starts the topics of the dead letters, $deadletter/<reason>/<original topic>.
+// The clients cannot publish to them.
+const DeadLetterPrefix = "$deadletter/"
+
+// DeadLetterConfig republishes the messages dropped by the broker to the dead letter topics, so the
+// operators can audit the message loss.
+type DeadLetterConfig struct {
+	// Qos of the dead letters
+	Qos byte
+	// NoSubscribers dead-letters the publishes matching no subscriber of the broker too, they may
+	// still be delivered by the peer brokers
+	NoSubscribers bool
+}
+
+// DeadLetter is the JSON payload of a dead letter, the payload of the message is base64 encoded.
+type DeadLetter struct {
+	Reason   string    `json:"reason"`
+	Topic    string    `json:"topic"`
+	ClientID string    `json:"client_id,omitempty"`
+	Qos      byte      `json:"qos"`
+	Retain   bool      `json:"retain,omitempty"`
+	Time     time.Time `json:"time"`
+	Payload  []byte    `json:"payload"`
+}
+
+// isDeadLetterTopic reports whether the topic is a dead letter topic.
+func isDeadLetterTopic(topic string) bool {
+	return strings.HasPrefix(topic, DeadLetterPrefix)
+}
+
+// deadLetter republishes the dropped message to the dead letter topic of the reason, clientID is
+// the publisher, or the subscriber of a dropped delivery. The dead letters and the $SYS topics are
+// never dead-lettered, a dead letter nobody reads would loop.
+func (b *Broker) deadLetter(reason string, clientID string, packet *packets.PublishPacket) {
+	cfg := b.deadLetterConfig
+	if cfg == nil || isDeadLetterTopic(packet.TopicName) || isSysTopic(packet.TopicName) {
+		return
+	}
+	if reason == DeadLetterNoSubscribers && !cfg.NoSubscribers {
+		return
+	}
+
+	payload, err := json.Marshal(DeadLetter{
+		Reason:   reason,
+		Topic:    packet.TopicName,
+		ClientID: clientID,
+		Qos:      packet.Qos,
+		Retain:   packet.Retain,
+		Time:     b.clock.Now(),
+		Payload:  packet.Payload,
+	})
+	if err != nil {
+		b.logger.Error("core_module/broker_deadletter/deadLetter: marshal error => ", zap.Error(err))
+		return
+	}
+
+	letter := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	letter.TopicName = DeadLetterPrefix + reason + "/" + packet.TopicName
+	letter.Qos = cfg.Qos
+	letter.Payload = payload
+
+	b.brokerNode.candidateForwardConfirmChan <- letter
+	b.SubmitPublishPacketsWorkTask(letter)
+}
+
+// deadLetterQueued dead-letters the message dropped by the offline queue of the client.
+func (b *Broker) deadLetterQueued(clientID string, m sessions.Message) {
+	if b.deadLetterConfig == nil {
+		return
+	}
+	payload, err := b.compressor.Decompress(m.Payload, m.Codec)
+	if err != nil {
+		return
+	}
+	packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	packet.TopicName = m.Topic
+	packet.Qos = m.Qos
+	packet.Payload = payload
+	b.deadLetter(DeadLetterOverflow, clientID, packet)
+}
+
+// notifyDeadLetters dead-letters the expired retained messages, before the sweeper starts.
+func (b *Broker) notifyDeadLetters() {
+	if b.deadLetterConfig == nil {
+		return
+	}
+	b.topicsManager.NotifyExpired(func(message *packets.PublishPacket) {
+		b.deadLetter(DeadLetterExpired, "", message)
+	})
+}
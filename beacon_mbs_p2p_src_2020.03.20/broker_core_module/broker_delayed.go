@@ -0,0 +1,140 @@
+package broker_core_module
+
+import (
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/delayed"
+	"awesomeProject/beacon/mqtt_network/libs/logging"
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+const defaultDelayedTick = time.Second
+
+// DelayedPublishes returns the publishes waiting for their delay, the soonest first.
+func (b *Broker) DelayedPublishes() []delayed.Message {
+	return b.delayed.Messages()
+}
+
+// CancelDelayedPublish drops the delayed publish, false if there's none with the ID.
+func (b *Broker) CancelDelayedPublish(id uint64) (bool, error) {
+	return b.delayed.Remove(id)
+}
+
+// processDelayedPublish holds the publish to $delayed/<seconds>/<topic> until its delay has
+// elapsed. The ACL, the plugins and the schemas check the target topic, and the publish is
+// acknowledged once it's queued. A publish without delay goes on to the target topic right away.
+func (c *client) processDelayedPublish(packet *packets.PublishPacket) bool {
+	b := c.broker
+	if b == nil {
+		return false
+	}
+	delay, target, ok, err := delayed.ParseTopic(packet.TopicName)
+	if !ok {
+		return false
+	}
+	if err != nil {
+		c.logger.Warn("core_module/broker_delayed/processDelayedPublish: invalid delayed publish => ",
+			zap.Error(err),
+		)
+		c.acknowledgePublish(packet, mqtt5.TopicNameInvalid)
+		return true
+	}
+	packet.TopicName = target
+	if delay == 0 {
+		return false
+	}
+
+	c.rewritePublish(packet)
+	if !c.allowPublish(packet) || !c.pluginPublish(packet) {
+		c.denyPublish(packet)
+		return true
+	}
+	if !c.validPayload(packet) {
+		return true
+	}
+	// the QoS 2 publish sent again before its PUBREL is queued once
+	if packet.Qos == QosExactlyOnce && c.session != nil && !c.session.ReceiveQos2(packet.MessageID) {
+		c.acknowledgePublish(packet, mqtt5.Success)
+		return true
+	}
+
+	id, err := b.delayed.Add(delayed.Message{
+		Topic:    packet.TopicName,
+		Payload:  packet.Payload,
+		Qos:      packet.Qos,
+		Retain:   packet.Retain,
+		Due:      b.clock.Now().Add(delay),
+		ClientID: c.info.clientID,
+	})
+	if err != nil {
+		c.logger.Warn("core_module/broker_delayed/processDelayedPublish: queue the delayed publish error => ",
+			zap.Error(err),
+			logging.Topic(packet.TopicName),
+		)
+		reasonCode := mqtt5.ImplementationSpecificError
+		if err == delayed.ErrFull {
+			reasonCode = mqtt5.QuotaExceeded
+		}
+		c.acknowledgePublish(packet, reasonCode)
+		return true
+	}
+	if packet.Qos == QosExactlyOnce && c.persistentSession() {
+		b.saveSession(c.info.clientID)
+	}
+	c.logger.Debug("core_module/broker_delayed/processDelayedPublish: the publish is delayed ",
+		logging.Topic(packet.TopicName),
+		zap.Uint64("id", id),
+		zap.Duration("delay", delay),
+	)
+	c.acknowledgePublish(packet, mqtt5.Success)
+	return true
+}
+
+// This will be called by StartListening
+func (b *Broker) startDelayedTask() {
+	go func() {
+		ticker := b.clock.NewTicker(defaultDelayedTick)
+		defer ticker.Stop()
+
+		for now := range ticker.C() {
+			// The messages loaded from the delayed file are not published by a replica.
+			if b.readOnly {
+				continue
+			}
+			due, err := b.delayed.Due(now)
+			if err != nil {
+				b.logger.Error("core_module/broker_delayed/startDelayedTask: save the delayed publishes error => ",
+					zap.Error(err),
+					zap.String("file", b.delayedFile),
+				)
+			}
+			for _, m := range due {
+				b.publishDelayed(m)
+			}
+		}
+	}()
+}
+
+// The delayed publish goes the same way as a scheduled publish once it's due.
+func (b *Broker) publishDelayed(m delayed.Message) {
+	packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	packet.TopicName = m.Topic
+	packet.Qos = m.Qos
+	packet.Retain = m.Retain
+	packet.Payload = m.Payload
+
+	b.brokerNode.candidateForwardConfirmChan <- packet
+
+	if packet.Retain {
+		if err := b.topicsManager.Retain(packet); err != nil {
+			b.logger.Error("core_module/broker_delayed/publishDelayed: Error retaining message => ",
+				zap.Error(err),
+				logging.ClientID(m.ClientID),
+			)
+		}
+	}
+	b.SubmitPublishPacketsWorkTask(packet)
+}
@@ -0,0 +1,56 @@
+package broker_core_module
+
+import (
+	"awesomeProject/beacon/mqtt_network/libs/fanout"
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// publishProperties returns the properties of the PUBLISH of a 5.0 client forwarded to the
+// subscribers of this broker, nil if there are none. The peer brokers get the messages without
+// them.
+func publishProperties(v5 *mqtt5.Packet) *mqtt5.Properties {
+	if v5 == nil {
+		return nil
+	}
+	return mqtt5.PublishProperties(v5.Properties)
+}
+
+// deliverProperties delivers the publish with the properties of the publisher, a 3.1.1 subscriber
+// which cannot receive them gets the publish by the downgrade policy of the broker. The shared
+// encoding of the publish may be nil.
+func (c *client) deliverProperties(packet *packets.PublishPacket, filter string, props *mqtt5.Properties, shared *fanout.Message) error {
+	if props == nil {
+		return c.deliverExt(packet, filter, nil, shared)
+	}
+	if c.isV5() {
+		return c.deliverExt(packet, filter, &mqtt5.Packet{Properties: props}, nil)
+	}
+	if !props.Downgraded() || c.broker == nil {
+		return c.deliverExt(packet, filter, nil, shared)
+	}
+
+	switch c.broker.downgradePolicy {
+	case mqtt5.DowngradeSkip:
+		c.logger.Debug("core_module/broker_downgrade/deliverProperties: skip the 3.1.1 subscriber of a publish with properties",
+			zap.String("topic", packet.TopicName),
+		)
+		return nil
+	case mqtt5.DowngradeEnvelope:
+		payload, err := mqtt5.EncodeEnvelope(props, packet.Payload)
+		if err != nil {
+			return err
+		}
+		pkt := *packet
+		pkt.Payload = payload
+		if c.broker != nil {
+			defer c.broker.aliasReceipt(&pkt, packet)()
+			defer c.broker.aliasTrace(&pkt, packet)()
+		}
+		return c.deliver(&pkt, filter)
+	default:
+		return c.deliverExt(packet, filter, nil, shared)
+	}
+}
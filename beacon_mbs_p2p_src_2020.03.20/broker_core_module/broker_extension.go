@@ -6,6 +6,8 @@ import (
 	"sync"
 	"time"
 
+	"awesomeProject/beacon/mqtt_network/libs/logging"
+	"awesomeProject/beacon/mqtt_network/libs/retaincrdt"
 	"awesomeProject/beacon/mqtt_network/libs/topics_p2p"
 
 	p2p "awesomeProject/beacon/p2p_network/core_module"
@@ -15,6 +17,7 @@ import (
 	"github.com/dustin/go-humanize"
 	"github.com/eclipse/paho.mqtt.golang/packets"
 	"github.com/rs/xid"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
 
@@ -41,20 +44,26 @@ type BrokerP2PNode struct {
 	subNumMap sync.Map
 	nodeIDMap sync.Map
 
+	// Counts the changes of the known brokers in nodeIDMap
+	membership atomic.Uint64
+
 	brokerID *xid.ID
 	nodeID   *cryptographic.ID
 	overlay  *kademlia.Protocol
 
 	actionElementChan    chan topics_p2p.ActionElement
-	forwardPacketChanMap map[string]chan *packets.PublishPacket
+	forwardPacketChanMap map[string]chan forwardPacket
+	forwardLinks         map[string]*forwardLink
 
 	// After the publish-packet is processed for this broker, it will be submitted to this channel
 	// and waits for the subsequent forwarding confirmation process.
 	candidateForwardConfirmChan chan *packets.PublishPacket
 	packetForwardMetrics        *PacketForwardMetrics
 
-	deliverForwardPacketsToTargetNode func(string, string, []packets.PublishPacket)
+	deliverForwardPacketsToTargetNode func(string, ForwardBatch)
 	deliverTopicActionsToPeerNodes    func(*Broker, []topics_p2p.ActionElement)
+	deliverRetainedEntries            func(*Broker, string, []retaincrdt.Entry)
+	deliverSessionHandover            func(*Broker, SessionHandover) int
 }
 
 func NewBrokerP2PNode() *BrokerP2PNode {
@@ -67,7 +76,8 @@ func NewBrokerP2PNode() *BrokerP2PNode {
 		nodeID:                            nil,
 		overlay:                           nil,
 		actionElementChan:                 make(chan topics_p2p.ActionElement, defaultActionElementChanSize),
-		forwardPacketChanMap:              make(map[string]chan *packets.PublishPacket),
+		forwardPacketChanMap:              make(map[string]chan forwardPacket),
+		forwardLinks:                      make(map[string]*forwardLink),
 		candidateForwardConfirmChan:       make(chan *packets.PublishPacket, defaultCandidateForwardConfirmChanSize),
 		packetForwardMetrics:              &PacketForwardMetrics{},
 		deliverForwardPacketsToTargetNode: nil,
@@ -120,6 +130,9 @@ func (b *BrokerP2PNode) NodeIdAddrStoreToMap(brokerIDStr string, nodeIdAddr stri
 		}
 	}
 	b.nodeIDMap.Store(brokerIDStr, nodeIdAddr)
+	if !exist {
+		b.membership.Inc()
+	}
 	return nil
 }
 
@@ -139,6 +152,26 @@ func (b *BrokerP2PNode) NodeIdAddrGetFromMap(brokerIDStr string) string {
 	return "ERROR"
 }
 
+// NodeIdAddrRemoveFromMap removes the brokers of the node address, and returns their broker ids.
+func (b *BrokerP2PNode) NodeIdAddrRemoveFromMap(nodeIdAddr string) []string {
+	brokerIDs := make([]string, 0, 1)
+	b.nodeIDMap.Range(func(ki, vi interface{}) bool {
+		k, _ := ki.(string)
+		v, _ := vi.(string)
+		if v == nodeIdAddr && k != b.brokerID.String() {
+			brokerIDs = append(brokerIDs, k)
+		}
+		return true
+	})
+	for _, brokerIDStr := range brokerIDs {
+		b.nodeIDMap.Delete(brokerIDStr)
+	}
+	if len(brokerIDs) > 0 {
+		b.membership.Inc()
+	}
+	return brokerIDs
+}
+
 func (b *BrokerP2PNode) ProcessSubNumMapForAdd(topic string) {
 	var exist bool
 	var old interface{}
@@ -170,7 +203,7 @@ func (b *BrokerP2PNode) ProcessSubNumMapForDel(topic string) {
 	}
 }
 
-func (b *BrokerP2PNode) RegisterDeliverForwardPacketsToTargetNode(f func(string, string, []packets.PublishPacket)) {
+func (b *BrokerP2PNode) RegisterDeliverForwardPacketsToTargetNode(f func(string, ForwardBatch)) {
 	b.deliverForwardPacketsToTargetNode = f
 }
 
@@ -178,6 +211,18 @@ func (b *BrokerP2PNode) RegisterDeliverTopicActionsToPeerNodes(f func(*Broker, [
 	b.deliverTopicActionsToPeerNodes = f
 }
 
+// RegisterDeliverRetainedEntries sets the function sending the retained entries to a peer node,
+// or to all the peer nodes if the address is empty.
+func (b *BrokerP2PNode) RegisterDeliverRetainedEntries(f func(*Broker, string, []retaincrdt.Entry)) {
+	b.deliverRetainedEntries = f
+}
+
+// RegisterDeliverSessionHandover sets the function sending the session handed over to all the
+// peer nodes, it returns how many received it.
+func (b *BrokerP2PNode) RegisterDeliverSessionHandover(f func(*Broker, SessionHandover) int) {
+	b.deliverSessionHandover = f
+}
+
 // **********************
 // Still for broker ...
 
@@ -229,6 +274,19 @@ func (b *Broker) ExistedTopicActions() []topics_p2p.ActionElement {
 	return aeList
 }
 
+// ForgetPeerNode removes the brokers of an evicted peer node and the topics they subscribed to,
+// the packets are no longer forwarded to them. They are learnt again if the node comes back.
+func (b *Broker) ForgetPeerNode(nodeIdAddr string) {
+	for _, brokerIDStr := range b.brokerNode.NodeIdAddrRemoveFromMap(nodeIdAddr) {
+		removed := b.topicsManager4P2P.RemoveBroker4P2P(brokerIDStr)
+		b.subsystemLogger(LogPeer).Info("core_module/broker_extension/ForgetPeerNode: the topics of the peer broker are removed, ",
+			logging.Peer(brokerIDStr),
+			zap.String("NodeIDAddress", nodeIdAddr),
+			zap.Int("Topics", removed),
+		)
+	}
+}
+
 // This will be called by StartListening
 func (b *Broker) startProcessActionElementListTask() {
 	go func() {
@@ -267,6 +325,24 @@ func (b *Broker) startCandidateForwardConfirmTask() {
 	go func() {
 		for pkt := range b.brokerNode.candidateForwardConfirmChan {
 			b.brokerNode.packetForwardMetrics.increasingNumOfCandidate()
+			trace := b.forwardTrace(pkt)
+
+			if b.LocalTopic(pkt.TopicName) {
+				continue
+			}
+			// the peers get the payload as the plugins rewrite it, sealed for instance
+			if pkt = b.pluginForward(pkt); pkt == nil {
+				continue
+			}
+
+			// a command topic goes to its owner only
+			if owner, ok := b.commandOwner(pkt.TopicName); ok {
+				if owner != b.BrokerID().String() {
+					b.processForwardPacket(owner, forwardPacket{packet: pkt, trace: trace})
+					b.brokerNode.packetForwardMetrics.increasingNumOfForwarding()
+				}
+				continue
+			}
 
 			var brokerIdStrList []interface{}
 			b.brokerNode.mu.Lock()
@@ -280,7 +356,7 @@ func (b *Broker) startCandidateForwardConfirmTask() {
 					for _, broker := range brokerIdStrList {
 						brokerIdStr, ok := broker.(string)
 						if ok && len(brokerIdStr) > 0 {
-							b.processForwardPacket(brokerIdStr, pkt)
+							b.processForwardPacket(brokerIdStr, forwardPacket{packet: pkt, trace: trace})
 						}
 					}
 					b.brokerNode.packetForwardMetrics.increasingNumOfForwarding()
@@ -290,16 +366,23 @@ func (b *Broker) startCandidateForwardConfirmTask() {
 	}()
 }
 
-func (b *Broker) processForwardPacket(targetBrokerIdStr string, pkt *packets.PublishPacket) {
+func (b *Broker) processForwardPacket(targetBrokerIdStr string, pkt forwardPacket) {
+	if b.FaultPartitioned(targetBrokerIdStr) {
+		b.brokerNode.packetForwardMetrics.increasingNumOfForwardDropped(1)
+		return
+	}
+
 	var forwardMessageChan, exist = b.brokerNode.forwardPacketChanMap[targetBrokerIdStr]
 	if !exist {
-		forwardMessageChan = make(chan *packets.PublishPacket, defaultForwardPacketChanSize)
+		forwardMessageChan = make(chan forwardPacket, defaultForwardPacketChanSize)
+		link := newForwardLink()
 
 		b.brokerNode.mu.Lock()
 		b.brokerNode.forwardPacketChanMap[targetBrokerIdStr] = forwardMessageChan
+		b.brokerNode.forwardLinks[targetBrokerIdStr] = link
 		b.brokerNode.mu.Unlock()
 
-		b.startProcessForwardMessageTask(targetBrokerIdStr, forwardMessageChan)
+		b.startProcessForwardMessageTask(targetBrokerIdStr, forwardMessageChan, link)
 
 		//wait for the task done.
 		time.Sleep(500 * time.Millisecond)
@@ -310,55 +393,100 @@ func (b *Broker) processForwardPacket(targetBrokerIdStr string, pkt *packets.Pub
 }
 
 // This will be called by processForwardMessage
-func (b *Broker) startProcessForwardMessageTask(targetBrokerIdStr string, fmChan chan *packets.PublishPacket) {
+// The packets are sent in batches as long as the target broker has credits, otherwise they wait
+// here until the credits are granted back, the oldest are dropped beyond the pending limit.
+func (b *Broker) startProcessForwardMessageTask(targetBrokerIdStr string, fmChan chan forwardPacket, link *forwardLink) {
 	var targetNodeIdAddr = b.brokerNode.NodeIdAddrGetFromMap(targetBrokerIdStr)
+	log := b.subsystemLogger(LogPeer).With(logging.Peer(targetBrokerIdStr))
 	go func() {
+		ticker := time.NewTicker(defaultForwardPacketListAcceptTimeInterval * time.Millisecond)
+		defer ticker.Stop()
+
 		lastTime := time.Now()
 		lastTime4Notification := time.Now()
 
 		pkList := make([]packets.PublishPacket, 0, defaultForwardPacketListCapacity)
+		traces := make([]string, 0, defaultForwardPacketListCapacity)
 		infoList := make([]string, 0, defaultInfoListCapacity)
 		var info string
-		for pkt := range fmChan {
-			pkList = append(pkList, *pkt)
-			b.logger.Debug("Received the forward message",
-				zap.String("topic", pkt.TopicName),
-				zap.Int("payload size", len(pkt.Payload)),
-				zap.String("target broker id", targetBrokerIdStr),
-				zap.String("target node id address", targetNodeIdAddr),
-			)
-
-			if time.Since(lastTime).Milliseconds() > defaultForwardPacketListAcceptTimeInterval || len(pkList) >= defaultForwardPacketListCapacity {
-				if len(targetNodeIdAddr) < 1 || targetNodeIdAddr == "ERROR" {
-					targetNodeIdAddr = b.brokerNode.NodeIdAddrGetFromMap(targetBrokerIdStr)
-					time.Sleep(50 * time.Millisecond)
+		for {
+			select {
+			case fp, ok := <-fmChan:
+				if !ok {
+					return
 				}
-				if len(targetNodeIdAddr) > 0 && targetNodeIdAddr != "ERROR" {
-					b.brokerNode.deliverForwardPacketsToTargetNode(targetNodeIdAddr, targetBrokerIdStr, pkList)
-					b.brokerNode.packetForwardMetrics.increasingNumOfForwardParcelOverP2P()
+				pkList = append(pkList, *fp.packet)
+				traces = append(traces, fp.trace)
+				log.Debug("Received the forward message",
+					logging.Topic(fp.packet.TopicName),
+					zap.Int("payload size", len(fp.packet.Payload)),
+					zap.String("target node id address", targetNodeIdAddr),
+				)
 
-					info = fmt.Sprintf(`{"amount":%d,"interval":"%s"}`,
-						len(pkList),
-						humanize.SI(time.Since(lastTime).Seconds(), "s"),
-					)
-
-					pkList = make([]packets.PublishPacket, 0, defaultForwardPacketListCapacity)
-					lastTime = time.Now()
-				} else {
-					info = fmt.Sprintf(`{"waiting_amount":%d,"waiting_interval":"%s"}`,
-						len(pkList),
-						humanize.SI(time.Since(lastTime).Seconds(), "s"),
-					)
-					//Todo close the channel of the target_broker_id
+				if drop := len(pkList) - defaultForwardPendingLimit; drop > 0 {
+					pkList = append(pkList[:0], pkList[drop:]...)
+					traces = append(traces[:0], traces[drop:]...)
+					b.brokerNode.packetForwardMetrics.increasingNumOfForwardDropped(uint64(drop))
 				}
-				infoList = append(infoList, info)
-				b.logger.Debug("Deliver Forward Packets To Target Node ", zap.String("metrics", info))
 
-				if time.Since(lastTime4Notification).Seconds() > defaultForwardPacketsNotificationTimeInterval {
-					b.ForwardPacketsMetricsNotification(b.BrokerID().String(), targetBrokerIdStr, infoList)
-					infoList = make([]string, 0, defaultInfoListCapacity)
-					lastTime4Notification = time.Now()
+				if time.Since(lastTime).Milliseconds() <= defaultForwardPacketListAcceptTimeInterval && forwardBatchSize(pkList) == len(pkList) {
+					continue
 				}
+			case <-ticker.C:
+			case <-link.granted:
+			}
+
+			if len(pkList) == 0 {
+				continue
+			}
+
+			if len(targetNodeIdAddr) < 1 || targetNodeIdAddr == "ERROR" {
+				targetNodeIdAddr = b.brokerNode.NodeIdAddrGetFromMap(targetBrokerIdStr)
+			}
+			if len(targetNodeIdAddr) > 0 && targetNodeIdAddr != "ERROR" {
+				amount := 0
+				for len(pkList) > 0 {
+					seq, ok := link.acquire(b.clock.Now())
+					if !ok {
+						b.brokerNode.packetForwardMetrics.increasingNumOfForwardCreditExhausted()
+						break
+					}
+
+					n := forwardBatchSize(pkList)
+					b.brokerNode.deliverForwardPacketsToTargetNode(targetNodeIdAddr, ForwardBatch{
+						SourceBrokerId: b.BrokerID().String(),
+						TargetBrokerId: targetBrokerIdStr,
+						Seq:            seq,
+						PacketList:     pkList[:n],
+						Traces:         forwardBatchTraces(traces[:n]),
+					})
+					b.brokerNode.packetForwardMetrics.increasingNumOfForwardParcelOverP2P()
+
+					amount += n
+					pkList = append(make([]packets.PublishPacket, 0, defaultForwardPacketListCapacity), pkList[n:]...)
+					traces = append(make([]string, 0, defaultForwardPacketListCapacity), traces[n:]...)
+				}
+
+				info = fmt.Sprintf(`{"amount":%d,"waiting_amount":%d,"interval":"%s"}`,
+					amount,
+					len(pkList),
+					humanize.SI(time.Since(lastTime).Seconds(), "s"),
+				)
+				lastTime = time.Now()
+			} else {
+				info = fmt.Sprintf(`{"waiting_amount":%d,"waiting_interval":"%s"}`,
+					len(pkList),
+					humanize.SI(time.Since(lastTime).Seconds(), "s"),
+				)
+				//Todo close the channel of the target_broker_id
+			}
+			infoList = append(infoList, info)
+			log.Debug("Deliver Forward Packets To Target Node ", zap.String("metrics", info))
+
+			if time.Since(lastTime4Notification).Seconds() > defaultForwardPacketsNotificationTimeInterval {
+				b.ForwardPacketsMetricsNotification(b.BrokerID().String(), targetBrokerIdStr, infoList)
+				infoList = make([]string, 0, defaultInfoListCapacity)
+				lastTime4Notification = time.Now()
 			}
 		}
 	}()
@@ -0,0 +1,65 @@
+package broker_core_module
+
+import (
+	"errors"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/fanout"
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// sharedDelivery returns the encoding of the publish shared by all its subscribers, nil if there is
+// only one.
+func sharedDelivery(packet *packets.PublishPacket, subscribers int) *fanout.Message {
+	if subscribers < 2 {
+		return nil
+	}
+	return fanout.NewMessage(packet.TopicName, packet.Payload)
+}
+
+// writePublish writes the publish with the shared encoding of its message if it still carries it.
+// A publish without 5.0 fields is encoded on its own the same way otherwise, its payload is written
+// from where it is instead of being copied by the encoder.
+func (c *client) writePublish(packet *packets.PublishPacket, ext *mqtt5.Packet, shared *fanout.Message) error {
+	if ext != nil {
+		return c.writePacket(packet, ext)
+	}
+
+	if c.status.Load() == Disconnected {
+		return nil
+	}
+	if c.conn == nil {
+		c.Close()
+		return errors.New("core_module/broker_fanout/writePublish: connection lost")
+	}
+
+	if c.broker != nil {
+		defer c.broker.stageLatency.since(StageWrite, time.Now())
+		c.faultSlowWrite()
+	}
+
+	delivery := c.namespaceDelivery(packet)
+	if shared == nil || !shared.Shares(delivery.TopicName, delivery.Payload) {
+		shared = fanout.NewMessage(delivery.TopicName, delivery.Payload)
+	}
+	h := fanout.Header{
+		Qos:       delivery.Qos,
+		Retain:    delivery.Retain,
+		Dup:       delivery.Dup,
+		MessageID: delivery.MessageID,
+		V5:        c.isV5(),
+	}
+	c.mu.Lock()
+	n := shared.WriteBuffered(&c.out, h)
+	err := c.flushOut()
+	c.mu.Unlock()
+
+	if err == nil && c.broker != nil {
+		c.broker.sysStats.Sent(n, true)
+		c.logPacket(delivery, false)
+		c.capturePacket(delivery, nil, false)
+	}
+	return err
+}
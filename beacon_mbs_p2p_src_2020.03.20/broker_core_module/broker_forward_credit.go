@@ -0,0 +1,124 @@
+package broker_core_module
+
+import (
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+const (
+	defaultForwardCredits       = 8                // batches in flight per peer broker
+	defaultForwardCreditTimeout = 10 * time.Second // restore the credits if the peer grants nothing back
+	defaultForwardPendingLimit  = 4096             // packets waiting for credits, the oldest are dropped beyond it
+	defaultForwardBatchMaxBytes = 256 * 1024
+)
+
+// ForwardBatch is a framed batch of the packets forwarded to a peer broker. The peer grants one
+// credit back to the source broker for each batch it has processed.
+type ForwardBatch struct {
+	SourceBrokerId string
+	TargetBrokerId string
+	Seq            uint64
+	PacketList     []packets.PublishPacket
+	// Traces are the traceparents of the packets, empty for the untraced ones, nil if none is
+	Traces []string
+}
+
+// forwardPacket is a packet waiting to be forwarded to a peer broker, with its traceparent.
+type forwardPacket struct {
+	packet *packets.PublishPacket
+	trace  string
+}
+
+// forwardBatchTraces returns the traceparents of the packets of the batch, nil if none is traced.
+func forwardBatchTraces(traces []string) []string {
+	for _, t := range traces {
+		if len(t) > 0 {
+			return append([]string(nil), traces...)
+		}
+	}
+	return nil
+}
+
+// forwardLink is the credit-based flow control of the batches forwarded to a peer broker, a slow
+// peer runs out of credits, and the packets wait in the forward task (bounded) instead of piling up
+// in the parcel queue shared by all the peers.
+type forwardLink struct {
+	mu        sync.Mutex
+	credits   int
+	seq       uint64
+	waitSince time.Time
+	granted   chan struct{}
+}
+
+func newForwardLink() *forwardLink {
+	return &forwardLink{
+		credits: defaultForwardCredits,
+		granted: make(chan struct{}, 1),
+	}
+}
+
+// acquire takes a credit for the next batch, and returns its sequence number. The peers of the
+// previous versions never grant credits, so the credits are restored after waiting for a while.
+func (l *forwardLink) acquire(now time.Time) (uint64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.credits == 0 {
+		if now.Sub(l.waitSince) < defaultForwardCreditTimeout {
+			return 0, false
+		}
+		l.credits = defaultForwardCredits
+	}
+
+	l.credits--
+	if l.credits == 0 {
+		l.waitSince = now
+	}
+	l.seq++
+
+	return l.seq, true
+}
+
+func (l *forwardLink) grant(credits int) {
+	l.mu.Lock()
+	l.credits += credits
+	if l.credits > defaultForwardCredits {
+		l.credits = defaultForwardCredits
+	}
+	l.mu.Unlock()
+
+	select {
+	case l.granted <- struct{}{}:
+	default:
+	}
+}
+
+// GrantForwardCredit is called when the peer broker granted credits back for the batches it
+// has processed.
+func (b *BrokerP2PNode) GrantForwardCredit(targetBrokerIdStr string, credits int) {
+	if credits < 1 {
+		return
+	}
+
+	b.mu.Lock()
+	link, exist := b.forwardLinks[targetBrokerIdStr]
+	b.mu.Unlock()
+
+	if exist {
+		link.grant(credits)
+	}
+}
+
+// Returns the number of the packets at the head of the list fitting in one batch.
+func forwardBatchSize(pkList []packets.PublishPacket) int {
+	size := 0
+	for i := range pkList {
+		size += len(pkList[i].Payload)
+		if i > 0 && (i >= defaultForwardPacketListCapacity || size > defaultForwardBatchMaxBytes) {
+			return i
+		}
+	}
+	return len(pkList)
+}
@@ -0,0 +1,103 @@
+package broker_core_module
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardLink(t *testing.T) {
+	l := newForwardLink()
+	now := time.Now()
+	for i := 1; i <= defaultForwardCredits; i++ {
+		seq, ok := l.acquire(now)
+		require.True(t, ok)
+		require.Equal(t, uint64(i), seq)
+	}
+	_, ok := l.acquire(now.Add(time.Second))
+	require.False(t, ok)
+
+	// a grant wakes the forward task up, the credits never exceed the window
+	l.grant(2 * defaultForwardCredits)
+	require.Len(t, l.granted, 1)
+	require.Equal(t, defaultForwardCredits, l.credits)
+
+	// a peer granting nothing back gets its credits again after the timeout
+	for i := 0; i < defaultForwardCredits; i++ {
+		_, ok = l.acquire(now)
+		require.True(t, ok)
+	}
+	_, ok = l.acquire(now.Add(defaultForwardCreditTimeout - time.Second))
+	require.False(t, ok)
+	seq, ok := l.acquire(now.Add(defaultForwardCreditTimeout))
+	require.True(t, ok)
+	require.Equal(t, uint64(2*defaultForwardCredits+1), seq)
+}
+
+func TestForwardBatchSize(t *testing.T) {
+	small := make([]packets.PublishPacket, defaultForwardPacketListCapacity+10)
+	require.Equal(t, defaultForwardPacketListCapacity, forwardBatchSize(small))
+	require.Equal(t, 5, forwardBatchSize(small[:5]))
+
+	// a batch holds one packet at least, even over the byte limit
+	large := make([]packets.PublishPacket, 3)
+	for i := range large {
+		large[i].Payload = make([]byte, defaultForwardBatchMaxBytes)
+	}
+	require.Equal(t, 1, forwardBatchSize(large))
+
+	require.Nil(t, forwardBatchTraces([]string{"", ""}))
+	require.Equal(t, []string{"", "00-t-s-01"}, forwardBatchTraces([]string{"", "00-t-s-01"}))
+}
+
+func TestForwardCredits(t *testing.T) {
+	b := newTestBroker(t)
+	batches := make(chan ForwardBatch, 64)
+	b.BrokerNode().RegisterDeliverForwardPacketsToTargetNode(func(_ string, batch ForwardBatch) {
+		batches <- batch
+	})
+	require.NoError(t, b.BrokerNode().NodeIdAddrStoreToMap("peer", "127.0.0.1:1"))
+
+	// takes the batches sent until the forward task waits for credits
+	receive := func() []ForwardBatch {
+		var list []ForwardBatch
+		for {
+			select {
+			case batch := <-batches:
+				list = append(list, batch)
+			case <-time.After(500 * time.Millisecond):
+				return list
+			}
+		}
+	}
+
+	const sent = 2 * defaultForwardCredits * defaultForwardPacketListCapacity
+	for i := 0; i < sent; i++ {
+		p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		p.TopicName = "t/" + strconv.Itoa(i)
+		b.processForwardPacket("peer", forwardPacket{packet: p})
+	}
+
+	// the peer which grants nothing back gets one window of batches
+	list := receive()
+	require.Len(t, list, defaultForwardCredits)
+	forwarded := 0
+	for i, batch := range list {
+		require.Equal(t, uint64(i+1), batch.Seq)
+		require.Equal(t, "peer", batch.TargetBrokerId)
+		require.Equal(t, b.BrokerID().String(), batch.SourceBrokerId)
+		require.True(t, len(batch.PacketList) <= defaultForwardPacketListCapacity)
+		forwarded += len(batch.PacketList)
+	}
+	require.True(t, forwarded < sent)
+
+	// each credit granted back lets one more batch through, in order
+	b.BrokerNode().GrantForwardCredit("peer", 2)
+	list = receive()
+	require.Len(t, list, 2)
+	require.Equal(t, uint64(defaultForwardCredits+1), list[0].Seq)
+	require.Equal(t, "t/"+strconv.Itoa(forwarded), list[0].PacketList[0].TopicName)
+}
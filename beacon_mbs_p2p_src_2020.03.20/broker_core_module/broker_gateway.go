@@ -0,0 +1,291 @@
+package broker_core_module
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"awesomeProject/beacon/mqtt_network/libs/acl"
+	"awesomeProject/beacon/mqtt_network/libs/delayed"
+	"awesomeProject/beacon/mqtt_network/libs/handoff"
+	"awesomeProject/beacon/mqtt_network/libs/logging"
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+const (
+	handoffGatewayName = "gateway"
+
+	// The client id the ACL and the topic owners check the publishes of the gateway with
+	gatewayClientID = "$gateway"
+
+	// The largest request body read by the gateway, the payload limits of the topics apply too
+	gatewayMaxBody = 1 << 20
+)
+
+var (
+	errNotListening   = errors.New("core_module/broker_gateway/Publish: the broker is not listening")
+	errGatewayDenied  = errors.New("core_module/broker_gateway/Publish: the publish is denied")
+	errGatewayPayload = errors.New("core_module/broker_gateway/Publish: the payload is over the limit of the topic")
+	errGatewayInvalid = errors.New("core_module/broker_gateway/Publish: the payload doesn't match the schema of the topic")
+)
+
+// GatewayConfig serves the HTTP publish gateway on its own listener, so the backends which don't
+// speak MQTT publish with a curl call. The requests carry the token as "Authorization: Bearer
+// <token>", the ACL checks the publishes as the user Username.
+type GatewayConfig struct {
+	// Addr is the host:port of the listener
+	Addr     string
+	Token    string
+	Username string
+}
+
+// GatewayPublish is the body of a POST /publish, the payload is either the text of Payload or the
+// base64 of PayloadBase64.
+type GatewayPublish struct {
+	Topic         string `json:"topic"`
+	Qos           byte   `json:"qos"`
+	Retain        bool   `json:"retain"`
+	Payload       string `json:"payload,omitempty"`
+	PayloadBase64 string `json:"payload_base64,omitempty"`
+}
+
+// Publish publishes the message on behalf of the broker, it goes through the pipeline of the
+// client publishes: forwarded to the peer brokers, retained and delivered to the subscribers. The
+// message is refused until the broker listens, it would be dropped.
+func (b *Broker) Publish(topic string, payload []byte, qos byte, retain bool) error {
+	if err := b.checkPublish(topic, qos); err != nil {
+		return err
+	}
+
+	packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	packet.TopicName = topic
+	packet.Qos = qos
+	packet.Retain = retain
+	packet.Payload = payload
+
+	b.brokerNode.candidateForwardConfirmChan <- packet
+
+	if packet.Retain {
+		if err := b.topicsManager.Retain(packet); err != nil {
+			return err
+		}
+		b.replicateRetainedMessage(packet)
+		b.recordRetained("", packet)
+	}
+	b.logPublish(packet)
+	b.SubmitPublishPacketsWorkTask(packet)
+	return nil
+}
+
+// checkPublish checks the publish of the broker can be published now.
+func (b *Broker) checkPublish(topic string, qos byte) error {
+	if b.readOnly {
+		return errReadOnly
+	}
+	if !b.listening.Load() {
+		return errNotListening
+	}
+	if err := topics.ValidatePublishTopic([]byte(topic)); err != nil {
+		return err
+	}
+	if isSysTopic(topic) {
+		return fmt.Errorf("core_module/broker_gateway/Publish: the $SYS topics are published by the broker only => %s", topic)
+	}
+	if !topics.ValidQos(qos) {
+		return fmt.Errorf("core_module/broker_gateway/Publish: invalid qos %d", qos)
+	}
+	return nil
+}
+
+// gatewayPublish checks the publish of the gateway before publishing it, see publishAs.
+func (b *Broker) gatewayPublish(p GatewayPublish, payload []byte) error {
+	return b.publishAs(b.gatewayConfig.Addr, gatewayClientID, b.gatewayConfig.Username, p.Topic, payload, p.Qos, p.Retain)
+}
+
+// publishAs checks the publish of a gateway like the publish of a client of the listener: the
+// $delayed prefix is taken off, the topic is rewritten and moved to the namespace of the tenant of
+// the user, then it's checked against the topic owners, the ACL, the payload limits and the
+// schemas as the client and the user, before it's published or delayed.
+func (b *Broker) publishAs(listener string, clientID string, username string, topic string, payload []byte, qos byte, retain bool) error {
+	delay, target, isDelayed, err := delayed.ParseTopic(topic)
+	if isDelayed {
+		if err != nil {
+			return err
+		}
+		topic = target
+	}
+	if b.rewrite != nil {
+		if rewritten, ok := b.rewrite.Publish(topic); ok {
+			topic = rewritten
+		}
+	}
+	if b.tenantNamespaces != nil {
+		topic = b.tenantNamespaces.PublishTopic(b.clientNamespace(listener, username), topic)
+	}
+	if err := b.checkPublish(topic, qos); err != nil {
+		return err
+	}
+
+	if isDeadLetterTopic(topic) || !b.topicOwners.CheckPublish(clientID, username, topic) {
+		return errGatewayDenied
+	}
+	if !b.checkACL(clientID, username, acl.Publish, topic) {
+		return errGatewayDenied
+	}
+	if b.payloadLimits != nil {
+		if l, ok := b.payloadLimits.Limit(topic); ok && len(payload) > l.MaxPayload {
+			return errGatewayPayload
+		}
+	}
+	if err := b.schemas.Validate(topic, payload); err != nil {
+		b.logger.Warn("core_module/broker_gateway/publishAs: the payload doesn't match the schema of the topic",
+			logging.ClientID(clientID),
+			logging.Topic(topic),
+			zap.Error(err),
+		)
+		return errGatewayInvalid
+	}
+
+	if delay > 0 {
+		_, err := b.delayed.Add(delayed.Message{
+			Topic:    topic,
+			Payload:  payload,
+			Qos:      qos,
+			Retain:   retain,
+			Due:      b.clock.Now().Add(delay),
+			ClientID: clientID,
+		})
+		return err
+	}
+	return b.Publish(topic, payload, qos, retain)
+}
+
+// startGatewayListener serves the HTTP publish gateway, the listener is handed off to the new
+// process on upgrade like the MQTT listener.
+func (b *Broker) startGatewayListener() error {
+	if b.gatewayConfig == nil {
+		return nil
+	}
+	if len(b.gatewayConfig.Token) == 0 {
+		return errors.New("core_module/broker_gateway/startGatewayListener: the HTTP gateway needs a token")
+	}
+
+	var err error
+	b.gatewayListener, err = handoff.Listen(handoffGatewayName, "tcp", b.gatewayConfig.Addr)
+	if err != nil {
+		return err
+	}
+	b.gatewayServer = &http.Server{Handler: b.gatewayHandler(), ReadHeaderTimeout: adminHeaderTimeout}
+
+	b.logger.Info("Listening for the HTTP gateway.",
+		zap.String("bind_addr", b.gatewayListener.Addr().String()),
+	)
+
+	go func() {
+		err := b.gatewayServer.Serve(b.gatewayListener)
+		if err != nil && err != http.ErrServerClosed && !b.stopped() {
+			b.logger.Error("HTTP gateway serve error on listening", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// gatewayHandler routes the HTTP gateway:
+//
+//	POST /publish             publishes the GatewayPublish of the JSON body
+//	GET  /retained?topic=<t>  the retained message of the topic, the payload base64 encoded
+//
+// For example:
+//
+//	curl -H "Authorization: Bearer $TOKEN" -d '{"topic":"devices/42/cmd","qos":1,"payload":"reboot"}' http://localhost:8081/publish
+func (b *Broker) gatewayHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/publish", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, gatewayMaxBody+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(body) > gatewayMaxBody {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		var p GatewayPublish
+		if err := json.Unmarshal(body, &p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		payload := []byte(p.Payload)
+		if len(p.PayloadBase64) > 0 {
+			if payload, err = base64.StdEncoding.DecodeString(p.PayloadBase64); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		switch err := b.gatewayPublish(p, payload); err {
+		case nil:
+			w.WriteHeader(http.StatusNoContent)
+		case errGatewayDenied:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errGatewayPayload:
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		case errGatewayInvalid:
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		case errReadOnly, errNotListening:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		case delayed.ErrFull:
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+	mux.HandleFunc("/retained", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		topic := r.URL.Query().Get("topic")
+		if err := topics.ValidatePublishTopic([]byte(topic)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		username := b.gatewayConfig.Username
+		if !b.checkACL(gatewayClientID, username, acl.Subscribe, topic) {
+			http.Error(w, errGatewayDenied.Error(), http.StatusForbidden)
+			return
+		}
+		retainedList, err := b.Retained(topic)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(retainedList) == 0 {
+			http.Error(w, "no retained message", http.StatusNotFound)
+			return
+		}
+		rm := retainedList[0]
+		writeJSON(w, AdminRetained{Topic: rm.TopicName, Qos: rm.Qos, Payload: rm.Payload})
+	})
+
+	token := []byte("Bearer " + b.gatewayConfig.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), token) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
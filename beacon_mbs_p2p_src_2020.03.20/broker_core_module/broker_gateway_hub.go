@@ -0,0 +1,181 @@
+package broker_core_module
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// GatewaySink is an end-user session of a gateway (such as a browser tab of the SSE or WebSocket
+// gateway), it receives the packets fanned out by the hub instead of owning a subscription in the
+// topics provider.
+type GatewaySink interface {
+	ID() string
+	Deliver(packet *packets.PublishPacket) error
+}
+
+// All the sinks subscribing the same filter share one hubSubscription, which is the only
+// subscriber of the filter registered to the topics provider.
+type hubSubscription struct {
+	broker *Broker
+	mu     sync.RWMutex
+	filter string
+	qos    byte
+	sinks  map[string]GatewaySink
+}
+
+type gatewayHub struct {
+	mu            sync.Mutex
+	subscriptions map[string]*hubSubscription
+}
+
+func newGatewayHub() *gatewayHub {
+	return &gatewayHub{
+		subscriptions: make(map[string]*hubSubscription),
+	}
+}
+
+func (h *hubSubscription) deliver(b *Broker, packet *packets.PublishPacket) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for id, sink := range h.sinks {
+		if err := sink.Deliver(packet); err != nil {
+			b.logger.Error("core_module/broker_gateway_hub/deliver: Error deliver to gateway sink => ",
+				zap.Error(err),
+				zap.String("filter", h.filter),
+				zap.String("SinkID", id),
+			)
+		}
+	}
+}
+
+// ID keys the hub subscription in the set of its filter, there's one by filter.
+func (h *hubSubscription) ID() string {
+	return internalID("gateway", h.filter)
+}
+
+func (h *hubSubscription) OnPublish(message *packets.PublishPacket) error {
+	h.deliver(h.broker, message)
+	return nil
+}
+
+func (h *hubSubscription) size() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.sinks)
+}
+
+// GatewaySubscribe attaches the sink to the shared subscription of the filter, the subscription is
+// created in the topics provider for the first sink only. The retained messages of the filter are
+// delivered to this sink only.
+func (b *Broker) GatewaySubscribe(filter string, qos byte, sink GatewaySink) (byte, error) {
+	if sink == nil {
+		return QosFailure, errors.New("core_module/broker_gateway_hub/GatewaySubscribe: sink cannot be nil")
+	}
+
+	h := b.gatewayHub
+	h.mu.Lock()
+	hs, exist := h.subscriptions[filter]
+	if !exist || qos > hs.qos {
+		if !exist {
+			hs = &hubSubscription{broker: b, filter: filter, sinks: make(map[string]GatewaySink)}
+		}
+		returnQos, err := b.topicsManager.Subscribe([]byte(filter), qos, hs)
+		if err != nil {
+			h.mu.Unlock()
+			return QosFailure, err
+		}
+		hs.qos = returnQos
+		if !exist {
+			h.subscriptions[filter] = hs
+			b.brokerNode.ProcessSubNumMapForAdd(filter)
+		}
+	}
+
+	hs.mu.Lock()
+	hs.sinks[sink.ID()] = sink
+	hs.mu.Unlock()
+	h.mu.Unlock()
+
+	var retainedList []*packets.PublishPacket
+	_ = b.topicsManager.Retained([]byte(filter), &retainedList)
+	for _, rm := range retainedList {
+		if err := sink.Deliver(b.retainedDeliveryPacket(rm, qos)); err != nil {
+			b.logger.Error("core_module/broker_gateway_hub/GatewaySubscribe: publishing retained message error, ",
+				zap.Error(err),
+				zap.String("SinkID", sink.ID()),
+			)
+		}
+	}
+
+	return qos, nil
+}
+
+// GatewayUnsubscribe detaches the sink, the subscription is removed from the topics provider
+// after the last sink left.
+func (b *Broker) GatewayUnsubscribe(filter string, sink GatewaySink) error {
+	if sink == nil {
+		return errors.New("core_module/broker_gateway_hub/GatewayUnsubscribe: sink cannot be nil")
+	}
+
+	h := b.gatewayHub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hs, exist := h.subscriptions[filter]
+	if !exist {
+		return errors.New("core_module/broker_gateway_hub/GatewayUnsubscribe: No subscription found for filter " + filter)
+	}
+
+	hs.mu.Lock()
+	delete(hs.sinks, sink.ID())
+	hs.mu.Unlock()
+
+	if hs.size() > 0 {
+		return nil
+	}
+
+	delete(h.subscriptions, filter)
+	b.brokerNode.ProcessSubNumMapForDel(filter)
+
+	return b.topicsManager.Unsubscribe([]byte(filter), hs)
+}
+
+// GatewayUnsubscribeAll detaches the sink from all the filters, it's called when the end-user
+// session of the gateway is closed.
+func (b *Broker) GatewayUnsubscribeAll(sink GatewaySink) {
+	if sink == nil {
+		return
+	}
+
+	var filterList []string
+	b.gatewayHub.mu.Lock()
+	for filter, hs := range b.gatewayHub.subscriptions {
+		hs.mu.RLock()
+		if _, ok := hs.sinks[sink.ID()]; ok {
+			filterList = append(filterList, filter)
+		}
+		hs.mu.RUnlock()
+	}
+	b.gatewayHub.mu.Unlock()
+
+	for _, filter := range filterList {
+		_ = b.GatewayUnsubscribe(filter, sink)
+	}
+}
+
+// GatewaySubscriptions returns the number of sinks per shared filter.
+func (b *Broker) GatewaySubscriptions() map[string]int {
+	b.gatewayHub.mu.Lock()
+	defer b.gatewayHub.mu.Unlock()
+
+	m := make(map[string]int, len(b.gatewayHub.subscriptions))
+	for filter, hs := range b.gatewayHub.subscriptions {
+		m[filter] = hs.size()
+	}
+	return m
+}
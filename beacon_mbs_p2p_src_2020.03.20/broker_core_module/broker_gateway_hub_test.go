@@ -0,0 +1,99 @@
+package broker_core_module
+
+import (
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+)
+
+// testSink is a gateway session collecting the packets fanned out to it.
+type testSink struct {
+	id      string
+	packets chan *packets.PublishPacket
+}
+
+func newTestSink(id string) *testSink {
+	return &testSink{id: id, packets: make(chan *packets.PublishPacket, 16)}
+}
+
+func (s *testSink) ID() string {
+	return s.id
+}
+
+func (s *testSink) Deliver(packet *packets.PublishPacket) error {
+	s.packets <- packet
+	return nil
+}
+
+func (s *testSink) expectPublish(t *testing.T) *packets.PublishPacket {
+	t.Helper()
+
+	select {
+	case p := <-s.packets:
+		return p
+	case <-time.After(testReadTimeout):
+		t.Fatalf("the sink %s got no publish", s.id)
+		return nil
+	}
+}
+
+func (s *testSink) expectNothing(t *testing.T) {
+	t.Helper()
+
+	select {
+	case p := <-s.packets:
+		t.Fatalf("unexpected publish %v to the sink %s", p, s.id)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// subscriberCount returns the subscribers of the topic in the topics provider of the broker.
+func subscriberCount(t *testing.T, b *Broker, topic string) int {
+	t.Helper()
+
+	var subs []topics.Subscriber
+	var qoss []byte
+	require.NoError(t, b.topicsManager.Subscribers([]byte(topic), 1, &subs, &qoss))
+	return len(subs)
+}
+
+func TestGatewayHub(t *testing.T) {
+	b := newTestBroker(t)
+	pub := connectTestClient(t, b, "publisher", "", false)
+	pub.publish("news/a", "retained", 1, true)
+
+	// each sink gets the retained messages when it joins, the hub subscribes the filter once
+	tab1, tab2 := newTestSink("tab1"), newTestSink("tab2")
+	_, err := b.GatewaySubscribe("news/#", 1, tab1)
+	require.NoError(t, err)
+	require.Equal(t, "news/a", tab1.expectPublish(t).TopicName)
+	_, err = b.GatewaySubscribe("news/#", 0, tab2)
+	require.NoError(t, err)
+	require.Equal(t, "news/a", tab2.expectPublish(t).TopicName)
+	tab1.expectNothing(t)
+	require.Equal(t, map[string]int{"news/#": 2}, b.GatewaySubscriptions())
+	require.Equal(t, 1, subscriberCount(t, b, "news/b"))
+
+	pub.publish("news/b", "1", 1, false)
+	require.Equal(t, []byte("1"), tab1.expectPublish(t).Payload)
+	require.Equal(t, []byte("1"), tab2.expectPublish(t).Payload)
+	tab1.expectNothing(t)
+
+	// the subscription stays until the last sink leaves
+	require.NoError(t, b.GatewayUnsubscribe("news/#", tab1))
+	pub.publish("news/b", "2", 1, false)
+	require.Equal(t, []byte("2"), tab2.expectPublish(t).Payload)
+	tab1.expectNothing(t)
+
+	b.GatewayUnsubscribeAll(tab2)
+	require.Empty(t, b.GatewaySubscriptions())
+	require.Equal(t, 0, subscriberCount(t, b, "news/b"))
+	require.Error(t, b.GatewayUnsubscribe("news/#", tab2))
+
+	_, err = b.GatewaySubscribe("news/#", 0, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,176 @@
+package broker_core_module
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"awesomeProject/beacon/mqtt_network/libs/acl"
+	"awesomeProject/beacon/mqtt_network/libs/namespace"
+	"awesomeProject/beacon/mqtt_network/libs/quota"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testGatewayToken = "secret"
+
+func gatewayRequest(t *testing.T, h http.Handler, method string, target string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+testGatewayToken)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestGatewayPublish(t *testing.T) {
+	aclFile := writeTestFile(t, "acl.yaml", `
+rules:
+  - action: allow
+    username: backend
+    access: publish
+    topics: ["telemetry/#", "devices/#", "sensors/#"]
+  - action: allow
+    username: backend
+    access: subscribe
+    topics: ["telemetry/#"]
+  - action: allow
+    username: dashboard
+    topics: ["#"]
+`)
+	rewriteFile := writeTestFile(t, "rewrite.yaml", `
+rules:
+  - from: legacy/+id/temp
+    to: telemetry/$id
+`)
+	b := newTestBroker(t,
+		WithHTTPGateway(GatewayConfig{Addr: "127.0.0.1:0", Token: testGatewayToken, Username: "backend"}),
+		WithACLFile(aclFile),
+		WithTopicRewrite(rewriteFile),
+		WithPayloadLimits(quota.PayloadLimit{Filter: "telemetry/#", MaxPayload: 8}),
+		WithTopicClaims(acl.Claim{Filter: "devices/+/config", Owner: "config-service", Usernames: []string{"config"}}),
+	)
+	h := b.gatewayHandler()
+
+	sub := connectTestClient(t, b, "dashboard", "dashboard", false)
+	require.Equal(t, byte(1), sub.subscribe("telemetry/#", 1))
+
+	w := gatewayRequest(t, h, http.MethodPost, "/publish", `{"topic":"telemetry/d1","qos":1,"payload":"21.5"}`)
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	p := sub.expectPublish()
+	require.Equal(t, "telemetry/d1", p.TopicName)
+	require.Equal(t, []byte("21.5"), p.Payload)
+
+	// the publish is counted once by the filter preview
+	known, err := b.ExpandFilter("telemetry/#")
+	require.NoError(t, err)
+	require.Len(t, known, 1)
+	require.Equal(t, uint64(1), known[0].Publishes)
+
+	// the topic is rewritten like the one of a client publish
+	w = gatewayRequest(t, h, http.MethodPost, "/publish", `{"topic":"legacy/d2/temp","payload_base64":"MjI="}`)
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	p = sub.expectPublish()
+	require.Equal(t, "telemetry/d2", p.TopicName)
+	require.Equal(t, []byte("22"), p.Payload)
+
+	for _, tc := range []struct {
+		body string
+		code int
+	}{
+		{`{"topic":"other/d1","payload":"1"}`, http.StatusForbidden},
+		{`{"topic":"devices/d1/config","payload":"{}"}`, http.StatusForbidden},
+		{`{"topic":"$deadletter/denied/telemetry/d1","payload":"1"}`, http.StatusForbidden},
+		{`{"topic":"telemetry/d1","payload":"123456789"}`, http.StatusRequestEntityTooLarge},
+		{`{"topic":"$SYS/broker/uptime","payload":"1"}`, http.StatusBadRequest},
+		{`{"topic":"telemetry/+","payload":"1"}`, http.StatusBadRequest},
+		{`{"topic":"$delayed/x/telemetry/d1","payload":"1"}`, http.StatusBadRequest},
+		{`{"topic":`, http.StatusBadRequest},
+	} {
+		w := gatewayRequest(t, h, http.MethodPost, "/publish", tc.body)
+		require.Equal(t, tc.code, w.Code, tc.body)
+	}
+	sub.expectNothing()
+
+	w = gatewayRequest(t, h, http.MethodGet, "/publish", "")
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	r := httptest.NewRequest(http.MethodPost, "/publish", strings.NewReader(`{"topic":"telemetry/d1","payload":"1"}`))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// the delayed publish is queued for its target topic
+	w = gatewayRequest(t, h, http.MethodPost, "/publish", `{"topic":"$delayed/60/telemetry/d3","payload":"23"}`)
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	queued := b.DelayedPublishes()
+	require.Len(t, queued, 1)
+	require.Equal(t, "telemetry/d3", queued[0].Topic)
+	require.Equal(t, gatewayClientID, queued[0].ClientID)
+	sub.expectNothing()
+
+	// nothing is published once the broker stops listening
+	require.NoError(t, b.Shutdown(0))
+	w = gatewayRequest(t, h, http.MethodPost, "/publish", `{"topic":"telemetry/d1","payload":"1"}`)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestGatewayRetained(t *testing.T) {
+	aclFile := writeTestFile(t, "acl.yaml", `
+rules:
+  - action: allow
+    username: backend
+    topics: ["sensors/#"]
+`)
+	b := newTestBroker(t,
+		WithHTTPGateway(GatewayConfig{Addr: "127.0.0.1:0", Token: testGatewayToken, Username: "backend"}),
+		WithACLFile(aclFile),
+	)
+	h := b.gatewayHandler()
+
+	w := gatewayRequest(t, h, http.MethodPost, "/publish", `{"topic":"sensors/s1","qos":1,"retain":true,"payload":"on"}`)
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+
+	w = gatewayRequest(t, h, http.MethodGet, "/retained?topic=sensors/s1", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var rm AdminRetained
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rm))
+	require.Equal(t, AdminRetained{Topic: "sensors/s1", Qos: 1, Payload: []byte("on")}, rm)
+
+	w = gatewayRequest(t, h, http.MethodGet, "/retained?topic=sensors/s2", "")
+	require.Equal(t, http.StatusNotFound, w.Code)
+	w = gatewayRequest(t, h, http.MethodGet, "/retained?topic=sensors/%2B", "")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = gatewayRequest(t, h, http.MethodGet, "/retained?topic=other/s1", "")
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	// clearing the retained message
+	w = gatewayRequest(t, h, http.MethodPost, "/publish", `{"topic":"sensors/s1","retain":true}`)
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	w = gatewayRequest(t, h, http.MethodGet, "/retained?topic=sensors/s1", "")
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGatewayNamespace(t *testing.T) {
+	b := newTestBroker(t,
+		WithHTTPGateway(GatewayConfig{Addr: "127.0.0.1:0", Token: testGatewayToken, Username: "backend"}),
+		WithTenantNamespaces(namespace.TenantConfig{
+			Tenants: []namespace.Tenant{{Name: "acme", Prefix: "tenants/acme"}},
+			Users:   map[string]string{"backend": "acme", "dashboard": "acme"},
+		}),
+	)
+	h := b.gatewayHandler()
+
+	operator := connectTestClient(t, b, "operator", "", false)
+	operator.subscribe("tenants/#", 0)
+	dashboard := connectTestClient(t, b, "dashboard", "dashboard", false)
+	dashboard.subscribe("alerts/#", 0)
+
+	// the publish of the gateway is moved to the namespace of the tenant of its user
+	w := gatewayRequest(t, h, http.MethodPost, "/publish", `{"topic":"alerts/a1","payload":"fire"}`)
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	require.Equal(t, "tenants/acme/alerts/a1", operator.expectPublish().TopicName)
+	require.Equal(t, "alerts/a1", dashboard.expectPublish().TopicName)
+}
@@ -0,0 +1,69 @@
+package broker_core_module
+
+import (
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/gctune"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+const defaultGCInterval = 10 * time.Second
+
+// liveMemoryEstimate returns the bytes held by the broker whatever the collections: the tasks
+// queued by the tenant pools, and the topics and payloads of the retained messages.
+func (b *Broker) liveMemoryEstimate() uint64 {
+	var n uint64
+	for _, s := range b.tenantPools.Stats() {
+		n += uint64(s.MemoryUsed)
+	}
+
+	var retained []*packets.PublishPacket
+	if err := b.topicsManager.RetainedStored([]byte("#"), &retained); err == nil {
+		for _, p := range retained {
+			n += uint64(len(p.TopicName) + len(p.Payload))
+		}
+	}
+	return n
+}
+
+// startGCTuneTask sets the GOGC at each interval, and logs it when it changes.
+func (b *Broker) startGCTuneTask() {
+	if b.gcTuner == nil {
+		return
+	}
+	interval := b.gcInterval
+	if interval <= 0 {
+		interval = defaultGCInterval
+	}
+
+	go func() {
+		ticker := b.clock.NewTicker(interval)
+		defer ticker.Stop()
+
+		gogc := -1
+		for {
+			s := b.gcTuner.Tune()
+			if s.GOGC != gogc {
+				b.logger.Info("core_module/broker_gctune/startGCTuneTask: GOGC set ",
+					zap.Int("GOGC", s.GOGC),
+					zap.Uint64("Live", s.Live),
+					zap.Uint64("Estimate", s.Estimate),
+					zap.Duration("PauseP99", s.Pauses.P99),
+				)
+				gogc = s.GOGC
+			}
+			<-ticker.C()
+		}
+	}()
+}
+
+// GCStats returns the pacing of the garbage collector and the pauses of the collections, false if
+// the broker doesn't tune it.
+func (b *Broker) GCStats() (gctune.Stats, bool) {
+	if b.gcTuner == nil {
+		return gctune.Stats{}, false
+	}
+	return b.gcTuner.Stats(), true
+}
@@ -0,0 +1,69 @@
+package broker_core_module
+
+import (
+	"net"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/handoff"
+
+	"go.uber.org/zap"
+)
+
+const handoffListenerName = "mqtt"
+
+// Upgrade starts a new process of the broker with the listeners, and waits until it accepts the
+// connections. Then this broker stops accepting, and disconnects its clients spread over the drain
+// period, so they reconnect to the new process gradually rather than all at once.
+func (b *Broker) Upgrade(timeout time.Duration, drain time.Duration) error {
+	listeners := map[string]net.Listener{handoffListenerName: b.listener}
+	if b.wsListener != nil {
+		listeners[handoffWebSocketName] = b.wsListener
+	}
+	if b.adminListener != nil {
+		listeners[handoffAdminName] = b.adminListener
+	}
+	if b.gatewayListener != nil {
+		listeners[handoffGatewayName] = b.gatewayListener
+	}
+	p, err := handoff.Upgrade(listeners, timeout)
+	if err != nil {
+		return err
+	}
+
+	b.logger.Info("core_module/broker_handoff/Upgrade: listener handed off to the new process ",
+		zap.Int("pid", p.Pid),
+		zap.Duration("drain", drain),
+	)
+
+	b.handedOff.Store(true)
+	b.closeListeners()
+
+	// The clients are not gone, their will messages must not be published.
+	b.drainClients(drain, (*client).closeWithoutWill)
+
+	return nil
+}
+
+// drainClients closes the clients with closeClient, spread over the drain period.
+func (b *Broker) drainClients(drain time.Duration, closeClient func(c *client)) {
+	var clientList []*client
+	b.clients.Range(func(key, value interface{}) bool {
+		if c, ok := value.(*client); ok {
+			clientList = append(clientList, c)
+		}
+		return true
+	})
+
+	if len(clientList) == 0 {
+		return
+	}
+
+	pause := drain / time.Duration(len(clientList))
+	for _, c := range clientList {
+		closeClient(c)
+
+		if pause > 0 {
+			time.Sleep(pause)
+		}
+	}
+}
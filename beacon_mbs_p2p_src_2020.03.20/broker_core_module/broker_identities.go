@@ -0,0 +1,117 @@
+package broker_core_module
+
+import (
+	"crypto/x509"
+	"errors"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/auth"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+var errNoCredentialManager = errors.New("core_module/broker_identities: the auth provider doesn't manage the credentials")
+
+// credentialManager returns the credential manager of the auth provider.
+func (b *Broker) credentialManager() (auth.CredentialManager, error) {
+	if b.authManager == nil {
+		return nil, errNoCredentialManager
+	}
+	cm, ok := b.authManager.Credentials()
+	if !ok {
+		return nil, errNoCredentialManager
+	}
+	return cm, nil
+}
+
+// AddCredential adds a credential to the identity, the old credentials stay valid until they're
+// expired or removed, so the identity is rotated without a flag day.
+func (b *Broker) AddCredential(identity string, c auth.Credential) error {
+	cm, err := b.credentialManager()
+	if err != nil {
+		return err
+	}
+	if err := cm.AddCredential(identity, c); err != nil {
+		return err
+	}
+	b.logger.Info("core_module/broker_identities/AddCredential: credential added ",
+		zap.String("identity", identity),
+		zap.String("credential", c.ID),
+		zap.Time("notBefore", c.NotBefore),
+		zap.Time("notAfter", c.NotAfter),
+	)
+	b.recordConfigChange("credentials/"+identity, "added "+c.ID)
+	return nil
+}
+
+// ExpireCredential ends the validity window of the credential of the identity at notAfter, the
+// clients connected with it are asked for fresh credentials by then.
+func (b *Broker) ExpireCredential(identity string, id string, notAfter time.Time) error {
+	cm, err := b.credentialManager()
+	if err != nil {
+		return err
+	}
+	if err := cm.ExpireCredential(identity, id, notAfter); err != nil {
+		return err
+	}
+	b.logger.Info("core_module/broker_identities/ExpireCredential: credential expiry set ",
+		zap.String("identity", identity),
+		zap.String("credential", id),
+		zap.Time("notAfter", notAfter),
+	)
+	b.recordConfigChange("credentials/"+identity, "expiring "+id)
+	return nil
+}
+
+// RemoveCredential removes the credential of the identity at once.
+func (b *Broker) RemoveCredential(identity string, id string) error {
+	cm, err := b.credentialManager()
+	if err != nil {
+		return err
+	}
+	if err := cm.RemoveCredential(identity, id); err != nil {
+		return err
+	}
+	b.logger.Info("core_module/broker_identities/RemoveCredential: credential removed ",
+		zap.String("identity", identity),
+		zap.String("credential", id),
+	)
+	b.recordConfigChange("credentials/"+identity, "removed "+id)
+	return nil
+}
+
+// Credentials returns the credentials of the identity, without their secrets.
+func (b *Broker) Credentials(identity string) ([]auth.Credential, error) {
+	cm, err := b.credentialManager()
+	if err != nil {
+		return nil, err
+	}
+	return cm.Credentials(identity), nil
+}
+
+// checkPinnedCertificate refuses the client certificate which the auth provider doesn't pin for
+// the identity, while a certificate of the identity is rotated both are pinned.
+func (b *Broker) checkPinnedCertificate(identity string, cert *x509.Certificate, msg *packets.ConnectPacket) byte {
+	if b.authManager == nil {
+		return packets.Accepted
+	}
+	ok, err := b.authManager.AuthenticateCertificate(identity, cert.Raw)
+	if err != nil {
+		b.logger.Error("core_module/broker_identities/checkPinnedCertificate: auth provider error => ",
+			zap.Error(err),
+			zap.String("clientID", msg.ClientIdentifier),
+			zap.String("identity", identity),
+		)
+		return packets.ErrRefusedServerUnavailable
+	}
+	if !ok {
+		b.logger.Warn("core_module/broker_identities/checkPinnedCertificate: the certificate is not pinned for the identity, reject the connect",
+			zap.String("clientID", msg.ClientIdentifier),
+			zap.String("identity", identity),
+			zap.String("fingerprint", auth.Fingerprint(cert.Raw)),
+		)
+		return packets.ErrRefusedNotAuthorised
+	}
+	return packets.Accepted
+}
@@ -0,0 +1,54 @@
+package broker_core_module
+
+import (
+	"net"
+
+	"awesomeProject/beacon/mqtt_network/libs/keepalive"
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// priorityPacket reports whether the packet is processed ahead of the publishes: the PINGREQ, so
+// the keepalive of a client uploading a large payload doesn't time out, and the acknowledgements,
+// which release the inflight window of the client.
+func priorityPacket(packet packets.ControlPacket) bool {
+	switch packet.(type) {
+	case *packets.PingreqPacket,
+		*packets.PubackPacket,
+		*packets.PubrecPacket,
+		*packets.PubrelPacket,
+		*packets.PubcompPacket,
+		*mqtt5.AuthPacket:
+		return true
+	}
+	return false
+}
+
+// submitPriorityTask queues the control packet ahead of the publishes of all the clients, the
+// tenant pools included, it returns false if the message is not a priority one.
+func (b *Broker) submitPriorityTask(msg *Message) bool {
+	if b.inboundPriorityBurst <= 0 || !priorityPacket(msg.packet) {
+		return false
+	}
+	b.fixedWorkPool.SubmitPriorityTask(func() {
+		b.stageLatency.since(StageQueue, msg.received)
+		ProcessMessage(msg)
+	})
+	return true
+}
+
+// progressReader notes the bytes of a packet as they arrive, so a client slowly uploading a large
+// publish isn't timed out mid-way, the connection only expires once it's silent for the timeout.
+type progressReader struct {
+	conn  net.Conn
+	alive *keepalive.Entry
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.conn.Read(p)
+	if n > 0 {
+		r.alive.Touch(false)
+	}
+	return n, err
+}
@@ -0,0 +1,22 @@
+package broker_core_module
+
+import (
+	"net"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/keepalive"
+
+	"go.uber.org/zap"
+)
+
+// expireConnection times the connection out: its read deadline is moved to the past, so the read
+// loop fails and closes the client, and the will of the client is published.
+func (c *client) expireConnection(nc net.Conn, reason keepalive.Reason) {
+	c.logger.Warn("core_module/broker_keepalive/expireConnection: the connection timed out, close it",
+		zap.String("reason", string(reason)),
+		zap.Uint16("keepalive", c.info.keepalive),
+	)
+	if err := nc.SetReadDeadline(time.Unix(1, 0)); err != nil {
+		_ = nc.Close()
+	}
+}
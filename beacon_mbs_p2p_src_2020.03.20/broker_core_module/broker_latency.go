@@ -0,0 +1,104 @@
+package broker_core_module
+
+import (
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/latency"
+)
+
+// pingLatency keeps two views of the same PINGREQ: the time the broker took from reading the
+// PINGREQ to writing the PINGRESP, and the round-trip time of the connection measured by the
+// kernel. A high broker latency with a low network one points to the broker, and vice versa.
+type pingLatency struct {
+	broker  *latency.Recorder
+	network *latency.Recorder
+}
+
+type LatencySummary struct {
+	Broker  latency.Summary `json:"broker"`
+	Network latency.Summary `json:"network"`
+}
+
+type latencyStats struct {
+	mu        sync.Mutex
+	listeners map[string]*pingLatency
+}
+
+func newPingLatency() *pingLatency {
+	return &pingLatency{
+		broker:  latency.NewRecorder(0),
+		network: latency.NewRecorder(0),
+	}
+}
+
+func (p *pingLatency) observe(d time.Duration, rtt time.Duration, rttOk bool) {
+	p.broker.Observe(d)
+	if rttOk {
+		p.network.Observe(rtt)
+	}
+}
+
+func (p *pingLatency) summary() LatencySummary {
+	return LatencySummary{
+		Broker:  p.broker.Summary(),
+		Network: p.network.Summary(),
+	}
+}
+
+func newLatencyStats() *latencyStats {
+	return &latencyStats{
+		listeners: make(map[string]*pingLatency),
+	}
+}
+
+func (l *latencyStats) listener(name string) *pingLatency {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	p, ok := l.listeners[name]
+	if !ok {
+		p = newPingLatency()
+		l.listeners[name] = p
+	}
+	return p
+}
+
+// observePing is called after the PINGRESP of the PINGREQ received at the time has been written.
+func (c *client) observePing(received time.Time) {
+	d := time.Since(received)
+
+	conn := c.conn
+	if conn == nil {
+		return
+	}
+	rtt, ok := tcpRTT(conn)
+
+	c.latency.observe(d, rtt, ok)
+	c.broker.latencyStats.listener(c.info.listener).observe(d, rtt, ok)
+}
+
+// ClientLatency returns the ping latency percentiles of the connected client.
+func (b *Broker) ClientLatency(clientID string) (LatencySummary, bool) {
+	v, exist := b.clients.Load(clientID)
+	if !exist {
+		return LatencySummary{}, false
+	}
+	c, ok := v.(*client)
+	if !ok {
+		return LatencySummary{}, false
+	}
+	return c.latency.summary(), true
+}
+
+// ListenerLatency returns the ping latency percentiles of all the clients per listener.
+func (b *Broker) ListenerLatency() map[string]LatencySummary {
+	b.latencyStats.mu.Lock()
+	defer b.latencyStats.mu.Unlock()
+
+	m := make(map[string]LatencySummary, len(b.latencyStats.listeners))
+	for name, p := range b.latencyStats.listeners {
+		m[name] = p.summary()
+	}
+	return m
+}
@@ -0,0 +1,32 @@
+package broker_core_module
+
+import (
+	"fmt"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+)
+
+// The topics of the broker statistics are always local to the broker
+var defaultLocalTopics = []string{"$SYS/#"}
+
+// checkLocalTopics checks the filters of the node-local topics.
+func checkLocalTopics(filters []string) error {
+	for _, f := range filters {
+		if err := topics.ValidateTopicFilter([]byte(f)); err != nil {
+			return fmt.Errorf("core_module/broker_local_topics/checkLocalTopics: invalid local topic filter %q", f)
+		}
+	}
+	return nil
+}
+
+// LocalTopic reports whether the topic is node-local: its messages are delivered to the
+// subscribers of this broker only, they never cross the cluster links, and its retained messages
+// are not replicated. The ones received from a peer are dropped.
+func (b *Broker) LocalTopic(topic string) bool {
+	for _, f := range b.localTopics {
+		if ok, _ := topics.MatchTopic([]byte(f), []byte(topic)); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package broker_core_module
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalTopics(t *testing.T) {
+	require.Error(t, checkLocalTopics([]string{"local/#/x"}))
+	require.Error(t, checkLocalTopics([]string{""}))
+
+	b := newTestBroker(t, WithLocalTopics("local/#"))
+	require.True(t, b.LocalTopic("local/metrics"))
+	require.True(t, b.LocalTopic("$SYS/broker/uptime"))
+	require.False(t, b.LocalTopic("shared/metrics"))
+
+	forwarded := make(chan string, 16)
+	b.BrokerNode().RegisterDeliverForwardPacketsToTargetNode(func(_ string, batch ForwardBatch) {
+		for _, p := range batch.PacketList {
+			forwarded <- p.TopicName
+		}
+	})
+	require.NoError(t, b.BrokerNode().NodeIdAddrStoreToMap("peer", "127.0.0.1:1"))
+	require.NoError(t, b.topicsManager4P2P.Subscribe4P2P([]byte("#"), "peer"))
+
+	// the local subscribers get both, the peer the shared one only
+	sub := connectTestClient(t, b, "monitor", "", false)
+	require.Equal(t, byte(0), sub.subscribe("local/#", 0))
+	require.Equal(t, byte(0), sub.subscribe("shared/#", 0))
+	pub := connectTestClient(t, b, "publisher", "", false)
+	pub.publish("local/metrics", "1", 1, false)
+	pub.publish("shared/metrics", "2", 1, false)
+	require.Equal(t, "local/metrics", sub.expectPublish().TopicName)
+	require.Equal(t, "shared/metrics", sub.expectPublish().TopicName)
+
+	select {
+	case topic := <-forwarded:
+		require.Equal(t, "shared/metrics", topic)
+	case <-time.After(testReadTimeout):
+		t.Fatal("nothing forwarded to the peer")
+	}
+	select {
+	case topic := <-forwarded:
+		t.Fatalf("unexpected forward of %s", topic)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
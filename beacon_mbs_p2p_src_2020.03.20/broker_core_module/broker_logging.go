@@ -0,0 +1,87 @@
+package broker_core_module
+
+import (
+	"fmt"
+	"reflect"
+
+	"awesomeProject/beacon/mqtt_network/libs/logging"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// The subsystems logging apart from the broker, their levels are changed at runtime by SetLogLevel
+// and the admin API. They follow the level of the logger of the broker until then.
+const (
+	// LogClient logs the connections of the clients, with their client id
+	LogClient = "client"
+	// LogPackets logs each packet read from and written to the clients at debug
+	LogPackets = "packets"
+	// LogBridge logs the bridges to the remote brokers
+	LogBridge = "bridge"
+	// LogPeer logs the messages forwarded to the peer brokers
+	LogPeer = "peer"
+)
+
+var logSubsystems = []string{LogClient, LogPackets, LogBridge, LogPeer}
+
+// initLogLevels creates the loggers of the subsystems on the logger of the broker.
+func (b *Broker) initLogLevels() {
+	b.logLevels = logging.New(b.logger)
+	for _, name := range logSubsystems {
+		b.logLevels.Logger(name)
+	}
+}
+
+func (b *Broker) subsystemLogger(subsystem string) *zap.Logger {
+	return b.logLevels.Logger(subsystem)
+}
+
+// LogLevels returns the level of each subsystem, by name.
+func (b *Broker) LogLevels() map[string]string {
+	return b.logLevels.Levels()
+}
+
+// SetLogLevel changes the level of the subsystem, debug, info, warn or error; default has it follow
+// the level of the logger of the broker again.
+func (b *Broker) SetLogLevel(subsystem string, level string) error {
+	known := false
+	for _, name := range logSubsystems {
+		known = known || name == subsystem
+	}
+	if !known {
+		return fmt.Errorf("core_module/broker_logging/SetLogLevel: unknown subsystem %q", subsystem)
+	}
+	if level == "default" {
+		b.logLevels.Reset(subsystem)
+		return nil
+	}
+
+	var l zapcore.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("core_module/broker_logging/SetLogLevel: unknown level %q", level)
+	}
+	return b.logLevels.SetLevel(subsystem, l)
+}
+
+// logPacket logs the packet read from or written to the client, if the packets are logged.
+func (c *client) logPacket(packet packets.ControlPacket, inbound bool) {
+	ce := c.packetLogger.Check(zap.DebugLevel, "core_module/broker_logging/logPacket: packet")
+	if ce == nil {
+		return
+	}
+	fields := []zap.Field{
+		logging.PacketType(reflect.TypeOf(packet).Elem().Name()),
+		zap.Bool("inbound", inbound),
+	}
+	if p, ok := packet.(*packets.PublishPacket); ok {
+		fields = append(fields,
+			logging.Topic(p.TopicName),
+			zap.Uint8("qos", p.Qos),
+			zap.Uint16("message_id", p.MessageID),
+			zap.Int("payload_size", len(p.Payload)),
+		)
+	}
+	ce.Write(fields...)
+}
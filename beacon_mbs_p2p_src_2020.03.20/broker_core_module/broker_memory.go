@@ -0,0 +1,158 @@
+package broker_core_module
+
+import (
+	"strconv"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/keepalive"
+	"awesomeProject/beacon/mqtt_network/libs/memacct"
+	"awesomeProject/beacon/mqtt_network/libs/sysstats"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultMemoryInterval = 10 * time.Second
+
+	// A paused client is touched at this interval, the keepalive doesn't time it out meanwhile
+	memoryPauseTouch = time.Second
+
+	memoryTopicPrefix = sysstats.Prefix + "memory/"
+)
+
+// MemoryReport is the memory accounted by the broker, with the connections holding the most.
+type MemoryReport struct {
+	memacct.Stats
+	Top []memacct.AccountStats `json:"top"`
+}
+
+// MemoryStats returns the memory accounted by the broker and the n connections holding the most,
+// false if the broker doesn't account it.
+func (b *Broker) MemoryStats(n int) (MemoryReport, bool) {
+	if b.memory == nil {
+		return MemoryReport{}, false
+	}
+	return MemoryReport{Stats: b.memory.Stats(), Top: b.memory.Top(n)}, true
+}
+
+// checkConnectMemory refuses the connections while the broker sheds the load.
+func (b *Broker) checkConnectMemory() byte {
+	if b.memory != nil && b.memory.Shedding() {
+		return packets.ErrRefusedServerUnavailable
+	}
+	return packets.Accepted
+}
+
+// openMemory opens the account of the client, if the broker accounts the memory.
+func (c *client) openMemory() {
+	if c.broker == nil || c.broker.memory == nil {
+		return
+	}
+	c.memory = c.broker.memory.Open(c.info.clientID)
+}
+
+// waitMemory pauses the reads of the client while the broker sheds the load, false once the client
+// is closed meanwhile.
+func (c *client) waitMemory(alive *keepalive.Entry) bool {
+	if c.broker.memory == nil {
+		return true
+	}
+	for {
+		resume := c.broker.memory.Paused()
+		if resume == nil {
+			return true
+		}
+		select {
+		case <-c.ctx.Done():
+			return false
+		case <-resume:
+			return true
+		case <-c.broker.clock.After(memoryPauseTouch):
+			alive.Touch(false)
+		}
+	}
+}
+
+// releaseMemory releases the packet once it's processed.
+func (msg *Message) releaseMemory() {
+	msg.client.memory.Release(memacct.Read, int64(msg.size))
+}
+
+// deliverySize is the size accounted to a queued delivery.
+func deliverySize(packet *packets.PublishPacket) int64 {
+	return int64(len(packet.TopicName) + len(packet.Payload))
+}
+
+// startMemoryTask accounts the retained messages and logs the broker shedding the load, or not
+// anymore.
+func (b *Broker) startMemoryTask() {
+	if b.memory == nil {
+		return
+	}
+
+	go func() {
+		ticker := b.clock.NewTicker(defaultMemoryInterval)
+		defer ticker.Stop()
+
+		shedding := false
+		for {
+			b.memory.SetRetained(b.retainedBytes())
+			s := b.memory.Stats()
+			if s.Shedding != shedding {
+				shedding = s.Shedding
+				if shedding {
+					b.logger.Warn("core_module/broker_memory/startMemoryTask: the memory is over the high-water mark, refuse the connections and pause the reads",
+						zap.Int64("total", s.Total),
+						zap.Int64("high_water", s.HighWater),
+					)
+				} else {
+					b.logger.Info("core_module/broker_memory/startMemoryTask: the memory is under the low-water mark, resume",
+						zap.Int64("total", s.Total),
+						zap.Int64("low_water", s.LowWater),
+					)
+				}
+			}
+			<-ticker.C()
+		}
+	}()
+}
+
+// retainedBytes is the size of the retained messages, counted by the retain limits if the store
+// has some.
+func (b *Broker) retainedBytes() int64 {
+	if l, ok := b.RetainLimitStats(); ok {
+		return l.Bytes
+	}
+	stats, err := b.RetainStats()
+	if err != nil {
+		return 0
+	}
+	var n int64
+	for _, s := range stats {
+		n += s.Bytes
+	}
+	return n
+}
+
+// memoryTopics are the $SYS topics of the accounted memory, none if the broker doesn't account it.
+func (b *Broker) memoryTopics() []sysstats.Topic {
+	if b.memory == nil {
+		return nil
+	}
+	s := b.memory.Stats()
+	shedding := "0"
+	if s.Shedding {
+		shedding = "1"
+	}
+	return []sysstats.Topic{
+		{Name: memoryTopicPrefix + "total", Value: strconv.FormatInt(s.Total, 10)},
+		{Name: memoryTopicPrefix + "buffers", Value: strconv.FormatInt(s.Buffers, 10)},
+		{Name: memoryTopicPrefix + "read", Value: strconv.FormatInt(s.Read, 10)},
+		{Name: memoryTopicPrefix + "queued", Value: strconv.FormatInt(s.Queued, 10)},
+		{Name: memoryTopicPrefix + "retained", Value: strconv.FormatInt(s.Retained, 10)},
+		{Name: memoryTopicPrefix + "high_water", Value: strconv.FormatInt(s.HighWater, 10)},
+		{Name: memoryTopicPrefix + "shedding", Value: shedding},
+		{Name: memoryTopicPrefix + "shed", Value: strconv.FormatUint(s.Shed, 10)},
+	}
+}
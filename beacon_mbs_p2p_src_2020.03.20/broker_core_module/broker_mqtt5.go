@@ -0,0 +1,218 @@
+package broker_core_module
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/logging"
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+	"awesomeProject/beacon/mqtt_network/libs/netbuf"
+	"awesomeProject/beacon/mqtt_network/libs/statelog"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+)
+
+// defaultTopicAliasMaximum is the number of topic aliases a 5.0 client may set on a connection.
+const defaultTopicAliasMaximum = 16
+
+func (c *client) isV5() bool {
+	return c.info.protocolVersion == mqtt5.ProtocolVersion
+}
+
+// writeConnack writes the CONNACK in the version of the CONNECT.
+func writeConnack(conn net.Conn, connAck *packets.ConnackPacket, v5 bool, props *mqtt5.Properties) error {
+	if v5 {
+		return mqtt5.Write(conn, &mqtt5.Packet{Control: connAck, Properties: props})
+	}
+	return connAck.Write(conn)
+}
+
+// connackProperties returns the properties of the CONNACK of a 5.0 client, the client identifier
+// is assigned by the broker if the client sent none. The capabilities and the build info of the
+// broker are announced.
+func (b *Broker) connackProperties(msg *packets.ConnectPacket) *mqtt5.Properties {
+	props := &mqtt5.Properties{}
+	if b.topicAliasMaximum > 0 {
+		props.TopicAliasMaximum = mqtt5.Uint16(b.topicAliasMaximum)
+	}
+	b.Capabilities().setProperties(props)
+	b.BuildInfo().setProperties(props)
+	if len(msg.ClientIdentifier) == 0 {
+		msg.ClientIdentifier = xid.New().String()
+		props.AssignedClientIdentifier = msg.ClientIdentifier
+	}
+	return props
+}
+
+// sessionExpiry returns the session expiry interval of the CONNECT of a 5.0 client, 0 removes the
+// session when the connection is closed.
+func sessionExpiry(connect *mqtt5.Packet) uint32 {
+	if connect.Properties == nil || connect.Properties.SessionExpiryInterval == nil {
+		return 0
+	}
+	return *connect.Properties.SessionExpiryInterval
+}
+
+// messageExpiry returns the message expiry interval of a PUBLISH, 0 if it never expires.
+func messageExpiry(p *mqtt5.Packet) time.Duration {
+	if p == nil || p.Properties == nil || p.Properties.MessageExpiry == nil {
+		return 0
+	}
+	return time.Duration(*p.Properties.MessageExpiry) * time.Second
+}
+
+// readPacket reads a packet in the version of the client, the 5.0 fields are returned beside it
+// for the 5.0 clients. The topic aliases are resolved here, in the order of the packets.
+func (c *client) readPacket(r io.Reader) (packets.ControlPacket, *mqtt5.Packet, error) {
+	if !c.isV5() {
+		packet, err := netbuf.ReadPacket(r)
+		return packet, nil, err
+	}
+
+	p, err := mqtt5.ReadPacket(r)
+	if err != nil {
+		if errors.Is(err, mqtt5.ErrMalformed) {
+			c.writeDisconnect(mqtt5.MalformedPacket)
+		}
+		return nil, nil, err
+	}
+
+	if publish, ok := p.Control.(*packets.PublishPacket); ok {
+		if reasonCode := c.resolveTopicAlias(publish, p.Properties); reasonCode != mqtt5.Success {
+			c.writeDisconnect(reasonCode)
+			return nil, nil, fmt.Errorf("core_module/broker_mqtt5/readPacket: topic alias error 0x%02X", reasonCode)
+		}
+	}
+	return p.Control, p, nil
+}
+
+// resolveTopicAlias sets the topic of a PUBLISH sent with a topic alias and an empty topic, or
+// maps the alias to the topic if both are set.
+func (c *client) resolveTopicAlias(publish *packets.PublishPacket, props *mqtt5.Properties) byte {
+	if props == nil || props.TopicAlias == nil {
+		if len(publish.TopicName) == 0 {
+			return mqtt5.ProtocolError
+		}
+		return mqtt5.Success
+	}
+
+	alias := *props.TopicAlias
+	if alias == 0 || c.broker == nil || alias > c.broker.topicAliasMaximum {
+		return mqtt5.TopicAliasInvalid
+	}
+
+	if len(publish.TopicName) == 0 {
+		topic, ok := c.topicAliases[alias]
+		if !ok {
+			return mqtt5.ProtocolError
+		}
+		publish.TopicName = topic
+		return mqtt5.Success
+	}
+
+	if c.topicAliases == nil {
+		c.topicAliases = make(map[uint16]string)
+	}
+	c.topicAliases[alias] = publish.TopicName
+	return mqtt5.Success
+}
+
+// retainedExt returns the 5.0 fields of a retained message sent to a 5.0 client, its message
+// expiry is the time left.
+func (c *client) retainedExt(topic string) *mqtt5.Packet {
+	if !c.isV5() {
+		return nil
+	}
+	left, ok := c.topicsManager.RetainedExpiry(topic)
+	if !ok {
+		return nil
+	}
+	secs := uint32((left + time.Second - 1) / time.Second)
+	return &mqtt5.Packet{Properties: &mqtt5.Properties{MessageExpiry: mqtt5.Uint32(secs)}}
+}
+
+// writeDisconnect sends a DISCONNECT with the reason code to a 5.0 client, the 3.1.1 clients are
+// just disconnected.
+func (c *client) writeDisconnect(reasonCode byte) {
+	c.writeDisconnectExt(&mqtt5.Packet{ReasonCode: reasonCode})
+}
+
+// writeDisconnectExt is writeDisconnect with the 5.0 fields of the DISCONNECT.
+func (c *client) writeDisconnectExt(ext *mqtt5.Packet) {
+	if !c.isV5() {
+		return
+	}
+	disconnect := packets.NewControlPacket(packets.Disconnect).(*packets.DisconnectPacket)
+	if err := c.writePacket(disconnect, ext); err != nil {
+		c.logger.Warn("core_module/broker_mqtt5/writeDisconnect: send disconnect error, ",
+			zap.Error(err),
+		)
+	}
+}
+
+func (c *client) disconnect(reasonCode byte) {
+	c.writeDisconnect(reasonCode)
+	c.Close()
+}
+
+// processDisconnect applies the DISCONNECT of the client: the will message of a 3.1.1 client is
+// dropped, the one of a 5.0 client is only published if it asked for it, and it may change the
+// session expiry interval.
+func (c *client) processDisconnect(p *mqtt5.Packet) {
+	if p == nil {
+		c.dropWill()
+		return
+	}
+	if p.ReasonCode != mqtt5.DisconnectWithWillMessage {
+		c.dropWill()
+	}
+	// a session expiry interval of 0 in the CONNECT cannot be changed
+	if p.Properties != nil && p.Properties.SessionExpiryInterval != nil && c.info.sessionExpiry != 0 {
+		c.info.sessionExpiry = *p.Properties.SessionExpiryInterval
+	}
+}
+
+// expireSession removes the session of a 5.0 client once its expiry interval has elapsed after
+// the connection is closed, unless the client has connected again.
+func (b *Broker) expireSession(c *client) {
+	if !c.isV5() || c.session == nil || c.takenOver {
+		return
+	}
+
+	switch c.info.sessionExpiry {
+	case mqtt5.SessionNeverExpires:
+		return
+	case 0:
+		b.removeSession(c)
+	default:
+		timer := b.clock.NewTimer(time.Duration(c.info.sessionExpiry) * time.Second)
+		go func() {
+			<-timer.C()
+			if _, online := b.clients.Load(c.info.clientID); !online {
+				b.removeSession(c)
+			}
+		}()
+	}
+}
+
+// removeSession removes the session of the client if it's still the session of its client id.
+func (b *Broker) removeSession(c *client) {
+	cid := c.info.clientID
+	if s, err := b.sessionManager.Get(cid); err != nil || s != c.session {
+		return
+	}
+	b.unparkSubscriptions(cid, true)
+	b.sessionManager.Del(cid)
+	b.recordState(statelog.Event{Kind: statelog.SessionRemoved, ClientID: cid})
+	if err := b.packetIDs.Delete(cid); err != nil {
+		b.logger.Warn("core_module/broker_mqtt5/removeSession: delete packet ids error, ",
+			zap.Error(err),
+			logging.ClientID(cid),
+		)
+	}
+}
@@ -0,0 +1,647 @@
+package broker_core_module
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/acl"
+	"awesomeProject/beacon/mqtt_network/libs/handoff"
+	"awesomeProject/beacon/mqtt_network/libs/logging"
+	"awesomeProject/beacon/mqtt_network/libs/mqttsn"
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+const (
+	// The messages kept for a sleeping client by default
+	defaultMQTTSNBuffered = 100
+
+	// The clients are dropped once they're silent for 1.5 times their keep alive or sleep duration,
+	// they're checked every interval
+	mqttsnSweepInterval = 10 * time.Second
+
+	// The largest datagram read, MQTT-SN messages are 65535 bytes at most
+	mqttsnMaxDatagram = 1 << 16
+
+	// The prefix of the client ids of the MQTT-SN clients, for the ACL and the topic owners
+	mqttsnClientPrefix = "mqttsn/"
+)
+
+// MQTTSNConfig serves the MQTT-SN 1.2 gateway on a UDP listener, so the battery powered sensors
+// publish and subscribe without a TCP and MQTT stack. The clients are not authenticated, the ACL
+// checks them as "mqttsn/<client id>" and the user Username. The Predefined topic ids are known
+// to all the clients beforehand, the QoS -1 publishes use them without connecting.
+//
+// The deliveries are QoS 0 or 1 and not retransmitted; the wills and the gateway discovery are not
+// supported.
+type MQTTSNConfig struct {
+	// Addr is the host:port of the UDP listener
+	Addr       string
+	Username   string
+	Predefined map[uint16]string
+	// MaxBuffered is the number of messages kept for a sleeping client, 100 if it's zero; the
+	// oldest ones are dropped beyond it
+	MaxBuffered int
+}
+
+type mqttsnGateway struct {
+	b    *Broker
+	cfg  MQTTSNConfig
+	conn net.PacketConn
+	// predefined resolves the topics of the QoS -1 publishes
+	predefined *mqttsn.Topics
+
+	mu      sync.Mutex
+	clients map[string]*mqttsnClient
+	byAddr  map[string]*mqttsnClient
+	stop    chan struct{}
+}
+
+// mqttsnClient is a connected MQTT-SN client, it's the sink of its subscriptions in the gateway
+// hub. A sleeping client gets the messages delivered meanwhile when it wakes up with a PINGREQ.
+type mqttsnClient struct {
+	gw       *mqttsnGateway
+	clientID string
+	topics   *mqttsn.Topics
+	buffer   *mqttsn.Buffer
+
+	mu        sync.Mutex
+	addr      net.Addr
+	asleep    bool
+	keepAlive time.Duration
+	lastSeen  time.Time
+	msgID     uint16
+	// The messages waiting for the REGACK of their topic id, by topic id
+	registering map[uint16][]mqttsn.Message
+	// The messages delivered while the client subscribes, sent after the SUBACK
+	held    []mqttsn.Message
+	holding bool
+	// The packet ids of the QoS 2 publishes waiting for their PUBREL
+	received map[uint16]bool
+}
+
+// startMQTTSNListener serves the MQTT-SN gateway. Like the QUIC socket, the UDP socket is not
+// handed off on upgrade, the clients reconnect to the new process.
+func (b *Broker) startMQTTSNListener() error {
+	if b.mqttsnConfig == nil {
+		return nil
+	}
+
+	conn, err := handoff.ListenPacket("udp", b.mqttsnConfig.Addr)
+	if err != nil {
+		return err
+	}
+	cfg := *b.mqttsnConfig
+	if cfg.MaxBuffered == 0 {
+		cfg.MaxBuffered = defaultMQTTSNBuffered
+	}
+	gw := &mqttsnGateway{
+		b:          b,
+		cfg:        cfg,
+		conn:       conn,
+		predefined: mqttsn.NewTopics(cfg.Predefined),
+		clients:    make(map[string]*mqttsnClient),
+		byAddr:     make(map[string]*mqttsnClient),
+		stop:       make(chan struct{}),
+	}
+	b.mqttsnGateway = gw
+
+	b.logger.Info("Listening for the MQTT-SN gateway.",
+		zap.String("bind_addr", conn.LocalAddr().String()),
+	)
+
+	go gw.serve()
+	go gw.sweep()
+	return nil
+}
+
+// close stops the gateway, the subscriptions of its clients are removed.
+func (gw *mqttsnGateway) close() {
+	_ = gw.conn.Close()
+
+	gw.mu.Lock()
+	select {
+	case <-gw.stop:
+	default:
+		close(gw.stop)
+	}
+	clients := gw.clients
+	gw.clients = make(map[string]*mqttsnClient)
+	gw.byAddr = make(map[string]*mqttsnClient)
+	gw.mu.Unlock()
+
+	for _, c := range clients {
+		gw.b.GatewayUnsubscribeAll(c)
+	}
+}
+
+func (gw *mqttsnGateway) serve() {
+	buf := make([]byte, mqttsnMaxDatagram)
+	for {
+		n, addr, err := gw.conn.ReadFrom(buf)
+		if err != nil {
+			if !gw.b.stopped() && !isClosed(gw.stop) {
+				gw.b.logger.Error("MQTT-SN gateway read error on listening", zap.Error(err))
+			}
+			return
+		}
+		p, err := mqttsn.Decode(buf[:n])
+		if err != nil {
+			gw.b.logger.Debug("core_module/broker_mqttsn/serve: invalid message, drop it ",
+				zap.Error(err),
+				zap.String("remote", addr.String()),
+			)
+			continue
+		}
+		gw.handle(addr, p)
+	}
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// sweep drops the clients silent for 1.5 times their keep alive or sleep duration.
+func (gw *mqttsnGateway) sweep() {
+	ticker := gw.b.clock.NewTicker(mqttsnSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-gw.stop:
+			return
+		case <-ticker.C():
+		}
+
+		now := gw.b.clock.Now()
+		var expired []*mqttsnClient
+		gw.mu.Lock()
+		for _, c := range gw.clients {
+			c.mu.Lock()
+			if c.keepAlive > 0 && now.Sub(c.lastSeen) > c.keepAlive*3/2 {
+				expired = append(expired, c)
+			}
+			c.mu.Unlock()
+		}
+		gw.mu.Unlock()
+
+		for _, c := range expired {
+			gw.b.logger.Info("core_module/broker_mqttsn/sweep: the client is silent, drop it ",
+				logging.ClientID(c.clientID),
+			)
+			gw.remove(c)
+		}
+	}
+}
+
+func (gw *mqttsnGateway) send(addr net.Addr, p *mqttsn.Packet) {
+	data, err := mqttsn.Encode(p)
+	if err == nil {
+		_, err = gw.conn.WriteTo(data, addr)
+	}
+	if err != nil && !isClosed(gw.stop) {
+		gw.b.logger.Warn("core_module/broker_mqttsn/send: send error ",
+			zap.Error(err),
+			zap.String("remote", addr.String()),
+		)
+	}
+}
+
+// client returns the client connected from the address, nil if there is none.
+func (gw *mqttsnGateway) client(addr net.Addr) *mqttsnClient {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	return gw.byAddr[addr.String()]
+}
+
+// remove forgets the client and removes its subscriptions.
+func (gw *mqttsnGateway) remove(c *mqttsnClient) {
+	gw.mu.Lock()
+	if gw.clients[c.clientID] == c {
+		delete(gw.clients, c.clientID)
+	}
+	c.mu.Lock()
+	addr := c.addr.String()
+	c.mu.Unlock()
+	if gw.byAddr[addr] == c {
+		delete(gw.byAddr, addr)
+	}
+	gw.mu.Unlock()
+
+	gw.b.GatewayUnsubscribeAll(c)
+}
+
+func (gw *mqttsnGateway) handle(addr net.Addr, p *mqttsn.Packet) {
+	if p.Type == mqttsn.Connect {
+		gw.connect(addr, p)
+		return
+	}
+	if p.Type == mqttsn.Publish && p.Flags.Qos == mqttsn.QosMinusOne {
+		gw.publishMinusOne(p)
+		return
+	}
+	if p.Type == mqttsn.Pingreq && len(p.ClientID) > 0 {
+		gw.wake(addr, p.ClientID)
+		return
+	}
+
+	c := gw.client(addr)
+	if c == nil {
+		// the client has to connect first, or again once it was dropped
+		if p.Type == mqttsn.Publish || p.Type == mqttsn.Subscribe || p.Type == mqttsn.Register {
+			gw.send(addr, &mqttsn.Packet{Type: mqttsn.Disconnect})
+		}
+		return
+	}
+	c.seen()
+
+	switch p.Type {
+	case mqttsn.Register:
+		c.register(p)
+	case mqttsn.Regack:
+		c.regack(p)
+	case mqttsn.Publish:
+		c.publish(p)
+	case mqttsn.Pubrel:
+		c.mu.Lock()
+		delete(c.received, p.MsgID)
+		c.mu.Unlock()
+		c.reply(&mqttsn.Packet{Type: mqttsn.Pubcomp, MsgID: p.MsgID})
+	case mqttsn.Subscribe:
+		c.subscribe(p)
+	case mqttsn.Unsubscribe:
+		c.unsubscribe(p)
+	case mqttsn.Pingreq:
+		c.reply(&mqttsn.Packet{Type: mqttsn.Pingresp})
+	case mqttsn.Disconnect:
+		c.reply(&mqttsn.Packet{Type: mqttsn.Disconnect})
+		if p.Duration > 0 {
+			c.sleep(time.Duration(p.Duration) * time.Second)
+			return
+		}
+		gw.remove(c)
+	}
+}
+
+// connect accepts the client. A client which connects again without a clean session keeps its
+// subscriptions and topic ids, and gets the messages buffered while it was asleep.
+func (gw *mqttsnGateway) connect(addr net.Addr, p *mqttsn.Packet) {
+	b := gw.b
+	if p.Flags.Will {
+		gw.send(addr, &mqttsn.Packet{Type: mqttsn.Connack, ReturnCode: mqttsn.RejectedNotSupp})
+		return
+	}
+	if len(p.ClientID) == 0 || b.shuttingDown.Load() {
+		gw.send(addr, &mqttsn.Packet{Type: mqttsn.Connack, ReturnCode: mqttsn.RejectedCongested})
+		return
+	}
+
+	gw.mu.Lock()
+	old := gw.clients[p.ClientID]
+	if old != nil && !p.Flags.CleanSession {
+		old.mu.Lock()
+		delete(gw.byAddr, old.addr.String())
+		old.addr = addr
+		old.asleep = false
+		old.keepAlive = time.Duration(p.Duration) * time.Second
+		old.lastSeen = b.clock.Now()
+		old.mu.Unlock()
+		gw.byAddr[addr.String()] = old
+		gw.mu.Unlock()
+
+		old.reply(&mqttsn.Packet{Type: mqttsn.Connack, ReturnCode: mqttsn.Accepted})
+		old.flush()
+		return
+	}
+
+	c := &mqttsnClient{
+		gw:          gw,
+		clientID:    p.ClientID,
+		topics:      mqttsn.NewTopics(gw.cfg.Predefined),
+		buffer:      mqttsn.NewBuffer(gw.cfg.MaxBuffered),
+		addr:        addr,
+		keepAlive:   time.Duration(p.Duration) * time.Second,
+		lastSeen:    b.clock.Now(),
+		registering: make(map[uint16][]mqttsn.Message),
+		received:    make(map[uint16]bool),
+	}
+	if prev := gw.byAddr[addr.String()]; prev != nil && prev != old {
+		delete(gw.clients, prev.clientID)
+		defer b.GatewayUnsubscribeAll(prev)
+	}
+	gw.clients[c.clientID] = c
+	gw.byAddr[addr.String()] = c
+	if old != nil {
+		old.mu.Lock()
+		if gw.byAddr[old.addr.String()] == old {
+			delete(gw.byAddr, old.addr.String())
+		}
+		old.mu.Unlock()
+	}
+	gw.mu.Unlock()
+
+	// a clean session starts over
+	if old != nil {
+		b.GatewayUnsubscribeAll(old)
+	}
+	b.logger.Info("core_module/broker_mqttsn/connect: client connected ",
+		logging.ClientID(c.clientID),
+		zap.String("remote", addr.String()),
+	)
+	c.reply(&mqttsn.Packet{Type: mqttsn.Connack, ReturnCode: mqttsn.Accepted})
+}
+
+// wake sends the messages buffered for the sleeping client, then the PINGRESP which lets it sleep
+// again.
+func (gw *mqttsnGateway) wake(addr net.Addr, clientID string) {
+	gw.mu.Lock()
+	c := gw.clients[clientID]
+	if c != nil {
+		c.mu.Lock()
+		delete(gw.byAddr, c.addr.String())
+		c.addr = addr
+		c.mu.Unlock()
+		gw.byAddr[addr.String()] = c
+	}
+	gw.mu.Unlock()
+	if c == nil {
+		gw.send(addr, &mqttsn.Packet{Type: mqttsn.Disconnect})
+		return
+	}
+
+	c.seen()
+	for _, m := range c.buffer.Take() {
+		c.deliver(m)
+	}
+	c.reply(&mqttsn.Packet{Type: mqttsn.Pingresp})
+}
+
+// publishMinusOne publishes the QoS -1 message of a client which may not be connected, to a
+// predefined or short topic, as QoS 0. It's not acknowledged.
+func (gw *mqttsnGateway) publishMinusOne(p *mqttsn.Packet) {
+	topic, ok := gw.predefined.Name(p.Flags.TopicIDType, p.TopicID)
+	if !ok || p.Flags.TopicIDType == mqttsn.TopicNormal {
+		return
+	}
+	if err := gw.b.publishAs(gw.cfg.Addr, mqttsnClientPrefix, gw.cfg.Username, topic, p.Data, 0, p.Flags.Retain); err != nil {
+		gw.b.logger.Debug("core_module/broker_mqttsn/publishMinusOne: publish error ",
+			zap.Error(err),
+			logging.Topic(topic),
+		)
+	}
+}
+
+func (c *mqttsnClient) ID() string {
+	return mqttsnClientPrefix + c.clientID
+}
+
+func (c *mqttsnClient) seen() {
+	c.mu.Lock()
+	c.lastSeen = c.gw.b.clock.Now()
+	c.mu.Unlock()
+}
+
+func (c *mqttsnClient) sleep(duration time.Duration) {
+	c.mu.Lock()
+	c.asleep = true
+	c.keepAlive = duration
+	c.mu.Unlock()
+}
+
+func (c *mqttsnClient) reply(p *mqttsn.Packet) {
+	c.mu.Lock()
+	addr := c.addr
+	c.mu.Unlock()
+	c.gw.send(addr, p)
+}
+
+func (c *mqttsnClient) nextMsgID() uint16 {
+	c.msgID++
+	if c.msgID == 0 {
+		c.msgID = 1
+	}
+	return c.msgID
+}
+
+func (c *mqttsnClient) register(p *mqttsn.Packet) {
+	code := mqttsn.Accepted
+	id, err := uint16(0), topics.ValidatePublishTopic([]byte(p.TopicName))
+	if err == nil {
+		id, err = c.topics.Register(p.TopicName)
+	}
+	if err != nil {
+		code = mqttsn.RejectedNotSupp
+	}
+	c.reply(&mqttsn.Packet{Type: mqttsn.Regack, TopicID: id, MsgID: p.MsgID, ReturnCode: code})
+}
+
+// regack sends the messages waiting for the topic id registered to the client, or drops them if
+// the client rejected it.
+func (c *mqttsnClient) regack(p *mqttsn.Packet) {
+	c.mu.Lock()
+	waiting := c.registering[p.TopicID]
+	delete(c.registering, p.TopicID)
+	c.mu.Unlock()
+	if p.ReturnCode != mqttsn.Accepted {
+		return
+	}
+	for _, m := range waiting {
+		c.deliver(m)
+	}
+}
+
+// publish publishes the message of the client through the pipeline of the broker. A QoS 2 publish
+// is published once, when it's received the first time.
+func (c *mqttsnClient) publish(p *mqttsn.Packet) {
+	topic, ok := c.topics.Name(p.Flags.TopicIDType, p.TopicID)
+	if !ok {
+		c.reply(&mqttsn.Packet{Type: mqttsn.Puback, TopicID: p.TopicID, MsgID: p.MsgID, ReturnCode: mqttsn.RejectedTopicID})
+		return
+	}
+
+	if p.Flags.Qos == 2 {
+		c.mu.Lock()
+		dup := c.received[p.MsgID]
+		c.mu.Unlock()
+		if dup {
+			c.reply(&mqttsn.Packet{Type: mqttsn.Pubrec, MsgID: p.MsgID})
+			return
+		}
+	}
+
+	b := c.gw.b
+	if err := b.publishAs(c.gw.cfg.Addr, c.ID(), c.gw.cfg.Username, topic, p.Data, p.Flags.Qos, p.Flags.Retain); err != nil {
+		b.logger.Debug("core_module/broker_mqttsn/publish: publish error ",
+			zap.Error(err),
+			logging.ClientID(c.clientID),
+			logging.Topic(topic),
+		)
+		if p.Flags.Qos > 0 {
+			c.reply(&mqttsn.Packet{Type: mqttsn.Puback, TopicID: p.TopicID, MsgID: p.MsgID, ReturnCode: mqttsn.RejectedNotSupp})
+		}
+		return
+	}
+
+	switch p.Flags.Qos {
+	case 1:
+		c.reply(&mqttsn.Packet{Type: mqttsn.Puback, TopicID: p.TopicID, MsgID: p.MsgID, ReturnCode: mqttsn.Accepted})
+	case 2:
+		c.mu.Lock()
+		c.received[p.MsgID] = true
+		c.mu.Unlock()
+		c.reply(&mqttsn.Packet{Type: mqttsn.Pubrec, MsgID: p.MsgID})
+	}
+}
+
+// filter returns the topic filter of a SUBSCRIBE or UNSUBSCRIBE.
+func (c *mqttsnClient) filter(p *mqttsn.Packet) (string, bool) {
+	if p.Flags.TopicIDType == mqttsn.TopicNormal {
+		return p.TopicName, len(p.TopicName) > 0
+	}
+	return c.topics.Name(p.Flags.TopicIDType, p.TopicID)
+}
+
+// subscribe subscribes the client through the gateway hub, the QoS is 1 at most. The topic id of
+// a topic name without wildcards is registered and returned in the SUBACK, the retained messages
+// are delivered after it.
+func (c *mqttsnClient) subscribe(p *mqttsn.Packet) {
+	b := c.gw.b
+	ack := &mqttsn.Packet{Type: mqttsn.Suback, MsgID: p.MsgID, ReturnCode: mqttsn.Accepted}
+	filter, ok := c.filter(p)
+	if !ok || p.Flags.Qos == mqttsn.QosMinusOne || topics.ValidateTopicFilter([]byte(filter)) != nil {
+		ack.ReturnCode = mqttsn.RejectedTopicID
+		c.reply(ack)
+		return
+	}
+	if !b.checkACL(c.ID(), c.gw.cfg.Username, acl.Subscribe, filter) {
+		ack.ReturnCode = mqttsn.RejectedNotSupp
+		c.reply(ack)
+		return
+	}
+
+	qos := p.Flags.Qos
+	if qos > 1 {
+		qos = 1
+	}
+	ack.Flags.Qos = qos
+	if p.Flags.TopicIDType == mqttsn.TopicNormal && topics.ValidatePublishTopic([]byte(filter)) == nil {
+		if _, ok := mqttsn.ShortTopicID(filter); !ok {
+			id, err := c.topics.Register(filter)
+			if err != nil {
+				ack.ReturnCode = mqttsn.RejectedCongested
+				c.reply(ack)
+				return
+			}
+			ack.TopicID = id
+		}
+	}
+
+	c.mu.Lock()
+	c.holding = true
+	c.mu.Unlock()
+	if _, err := b.GatewaySubscribe(filter, qos, c); err != nil {
+		b.logger.Error("core_module/broker_mqttsn/subscribe: subscribe error ",
+			zap.Error(err),
+			logging.ClientID(c.clientID),
+			zap.String("filter", filter),
+		)
+		ack.ReturnCode = mqttsn.RejectedCongested
+	}
+	c.reply(ack)
+
+	c.mu.Lock()
+	held := c.held
+	c.held, c.holding = nil, false
+	c.mu.Unlock()
+	for _, m := range held {
+		c.deliver(m)
+	}
+}
+
+func (c *mqttsnClient) unsubscribe(p *mqttsn.Packet) {
+	if filter, ok := c.filter(p); ok {
+		_ = c.gw.b.GatewayUnsubscribe(filter, c)
+	}
+	c.reply(&mqttsn.Packet{Type: mqttsn.Unsuback, MsgID: p.MsgID})
+}
+
+// Deliver sends the message to the client, it's buffered if the client is asleep.
+func (c *mqttsnClient) Deliver(packet *packets.PublishPacket) error {
+	qos := packet.Qos
+	if qos > 1 {
+		qos = 1
+	}
+	m := mqttsn.Message{Topic: packet.TopicName, Qos: qos, Retain: packet.Retain, Payload: packet.Payload}
+
+	c.mu.Lock()
+	if c.holding {
+		c.held = append(c.held, m)
+		c.mu.Unlock()
+		return nil
+	}
+	asleep := c.asleep
+	c.mu.Unlock()
+	if asleep {
+		if c.buffer.Push(m) {
+			c.gw.b.logger.Debug("core_module/broker_mqttsn/Deliver: the buffer of the sleeping client is full, drop the oldest message ",
+				logging.ClientID(c.clientID),
+			)
+		}
+		return nil
+	}
+	c.deliver(m)
+	return nil
+}
+
+// flush sends the messages buffered while the client was asleep.
+func (c *mqttsnClient) flush() {
+	for _, m := range c.buffer.Take() {
+		c.deliver(m)
+	}
+}
+
+// deliver sends the PUBLISH of the message, after registering its topic id to the client if it
+// has none yet.
+func (c *mqttsnClient) deliver(m mqttsn.Message) {
+	idType, id, ok := c.topics.ID(m.Topic)
+
+	c.mu.Lock()
+	if !ok {
+		var err error
+		if id, err = c.topics.Register(m.Topic); err != nil {
+			c.mu.Unlock()
+			return
+		}
+		c.registering[id] = append(c.registering[id], m)
+		msgID := c.nextMsgID()
+		c.mu.Unlock()
+		c.reply(&mqttsn.Packet{Type: mqttsn.Register, TopicID: id, MsgID: msgID, TopicName: m.Topic})
+		return
+	}
+	if waiting, ok := c.registering[id]; ok && idType == mqttsn.TopicNormal {
+		c.registering[id] = append(waiting, m)
+		c.mu.Unlock()
+		return
+	}
+	var msgID uint16
+	if m.Qos > 0 {
+		msgID = c.nextMsgID()
+	}
+	c.mu.Unlock()
+
+	c.reply(&mqttsn.Packet{
+		Type:    mqttsn.Publish,
+		Flags:   mqttsn.Flags{Qos: m.Qos, Retain: m.Retain, TopicIDType: idType},
+		TopicID: id,
+		MsgID:   msgID,
+		Data:    m.Payload,
+	})
+}
@@ -0,0 +1,126 @@
+package broker_core_module
+
+import (
+	"awesomeProject/beacon/mqtt_network/libs/acl"
+	"awesomeProject/beacon/mqtt_network/libs/logging"
+	"awesomeProject/beacon/mqtt_network/libs/namespace"
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// The scoped methods below are the operations of the admin API for the tenant admins, each of them
+// resolves the admin token first, and refuses any topic or client out of its namespace.
+
+func (b *Broker) Namespaces() *namespace.Registry {
+	return b.namespaces
+}
+
+// ScopedRetained returns the retained messages matched by the filter.
+func (b *Broker) ScopedRetained(token string, filter string) ([]*packets.PublishPacket, error) {
+	ns, err := b.namespaces.Resolve(token)
+	if err != nil {
+		return nil, err
+	}
+	if !ns.ContainsFilter(filter) {
+		return nil, namespace.ErrOutOfScope
+	}
+
+	return b.Retained(filter)
+}
+
+// ScopedRetainedHistory returns the kept versions of the retained message of the topic.
+func (b *Broker) ScopedRetainedHistory(token string, topic string) ([]topics.RetainedVersion, error) {
+	ns, err := b.namespaces.Resolve(token)
+	if err != nil {
+		return nil, err
+	}
+	if !ns.ContainsTopic(topic) {
+		return nil, namespace.ErrOutOfScope
+	}
+
+	return b.RetainedHistory(topic)
+}
+
+// ScopedExpandFilter returns the known concrete topics matched by the filter.
+func (b *Broker) ScopedExpandFilter(token string, filter string) ([]topics.KnownTopic, error) {
+	ns, err := b.namespaces.Resolve(token)
+	if err != nil {
+		return nil, err
+	}
+	if !ns.ContainsFilter(filter) {
+		return nil, namespace.ErrOutOfScope
+	}
+
+	return b.ExpandFilter(filter)
+}
+
+// ScopedClearRetained removes the retained messages matched by the filter, it returns how many
+// were removed.
+func (b *Broker) ScopedClearRetained(token string, filter string) (int, error) {
+	ns, err := b.namespaces.Resolve(token)
+	if err != nil {
+		return 0, err
+	}
+	if !ns.ContainsFilter(filter) {
+		return 0, namespace.ErrOutOfScope
+	}
+
+	return b.ClearRetained(filter)
+}
+
+// ScopedClients returns the connected clients owned by the namespace, by client id.
+func (b *Broker) ScopedClients(token string) ([]AdminClient, error) {
+	ns, err := b.namespaces.Resolve(token)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []AdminClient{}
+	for _, ac := range b.Clients() {
+		if ns.OwnsClient(ac.ClientID) {
+			list = append(list, ac)
+		}
+	}
+	return list, nil
+}
+
+// ScopedKick disconnects the client, it returns false if the client is not connected.
+func (b *Broker) ScopedKick(token string, clientID string) (bool, error) {
+	ns, err := b.namespaces.Resolve(token)
+	if err != nil {
+		return false, err
+	}
+	if !ns.OwnsClient(clientID) {
+		return false, namespace.ErrOutOfScope
+	}
+
+	b.logger.Info("core_module/broker_namespace/ScopedKick: kick the client by tenant admin ",
+		logging.ClientID(clientID),
+		zap.String("namespace", ns.Name),
+	)
+	return b.Kick(clientID), nil
+}
+
+// ScopedACL returns the rules of the ACL of the namespace, false if none is set.
+func (b *Broker) ScopedACL(token string) (acl.Config, bool, error) {
+	return b.namespaces.ACL(token)
+}
+
+// ScopedSetACL sets the ACL of the clients of the namespace, its rules cover the topics of the
+// namespace only. It cannot allow what the ACL of the broker denies.
+func (b *Broker) ScopedSetACL(token string, cfg acl.Config) error {
+	ns, err := b.namespaces.Resolve(token)
+	if err != nil {
+		return err
+	}
+	if err := b.namespaces.SetACL(token, cfg); err != nil {
+		return err
+	}
+	b.logger.Info("core_module/broker_namespace/ScopedSetACL: the ACL of the namespace is set by tenant admin ",
+		zap.String("namespace", ns.Name),
+		zap.Int("rules", len(cfg.Rules)),
+	)
+	return nil
+}
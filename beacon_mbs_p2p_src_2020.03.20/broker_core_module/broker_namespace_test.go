@@ -0,0 +1,65 @@
+package broker_core_module
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"awesomeProject/beacon/mqtt_network/libs/namespace"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScopedAdmin(t *testing.T) {
+	b := newTestBroker(t, WithAdminAPI(AdminConfig{Addr: "127.0.0.1:0", Token: "secret"}))
+	require.NoError(t, b.Namespaces().Delegate(namespace.Namespace{Name: "acme", Prefix: "acme", ClientPrefix: "acme-"}, "acme-token"))
+	handler := b.adminHandler()
+	serve := func(method string, target string, body string, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	acme := connectTestClient(t, b, "acme-1", "", false)
+	globex := connectTestClient(t, b, "globex-1", "", false)
+	acme.publish("acme/r", "a", 1, true)
+	globex.publish("globex/r", "g", 1, true)
+
+	w := serve(http.MethodGet, "/clients", "", "acme-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	var clients []AdminClient
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &clients))
+	require.Len(t, clients, 1)
+	require.Equal(t, "acme-1", clients[0].ClientID)
+	require.Equal(t, http.StatusForbidden, serve(http.MethodDelete, "/clients/globex-1", "", "acme-token").Code)
+
+	// the retained messages are the ones of the namespace by default
+	w = serve(http.MethodGet, "/retained", "", "acme-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	var retained []AdminRetained
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &retained))
+	require.Len(t, retained, 1)
+	require.Equal(t, "acme/r", retained[0].Topic)
+	require.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/retained?filter=%23", "", "acme-token").Code)
+
+	// the ACL of the namespace applies to its clients along the broker's
+	require.Equal(t, http.StatusForbidden, serve(http.MethodPut, "/acl", `{"default":"allow","rules":[{"action":"deny","topics":["globex/#"]}]}`, "acme-token").Code)
+	w = serve(http.MethodPut, "/acl", `{"default":"allow","rules":[{"action":"deny","access":"publish","topics":["acme/locked"]}]}`, "acme-token")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Equal(t, http.StatusOK, serve(http.MethodGet, "/acl", "", "acme-token").Code)
+
+	globex.subscribe("acme/#", 0)
+	globex.expectPublish()
+	acme.publish("acme/locked", "x", 1, false)
+	globex.expectNothing()
+	acme.publish("acme/open", "x", 1, false)
+	require.Equal(t, "acme/open", globex.expectPublish().TopicName)
+
+	require.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/clients/acme-1", "", "acme-token").Code)
+	acme.expectClosed()
+	require.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/clients", "", "unknown").Code)
+}
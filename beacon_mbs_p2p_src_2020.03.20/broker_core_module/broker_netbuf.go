@@ -0,0 +1,68 @@
+package broker_core_module
+
+import (
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// The size of the pooled buffer a connection reads its packets with, while their bytes are
+	// pending
+	readBufferSize = 4096
+
+	// The bytes the writer of the outbound queue gathers before it writes them, even if the queue
+	// has more deliveries ready
+	maxGatheredBytes = 64 * 1024
+)
+
+var errConnectionLost = errors.New("core_module/broker_netbuf/flushOut: connection lost")
+
+// flushOut writes the bytes written to the client to its connection, unless its writer is corked
+// and gathers them. c.mu must be held.
+func (c *client) flushOut() error {
+	if c.corked {
+		return nil
+	}
+	conn := c.conn
+	if conn == nil {
+		c.out.Reset()
+		return errConnectionLost
+	}
+	_, err := c.out.Flush(conn)
+	return err
+}
+
+// cork gathers the bytes written to the client until uncork, the deliveries written by the writer
+// of the outbound queue and the acknowledgements written meanwhile.
+func (c *client) cork() {
+	c.mu.Lock()
+	c.corked = true
+	c.mu.Unlock()
+}
+
+// uncork writes the bytes gathered since cork at once, the client is closed if the write fails:
+// the QoS 1 and 2 deliveries gathered stay inflight in its session.
+func (c *client) uncork() {
+	c.mu.Lock()
+	c.corked = false
+	var err error
+	if c.out.Buffered() > 0 {
+		err = c.flushOut()
+	}
+	c.mu.Unlock()
+
+	if err != nil {
+		c.logger.Error("core_module/broker_netbuf/uncork: write the gathered packets error => ",
+			zap.Error(err),
+		)
+		c.Close()
+	}
+}
+
+// gathered returns the bytes gathered since cork.
+func (c *client) gathered() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.out.Buffered()
+}
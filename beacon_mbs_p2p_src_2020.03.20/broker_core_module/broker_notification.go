@@ -80,3 +80,71 @@ func (b *Broker) PeerNodeNotification(brokerIdStr string, mod string, info strin
 	packet.Payload = []byte(fmt.Sprintf(`{"broker_id":"%s","timestamp":"%s","%s_info":%s}`, brokerIdStr, time.Now().UTC().Format(time.RFC3339), mod, info))
 	b.SubmitPublishPacketsWorkTask(packet)
 }
+
+func (b *Broker) StageLatencyMetricsNotification(brokerIdStr string, metricsInfo string) {
+	packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	packet.TopicName = "$SYS/metrics/stage_latency/broker/" + brokerIdStr
+	packet.Qos = QosAtMostOnce
+	packet.Payload = []byte(fmt.Sprintf(`{"broker_id":"%s","timestamp":"%s","metrics":%s}`, brokerIdStr, time.Now().UTC().Format(time.RFC3339), metricsInfo))
+	b.SubmitPublishPacketsWorkTask(packet)
+}
+
+func (b *Broker) TenantWorkPoolMetricsNotification(brokerIdStr string, tenant string, statsInfo string, metricsInfo string) {
+	packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	packet.TopicName = "$SYS/metrics/fixed_worker_pool/broker/" + brokerIdStr + "/tenant/" + tenant
+	packet.Qos = QosAtMostOnce
+	packet.Payload = []byte(fmt.Sprintf(`{"broker_id":"%s","tenant":"%s","timestamp":"%s","stats":%s,"metrics":%s}`, brokerIdStr, tenant, time.Now().UTC().Format(time.RFC3339), statsInfo, metricsInfo))
+	b.SubmitPublishPacketsWorkTask(packet)
+}
+
+func (b *Broker) RelayMetricsNotification(brokerIdStr string, linksInfo string) {
+	packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	packet.TopicName = "$SYS/metrics/relay/broker/" + brokerIdStr
+	packet.Qos = QosAtMostOnce
+	packet.Payload = []byte(fmt.Sprintf(`{"broker_id":"%s","timestamp":"%s","links":%s}`, brokerIdStr, time.Now().UTC().Format(time.RFC3339), linksInfo))
+	b.SubmitPublishPacketsWorkTask(packet)
+}
+
+func (b *Broker) CertificateMetricsNotification(brokerIdStr string, certificateInfo string) {
+	packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	packet.TopicName = "$SYS/metrics/certificate/broker/" + brokerIdStr
+	packet.Qos = QosAtMostOnce
+	packet.Payload = []byte(fmt.Sprintf(`{"broker_id":"%s","timestamp":"%s","certificate":%s}`, brokerIdStr, time.Now().UTC().Format(time.RFC3339), certificateInfo))
+	b.SubmitPublishPacketsWorkTask(packet)
+}
+
+func (b *Broker) CertificateAlertNotification(brokerIdStr string, kind string, alertInfo string) {
+	packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	packet.TopicName = "$SYS/alerts/certificate/" + kind + "/broker/" + brokerIdStr
+	packet.Qos = QosAtMostOnce
+	packet.Payload = []byte(fmt.Sprintf(`{"broker_id":"%s","timestamp":"%s","alert":%s}`, brokerIdStr, time.Now().UTC().Format(time.RFC3339), alertInfo))
+	b.SubmitPublishPacketsWorkTask(packet)
+}
+
+func (b *Broker) QoSReportNotification(brokerIdStr string, reportInfo string) {
+	packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	packet.TopicName = "$SYS/reports/qos/broker/" + brokerIdStr
+	packet.Qos = QosAtMostOnce
+	packet.Payload = []byte(fmt.Sprintf(`{"broker_id":"%s","timestamp":"%s","report":%s}`, brokerIdStr, time.Now().UTC().Format(time.RFC3339), reportInfo))
+	b.SubmitPublishPacketsWorkTask(packet)
+}
+
+func (b *Broker) RetainedMetricsNotification(brokerIdStr string, statsInfo string, limitsInfo string) {
+	packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	packet.TopicName = "$SYS/metrics/retained/broker/" + brokerIdStr
+	packet.Qos = QosAtMostOnce
+	if len(limitsInfo) > 0 {
+		packet.Payload = []byte(fmt.Sprintf(`{"broker_id":"%s","timestamp":"%s","topics":%s,"limits":%s}`, brokerIdStr, time.Now().UTC().Format(time.RFC3339), statsInfo, limitsInfo))
+	} else {
+		packet.Payload = []byte(fmt.Sprintf(`{"broker_id":"%s","timestamp":"%s","topics":%s}`, brokerIdStr, time.Now().UTC().Format(time.RFC3339), statsInfo))
+	}
+	b.SubmitPublishPacketsWorkTask(packet)
+}
+
+func (b *Broker) TopicsMetricsNotification(brokerIdStr string, metricsInfo string) {
+	packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	packet.TopicName = "$SYS/metrics/topics/broker/" + brokerIdStr
+	packet.Qos = QosAtMostOnce
+	packet.Payload = []byte(fmt.Sprintf(`{"broker_id":"%s","timestamp":"%s","provider":%s}`, brokerIdStr, time.Now().UTC().Format(time.RFC3339), metricsInfo))
+	b.SubmitPublishPacketsWorkTask(packet)
+}
@@ -0,0 +1,344 @@
+package broker_core_module
+
+import (
+	"sort"
+
+	"awesomeProject/beacon/mqtt_network/libs/logging"
+	"awesomeProject/beacon/mqtt_network/libs/sessions"
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// The messages queued for an offline client, the oldest ones are dropped past it
+const defaultOfflineQueue = 1000
+
+// offlineSubscription holds a filter of a persistent session while its client is offline, the QoS
+// 1 and 2 messages are queued to the session. The QoS 0 ones are not kept.
+type offlineSubscription struct {
+	broker   *Broker
+	clientID string
+	session  *sessions.Session
+	filter   string
+	qos      byte
+}
+
+// ID is the client id, the subscription of the client replaces the offline one once it's back.
+func (s *offlineSubscription) ID() string {
+	return s.clientID
+}
+
+func (s *offlineSubscription) OnPublish(message *packets.PublishPacket) error {
+	s.deliver(s.broker, message)
+	return nil
+}
+
+func (s *offlineSubscription) deliver(b *Broker, packet *packets.PublishPacket) {
+	qos := packet.Qos
+	if s.qos < qos {
+		qos = s.qos
+	}
+	if qos == QosAtMostOnce {
+		return
+	}
+
+	m := sessions.Message{
+		Filter:  s.filter,
+		Topic:   packet.TopicName,
+		Qos:     qos,
+		Payload: packet.Payload,
+	}
+	b.compressQueued(&m)
+	if oldest, dropped := s.session.Push(m, b.offlineQueue); dropped {
+		b.qosReport.Dropped(s.filter, 1)
+		b.deadLetterQueued(s.clientID, oldest)
+	}
+	b.saveSession(s.clientID)
+}
+
+// persistentSession reports whether the session of the client outlives the connection: the
+// session of a 3.1.1 client without clean session, of a 5.0 client with a session expiry interval.
+func (c *client) persistentSession() bool {
+	if c.session == nil {
+		return false
+	}
+	if c.isV5() {
+		return c.info.sessionExpiry != 0
+	}
+	return !c.info.cleanSession
+}
+
+// parkSession keeps the state of the closed connection in its session: the unacknowledged
+// deliveries are sent again to the next connection, and the subscriptions queue the messages
+// until then. A connection taken over leaves its subscriptions to the new one.
+func (b *Broker) parkSession(c *client) {
+	// the session may have been replaced by a clean one
+	if s, err := b.sessionManager.Get(c.info.clientID); err != nil || s != c.session {
+		c.dropInflight()
+		return
+	}
+
+	inflight := c.takeInflight()
+	ids := make([]int, 0, len(inflight))
+	for id := range inflight {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		d := inflight[uint16(id)]
+		m := sessions.Message{
+			Filter:    d.filter,
+			Topic:     d.packet.TopicName,
+			Qos:       d.packet.Qos,
+			MessageID: d.packet.MessageID,
+			Payload:   d.packet.Payload,
+			Released:  d.released,
+		}
+		b.compressQueued(&m)
+		c.session.AddInflight(m)
+	}
+	b.parkOutbound(c)
+
+	if !c.takenOver {
+		b.parkSubscriptions(c.info.clientID, c.session)
+	}
+	b.saveSession(c.info.clientID)
+}
+
+// parkSubscriptions subscribes the filters of the session to queue its messages, the shared
+// subscriptions are left to the other members of their group. The peer brokers already forward
+// the filters to this broker.
+func (b *Broker) parkSubscriptions(clientID string, session *sessions.Session) []*offlineSubscription {
+	parked := b.subscribeOffline(b.offlineSubscriptions(clientID, session))
+	b.parked.Store(clientID, parked)
+	return parked
+}
+
+func (b *Broker) offlineSubscriptions(clientID string, session *sessions.Session) []*offlineSubscription {
+	filters, qosList, err := session.Topics()
+	if err != nil {
+		return nil
+	}
+
+	var list []*offlineSubscription
+	for i, filter := range filters {
+		if _, _, share, err := topics.ParseSharedFilter([]byte(filter)); err != nil || share {
+			continue
+		}
+		list = append(list, &offlineSubscription{broker: b, clientID: clientID, session: session, filter: filter, qos: qosList[i]})
+	}
+	return list
+}
+
+// subscribeOffline subscribes the offline subscriptions as one batch, it returns the subscribed
+// ones.
+func (b *Broker) subscribeOffline(list []*offlineSubscription) []*offlineSubscription {
+	subs := make([]topics.Subscription, len(list))
+	for i, s := range list {
+		subs[i] = topics.Subscription{Filter: []byte(s.filter), Qos: s.qos, Subscriber: s}
+	}
+	_, errs := b.resubscribe(subs)
+
+	subscribed := list[:0:0]
+	for i, s := range list {
+		if errs[i] != nil {
+			b.logger.Error("core_module/broker_offline/subscribeOffline: subscribe error, ",
+				zap.Error(errs[i]),
+				logging.ClientID(s.clientID),
+				zap.String("filter", s.filter),
+			)
+			continue
+		}
+		subscribed = append(subscribed, s)
+	}
+	return subscribed
+}
+
+// unparkSubscriptions removes the offline subscriptions of the client, they are forwarded by the
+// peer brokers until forget is set.
+func (b *Broker) unparkSubscriptions(clientID string, forget bool) {
+	v, ok := b.parked.Load(clientID)
+	if !ok {
+		return
+	}
+	b.parked.Delete(clientID)
+
+	for _, s := range v.([]*offlineSubscription) {
+		_ = b.topicsManager.Unsubscribe([]byte(s.filter), s)
+		if forget {
+			b.brokerNode.ProcessSubNumMapForDel(s.filter)
+		}
+	}
+}
+
+// parkStoredSessions queues the messages of the persistent sessions loaded at start, until their
+// clients connect. The subscriptions of all the sessions are subscribed as one batch.
+func (b *Broker) parkStoredSessions() {
+	var list []*offlineSubscription
+	for _, cid := range b.sessionManager.IDs() {
+		session, err := b.sessionManager.Get(cid)
+		if err != nil {
+			continue
+		}
+		if _, online := b.clients.Load(cid); online {
+			continue
+		}
+		b.parked.Store(cid, []*offlineSubscription(nil))
+		list = append(list, b.offlineSubscriptions(cid, session)...)
+	}
+
+	parked := make(map[string][]*offlineSubscription)
+	for _, s := range b.subscribeOffline(list) {
+		parked[s.clientID] = append(parked[s.clientID], s)
+		b.brokerNode.ProcessSubNumMapForAdd(s.filter)
+	}
+	for cid, subs := range parked {
+		b.parked.Store(cid, subs)
+	}
+}
+
+// resumeSession takes the present session over for the new connection: its subscriptions are
+// restored, the deliveries left unacknowledged are sent again, then the queued messages.
+func (b *Broker) resumeSession(c *client) {
+	cid := c.info.clientID
+
+	v, _ := b.parked.Load(cid)
+	b.unparkSubscriptions(cid, false)
+	c.restoreSubscriptions()
+	if v != nil {
+		// after the restored ones are counted, the peer brokers never stop forwarding meanwhile
+		for _, s := range v.([]*offlineSubscription) {
+			b.brokerNode.ProcessSubNumMapForDel(s.filter)
+		}
+	}
+
+	for _, m := range c.session.TakeInflight() {
+		c.resendInflight(m)
+	}
+
+	queue := c.session.TakeQueue()
+	for _, m := range queue {
+		payload, ok := c.queuedPayload(m)
+		if !ok {
+			continue
+		}
+		packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		packet.TopicName = m.Topic
+		packet.Qos = m.Qos
+		packet.Payload = payload
+		if err := c.deliver(packet, m.Filter); err != nil {
+			c.logger.Error("core_module/broker_offline/resumeSession: deliver queued message error, ",
+				zap.Error(err),
+				logging.ClientID(cid),
+			)
+		}
+	}
+	if len(queue) > 0 {
+		c.logger.Info("core_module/broker_offline/resumeSession: delivered the queued messages ",
+			logging.ClientID(cid),
+			zap.Int("count", len(queue)),
+		)
+	}
+	b.saveSession(cid)
+}
+
+// restoreSubscriptions subscribes the client to the filters of its session, which are still
+// checked against the ACL. The retained messages are not sent again.
+func (c *client) restoreSubscriptions() {
+	b := c.broker
+	filters, qosList, err := c.session.Topics()
+	if err != nil {
+		return
+	}
+
+	var (
+		list []*subscription
+		subs []topics.Subscription
+	)
+	for i, t := range filters {
+		groupName, filter, share, err := topics.ParseSharedFilter([]byte(t))
+		if err != nil {
+			continue
+		}
+		if !c.allowSubscribe(string(filter)) {
+			_ = c.session.RemoveTopic(t)
+			continue
+		}
+
+		sub := &subscription{
+			client:    c,
+			topic:     t,
+			qos:       qosList[i],
+			share:     share,
+			groupName: groupName,
+		}
+		c.setSharePriority(sub, nil)
+		list = append(list, sub)
+		subs = append(subs, topics.Subscription{Filter: []byte(t), Qos: qosList[i], Subscriber: sub})
+	}
+
+	// the restores of the clients reconnecting together are inserted in batches
+	_, errs := b.resubscribe(subs)
+	for i, sub := range list {
+		if errs[i] != nil {
+			c.logger.Error("core_module/broker_offline/restoreSubscriptions: subscribe error, ",
+				zap.Error(errs[i]),
+				zap.String("filter", sub.topic),
+			)
+			continue
+		}
+		c.subscriptionMap[sub.topic] = sub
+		b.sysStats.Subscribed(1)
+		b.brokerNode.ProcessSubNumMapForAdd(sub.topic)
+	}
+}
+
+// resendInflight sends again the delivery left unacknowledged, with the DUP flag and the same
+// packet id. The PUBREL is sent instead for a QoS 2 message the client has received.
+func (c *client) resendInflight(m sessions.Message) {
+	if c.session.PacketIDs != nil {
+		c.session.PacketIDs.Reserve(m.MessageID)
+	}
+
+	payload, ok := c.queuedPayload(m)
+	if !ok {
+		return
+	}
+	packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	packet.TopicName = m.Topic
+	packet.Qos = m.Qos
+	packet.MessageID = m.MessageID
+	packet.Payload = payload
+	packet.Dup = true
+	c.trackInflight(packet, m.Filter, nil)
+
+	var err error
+	if m.Released {
+		c.releaseInflight(m.MessageID)
+		pubRel := packets.NewControlPacket(packets.Pubrel).(*packets.PubrelPacket)
+		pubRel.MessageID = m.MessageID
+		err = c.WriterPacket(pubRel)
+	} else {
+		if c.broker != nil {
+			c.broker.qosReport.Attempt(m.Filter, true)
+		}
+		err = c.WriterPacket(packet)
+	}
+	if err != nil {
+		c.logger.Error("core_module/broker_offline/resendInflight: send error, ",
+			zap.Error(err),
+		)
+	}
+}
+
+// saveSession persists the session if the provider writes them, it's only called for the
+// persistent sessions.
+func (b *Broker) saveSession(clientID string) {
+	if err := b.sessionManager.Save(clientID); err != nil {
+		b.logger.Error("core_module/broker_offline/saveSession: save session error, ",
+			zap.Error(err),
+			logging.ClientID(clientID),
+		)
+	}
+}
@@ -2,13 +2,39 @@ package broker_core_module
 
 import (
 	"net"
+	"os"
+	"time"
 
 	"awesomeProject/beacon/general_toolbox/logger"
 
+	"awesomeProject/beacon/mqtt_network/libs/acl"
+	"awesomeProject/beacon/mqtt_network/libs/auth"
+	"awesomeProject/beacon/mqtt_network/libs/autosub"
+	"awesomeProject/beacon/mqtt_network/libs/bridge"
+	"awesomeProject/beacon/mqtt_network/libs/certmon"
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+	"awesomeProject/beacon/mqtt_network/libs/compress"
+	"awesomeProject/beacon/mqtt_network/libs/computed"
+	"awesomeProject/beacon/mqtt_network/libs/config"
+	"awesomeProject/beacon/mqtt_network/libs/gctune"
+	"awesomeProject/beacon/mqtt_network/libs/keepalive"
+	"awesomeProject/beacon/mqtt_network/libs/memacct"
+	"awesomeProject/beacon/mqtt_network/libs/namespace"
+	"awesomeProject/beacon/mqtt_network/libs/outbound"
 	"awesomeProject/beacon/mqtt_network/libs/pool"
+	"awesomeProject/beacon/mqtt_network/libs/quota"
+	"awesomeProject/beacon/mqtt_network/libs/receipts"
+	"awesomeProject/beacon/mqtt_network/libs/relay"
+	"awesomeProject/beacon/mqtt_network/libs/replay"
+	"awesomeProject/beacon/mqtt_network/libs/sampling"
+	"awesomeProject/beacon/mqtt_network/libs/schema"
 	"awesomeProject/beacon/mqtt_network/libs/sessions"
+	"awesomeProject/beacon/mqtt_network/libs/statelog"
+	"awesomeProject/beacon/mqtt_network/libs/topiclog"
 	"awesomeProject/beacon/mqtt_network/libs/topics"
 	"awesomeProject/beacon/mqtt_network/libs/topics_p2p"
+	"awesomeProject/beacon/mqtt_network/libs/tracing"
+	"awesomeProject/beacon/mqtt_network/libs/transform"
 
 	p2p "awesomeProject/beacon/p2p_network/core_module"
 	"awesomeProject/beacon/p2p_network/libs/cryptographic"
@@ -32,30 +58,677 @@ func WithBrokerLogger(log *zap.Logger) BrokerOption {
 	}
 }
 
+// WithConfigFile configures the broker with the YAML or JSON file of the config package, the
+// options after it override the file. The file is reloaded once it changes, or by ReloadConfig.
+func WithConfigFile(path string) BrokerOption {
+	return func(b *Broker) {
+		cfg, err := config.Load(path)
+		if err == nil {
+			err = b.applyConfig(cfg)
+		}
+		if err != nil {
+			b.configErr = err
+			return
+		}
+		if fi, err := os.Stat(path); err == nil {
+			b.configModTime = fi.ModTime()
+		}
+		b.configFile = path
+		b.config = cfg
+	}
+}
+
 func WithFixedWorkPool(maxWorkers uint16) BrokerOption {
 	return func(b *Broker) {
 		b.fixedWorkPool = pool.NewFixedWorkPool(maxWorkers)
 	}
 }
 
+// WithInboundPriority processes the control packets of the clients, such as PINGREQ and the
+// acknowledgements, ahead of their publishes. The workers run a waiting publish after each burst
+// of control packets, so they cannot starve the publishes. A burst of 0 processes all the packets
+// in the order they are received. It's pool.DefaultPriorityBurst by default.
+func WithInboundPriority(burst int) BrokerOption {
+	return func(b *Broker) {
+		b.inboundPriorityBurst = burst
+	}
+}
+
+// WithTopicAliasMaximum sets the number of topic aliases a 5.0 client may set on a connection, 0
+// disables the topic aliases. It's 16 by default.
+func WithTopicAliasMaximum(max uint16) BrokerOption {
+	return func(b *Broker) {
+		b.topicAliasMaximum = max
+	}
+}
+
+// WithRetainedTTL expires the retained messages published without a message expiry interval after
+// the ttl, 0 keeps them until they are replaced or cleared.
+func WithRetainedTTL(ttl time.Duration) BrokerOption {
+	return func(b *Broker) {
+		b.retainedTTL = ttl
+	}
+}
+
+// WithRetainedReplication replicates the retained messages to the other brokers, so they all
+// keep the same retained messages. The brokers converge after a partition, the last retained
+// message published wins.
+func WithRetainedReplication(enable bool) BrokerOption {
+	return func(b *Broker) {
+		b.replicateRetained = enable
+	}
+}
+
+// WithTLS serves the MQTT listener over TLS with the certificate, which is watched for its expiry
+// and reloaded when its files change. The OCSP response is stapled if cfg.OCSP is set or the
+// certificate is must-staple.
+func WithTLS(cfg certmon.Config) BrokerOption {
+	return func(b *Broker) {
+		b.tlsConfig = &cfg
+	}
+}
+
+// WithCertificateIdentity authenticates the clients presenting a certificate issued by the client
+// CAs of the TLS listener with it, the name in the certificate replaces their username or client
+// id, or both, and no password is asked.
+func WithCertificateIdentity(cfg CertIdentity) BrokerOption {
+	return func(b *Broker) {
+		b.certIdentity = &cfg
+	}
+}
+
+// WithWebSocket serves MQTT over WebSocket besides the TCP listener.
+func WithWebSocket(cfg WebSocketConfig) BrokerOption {
+	return func(b *Broker) {
+		b.wsConfig = &cfg
+	}
+}
+
+// WithAdminAPI serves the admin HTTP API on its own listener, authenticated by the token.
+func WithAdminAPI(cfg AdminConfig) BrokerOption {
+	return func(b *Broker) {
+		b.adminConfig = &cfg
+	}
+}
+
+// WithHTTPGateway serves the HTTP publish gateway on its own listener, authenticated by the token.
+func WithHTTPGateway(cfg GatewayConfig) BrokerOption {
+	return func(b *Broker) {
+		b.gatewayConfig = &cfg
+	}
+}
+
+// WithMQTTSNGateway serves the MQTT-SN gateway of the constrained devices on a UDP listener.
+func WithMQTTSNGateway(cfg MQTTSNConfig) BrokerOption {
+	return func(b *Broker) {
+		b.mqttsnConfig = &cfg
+	}
+}
+
+// WithQUIC serves MQTT over QUIC besides the TCP listener.
+func WithQUIC(cfg QUICConfig) BrokerOption {
+	return func(b *Broker) {
+		b.quicConfig = &cfg
+	}
+}
+
+// WithListeners serves the listeners of the config besides the TCP listener.
+func WithListeners(cfg ListenersConfig) BrokerOption {
+	return func(b *Broker) {
+		b.wsConfig = cfg.WebSocket
+		b.quicConfig = cfg.QUIC
+	}
+}
+
+// WithBridge connects the broker to a remote broker, the topics of the rules are forwarded both
+// ways as configured.
+func WithBridge(cfg bridge.Config) BrokerOption {
+	return func(b *Broker) {
+		b.bridgeConfigs = append(b.bridgeConfigs, cfg)
+	}
+}
+
+// WithDeliveryReceipts publishes a receipt to the publisher of a QoS 1 or 2 message on the filters
+// of the config, once its subscribers have all acknowledged it or at the timeout.
+func WithDeliveryReceipts(cfg receipts.Config) BrokerOption {
+	return func(b *Broker) {
+		b.receiptConfig = &cfg
+	}
+}
+
+// WithRelay enables the relay role: the broker forwards the peer messages between the peers which
+// cannot reach each other, within the limits of the config.
+func WithRelay(cfg relay.Config) BrokerOption {
+	return func(b *Broker) {
+		b.relayConfig = &cfg
+	}
+}
+
+// WithPeerSecret seals the messages between the peer nodes with the secret shared by the nodes of
+// the cluster, the messages of the nodes without it are refused. It's at least seal.MinSecret
+// bytes.
+func WithPeerSecret(secret []byte) BrokerOption {
+	return func(b *Broker) {
+		b.peerSecret = append([]byte(nil), secret...)
+	}
+}
+
+// WithRelayRoutes sends the peer messages for the target node addresses (the keys) through the
+// relay node addresses (the values), the broker is not created if a route is invalid.
+func WithRelayRoutes(routes map[string]string) BrokerOption {
+	return func(b *Broker) {
+		for target, via := range routes {
+			if err := b.SetRelayRoute(target, via); err != nil {
+				b.configErr = err
+				return
+			}
+		}
+	}
+}
+
+// WithTenants gives each tenant its own workers and memory budget for the packets of its clients,
+// the clients of no tenant share the fixed work pool.
+func WithTenants(tenants ...pool.Tenant) BrokerOption {
+	return func(b *Broker) {
+		b.tenants = append(b.tenants, tenants...)
+	}
+}
+
 func WithTopicsManager(providerName string) BrokerOption {
 	return func(b *Broker) {
 		b.topicsManager, _ = topics.NewManager(providerName)
 	}
 }
 
+// WithTopicsFile persists the subscriptions and the retained messages to the BoltDB file at the
+// path, they are restored when the broker restarts. It's ignored if WithTopicsManager is set.
+func WithTopicsFile(path string) BrokerOption {
+	return func(b *Broker) {
+		b.topicsFile = path
+	}
+}
+
+// WithSubscriberSets bounds the subscribers of each filter in the in-memory topics provider, 0 is
+// unlimited. It's ignored if WithTopicsManager or WithTopicsFile is set.
+func WithSubscriberSets(max int) BrokerOption {
+	return func(b *Broker) {
+		b.memTopicsOptions = append(b.memTopicsOptions, topics.WithSubscriberSets(max))
+	}
+}
+
+// WithMatchCache caches the subscribers matched by the publish topics in the in-memory topics
+// provider, for the topics published repeatedly. The least recently used topics are evicted past
+// maxEntries or past maxBytes of estimated memory, 0 is unlimited. It's ignored if
+// WithTopicsManager or WithTopicsFile is set.
+func WithMatchCache(maxEntries int, maxBytes int) BrokerOption {
+	return func(b *Broker) {
+		b.memTopicsOptions = append(b.memTopicsOptions, topics.WithMatchCache(maxEntries, maxBytes))
+	}
+}
+
+// WithRetainLimits caps the retained store of the in-memory topics provider, by messages, by bytes
+// and by payload, with the eviction policy applied to the messages retained past the caps. It's
+// ignored if WithTopicsManager or WithTopicsFile is set.
+func WithRetainLimits(limits topics.RetainLimits) BrokerOption {
+	return func(b *Broker) {
+		b.retainLimits = &limits
+	}
+}
+
+// WithFilterLimits bounds the subscription filters of the in-memory topics provider, by levels, by
+// length and by wildcards; the subscriptions past them get a SUBACK failure. The subscriptions of
+// each client are capped by the MaxSubscriptions of the client limits. It's ignored if
+// WithTopicsManager or WithTopicsFile is set.
+func WithFilterLimits(limits topics.FilterLimits) BrokerOption {
+	return func(b *Broker) {
+		b.filterLimits = &limits
+	}
+}
+
+// WithKeepalive sets the idle timeout closing the connections which sent nothing but PINGREQ for
+// its duration, and the resolution and the jitter of the timer wheels checking the connections.
+// The connections silent for 1.5 times their keepalive are closed in any case.
+func WithKeepalive(cfg keepalive.Config) BrokerOption {
+	return func(b *Broker) {
+		b.keepaliveConfig = cfg
+	}
+}
+
+// WithTracer traces the publishes through the broker with the tracer, a span per publish with
+// the lookup of its subscribers and its deliveries as children. The traceparent user property of a
+// 5.0 publish is the parent of its span, and the span goes on in the peer brokers it's forwarded
+// to.
+func WithTracer(t tracing.Tracer) BrokerOption {
+	return func(b *Broker) {
+		b.tracer = t
+	}
+}
+
+// WithOutboundQueues queues the deliveries of each client to a writer of its own, so a slow
+// subscriber doesn't stall the publishers fanning the messages out. cfg bounds the queue and the
+// QoS 1 and 2 messages waiting for their acknowledgement, and sets the policy applied to the
+// messages delivered while the queue is full. The batched deliveries are not queued.
+func WithOutboundQueues(cfg outbound.Config) BrokerOption {
+	return func(b *Broker) {
+		b.outboundConfig = &cfg
+	}
+}
+
 func WithSessionsManager(providerName string) BrokerOption {
 	return func(b *Broker) {
 		b.sessionManager, _ = sessions.NewManager(providerName)
 	}
 }
 
+// WithPacketIDFile persists to the file where the packet ids of the persistent sessions resume, so
+// the ids issued after a restart don't collide with the ones the clients still hold inflight.
+func WithPacketIDFile(path string) BrokerOption {
+	return func(b *Broker) {
+		b.packetIDFile = path
+	}
+}
+
+// WithSessionsDir persists the sessions to the directory, so the subscriptions, the unacknowledged
+// deliveries and the queued messages of the persistent sessions survive a restart. It's ignored if
+// WithSessionsManager is set.
+func WithSessionsDir(dir string) BrokerOption {
+	return func(b *Broker) {
+		b.sessionsDir = dir
+	}
+}
+
+// WithOfflineQueue bounds the messages queued for each offline client of a persistent session, the
+// oldest ones are dropped past it. It's 1000 by default, 0 is unlimited.
+func WithOfflineQueue(max int) BrokerOption {
+	return func(b *Broker) {
+		b.offlineQueue = max
+	}
+}
+
+// WithSharePriorities sets the priorities of the share group members, the first matching one
+// applies.
+func WithSharePriorities(priorities ...SharePriority) BrokerOption {
+	return func(b *Broker) {
+		b.sharePriorities = append(b.sharePriorities, priorities...)
+	}
+}
+
+// WithShareSaturation sets the unacknowledged deliveries from which a member of a share group is
+// saturated, the messages go to the other members meanwhile. It's 100 by default, 0 never
+// saturates them.
+func WithShareSaturation(inflight int) BrokerOption {
+	return func(b *Broker) {
+		b.shareSaturation = inflight
+	}
+}
+
+// WithDowngradePolicy sets how the properties of a 5.0 publish, which a 3.1.1 subscriber cannot
+// receive, are delivered to it: mqtt5.DowngradeStrip (by default), DowngradeEnvelope or
+// DowngradeSkip.
+func WithDowngradePolicy(policy string) BrokerOption {
+	return func(b *Broker) {
+		b.downgradePolicy = policy
+	}
+}
+
+// WithLocalTopics marks the topics of the filters as node-local, such as local/#: they are never
+// forwarded to the peer brokers. The $SYS topics are always local.
+func WithLocalTopics(filters ...string) BrokerOption {
+	return func(b *Broker) {
+		b.localTopics = append(append([]string(nil), b.localTopics...), filters...)
+	}
+}
+
+// WithOrderedDelivery delivers the publishes on the topics of the filters in order: the publishes
+// of a client on a topic are processed one at a time, by the same worker, so each subscriber gets
+// them in the order they were published. It trades the throughput of a busy topic, which no longer
+// spreads over the workers, for the ordering.
+func WithOrderedDelivery(filters ...string) BrokerOption {
+	return func(b *Broker) {
+		b.orderedFilters = append(b.orderedFilters, filters...)
+	}
+}
+
+// WithCommandRouting makes the topics under the prefixes command topics, <prefix>/<device>/...:
+// the messages of a device are only delivered by the broker owning it, chosen by consistent
+// hashing over the brokers of the cluster, so they are processed in order on one broker. The
+// devices are rebalanced when the brokers join or leave.
+func WithCommandRouting(prefixes ...string) BrokerOption {
+	return func(b *Broker) {
+		b.commandPrefixes = append(b.commandPrefixes, prefixes...)
+	}
+}
+
+// WithWillDelay delays the will messages of the 3.1.1 clients and of the 5.0 clients setting no
+// will delay interval, they are not published if the client connects again in time. It's 0 by
+// default.
+func WithWillDelay(delay time.Duration) BrokerOption {
+	return func(b *Broker) {
+		b.defaultWillDelay = delay
+	}
+}
+
+// WithGCTuning paces the garbage collector under the soft memory limit of the config, the GOGC is
+// set again at each interval from the live heap and the memory held by the queues of the tenants
+// and the retained messages, 10 seconds if it's 0.
+func WithGCTuning(cfg gctune.Config, interval time.Duration) BrokerOption {
+	return func(b *Broker) {
+		b.gcTuning = &cfg
+		b.gcInterval = interval
+	}
+}
+
+// WithSysInterval publishes the statistics of the broker to the $SYS/broker/ topics at the
+// interval, 10 seconds by default, 0 disables them.
+func WithSysInterval(interval time.Duration) BrokerOption {
+	return func(b *Broker) {
+		b.sysInterval = interval
+	}
+}
+
+// WithAuthManager checks the credentials of the CONNECT packets with the registered auth provider,
+// the connections are all accepted if it's not set. The broker is not created if the provider is
+// not registered.
+func WithAuthManager(providerName string) BrokerOption {
+	return func(b *Broker) {
+		manager, err := auth.NewManager(providerName)
+		if err != nil {
+			b.configErr = err
+			return
+		}
+		b.authManager = manager
+	}
+}
+
+// WithCompression compresses the payloads of the retained messages and of the messages queued to
+// the offline sessions from the threshold of the config, or of the policy of their topic prefix;
+// they are decompressed once delivered.
+func WithCompression(cfg compress.Config) BrokerOption {
+	return func(b *Broker) {
+		b.compressionConfig = &cfg
+	}
+}
+
+// WithTopicLog logs the QoS 1 and 2 publishes on the topics matching the filters of the config,
+// so the clients replay them since a time or an offset with TopicLogReplayTopic.
+func WithTopicLog(cfg topiclog.Config) BrokerOption {
+	return func(b *Broker) {
+		b.topicLogConfig = &cfg
+	}
+}
+
+// WithStateLog records the changes of the subscriptions, the retained messages, the sessions and
+// the config in the state log, for the external mirrors of the broker state.
+func WithStateLog(cfg statelog.Config) BrokerOption {
+	return func(b *Broker) {
+		b.stateLogConfig = &cfg
+	}
+}
+
+// WithClientLimits limits the publish rates, the inflight publishes, the subscriptions and the
+// payload size of each client, the auth provider may override them by username. The clients over
+// their limits are throttled or disconnected as the limits' Action says.
+func WithClientLimits(limits quota.Limits) BrokerOption {
+	return func(b *Broker) {
+		b.limits = limits
+	}
+}
+
+// WithPayloadLimits bounds the payload size of the publishes by topic filter, such as telemetry/#
+// to 4KB and files/# to 5MB, the most specific filter matching the topic applies. The publishes
+// over the limit of their topic are refused whatever the limits of the client are.
+func WithPayloadLimits(limits ...quota.PayloadLimit) BrokerOption {
+	return func(b *Broker) {
+		b.payloadLimitList = append(b.payloadLimitList, limits...)
+	}
+}
+
+// WithSchemaValidation validates the payloads of the publishes against the schema bound to the
+// longest prefix of their topic, a JSON Schema or a Protobuf message type linked in the broker. The
+// invalid publishes are refused with the payload format invalid reason, and dead-lettered if the
+// dead letters are enabled.
+func WithSchemaValidation(bindings ...schema.Binding) BrokerOption {
+	return func(b *Broker) {
+		b.schemaBindings = append(b.schemaBindings, bindings...)
+	}
+}
+
+// WithSessionHandover hands the persistent sessions over to the peer brokers on Shutdown, the
+// client reconnecting to one of them within the window resumes its session there.
+func WithSessionHandover(window time.Duration) BrokerOption {
+	return func(b *Broker) {
+		b.handoverWindow = window
+	}
+}
+
+// WithServerReference sends the 5.0 clients to the server on Shutdown, with the use another server
+// reason of the DISCONNECT.
+func WithServerReference(reference string) BrokerOption {
+	return func(b *Broker) {
+		b.serverReference = reference
+	}
+}
+
+// WithPlugins runs the hooks of the registered plugins, in the given order, on the connects, the
+// subscriptions, the publishes, the deliveries and the disconnects of the clients.
+func WithPlugins(names ...string) BrokerOption {
+	return func(b *Broker) {
+		b.pluginNames = append(b.pluginNames, names...)
+	}
+}
+
+// WithACLFile authorizes the publishes and the subscriptions with the topic ACL of the JSON or YAML
+// file, which is reloaded once it changes.
+func WithACLFile(path string) BrokerOption {
+	return func(b *Broker) {
+		b.aclFile = path
+	}
+}
+
+// WithTopicRewrite rewrites the topics of the inbound publishes and the filters of the
+// subscriptions with the rules of the JSON or YAML file, which is reloaded once it changes.
+func WithTopicRewrite(path string) BrokerOption {
+	return func(b *Broker) {
+		b.rewriteFile = path
+	}
+}
+
+// WithDeadLetters republishes the messages the broker drops, overflowing a queue, denied or
+// expired, to $deadletter/<reason>/<topic> with their metadata.
+func WithDeadLetters(cfg DeadLetterConfig) BrokerOption {
+	return func(b *Broker) {
+		b.deadLetterConfig = &cfg
+	}
+}
+
+// WithControlTopics lets the admin clients of the config list the subscriptions of a client,
+// remove one of them or disconnect the client, by publishing to $CONTROL/clients/<id>/<action>.
+// The replies are delivered to the admin client on the request topic followed by /reply.
+func WithControlTopics(cfg ControlConfig) BrokerOption {
+	return func(b *Broker) {
+		b.controlConfig = &cfg
+	}
+}
+
+// WithTenantNamespaces isolates the tenants in the namespaces of their topics: the clients of a
+// tenant, picked by their listener or their username, publish and subscribe below its prefix
+// without knowing it. The broker isn't created if the prefixes are nested.
+func WithTenantNamespaces(cfg namespace.TenantConfig) BrokerOption {
+	return func(b *Broker) {
+		b.tenantNamespaceConfig = &cfg
+	}
+}
+
+// WithTopicClaims registers the claims of the services on their topics, the broker isn't created
+// if two owners claim the same topics.
+func WithTopicClaims(claims ...acl.Claim) BrokerOption {
+	return func(b *Broker) {
+		b.topicClaims = append(b.topicClaims, claims...)
+	}
+}
+
+// WithReauthentication sets how long before its token expires a token client is asked for a fresh
+// one (a minute by default), and how long after it expired the client is disconnected if it hasn't
+// presented one (30 seconds by default).
+func WithReauthentication(lead time.Duration, grace time.Duration) BrokerOption {
+	return func(b *Broker) {
+		b.reauthLead = lead
+		b.reauthGrace = grace
+	}
+}
+
 func WithTopicsManager4P2P(providerName string) BrokerOption {
 	return func(b *Broker) {
 		b.topicsManager4P2P, _ = topics_p2p.NewManager4P2P(providerName)
 	}
 }
 
+// WithClock sets the time source of the expiry logic, such as the scheduled publishes and the
+// computed topics, the tests and the simulations can fast-forward a clock.Mock.
+func WithClock(c clock.Clock) BrokerOption {
+	return func(b *Broker) {
+		b.clock = clock.OrReal(c)
+	}
+}
+
+// WithPayloadTransforms sets the payload transformation pipelines, the broker is not created if a
+// pipeline is invalid.
+func WithPayloadTransforms(pipelines ...transform.Pipeline) BrokerOption {
+	return func(b *Broker) {
+		if b.transformManager == nil {
+			b.transformManager = transform.NewManager()
+		}
+		for _, p := range pipelines {
+			if err := b.transformManager.Add(p); err != nil {
+				b.configErr = err
+				return
+			}
+		}
+	}
+}
+
+// WithComputedTopics sets the computed topics evaluated and published by the broker, the broker is
+// not created if a definition is invalid.
+func WithComputedTopics(definitions ...computed.Definition) BrokerOption {
+	return func(b *Broker) {
+		for _, def := range definitions {
+			c, err := computed.New(def)
+			if err != nil {
+				b.configErr = err
+				return
+			}
+			b.computedList = append(b.computedList, c)
+		}
+	}
+}
+
+// WithSampling mirrors a stable fraction of the topics matching the filters of the definitions to
+// their analytics topic and to the sample sinks.
+func WithSampling(definitions ...sampling.Definition) BrokerOption {
+	return func(b *Broker) {
+		b.samplingDefs = append(b.samplingDefs, definitions...)
+	}
+}
+
+// WithStoreCheckRepair sets whether the startup consistency check repairs (or quarantines) the broken
+// records of the persisted stores, or only reports them. The repair is enabled by default.
+func WithStoreCheckRepair(repair bool) BrokerOption {
+	return func(b *Broker) {
+		b.storeCheckRepair = repair
+	}
+}
+
+// WithRetainHistory keeps the last versions of the retained messages for the topics matched by
+// the filters, the deepest history of the longest matching filter is applied.
+func WithRetainHistory(depths ...topics.HistoryDepth) BrokerOption {
+	return func(b *Broker) {
+		b.retainHistory = append(b.retainHistory, depths...)
+	}
+}
+
+// WithRecentTopics sets the number of recently published topics kept for ExpandFilter, 0 keeps
+// none so only the retained topics are expanded.
+func WithRecentTopics(limit int) BrokerOption {
+	return func(b *Broker) {
+		b.recentTopics = limit
+	}
+}
+
+// WithAnnotationsFile sets the file where the topic annotations are persisted, they are kept in
+// memory only if it's not set.
+func WithAnnotationsFile(path string) BrokerOption {
+	return func(b *Broker) {
+		b.annotationsFile = path
+	}
+}
+
+// WithBatchPolicy coalesces the deliveries to the clients the policy returns an interval for, the
+// other clients can still opt in by subscribing to the container topic (such as "$batch/500").
+func WithBatchPolicy(policy BatchPolicyFunc) BrokerOption {
+	return func(b *Broker) {
+		b.batchPolicy = policy
+	}
+}
+
+// WithReadOnly makes the broker a read-only replica, it subscribes to all the topics of the peer
+// brokers and refuses the publishes (and the will messages) of its clients.
+func WithReadOnly(readOnly bool) BrokerOption {
+	return func(b *Broker) {
+		b.readOnly = readOnly
+	}
+}
+
+// WithScheduleFile sets the file where the scheduled publishes are persisted, the schedule is kept
+// in memory only if it's not set.
+func WithScheduleFile(path string) BrokerOption {
+	return func(b *Broker) {
+		b.scheduleFile = path
+	}
+}
+
+// WithDelayedFile sets the file where the publishes to $delayed/<seconds>/<topic> are persisted
+// until they're due, they're kept in memory only if it's not set.
+func WithDelayedFile(path string) BrokerOption {
+	return func(b *Broker) {
+		b.delayedFile = path
+	}
+}
+
+// WithMaxDelayedMessages bounds the number of the delayed publishes waiting, the publish beyond is
+// refused with the quota exceeded reason code. They're not bounded by default.
+func WithMaxDelayedMessages(max int) BrokerOption {
+	return func(b *Broker) {
+		b.maxDelayed = max
+	}
+}
+
+// WithMemoryAccounting accounts the memory held for each connection, the buffers, the packets read
+// and the deliveries queued, and the retained messages. Once it reaches the high-water mark of the
+// config the broker refuses the new connections and pauses the reads of the clients, until it falls
+// under the low-water mark.
+func WithMemoryAccounting(cfg memacct.Config) BrokerOption {
+	return func(b *Broker) {
+		b.memoryConfig = &cfg
+	}
+}
+
+// WithAutoSubscriptions subscribes each client to the filters on CONNECT, %c and %u are replaced by
+// its client id and its user name, such as devices/%c/cmd.
+func WithAutoSubscriptions(list ...autosub.Subscription) BrokerOption {
+	return func(b *Broker) {
+		b.autoSubscriptions = append(b.autoSubscriptions, list...)
+	}
+}
+
+// WithConnectReplayProtection rejects the CONNECT replaying a token seen within the window, or
+// carrying a timestamp older than the window (or ahead of the broker clock by more than the skew).
+func WithConnectReplayProtection(window time.Duration, skew time.Duration, tokenFunc ConnectReplayTokenFunc) BrokerOption {
+	return func(b *Broker) {
+		b.replayGuard = replay.NewGuard(window, skew)
+		b.replayToken = tokenFunc
+	}
+}
+
 func WithBrokerBindHost(host net.IP) BrokerOption {
 	return func(b *Broker) {
 		b.host = host
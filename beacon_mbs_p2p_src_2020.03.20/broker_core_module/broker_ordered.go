@@ -0,0 +1,64 @@
+package broker_core_module
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// checkOrderedFilters checks the filters of the ordered topics.
+func checkOrderedFilters(filters []string) error {
+	for _, f := range filters {
+		if err := topics.ValidateTopicFilter([]byte(f)); err != nil {
+			return fmt.Errorf("core_module/broker_ordered/checkOrderedFilters: invalid ordered topic filter %q", f)
+		}
+	}
+	return nil
+}
+
+// orderedKey returns the worker key of the publish on a topic matching the ordered filters, false
+// if its deliveries may be reordered. The publishes of a publisher on a topic share a key, so one
+// worker processes them in the order they were read, and each subscriber gets them in that order
+// through its outbound queue, or its connection.
+func (b *Broker) orderedKey(msg *Message) (uint64, bool) {
+	if len(b.orderedFilters) == 0 {
+		return 0, false
+	}
+	packet, ok := msg.packet.(*packets.PublishPacket)
+	if !ok || !b.orderedTopic(packet.TopicName) {
+		return 0, false
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(msg.client.info.clientID))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(packet.TopicName))
+	return h.Sum64(), true
+}
+
+// orderedTopic reports whether the topic matches one of the ordered filters.
+func (b *Broker) orderedTopic(topic string) bool {
+	for _, f := range b.orderedFilters {
+		if ok, _ := topics.MatchTopic([]byte(f), []byte(topic)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// submitOrderedTask queues the publish on an ordered topic to the worker of its key, it returns
+// false if the topic is not ordered.
+func (b *Broker) submitOrderedTask(msg *Message) bool {
+	key, ok := b.orderedKey(msg)
+	if !ok {
+		return false
+	}
+	b.fixedWorkPool.SubmitKeyedTask(key, func() {
+		b.stageLatency.since(StageQueue, msg.received)
+		ProcessMessage(msg)
+	})
+	return true
+}
@@ -0,0 +1,240 @@
+package broker_core_module
+
+import (
+	"awesomeProject/beacon/mqtt_network/libs/fanout"
+	"awesomeProject/beacon/mqtt_network/libs/memacct"
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+	"awesomeProject/beacon/mqtt_network/libs/outbound"
+	"awesomeProject/beacon/mqtt_network/libs/receipts"
+	"awesomeProject/beacon/mqtt_network/libs/sessions"
+	"awesomeProject/beacon/mqtt_network/libs/tracing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// OutboundStats are the messages dropped by the outbound queues of the clients, and the slow
+// subscribers disconnected by the queues.
+type OutboundStats struct {
+	Dropped      uint64 `json:"dropped"`
+	Disconnected uint64 `json:"disconnected"`
+}
+
+// queuedDelivery is a delivery waiting in the outbound queue of the client. The receipt of a QoS 1
+// or 2 delivery counts it from the time it's queued.
+type queuedDelivery struct {
+	packet  *packets.PublishPacket
+	filter  string
+	ext     *mqtt5.Packet
+	shared  *fanout.Message
+	receipt *receipts.Pending
+	// the span of the publish, and the span of the wait in the queue
+	trace  tracing.SpanContext
+	queued tracing.Span
+	// the size accounted to the client while it's queued
+	size int64
+}
+
+// OutboundStats returns the counters of the outbound queues, false if the clients have none.
+func (b *Broker) OutboundStats() (OutboundStats, bool) {
+	if b.outboundConfig == nil {
+		return OutboundStats{}, false
+	}
+	return OutboundStats{
+		Dropped:      b.outboundDropped.Load(),
+		Disconnected: b.outboundDisconnected.Load(),
+	}, true
+}
+
+// startOutbound starts the writer of the outbound queue of the client, if the broker queues the
+// deliveries.
+func (c *client) startOutbound() {
+	if c.broker == nil || c.broker.outboundConfig == nil {
+		return
+	}
+	q := outbound.New(*c.broker.outboundConfig)
+	c.mu.Lock()
+	c.outq = q
+	c.mu.Unlock()
+	go c.writeLoop(q)
+}
+
+// outboundStats returns the stats of the outbound queue of the client, nil if it has none.
+func (c *client) outboundStats() *outbound.Stats {
+	c.mu.Lock()
+	q := c.outq
+	c.mu.Unlock()
+	if q == nil {
+		return nil
+	}
+	stats := q.Stats()
+	return &stats
+}
+
+// enqueueDelivery queues the delivery to the writer of the client, the QoS 1 and 2 ones take a
+// slot of the inflight window once they're written. A client whose queue refuses the delivery is
+// disconnected.
+func (c *client) enqueueDelivery(q *outbound.Queue, packet *packets.PublishPacket, filter string, ext *mqtt5.Packet, shared *fanout.Message) error {
+	d := &queuedDelivery{packet: packet, filter: filter, ext: ext, shared: shared, trace: c.traceParent(packet)}
+	d.queued = c.broker.startSpan(spanQueue, d.trace)
+	d.size = deliverySize(packet)
+	c.memory.Add(memacct.Queued, d.size)
+	windowed := packet.Qos > QosAtMostOnce
+	if windowed {
+		d.receipt = c.pendingReceipt(packet)
+		d.receipt.Add()
+	}
+
+	dropped, err := q.Push(d, windowed)
+	for _, v := range dropped {
+		c.dropQueued(v.(*queuedDelivery))
+		c.broker.deadLetter(DeadLetterOverflow, c.info.clientID, v.(*queuedDelivery).packet)
+	}
+	if err != nil {
+		c.dropQueued(d)
+		c.broker.deadLetter(DeadLetterOverflow, c.info.clientID, packet)
+		c.disconnectSlow()
+		return err
+	}
+	return nil
+}
+
+// disconnectSlow closes the connection of the slow subscriber once, without a DISCONNECT since the
+// writes of the connection are stalled.
+func (c *client) disconnectSlow() {
+	if !c.slow.CAS(false, true) {
+		return
+	}
+	c.broker.outboundDisconnected.Inc()
+	c.logger.Warn("core_module/broker_outbound/disconnectSlow: the outbound queue of the client is full, disconnect it")
+	go c.Close()
+}
+
+// writeLoop writes the deliveries of the queue in order until the queue is closed. The deliveries
+// ready in the queue are gathered and written at once, in one vectored write, once the queue has
+// none ready left or maxGatheredBytes are gathered.
+func (c *client) writeLoop(q *outbound.Queue) {
+	defer c.uncork()
+	for {
+		v, windowed, ok := q.Pop()
+		if !ok {
+			return
+		}
+		c.cork()
+		c.writeQueued(q, v.(*queuedDelivery), windowed)
+		if !q.Ready() || c.gathered() >= maxGatheredBytes {
+			c.uncork()
+		}
+	}
+}
+
+func (c *client) writeQueued(q *outbound.Queue, d *queuedDelivery, windowed bool) {
+	d.queued.End()
+	c.memory.Release(memacct.Queued, d.size)
+	span := c.deliverySpan(d.trace, d.filter)
+	defer span.End()
+
+	pkt, err := c.outboundPacket(d.packet)
+	if err != nil {
+		span.SetAttribute("error", err.Error())
+		if windowed {
+			q.Release()
+		}
+		c.dropQueued(d)
+		c.logger.Error("core_module/broker_outbound/writeQueued: Error publish to subscriber => ",
+			zap.Error(err),
+		)
+		return
+	}
+
+	// a delivery downgraded to QoS 0 is settled once it's written
+	tracked := pkt != d.packet
+	if tracked {
+		c.trackInflight(pkt, d.filter, nil)
+		c.windowInflight(pkt.MessageID, d.receipt, windowed)
+	} else if windowed {
+		q.Release()
+	}
+
+	if err := c.writePublish(pkt, d.ext, d.shared); err != nil {
+		span.SetAttribute("error", err.Error())
+		if !tracked {
+			d.receipt.Fail(c.broker.clock.Now())
+		} else if f, ok := c.untrackInflight(pkt.MessageID); ok {
+			f.receipt.Fail(c.broker.clock.Now())
+		}
+		c.dropDelivery(d.filter)
+		c.logger.Error("core_module/broker_outbound/writeQueued: Error publish to subscriber => ",
+			zap.Error(err),
+		)
+		return
+	}
+	if !tracked {
+		d.receipt.Ack(c.broker.clock.Now())
+	}
+	c.pluginDeliver(pkt)
+}
+
+// windowInflight hands the slot of the inflight window and the receipt of the queued delivery to
+// its inflight delivery, they're released with it.
+func (c *client) windowInflight(id uint16, receipt *receipts.Pending, windowed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if d, ok := c.inflight[id]; ok {
+		d.receipt = receipt
+		d.windowed = windowed
+	}
+}
+
+// dropQueued counts the queued delivery as dropped.
+func (c *client) dropQueued(d *queuedDelivery) {
+	d.queued.SetAttribute("dropped", true)
+	d.queued.End()
+	c.memory.Release(memacct.Queued, d.size)
+	c.dropDelivery(d.filter)
+	c.broker.outboundDropped.Inc()
+	d.receipt.Fail(c.broker.clock.Now())
+}
+
+// closeOutbound stops the writer of the client, and returns the deliveries left in its queue.
+func (c *client) closeOutbound() []*queuedDelivery {
+	c.mu.Lock()
+	q := c.outq
+	c.mu.Unlock()
+	if q == nil {
+		return nil
+	}
+
+	var left []*queuedDelivery
+	for _, v := range q.Close() {
+		d := v.(*queuedDelivery)
+		c.memory.Release(memacct.Queued, d.size)
+		left = append(left, d)
+	}
+	return left
+}
+
+// parkOutbound queues the QoS 1 and 2 deliveries left in the outbound queue of the client to its
+// session, they're delivered once it's resumed.
+func (b *Broker) parkOutbound(c *client) {
+	for _, d := range c.closeOutbound() {
+		if d.packet.Qos == QosAtMostOnce {
+			c.dropQueued(d)
+			continue
+		}
+		d.queued.SetAttribute("parked", true)
+		d.queued.End()
+		m := sessions.Message{
+			Filter:  d.filter,
+			Topic:   d.packet.TopicName,
+			Qos:     d.packet.Qos,
+			Payload: d.packet.Payload,
+		}
+		b.compressQueued(&m)
+		if oldest, dropped := c.session.Push(m, b.offlineQueue); dropped {
+			b.qosReport.Dropped(d.filter, 1)
+			b.deadLetterQueued(c.info.clientID, oldest)
+		}
+	}
+}
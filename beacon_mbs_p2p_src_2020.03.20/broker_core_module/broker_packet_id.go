@@ -0,0 +1,51 @@
+package broker_core_module
+
+import (
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// outboundPacket returns the packet as written to the client. A QoS 1 or 2 packet gets a packet id
+// issued by the session of the client, the one it was published with belongs to the publisher and
+// may collide with the ids the client holds inflight.
+func (c *client) outboundPacket(packet *packets.PublishPacket) (*packets.PublishPacket, error) {
+	if packet.Qos == QosAtMostOnce || c.session == nil || c.session.PacketIDs == nil {
+		return packet, nil
+	}
+
+	id, err := c.session.PacketIDs.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	pkt := *packet
+	pkt.MessageID = id
+	return &pkt, nil
+}
+
+// releasePacketID is called when the delivery is acknowledged, by the PUBACK or the PUBCOMP.
+func (c *client) releasePacketID(id uint16) {
+	if d, ok := c.untrackInflight(id); ok && c.broker != nil {
+		c.broker.qosReport.Acknowledged(d.filter)
+		d.receipt.Ack(c.broker.clock.Now())
+	}
+
+	if c.session == nil || c.session.PacketIDs == nil {
+		return
+	}
+	c.session.PacketIDs.Release(id)
+}
+
+// processPubrec goes on with the QoS 2 flow of a packet sent to the client, its id stays inflight
+// until the PUBCOMP.
+func (c *client) processPubrec(packet *packets.PubrecPacket) {
+	c.releaseInflight(packet.MessageID)
+
+	pubRel := packets.NewControlPacket(packets.Pubrel).(*packets.PubrelPacket)
+	pubRel.MessageID = packet.MessageID
+	if err := c.WriterPacket(pubRel); err != nil {
+		c.logger.Error("core_module/broker_packet_id/processPubrec: send pubRel error, ",
+			zap.Error(err),
+		)
+	}
+}
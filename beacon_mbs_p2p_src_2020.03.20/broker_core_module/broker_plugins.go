@@ -0,0 +1,119 @@
+package broker_core_module
+
+import (
+	"net"
+
+	"awesomeProject/beacon/mqtt_network/libs/plugins"
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// Plugins returns the names of the plugins intercepting the messages, in the order their hooks run.
+func (b *Broker) Plugins() []string {
+	return b.plugins.Names()
+}
+
+// checkConnectPlugins runs the OnConnect hooks on the accepted CONNECT, a vetoed one is refused as
+// not authorized.
+func (b *Broker) checkConnectPlugins(msg *packets.ConnectPacket, remoteAddr net.Addr, listener string) byte {
+	if b.plugins == nil {
+		return packets.Accepted
+	}
+
+	pc := plugins.Client{
+		ClientID:        msg.ClientIdentifier,
+		Username:        msg.Username,
+		Listener:        listener,
+		ProtocolVersion: msg.ProtocolVersion,
+	}
+	if remoteAddr != nil {
+		pc.RemoteAddr = remoteAddr.String()
+	}
+	if err := b.plugins.Connect(pc); err != nil {
+		b.logger.Warn("core_module/broker_plugins/checkConnectPlugins: a plugin refuses the connect => ",
+			zap.Error(err),
+			zap.String("clientID", msg.ClientIdentifier),
+			zap.String("username", msg.Username),
+		)
+		return packets.ErrRefusedNotAuthorised
+	}
+	return packets.Accepted
+}
+
+// pluginClient is the client as the plugin hooks see it.
+func (c *client) pluginClient() plugins.Client {
+	return plugins.Client{
+		ClientID:        c.info.clientID,
+		Username:        c.info.username,
+		RemoteAddr:      c.info.remoteIP,
+		Listener:        c.info.listener,
+		ProtocolVersion: c.info.protocolVersion,
+	}
+}
+
+// pluginPublish runs the OnPublish hooks on the publish of the client, the rewritten topic and
+// payload replace the ones of the packet. The rewritten topic is checked against the ACL again, so
+// a plugin cannot route a client to the topics it isn't allowed to publish to.
+func (c *client) pluginPublish(packet *packets.PublishPacket) bool {
+	if c.broker == nil || !c.broker.plugins.HasPublish() {
+		return true
+	}
+
+	m := &plugins.Message{
+		Topic:   packet.TopicName,
+		Payload: packet.Payload,
+		Qos:     packet.Qos,
+		Retain:  packet.Retain,
+	}
+	if err := c.broker.plugins.Publish(c.pluginClient(), m); err != nil {
+		c.logger.Warn("core_module/broker_plugins/pluginPublish: a plugin vetoes the publish, drop it => ",
+			zap.Error(err),
+			zap.String("topic", packet.TopicName),
+		)
+		return false
+	}
+
+	packet.Payload = m.Payload
+	if m.Topic == packet.TopicName {
+		return true
+	}
+	if err := topics.ValidatePublishTopic([]byte(m.Topic)); err != nil {
+		c.logger.Warn("core_module/broker_plugins/pluginPublish: a plugin rewrites the publish to an invalid topic, drop it => ",
+			zap.Error(err),
+			zap.String("topic", packet.TopicName),
+		)
+		return false
+	}
+	packet.TopicName = m.Topic
+	return c.allowPublish(packet)
+}
+
+// pluginSubscribe runs the OnSubscribe hooks on a filter of the SUBSCRIBE of the client.
+func (c *client) pluginSubscribe(filter string, qos byte) bool {
+	if c.broker == nil || c.broker.plugins == nil {
+		return true
+	}
+	if err := c.broker.plugins.Subscribe(c.pluginClient(), filter, qos); err != nil {
+		c.logger.Warn("core_module/broker_plugins/pluginSubscribe: a plugin refuses the subscription => ",
+			zap.Error(err),
+			zap.String("topic", filter),
+		)
+		return false
+	}
+	return true
+}
+
+// pluginDeliver runs the OnDeliver hooks on the message written or batched to the client.
+func (c *client) pluginDeliver(packet *packets.PublishPacket) {
+	if c.broker == nil || !c.broker.plugins.HasDeliver() {
+		return
+	}
+	c.broker.plugins.Deliver(c.pluginClient(), &plugins.Message{
+		Topic:   packet.TopicName,
+		Payload: packet.Payload,
+		Qos:     packet.Qos,
+		Retain:  packet.Retain,
+	})
+}
@@ -0,0 +1,77 @@
+package broker_core_module
+
+import (
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// processQos2Publish delivers the QoS 2 publish of the client once: its packet id is recorded by
+// the session until the PUBREL, the publish sent again with the same id is only acknowledged. The
+// id is persisted before the PUBREC for a persistent session, so the duplicate is still detected
+// once the client reconnects to a restarted broker.
+func (c *client) processQos2Publish(packet *packets.PublishPacket, v5 *mqtt5.Packet) {
+	if c.session == nil || c.session.ReceiveQos2(packet.MessageID) {
+		c.processPublishMessage(packet, v5)
+		if c.persistentSession() {
+			c.broker.saveSession(c.info.clientID)
+		}
+	} else {
+		c.logger.Debug("core_module/broker_qos2/processQos2Publish: duplicate publish, not delivered again ",
+			zap.Uint16("messageID", packet.MessageID),
+		)
+	}
+
+	c.acknowledgePublish(packet, mqtt5.Success)
+}
+
+// processPubrel completes the QoS 2 flow of a publish of the client, the packet id is released.
+// The PUBCOMP of an unknown id carries the packet identifier not found reason for a 5.0 client,
+// such as the PUBREL sent again after the PUBCOMP was lost.
+func (c *client) processPubrel(packet *packets.PubrelPacket) {
+	reasonCode := mqtt5.Success
+	if c.session != nil && c.session.ReleaseQos2(packet.MessageID) {
+		if c.persistentSession() {
+			c.broker.saveSession(c.info.clientID)
+		}
+	} else {
+		reasonCode = mqtt5.PacketIdentifierNotFound
+	}
+
+	pubComp := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+	pubComp.MessageID = packet.MessageID
+	if err := c.writePacket(pubComp, &mqtt5.Packet{ReasonCode: reasonCode}); err != nil {
+		c.logger.Error("core_module/broker_qos2/processPubrel: send pubComp error, ",
+			zap.Error(err),
+		)
+	}
+}
+
+// acknowledgePublish sends the PUBACK of a QoS 1 publish or the PUBREC of a QoS 2 one, with the
+// reason code for a 5.0 client. A QoS 0 publish has no acknowledgement.
+func (c *client) acknowledgePublish(packet *packets.PublishPacket, reasonCode byte) {
+	c.acknowledgePublishExt(packet, &mqtt5.Packet{ReasonCode: reasonCode})
+}
+
+// acknowledgePublishExt is acknowledgePublish with the 5.0 fields of the acknowledgement.
+func (c *client) acknowledgePublishExt(packet *packets.PublishPacket, ext *mqtt5.Packet) {
+	var ack packets.ControlPacket
+	switch packet.Qos {
+	case QosAtLeastOnce:
+		pubAck := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+		pubAck.MessageID = packet.MessageID
+		ack = pubAck
+	case QosExactlyOnce:
+		pubRec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+		pubRec.MessageID = packet.MessageID
+		ack = pubRec
+	default:
+		return
+	}
+
+	if err := c.writePacket(ack, ext); err != nil {
+		c.logger.Error("core_module/broker_qos2/acknowledgePublish: send ack error, ",
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,106 @@
+package broker_core_module
+
+import (
+	"encoding/json"
+
+	"awesomeProject/beacon/mqtt_network/libs/qosreport"
+	"awesomeProject/beacon/mqtt_network/libs/receipts"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// inflightDelivery is a QoS 1 or 2 delivery waiting for its acknowledgement, released once the
+// client has received the QoS 2 packet (PUBREC). The receipt of the publish is nil if it has none.
+type inflightDelivery struct {
+	filter   string
+	packet   *packets.PublishPacket
+	released bool
+	receipt  *receipts.Pending
+	// windowed holds a slot of the inflight window of the outbound queue
+	windowed bool
+}
+
+// trackInflight notes the QoS 1 or 2 delivery until it's acknowledged, it's noted before the
+// packet is written so the acknowledgement cannot come first.
+func (c *client) trackInflight(packet *packets.PublishPacket, filter string, receipt *receipts.Pending) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.inflight == nil {
+		c.inflight = make(map[uint16]*inflightDelivery)
+	}
+	c.inflight[packet.MessageID] = &inflightDelivery{filter: filter, packet: packet, receipt: receipt}
+	receipt.Add()
+	c.inflightCount.Store(int32(len(c.inflight)))
+}
+
+func (c *client) untrackInflight(id uint16) (*inflightDelivery, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d, ok := c.inflight[id]
+	if !ok {
+		return nil, false
+	}
+	delete(c.inflight, id)
+	c.inflightCount.Store(int32(len(c.inflight)))
+	if d.windowed && c.outq != nil {
+		c.outq.Release()
+	}
+	return d, true
+}
+
+func (c *client) releaseInflight(id uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if d, ok := c.inflight[id]; ok {
+		d.released = true
+	}
+}
+
+// takeInflight returns the unacknowledged deliveries, and forgets them.
+func (c *client) takeInflight() map[uint16]*inflightDelivery {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	inflight := c.inflight
+	c.inflight = nil
+	c.inflightCount.Store(0)
+	return inflight
+}
+
+func (c *client) dropDelivery(filter string) {
+	if c.broker != nil {
+		c.broker.qosReport.Dropped(filter, 1)
+	}
+}
+
+// dropInflight counts the deliveries left unacknowledged by the closed connection as dropped, they
+// are not sent again.
+func (c *client) dropInflight() {
+	for _, d := range c.takeInflight() {
+		c.dropDelivery(d.filter)
+		if c.broker != nil {
+			d.receipt.Fail(c.broker.clock.Now())
+		}
+	}
+	for _, d := range c.closeOutbound() {
+		c.dropQueued(d)
+	}
+}
+
+// QoSReport returns the deliveries, retransmissions and drops per subscription filter since the
+// last report was published.
+func (b *Broker) QoSReport() qosreport.Report {
+	return b.qosReport.Report()
+}
+
+// qosReportNotification publishes the report of the period and starts a new one.
+func (b *Broker) qosReportNotification() {
+	report, err := json.Marshal(b.qosReport.Take())
+	if err != nil {
+		return
+	}
+	b.QoSReportNotification(b.BrokerID().String(), string(report))
+}
@@ -0,0 +1,147 @@
+package broker_core_module
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/handoff"
+
+	"github.com/quic-go/quic-go"
+	"go.uber.org/zap"
+)
+
+const (
+	// The ALPN of MQTT over QUIC
+	quicProtocol = "mqtt"
+
+	defaultQUICKeepAlive = 10 * time.Second
+
+	// How long a new connection has to open its stream
+	quicStreamTimeout = 10 * time.Second
+)
+
+// QUICConfig serves MQTT over QUIC for the lossy links: the MQTT byte stream is carried by the
+// first bidirectional stream the client opens. The connections are identified by their connection
+// IDs rather than the addresses, so they survive the address changes of the clients. QUIC needs the
+// TLS certificate of the MQTT listener.
+type QUICConfig struct {
+	// Addr is the host:port of the UDP listener
+	Addr string
+	// Allow0RTT accepts the CONNECT in the early data of a resumed session. The early data can be
+	// replayed by an attacker, pair it with the connect replay guard.
+	Allow0RTT bool
+	// MaxIdleTimeout closes the silent connections, the default of quic-go if it's 0
+	MaxIdleTimeout time.Duration
+	// KeepAlivePeriod pings the idle connections below the idle timeout, 10 seconds if it's 0
+	KeepAlivePeriod time.Duration
+}
+
+// ListenersConfig selects the listeners served besides the TCP one, nil disables a listener.
+type ListenersConfig struct {
+	WebSocket *WebSocketConfig
+	QUIC      *QUICConfig
+}
+
+// quicConn is a client connection over a QUIC stream, the addresses are the current ones of the
+// QUIC connection.
+type quicConn struct {
+	quic.Stream
+	conn quic.EarlyConnection
+}
+
+func (c *quicConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *quicConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *quicConn) Close() error {
+	err := c.Stream.Close()
+	_ = c.conn.CloseWithError(0, "")
+	return err
+}
+
+// tlsState returns the TLS state once the handshake is complete, nil if the connection is gone
+// before. A CONNECT in the early data is read before it.
+func (c *quicConn) tlsState() *tls.ConnectionState {
+	select {
+	case <-c.conn.HandshakeComplete():
+	case <-c.conn.Context().Done():
+		return nil
+	}
+	s := c.conn.ConnectionState().TLS
+	return &s
+}
+
+// startQUICListener starts accepting the QUIC connections. The UDP socket is not handed off on
+// upgrade: the new process binds the address beside this one, and the QUIC clients reconnect to
+// it, with 0-RTT if it's allowed.
+func (b *Broker) startQUICListener() error {
+	if b.quicConfig == nil {
+		return nil
+	}
+
+	pc, err := handoff.ListenPacket("udp", b.quicConfig.Addr)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig := b.certMonitor.TLSConfig().Clone()
+	tlsConfig.NextProtos = []string{quicProtocol}
+
+	keepAlive := b.quicConfig.KeepAlivePeriod
+	if keepAlive <= 0 {
+		keepAlive = defaultQUICKeepAlive
+	}
+	b.quicListener, err = quic.ListenEarly(pc, tlsConfig, &quic.Config{
+		Allow0RTT:       b.quicConfig.Allow0RTT,
+		MaxIdleTimeout:  b.quicConfig.MaxIdleTimeout,
+		KeepAlivePeriod: keepAlive,
+	})
+	if err != nil {
+		_ = pc.Close()
+		return err
+	}
+	listenerAddr := b.quicListener.Addr().String()
+
+	b.logger.Info("Listening for mqtt over quic.",
+		zap.String("bind_addr", listenerAddr),
+		zap.Bool("0rtt", b.quicConfig.Allow0RTT),
+	)
+
+	go func() {
+		for {
+			conn, err := b.quicListener.Accept(context.Background())
+			if err != nil {
+				if !errors.Is(err, quic.ErrServerClosed) && !b.stopped() {
+					b.logger.Error("MQTT quic accept error on listening", zap.Error(err))
+				}
+				return
+			}
+			go b.serveQUIC(conn, listenerAddr)
+		}
+	}()
+	return nil
+}
+
+// serveQUIC serves the MQTT connection of the first stream opened by the client.
+func (b *Broker) serveQUIC(conn quic.EarlyConnection, listenerAddr string) {
+	ctx, cancel := context.WithTimeout(conn.Context(), quicStreamTimeout)
+	stream, err := conn.AcceptStream(ctx)
+	cancel()
+	if err != nil {
+		b.logger.Warn("core_module/broker_quic/serveQUIC: no stream opened, close the connection ",
+			zap.Error(err),
+			zap.String("remote", conn.RemoteAddr().String()),
+		)
+		_ = conn.CloseWithError(0, "no stream")
+		return
+	}
+
+	b.handleConnection(&quicConn{Stream: stream, conn: conn}, listenerAddr)
+}
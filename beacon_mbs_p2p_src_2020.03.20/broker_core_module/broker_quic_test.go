@@ -0,0 +1,103 @@
+package broker_core_module
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/certmon"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCertificate writes a self-signed certificate of 127.0.0.1 and its key.
+func writeTestCertificate(t *testing.T) certmon.Config {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "broker"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return certmon.Config{
+		CertFile: writeTestFile(t, "cert.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))),
+		KeyFile:  writeTestFile(t, "key.pem", string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))),
+	}
+}
+
+// connectQUICClient connects a 3.1.1 client over a QUIC stream.
+func connectQUICClient(t *testing.T, b *Broker, clientID string) *testClient {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testReadTimeout)
+	defer cancel()
+	conn, err := quic.DialAddrEarly(ctx, b.quicListener.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{quicProtocol},
+	}, nil)
+	require.NoError(t, err)
+	stream, err := conn.OpenStreamSync(ctx)
+	require.NoError(t, err)
+	c := &testClient{t: t, conn: &quicConn{Stream: stream, conn: conn}}
+	t.Cleanup(func() { _ = c.conn.Close() })
+
+	connect := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+	connect.ProtocolName = "MQTT"
+	connect.ProtocolVersion = 4
+	connect.ClientIdentifier = clientID
+	connect.CleanSession = true
+	connect.Keepalive = 60
+	c.write(connect)
+	connack, ok := c.read().Control.(*packets.ConnackPacket)
+	require.True(t, ok)
+	require.Equal(t, byte(packets.Accepted), connack.ReturnCode)
+	return c
+}
+
+func TestQUIC(t *testing.T) {
+	b := newTestBroker(t,
+		WithTLS(writeTestCertificate(t)),
+		WithListeners(ListenersConfig{QUIC: &QUICConfig{Addr: "127.0.0.1:0"}}),
+	)
+
+	mobile := connectQUICClient(t, b, "mobile")
+	v, ok := b.clients.Load("mobile")
+	require.True(t, ok)
+	require.Equal(t, b.quicListener.Addr().String(), v.(*client).info.listener)
+
+	require.Equal(t, byte(1), mobile.subscribe("cmd/#", 1))
+	other := connectQUICClient(t, b, "other")
+	other.publish("cmd/1", "go", 1, false)
+	p := mobile.expectPublish()
+	require.Equal(t, "cmd/1", p.TopicName)
+	require.Equal(t, []byte("go"), p.Payload)
+
+	// closing the connection ends the session like a lost TCP connection
+	_ = mobile.conn.Close()
+	require.Eventually(t, func() bool {
+		_, ok := b.clients.Load("mobile")
+		return !ok
+	}, testReadTimeout, 10*time.Millisecond)
+}
@@ -0,0 +1,181 @@
+package broker_core_module
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+	"awesomeProject/beacon/mqtt_network/libs/quota"
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// clientLimits returns the limits of the client, the ones of the broker overridden by the auth
+// provider for the username.
+func (b *Broker) clientLimits(username string) quota.Limits {
+	b.limitsMu.RLock()
+	l := b.limits
+	b.limitsMu.RUnlock()
+	if b.authManager != nil {
+		if o, ok := b.authManager.Limits(username); ok {
+			l = l.Override(o)
+		}
+	}
+	return l
+}
+
+// setReceiveMaximum tells a 5.0 client how many QoS 1 and 2 publishes it may send before they're
+// acknowledged.
+func setReceiveMaximum(props *mqtt5.Properties, l quota.Limits) {
+	if props == nil || l.MaxInflight <= 0 {
+		return
+	}
+	max := l.MaxInflight
+	if max > math.MaxUint16 {
+		max = math.MaxUint16
+	}
+	props.ReceiveMaximum = mqtt5.Uint16(uint16(max))
+}
+
+// limitPublish enforces the limits of the client on the publish it sent, before the publish is
+// queued. It returns whether the publish is processed, and whether the client is still connected:
+// over its limits, a throttled client waits and its oversized payloads are dropped, any other
+// client is disconnected.
+func (c *client) limitPublish(msg *Message) (bool, bool) {
+	packet, ok := msg.packet.(*packets.PublishPacket)
+	if !ok {
+		return true, true
+	}
+	if !c.limitTopicPayload(packet) {
+		return false, true
+	}
+	if c.limiter == nil {
+		return true, true
+	}
+	limits := c.limiter.Limits()
+
+	if !c.limiter.AllowPayload(len(packet.Payload)) {
+		c.logger.Warn("core_module/broker_quota/limitPublish: the payload is over the limit",
+			zap.String("topic", packet.TopicName),
+			zap.Int("size", len(packet.Payload)),
+			zap.Int("max", limits.MaxPayload),
+		)
+		if limits.Disconnects() {
+			c.disconnect(mqtt5.PacketTooLarge)
+			return false, false
+		}
+		c.quotaPublish(packet)
+		return false, true
+	}
+
+	if wait := c.limiter.Reserve(len(packet.Payload)); wait > 0 {
+		if limits.Disconnects() {
+			c.logger.Warn("core_module/broker_quota/limitPublish: the publish rate is over the limit, disconnect the client",
+				zap.Float64("publishRate", limits.PublishRate),
+				zap.Float64("byteRate", limits.ByteRate),
+			)
+			c.disconnect(mqtt5.MessageRateTooHigh)
+			return false, false
+		}
+		select {
+		case <-c.ctx.Done():
+			return false, false
+		case <-c.broker.clock.After(wait):
+		}
+	}
+
+	if packet.Qos == QosAtMostOnce {
+		return true, true
+	}
+	if limits.Disconnects() {
+		if !c.limiter.TryAcquire() {
+			c.logger.Warn("core_module/broker_quota/limitPublish: the inflight publishes are over the limit, disconnect the client",
+				zap.Int("max", limits.MaxInflight),
+			)
+			c.disconnect(mqtt5.ReceiveMaximumExceeded)
+			return false, false
+		}
+	} else if !c.limiter.Acquire(c.ctx.Done()) {
+		return false, false
+	}
+	msg.inflight = true
+	return true, true
+}
+
+// limitTopicPayload reports whether the payload is under the limit of its topic. The publish over it
+// is refused, the client stays connected: a 5.0 client gets the implementation specific error
+// reason, told apart from its own quota by the reason string.
+func (c *client) limitTopicPayload(packet *packets.PublishPacket) bool {
+	if c.broker == nil || c.broker.payloadLimits == nil {
+		return true
+	}
+	l, ok := c.broker.payloadLimits.Limit(packet.TopicName)
+	if !ok || len(packet.Payload) <= l.MaxPayload {
+		return true
+	}
+
+	c.logger.Warn("core_module/broker_quota/limitTopicPayload: the payload is over the limit of the topic",
+		zap.String("topic", packet.TopicName),
+		zap.String("filter", l.Filter),
+		zap.Int("size", len(packet.Payload)),
+		zap.Int("max", l.MaxPayload),
+	)
+	c.acknowledgePublishExt(packet, &mqtt5.Packet{
+		ReasonCode: mqtt5.ImplementationSpecificError,
+		Properties: &mqtt5.Properties{ReasonString: fmt.Sprintf("payload over the limit of %s (%d bytes)", l.Filter, l.MaxPayload)},
+	})
+	return false
+}
+
+// releasePublish frees the inflight slot of the publish once it's processed or dropped.
+func (msg *Message) releasePublish() {
+	if msg.inflight {
+		msg.inflight = false
+		msg.client.limiter.Release()
+	}
+}
+
+// limitSubscription reports whether the client may subscribe to one more filter, a client which
+// is disconnected over its limit is closed.
+func (c *client) limitSubscription() bool {
+	c.mu.Lock()
+	n := len(c.subscriptionMap)
+	c.mu.Unlock()
+	if c.limiter.AllowSubscriptions(n + 1) {
+		return true
+	}
+	limits := c.limiter.Limits()
+	c.logger.Warn("core_module/broker_quota/limitSubscription: the subscriptions are over the limit",
+		zap.Int("max", limits.MaxSubscriptions),
+	)
+	if limits.Disconnects() {
+		c.disconnect(mqtt5.QuotaExceeded)
+	}
+	return false
+}
+
+// quotaPublish acknowledges the dropped publish of QoS 1 or 2, so the client doesn't retransmit it,
+// with the quota exceeded reason for a 5.0 client.
+func (c *client) quotaPublish(packet *packets.PublishPacket) {
+	c.acknowledgePublish(packet, mqtt5.QuotaExceeded)
+}
+
+// quotaSubscribeCode is the SUBACK return code of a subscription over the limit.
+func (c *client) quotaSubscribeCode() byte {
+	if c.isV5() {
+		return mqtt5.QuotaExceeded
+	}
+	return QosFailure
+}
+
+// subscribeErrorCode is the SUBACK return code of a subscription refused by the topics provider,
+// the topic filter invalid reason for a 5.0 client whose filter is past the filter limits.
+func (c *client) subscribeErrorCode(err error) byte {
+	if c.isV5() && errors.Is(err, topics.ErrFilterLimit) {
+		return mqtt5.TopicFilterInvalid
+	}
+	return QosFailure
+}
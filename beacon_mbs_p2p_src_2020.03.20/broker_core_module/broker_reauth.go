@@ -0,0 +1,145 @@
+package broker_core_module
+
+import (
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/auth"
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+
+	"go.uber.org/zap"
+)
+
+// TokenAuthMethod is the authentication method of the 5.0 clients presenting an access token in
+// the authentication data of the CONNECT and of the AUTH packets, instead of a password.
+const TokenAuthMethod = "token"
+
+const (
+	// How long before its token expires a client is asked for a fresh one
+	defaultReauthLead = time.Minute
+
+	// How long after its token has expired a client which hasn't presented a fresh one is kept
+	defaultReauthGrace = 30 * time.Second
+)
+
+// tokenAuth reports whether the CONNECT of a 5.0 client authenticates with a token.
+func tokenAuth(connect *mqtt5.Packet) bool {
+	return connect != nil && connect.Properties != nil && connect.Properties.AuthMethod == TokenAuthMethod
+}
+
+// startReauthTask asks the token client for a fresh token before the expiry the auth provider
+// reported, with an AUTH continuing the authentication. The client answers with an AUTH holding
+// the token, and is disconnected with the maximum connect time reason if it doesn't within the
+// grace period. A client may also re-authenticate on its own at any time.
+func (c *client) startReauthTask(expiry time.Time) {
+	c.authMethod = TokenAuthMethod
+	c.reauth = make(chan time.Time, 1)
+
+	go func() {
+		b := c.broker
+		for {
+			if expiry.IsZero() {
+				select {
+				case <-c.ctx.Done():
+					return
+				case expiry = <-c.reauth:
+				}
+				continue
+			}
+
+			timer := b.clock.NewTimer(expiry.Sub(b.clock.Now()) - b.reauthLead)
+			select {
+			case <-c.ctx.Done():
+				timer.Stop()
+				return
+			case expiry = <-c.reauth:
+				timer.Stop()
+				continue
+			case <-timer.C():
+			}
+
+			c.writeAuth(mqtt5.ContinueAuthentication, "the token expires")
+
+			deadline := expiry
+			if now := b.clock.Now(); now.After(deadline) {
+				deadline = now
+			}
+			timer = b.clock.NewTimer(deadline.Add(b.reauthGrace).Sub(b.clock.Now()))
+			select {
+			case <-c.ctx.Done():
+				timer.Stop()
+				return
+			case expiry = <-c.reauth:
+				timer.Stop()
+				continue
+			case <-timer.C():
+			}
+
+			c.logger.Warn("core_module/broker_reauth/startReauthTask: no fresh token within the grace period, disconnect the client",
+				zap.Time("expiry", expiry),
+			)
+			c.disconnect(mqtt5.MaximumConnectTime)
+			return
+		}
+	}()
+}
+
+// processAuth checks the token of the AUTH of a token client, the AUTH of the other clients is a
+// protocol error since the broker supports no other authentication method.
+func (c *client) processAuth(p *mqtt5.Packet) {
+	b := c.broker
+	if p == nil || b == nil {
+		return
+	}
+	if len(c.authMethod) == 0 || p.Properties == nil || p.Properties.AuthMethod != c.authMethod {
+		c.logger.Warn("core_module/broker_reauth/processAuth: auth with another method, close the client")
+		c.disconnect(mqtt5.ProtocolError)
+		return
+	}
+	if p.ReasonCode != mqtt5.ReAuthenticate && p.ReasonCode != mqtt5.ContinueAuthentication {
+		c.disconnect(mqtt5.ProtocolError)
+		return
+	}
+
+	cred := auth.Credentials{
+		ClientID: c.info.clientID,
+		Username: c.info.username,
+		Password: p.Properties.AuthData,
+	}
+	if c.conn != nil {
+		cred.RemoteAddr = c.conn.RemoteAddr().String()
+	}
+	ok, expiry, err := b.authManager.AuthenticateExpiry(cred)
+	if err != nil {
+		c.logger.Error("core_module/broker_reauth/processAuth: auth provider error => ",
+			zap.Error(err),
+		)
+		c.disconnect(mqtt5.UnspecifiedError)
+		return
+	}
+	if !ok {
+		c.logger.Warn("core_module/broker_reauth/processAuth: reject the token, close the client")
+		c.disconnect(mqtt5.NotAuthorized)
+		return
+	}
+
+	c.writeAuth(mqtt5.Success, "")
+
+	// the task takes the latest expiry
+	select {
+	case <-c.reauth:
+	default:
+	}
+	select {
+	case c.reauth <- expiry:
+	default:
+	}
+}
+
+func (c *client) writeAuth(reasonCode byte, reasonString string) {
+	props := &mqtt5.Properties{AuthMethod: c.authMethod, ReasonString: reasonString}
+	if err := c.writePacket(mqtt5.NewAuthPacket(), &mqtt5.Packet{ReasonCode: reasonCode, Properties: props}); err != nil {
+		c.logger.Warn("core_module/broker_reauth/writeAuth: send auth error, ",
+			zap.Error(err),
+		)
+	}
+}
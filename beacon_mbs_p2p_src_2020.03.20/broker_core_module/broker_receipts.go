@@ -0,0 +1,92 @@
+package broker_core_module
+
+import (
+	"encoding/json"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/receipts"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// How often the receipts past their timeout are emitted
+const receiptSweep = time.Second
+
+// The receipts cover the deliveries to the subscribers of this broker, the peer brokers deliver
+// the publish to theirs without a receipt.
+
+// startReceipt starts the receipt of the QoS 1 or 2 publish of the client if its topic has
+// receipts, nil otherwise. The deliveries of the packet are counted until it's sealed.
+func (b *Broker) startReceipt(c *client, packet *packets.PublishPacket) *receipts.Pending {
+	if b.receipts == nil || packet.Qos == QosAtMostOnce || !b.receiptConfig.Matches(packet.TopicName) {
+		return nil
+	}
+	p := b.receipts.Start(packet.TopicName, c.info.clientID, packet.MessageID, b.clock.Now())
+	b.pendingReceipts.Store(packet, p)
+	return p
+}
+
+// sealReceipt ends the deliveries of the packet, the receipt is published once they're settled.
+func (b *Broker) sealReceipt(packet *packets.PublishPacket, p *receipts.Pending) {
+	if p == nil {
+		return
+	}
+	b.pendingReceipts.Delete(packet)
+	p.Seal(b.clock.Now())
+}
+
+// aliasReceipt counts the deliveries of a copy of the packet in its receipt, until the returned
+// function is called.
+func (b *Broker) aliasReceipt(copied *packets.PublishPacket, packet *packets.PublishPacket) func() {
+	v, ok := b.pendingReceipts.Load(packet)
+	if !ok {
+		return func() {}
+	}
+	b.pendingReceipts.Store(copied, v)
+	return func() { b.pendingReceipts.Delete(copied) }
+}
+
+// pendingReceipt returns the receipt of the packet being delivered, nil if it has none.
+func (c *client) pendingReceipt(packet *packets.PublishPacket) *receipts.Pending {
+	if c.broker == nil || c.broker.receipts == nil {
+		return nil
+	}
+	v, ok := c.broker.pendingReceipts.Load(packet)
+	if !ok {
+		return nil
+	}
+	return v.(*receipts.Pending)
+}
+
+// publishReceipt publishes the receipt to the receipt topic of the publisher, to the local
+// subscribers only.
+func (b *Broker) publishReceipt(r receipts.Receipt) {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		b.logger.Error("core_module/broker_receipts/publishReceipt: marshal receipt error => ", zap.Error(err))
+		return
+	}
+
+	packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	packet.TopicName = b.receiptConfig.ReceiptTopic(r.ClientID)
+	packet.Qos = QosAtMostOnce
+	packet.Payload = payload
+	b.SubmitPublishPacketsWorkTask(packet)
+}
+
+// startReceiptTask emits the receipts past their timeout.
+func (b *Broker) startReceiptTask() {
+	if b.receipts == nil {
+		return
+	}
+
+	go func() {
+		ticker := b.clock.NewTicker(receiptSweep)
+		defer ticker.Stop()
+
+		for range ticker.C() {
+			b.receipts.Expire(b.clock.Now())
+		}
+	}()
+}
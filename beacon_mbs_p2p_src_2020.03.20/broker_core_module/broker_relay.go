@@ -0,0 +1,70 @@
+package broker_core_module
+
+import (
+	"encoding/json"
+	"errors"
+
+	"awesomeProject/beacon/mqtt_network/libs/relay"
+)
+
+var errNotRelay = errors.New("core_module/broker_relay: the broker is not a relay")
+
+// Relay returns the relay of the broker, nil if the relay role is not enabled.
+func (b *Broker) Relay() *relay.Relay {
+	return b.relay
+}
+
+// RelayStats returns the traffic relayed per link by this broker.
+func (b *Broker) RelayStats() ([]relay.LinkStats, error) {
+	if b.relay == nil {
+		return nil, errNotRelay
+	}
+	return b.relay.Stats(), nil
+}
+
+// SetRelayRoute sends the peer messages for the node address through the relay node, for the
+// peers this broker cannot reach directly.
+func (b *Broker) SetRelayRoute(targetNodeAddr string, relayNodeAddr string) error {
+	if len(targetNodeAddr) == 0 || len(relayNodeAddr) == 0 {
+		return errors.New("core_module/broker_relay/SetRelayRoute: node address cannot be empty")
+	}
+	if targetNodeAddr == relayNodeAddr {
+		return errors.New("core_module/broker_relay/SetRelayRoute: a node cannot be its own relay")
+	}
+	b.relayRoutes.Store(targetNodeAddr, relayNodeAddr)
+	return nil
+}
+
+func (b *Broker) RemoveRelayRoute(targetNodeAddr string) {
+	b.relayRoutes.Delete(targetNodeAddr)
+}
+
+// RelayRoute returns the relay node of the node address, if any.
+func (b *Broker) RelayRoute(targetNodeAddr string) (string, bool) {
+	v, ok := b.relayRoutes.Load(targetNodeAddr)
+	if !ok {
+		return "", false
+	}
+	return v.(string), true
+}
+
+// RelayRoutes returns the relay node by target node address.
+func (b *Broker) RelayRoutes() map[string]string {
+	m := make(map[string]string)
+	b.relayRoutes.Range(func(k, v interface{}) bool {
+		m[k.(string)] = v.(string)
+		return true
+	})
+	return m
+}
+
+func (b *Broker) relayMetricsNotification() {
+	if b.relay == nil {
+		return
+	}
+	stats, err := json.Marshal(b.relay.Stats())
+	if err != nil {
+		return
+	}
+	b.RelayMetricsNotification(b.BrokerID().String(), string(stats))
+}
@@ -0,0 +1,64 @@
+package broker_core_module
+
+import (
+	"errors"
+
+	"awesomeProject/beacon/mqtt_network/libs/logging"
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// The filter a read-only replica subscribes to on the peer brokers, so all the publishes of the
+// cluster are forwarded to it.
+const replicaFilter = "#"
+
+var errReadOnly = errors.New("core_module/broker_replica: the broker is a read-only replica")
+
+// ReadOnly reports whether the broker is a read-only replica, which receives the whole traffic of
+// the cluster but refuses any publish from its clients.
+func (b *Broker) ReadOnly() bool {
+	return b.readOnly
+}
+
+// This will be called by StartListening
+func (b *Broker) startReplicaTask() {
+	if !b.readOnly {
+		return
+	}
+
+	b.brokerNode.ProcessSubNumMapForAdd(replicaFilter)
+}
+
+// checkConnectReadOnly refuses the CONNECT carrying a will message on a replica, since the will
+// would be a publish injected by the client.
+func (b *Broker) checkConnectReadOnly(msg *packets.ConnectPacket) byte {
+	if !b.readOnly || !msg.WillFlag {
+		return packets.Accepted
+	}
+
+	b.logger.Warn("core_module/broker_replica/checkConnectReadOnly: reject the connect with will on the read-only replica",
+		zap.String("clientID", msg.ClientIdentifier),
+		zap.String("willTopic", msg.WillTopic),
+	)
+	return packets.ErrRefusedNotAuthorised
+}
+
+// rejectPublish refuses the publish of a client on a replica. A 5.0 client gets the not authorized
+// reason in the PUBACK or PUBREC and stays connected, its QoS 0 publish is dropped like a denied
+// one. MQTT 3.1.1 has no reason code in PUBACK, the 3.1.1 client is closed instead.
+func (c *client) rejectPublish(packet *packets.PublishPacket) {
+	if c.isV5() {
+		c.logger.Warn("core_module/broker_replica/rejectPublish: reject the publish on the read-only replica ",
+			logging.Topic(packet.TopicName),
+		)
+		c.acknowledgePublish(packet, mqtt5.NotAuthorized)
+		return
+	}
+
+	c.logger.Warn("core_module/broker_replica/rejectPublish: reject the publish on the read-only replica, close the client ",
+		logging.Topic(packet.TopicName),
+	)
+	c.Close()
+}
@@ -0,0 +1,50 @@
+package broker_core_module
+
+import (
+	"testing"
+
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+	"awesomeProject/beacon/mqtt_network/libs/schedule"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOnlyReplica(t *testing.T) {
+	b := newTestBroker(t, WithReadOnly(true))
+	require.True(t, b.ReadOnly())
+	// the peers forward the whole traffic to the replica
+	_, ok := b.brokerNode.subNumMap.Load(replicaFilter)
+	require.True(t, ok)
+
+	sub := connectTestClient(t, b, "analytics", "", false)
+	require.Equal(t, byte(1), sub.subscribe("orders/#", 1))
+
+	// the packets forwarded by the peers are delivered
+	p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	p.TopicName = "orders/1"
+	p.Payload = []byte("forwarded")
+	b.SubmitPublishPacketsWorkTask(p)
+	require.Equal(t, []byte("forwarded"), sub.expectPublish().Payload)
+
+	// the publishes of the clients, their wills and the local publishes are refused: a 5.0 client
+	// gets the reason code and stays connected, a 3.1.1 client is closed
+	pub5 := connectTestClient(t, b, "injector5", "", true)
+	ack := pub5.publish("orders/2", "injected", 1, false)
+	require.Equal(t, mqtt5.NotAuthorized, ack.ReasonCode)
+	ack = pub5.publish("orders/2", "injected", 1, false)
+	require.Equal(t, mqtt5.NotAuthorized, ack.ReasonCode)
+	sub.expectNothing()
+
+	pub := connectTestClient(t, b, "injector", "", false)
+	pub.publish("orders/2", "injected", 0, false)
+	pub.expectClosed()
+	sub.expectNothing()
+
+	_, code := connectWillClient(t, b, "device", "orders/3", "offline")
+	require.Equal(t, byte(packets.ErrRefusedNotAuthorised), code)
+
+	require.Equal(t, errReadOnly, b.Publish("orders/4", []byte("x"), 0, false))
+	require.Equal(t, errReadOnly, b.AddSchedule(schedule.Entry{ID: "tick"}))
+	sub.expectNothing()
+}
@@ -0,0 +1,79 @@
+package broker_core_module
+
+import (
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+)
+
+// resubscribeQueue is how many restores wait for the batch in progress, resubscribeBatch the most
+// subscriptions inserted by one batch.
+const (
+	resubscribeQueue = 1024
+	resubscribeBatch = 4096
+)
+
+// resubscribeRequest is the restore of the subscriptions of a session, done once its batch is
+// inserted.
+type resubscribeRequest struct {
+	subs    []topics.Subscription
+	granted []byte
+	errs    []error
+	done    chan struct{}
+}
+
+// startResubscribeTask inserts the subscriptions restored by the persistent sessions in batches.
+// When many clients reconnect at once, such as after a restart, their restores queue up while a
+// batch is inserted and the next batch takes them all: the trie is copied and published once per
+// batch instead of once per subscription. A lone restore is inserted at once, it never waits for
+// others.
+func (b *Broker) startResubscribeTask() {
+	requests := make(chan *resubscribeRequest, resubscribeQueue)
+	b.resubscribeRequests = requests
+
+	go func() {
+		for req := range requests {
+			batch := []*resubscribeRequest{req}
+			n := len(req.subs)
+		gather:
+			for n < resubscribeBatch {
+				select {
+				case r := <-requests:
+					batch = append(batch, r)
+					n += len(r.subs)
+				default:
+					break gather
+				}
+			}
+			b.subscribeBatch(batch, n)
+		}
+	}()
+}
+
+func (b *Broker) subscribeBatch(batch []*resubscribeRequest, n int) {
+	subs := make([]topics.Subscription, 0, n)
+	for _, req := range batch {
+		subs = append(subs, req.subs...)
+	}
+	granted, errs := b.topicsManager.SubscribeBatch(subs)
+	for _, req := range batch {
+		k := len(req.subs)
+		req.granted, req.errs = granted[:k:k], errs[:k:k]
+		granted, errs = granted[k:], errs[k:]
+		close(req.done)
+	}
+}
+
+// resubscribe restores the subscriptions with the next batch, it returns the granted QoS and the
+// error of each subscription in its order.
+func (b *Broker) resubscribe(subs []topics.Subscription) ([]byte, []error) {
+	if len(subs) == 0 {
+		return nil, nil
+	}
+	if b.resubscribeRequests == nil {
+		return b.topicsManager.SubscribeBatch(subs)
+	}
+
+	req := &resubscribeRequest{subs: subs, done: make(chan struct{})}
+	b.resubscribeRequests <- req
+	<-req.done
+	return req.granted, req.errs
+}
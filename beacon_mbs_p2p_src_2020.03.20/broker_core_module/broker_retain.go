@@ -0,0 +1,102 @@
+package broker_core_module
+
+import (
+	"encoding/json"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// retainedDelivery is a retained message to send to a new subscription, with the QoS granted to it.
+type retainedDelivery struct {
+	packet *packets.PublishPacket
+	qos    byte
+	// the subscription identifier, 0 if none
+	id int
+}
+
+// retainedDeliveryPacket returns the retained message as sent to a new subscription. The retain
+// flag is set [MQTT-3.3.1-8], and the QoS is the lower of the one it was published with and the
+// granted one [MQTT-3.8.4-6].
+func (b *Broker) retainedDeliveryPacket(rm *packets.PublishPacket, grantedQos byte) *packets.PublishPacket {
+	packet := b.deliveryPacket(rm)
+
+	qos := packet.Qos
+	if grantedQos < qos {
+		qos = grantedQos
+	}
+	if packet.Retain && packet.Qos == qos {
+		return packet
+	}
+
+	pkt := *packet
+	pkt.Retain = true
+	pkt.Qos = qos
+
+	return &pkt
+}
+
+// liveDeliveryPacket returns the packet as sent to the established subscriptions, the retain flag
+// is cleared whether or not the message is retained [MQTT-3.3.1-9].
+func (b *Broker) liveDeliveryPacket(packet *packets.PublishPacket) *packets.PublishPacket {
+	packet = b.deliveryPacket(packet)
+	if !packet.Retain {
+		return packet
+	}
+
+	pkt := *packet
+	pkt.Retain = false
+
+	return &pkt
+}
+
+// RetainStats returns the number and the size of the retained messages by top-level topic, to
+// follow the growth of the retained storage.
+func (b *Broker) RetainStats() (map[string]topics.RetainStats, error) {
+	return b.topicsManager.RetainStats()
+}
+
+// MatchCacheStats returns the entries, the memory and the hits of the match cache, false if it's
+// disabled.
+func (b *Broker) MatchCacheStats() (topics.MatchCacheStats, bool) {
+	return b.topicsManager.MatchCacheStats()
+}
+
+// RetainLimitStats returns the size of the retained store and the messages refused or evicted by
+// its limits, false if it has none.
+func (b *Broker) RetainLimitStats() (topics.RetainLimitStats, bool) {
+	return b.topicsManager.RetainLimitStats()
+}
+
+func (b *Broker) retainStatsNotification() {
+	stats, err := b.RetainStats()
+	if err != nil {
+		b.logger.Warn("core_module/broker_retain/retainStatsNotification: retained stats error, ", zap.Error(err))
+		return
+	}
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return
+	}
+	var limits []byte
+	if l, ok := b.RetainLimitStats(); ok {
+		if limits, err = json.Marshal(l); err != nil {
+			return
+		}
+	}
+	b.RetainedMetricsNotification(b.BrokerID().String(), string(data), string(limits))
+}
+
+func (b *Broker) topicsMetricsNotification() {
+	ops, matched := b.topicsMetrics.Stats()
+	data, err := json.Marshal(struct {
+		Operations map[string]topics.OpStats `json:"operations"`
+		Matched    uint64                    `json:"matched_subscribers"`
+	}{ops, matched})
+	if err != nil {
+		return
+	}
+	b.TopicsMetricsNotification(b.BrokerID().String(), string(data))
+}
@@ -0,0 +1,102 @@
+package broker_core_module
+
+import (
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/retaincrdt"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+const (
+	// All the retained entries are sent to the peers that often, so the brokers converge after a
+	// partition even if they never saw each other leave.
+	defaultRetainAntiEntropy = 5 * time.Minute
+
+	// The cleared retained messages are remembered that long.
+	defaultRetainTombstoneAge = 24 * time.Hour
+)
+
+// RetainReplication returns the replicated retained entries, nil if the replication is disabled.
+func (b *Broker) RetainReplication() *retaincrdt.Map {
+	return b.retainReplication
+}
+
+// replicateRetainedMessage stamps the retained message published to this broker and sends it to
+// the peers.
+func (b *Broker) replicateRetainedMessage(packet *packets.PublishPacket) {
+	if b.retainReplication == nil || b.LocalTopic(packet.TopicName) {
+		return
+	}
+	e := b.retainReplication.Set(packet.TopicName, packet.Payload, packet.Qos)
+	b.deliverRetainedEntries("", []retaincrdt.Entry{e})
+}
+
+// MergeRetained applies the retained entries received from a peer broker, the entries newer than
+// the known ones replace the retained messages of their topics.
+func (b *Broker) MergeRetained(entries []retaincrdt.Entry) error {
+	if b.retainReplication == nil {
+		return nil
+	}
+
+	won, err := b.retainReplication.Merge(entries)
+	for _, e := range won {
+		if b.LocalTopic(e.Topic) {
+			continue
+		}
+		pkt := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		pkt.TopicName = e.Topic
+		pkt.Payload = e.Payload
+		pkt.Qos = e.Qos
+		pkt.Retain = true
+		if errR := b.topicsManager.RetainWithExpiry(pkt, 0); errR != nil {
+			b.logger.Warn("core_module/broker_retain_replication/MergeRetained: retain error, ",
+				zap.Error(errR),
+				zap.String("topic", e.Topic),
+			)
+		}
+	}
+	return err
+}
+
+// SyncRetained sends all the retained entries to the peer node, or to all the peers if the address
+// is empty.
+func (b *Broker) SyncRetained(nodeIdAddr string) {
+	if b.retainReplication == nil {
+		return
+	}
+	entries := b.retainReplication.Entries()
+	if len(entries) == 0 {
+		return
+	}
+	b.deliverRetainedEntries(nodeIdAddr, entries)
+}
+
+func (b *Broker) deliverRetainedEntries(nodeIdAddr string, entries []retaincrdt.Entry) {
+	if b.brokerNode.deliverRetainedEntries == nil {
+		return
+	}
+	b.brokerNode.deliverRetainedEntries(b, nodeIdAddr, entries)
+}
+
+// This will be called by StartListening
+func (b *Broker) startRetainReplicationTask() {
+	if b.retainReplication == nil {
+		return
+	}
+
+	go func() {
+		ticker := b.clock.NewTicker(defaultRetainAntiEntropy)
+		defer ticker.Stop()
+
+		for range ticker.C() {
+			if pruned := b.retainReplication.Prune(defaultRetainTombstoneAge); pruned > 0 {
+				b.logger.Debug("core_module/broker_retain_replication/startRetainReplicationTask: pruned tombstones",
+					zap.Int("pruned", pruned),
+				)
+			}
+			b.SyncRetained("")
+		}
+	}()
+}
@@ -0,0 +1,99 @@
+package broker_core_module
+
+import (
+	"errors"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+const defaultRewriteCheck = 10 * time.Second
+
+// ReloadTopicRewrite reads the file of the rewrite rules again, the rules apply to the publishes
+// and the subscriptions from then on. The current rules are kept if the file is invalid.
+func (b *Broker) ReloadTopicRewrite() error {
+	if b.rewrite == nil {
+		return errors.New("core_module/broker_rewrite/ReloadTopicRewrite: the broker has no rewrite file")
+	}
+	if err := b.rewrite.Reload(); err != nil {
+		return err
+	}
+	b.recordConfigChange("rewrite", b.rewriteFile)
+	return nil
+}
+
+// startRewriteTask reloads the file of the rewrite rules once it has changed.
+func (b *Broker) startRewriteTask() {
+	if b.rewrite == nil {
+		return
+	}
+
+	go func() {
+		ticker := b.clock.NewTicker(defaultRewriteCheck)
+		defer ticker.Stop()
+
+		for range ticker.C() {
+			reloaded, err := b.rewrite.Refresh()
+			if err != nil {
+				b.logger.Error("core_module/broker_rewrite/startRewriteTask: reload the rewrite rules error, the current rules are kept => ",
+					zap.Error(err),
+					zap.String("file", b.rewriteFile),
+				)
+			} else if reloaded {
+				b.logger.Info("core_module/broker_rewrite/startRewriteTask: the rewrite rules are reloaded",
+					zap.String("file", b.rewriteFile),
+				)
+				b.recordConfigChange("rewrite", b.rewriteFile)
+			}
+		}
+	}()
+}
+
+// rewritePublish rewrites the topic of the publish of the client, before it's authorized, so the
+// ACL and the subscribers see the new topic only. The topic is moved to the namespace of the tenant
+// of the client once the rules are applied.
+func (c *client) rewritePublish(packet *packets.PublishPacket) {
+	c.rewriteTopic(packet)
+	c.namespacePublish(packet)
+}
+
+func (c *client) rewriteTopic(packet *packets.PublishPacket) {
+	if c.broker == nil || c.broker.rewrite == nil {
+		return
+	}
+	if topic, ok := c.broker.rewrite.Publish(packet.TopicName); ok {
+		c.logger.Debug("core_module/broker_rewrite/rewritePublish: the topic of the publish is rewritten",
+			zap.String("from", packet.TopicName),
+			zap.String("to", topic),
+		)
+		packet.TopicName = topic
+	}
+}
+
+// rewriteSubscribe rewrites the filter of a subscription of the client, the share group is kept.
+// The subscription is kept, and unsubscribed, by its rewritten filter, in the namespace of the
+// tenant of the client.
+func (c *client) rewriteSubscribe(topic string) string {
+	return c.namespaceFilter(c.rewriteFilter(topic))
+}
+
+func (c *client) rewriteFilter(topic string) string {
+	if c.broker == nil || c.broker.rewrite == nil {
+		return topic
+	}
+	groupName, filter, share, err := topics.ParseSharedFilter([]byte(topic))
+	if err != nil {
+		return topic
+	}
+	rewritten, ok := c.broker.rewrite.Subscribe(string(filter))
+	if !ok {
+		return topic
+	}
+	if share {
+		rewritten = topics.SharePrefix + groupName + topics.SEP + rewritten
+	}
+	return rewritten
+}
@@ -0,0 +1,61 @@
+package broker_core_module
+
+import (
+	"awesomeProject/beacon/mqtt_network/libs/sampling"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// SampleSink receives the sampled messages besides their mirror topic, such as the writer of an
+// analytics pipeline. It's called on the publishing path so it must not block.
+type SampleSink interface {
+	ID() string
+	Sample(def sampling.Definition, packet *packets.PublishPacket) error
+}
+
+func (b *Broker) AddSampleSink(sink SampleSink) {
+	b.sampleSinks.Store(sink.ID(), sink)
+}
+
+func (b *Broker) RemoveSampleSink(id string) {
+	b.sampleSinks.Delete(id)
+}
+
+// SamplingDefinitions returns the sampling mirrors of the broker.
+func (b *Broker) SamplingDefinitions() []sampling.Definition {
+	return b.sampler.Definitions()
+}
+
+// samplePublish mirrors the client publish if its topic is sampled. Each broker samples the
+// publishes of its own clients, so a message is sampled once in the cluster. The mirrored message
+// is published at QoS 0 and not retained, it goes to the peer brokers like a client publish.
+func (b *Broker) samplePublish(packet *packets.PublishPacket) {
+	if b.sampler == nil {
+		return
+	}
+
+	b.sampler.Each(packet.TopicName, func(def *sampling.Definition) {
+		b.sampleSinks.Range(func(key, value interface{}) bool {
+			if err := value.(SampleSink).Sample(*def, packet); err != nil {
+				b.logger.Error("core_module/broker_sampling/samplePublish: Error deliver to sample sink => ",
+					zap.Error(err),
+					zap.String("filter", def.Filter),
+					zap.String("SinkID", key.(string)),
+				)
+			}
+			return true
+		})
+
+		if len(def.Topic) == 0 {
+			return
+		}
+		mirror := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		mirror.TopicName = def.MirrorTopic(packet.TopicName)
+		mirror.Qos = QosAtMostOnce
+		mirror.Payload = packet.Payload
+
+		b.brokerNode.candidateForwardConfirmChan <- mirror
+		b.SubmitPublishPacketsWorkTask(mirror)
+	})
+}
@@ -0,0 +1,76 @@
+package broker_core_module
+
+import (
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/schedule"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+const defaultScheduleTick = time.Second
+
+// AddSchedule registers a recurring publish, the entry with the same ID is replaced. A read-only
+// replica refuses it.
+func (b *Broker) AddSchedule(entry schedule.Entry) error {
+	if b.readOnly {
+		return errReadOnly
+	}
+	return b.scheduler.Add(entry)
+}
+
+func (b *Broker) RemoveSchedule(id string) error {
+	return b.scheduler.Remove(id)
+}
+
+func (b *Broker) Schedules() []schedule.Entry {
+	return b.scheduler.Entries()
+}
+
+// This will be called by StartListening, the task stops once the broker shuts down.
+func (b *Broker) startScheduleTask() {
+	go func() {
+		ticker := b.clock.NewTicker(defaultScheduleTick)
+		defer ticker.Stop()
+
+		for {
+			var now time.Time
+			select {
+			case <-b.quit:
+				return
+			case now = <-ticker.C():
+			}
+
+			// The entries loaded from the schedule file are not published by a replica.
+			if b.readOnly {
+				continue
+			}
+			for _, e := range b.scheduler.Due(now) {
+				b.publishScheduled(e)
+			}
+		}
+	}()
+}
+
+// The scheduled publish goes the same way as a client publish, it's forwarded to the peer brokers
+// and delivered to the local subscribers.
+func (b *Broker) publishScheduled(e schedule.Entry) {
+	packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	packet.TopicName = e.Topic
+	packet.Qos = e.Qos
+	packet.Retain = e.Retain
+	packet.Payload = e.Payload
+
+	b.brokerNode.candidateForwardConfirmChan <- packet
+
+	if packet.Retain {
+		if err := b.topicsManager.Retain(packet); err != nil {
+			b.logger.Error("core_module/broker_schedule/publishScheduled: Error retaining message => ",
+				zap.Error(err),
+				zap.String("ScheduleID", e.ID),
+			)
+		}
+	}
+	b.SubmitPublishPacketsWorkTask(packet)
+}
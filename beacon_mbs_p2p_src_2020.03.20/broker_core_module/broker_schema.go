@@ -0,0 +1,41 @@
+package broker_core_module
+
+import (
+	"awesomeProject/beacon/mqtt_network/libs/logging"
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+	"awesomeProject/beacon/mqtt_network/libs/schema"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// SchemaStats returns the counters of the payloads validated and refused by each schema binding.
+func (b *Broker) SchemaStats() []schema.Stats {
+	return b.schemas.Stats()
+}
+
+// validPayload reports whether the payload matches the schema of its topic. The invalid publish is
+// refused, the client stays connected: a 5.0 client gets the payload format invalid reason with
+// the schema error as reason string, and the publish is dead-lettered if the dead letters are
+// enabled.
+func (c *client) validPayload(packet *packets.PublishPacket) bool {
+	b := c.broker
+	if b == nil || b.schemas == nil {
+		return true
+	}
+	err := b.schemas.Validate(packet.TopicName, packet.Payload)
+	if err == nil {
+		return true
+	}
+
+	c.logger.Warn("core_module/broker_schema/validPayload: the payload doesn't match the schema of the topic",
+		logging.Topic(packet.TopicName),
+		zap.Error(err),
+	)
+	c.acknowledgePublishExt(packet, &mqtt5.Packet{
+		ReasonCode: mqtt5.PayloadFormatInvalid,
+		Properties: &mqtt5.Properties{ReasonString: err.Error()},
+	})
+	b.deadLetter(DeadLetterInvalid, c.info.clientID, packet)
+	return false
+}
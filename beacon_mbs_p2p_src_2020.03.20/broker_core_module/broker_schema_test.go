@@ -0,0 +1,92 @@
+package broker_core_module
+
+import (
+	"encoding/json"
+	"testing"
+
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+	"awesomeProject/beacon/mqtt_network/libs/schema"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaValidation(t *testing.T) {
+	b := newTestBroker(t,
+		WithSchemaValidation(schema.Binding{
+			Prefix: "sensors/",
+			Kind:   schema.KindJSONSchema,
+			Schema: `{"type": "object", "properties": {"temp": {"type": "number"}}, "required": ["temp"]}`,
+		}),
+		WithDeadLetters(DeadLetterConfig{}),
+	)
+
+	audit := connectTestClient(t, b, "audit", "", false)
+	require.Equal(t, byte(0), audit.subscribe(DeadLetterPrefix+"#", 0))
+	sub := connectTestClient(t, b, "dashboard", "", false)
+	require.Equal(t, byte(1), sub.subscribe("sensors/#", 1))
+
+	// a 5.0 client gets the reason, and the schema error as reason string
+	pub := connectTestClient(t, b, "sensor1", "", true)
+	ack := pub.publish("sensors/1", `{"temp": "hot"}`, 1, false)
+	require.Equal(t, mqtt5.PayloadFormatInvalid, ack.ReasonCode)
+	require.NotNil(t, ack.Properties)
+	require.NotEmpty(t, ack.Properties.ReasonString)
+
+	p := audit.expectPublish()
+	require.Equal(t, DeadLetterPrefix+DeadLetterInvalid+"/sensors/1", p.TopicName)
+	var dl DeadLetter
+	require.NoError(t, json.Unmarshal(p.Payload, &dl))
+	require.Equal(t, DeadLetterInvalid, dl.Reason)
+	require.Equal(t, "sensor1", dl.ClientID)
+	require.Equal(t, []byte(`{"temp": "hot"}`), dl.Payload)
+	sub.expectNothing()
+
+	// the client stays connected, its valid payloads are delivered
+	ack = pub.publish("sensors/1", `{"temp": 21.5}`, 1, false)
+	require.Equal(t, mqtt5.Success, ack.ReasonCode)
+	p = sub.expectPublish()
+	require.Equal(t, "sensors/1", p.TopicName)
+
+	// a 3.1.1 client is acknowledged as usual, the publish is dropped all the same
+	legacy := connectTestClient(t, b, "sensor2", "", false)
+	legacy.publish("sensors/2", `[]`, 1, false)
+	p = audit.expectPublish()
+	require.Equal(t, DeadLetterPrefix+DeadLetterInvalid+"/sensors/2", p.TopicName)
+	sub.expectNothing()
+	legacy.publish("sensors/2", `{"temp": 3}`, 1, false)
+	require.Equal(t, "sensors/2", sub.expectPublish().TopicName)
+
+	stats := b.SchemaStats()
+	require.Len(t, stats, 1)
+	require.EqualValues(t, 4, stats[0].Validated)
+	require.EqualValues(t, 2, stats[0].Invalid)
+}
+
+func TestSchemaValidationDelayed(t *testing.T) {
+	b := newTestBroker(t,
+		WithSchemaValidation(schema.Binding{
+			Prefix: "sensors/",
+			Kind:   schema.KindJSONSchema,
+			Schema: `{"type": "object", "properties": {"temp": {"type": "number"}}, "required": ["temp"]}`,
+		}),
+		WithDeadLetters(DeadLetterConfig{}),
+	)
+
+	audit := connectTestClient(t, b, "audit", "", false)
+	require.Equal(t, byte(0), audit.subscribe(DeadLetterPrefix+"#", 0))
+
+	// the payload of a delayed publish is checked against the schema of its target topic
+	pub := connectTestClient(t, b, "sensor1", "", true)
+	ack := pub.publish("$delayed/60/sensors/1", `{"temp": "hot"}`, 1, false)
+	require.Equal(t, mqtt5.PayloadFormatInvalid, ack.ReasonCode)
+	require.Empty(t, b.DelayedPublishes())
+
+	p := audit.expectPublish()
+	require.Equal(t, DeadLetterPrefix+DeadLetterInvalid+"/sensors/1", p.TopicName)
+
+	ack = pub.publish("$delayed/60/sensors/1", `{"temp": 21.5}`, 1, false)
+	require.Equal(t, mqtt5.Success, ack.ReasonCode)
+	queued := b.DelayedPublishes()
+	require.Len(t, queued, 1)
+	require.Equal(t, "sensors/1", queued[0].Topic)
+}
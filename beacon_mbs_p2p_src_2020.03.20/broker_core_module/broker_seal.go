@@ -0,0 +1,71 @@
+package broker_core_module
+
+import (
+	"awesomeProject/beacon/mqtt_network/libs/logging"
+	"awesomeProject/beacon/mqtt_network/libs/plugins"
+	"awesomeProject/beacon/mqtt_network/libs/seal"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// PeerSealPurpose derives the key of the messages between the peer nodes from the secret of the
+// cluster.
+const PeerSealPurpose = "beacon p2p link"
+
+// PeerSealer returns the sealer of the messages between the peer nodes, nil if the broker has no
+// secret and sends them in clear.
+func (b *Broker) PeerSealer() *seal.Sealer {
+	return b.peerSealer
+}
+
+// pluginForward runs the OnForward hooks on the packet forwarded to the peer brokers. The packet
+// is shared with the local subscribers, so a rewritten payload goes in a copy; nil if a plugin
+// keeps the packet from the peers.
+func (b *Broker) pluginForward(packet *packets.PublishPacket) *packets.PublishPacket {
+	if !b.plugins.HasForward() {
+		return packet
+	}
+
+	m := &plugins.Message{
+		Topic:   packet.TopicName,
+		Payload: packet.Payload,
+		Qos:     packet.Qos,
+		Retain:  packet.Retain,
+	}
+	if err := b.plugins.Forward(m); err != nil {
+		b.subsystemLogger(LogPeer).Warn("core_module/broker_seal/pluginForward: a plugin keeps the publish from the peers => ",
+			zap.Error(err),
+			logging.Topic(packet.TopicName),
+		)
+		return nil
+	}
+	forwarded := *packet
+	forwarded.Payload = m.Payload
+	return &forwarded
+}
+
+// ReceivePeerPublish runs the OnReceive hooks on the packet forwarded by the peer broker before
+// it's published, false if a plugin drops it.
+func (b *Broker) ReceivePeerPublish(packet *packets.PublishPacket, sourceBrokerID string) bool {
+	if !b.plugins.HasReceive() {
+		return true
+	}
+
+	m := &plugins.Message{
+		Topic:   packet.TopicName,
+		Payload: packet.Payload,
+		Qos:     packet.Qos,
+		Retain:  packet.Retain,
+	}
+	if err := b.plugins.Receive(m); err != nil {
+		b.subsystemLogger(LogPeer).Warn("core_module/broker_seal/ReceivePeerPublish: a plugin drops the publish of the peer => ",
+			zap.Error(err),
+			logging.Topic(packet.TopicName),
+			logging.Peer(sourceBrokerID),
+		)
+		return false
+	}
+	packet.Payload = m.Payload
+	return true
+}
@@ -1,6 +1,10 @@
 package broker_core_module
 
-import "github.com/eclipse/paho.mqtt.golang/packets"
+import (
+	"awesomeProject/beacon/mqtt_network/libs/sessions"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
 
 func (b *Broker) getSession(cli *client, req *packets.ConnectPacket, resp *packets.ConnackPacket) error {
 	// If CleanSession is set to 0, the server MUST resume communications with the
@@ -23,6 +27,7 @@ func (b *Broker) getSession(cli *client, req *packets.ConnectPacket, resp *packe
 	}
 
 	cid := req.ClientIdentifier
+	cli.info.cleanSession = req.CleanSession
 
 	// If CleanSession is NOT set, check the session store for existing session.
 	// If found, return it.
@@ -33,11 +38,25 @@ func (b *Broker) getSession(cli *client, req *packets.ConnectPacket, resp *packe
 			if err := cli.session.Update(req); err != nil {
 				return err
 			}
+
+			// a session restored from the disk resumes its packet ids
+			if cli.session.PacketIDs == nil {
+				cli.session.PacketIDs = b.packetIDs.Allocator(cid)
+			}
 		}
 	}
 
 	// If CleanSession, or no existing session found, then create a new one
 	if cli.session == nil {
+		if err = b.faultStoreWrite("sessions"); err != nil {
+			return err
+		}
+
+		// the previous session is discarded with the messages queued for it
+		if _, err := b.sessionManager.Get(cid); err == nil {
+			b.unparkSubscriptions(cid, true)
+			b.sessionManager.Del(cid)
+		}
 		if cli.session, err = b.sessionManager.New(cid); err != nil {
 			return err
 		}
@@ -47,6 +66,17 @@ func (b *Broker) getSession(cli *client, req *packets.ConnectPacket, resp *packe
 		if err := cli.session.Initialize(req); err != nil {
 			return err
 		}
+
+		// the packet ids of a persistent session resume where they were before a restart, the
+		// session of a 5.0 client outlives the connection if it has a session expiry interval
+		if req.CleanSession && cli.info.sessionExpiry == 0 {
+			cli.session.PacketIDs = sessions.NewPacketIDs()
+			if err := b.packetIDs.Delete(cid); err != nil {
+				return err
+			}
+		} else {
+			cli.session.PacketIDs = b.packetIDs.Allocator(cid)
+		}
 	}
 
 	return nil
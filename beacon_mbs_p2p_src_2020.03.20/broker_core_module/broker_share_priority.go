@@ -0,0 +1,87 @@
+package broker_core_module
+
+import (
+	"strconv"
+
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// The user properties of a 5.0 SUBSCRIBE setting the priority and the weight of its shared
+	// subscriptions
+	SharePriorityProperty = "share-priority"
+	ShareWeightProperty   = "share-weight"
+
+	// The unacknowledged deliveries from which a member of a share group is saturated
+	defaultShareSaturation = 100
+)
+
+// SharePriority sets the priority and the weight of the client in the share group: the messages
+// go to the available members of the highest priority, in turn by their weight, and the members
+// of a lower priority only get them while the ones above are saturated or offline. An empty group
+// or client id matches all of them. It takes precedence over the user properties of the client.
+type SharePriority struct {
+	Group    string
+	ClientID string
+	Priority int
+	Weight   int
+}
+
+func (p *SharePriority) match(group, clientID string) bool {
+	return (len(p.Group) == 0 || p.Group == group) && (len(p.ClientID) == 0 || p.ClientID == clientID)
+}
+
+// SharePriority implements topics.ShareMember, the members have the priority 0 and the weight 1
+// by default.
+func (s *subscription) SharePriority() (int, int) {
+	return s.priority, s.weight
+}
+
+// ShareAvailable implements topics.ShareMember, a member is saturated while it has too many
+// deliveries waiting for their acknowledgement.
+func (s *subscription) ShareAvailable() bool {
+	c := s.client
+	if c.status.Load() == Disconnected {
+		return false
+	}
+	return c.broker == nil || c.broker.shareSaturation <= 0 || int(c.inflightCount.Load()) < c.broker.shareSaturation
+}
+
+// setSharePriority sets the priority of the shared subscription from the admin config first, from
+// the user properties of the SUBSCRIBE of a 5.0 client otherwise.
+func (c *client) setSharePriority(sub *subscription, v5 *mqtt5.Packet) {
+	if !sub.share {
+		return
+	}
+
+	if c.broker != nil {
+		for i := range c.broker.sharePriorities {
+			p := &c.broker.sharePriorities[i]
+			if p.match(sub.groupName, c.info.clientID) {
+				sub.priority, sub.weight = p.Priority, p.Weight
+				return
+			}
+		}
+	}
+
+	if v5 == nil || v5.Properties == nil {
+		return
+	}
+	for _, u := range v5.Properties.User {
+		var err error
+		switch u.Key {
+		case SharePriorityProperty:
+			sub.priority, err = strconv.Atoi(u.Value)
+		case ShareWeightProperty:
+			sub.weight, err = strconv.Atoi(u.Value)
+		}
+		if err != nil {
+			c.logger.Warn("core_module/broker_share_priority/setSharePriority: invalid user property, ignore it",
+				zap.String("key", u.Key),
+				zap.String("value", u.Value),
+			)
+		}
+	}
+}
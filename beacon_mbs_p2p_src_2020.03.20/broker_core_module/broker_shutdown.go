@@ -0,0 +1,210 @@
+package broker_core_module
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/logging"
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+	"awesomeProject/beacon/mqtt_network/libs/sessions"
+	"awesomeProject/beacon/mqtt_network/libs/statelog"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// SessionHandover is a persistent session handed over to the peer brokers by a broker shutting
+// down. The peer the client reconnects to within the window resumes the session, the others drop
+// it once the window has elapsed.
+type SessionHandover struct {
+	SourceBrokerID string          `json:"source_broker_id"`
+	ClientID       string          `json:"client_id"`
+	Session        json.RawMessage `json:"session"`
+	Window         time.Duration   `json:"window"`
+}
+
+// Shutdown stops the broker: it stops accepting the connections, then disconnects its clients
+// spread over the drain period, so they don't reconnect to the peer brokers all at once. The 5.0
+// clients get the server shutting down reason, or use another server with the server reference if
+// one is set. The persistent sessions are saved and handed over to the peer brokers if the handover
+// is enabled, then the stores are closed.
+func (b *Broker) Shutdown(drain time.Duration) error {
+	if !b.shuttingDown.CAS(false, true) {
+		return errors.New("core_module/broker_shutdown/Shutdown: the broker is already shutting down")
+	}
+	close(b.quit)
+	b.logger.Info("core_module/broker_shutdown/Shutdown: shutting down the broker ",
+		zap.Duration("drain", drain),
+		zap.Duration("handover", b.handoverWindow),
+	)
+
+	b.closeListeners()
+	b.listening.Store(false)
+
+	ext := &mqtt5.Packet{ReasonCode: mqtt5.ServerShuttingDown}
+	if len(b.serverReference) > 0 {
+		ext = &mqtt5.Packet{ReasonCode: mqtt5.UseAnotherServer, Properties: &mqtt5.Properties{ServerReference: b.serverReference}}
+	}
+	handedOver := make(map[string]bool)
+	b.drainClients(drain, func(c *client) {
+		persistent := c.persistentSession()
+		c.writeDisconnectExt(ext)
+		c.closeWithoutWill()
+		if persistent {
+			handedOver[c.info.clientID] = b.handOverSession(c.info.clientID)
+		}
+	})
+
+	// the sessions of the clients which were offline already
+	for _, cid := range b.sessionManager.IDs() {
+		if _, ok := handedOver[cid]; !ok {
+			b.handOverSession(cid)
+		}
+		b.saveSession(cid)
+	}
+
+	var first error
+	keep := func(err error) {
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	b.keepalives.Stop()
+	keep(b.sessionManager.Close())
+	keep(b.topicsManager.Close())
+	keep(b.topicLog.Close())
+	keep(b.stateLog.Close())
+	if first != nil {
+		b.logger.Error("core_module/broker_shutdown/Shutdown: close the stores error, ", zap.Error(first))
+	}
+	return first
+}
+
+// closeListeners stops accepting the connections of all the listeners.
+func (b *Broker) closeListeners() {
+	if b.listener != nil {
+		_ = b.listener.Close()
+	}
+	if b.wsServer != nil {
+		// Stops accepting, the upgraded connections are not tracked by the server
+		_ = b.wsServer.Close()
+	}
+	if b.adminServer != nil {
+		_ = b.adminServer.Close()
+	}
+	if b.gatewayServer != nil {
+		_ = b.gatewayServer.Close()
+	}
+	if b.quicListener != nil {
+		// Closes the QUIC connections too, their clients reconnect elsewhere
+		_ = b.quicListener.Close()
+	}
+	if b.mqttsnGateway != nil {
+		b.mqttsnGateway.close()
+	}
+}
+
+// stopped reports whether the listeners are closed on purpose, their accept errors are expected.
+func (b *Broker) stopped() bool {
+	return b.handedOff.Load() || b.shuttingDown.Load()
+}
+
+// checkConnectShutdown refuses the connections accepted while the broker shuts down.
+func (b *Broker) checkConnectShutdown() byte {
+	if b.shuttingDown.Load() {
+		return packets.ErrRefusedServerUnavailable
+	}
+	return packets.Accepted
+}
+
+// handOverSession sends the session of the client to the peer brokers, it reports whether one of
+// them received it.
+func (b *Broker) handOverSession(clientID string) bool {
+	if b.handoverWindow <= 0 || b.brokerNode.deliverSessionHandover == nil {
+		return false
+	}
+	session, err := b.sessionManager.Get(clientID)
+	if err != nil {
+		return false
+	}
+	data, err := session.Export()
+	if err != nil {
+		b.logger.Error("core_module/broker_shutdown/handOverSession: export session error, ",
+			zap.Error(err),
+			logging.ClientID(clientID),
+		)
+		return false
+	}
+
+	peers := b.brokerNode.deliverSessionHandover(b, SessionHandover{
+		SourceBrokerID: b.BrokerID().String(),
+		ClientID:       clientID,
+		Session:        data,
+		Window:         b.handoverWindow,
+	})
+	b.logger.Info("core_module/broker_shutdown/handOverSession: session handed over ",
+		logging.ClientID(clientID),
+		zap.Int("peers", peers),
+	)
+	return peers > 0
+}
+
+// AdoptSession keeps the session handed over by a peer broker shutting down, its messages are
+// queued until the client reconnects. The session is dropped if the client doesn't reconnect to
+// this broker within the window; a client already connected here has started over, the session
+// handed over is ignored.
+func (b *Broker) AdoptSession(h SessionHandover) error {
+	if h.Window <= 0 || b.shuttingDown.Load() {
+		return nil
+	}
+	cid := h.ClientID
+	if _, online := b.clients.Load(cid); online {
+		b.logger.Info("core_module/broker_shutdown/AdoptSession: the client is connected, ignore the session handed over ",
+			logging.ClientID(cid),
+			zap.String("source", h.SourceBrokerID),
+		)
+		return nil
+	}
+
+	// the session handed over is the latest one, it replaces the session kept here
+	session, err := b.sessionManager.Import(cid, h.Session)
+	if err != nil {
+		return err
+	}
+	b.unparkSubscriptions(cid, true)
+
+	for _, s := range b.parkSubscriptions(cid, session) {
+		b.brokerNode.ProcessSubNumMapForAdd(s.filter)
+	}
+	b.handovers.Store(cid, session)
+	b.recordState(statelog.Event{Kind: statelog.SessionCreated, ClientID: cid, Data: map[string]string{"handed_over_by": h.SourceBrokerID}})
+
+	timer := b.clock.NewTimer(h.Window)
+	go func() {
+		<-timer.C()
+		b.expireHandover(cid, session)
+	}()
+	return nil
+}
+
+// expireHandover drops the session handed over if its client hasn't resumed it.
+func (b *Broker) expireHandover(clientID string, session *sessions.Session) {
+	if v, ok := b.handovers.Load(clientID); !ok || v != session {
+		return
+	}
+	b.handovers.Delete(clientID)
+	if _, online := b.clients.Load(clientID); online {
+		return
+	}
+	if s, err := b.sessionManager.Get(clientID); err != nil || s != session {
+		return
+	}
+
+	b.unparkSubscriptions(clientID, true)
+	b.sessionManager.Del(clientID)
+	b.recordState(statelog.Event{Kind: statelog.SessionRemoved, ClientID: clientID})
+	b.logger.Info("core_module/broker_shutdown/expireHandover: the client didn't resume the session handed over, drop it ",
+		logging.ClientID(clientID),
+	)
+}
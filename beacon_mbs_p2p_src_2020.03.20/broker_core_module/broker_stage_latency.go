@@ -0,0 +1,93 @@
+package broker_core_module
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/latency"
+)
+
+// The stages of the internal pipeline of a packet, from the wire to the subscribers:
+//   - decode: from the first byte of the packet read to the packet decoded
+//   - auth: the admission checks of the CONNECT and of the PUBLISH
+//   - queue: from the packet decoded to a worker of the pool processing it
+//   - match: the lookup of the subscribers of the topic
+//   - write: the write of a packet to a client, waiting for the connection included
+const (
+	StageDecode = "decode"
+	StageAuth   = "auth"
+	StageQueue  = "queue"
+	StageMatch  = "match"
+	StageWrite  = "write"
+)
+
+var stages = []string{StageDecode, StageAuth, StageQueue, StageMatch, StageWrite}
+
+// stageLatency keeps an HDR histogram per stage, the metrics notification publishes and resets
+// them every minute, so a regression shows up in the stage causing it.
+type stageLatency struct {
+	histograms map[string]*latency.Histogram
+}
+
+func newStageLatency() *stageLatency {
+	s := &stageLatency{
+		histograms: make(map[string]*latency.Histogram, len(stages)),
+	}
+	for _, name := range stages {
+		s.histograms[name] = latency.NewHistogram()
+	}
+	return s
+}
+
+func (s *stageLatency) observe(stage string, d time.Duration) {
+	s.histograms[stage].Observe(d)
+}
+
+func (s *stageLatency) since(stage string, start time.Time) {
+	s.histograms[stage].Observe(time.Since(start))
+}
+
+func (s *stageLatency) summary(reset bool) map[string]latency.Summary {
+	m := make(map[string]latency.Summary, len(s.histograms))
+	for name, h := range s.histograms {
+		if reset {
+			m[name] = h.SummaryAndReset()
+		} else {
+			m[name] = h.Summary()
+		}
+	}
+	return m
+}
+
+// StageLatency returns the latency percentiles per stage since the last metrics notification.
+func (b *Broker) StageLatency() map[string]latency.Summary {
+	return b.stageLatency.summary(false)
+}
+
+func (b *Broker) stageLatencyMetricsInfo() string {
+	data, _ := json.Marshal(b.stageLatency.summary(true))
+	return string(data)
+}
+
+// stampedReader notes when the first byte of a packet is read, the time before is spent waiting
+// for the client and is not part of the decode stage.
+type stampedReader struct {
+	io.Reader
+	first time.Time
+	n     int
+}
+
+func (r *stampedReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 && r.first.IsZero() {
+		r.first = time.Now()
+	}
+	r.n += n
+	return n, err
+}
+
+func (r *stampedReader) reset() {
+	r.first = time.Time{}
+	r.n = 0
+}
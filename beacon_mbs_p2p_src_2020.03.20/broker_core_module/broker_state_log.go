@@ -0,0 +1,59 @@
+package broker_core_module
+
+import (
+	"net/http"
+	"strconv"
+
+	"awesomeProject/beacon/mqtt_network/libs/statelog"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// StateLog returns the log of the state changes, nil if it's disabled.
+func (b *Broker) StateLog() *statelog.Log {
+	return b.stateLog
+}
+
+// StateLogHandler streams the state changes as JSON lines, for the admin API. It's nil if the
+// state log is disabled.
+func (b *Broker) StateLogHandler() http.Handler {
+	if b.stateLog == nil {
+		return nil
+	}
+	return statelog.Handler(b.stateLog)
+}
+
+// recordState appends the state change to the state log at the time of the broker clock.
+func (b *Broker) recordState(e statelog.Event) {
+	if b.stateLog == nil {
+		return
+	}
+	e.Time = b.clock.Now()
+	b.stateLog.Append(e)
+}
+
+// recordSession records the change of the session of the client, the subscriptions of a session
+// which is not persistent are gone with it.
+func (b *Broker) recordSession(kind statelog.Kind, c *client) {
+	b.recordState(statelog.Event{
+		Kind:     kind,
+		ClientID: c.info.clientID,
+		Data:     map[string]string{"persistent": strconv.FormatBool(c.persistentSession())},
+	})
+}
+
+// recordRetained records the retained message set or cleared by the publish.
+func (b *Broker) recordRetained(clientID string, packet *packets.PublishPacket) {
+	e := statelog.Event{Kind: statelog.Retained, ClientID: clientID, Topic: packet.TopicName, Qos: packet.Qos}
+	if len(packet.Payload) == 0 {
+		e.Kind = statelog.RetainCleared
+	} else {
+		e.Data = map[string]string{"size": strconv.Itoa(len(packet.Payload))}
+	}
+	b.recordState(e)
+}
+
+// recordConfigChange records the change of a setting of the broker.
+func (b *Broker) recordConfigChange(name string, value string) {
+	b.recordState(statelog.Event{Kind: statelog.ConfigChanged, Data: map[string]string{name: value}})
+}
@@ -0,0 +1,69 @@
+package broker_core_module
+
+import (
+	"fmt"
+	"strings"
+
+	"awesomeProject/beacon/mqtt_network/libs/storecheck"
+
+	"go.uber.org/zap"
+)
+
+// checkStores validates the persisted state of the topics and sessions providers before the broker
+// accepts any connection. The broken records are repaired or quarantined by the providers (or only
+// reported if the repair is disabled), so the broker never loads a bad state silently.
+func (b *Broker) checkStores() error {
+	type checker interface {
+		CheckConsistency(repair bool) (*storecheck.Report, error)
+	}
+
+	b.storeReports = b.storeReports[:0]
+	for _, c := range []checker{b.topicsManager, b.sessionManager} {
+		report, err := c.CheckConsistency(b.storeCheckRepair)
+		if err != nil {
+			return fmt.Errorf("core_module/broker_store_check/checkStores: check persisted store error => %v", err)
+		}
+		if report == nil {
+			continue
+		}
+
+		if report.Clean() {
+			b.logger.Info("core_module/broker_store_check/checkStores: persisted store is consistent",
+				zap.String("store", report.Store),
+				zap.Int("checked", report.Checked),
+			)
+		} else {
+			b.logger.Warn("core_module/broker_store_check/checkStores: persisted store has inconsistent records",
+				zap.String("store", report.Store),
+				zap.Int("checked", report.Checked),
+				zap.Int("issues", len(report.Issues)),
+				zap.Bool("repair", b.storeCheckRepair),
+			)
+		}
+		b.storeReports = append(b.storeReports, report)
+	}
+
+	return nil
+}
+
+// The reports are published once the broker is listening, the notifications are dropped before.
+func (b *Broker) storeCheckNotification() {
+	if len(b.storeReports) == 0 {
+		return
+	}
+
+	infoList := make([]string, 0, len(b.storeReports))
+	for _, report := range b.storeReports {
+		data, err := report.Marshal()
+		if err != nil {
+			continue
+		}
+		infoList = append(infoList, string(data))
+	}
+
+	b.PeerNodeNotification(b.BrokerID().String(), "store_check", "["+strings.Join(infoList, ",")+"]")
+}
+
+func (b *Broker) StoreCheckReports() []*storecheck.Report {
+	return b.storeReports
+}
@@ -0,0 +1,104 @@
+package broker_core_module
+
+import (
+	"errors"
+
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// SubOptions returns the MQTT 5 options of the subscription, the 3.1.1 subscriptions and the
+// restored ones have the default options.
+func (s *subscription) SubOptions() topics.SubOptions {
+	o := s.options
+	o.Qos = s.qos
+	return o
+}
+
+// subscriptionIdentifier returns the subscription identifier of a 5.0 SUBSCRIBE, 0 if it has none.
+// A SUBSCRIBE carries one identifier at most, from 1.
+func subscriptionIdentifier(v5 *mqtt5.Packet) (int, error) {
+	if v5 == nil || v5.Properties == nil || len(v5.Properties.SubscriptionIdentifier) == 0 {
+		return 0, nil
+	}
+	ids := v5.Properties.SubscriptionIdentifier
+	if len(ids) > 1 {
+		return 0, errors.New("core_module/broker_sub_options/subscriptionIdentifier: more than one subscription identifier")
+	}
+	if ids[0] < 1 || ids[0] > topics.MaxSubscriptionIdentifier {
+		return 0, errors.New("core_module/broker_sub_options/subscriptionIdentifier: subscription identifier out of range")
+	}
+	return ids[0], nil
+}
+
+// subOptions returns the options of the i-th filter of the SUBSCRIBE. The No Local option is a
+// protocol error on a shared subscription, the caller disconnects the client on an error.
+func subOptions(v5 *mqtt5.Packet, i int, qos byte, share bool, id int) (topics.SubOptions, error) {
+	if v5 == nil || i >= len(v5.SubOptions) {
+		return topics.SubOptions{Qos: qos}, nil
+	}
+	o, err := topics.ParseSubOptions(v5.SubOptions[i])
+	if err != nil {
+		return o, err
+	}
+	if share && o.NoLocal {
+		return o, errors.New("core_module/broker_sub_options/subOptions: no local on a shared subscription")
+	}
+	o.Identifier = id
+	return o, nil
+}
+
+// invalidSubscribe disconnects the client which sent a SUBSCRIBE with invalid options.
+func (c *client) invalidSubscribe(err error) {
+	c.logger.Warn("core_module/broker_sub_options/invalidSubscribe: invalid subscription options, disconnect the client",
+		zap.Error(err),
+	)
+	c.disconnect(mqtt5.ProtocolError)
+}
+
+// identifierProperties returns the properties of a delivery to the subscription, with its
+// subscription identifier for a 5.0 client.
+func (s *subscription) identifierProperties(props *mqtt5.Properties) *mqtt5.Properties {
+	if s.options.Identifier == 0 || !s.client.isV5() {
+		return props
+	}
+	p := &mqtt5.Properties{}
+	if props != nil {
+		*p = *props
+	}
+	p.SubscriptionIdentifier = []int{s.options.Identifier}
+	return p
+}
+
+// retainAsPublished returns the live delivery of a publish for the subscription, with the retain
+// flag of the published packet kept if the subscription asks for it. The release func must be
+// called once the copy is delivered.
+func (b *Broker) retainAsPublished(s *subscription, live *packets.PublishPacket, retain bool) (*packets.PublishPacket, func()) {
+	if !retain || !s.options.RetainAsPublished || live.Retain {
+		return live, func() {}
+	}
+	pkt := *live
+	pkt.Retain = true
+	release, untrace := b.aliasReceipt(&pkt, live), b.aliasTrace(&pkt, live)
+	return &pkt, func() {
+		release()
+		untrace()
+	}
+}
+
+// retainedExtFor returns the properties of a retained message sent to a new subscription, with
+// the subscription identifier if it has one.
+func (c *client) retainedExtFor(topic string, id int) *mqtt5.Packet {
+	ext := c.retainedExt(topic)
+	if id == 0 || !c.isV5() {
+		return ext
+	}
+	if ext == nil {
+		ext = &mqtt5.Packet{Properties: &mqtt5.Properties{}}
+	}
+	ext.Properties.SubscriptionIdentifier = []int{id}
+	return ext
+}
@@ -0,0 +1,89 @@
+package broker_core_module
+
+import (
+	"strings"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/sysstats"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSysInterval = 10 * time.Second
+
+	// The clients cannot publish to the topics of the broker statistics
+	sysTopicPrefix = "$SYS/"
+)
+
+func isSysTopic(topic string) bool {
+	return strings.HasPrefix(topic, sysTopicPrefix)
+}
+
+// SysStats returns the statistics published to the $SYS topics.
+func (b *Broker) SysStats() sysstats.Stats {
+	s := sysstats.Stats{
+		ClientsTotal: b.sessionManager.Count(),
+	}
+	if b.listening.Load() {
+		s.Uptime = b.clock.Now().Sub(b.started)
+	}
+	b.clients.Range(func(key, value interface{}) bool {
+		s.ClientsConnected++
+		return true
+	})
+
+	// the providers counting their trie are not walked
+	if n, err := b.topicsManager.RetainedCount(); err == nil {
+		s.Retained = n
+	} else {
+		var retained []*packets.PublishPacket
+		if err := b.topicsManager.RetainedStored([]byte("#"), &retained); err == nil {
+			s.Retained = len(retained)
+		}
+	}
+	s.TopicNodes, _ = b.topicsManager.TopicNodeCount()
+
+	b.sysStats.Fill(&s)
+	return s
+}
+
+// startSysTask publishes the statistics of the broker to the $SYS topics as retained messages, at
+// each interval. Only the changed values are published again. They are the statistics of this
+// broker, they are not forwarded to the peer brokers.
+func (b *Broker) startSysTask() {
+	if b.sysInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := b.clock.NewTicker(b.sysInterval)
+		defer ticker.Stop()
+
+		tracker := sysstats.NewTracker()
+		for {
+			list := append(sysstats.Topics(b.SysStats()), b.memoryTopics()...)
+			for _, t := range tracker.Changed(list) {
+				b.publishSys(t)
+			}
+			<-ticker.C()
+		}
+	}()
+}
+
+func (b *Broker) publishSys(t sysstats.Topic) {
+	packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	packet.TopicName = t.Name
+	packet.Qos = QosAtMostOnce
+	packet.Retain = true
+	packet.Payload = []byte(t.Value)
+
+	if err := b.topicsManager.Retain(packet); err != nil {
+		b.logger.Error("core_module/broker_sys/publishSys: Error retaining message => ",
+			zap.Error(err),
+			zap.String("topic", t.Name),
+		)
+	}
+	b.SubmitPublishPacketsWorkTask(packet)
+}
@@ -0,0 +1,59 @@
+package broker_core_module
+
+import (
+	"encoding/json"
+
+	"awesomeProject/beacon/mqtt_network/libs/logging"
+	"awesomeProject/beacon/mqtt_network/libs/pool"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// submitTenantTask queues the message to the pool of the tenant of its client, the publishes are
+// charged to the memory budget of the tenant until a worker has fanned them out, the ones on the
+// ordered topics by the worker of their key. It returns false if the client belongs to no tenant,
+// the message then goes to the shared pool.
+func (b *Broker) submitTenantTask(msg *Message) bool {
+	tp := msg.client.tenant
+	if tp == nil {
+		return false
+	}
+
+	var size int64
+	if packet, ok := msg.packet.(*packets.PublishPacket); ok {
+		size = int64(len(packet.TopicName) + len(packet.Payload))
+	}
+
+	task := func() {
+		b.stageLatency.since(StageQueue, msg.received)
+		ProcessMessage(msg)
+	}
+	var queued bool
+	if key, ordered := b.orderedKey(msg); ordered {
+		queued = tp.SubmitKeyed(size, key, task)
+	} else {
+		queued = tp.Submit(size, task)
+	}
+	if !queued {
+		b.logger.Warn("core_module/broker_tenant/submitTenantTask: tenant memory budget exceeded, drop the publish",
+			zap.String("tenant", tp.Name()),
+			logging.ClientID(msg.client.info.clientID),
+		)
+		msg.releasePublish()
+	}
+	return true
+}
+
+// TenantStats returns the worker share and the memory budget usage of the tenants.
+func (b *Broker) TenantStats() []pool.TenantStats {
+	return b.tenantPools.Stats()
+}
+
+func (b *Broker) tenantMetricsNotification() {
+	brokerIdStr := b.BrokerID().String()
+	for _, tp := range b.tenantPools.Pools() {
+		stats, _ := json.Marshal(tp.Stats())
+		b.TenantWorkPoolMetricsNotification(brokerIdStr, tp.Name(), string(stats), tp.Metrics().MetricsInfo())
+	}
+}
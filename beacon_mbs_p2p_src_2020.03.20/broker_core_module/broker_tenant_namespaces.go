@@ -0,0 +1,57 @@
+package broker_core_module
+
+import (
+	"awesomeProject/beacon/mqtt_network/libs/namespace"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// clientNamespace returns the topic namespace of the tenant of the client connected to the
+// listener, nil if it has none.
+func (b *Broker) clientNamespace(listener string, username string) *namespace.Tenant {
+	if b.tenantNamespaces == nil {
+		return nil
+	}
+	return b.tenantNamespaces.Resolve(listener, username)
+}
+
+// namespacePublish prepends the prefix of the namespace of the client to the topic of its publish,
+// unless a shared rule leaves the topic out of it.
+func (c *client) namespacePublish(packet *packets.PublishPacket) {
+	if c.namespace == nil {
+		return
+	}
+	topic := c.broker.tenantNamespaces.PublishTopic(c.namespace, packet.TopicName)
+	if topic != packet.TopicName {
+		c.logger.Debug("core_module/broker_tenant_namespaces/namespacePublish: the publish is moved to the namespace of the tenant",
+			zap.String("tenant", c.namespace.Name),
+			zap.String("topic", topic),
+		)
+		packet.TopicName = topic
+	}
+}
+
+// namespaceFilter prepends the prefix of the namespace of the client to the filter of its
+// subscription, unless a shared rule leaves the filter out of it.
+func (c *client) namespaceFilter(filter string) string {
+	if c.namespace == nil {
+		return filter
+	}
+	return c.broker.tenantNamespaces.SubscribeFilter(c.namespace, filter)
+}
+
+// namespaceDelivery returns the publish delivered to the client without the prefix of its
+// namespace. The packet is shared by the subscribers, it's copied if the topic is changed.
+func (c *client) namespaceDelivery(packet *packets.PublishPacket) *packets.PublishPacket {
+	if c.namespace == nil {
+		return packet
+	}
+	topic := c.broker.tenantNamespaces.DeliveryTopic(c.namespace, packet.TopicName)
+	if topic == packet.TopicName {
+		return packet
+	}
+	delivery := *packet
+	delivery.TopicName = topic
+	return &delivery
+}
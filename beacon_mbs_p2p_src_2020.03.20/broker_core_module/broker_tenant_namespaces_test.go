@@ -0,0 +1,74 @@
+package broker_core_module
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+	"awesomeProject/beacon/mqtt_network/libs/namespace"
+	"awesomeProject/beacon/mqtt_network/libs/topiclog"
+
+	"github.com/stretchr/testify/require"
+)
+
+// twoTenants puts the users acme and globex in the namespaces of their tenants.
+var twoTenants = namespace.TenantConfig{
+	Tenants: []namespace.Tenant{
+		{Name: "acme", Prefix: "tenants/acme"},
+		{Name: "globex", Prefix: "tenants/globex"},
+	},
+	Users: map[string]string{"acme": "acme", "acme-admin": "acme", "globex": "globex"},
+}
+
+func TestNamespaceReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "topiclog")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	b := newTestBroker(t,
+		WithTenantNamespaces(twoTenants),
+		WithTopicLog(topiclog.Config{Dir: dir, Filters: []string{"tenants/#"}}),
+	)
+	acme := connectTestClient(t, b, "acme1", "acme", false)
+	globex := connectTestClient(t, b, "globex1", "globex", false)
+	acme.publish("data/x", "a", 1, false)
+	globex.publish("data/y", "g", 1, false)
+
+	// the filter of the replay is in the namespace of the tenant, like a subscription
+	globex.publish(TopicLogReplayTopic, `{"filter":"#"}`, 1, false)
+	p := globex.expectPublish()
+	require.Equal(t, "data/y", p.TopicName)
+	require.Equal(t, []byte("g"), p.Payload)
+	globex.expectNothing()
+
+	acme.publish(TopicLogReplayTopic, `{"filter":"data/+"}`, 1, false)
+	p = acme.expectPublish()
+	require.Equal(t, "data/x", p.TopicName)
+	require.Equal(t, []byte("a"), p.Payload)
+	acme.expectNothing()
+}
+
+func TestNamespaceControl(t *testing.T) {
+	b := newTestBroker(t,
+		WithTenantNamespaces(twoTenants),
+		WithControlTopics(ControlConfig{Usernames: []string{"acme-admin"}}),
+	)
+	admin := connectTestClient(t, b, "admin", "acme-admin", true)
+	acme := connectTestClient(t, b, "acme1", "acme", false)
+	globex := connectTestClient(t, b, "globex1", "globex", false)
+	acme.subscribe("a/#", 0)
+	globex.subscribe("g/#", 0)
+
+	// the admin of a tenant controls the clients of its namespace only
+	reason, reply := admin.controlRequest("$CONTROL/clients/acme1/subscriptions", "")
+	require.Equal(t, mqtt5.Success, reason)
+	require.Equal(t, []AdminSubscription{{Filter: "tenants/acme/a/#", Qos: 0}}, reply.Subscriptions)
+
+	reason, reply = admin.controlRequest("$CONTROL/clients/globex1/subscriptions", "")
+	require.Equal(t, mqtt5.NotAuthorized, reason)
+	require.Nil(t, reply)
+	reason, _ = admin.controlRequest("$CONTROL/clients/globex1/kick", "")
+	require.Equal(t, mqtt5.NotAuthorized, reason)
+	globex.expectNothing()
+}
@@ -0,0 +1,240 @@
+package broker_core_module
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+	"awesomeProject/beacon/mqtt_network/libs/retaincrdt"
+	"awesomeProject/beacon/mqtt_network/libs/sessions"
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+	"awesomeProject/beacon/mqtt_network/libs/topics_p2p"
+
+	p2p "awesomeProject/beacon/p2p_network/core_module"
+	"awesomeProject/beacon/p2p_network/libs/kademlia"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// The time a test client waits for a packet
+const testReadTimeout = 2 * time.Second
+
+// newTestBroker starts a broker with the options on a loopback port, without peer brokers. It's
+// shut down at the end of the test.
+func newTestBroker(t *testing.T, opts ...BrokerOption) *Broker {
+	t.Helper()
+
+	node, err := p2p.NewNode(p2p.WithNodeBindHost(net.IPv4(127, 0, 0, 1)), p2p.WithNodeBindPort(0))
+	require.NoError(t, err)
+
+	// the providers of the broker of the previous test are registered still
+	topics.UnRegisterMemTopicsProvider()
+	topics_p2p.UnRegisterMemTopicsProvider4P2P()
+	sessions.UnRegisterMemSessionProvider()
+	b, err := NewBroker(append([]BrokerOption{
+		WithBrokerLogger(zap.NewNop()),
+		WithBrokerBindHost(net.IPv4(127, 0, 0, 1)),
+		WithBrokerBindPort(0),
+		WithNodeId(node.ID()),
+		WithNode(node),
+	}, opts...)...)
+	require.NoError(t, err)
+
+	overlay := kademlia.New()
+	b.SetOverlay(overlay)
+	node.Bind(overlay.Protocol())
+	bn := b.BrokerNode()
+	bn.RegisterDeliverForwardPacketsToTargetNode(func(string, ForwardBatch) {})
+	bn.RegisterDeliverTopicActionsToPeerNodes(func(*Broker, []topics_p2p.ActionElement) {})
+	bn.RegisterDeliverRetainedEntries(func(*Broker, string, []retaincrdt.Entry) {})
+	bn.RegisterDeliverSessionHandover(func(*Broker, SessionHandover) int { return 0 })
+
+	listened := make(chan error, 1)
+	go func() { listened <- b.StartListening() }()
+	for deadline := time.Now().Add(testReadTimeout); !b.listening.Load(); time.Sleep(time.Millisecond) {
+		select {
+		case err := <-listened:
+			require.NoError(t, err)
+		default:
+		}
+		require.True(t, time.Now().Before(deadline), "the broker is not listening")
+	}
+
+	t.Cleanup(func() {
+		_ = b.Shutdown(0)
+		_ = node.Close()
+	})
+	return b
+}
+
+// testClient is a raw 3.1.1 or 5.0 client of a test broker.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	v5   bool
+	id   uint16
+	// the CONNACK of the connection, with its properties for a 5.0 client
+	connack *mqtt5.Packet
+}
+
+// connectTestClient connects the client to the broker with a clean session, it's closed at the end
+// of the test.
+func connectTestClient(t *testing.T, b *Broker, clientID string, username string, v5 bool) *testClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(b.host.String(), strconv.Itoa(int(b.port))))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	c := &testClient{t: t, conn: conn, v5: v5}
+
+	connect := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+	connect.ProtocolName = "MQTT"
+	connect.ProtocolVersion = 4
+	connect.ClientIdentifier = clientID
+	connect.CleanSession = true
+	connect.Keepalive = 60
+	if len(username) > 0 {
+		connect.UsernameFlag = true
+		connect.Username = username
+	}
+	c.write(connect)
+
+	c.connack = c.read()
+	connack, ok := c.connack.Control.(*packets.ConnackPacket)
+	require.True(t, ok)
+	require.Equal(t, byte(packets.Accepted), connack.ReturnCode)
+	return c
+}
+
+func (c *testClient) write(cp packets.ControlPacket) {
+	c.t.Helper()
+
+	var err error
+	if c.v5 {
+		err = mqtt5.Write(c.conn, &mqtt5.Packet{Control: cp})
+	} else {
+		err = cp.Write(c.conn)
+	}
+	require.NoError(c.t, err)
+}
+
+// read returns the next packet sent to the client, the test fails if there's none.
+func (c *testClient) read() *mqtt5.Packet {
+	c.t.Helper()
+
+	p, err := c.readWithin(testReadTimeout)
+	require.NoError(c.t, err)
+	return p
+}
+
+func (c *testClient) readWithin(timeout time.Duration) (*mqtt5.Packet, error) {
+	_ = c.conn.SetReadDeadline(time.Now().Add(timeout))
+	if c.v5 {
+		return mqtt5.ReadPacket(c.conn)
+	}
+	cp, err := packets.ReadPacket(c.conn)
+	if err != nil {
+		return nil, err
+	}
+	return &mqtt5.Packet{Control: cp}, nil
+}
+
+func (c *testClient) nextID() uint16 {
+	c.id++
+	return c.id
+}
+
+// subscribe subscribes to the filter and returns the granted QoS of the SUBACK.
+func (c *testClient) subscribe(filter string, qos byte) byte {
+	c.t.Helper()
+
+	sub := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
+	sub.MessageID = c.nextID()
+	sub.Topics = []string{filter}
+	sub.Qoss = []byte{qos}
+	c.write(sub)
+
+	suback, ok := c.read().Control.(*packets.SubackPacket)
+	require.True(c.t, ok)
+	require.Len(c.t, suback.ReturnCodes, 1)
+	return suback.ReturnCodes[0]
+}
+
+// publish publishes the message, a QoS 1 publish returns its PUBACK and a QoS 0 one nil.
+func (c *testClient) publish(topic string, payload string, qos byte, retain bool) *mqtt5.Packet {
+	c.t.Helper()
+
+	p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	p.TopicName = topic
+	p.Payload = []byte(payload)
+	p.Qos = qos
+	p.Retain = retain
+	if qos > 0 {
+		p.MessageID = c.nextID()
+	}
+	c.write(p)
+	if qos == 0 {
+		return nil
+	}
+
+	ack := c.read()
+	puback, ok := ack.Control.(*packets.PubackPacket)
+	require.True(c.t, ok)
+	require.Equal(c.t, p.MessageID, puback.MessageID)
+	return ack
+}
+
+// expectPublish returns the next publish delivered to the client.
+func (c *testClient) expectPublish() *packets.PublishPacket {
+	c.t.Helper()
+
+	p, ok := c.read().Control.(*packets.PublishPacket)
+	require.True(c.t, ok)
+	return p
+}
+
+// expectNothing checks the broker sends nothing to the client for a while.
+func (c *testClient) expectNothing() {
+	c.t.Helper()
+
+	p, err := c.readWithin(200 * time.Millisecond)
+	require.Error(c.t, err, "unexpected packet %v", p)
+}
+
+// expectClosed checks the broker closes the connection of the client, the packets sent before are
+// skipped.
+func (c *testClient) expectClosed() {
+	c.t.Helper()
+
+	deadline := time.Now().Add(testReadTimeout)
+	for time.Now().Before(deadline) {
+		if _, err := c.readWithin(time.Until(deadline)); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			return
+		}
+	}
+	c.t.Fatal("the connection is still open")
+}
+
+// writeTestFile writes the content to a file of a temporary directory, it's removed at the end of
+// the test.
+func writeTestFile(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "broker")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
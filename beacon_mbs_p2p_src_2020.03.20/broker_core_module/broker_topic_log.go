@@ -0,0 +1,150 @@
+package broker_core_module
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+	"awesomeProject/beacon/mqtt_network/libs/topiclog"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+const (
+	// TopicLogReplayTopic takes the replay requests of the clients, the records are delivered to
+	// the client which published the request.
+	TopicLogReplayTopic = "$queue/replay"
+
+	defaultTopicLogPrune = time.Minute
+)
+
+// TopicLogReplayRequest is the JSON payload of a publish to TopicLogReplayTopic, the records of the
+// topics matching the filter are replayed from the offset, or since the time if it's set.
+type TopicLogReplayRequest struct {
+	Filter string    `json:"filter"`
+	Offset uint64    `json:"offset"`
+	Since  time.Time `json:"since"`
+	Limit  int       `json:"limit"`
+}
+
+// TopicLogStats returns the stats of the logged topics, false if the topic log is disabled.
+func (b *Broker) TopicLogStats() ([]topiclog.Stats, bool) {
+	if b.topicLog == nil {
+		return nil, false
+	}
+	return b.topicLog.Stats(), true
+}
+
+// ReplayTopicLog calls fn with the logged records selected by the query.
+func (b *Broker) ReplayTopicLog(q topiclog.Query, fn func(topiclog.Record) error) error {
+	if b.topicLog == nil {
+		return errors.New("core_module/broker_topic_log/ReplayTopicLog: the topic log is disabled")
+	}
+	return b.topicLog.Replay(q, fn)
+}
+
+// logPublish appends the QoS 1 and 2 publishes on the logged topics to the topic log.
+func (b *Broker) logPublish(packet *packets.PublishPacket) {
+	if b.topicLog == nil || packet.Qos == QosAtMostOnce || !b.topicLog.Matches(packet.TopicName) {
+		return
+	}
+	if _, err := b.topicLog.Append(packet.TopicName, packet.Qos, packet.Payload, b.clock.Now()); err != nil {
+		b.logger.Error("core_module/broker_topic_log/logPublish: append to the topic log error => ",
+			zap.Error(err),
+			zap.String("topic", packet.TopicName),
+		)
+	}
+}
+
+// startTopicLogTask drops the segments of the topic log past its retention.
+func (b *Broker) startTopicLogTask() {
+	if b.topicLog == nil {
+		return
+	}
+
+	go func() {
+		ticker := b.clock.NewTicker(defaultTopicLogPrune)
+		defer ticker.Stop()
+
+		for range ticker.C() {
+			n, err := b.topicLog.Prune(b.clock.Now())
+			if err != nil {
+				b.logger.Error("core_module/broker_topic_log/startTopicLogTask: prune the topic log error => ",
+					zap.Error(err),
+				)
+			} else if n > 0 {
+				b.logger.Info("core_module/broker_topic_log/startTopicLogTask: pruned the topic log ",
+					zap.Int("segments", n),
+				)
+			}
+		}
+	}()
+}
+
+// processReplayRequest replays the topic log to the client which published to
+// TopicLogReplayTopic, it reports whether the publish was a replay request. The filter is rewritten
+// and moved to the namespace of the tenant of the client like the filter of a subscription, the
+// client must be allowed to subscribe to it. The records are delivered with their topic.
+func (c *client) processReplayRequest(packet *packets.PublishPacket) bool {
+	b := c.broker
+	if b == nil || b.topicLog == nil || packet.TopicName != TopicLogReplayTopic {
+		return false
+	}
+
+	var req TopicLogReplayRequest
+	if err := json.Unmarshal(packet.Payload, &req); err != nil || len(req.Filter) == 0 {
+		c.logger.Warn("core_module/broker_topic_log/processReplayRequest: invalid replay request",
+			zap.ByteString("payload", packet.Payload),
+		)
+		c.ackReplayRequest(packet, mqtt5.PayloadFormatInvalid)
+		return true
+	}
+	req.Filter = c.rewriteSubscribe(req.Filter)
+	if !c.allowSubscribe(req.Filter) {
+		c.denyPublish(packet)
+		return true
+	}
+	c.ackReplayRequest(packet, mqtt5.Success)
+
+	q := topiclog.Query{Filter: req.Filter, Offset: req.Offset, Since: req.Since, Limit: req.Limit}
+	go func() {
+		n := 0
+		err := b.topicLog.Replay(q, func(r topiclog.Record) error {
+			if c.status.Load() == Disconnected {
+				return errors.New("core_module/broker_topic_log/processReplayRequest: the client is gone")
+			}
+			pkt := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+			pkt.TopicName = r.Topic
+			pkt.Payload = r.Payload
+			pkt.Qos = r.Qos
+			if pkt.Qos > QosAtLeastOnce {
+				pkt.Qos = QosAtLeastOnce
+			}
+			if err := c.deliver(pkt, req.Filter); err != nil {
+				return err
+			}
+			n++
+			return nil
+		})
+		if err != nil {
+			c.logger.Warn("core_module/broker_topic_log/processReplayRequest: replay stopped => ",
+				zap.Error(err),
+				zap.Int("records", n),
+			)
+			return
+		}
+		c.logger.Info("core_module/broker_topic_log/processReplayRequest: replayed the topic log ",
+			zap.String("filter", req.Filter),
+			zap.Int("records", n),
+		)
+	}()
+	return true
+}
+
+// ackReplayRequest acknowledges the replay request of QoS 1 or 2 with the reason code for a 5.0
+// client.
+func (c *client) ackReplayRequest(packet *packets.PublishPacket, reasonCode byte) {
+	c.acknowledgePublish(packet, reasonCode)
+}
@@ -0,0 +1,160 @@
+package broker_core_module
+
+import (
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+	"awesomeProject/beacon/mqtt_network/libs/tracing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// The spans of a message, from the publish of the client to the writes to the subscribers:
+//   - mqtt.publish: the processing of the publish of a client, the child of its traceparent
+//   - mqtt.forwarded: the processing of a publish forwarded by a peer broker, the child of the
+//     span of the peer
+//   - mqtt.match: the lookup of the subscribers of the topic
+//   - mqtt.queue: the wait of a delivery in the outbound queue of the subscriber
+//   - mqtt.deliver: the write of the publish to a subscriber
+const (
+	spanPublish   = "mqtt.publish"
+	spanForwarded = "mqtt.forwarded"
+	spanMatch     = "mqtt.match"
+	spanQueue     = "mqtt.queue"
+	spanDeliver   = "mqtt.deliver"
+)
+
+// noSpan is the span of the untraced messages.
+type noSpan struct{}
+
+func (noSpan) Context() tracing.SpanContext {
+	return tracing.SpanContext{}
+}
+
+func (noSpan) SetAttribute(string, interface{}) {}
+
+func (noSpan) End() {}
+
+// startSpan opens a span, the child of the parent, a no-op span if the message is not traced.
+func (b *Broker) startSpan(name string, parent tracing.SpanContext) tracing.Span {
+	if b.tracer == nil || !parent.Valid() {
+		return noSpan{}
+	}
+	return b.tracer.Start(name, parent)
+}
+
+// tracePublish opens the span of the publish of the client, the child of the traceparent of a
+// 5.0 publish. The deliveries find it by the packet, and the forwarding to the peer brokers its
+// traceparent, until the returned function ends it.
+func (b *Broker) tracePublish(c *client, packet *packets.PublishPacket, v5 *mqtt5.Packet) func() {
+	if b.tracer == nil {
+		return func() {}
+	}
+	span := b.tracer.Start(spanPublish, publishTraceparent(v5))
+	span.SetAttribute("mqtt.topic", packet.TopicName)
+	span.SetAttribute("mqtt.qos", int(packet.Qos))
+	span.SetAttribute("mqtt.client_id", c.info.clientID)
+	b.pendingSpans.Store(packet, span)
+	b.forwardTraces.Store(packet, span.Context().String())
+	return func() {
+		b.pendingSpans.Delete(packet)
+		span.End()
+	}
+}
+
+func publishTraceparent(v5 *mqtt5.Packet) tracing.SpanContext {
+	if v5 == nil || v5.Properties == nil {
+		return tracing.SpanContext{}
+	}
+	for _, p := range v5.Properties.User {
+		if p.Key == tracing.Header {
+			s, _ := tracing.Parse(p.Value)
+			return s
+		}
+	}
+	return tracing.SpanContext{}
+}
+
+// traceParent returns the span of the publish being delivered, the parent of the spans of its
+// deliveries.
+func (b *Broker) traceParent(packet *packets.PublishPacket) tracing.SpanContext {
+	if b.tracer == nil {
+		return tracing.SpanContext{}
+	}
+	v, ok := b.pendingSpans.Load(packet)
+	if !ok {
+		return tracing.SpanContext{}
+	}
+	return v.(tracing.Span).Context()
+}
+
+// aliasTrace traces the deliveries of a copy of the packet in its span, until the returned
+// function is called.
+func (b *Broker) aliasTrace(copied *packets.PublishPacket, packet *packets.PublishPacket) func() {
+	if b.tracer == nil || copied == packet {
+		return func() {}
+	}
+	v, ok := b.pendingSpans.Load(packet)
+	if !ok {
+		return func() {}
+	}
+	b.pendingSpans.Store(copied, v)
+	return func() { b.pendingSpans.Delete(copied) }
+}
+
+// forwardTrace returns the traceparent of the publish forwarded to the peer brokers, empty if it's
+// not traced. It's taken once, by the forward task.
+func (b *Broker) forwardTrace(packet *packets.PublishPacket) string {
+	if b.tracer == nil {
+		return ""
+	}
+	v, ok := b.forwardTraces.Load(packet)
+	if !ok {
+		return ""
+	}
+	b.forwardTraces.Delete(packet)
+	return v.(string)
+}
+
+// SubmitTracedPublish submits the publish forwarded by the peer broker like
+// SubmitPublishPacketsWorkTask, its processing is traced in a span, the child of the traceparent
+// of the peer.
+func (b *Broker) SubmitTracedPublish(packet *packets.PublishPacket, sourceBrokerID string, traceparent string) {
+	parent, err := tracing.Parse(traceparent)
+	if b.tracer == nil || err != nil {
+		b.SubmitPublishPacketsWorkTask(packet)
+		return
+	}
+	if !b.listening.Load() {
+		return
+	}
+
+	// the span covers the wait for a worker
+	span := b.tracer.Start(spanForwarded, parent)
+	span.SetAttribute("mqtt.topic", packet.TopicName)
+	span.SetAttribute("mqtt.source_broker", sourceBrokerID)
+	b.pendingSpans.Store(packet, span)
+	b.fixedWorkPool.SubmitTask(func() {
+		PublishMessageWithBroker(b, packet)
+		b.pendingSpans.Delete(packet)
+		span.End()
+	})
+	b.fixedWorkPool.Metrics().IncreasingPublishPacketTaskSubmitted()
+}
+
+// deliverySpan opens the span of the write of the publish to the client.
+func (c *client) deliverySpan(parent tracing.SpanContext, filter string) tracing.Span {
+	if c.broker == nil {
+		return noSpan{}
+	}
+	span := c.broker.startSpan(spanDeliver, parent)
+	span.SetAttribute("mqtt.client_id", c.info.clientID)
+	span.SetAttribute("mqtt.filter", filter)
+	return span
+}
+
+// traceParent returns the span of the publish delivered to the client.
+func (c *client) traceParent(packet *packets.PublishPacket) tracing.SpanContext {
+	if c.broker == nil {
+		return tracing.SpanContext{}
+	}
+	return c.broker.traceParent(packet)
+}
@@ -0,0 +1,39 @@
+package broker_core_module
+
+import (
+	"awesomeProject/beacon/mqtt_network/libs/transform"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+func (b *Broker) TransformManager() *transform.Manager {
+	return b.transformManager
+}
+
+// deliveryPacket returns the packet to write to the subscribers. If a delivery pipeline matches
+// the topic, it's a copy carrying the transformed payload, so the original packet which is
+// retained or forwarded to the peer brokers is left untouched.
+func (b *Broker) deliveryPacket(packet *packets.PublishPacket) *packets.PublishPacket {
+	if b.transformManager == nil || packet == nil {
+		return packet
+	}
+
+	payload, matched, err := b.transformManager.Apply(packet.TopicName, packet.Payload, true)
+	if err != nil {
+		b.logger.Error("core_module/broker_transform/deliveryPacket: transform payload error, deliver the original one => ",
+			zap.Error(err),
+			zap.String("topic", packet.TopicName),
+		)
+		return packet
+	}
+
+	if !matched {
+		return packet
+	}
+
+	pkt := *packet
+	pkt.Payload = payload
+
+	return &pkt
+}
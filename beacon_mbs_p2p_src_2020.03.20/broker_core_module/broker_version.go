@@ -0,0 +1,138 @@
+package broker_core_module
+
+import (
+	"runtime"
+	"sort"
+	"strings"
+
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+)
+
+// BuildCommit and BuildTime describe the build of the broker, they may be set at build time like
+// ServerVersion, with -ldflags "-X awesomeProject/beacon/mqtt_network/broker_core_module.BuildCommit=<commit>".
+var (
+	BuildCommit = "unknown"
+	BuildTime   = "unknown"
+)
+
+// The build info is retained under this topic, one message each.
+const versionTopic = "$SYS/broker/version/"
+
+// BuildInfo identifies the broker and its build, the fleet tooling inventories the brokers with
+// it: it's retained under $SYS/broker/version/# and sent in the CONNACK of the 5.0 clients.
+type BuildInfo struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit"`
+	BuildTime string   `json:"build_time"`
+	GoVersion string   `json:"go_version"`
+	BrokerID  string   `json:"broker_id"`
+	NodeID    string   `json:"node_id"`
+	Features  []string `json:"features"`
+}
+
+// BuildInfo returns the build info of the broker.
+func (b *Broker) BuildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   ServerVersion,
+		Commit:    BuildCommit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+		BrokerID:  b.BrokerID().String(),
+		Features:  b.Features(),
+	}
+	// the p2p identity of the node is known once it's started
+	if b.brokerNode.nodeID != nil {
+		info.NodeID = b.NodeID().PubKey.String()
+	}
+	return info
+}
+
+// Features returns the feature flags enabled by the build and the config of the broker, in order.
+func (b *Broker) Features() []string {
+	b.bridgesMu.Lock()
+	bridges := len(b.bridgeConfigs) > 0
+	b.bridgesMu.Unlock()
+
+	flags := map[string]bool{
+		"acl":                b.acl != nil,
+		"admin_api":          b.adminConfig != nil,
+		"auth":               b.authManager != nil,
+		"auto_subscribe":     len(b.AutoSubscriptions()) > 0,
+		"bridges":            bridges,
+		"chaos":              chaosBuilt,
+		"compression":        b.compressionConfig != nil,
+		"config_file":        len(b.configFile) > 0,
+		"control_topics":     b.controlConfig != nil,
+		"http_gateway":       b.gatewayConfig != nil,
+		"dead_letters":       b.deadLetterConfig != nil,
+		"filter_limits":      b.filterLimits != nil,
+		"idle_timeout":       b.keepaliveConfig.IdleTimeout > 0,
+		"memory_accounting":  b.memory != nil,
+		"mqttsn_gateway":     b.mqttsnConfig != nil,
+		"ordered_delivery":   len(b.orderedFilters) > 0,
+		"outbound_queues":    b.outboundConfig != nil,
+		"payload_limits":     b.payloadLimits != nil,
+		"peer_encryption":    b.peerSealer != nil,
+		"persistent_delayed": len(b.delayedFile) > 0,
+		"persistent_topics":  len(b.topicsFile) > 0,
+		"plugins":            len(b.pluginNames) > 0,
+		"quic":               b.quicConfig != nil,
+		"read_only":          b.readOnly,
+		"receipts":           b.receiptConfig != nil,
+		"relay":              b.relayConfig != nil,
+		"retain_limits":      b.retainLimits != nil,
+		"retain_replication": b.replicateRetained,
+		"rewrite":            b.rewrite != nil,
+		"sampling":           len(b.samplingDefs) > 0,
+		"schema_validation":  b.schemas != nil,
+		"session_handover":   b.handoverWindow > 0,
+		"state_log":          b.stateLogConfig != nil,
+		"sys_stats":          b.sysInterval > 0,
+		"tenant_namespaces":  b.tenantNamespaces != nil,
+		"tls":                b.tlsConfig != nil,
+		"topic_log":          b.topicLogConfig != nil,
+		"tracing":            b.tracer != nil,
+		"websocket":          b.wsConfig != nil,
+	}
+	var features []string
+	for name, enabled := range flags {
+		if enabled {
+			features = append(features, name)
+		}
+	}
+	sort.Strings(features)
+	return features
+}
+
+// setProperties adds the build info to the user properties of the CONNACK, the server version is
+// already set by the capabilities.
+func (i BuildInfo) setProperties(props *mqtt5.Properties) {
+	props.User = append(props.User,
+		mqtt5.UserProperty{Key: "build_commit", Value: i.Commit},
+		mqtt5.UserProperty{Key: "broker_id", Value: i.BrokerID},
+		mqtt5.UserProperty{Key: "features", Value: strings.Join(i.Features, ",")},
+	)
+	if len(i.NodeID) > 0 {
+		props.User = append(props.User, mqtt5.UserProperty{Key: "node_id", Value: i.NodeID})
+	}
+}
+
+// topics returns the payload of each field of the build info by topic, the features are comma
+// separated.
+func (i BuildInfo) topics() map[string]string {
+	return map[string]string{
+		versionTopic + "version":    i.Version,
+		versionTopic + "commit":     i.Commit,
+		versionTopic + "build_time": i.BuildTime,
+		versionTopic + "go_version": i.GoVersion,
+		versionTopic + "broker_id":  i.BrokerID,
+		versionTopic + "node_id":    i.NodeID,
+		versionTopic + "features":   strings.Join(i.Features, ","),
+	}
+}
+
+// retainBuildInfo retains the build info of the broker, it never expires. This will be called by
+// StartListening.
+func (b *Broker) retainBuildInfo() {
+	b.retainForever("core_module/broker_version/retainBuildInfo", b.BuildInfo().topics())
+}
@@ -0,0 +1,67 @@
+package broker_core_module
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildInfo(t *testing.T) {
+	b := newTestBroker(t,
+		WithControlTopics(ControlConfig{Usernames: []string{"admin"}}),
+		WithDeadLetters(DeadLetterConfig{}),
+		WithAdminAPI(AdminConfig{Addr: "127.0.0.1:0", Token: "secret"}),
+	)
+
+	info := b.BuildInfo()
+	require.Equal(t, ServerVersion, info.Version)
+	require.Equal(t, BuildCommit, info.Commit)
+	require.Equal(t, runtime.Version(), info.GoVersion)
+	require.Equal(t, b.BrokerID().String(), info.BrokerID)
+	require.NotEmpty(t, info.NodeID)
+	// the features are the ones enabled, in order
+	require.Subset(t, info.Features, []string{"admin_api", "control_topics", "dead_letters"})
+	require.NotContains(t, info.Features, "websocket")
+	require.True(t, sort.StringsAreSorted(info.Features))
+
+	c := connectTestClient(t, b, "inventory", "", false)
+	require.Equal(t, byte(0), c.subscribe(versionTopic+"#", 0))
+	require.Equal(t, map[string]string{
+		versionTopic + "version":    info.Version,
+		versionTopic + "commit":     info.Commit,
+		versionTopic + "build_time": info.BuildTime,
+		versionTopic + "go_version": info.GoVersion,
+		versionTopic + "broker_id":  info.BrokerID,
+		versionTopic + "node_id":    info.NodeID,
+		versionTopic + "features":   strings.Join(info.Features, ","),
+	}, c.expectRetained(7))
+
+	// a 5.0 client gets it in the CONNACK
+	v5 := connectTestClient(t, b, "inventory5", "", true)
+	for key, value := range map[string]string{
+		"build_commit": info.Commit,
+		"broker_id":    info.BrokerID,
+		"node_id":      info.NodeID,
+		"features":     strings.Join(info.Features, ","),
+	} {
+		got, ok := userProperty(v5.connack.Properties, key)
+		require.True(t, ok, key)
+		require.Equal(t, value, got, key)
+	}
+
+	// and the admin API serves it
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/version", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	b.adminHandler().ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	var served BuildInfo
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &served))
+	require.Equal(t, info, served)
+}
@@ -0,0 +1,117 @@
+package broker_core_module
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/handoff"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	handoffWebSocketName = "websocket"
+
+	defaultWebSocketPath = "/mqtt"
+
+	// The subprotocol of MQTT over WebSocket, for both 3.1.1 and 5.0
+	webSocketProtocol = "mqtt"
+
+	webSocketHeaderTimeout = 10 * time.Second
+)
+
+// WebSocketConfig serves MQTT over WebSocket for the browsers, the clients share the sessions and
+// the subscriptions of the TCP clients.
+type WebSocketConfig struct {
+	// Addr is the host:port of the listener
+	Addr string
+	// Path of the upgrade requests, /mqtt if empty
+	Path string
+	// TLS serves wss:// with the certificate of the MQTT listener, which must have TLS
+	TLS bool
+}
+
+// wsConn is a client connection over WebSocket, the addresses are the ones of the underlying
+// connection rather than the origin of the upgrade request.
+type wsConn struct {
+	*websocket.Conn
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	tlsState   *tls.ConnectionState
+}
+
+func (c *wsConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// startWebSocketListener starts serving the upgrade requests, the listener is handed off to the
+// new process on upgrade like the MQTT listener.
+func (b *Broker) startWebSocketListener() error {
+	if b.wsConfig == nil {
+		return nil
+	}
+
+	var err error
+	b.wsListener, err = handoff.Listen(handoffWebSocketName, "tcp", b.wsConfig.Addr)
+	if err != nil {
+		return err
+	}
+	listenerAddr := b.wsListener.Addr()
+
+	path := b.wsConfig.Path
+	if len(path) == 0 {
+		path = defaultWebSocketPath
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, websocket.Server{
+		Handshake: webSocketHandshake,
+		Handler: func(ws *websocket.Conn) {
+			ws.PayloadType = websocket.BinaryFrame
+			conn := &wsConn{Conn: ws, localAddr: listenerAddr, remoteAddr: listenerAddr, tlsState: ws.Request().TLS}
+			if addr, err := net.ResolveTCPAddr("tcp", ws.Request().RemoteAddr); err == nil {
+				conn.remoteAddr = addr
+			}
+			b.handleConnection(conn, listenerAddr.String())
+		},
+	})
+
+	l := b.wsListener
+	if b.wsConfig.TLS {
+		l = tls.NewListener(l, b.certMonitor.TLSConfig())
+	}
+	b.wsServer = &http.Server{Handler: mux, ReadHeaderTimeout: webSocketHeaderTimeout}
+
+	b.logger.Info("Listening for mqtt over websocket.",
+		zap.String("bind_addr", listenerAddr.String()),
+		zap.String("path", path),
+		zap.Bool("tls", b.wsConfig.TLS),
+	)
+
+	go func() {
+		err := b.wsServer.Serve(l)
+		if err != nil && err != http.ErrServerClosed && !b.stopped() {
+			b.logger.Error("MQTT websocket serve error on listening", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// webSocketHandshake accepts the upgrade requests offering the mqtt subprotocol.
+func webSocketHandshake(cfg *websocket.Config, req *http.Request) error {
+	for _, p := range cfg.Protocol {
+		if p == webSocketProtocol {
+			cfg.Protocol = []string{webSocketProtocol}
+			return nil
+		}
+	}
+	return errors.New("core_module/broker_websocket/webSocketHandshake: the mqtt subprotocol is not offered")
+}
@@ -0,0 +1,64 @@
+package broker_core_module
+
+import (
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+// dialWebSocket opens a WebSocket to the broker offering the subprotocols.
+func dialWebSocket(t *testing.T, b *Broker, protocols ...string) (*websocket.Conn, error) {
+	t.Helper()
+
+	addr := b.wsListener.Addr().String()
+	cfg, err := websocket.NewConfig("ws://"+addr+defaultWebSocketPath, "http://"+addr)
+	require.NoError(t, err)
+	cfg.Protocol = protocols
+	ws, err := websocket.DialConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { _ = ws.Close() })
+	ws.PayloadType = websocket.BinaryFrame
+	return ws, nil
+}
+
+func TestWebSocket(t *testing.T) {
+	b := newTestBroker(t, WithWebSocket(WebSocketConfig{Addr: "127.0.0.1:0"}))
+
+	_, err := dialWebSocket(t, b, "chat")
+	require.Error(t, err)
+
+	ws, err := dialWebSocket(t, b, "mqtt")
+	require.NoError(t, err)
+	require.Equal(t, webSocketProtocol, ws.Config().Protocol[0])
+	browser := &testClient{t: t, conn: ws}
+	connect := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+	connect.ProtocolName = "MQTT"
+	connect.ProtocolVersion = 4
+	connect.ClientIdentifier = "browser"
+	connect.CleanSession = true
+	connect.Keepalive = 60
+	browser.write(connect)
+	connack, ok := browser.read().Control.(*packets.ConnackPacket)
+	require.True(t, ok)
+	require.Equal(t, byte(packets.Accepted), connack.ReturnCode)
+
+	// the client is served by the listener it connected to
+	v, ok := b.clients.Load("browser")
+	require.True(t, ok)
+	require.Equal(t, b.wsListener.Addr().String(), v.(*client).info.listener)
+
+	// the WebSocket and the TCP clients share the subscriptions
+	require.Equal(t, byte(1), browser.subscribe("chat/#", 1))
+	tcp := connectTestClient(t, b, "device", "", false)
+	require.Equal(t, byte(1), tcp.subscribe("chat/#", 1))
+	tcp.publish("chat/1", "from tcp", 1, false)
+	require.Equal(t, []byte("from tcp"), browser.expectPublish().Payload)
+	require.Equal(t, []byte("from tcp"), tcp.expectPublish().Payload)
+	browser.publish("chat/2", "from ws", 1, false)
+	require.Equal(t, []byte("from ws"), tcp.expectPublish().Payload)
+	require.Equal(t, []byte("from ws"), browser.expectPublish().Payload)
+}
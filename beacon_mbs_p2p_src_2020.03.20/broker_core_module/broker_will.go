@@ -0,0 +1,235 @@
+package broker_core_module
+
+import (
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/acl"
+	"awesomeProject/beacon/mqtt_network/libs/logging"
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// pendingWill is a will message waiting for its delay, it's cancelled if the client connects
+// again meanwhile.
+type pendingWill struct {
+	packet *packets.PublishPacket
+	cancel chan struct{}
+}
+
+// willScheduler holds the delayed will messages by client id.
+type willScheduler struct {
+	mu      sync.Mutex
+	pending map[string]*pendingWill
+}
+
+func newWillScheduler() *willScheduler {
+	return &willScheduler{pending: make(map[string]*pendingWill)}
+}
+
+// willDelay returns the will delay interval of the CONNECT, the delay of the broker if a 5.0 client
+// sets none and for the 3.1.1 clients.
+func (b *Broker) willDelay(connect *mqtt5.Packet) time.Duration {
+	if connect != nil && connect.WillProperties != nil && connect.WillProperties.WillDelayInterval != nil {
+		return time.Duration(*connect.WillProperties.WillDelayInterval) * time.Second
+	}
+	return b.defaultWillDelay
+}
+
+// willTopic returns the topic the will of the CONNECT is published to, rewritten and moved to the
+// namespace of the tenant of the client like the topics of its publishes.
+func (b *Broker) willTopic(msg *packets.ConnectPacket, listener string) string {
+	topic := msg.WillTopic
+	if b.rewrite != nil {
+		if rewritten, ok := b.rewrite.Publish(topic); ok {
+			topic = rewritten
+		}
+	}
+	if tn := b.clientNamespace(listener, msg.Username); tn != nil {
+		topic = b.tenantNamespaces.PublishTopic(tn, topic)
+	}
+	return topic
+}
+
+// checkConnectWill refuses the CONNECT whose will message the client could not publish: an
+// invalid or $SYS topic, a topic claimed by another owner or denied by the ACL, or a payload which
+// doesn't match the schema of the topic. Otherwise a will would publish where the client is not
+// allowed to. The owners, the ACL and the schema check the rewritten topic.
+func (b *Broker) checkConnectWill(msg *packets.ConnectPacket, listener string) byte {
+	if !msg.WillFlag {
+		return packets.Accepted
+	}
+
+	topic := b.willTopic(msg, listener)
+	reason := ""
+	switch {
+	case topics.ValidatePublishTopic([]byte(msg.WillTopic)) != nil:
+		reason = "invalid will topic"
+	case isSysTopic(msg.WillTopic) || isSysTopic(topic):
+		reason = "$SYS will topic"
+	case !b.topicOwners.CheckPublish(msg.ClientIdentifier, msg.Username, topic):
+		reason = "will topic claimed by another owner"
+	case !b.checkACL(msg.ClientIdentifier, msg.Username, acl.Publish, topic):
+		reason = "will topic denied by the ACL"
+	case b.schemas != nil && b.schemas.Validate(topic, msg.WillMessage) != nil:
+		reason = "will payload doesn't match the schema of the topic"
+	default:
+		return packets.Accepted
+	}
+
+	b.logger.Warn("core_module/broker_will/checkConnectWill: reject the connect with a will the client cannot publish",
+		logging.ClientID(msg.ClientIdentifier),
+		zap.String("username", msg.Username),
+		logging.Topic(msg.WillTopic),
+		zap.String("reason", reason),
+	)
+	return packets.ErrRefusedNotAuthorised
+}
+
+// allowWill runs the will message through the checks of a publish of the client, the ACL may
+// have been reloaded since the CONNECT: the rewrite rules, the ACL, the plugins, the schema and the
+// payload limits of the topic and of the client.
+func (c *client) allowWill(will *packets.PublishPacket) bool {
+	c.rewritePublish(will)
+	if !c.allowPublish(will) || !c.pluginPublish(will) {
+		return false
+	}
+	if b := c.broker; b != nil && b.schemas != nil {
+		if err := b.schemas.Validate(will.TopicName, will.Payload); err != nil {
+			c.logger.Warn("core_module/broker_will/allowWill: the will payload doesn't match the schema of the topic, drop it",
+				logging.Topic(will.TopicName),
+				zap.Error(err),
+			)
+			b.deadLetter(DeadLetterInvalid, c.info.clientID, will)
+			return false
+		}
+	}
+
+	max := -1
+	if b := c.broker; b != nil && b.payloadLimits != nil {
+		if l, ok := b.payloadLimits.Limit(will.TopicName); ok {
+			max = l.MaxPayload
+		}
+	}
+	if (max >= 0 && len(will.Payload) > max) || (c.limiter != nil && !c.limiter.AllowPayload(len(will.Payload))) {
+		c.logger.Warn("core_module/broker_will/allowWill: the will payload is over the limit, drop it",
+			logging.Topic(will.TopicName),
+			zap.Int("size", len(will.Payload)),
+		)
+		return false
+	}
+	return true
+}
+
+// publishWill publishes the will message of the closed connection once its delay has elapsed, or
+// once the session of a 5.0 client ends if it's sooner. The will is a publish of the client: it's
+// checked like one, and the publish rate of the client delays it like a throttled publish.
+func (b *Broker) publishWill(c *client) {
+	c.mu.Lock()
+	will := c.info.willMessage
+	c.mu.Unlock()
+	if will == nil {
+		return
+	}
+	if !c.allowWill(will) {
+		return
+	}
+
+	delay := c.info.willDelay
+	if c.isV5() && c.info.sessionExpiry != mqtt5.SessionNeverExpires {
+		if expiry := time.Duration(c.info.sessionExpiry) * time.Second; expiry < delay {
+			delay = expiry
+		}
+	}
+	if c.limiter != nil {
+		delay += c.limiter.Reserve(len(will.Payload))
+	}
+	cid := c.info.clientID
+	if delay <= 0 {
+		b.sendWill(cid, will)
+		return
+	}
+
+	w := &pendingWill{packet: will, cancel: make(chan struct{})}
+	s := b.wills
+	s.mu.Lock()
+	if old, ok := s.pending[cid]; ok {
+		close(old.cancel)
+	}
+	s.pending[cid] = w
+	s.mu.Unlock()
+
+	timer := b.clock.NewTimer(delay)
+	go func() {
+		select {
+		case <-w.cancel:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		s.mu.Lock()
+		current := s.pending[cid] == w
+		if current {
+			delete(s.pending, cid)
+		}
+		s.mu.Unlock()
+
+		if current {
+			b.logger.Info("core_module/broker_will/publishWill: publish the delayed will message, ",
+				logging.ClientID(cid),
+				zap.String("topic", will.TopicName),
+			)
+			b.sendWill(cid, will)
+		}
+	}()
+}
+
+// dropWill drops the will message of the client, the shutdown and the handoff drop it from their
+// own goroutine.
+func (c *client) dropWill() {
+	c.mu.Lock()
+	c.info.willMessage = nil
+	c.mu.Unlock()
+}
+
+// sendWill publishes the will message like a publish of the client: forwarded to the peer
+// brokers, retained and delivered to the subscribers.
+func (b *Broker) sendWill(clientID string, will *packets.PublishPacket) {
+	b.brokerNode.candidateForwardConfirmChan <- will
+
+	if will.Retain {
+		if err := b.topicsManager.Retain(will); err != nil {
+			b.logger.Error("core_module/broker_will/sendWill: Error retaining message => ",
+				zap.Error(err),
+				logging.ClientID(clientID),
+			)
+		} else {
+			b.replicateRetainedMessage(will)
+			b.recordRetained(clientID, will)
+		}
+	}
+	b.logPublish(will)
+	b.SubmitPublishPacketsWorkTask(will)
+}
+
+// cancelWill drops the delayed will message of the client, which has connected again in time.
+func (b *Broker) cancelWill(clientID string) {
+	s := b.wills
+	s.mu.Lock()
+	w, ok := s.pending[clientID]
+	if ok {
+		delete(s.pending, clientID)
+		close(w.cancel)
+	}
+	s.mu.Unlock()
+
+	if ok {
+		b.logger.Info("core_module/broker_will/cancelWill: the client connected again, drop its delayed will message",
+			logging.ClientID(clientID),
+		)
+	}
+}
@@ -0,0 +1,151 @@
+package broker_core_module
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/quota"
+	"awesomeProject/beacon/mqtt_network/libs/schema"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+)
+
+// connectWillClient connects a 3.1.1 client with a QoS 0 will message, it returns the return code
+// of the CONNACK.
+func connectWillClient(t *testing.T, b *Broker, clientID string, willTopic string, willPayload string) (*testClient, byte) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(b.host.String(), strconv.Itoa(int(b.port))))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	c := &testClient{t: t, conn: conn}
+
+	connect := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+	connect.ProtocolName = "MQTT"
+	connect.ProtocolVersion = 4
+	connect.ClientIdentifier = clientID
+	connect.CleanSession = true
+	connect.Keepalive = 60
+	connect.WillFlag = true
+	connect.WillTopic = willTopic
+	connect.WillMessage = []byte(willPayload)
+	c.write(connect)
+
+	connack, ok := c.read().Control.(*packets.ConnackPacket)
+	require.True(t, ok)
+	return c, connack.ReturnCode
+}
+
+func TestWillDisconnect(t *testing.T) {
+	b := newTestBroker(t)
+
+	sub := connectTestClient(t, b, "monitor", "", false)
+	require.Equal(t, byte(0), sub.subscribe("status/#", 0))
+
+	// a clean DISCONNECT drops the will
+	c1, code := connectWillClient(t, b, "c1", "status/c1", "offline")
+	require.Equal(t, byte(packets.Accepted), code)
+	c1.write(packets.NewControlPacket(packets.Disconnect))
+	c1.expectClosed()
+	sub.expectNothing()
+
+	// the connection lost publishes it
+	c2, code := connectWillClient(t, b, "c2", "status/c2", "offline")
+	require.Equal(t, byte(packets.Accepted), code)
+	_ = c2.conn.Close()
+	p := sub.expectPublish()
+	require.Equal(t, "status/c2", p.TopicName)
+	require.Equal(t, []byte("offline"), p.Payload)
+}
+
+func TestWillChecks(t *testing.T) {
+	aclFile := writeTestFile(t, "acl.yaml", `
+default: allow
+rules:
+  - action: deny
+    access: publish
+    topics: ["alarms/#"]
+`)
+	b := newTestBroker(t,
+		WithACLFile(aclFile),
+		WithPayloadLimits(quota.PayloadLimit{Filter: "status/#", MaxPayload: 4}),
+	)
+
+	sub := connectTestClient(t, b, "monitor", "", false)
+	require.Equal(t, byte(0), sub.subscribe("status/#", 0))
+	require.Equal(t, byte(0), sub.subscribe("alarms/#", 0))
+
+	// the will the client could not publish is refused with the CONNECT
+	for _, topic := range []string{"alarms/c1", "$SYS/broker/c1", "status/+"} {
+		_, code := connectWillClient(t, b, "c1", topic, "off")
+		require.Equal(t, byte(packets.ErrRefusedNotAuthorised), code, topic)
+	}
+
+	// the will over the payload limit of its topic is dropped
+	c2, code := connectWillClient(t, b, "c2", "status/c2", "offline")
+	require.Equal(t, byte(packets.Accepted), code)
+	_ = c2.conn.Close()
+	sub.expectNothing()
+
+	c3, code := connectWillClient(t, b, "c3", "status/c3", "off")
+	require.Equal(t, byte(packets.Accepted), code)
+	_ = c3.conn.Close()
+	p := sub.expectPublish()
+	require.Equal(t, "status/c3", p.TopicName)
+}
+
+func TestWillSchema(t *testing.T) {
+	rewriteFile := writeTestFile(t, "rewrite.yaml", `
+rules:
+  - from: legacy/+id/temp
+    to: telemetry/$id
+`)
+	b := newTestBroker(t,
+		WithTopicRewrite(rewriteFile),
+		WithSchemaValidation(schema.Binding{
+			Prefix: "telemetry/",
+			Kind:   schema.KindJSONSchema,
+			Schema: `{"type": "object", "required": ["temp"]}`,
+		}),
+	)
+
+	sub := connectTestClient(t, b, "monitor", "", false)
+	require.Equal(t, byte(0), sub.subscribe("telemetry/#", 0))
+
+	// the schema of the rewritten topic applies to the will
+	_, code := connectWillClient(t, b, "c1", "legacy/c1/temp", "off")
+	require.Equal(t, byte(packets.ErrRefusedNotAuthorised), code)
+
+	c2, code := connectWillClient(t, b, "c2", "legacy/c2/temp", `{"temp": 0}`)
+	require.Equal(t, byte(packets.Accepted), code)
+	_ = c2.conn.Close()
+	p := sub.expectPublish()
+	require.Equal(t, "telemetry/c2", p.TopicName)
+}
+
+func TestWillDelay(t *testing.T) {
+	b := newTestBroker(t, WithWillDelay(500*time.Millisecond))
+
+	sub := connectTestClient(t, b, "monitor", "", false)
+	require.Equal(t, byte(0), sub.subscribe("status/#", 0))
+
+	// the will is published once the delay has elapsed
+	c1, code := connectWillClient(t, b, "c1", "status/c1", "offline")
+	require.Equal(t, byte(packets.Accepted), code)
+	_ = c1.conn.Close()
+	sub.expectNothing()
+	p := sub.expectPublish()
+	require.Equal(t, "status/c1", p.TopicName)
+
+	// the client connecting again in time cancels it
+	c2, code := connectWillClient(t, b, "c2", "status/c2", "offline")
+	require.Equal(t, byte(packets.Accepted), code)
+	_ = c2.conn.Close()
+	sub.expectNothing()
+	connectTestClient(t, b, "c2", "", false)
+	_, err := sub.readWithin(time.Second)
+	require.Error(t, err)
+}
@@ -0,0 +1,25 @@
+//go:build !chaos
+// +build !chaos
+
+package broker_core_module
+
+import "github.com/eclipse/paho.mqtt.golang/packets"
+
+// Without the "chaos" tag, the fault injection points are no-ops.
+const chaosBuilt = false
+
+func (b *Broker) initChaos() {}
+
+func (b *Broker) faultInboundPublish(packet *packets.PublishPacket) bool {
+	return true
+}
+
+func (b *Broker) faultStoreWrite(store string) error {
+	return nil
+}
+
+func (b *Broker) FaultPartitioned(brokerID string) bool {
+	return false
+}
+
+func (c *client) faultSlowWrite() {}
@@ -0,0 +1,50 @@
+//go:build chaos
+// +build chaos
+
+package broker_core_module
+
+import (
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/chaos"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+// The fault injection points, built with the "chaos" tag only.
+const chaosBuilt = true
+
+func (b *Broker) initChaos() {
+	b.chaos = chaos.NewInjector(time.Now().UnixNano(), b.clock)
+	b.logger.Warn("core_module/chaos_on/initChaos: the broker is built with the fault injection points, never run it in production")
+}
+
+// faultInboundPublish drops or delays the publish received from a client, it returns false if
+// the publish is dropped.
+func (b *Broker) faultInboundPublish(packet *packets.PublishPacket) bool {
+	if b.chaos.DropPacket(packet.TopicName) {
+		b.logger.Debug("core_module/chaos_on/faultInboundPublish: drop the publish", zap.String("topic", packet.TopicName))
+		return false
+	}
+	if d := b.chaos.PacketDelay(packet.TopicName); d > 0 {
+		time.Sleep(d)
+	}
+	return true
+}
+
+func (b *Broker) faultStoreWrite(store string) error {
+	return b.chaos.StoreWrite(store)
+}
+
+// FaultPartitioned reports whether the link with the peer broker is cut, the cluster module
+// drops the messages received from it.
+func (b *Broker) FaultPartitioned(brokerID string) bool {
+	return b.chaos.Partitioned(brokerID)
+}
+
+func (c *client) faultSlowWrite() {
+	if d := c.broker.chaos.SubscriberDelay(c.info.clientID); d > 0 {
+		time.Sleep(d)
+	}
+}
@@ -4,55 +4,93 @@ import (
 	"context"
 	"errors"
 	"io"
-	"math/rand"
 	"net"
-	"regexp"
 	"strings"
 	"sync"
 	"time"
 
-	"awesomeProject/beacon/general_toolbox/logger"
-
+	"awesomeProject/beacon/mqtt_network/libs/batch"
+	"awesomeProject/beacon/mqtt_network/libs/keepalive"
+	"awesomeProject/beacon/mqtt_network/libs/logging"
+	"awesomeProject/beacon/mqtt_network/libs/memacct"
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+	"awesomeProject/beacon/mqtt_network/libs/namespace"
+	"awesomeProject/beacon/mqtt_network/libs/netbuf"
+	"awesomeProject/beacon/mqtt_network/libs/outbound"
+	"awesomeProject/beacon/mqtt_network/libs/pool"
+	"awesomeProject/beacon/mqtt_network/libs/quota"
 	"awesomeProject/beacon/mqtt_network/libs/sessions"
+	"awesomeProject/beacon/mqtt_network/libs/statelog"
 	"awesomeProject/beacon/mqtt_network/libs/topics"
 	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
 
-const (
-	_GroupTopicRegexp = `^\$share/([0-9a-zA-Z_-]+)/(.*)$`
-)
-
 const (
 	Connected    = true
 	Disconnected = false
 )
 
-var (
-	groupCompile = regexp.MustCompile(_GroupTopicRegexp)
-
-	r = rand.New(rand.NewSource(time.Now().UnixNano()))
-)
-
 type client struct {
 	mu     sync.Mutex
 	logger *zap.Logger
+	// logs each packet read and written at debug, off unless its subsystem is at debug
+	packetLogger *zap.Logger
 
 	conn net.Conn
 
 	broker *Broker
 	info   info
-	status bool
-
-	ctx             context.Context
-	cancelFunc      context.CancelFunc
-	session         *sessions.Session
-	topicsManager   *topics.Manager
+	// read by the writers of the deliveries, the shutdown and the handoff close the client from
+	// their own goroutine
+	status atomic.Bool
+
+	ctx           context.Context
+	cancelFunc    context.CancelFunc
+	session       *sessions.Session
+	topicsManager *topics.Manager
+	// changed under mu, the control requests of the admin clients remove from it too
 	subscriptionMap map[string]*subscription
 
-	subList             []interface{}
+	subList             []topics.Subscriber
 	qosList             []byte
 	retainedMessageList []*packets.PublishPacket
+	retainedDeliveries  []retainedDelivery
+
+	latency  *pingLatency
+	batching *deliveryBatch
+	tenant   *pool.TenantPool
+	limiter  *quota.Limiter
+
+	// The topic aliases set by a 5.0 client, and whether a new connection took its session over.
+	topicAliases map[uint16]string
+	takenOver    bool
+
+	// The QoS 1 and 2 deliveries waiting for their acknowledgement, by packet id, and their count
+	// read by the share groups
+	inflight      map[uint16]*inflightDelivery
+	inflightCount atomic.Int32
+
+	// The queue of the deliveries written by the writer of the client, and whether it's
+	// disconnected as a slow subscriber
+	outq *outbound.Queue
+	slow atomic.Bool
+
+	// The bytes written to the connection, gathered while the writer of the client is corked;
+	// guarded by mu
+	out    netbuf.Writer
+	corked bool
+
+	// The authentication method of a token client, and the expiries of its fresh tokens
+	authMethod string
+	reauth     chan time.Time
+
+	// The memory accounted to the client, nil if the broker accounts none
+	memory *memacct.Account
+
+	// The topic namespace of the tenant of the client, nil if it has none
+	namespace *namespace.Tenant
 }
 
 type info struct {
@@ -61,23 +99,32 @@ type info struct {
 	password    []byte
 	keepalive   uint16
 	willMessage *packets.PublishPacket
+	willDelay   time.Duration
 	localIP     string
 	remoteIP    string
+	listener    string
+
+	protocolVersion byte
+	sessionExpiry   uint32
+	cleanSession    bool
 }
 
 func (c *client) init() {
 	c.mu = sync.Mutex{}
-	c.status = Connected
+	c.status.Store(Connected)
 
 	c.info.localIP = strings.Split(c.conn.LocalAddr().String(), ":")[0]
 	c.info.remoteIP = strings.Split(c.conn.RemoteAddr().String(), ":")[0]
 
 	c.ctx, c.cancelFunc = context.WithCancel(context.Background())
 	c.subscriptionMap = make(map[string]*subscription)
+	c.latency = newPingLatency()
 
 	c.topicsManager = c.broker.topicsManager
 
-	c.logger = logger.Get()
+	c.logger = c.broker.subsystemLogger(LogClient).With(logging.ClientID(c.info.clientID))
+	c.packetLogger = c.broker.subsystemLogger(LogPackets).With(logging.ClientID(c.info.clientID))
+	c.openMemory()
 }
 
 func (c *client) readLoop() {
@@ -87,44 +134,67 @@ func (c *client) readLoop() {
 		return
 	}
 
-	keepAlive := time.Second * time.Duration(c.info.keepalive)
-	timeOut := keepAlive + (keepAlive / 2)
-
 	defer c.Close()
 
+	// the timer wheels of the broker time the connection out, instead of the read deadline
+	if err := nc.SetReadDeadline(time.Time{}); err != nil {
+		c.logger.Error("core_module/client/readLoop: clear read timeout error => ",
+			zap.Error(err),
+		)
+		return
+	}
+	alive := b.keepalives.Add(time.Second*time.Duration(c.info.keepalive), func(reason keepalive.Reason) {
+		c.expireConnection(nc, reason)
+	})
+	defer alive.Remove()
+
+	br := netbuf.NewReader(&progressReader{conn: nc, alive: alive}, readBufferSize)
+	defer br.Release()
+	r := &stampedReader{Reader: br}
 	for {
 		select {
 		case <-c.ctx.Done():
 			return
 		default:
-			//add read timeout
-			if err := nc.SetReadDeadline(time.Now().Add(timeOut)); err != nil {
-				c.logger.Error("core_module/client/readLoop: set read timeout error => ",
-					zap.Error(err),
-					zap.String("ClientID", c.info.clientID),
-				)
+			if !c.waitMemory(alive) {
 				return
 			}
-
-			packet, err := packets.ReadPacket(nc)
+			r.reset()
+			packet, v5, err := c.readPacket(r)
 			if err != nil {
 				if errors.Is(err, io.EOF) {
-					c.logger.Warn("core_module/client/readLoop: read packet io.EOF => ",
-						zap.String("ClientID", c.info.clientID),
-					)
+					c.logger.Warn("core_module/client/readLoop: read packet io.EOF => ")
 				} else {
 					c.logger.Error("core_module/client/readLoop: read packet error => ",
 						zap.Error(err),
-						zap.String("ClientID", c.info.clientID),
 					)
 				}
 				return
 			}
 			msg := &Message{
-				client: c,
-				packet: packet,
+				client:   c,
+				packet:   packet,
+				v5:       v5,
+				received: time.Now(),
+				size:     r.n,
+			}
+			c.memory.Add(memacct.Read, int64(r.n))
+			b.stageLatency.observe(StageDecode, msg.received.Sub(r.first))
+			_, ping := packet.(*packets.PingreqPacket)
+			alive.Touch(!ping)
+			_, publish := packet.(*packets.PublishPacket)
+			b.sysStats.Received(r.n, publish)
+			c.logPacket(packet, true)
+			c.capturePacket(packet, v5, true)
+			process, open := c.limitPublish(msg)
+			if !open {
+				return
+			}
+			if process {
+				b.SubmitWorkTask(msg)
+			} else {
+				msg.releaseMemory()
 			}
-			b.SubmitWorkTask(msg)
 		}
 	}
 }
@@ -135,144 +205,213 @@ func ProcessMessage(msg *Message) {
 	if ca == nil {
 		return
 	}
-
-	//log.Debug("client/ProcessMessage: Recv message => ", zap.String("message type", reflect.TypeOf(msg.packet).String()[9:]), zap.String("ClientID", c.info.clientID))
+	defer msg.releaseMemory()
 
 	switch ca.(type) {
 	case *packets.ConnackPacket:
 	case *packets.ConnectPacket:
+		// A second CONNECT on the same connection is a protocol violation, it may be a replay.
+		c.logger.Warn("core_module/client/ProcessMessage: Recv connect again, close the client ")
+		c.disconnect(mqtt5.ProtocolError)
 	case *packets.PublishPacket:
 		packet := ca.(*packets.PublishPacket)
-		c.ProcessPublish(packet)
+		c.processClientPublish(packet, msg.v5)
+		msg.releasePublish()
 	case *packets.PubackPacket:
+		c.releasePacketID(ca.(*packets.PubackPacket).MessageID)
 	case *packets.PubrecPacket:
+		c.processPubrec(ca.(*packets.PubrecPacket))
 	case *packets.PubrelPacket:
+		c.processPubrel(ca.(*packets.PubrelPacket))
 	case *packets.PubcompPacket:
+		c.releasePacketID(ca.(*packets.PubcompPacket).MessageID)
 	case *packets.SubscribePacket:
 		packet := ca.(*packets.SubscribePacket)
-		c.ProcessSubscribe(packet)
+		c.processClientSubscribe(packet, msg.v5)
 	case *packets.SubackPacket:
 	case *packets.UnsubscribePacket:
 		packet := ca.(*packets.UnsubscribePacket)
 		c.ProcessUnSubscribe(packet)
 	case *packets.UnsubackPacket:
 	case *packets.PingreqPacket:
-		c.ProcessPing()
+		if c.ProcessPing() {
+			c.observePing(msg.received)
+		}
 	case *packets.PingrespPacket:
 	case *packets.DisconnectPacket:
+		c.processDisconnect(msg.v5)
 		c.Close()
+	case *mqtt5.AuthPacket:
+		c.processAuth(msg.v5)
 	default:
 		//log.Info("client/ProcessMessage: Recv unknown message .......", zap.String("ClientID", c.info.clientID))
 	}
 }
 
 func (c *client) ProcessPublish(packet *packets.PublishPacket) {
-	c.processClientPublish(packet)
+	c.processClientPublish(packet, nil)
 }
 
-func (c *client) processClientPublish(packet *packets.PublishPacket) {
-	if c.status == Disconnected {
+// v5 holds the 5.0 fields of the publish, it's nil for the 3.1.1 clients.
+func (c *client) processClientPublish(packet *packets.PublishPacket, v5 *mqtt5.Packet) {
+	if c.status.Load() == Disconnected {
+		return
+	}
+
+	authStart := time.Now()
+	if c.broker != nil && c.broker.readOnly {
+		c.rejectPublish(packet)
+		return
+	}
+
+	if c.broker != nil && !c.broker.faultInboundPublish(packet) {
+		return
+	}
+
+	if c.processReplayRequest(packet) {
+		return
+	}
+	if c.processControlRequest(packet) {
+		return
+	}
+	if c.processDelayedPublish(packet) {
 		return
 	}
 
-	// TODO CheckTopicAuth
+	c.rewritePublish(packet)
+	if !c.allowPublish(packet) {
+		c.denyPublish(packet)
+		return
+	}
+	if !c.pluginPublish(packet) {
+		c.denyPublish(packet)
+		return
+	}
+	if !c.validPayload(packet) {
+		return
+	}
+	if c.broker != nil {
+		c.broker.stageLatency.since(StageAuth, authStart)
+	}
 
 	switch packet.Qos {
 	case QosAtMostOnce:
-		c.ProcessPublishMessage(packet)
+		c.processPublishMessage(packet, v5)
 	case QosAtLeastOnce:
 		pubAck := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
 		pubAck.MessageID = packet.MessageID
 		if err := c.WriterPacket(pubAck); err != nil {
 			c.logger.Error("core_module/client/processClientPublish: send pubAck error, ",
 				zap.Error(err),
-				zap.String("ClientID", c.info.clientID),
 			)
 			return
 		}
-		c.ProcessPublishMessage(packet)
+		c.processPublishMessage(packet, v5)
 	case QosExactlyOnce:
-		return
+		c.processQos2Publish(packet, v5)
 	default:
-		c.logger.Error("core_module/client/processClientPublish: publish with unknown qos ",
-			zap.String("ClientID", c.info.clientID),
-		)
+		c.logger.Error("core_module/client/processClientPublish: publish with unknown qos ")
 		return
 	}
 }
 
 // The work pool will process the PublishPacket for this broker, and the other module that not in the work pool will forward it to other brokers.
 func (c *client) ProcessPublishMessage(packet *packets.PublishPacket) {
+	c.processPublishMessage(packet, nil)
+}
+
+// The retained message of a publish with a message expiry (MQTT 5.0) is dropped once it has
+// elapsed. The properties of the publish are delivered to the subscribers of this broker.
+func (c *client) processPublishMessage(packet *packets.PublishPacket, v5 *mqtt5.Packet) {
 	b := c.broker
 	if b == nil {
 		return
 	}
+	expiry := messageExpiry(v5)
+	defer b.tracePublish(c, packet, v5)()
 
 	// it's very important section
 	// put it to the candidate-forward-confirm channel
 	b.brokerNode.candidateForwardConfirmChan <- packet
 
+	// the command topic of a device owned by another broker is processed there only
+	if owner, ok := b.commandOwner(packet.TopicName); ok && owner != b.BrokerID().String() {
+		return
+	}
+
 	if packet.Retain {
-		if err := c.topicsManager.Retain(packet); err != nil {
+		err := b.faultStoreWrite("retained")
+		if err == nil {
+			err = c.topicsManager.RetainWithExpiry(packet, expiry)
+		}
+		if err != nil {
 			c.logger.Error("core_module/client/ProcessPublishMessage: Error retaining message => ",
 				zap.Error(err),
-				zap.String("ClientID", c.info.clientID),
 			)
+		} else {
+			b.replicateRetainedMessage(packet)
+			b.recordRetained(c.info.clientID, packet)
 		}
 	}
+	b.logPublish(packet)
+	c.topicsManager.ObservePublish(packet.TopicName, b.clock.Now())
+	b.samplePublish(packet)
+
+	matchStart := time.Now()
+	matchSpan := b.startSpan(spanMatch, b.traceParent(packet))
 	c.mu.Lock()
-	err := c.topicsManager.Subscribers([]byte(packet.TopicName), packet.Qos, &c.subList, &c.qosList)
+	err := c.topicsManager.SubscribersFrom([]byte(packet.TopicName), packet.Qos, c.info.clientID, &c.subList, &c.qosList)
 	c.mu.Unlock()
+	matchSpan.End()
+	b.stageLatency.since(StageMatch, matchStart)
 
 	if err != nil {
 		c.logger.Error("core_module/client/ProcessPublishMessage: Error retrieving subscribers list => ",
 			zap.Error(err),
-			zap.String("ClientID", c.info.clientID),
 		)
 		return
 	}
 
 	if len(c.subList) == 0 {
+		b.sealReceipt(packet, b.startReceipt(c, packet))
+		b.deadLetter(DeadLetterNoSubscribers, c.info.clientID, packet)
 		return
 	}
 
-	var qSub []int
-	for i, sub := range c.subList {
-		s, ok := sub.(*subscription)
-		if ok {
-			if s.share {
-				qSub = append(qSub, i)
-			} else {
-				err := s.client.WriterPacket(packet)
-				if err != nil {
-					c.logger.Error("core_module/client/ProcessPublishMessage: Error publish to subscriber => ",
-						zap.Error(err),
-						zap.String("ClientID", c.info.clientID),
-					)
-				}
+	retain := packet.Retain
+	live := b.liveDeliveryPacket(packet)
+	defer b.aliasTrace(live, packet)()
+	packet = live
+	defer b.sealReceipt(packet, b.startReceipt(c, packet))
+	props := publishProperties(v5)
+	shared := sharedDelivery(packet, len(c.subList))
+
+	// the topics provider returns one member of each share group
+	for _, sub := range c.subList {
+		switch s := sub.(type) {
+		case *subscription:
+			pkt, release := b.retainAsPublished(s, packet, retain)
+			err := s.client.deliverProperties(pkt, s.topic, s.identifierProperties(props), shared)
+			release()
+			if err != nil {
+				c.logger.Error("core_module/client/ProcessPublishMessage: Error publish to subscriber => ",
+					zap.Error(err),
+				)
 			}
-		}
-	}
-
-	if len(qSub) > 0 {
-		idx := r.Intn(len(qSub))
-		sub := c.subList[qSub[idx]].(*subscription)
-		err := sub.client.WriterPacket(packet)
-		if err != nil {
-			c.logger.Error("core_module/client/ProcessPublishMessage: Error publish to subscriber [share group random mode] => ",
-				zap.Error(err),
-				zap.String("ClientID", c.info.clientID),
-			)
+		case internalSubscriber:
+			s.deliver(b, packet)
 		}
 	}
 }
 
 func (c *client) ProcessSubscribe(packet *packets.SubscribePacket) {
-	c.processClientSubscribe(packet)
+	c.processClientSubscribe(packet, nil)
 }
 
-func (c *client) processClientSubscribe(packet *packets.SubscribePacket) {
-	if c.status == Disconnected {
+// The user properties of the SUBSCRIBE of a 5.0 client may set the priority of its shared
+// subscriptions.
+func (c *client) processClientSubscribe(packet *packets.SubscribePacket, v5 *mqtt5.Packet) {
+	if c.status.Load() == Disconnected {
 		return
 	}
 
@@ -281,80 +420,147 @@ func (c *client) processClientSubscribe(packet *packets.SubscribePacket) {
 		return
 	}
 
-	topicList := packet.Topics
-	qosList := packet.Qoss
+	returnCodeList, ok := c.subscribeTopics(packet.Topics, packet.Qoss, v5)
+	if !ok {
+		return
+	}
 
 	subAck := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
 	subAck.MessageID = packet.MessageID
+	subAck.ReturnCodes = returnCodeList
+	err := c.WriterPacket(subAck)
+	if err != nil {
+		c.logger.Error("core_module/client/processClientSubscribe send subAck error, ",
+			zap.Error(err),
+		)
+		return
+	}
+
+	c.deliverRetained()
+}
+
+// subscribeTopics subscribes the client to the filters and returns their return codes, the
+// retained messages to deliver are kept in retainedDeliveries. It's false if the client is
+// disconnected meanwhile.
+func (c *client) subscribeTopics(topicList []string, qosList []byte, v5 *mqtt5.Packet) ([]byte, bool) {
+	b := c.broker
+	id, err := subscriptionIdentifier(v5)
+	if err != nil {
+		c.invalidSubscribe(err)
+		return nil, false
+	}
+
 	var returnCodeList []byte
+	c.retainedDeliveries = c.retainedDeliveries[0:0]
 
 	for i, topic := range topicList {
+		// The client opts in the coalesced delivery by subscribing to the container topic.
+		if interval, ok := batch.ParseTopic(topic); ok {
+			c.enableBatching(interval)
+			returnCodeList = append(returnCodeList, QosAtMostOnce)
+			continue
+		}
+
+		topic = c.rewriteSubscribe(topic)
 		t := topic
 
-		//TODO CheckTopicAuth
+		// The shared subscriptions are kept by the topics provider with their share group, the
+		// retained messages are looked up with the filter only.
+		groupName, filter, share, err := topics.ParseSharedFilter([]byte(topic))
+		if err != nil {
+			returnCodeList = append(returnCodeList, QosFailure)
+			continue
+		}
+		topic = string(filter)
+
+		options, err := subOptions(v5, i, qosList[i], share, id)
+		if err != nil {
+			c.invalidSubscribe(err)
+			return nil, false
+		}
 
-		groupName := ""
-		share := false
-		if strings.HasPrefix(topic, "$share/") {
-			substr := groupCompile.FindStringSubmatch(topic)
-			if len(substr) != 3 {
-				returnCodeList = append(returnCodeList, QosFailure)
-				continue
+		c.mu.Lock()
+		_, existed := c.subscriptionMap[t]
+		c.mu.Unlock()
+		if !existed && !c.limitSubscription() {
+			if c.status.Load() == Disconnected {
+				return nil, false
 			}
-			share = true
-			groupName = substr[1]
-			topic = substr[2]
+			returnCodeList = append(returnCodeList, c.quotaSubscribeCode())
+			continue
+		}
+
+		if !c.allowSubscribe(topic) || !c.pluginSubscribe(t, qosList[i]) {
+			returnCodeList = append(returnCodeList, c.subscribeDeniedCode())
+			continue
 		}
 
 		sub := &subscription{
 			client:    c,
-			topic:     topic,
+			topic:     t,
 			qos:       qosList[i],
 			share:     share,
 			groupName: groupName,
+			options:   options,
 		}
+		c.setSharePriority(sub, v5)
 
-		returnQos, err := c.topicsManager.Subscribe([]byte(topic), qosList[i], sub)
+		res, err := c.topicsManager.SubscribeResult([]byte(t), qosList[i], sub)
 		if err != nil {
-			c.logger.Error("core_module/client/processClientSubscribe error, ",
+			c.logger.Error("core_module/client/subscribeTopics error, ",
 				zap.Error(err),
-				zap.String("ClientID", c.info.clientID),
 			)
-			returnCodeList = append(returnCodeList, QosFailure)
+			returnCodeList = append(returnCodeList, c.subscribeErrorCode(err))
 			continue
 		}
 
+		if !existed {
+			b.sysStats.Subscribed(1)
+		}
+		c.mu.Lock()
 		c.subscriptionMap[t] = sub
+		c.mu.Unlock()
+		b.recordState(statelog.Event{Kind: statelog.Subscribed, ClientID: c.info.clientID, Topic: t, Qos: qosList[i]})
 
 		_ = c.session.AddTopic(t, qosList[i])
+		returnQos := res.Qos
 		returnCodeList = append(returnCodeList, returnQos)
-		_ = c.topicsManager.Retained([]byte(topic), &c.retainedMessageList)
+		// the subscription of the session resumed after a restart is not in the map yet, but
+		// the provider kept it
+		if options.SendRetained(existed || res.Existed) {
+			c.retainedMessageList = c.retainedMessageList[0:0]
+			_ = c.topicsManager.Retained([]byte(topic), &c.retainedMessageList)
+			for _, rm := range c.retainedMessageList {
+				c.retainedDeliveries = append(c.retainedDeliveries, retainedDelivery{packet: rm, qos: returnQos, id: id})
+			}
+		}
 
 		//process map for adding the subscriber number to the topic
 		c.broker.brokerNode.ProcessSubNumMapForAdd(t)
 	}
 
-	subAck.ReturnCodes = returnCodeList
-	err := c.WriterPacket(subAck)
-	if err != nil {
-		c.logger.Error("core_module/client/processClientSubscribe send subAck error, ",
-			zap.Error(err),
-			zap.String("ClientID", c.info.clientID),
-		)
-		return
+	if c.persistentSession() {
+		b.saveSession(c.info.clientID)
 	}
 
-	//process retain message
-	for _, rm := range c.retainedMessageList {
-		if err := c.WriterPacket(rm); err != nil {
-			c.logger.Error("core_module/client/processClientSubscribe: publishing retained message error, ",
+	return returnCodeList, true
+}
+
+// deliverRetained writes the retained messages matched by the filters subscribed.
+func (c *client) deliverRetained() {
+	b := c.broker
+	for _, rd := range c.retainedDeliveries {
+		pkt, err := c.outboundPacket(b.retainedDeliveryPacket(rd.packet, rd.qos))
+		if err == nil {
+			err = c.writePacket(pkt, c.retainedExtFor(rd.packet.TopicName, rd.id))
+		}
+		if err != nil {
+			c.logger.Error("core_module/client/deliverRetained: publishing retained message error, ",
 				zap.Any("err", err),
-				zap.String("ClientID", c.info.clientID),
 			)
 		} else {
-			c.logger.Info("core_module/client/processClientSubscribe: process retain  message, ",
-				zap.Any("packet", packet),
-				zap.String("ClientID", c.info.clientID),
+			c.logger.Info("core_module/client/deliverRetained: process retain  message, ",
+				zap.String("topic", rd.packet.TopicName),
 			)
 		}
 	}
@@ -365,7 +571,7 @@ func (c *client) ProcessUnSubscribe(packet *packets.UnsubscribePacket) {
 }
 
 func (c *client) processClientUnSubscribe(packet *packets.UnsubscribePacket) {
-	if c.status == Disconnected {
+	if c.status.Load() == Disconnected {
 		return
 	}
 
@@ -375,87 +581,139 @@ func (c *client) processClientUnSubscribe(packet *packets.UnsubscribePacket) {
 	}
 
 	topicList := packet.Topics
+	reasonCodes := make([]byte, 0, len(topicList))
 	for _, topic := range topicList {
-		sub, exist := c.subscriptionMap[topic]
-		if exist {
-			_ = c.topicsManager.Unsubscribe([]byte(sub.topic), sub)
-			_ = c.session.RemoveTopic(topic)
-			delete(c.subscriptionMap, topic)
-
-			//process map for deleting the subscriber number to the topic
-			c.broker.brokerNode.ProcessSubNumMapForDel(topic)
+		topic = c.rewriteSubscribe(topic)
+		if c.removeSubscription(topic) {
+			reasonCodes = append(reasonCodes, mqtt5.Success)
+		} else {
+			reasonCodes = append(reasonCodes, mqtt5.NoSubscriptionExisted)
 		}
 	}
 
+	if c.persistentSession() {
+		b.saveSession(c.info.clientID)
+	}
+
 	unsubAck := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
 	unsubAck.MessageID = packet.MessageID
 
-	err := c.WriterPacket(unsubAck)
+	err := c.writePacket(unsubAck, &mqtt5.Packet{ReasonCodes: reasonCodes})
 	if err != nil {
 		c.logger.Error("core_module/client/processClientUnSubscribe send unsubAck error, ",
 			zap.Error(err),
-			zap.String("ClientID", c.info.clientID),
 		)
 		return
 	}
 }
 
-func (c *client) ProcessPing() {
-	if c.status == Disconnected {
-		return
+// removeSubscription removes the subscription of the filter from the trie and the session, false if
+// the client has none.
+func (c *client) removeSubscription(topic string) bool {
+	b := c.broker
+	c.mu.Lock()
+	sub, exist := c.subscriptionMap[topic]
+	delete(c.subscriptionMap, topic)
+	c.mu.Unlock()
+	if !exist {
+		return false
+	}
+	_ = c.topicsManager.Unsubscribe([]byte(sub.topic), sub)
+	_ = c.session.RemoveTopic(topic)
+	b.sysStats.Subscribed(-1)
+	b.recordState(statelog.Event{Kind: statelog.Unsubscribed, ClientID: c.info.clientID, Topic: topic})
+
+	//process map for deleting the subscriber number to the topic
+	b.brokerNode.ProcessSubNumMapForDel(topic)
+	return true
+}
+
+// ProcessPing returns true if the PINGRESP has been written.
+func (c *client) ProcessPing() bool {
+	if c.status.Load() == Disconnected {
+		return false
 	}
 	ping := packets.NewControlPacket(packets.Pingresp).(*packets.PingrespPacket)
 	err := c.WriterPacket(ping)
 	if err != nil {
 		c.logger.Error("core_module/client/ProcessPing error, ",
 			zap.Error(err),
-			zap.String("ClientID", c.info.clientID),
 		)
-		return
+		return false
 	}
+	return true
 }
 
+// Close closes the client once, whichever goroutine closes it first. The connection is closed but
+// kept, the writers running meanwhile get its write error.
 func (c *client) Close() {
-	if c.status == Disconnected {
+	if !c.status.CAS(Connected, Disconnected) {
 		return
 	}
 
 	c.cancelFunc()
-	c.status = Disconnected
+	c.memory.Close()
 
 	if c.conn != nil {
 		_ = c.conn.Close()
-		c.conn = nil
 	}
 
 	b := c.broker
+	c.mu.Lock()
 	subMap := c.subscriptionMap
+	c.subscriptionMap = make(map[string]*subscription)
+	c.mu.Unlock()
 	if b != nil {
 		b.removeClient(c)
+		b.sysStats.Subscribed(-len(subMap))
 		for _, sub := range subMap {
 			err := b.topicsManager.Unsubscribe([]byte(sub.topic), sub)
 			if err != nil {
 				c.logger.Error("core_module/client/Close unsubscribe error, ",
 					zap.Error(err),
-					zap.String("ClientID", c.info.clientID),
 				)
 			}
 
-			//process map for deleting the subscriber number to the topic
-			b.brokerNode.ProcessSubNumMapForDel(sub.topic)
+			// the peer brokers keep forwarding the filters of a persistent session, its messages
+			// are queued while the client is offline
+			if !c.persistentSession() || sub.share || c.takenOver {
+				b.brokerNode.ProcessSubNumMapForDel(sub.topic)
+			}
 		}
 
 		//offline notification
 		b.OnlineOfflineNotification(c.info.clientID, false)
 
-		if c.info.willMessage != nil {
-			b.SubmitPublishPacketsWorkTask(c.info.willMessage)
+		if c.persistentSession() {
+			b.parkSession(c)
+		} else {
+			c.dropInflight()
 		}
+
+		b.expireSession(c)
+
+		b.publishWill(c)
+
+		b.plugins.Disconnect(c.pluginClient())
+		b.recordSession(statelog.SessionClosed, c)
 	}
 }
 
+// closeWithoutWill closes the client which is not gone, its session goes on elsewhere: the will
+// message is dropped before the client is closed, from any goroutine.
+func (c *client) closeWithoutWill() {
+	c.dropWill()
+	c.Close()
+}
+
 func (c *client) WriterPacket(packet packets.ControlPacket) error {
-	if c.status == Disconnected {
+	return c.writePacket(packet, nil)
+}
+
+// writePacket writes the packet in the version of the client, ext holds the 5.0 fields of the
+// packet (its Control is ignored), it may be nil.
+func (c *client) writePacket(packet packets.ControlPacket, ext *mqtt5.Packet) error {
+	if c.status.Load() == Disconnected {
 		return nil
 	}
 
@@ -467,8 +725,39 @@ func (c *client) WriterPacket(packet packets.ControlPacket) error {
 		return errors.New("core_module/client/WriterPacket: connection lost")
 	}
 
+	if c.broker != nil {
+		defer c.broker.stageLatency.since(StageWrite, time.Now())
+		c.faultSlowWrite()
+	}
+
+	if pub, ok := packet.(*packets.PublishPacket); ok {
+		packet = c.namespaceDelivery(pub)
+	}
+
+	var err error
 	c.mu.Lock()
-	err := packet.Write(c.conn)
+	start := c.out.Buffered()
+	if c.isV5() {
+		p := mqtt5.Packet{Control: packet}
+		if ext != nil {
+			p = *ext
+			p.Control = packet
+		}
+		err = mqtt5.Write(&c.out, &p)
+	} else {
+		err = packet.Write(&c.out)
+	}
+	n := c.out.Buffered() - start
+	if err == nil {
+		err = c.flushOut()
+	}
 	c.mu.Unlock()
+
+	if err == nil && c.broker != nil {
+		_, publish := packet.(*packets.PublishPacket)
+		c.broker.sysStats.Sent(n, publish)
+		c.logPacket(packet, false)
+		c.capturePacket(packet, ext, false)
+	}
 	return err
 }
@@ -13,6 +13,8 @@ type PacketForwardMetrics struct {
 	numOfForwardToMultiBroker   uint64
 	numOfForwardParcelOverP2P   uint64
 	numOfTopicMatchBrokerFailed uint64
+	numOfForwardDropped         uint64
+	numOfForwardCreditExhausted uint64
 }
 
 func (p *PacketForwardMetrics) increasingNumOfCandidate() {
@@ -35,12 +37,22 @@ func (p *PacketForwardMetrics) increasingNumOfTopicMatchBrokerFailed() {
 	atomic.AddUint64(&p.numOfTopicMatchBrokerFailed, 1)
 }
 
+func (p *PacketForwardMetrics) increasingNumOfForwardDropped(n uint64) {
+	atomic.AddUint64(&p.numOfForwardDropped, n)
+}
+
+func (p *PacketForwardMetrics) increasingNumOfForwardCreditExhausted() {
+	atomic.AddUint64(&p.numOfForwardCreditExhausted, 1)
+}
+
 func (p *PacketForwardMetrics) MetricsInfo() string {
-	return fmt.Sprintf(`{"candidate":"%s","forwarding":"%s","forward to multi-brokers":"%s","forward parcel over p2p":"%s","topic match broker_id failed":%d}`,
+	return fmt.Sprintf(`{"candidate":"%s","forwarding":"%s","forward to multi-brokers":"%s","forward parcel over p2p":"%s","topic match broker_id failed":%d,"forward dropped":"%s","forward credit exhausted":"%s"}`,
 		humanize.Comma(int64(p.numOfCandidate)),
 		humanize.Comma(int64(p.numOfForwarding)),
 		humanize.Comma(int64(p.numOfForwardToMultiBroker)),
 		humanize.Comma(int64(p.numOfForwardParcelOverP2P)),
 		p.numOfTopicMatchBrokerFailed,
+		humanize.Comma(int64(p.numOfForwardDropped)),
+		humanize.Comma(int64(p.numOfForwardCreditExhausted)),
 	)
 }
@@ -0,0 +1,37 @@
+//go:build linux && !386
+// +build linux,!386
+
+package broker_core_module
+
+import (
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// tcpRTT returns the smoothed round-trip time measured by the kernel for the TCP connection.
+func tcpRTT(conn net.Conn) (time.Duration, bool) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0, false
+	}
+
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+
+	var info syscall.TCPInfo
+	var errno syscall.Errno
+	err = raw.Control(func(fd uintptr) {
+		size := uint32(syscall.SizeofTCPInfo)
+		_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	if err != nil || errno != 0 {
+		return 0, false
+	}
+
+	return time.Duration(info.Rtt) * time.Microsecond, true
+}
@@ -0,0 +1,14 @@
+//go:build !linux || (linux && 386)
+// +build !linux linux,386
+
+package broker_core_module
+
+import (
+	"net"
+	"time"
+)
+
+// The TCP round-trip time is not available on this platform.
+func tcpRTT(conn net.Conn) (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,65 @@
+package broker_p2p_module
+
+import (
+	"encoding/json"
+	"errors"
+
+	mqtt "awesomeProject/beacon/mqtt_network/broker_core_module"
+)
+
+//opCode (CreditOpCode) : the credits granted back to the source broker of the forwarded packets.
+
+type ForwardCredit struct {
+	SourceBrokerId string `json:"source_broker_id"`
+	TargetBrokerId string `json:"target_broker_id"`
+	Seq            uint64 `json:"seq"`
+	Credits        int    `json:"credits"`
+}
+
+func (f *ForwardCredit) Marshal() ([]byte, error) {
+	return json.Marshal(f)
+}
+
+func UnmarshalForwardCredit(data []byte) (*ForwardCredit, error) {
+	fc := &ForwardCredit{}
+	err := json.Unmarshal(data, fc)
+
+	return fc, err
+}
+
+func NewForwardCreditToMessageOverP2P(fc ForwardCredit) (*MessageOverP2P, error) {
+	if len(fc.SourceBrokerId) < 1 || len(fc.TargetBrokerId) < 1 {
+		return nil, errors.New("NewForwardCreditToMessageOverP2P => no broker id found ")
+	}
+	fcData, err := fc.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return &MessageOverP2P{opCode: CreditOpCode, payLoad: fcData}, nil
+}
+
+// After the forwarded packets are submitted, one credit is granted back to the source broker,
+// nothing is granted to the brokers of the previous versions which don't frame the batches.
+func grantForwardCreditToSourceNode(b *mqtt.Broker, fps *ForwardPackets) {
+	if len(fps.SourceBrokerId) < 1 {
+		return
+	}
+	sourceNodeIdAddr := b.BrokerNode().NodeIdAddrGetFromMap(fps.SourceBrokerId)
+	if sourceNodeIdAddr == "ERROR" {
+		return
+	}
+
+	msgOverP2P, err := NewForwardCreditToMessageOverP2P(ForwardCredit{
+		SourceBrokerId: b.BrokerID().String(),
+		TargetBrokerId: fps.SourceBrokerId,
+		Seq:            fps.Seq,
+		Credits:        1,
+	})
+	if err != nil {
+		return
+	}
+	msgParcel := NewPendingMessageParcel(sourceNodeIdAddr, msgOverP2P)
+	if msgParcel != nil {
+		msgParcel.Pending()
+	}
+}
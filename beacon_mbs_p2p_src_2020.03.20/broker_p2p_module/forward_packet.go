@@ -6,6 +6,8 @@ import (
 
 	"sync/atomic"
 
+	mqtt "awesomeProject/beacon/mqtt_network/broker_core_module"
+
 	"github.com/eclipse/paho.mqtt.golang/packets"
 )
 
@@ -16,9 +18,15 @@ var (
 
 //opCode (PacketsOpCode) : the packets for the target broker.
 
+// SourceBrokerId and Seq frame the batch, the target broker grants a credit back to the source
+// broker after processing it. They're empty if sent by the brokers of the previous versions.
 type ForwardPackets struct {
+	SourceBrokerId string                  `json:"source_broker_id,omitempty"`
 	TargetBrokerId string                  `json:"target_broker_id"`
+	Seq            uint64                  `json:"seq,omitempty"`
 	PacketList     []packets.PublishPacket `json:"packet_list"`
+	// Traces are the traceparents of the packets, by index, if one of them is traced
+	Traces []string `json:"traces,omitempty"`
 }
 
 func (f *ForwardPackets) Marshal() ([]byte, error) {
@@ -32,14 +40,20 @@ func UnmarshalForwardPackets(data []byte) (*ForwardPackets, error) {
 	return fps, err
 }
 
-func NewForwardPacketsToMessageOverP2P(targetBrokerIdStr string, packetList []packets.PublishPacket) (*MessageOverP2P, error) {
-	if len(targetBrokerIdStr) < 1 {
+func NewForwardPacketsToMessageOverP2P(batch mqtt.ForwardBatch) (*MessageOverP2P, error) {
+	if len(batch.TargetBrokerId) < 1 {
 		return nil, errors.New("NewForwardPacketsToMessageOverP2P => no target broker id found ")
 	}
-	if len(packetList) < 1 {
+	if len(batch.PacketList) < 1 {
 		return nil, errors.New("NewForwardPacketsToMessageOverP2P => no publish packet found ")
 	}
-	fps := ForwardPackets{TargetBrokerId: targetBrokerIdStr, PacketList: packetList}
+	fps := ForwardPackets{
+		SourceBrokerId: batch.SourceBrokerId,
+		TargetBrokerId: batch.TargetBrokerId,
+		Seq:            batch.Seq,
+		PacketList:     batch.PacketList,
+		Traces:         batch.Traces,
+	}
 	fpsData, err := fps.Marshal()
 	if err != nil {
 		atomic.AddUint32(&forwardPacketsFailedOverP2P, 1)
@@ -48,11 +62,11 @@ func NewForwardPacketsToMessageOverP2P(targetBrokerIdStr string, packetList []pa
 	return &MessageOverP2P{opCode: PacketsOpCode, payLoad: fpsData}, nil
 }
 
-func deliverForwardPacketsToTargetNode(targetIDAddr string, targetBrokerIdStr string, packetList []packets.PublishPacket) {
-	if len(targetIDAddr) < 1 || len(targetBrokerIdStr) < 1 || len(packetList) < 1 {
+func deliverForwardPacketsToTargetNode(targetIDAddr string, batch mqtt.ForwardBatch) {
+	if len(targetIDAddr) < 1 || len(batch.TargetBrokerId) < 1 || len(batch.PacketList) < 1 {
 		return
 	}
-	msgOverP2P, err := NewForwardPacketsToMessageOverP2P(targetBrokerIdStr, packetList)
+	msgOverP2P, err := NewForwardPacketsToMessageOverP2P(batch)
 	if err != nil {
 		return
 	}
@@ -49,7 +49,7 @@ func swapNodeIdsInfoWithPeerNode(broker *mqtt.Broker, targetIDAddress string) {
 	if err != nil {
 		return
 	}
-	res, errR := broker.Node().RequestMessage(context.TODO(), targetIDAddress, *msgOverP2P)
+	res, errR := broker.Node().RequestMessage(context.TODO(), targetIDAddress, *sealMessage(broker, msgOverP2P))
 	if errR != nil {
 		return
 	}
@@ -77,6 +77,6 @@ func swapNodeIdsInfoWithPeerNode(broker *mqtt.Broker, targetIDAddress string) {
 func setNodeIdsInfoMsgOverP2PForSwap(broker *mqtt.Broker) {
 	msgOverP2P, err := NewNodeIdsInfoToMessageOverP2P(broker)
 	if err == nil {
-		nodeIdsInfoMsgOverP2PForSwap = msgOverP2P
+		nodeIdsInfoMsgOverP2PForSwap = sealMessage(broker, msgOverP2P)
 	}
 }
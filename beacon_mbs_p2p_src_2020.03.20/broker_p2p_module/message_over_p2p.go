@@ -17,6 +17,10 @@ const (
 	NodeIDSInfoOpCode  = byte(1)
 	TopicActionsOpCode = byte(2)
 	PacketsOpCode      = byte(4)
+	CreditOpCode       = byte(8)
+	RelayOpCode        = byte(16)
+	RetainedOpCode     = byte(32)
+	SessionOpCode      = byte(64)
 	UnknownOpCode      = byte(0x88)
 )
 
@@ -44,7 +48,7 @@ func (m *MessageOverP2P) swapNodeIdsInfo(ctx p2p.HandlerContext) bool {
 }
 
 func (m *MessageOverP2P) IsValid() bool {
-	return m.header == MessageHeader
+	return m.header == MessageHeader || m.header == SealedMessageHeader
 }
 
 func (m *MessageOverP2P) Size() int {
@@ -52,7 +56,7 @@ func (m *MessageOverP2P) Size() int {
 }
 
 func (m *MessageOverP2P) CheckOpCode() {
-	if m.opCode != NodeIDSInfoOpCode && m.opCode != TopicActionsOpCode && m.opCode != PacketsOpCode && m.opCode != UnknownOpCode {
+	if m.opCode != NodeIDSInfoOpCode && m.opCode != TopicActionsOpCode && m.opCode != PacketsOpCode && m.opCode != CreditOpCode && m.opCode != RelayOpCode && m.opCode != RetainedOpCode && m.opCode != SessionOpCode && m.opCode != UnknownOpCode {
 		m.opCode = UnknownOpCode
 	}
 }
@@ -75,6 +79,14 @@ func (m *MessageOverP2P) OpCodeString() string {
 		opCodeStr = "TopicActions' OpCode"
 	case PacketsOpCode:
 		opCodeStr = "Packets' OpCode"
+	case CreditOpCode:
+		opCodeStr = "Credit's OpCode"
+	case RelayOpCode:
+		opCodeStr = "Relay's OpCode"
+	case RetainedOpCode:
+		opCodeStr = "Retained's OpCode"
+	case SessionOpCode:
+		opCodeStr = "Session's OpCode"
 	case UnknownOpCode:
 		opCodeStr = "Unknown OpCode"
 	default:
@@ -91,6 +103,9 @@ func (m MessageOverP2P) Marshal() []byte {
 func (m *MessageOverP2P) ByteMarshal() []byte {
 	buf := make([]byte, 2+len(m.payLoad))
 	buf[0] = MessageHeader
+	if m.header == SealedMessageHeader {
+		buf[0] = SealedMessageHeader
+	}
 	buf[1] = m.opCode
 	copy(buf[2:2+len(m.payLoad)], m.payLoad)
 	return buf
@@ -148,11 +163,28 @@ func (m *MessageOverP2P) ExecuteTaskAccordingMessageOverP2P(b *mqtt.Broker) erro
 		if err != nil {
 			return err
 		}
+		if b.FaultPartitioned(fps.SourceBrokerId) {
+			return nil
+		}
 		if fps.TargetBrokerId == b.BrokerID().String() {
 			if len(fps.PacketList) > 0 {
-				for _, pkt := range fps.PacketList {
-					b.SubmitPublishPacketsWorkTask(&pkt)
+				for i := range fps.PacketList {
+					pkt := &fps.PacketList[i]
+					// a peer with another policy may forward the node-local topics
+					if b.LocalTopic(pkt.TopicName) {
+						continue
+					}
+					// the payload sealed by the plugins of the source broker, for instance
+					if !b.ReceivePeerPublish(pkt, fps.SourceBrokerId) {
+						continue
+					}
+					if i < len(fps.Traces) && len(fps.Traces[i]) > 0 {
+						b.SubmitTracedPublish(pkt, fps.SourceBrokerId, fps.Traces[i])
+						continue
+					}
+					b.SubmitPublishPacketsWorkTask(pkt)
 				}
+				grantForwardCreditToSourceNode(b, fps)
 			} else {
 				err = fmt.Errorf("broker_p2p_module/node_message/ExecuteTaskAccordingMessageOverP2P: No packet found ... [%s] ",
 					fps.TargetBrokerId,
@@ -166,6 +198,27 @@ func (m *MessageOverP2P) ExecuteTaskAccordingMessageOverP2P(b *mqtt.Broker) erro
 			)
 			return err
 		}
+	case CreditOpCode:
+		fc, err := UnmarshalForwardCredit(m.payLoad)
+		if err != nil {
+			return err
+		}
+		if fc.TargetBrokerId != b.BrokerID().String() {
+			return fmt.Errorf("broker_p2p_module/node_message/ExecuteTaskAccordingMessageOverP2P: the credit target broker [%s] not match this broker %s",
+				fc.TargetBrokerId,
+				b.BrokerID().String(),
+			)
+		}
+		if b.FaultPartitioned(fc.SourceBrokerId) {
+			return nil
+		}
+		b.BrokerNode().GrantForwardCredit(fc.SourceBrokerId, fc.Credits)
+	case RelayOpCode:
+		return processRelayEnvelope(b, m.payLoad)
+	case RetainedOpCode:
+		return processRetainedEntries(b, m.payLoad)
+	case SessionOpCode:
+		return processSessionHandover(b, m.payLoad)
 	case UnknownOpCode:
 		return errors.New("core_module/broker_p2p/ExecuteTaskAccordingMessageOverP2P error : Unknown OpCode")
 	default:
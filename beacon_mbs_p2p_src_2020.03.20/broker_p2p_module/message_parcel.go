@@ -3,6 +3,8 @@ package broker_p2p_module
 import (
 	"sync/atomic"
 
+	mqtt "awesomeProject/beacon/mqtt_network/broker_core_module"
+
 	p2p "awesomeProject/beacon/p2p_network/core_module"
 
 	"github.com/dustin/go-humanize"
@@ -28,17 +30,20 @@ func (p *PendingMessageParcel) Pending() {
 }
 
 // will be run in p2p_service/ServiceWithFlag
-func startProcessPendingMessageParcelJobTask(logger *zap.Logger, node *p2p.Node) {
+// The parcels for the peers reached through a relay are sent to the relay, sealed with the secret
+// of the cluster if the broker has one.
+func startProcessPendingMessageParcelJobTask(logger *zap.Logger, node *p2p.Node, b *mqtt.Broker) {
 	go func() {
 		for msgParcel := range PendingMessageParcelChan {
-			msgOverP2P := msgParcel.messageOverP2P
-			err := msgOverP2P.sendMessageOverP2PToTargetNode(node, msgParcel.targetNodeIdAddr)
+			targetAddr, msgOverP2P := relayParcel(b, msgParcel.targetNodeIdAddr, msgParcel.messageOverP2P)
+			msgOverP2P = sealMessage(b, msgOverP2P)
+			err := msgOverP2P.sendMessageOverP2PToTargetNode(node, targetAddr)
 			if err != nil {
 				atomic.AddUint32(&sentMessageFailedOverP2P, 1)
 
 				logger.Error("Failed to send message ... ",
 					zap.Error(err),
-					zap.String("Address", msgParcel.targetNodeIdAddr),
+					zap.String("Address", targetAddr),
 					zap.String("Payload Size", humanize.Bytes(uint64(msgOverP2P.Size()))),
 				)
 			}
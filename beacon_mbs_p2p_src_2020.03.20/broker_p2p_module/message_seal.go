@@ -0,0 +1,52 @@
+package broker_p2p_module
+
+import (
+	"errors"
+
+	mqtt "awesomeProject/beacon/mqtt_network/broker_core_module"
+)
+
+// SealedMessageHeader starts the messages sealed with the secret of the cluster. The op code is
+// left in clear, it's authenticated with the sealed payload.
+const SealedMessageHeader = byte(0x9a)
+
+var (
+	errUnsealedMessage = errors.New("broker_p2p_module/message_seal/openMessage: the cluster seals its messages, an unsealed one is refused")
+	errNoPeerSecret    = errors.New("broker_p2p_module/message_seal/openMessage: the message is sealed but the broker has no peer secret")
+)
+
+// sealMessage returns the message sealed with the secret of the cluster, the message itself if the
+// broker has none. It's called once the message is wrapped for the relay, if any, so each hop
+// seals it again.
+func sealMessage(b *mqtt.Broker, m *MessageOverP2P) *MessageOverP2P {
+	s := b.PeerSealer()
+	if s == nil || m.header == SealedMessageHeader {
+		return m
+	}
+	return &MessageOverP2P{
+		header:  SealedMessageHeader,
+		opCode:  m.opCode,
+		payLoad: s.Seal(m.payLoad, []byte{SealedMessageHeader, m.opCode}),
+	}
+}
+
+// openMessage returns the sealed message opened, the message itself if it's not sealed. A broker
+// with a peer secret refuses the unsealed messages, so a node without the secret can neither read
+// nor inject them. The received message is left as it is, the handler may still read it.
+func openMessage(b *mqtt.Broker, m *MessageOverP2P) (*MessageOverP2P, error) {
+	s := b.PeerSealer()
+	if m.header != SealedMessageHeader {
+		if s != nil {
+			return nil, errUnsealedMessage
+		}
+		return m, nil
+	}
+	if s == nil {
+		return nil, errNoPeerSecret
+	}
+	payLoad, err := s.Open(m.payLoad, []byte{SealedMessageHeader, m.opCode})
+	if err != nil {
+		return nil, err
+	}
+	return &MessageOverP2P{header: MessageHeader, opCode: m.opCode, payLoad: payLoad}, nil
+}
@@ -4,10 +4,13 @@ import (
 	"fmt"
 	"net"
 	"runtime"
+	"time"
 
 	"awesomeProject/beacon/general_toolbox/logger"
 
 	mqtt "awesomeProject/beacon/mqtt_network/broker_core_module"
+	"awesomeProject/beacon/mqtt_network/libs/certmon"
+	"awesomeProject/beacon/mqtt_network/libs/relay"
 
 	p2p "awesomeProject/beacon/p2p_network/core_module"
 	"awesomeProject/beacon/p2p_network/libs/cryptographic"
@@ -16,7 +19,14 @@ import (
 	"go.uber.org/zap"
 )
 
-func ServiceWithFlag(host net.IP, port uint16, address string, mHost net.IP, mPort uint16, mAddress string, debug bool, addresses ...string) {
+const (
+	// The peers keep the sessions of a broker shutting down for this long
+	defaultSessionHandover = 5 * time.Minute
+	// The clients are disconnected over this period on shutdown
+	defaultShutdownDrain = 10 * time.Second
+)
+
+func ServiceWithFlag(host net.IP, port uint16, address string, mHost net.IP, mPort uint16, mAddress string, debug bool, readOnly bool, replicateRetained bool, authProvider string, aclFile string, wsAddr string, relayCfg *relay.Config, relayVia map[string]string, tlsCfg *certmon.Config, certIdentity *mqtt.CertIdentity, peerSecret []byte, addresses ...string) {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
 	logger.InitLogger(debug, "mqtt_service_p2p")
@@ -35,14 +45,40 @@ func ServiceWithFlag(host net.IP, port uint16, address string, mHost net.IP, mPo
 	defer node.Close()
 
 	// Broker
-	broker, errMQTT := mqtt.NewBroker(
+	opts := []mqtt.BrokerOption{
 		mqtt.WithBrokerLogger(theLogger),
 		mqtt.WithBrokerBindHost(mHost),
 		mqtt.WithBrokerBindPort(mPort),
 		mqtt.WithBrokerAddress(mAddress),
 		mqtt.WithNodeId(node.ID()),
 		mqtt.WithNode(node),
-	)
+		mqtt.WithReadOnly(readOnly),
+		mqtt.WithRetainedReplication(replicateRetained),
+		mqtt.WithRelayRoutes(relayVia),
+		mqtt.WithSessionHandover(defaultSessionHandover),
+	}
+	if len(authProvider) > 0 {
+		opts = append(opts, mqtt.WithAuthManager(authProvider))
+	}
+	if len(aclFile) > 0 {
+		opts = append(opts, mqtt.WithACLFile(aclFile))
+	}
+	if relayCfg != nil {
+		opts = append(opts, mqtt.WithRelay(*relayCfg))
+	}
+	if tlsCfg != nil {
+		opts = append(opts, mqtt.WithTLS(*tlsCfg))
+	}
+	if certIdentity != nil {
+		opts = append(opts, mqtt.WithCertificateIdentity(*certIdentity))
+	}
+	if len(peerSecret) > 0 {
+		opts = append(opts, mqtt.WithPeerSecret(peerSecret))
+	}
+	if len(wsAddr) > 0 {
+		opts = append(opts, mqtt.WithWebSocket(mqtt.WebSocketConfig{Addr: wsAddr, TLS: tlsCfg != nil}))
+	}
+	broker, errMQTT := mqtt.NewBroker(opts...)
 	checkForPanics(errMQTT)
 
 	// Register the MessageOverP2P Go type to the node with an associated unmarshal function.
@@ -63,6 +99,7 @@ func ServiceWithFlag(host net.IP, port uint16, address string, mHost net.IP, mPo
 			)
 
 			processExistedTopicsAndDeliverToTargetNodeAtOnce(broker, id.Address)
+			broker.SyncRetained(id.Address)
 		},
 		OnPeerEvicted: func(id cryptographic.ID) {
 			theLogger.Info("Forgotten a new peer node ",
@@ -73,7 +110,7 @@ func ServiceWithFlag(host net.IP, port uint16, address string, mHost net.IP, mPo
 			info := fmt.Sprintf(`{"peer_node_address":"%s","public_key":"%s"}`, id.Address, id.PubKey.String()[:PrintedLength])
 			broker.PeerNodeNotification(broker.BrokerID().String(), "peer_evicted", info)
 
-			//Todo process the target id related task. such as remove NodeIdsInfo,remove target's topics in this node.
+			broker.ForgetPeerNode(id.Address)
 		},
 	}
 
@@ -85,12 +122,14 @@ func ServiceWithFlag(host net.IP, port uint16, address string, mHost net.IP, mPo
 	// Register DeliverForwardPacketsToTargetNode
 	broker.BrokerNode().RegisterDeliverForwardPacketsToTargetNode(deliverForwardPacketsToTargetNode)
 	broker.BrokerNode().RegisterDeliverTopicActionsToPeerNodes(deliverTopicActionsToPeerNodes)
+	broker.BrokerNode().RegisterDeliverRetainedEntries(deliverRetainedEntries)
+	broker.BrokerNode().RegisterDeliverSessionHandover(deliverSessionHandover)
 
 	// Bind Kademlia to the node.
 	node.Bind(overlay.Protocol())
 
 	// Start the processing pending message parcel job task
-	startProcessPendingMessageParcelJobTask(theLogger, node)
+	startProcessPendingMessageParcelJobTask(theLogger, node, broker)
 
 	// Start the processing received message over p2p job task
 	startProcessReceivedMessageOverP2PJobTask(theLogger, broker)
@@ -108,4 +147,9 @@ func ServiceWithFlag(host net.IP, port uint16, address string, mHost net.IP, mPo
 	checkForPanics(broker.StartListening())
 
 	waitForSignal()
+
+	// Hand the sessions over to the peers while the node is still up.
+	if err := broker.Shutdown(defaultShutdownDrain); err != nil {
+		theLogger.Error("Failed to shut the broker down", zap.Error(err))
+	}
 }
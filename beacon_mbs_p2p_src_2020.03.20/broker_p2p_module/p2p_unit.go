@@ -10,6 +10,7 @@ import (
 	"time"
 
 	mqtt "awesomeProject/beacon/mqtt_network/broker_core_module"
+	"awesomeProject/beacon/mqtt_network/libs/discovery"
 
 	p2p "awesomeProject/beacon/p2p_network/core_module"
 	"awesomeProject/beacon/p2p_network/libs/kademlia"
@@ -69,30 +70,118 @@ func waitForSignal() {
 }
 
 // bootstrap pings and dials an array of network addresses which we may interact with and discover peers from.
+// The addresses given as SRV records, DNS-SD service names or mDNS services are resolved, and monitored for the new
+// seeds; the node announces itself on the mDNS services. The seeds are health checked, the ones failing are forgotten
+// until they answer again.
 func bootstrap(broker *mqtt.Broker, node *p2p.Node, overlay *kademlia.Protocol, addresses ...string) {
+	self := node.ID().Address
+	health := discovery.NewHealth(func(ctx context.Context, addr string) error {
+		_, err := node.Ping(ctx, addr)
+		return err
+	}, 0, 0, func(addr string) {
+		node.Logger().Warn("Seed node is down, forget it ... ", zap.String("address", addr))
+		broker.ForgetPeerNode(addr)
+	}, func(addr string) {
+		node.Logger().Info("Seed node is up again ", zap.String("address", addr))
+		bootstrapPeerNode(broker, node, addr)
+	})
+
+	var specs []string
 	for _, addr := range addresses {
-		node.Logger().Debug("mqtt_service_p2p/node_service bootstrap ", zap.String("flag address", addr))
+		if discovery.IsDNSName(addr) {
+			specs = append(specs, addr)
+			if strings.HasPrefix(addr, discovery.SchemeMDNS) {
+				announce(node, strings.TrimPrefix(addr, discovery.SchemeMDNS))
+			}
+			continue
+		}
+		health.Add(addr)
+		bootstrapPeerNode(broker, node, addr)
+	}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-		_, err := node.Ping(ctx, addr)
+	if len(specs) > 0 {
+		watcher := discovery.NewWatcher(nil, specs, 0, func(added []string, removed []string) {
+			for _, addr := range added {
+				if addr == self {
+					continue
+				}
+				health.Add(addr)
+				bootstrapPeerNode(broker, node, addr)
+			}
+			if len(removed) > 0 {
+				node.Logger().Info("Seed node(s) removed from discovery ",
+					zap.String("addresses", strings.Join(removed, ", ")),
+				)
+			}
+			for _, addr := range removed {
+				health.Remove(addr)
+				broker.ForgetPeerNode(addr)
+			}
+			discover(node.Logger(), overlay)
+		})
 
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		added, _, err := watcher.Poll(ctx)
 		cancel()
-
 		if err != nil {
-			node.Logger().Error("Failed to ping bootstrap node. Skipping ... ",
+			node.Logger().Error("Failed to resolve bootstrap DNS names ... ",
 				zap.Error(err),
-				zap.String("bootstrap node address", addr),
+				zap.String("names", strings.Join(specs, ", ")),
 			)
-			continue
-		} else {
-			node.Logger().Debug("Succeed to ping bootstrap node.", zap.String("bootstrap node address", addr))
-			swapNodeIdsInfoWithPeerNode(broker, addr)
 		}
+		for _, addr := range added {
+			if addr == self {
+				continue
+			}
+			health.Add(addr)
+			bootstrapPeerNode(broker, node, addr)
+		}
+
+		_ = watcher.Start()
 	}
+	_ = health.Start()
+
 	discover(node.Logger(), overlay)
 	processExistedTopicsAndDeliverToPeerNodes(broker)
 }
 
+// announce answers the mDNS queries of the service with the address of the node, so the nodes on
+// the LAN find it.
+func announce(node *p2p.Node, service string) {
+	responder, err := discovery.NewResponder(service, node.ID().Address)
+	if err == nil {
+		err = responder.Start()
+	}
+	if err != nil {
+		node.Logger().Error("Failed to announce the node with mDNS ... ",
+			zap.Error(err),
+			zap.String("service", service),
+		)
+		return
+	}
+	node.Logger().Info("Announced the node with mDNS ", zap.String("service", service))
+}
+
+func bootstrapPeerNode(broker *mqtt.Broker, node *p2p.Node, addr string) {
+	node.Logger().Debug("mqtt_service_p2p/node_service bootstrap ", zap.String("flag address", addr))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	_, err := node.Ping(ctx, addr)
+
+	cancel()
+
+	if err != nil {
+		node.Logger().Error("Failed to ping bootstrap node. Skipping ... ",
+			zap.Error(err),
+			zap.String("bootstrap node address", addr),
+		)
+		return
+	}
+
+	node.Logger().Debug("Succeed to ping bootstrap node.", zap.String("bootstrap node address", addr))
+	swapNodeIdsInfoWithPeerNode(broker, addr)
+}
+
 // discover uses Kademlia to discover new peers from nodes we already are aware of.
 func discover(logger *zap.Logger, overlay *kademlia.Protocol) {
 	ids := overlay.Discover()
@@ -191,8 +280,17 @@ func handleSend(ctx p2p.HandlerContext) error {
 
 func startProcessReceivedMessageOverP2PJobTask(logger *zap.Logger, b *mqtt.Broker) {
 	go func() {
-		for msgOverP2P := range ReceivedMessageOverP2PChan {
-			err := msgOverP2P.ExecuteTaskAccordingMessageOverP2P(b)
+		for received := range ReceivedMessageOverP2PChan {
+			msgOverP2P, err := openMessage(b, received)
+			if err != nil {
+				atomic.AddUint32(&receivedMessageFailedOverP2P, 1)
+				logger.Warn("Broker_p2p_module/node_service/StartProcessReceivedMessageOverP2PJobTask : openMessage error, drop the message ",
+					zap.Error(err),
+					zap.String("OpCode", received.OpCodeString()),
+				)
+				continue
+			}
+			err = msgOverP2P.ExecuteTaskAccordingMessageOverP2P(b)
 			if err != nil {
 				atomic.AddUint32(&executeMessageFailedOverP2P, 1)
 				logger.Error("Broker_p2p_module/node_service/StartProcessReceivedMessageOverP2PJobTask : ExecuteTaskAccordingMessageOverP2P error ",
@@ -0,0 +1,105 @@
+package broker_p2p_module
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	mqtt "awesomeProject/beacon/mqtt_network/broker_core_module"
+)
+
+//opCode (RelayOpCode) : a message for a peer which cannot be reached directly, sent through a relay broker.
+
+type RelayEnvelope struct {
+	SourceNodeAddr string `json:"source_node_addr"`
+	TargetNodeAddr string `json:"target_node_addr"`
+	OpCode         byte   `json:"op_code"`
+	PayLoad        []byte `json:"payload"`
+}
+
+func (r *RelayEnvelope) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func UnmarshalRelayEnvelope(data []byte) (*RelayEnvelope, error) {
+	re := &RelayEnvelope{}
+	err := json.Unmarshal(data, re)
+
+	return re, err
+}
+
+func NewRelayEnvelopeToMessageOverP2P(re RelayEnvelope) (*MessageOverP2P, error) {
+	if len(re.SourceNodeAddr) < 1 || len(re.TargetNodeAddr) < 1 {
+		return nil, errors.New("NewRelayEnvelopeToMessageOverP2P => no node address found ")
+	}
+	if re.OpCode == RelayOpCode {
+		return nil, errors.New("NewRelayEnvelopeToMessageOverP2P => cannot relay a relayed message ")
+	}
+	reData, err := re.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return &MessageOverP2P{header: MessageHeader, opCode: RelayOpCode, payLoad: reData}, nil
+}
+
+// relayParcel wraps the message for the relay node if the target node is reached through one,
+// it returns the address to send to and the message to send.
+func relayParcel(b *mqtt.Broker, targetNodeAddr string, msgOverP2P *MessageOverP2P) (string, *MessageOverP2P) {
+	if b == nil || msgOverP2P.opCode == RelayOpCode {
+		return targetNodeAddr, msgOverP2P
+	}
+	via, ok := b.RelayRoute(targetNodeAddr)
+	if !ok {
+		return targetNodeAddr, msgOverP2P
+	}
+	relayed, err := NewRelayEnvelopeToMessageOverP2P(RelayEnvelope{
+		SourceNodeAddr: b.NodeID().Address,
+		TargetNodeAddr: targetNodeAddr,
+		OpCode:         msgOverP2P.opCode,
+		PayLoad:        msgOverP2P.payLoad,
+	})
+	if err != nil {
+		return targetNodeAddr, msgOverP2P
+	}
+	return via, relayed
+}
+
+// processRelayEnvelope executes the message if this node is the target, or relays it to the target
+// if this broker is a relay and the link is within its limits.
+func processRelayEnvelope(b *mqtt.Broker, data []byte) error {
+	re, err := UnmarshalRelayEnvelope(data)
+	if err != nil {
+		return err
+	}
+
+	if re.TargetNodeAddr == b.NodeID().Address {
+		inner := &MessageOverP2P{header: MessageHeader, opCode: re.OpCode, payLoad: re.PayLoad}
+		if inner.OpCode() == RelayOpCode {
+			return errors.New("broker_p2p_module/relay/processRelayEnvelope: nested relayed message")
+		}
+		return inner.ExecuteTaskAccordingMessageOverP2P(b)
+	}
+
+	r := b.Relay()
+	if r == nil {
+		return fmt.Errorf("broker_p2p_module/relay/processRelayEnvelope: not a relay, drop the message from [%s] to [%s]",
+			re.SourceNodeAddr,
+			re.TargetNodeAddr,
+		)
+	}
+
+	ok, err := r.Allow(re.SourceNodeAddr, re.TargetNodeAddr, len(data))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// over the bandwidth cap of the link, accounted as dropped
+		return nil
+	}
+
+	msgParcel := NewPendingMessageParcel(re.TargetNodeAddr, &MessageOverP2P{header: MessageHeader, opCode: RelayOpCode, payLoad: data})
+	if msgParcel != nil {
+		msgParcel.Pending()
+	}
+	return nil
+}
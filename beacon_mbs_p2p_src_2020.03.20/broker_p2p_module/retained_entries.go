@@ -0,0 +1,84 @@
+package broker_p2p_module
+
+import (
+	"encoding/json"
+	"errors"
+
+	mqtt "awesomeProject/beacon/mqtt_network/broker_core_module"
+	"awesomeProject/beacon/mqtt_network/libs/retaincrdt"
+)
+
+//opCode (RetainedOpCode) : the replicated retained messages, stamped by the broker they were published to.
+
+// The full state is split in messages of this many entries.
+const defaultRetainedEntriesBatch = 256
+
+type RetainedEntries struct {
+	SourceBrokerId string             `json:"source_broker_id"`
+	Entries        []retaincrdt.Entry `json:"entries"`
+}
+
+func (r *RetainedEntries) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func UnmarshalRetainedEntries(data []byte) (*RetainedEntries, error) {
+	re := &RetainedEntries{}
+	err := json.Unmarshal(data, re)
+
+	return re, err
+}
+
+func NewRetainedEntriesToMessageOverP2P(re RetainedEntries) (*MessageOverP2P, error) {
+	if len(re.SourceBrokerId) < 1 || len(re.Entries) < 1 {
+		return nil, errors.New("NewRetainedEntriesToMessageOverP2P => no broker id or entry found ")
+	}
+	reData, err := re.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return &MessageOverP2P{opCode: RetainedOpCode, payLoad: reData}, nil
+}
+
+// Deliver the retained entries to the target node, or to each peer node if the address is empty.
+func deliverRetainedEntries(broker *mqtt.Broker, targetNodeIDAddr string, entries []retaincrdt.Entry) {
+	var targets []string
+	if len(targetNodeIDAddr) > 0 {
+		targets = []string{targetNodeIDAddr}
+	} else {
+		for _, tid := range broker.Overlay().Table().Peers() {
+			targets = append(targets, tid.Address)
+		}
+	}
+
+	for start := 0; start < len(entries); start += defaultRetainedEntriesBatch {
+		end := start + defaultRetainedEntriesBatch
+		if end > len(entries) {
+			end = len(entries)
+		}
+		msgOverP2P, err := NewRetainedEntriesToMessageOverP2P(RetainedEntries{
+			SourceBrokerId: broker.BrokerID().String(),
+			Entries:        entries[start:end],
+		})
+		if err != nil {
+			return
+		}
+		for _, target := range targets {
+			msgParcel := NewPendingMessageParcel(target, msgOverP2P)
+			if msgParcel != nil {
+				msgParcel.Pending()
+			}
+		}
+	}
+}
+
+func processRetainedEntries(b *mqtt.Broker, data []byte) error {
+	re, err := UnmarshalRetainedEntries(data)
+	if err != nil {
+		return err
+	}
+	if b.FaultPartitioned(re.SourceBrokerId) {
+		return nil
+	}
+	return b.MergeRetained(re.Entries)
+}
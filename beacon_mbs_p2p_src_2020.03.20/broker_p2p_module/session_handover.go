@@ -0,0 +1,52 @@
+package broker_p2p_module
+
+import (
+	"encoding/json"
+	"errors"
+
+	mqtt "awesomeProject/beacon/mqtt_network/broker_core_module"
+)
+
+//opCode (SessionOpCode) : a persistent session handed over by a broker shutting down.
+
+func NewSessionHandoverToMessageOverP2P(h mqtt.SessionHandover) (*MessageOverP2P, error) {
+	if len(h.SourceBrokerID) < 1 || len(h.ClientID) < 1 || len(h.Session) < 1 {
+		return nil, errors.New("NewSessionHandoverToMessageOverP2P => no broker id, client id or session found ")
+	}
+	data, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	return &MessageOverP2P{opCode: SessionOpCode, payLoad: data}, nil
+}
+
+// Deliver the session to each peer node at once, the broker is shutting down and cannot wait for
+// the pending parcels. It returns how many peers received it.
+func deliverSessionHandover(broker *mqtt.Broker, h mqtt.SessionHandover) int {
+	msgOverP2P, err := NewSessionHandoverToMessageOverP2P(h)
+	if err != nil {
+		return 0
+	}
+
+	delivered := 0
+	for _, tid := range broker.Overlay().Table().Peers() {
+		targetAddr, msg := relayParcel(broker, tid.Address, msgOverP2P)
+		msg = sealMessage(broker, msg)
+		if err := msg.sendMessageOverP2PToTargetNode(broker.Node(), targetAddr); err != nil {
+			continue
+		}
+		delivered++
+	}
+	return delivered
+}
+
+func processSessionHandover(b *mqtt.Broker, data []byte) error {
+	h := mqtt.SessionHandover{}
+	if err := json.Unmarshal(data, &h); err != nil {
+		return err
+	}
+	if b.FaultPartitioned(h.SourceBrokerID) {
+		return nil
+	}
+	return b.AdoptSession(h)
+}
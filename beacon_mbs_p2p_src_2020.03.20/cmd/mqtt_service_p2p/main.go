@@ -6,16 +6,38 @@ import (
 	"net"
 	"strings"
 
+	mqtt "awesomeProject/beacon/mqtt_network/broker_core_module"
 	"awesomeProject/beacon/mqtt_network/broker_p2p_module"
+	"awesomeProject/beacon/mqtt_network/libs/auth"
+	"awesomeProject/beacon/mqtt_network/libs/certmon"
+	"awesomeProject/beacon/mqtt_network/libs/relay"
+	"awesomeProject/beacon/mqtt_network/libs/seal"
 
 	"github.com/spf13/pflag"
 )
 
 var (
-	hostFlag     = pflag.IPP("host", "h", nil, "p2p network binding host")
-	portFlag     = pflag.Uint16P("port", "p", 15666, "p2p network binding port")
-	mqttPortFlag = pflag.Uint16P("mqtt_port", "m", 1883, "mqtt broker binding port")
-	debugFlag    = pflag.BoolP("debug", "d", false, "logger enable debug mode")
+	hostFlag       = pflag.IPP("host", "h", nil, "p2p network binding host")
+	portFlag       = pflag.Uint16P("port", "p", 15666, "p2p network binding port")
+	mqttPortFlag   = pflag.Uint16P("mqtt_port", "m", 1883, "mqtt broker binding port")
+	debugFlag      = pflag.BoolP("debug", "d", false, "logger enable debug mode")
+	readOnlyFlag   = pflag.Bool("read_only", false, "read-only replica, receives all the cluster traffic but refuses the publishes")
+	replicateFlag  = pflag.Bool("replicate_retained", false, "replicate the retained messages to the other brokers, the last one published wins")
+	relayFlag      = pflag.Bool("relay", false, "relay the peer messages between the nodes which cannot reach each other")
+	relayRateFlag  = pflag.Int64("relay_rate", 0, "bytes per second relayed for each link, 0 is unlimited")
+	relayViaFlag   = pflag.StringToString("relay_via", nil, "send the peer messages for a node address through a relay node address, target=relay")
+	tlsCertFlag    = pflag.String("tls_cert", "", "serve mqtt over TLS with this certificate (PEM, with its chain)")
+	tlsKeyFlag     = pflag.String("tls_key", "", "the key of the TLS certificate (PEM)")
+	authFileFlag   = pflag.String("auth_file", "", "check the connect credentials against a file of username:bcrypt-hash lines")
+	authHookFlag   = pflag.String("auth_webhook", "", "check the connect credentials with a POST to this url, 2xx accepts")
+	aclFileFlag    = pflag.String("acl_file", "", "authorize the publishes and the subscriptions with the topic ACL of this JSON or YAML file, reloaded when it changes")
+	wsFlag         = pflag.String("ws", "", "serve mqtt over websocket (path /mqtt) on this host:port, wss if the TLS certificate is set")
+	ocspFlag       = pflag.Bool("ocsp_stapling", false, "staple the OCSP response of the TLS certificate")
+	clientCAFlag   = pflag.String("tls_client_ca", "", "verify the client certificates against the CAs of this PEM bundle")
+	clientOptFlag  = pflag.Bool("tls_client_optional", false, "accept the clients without a certificate, they authenticate with their password")
+	identityFlag   = pflag.String("tls_identity", "", "authenticate the clients with their certificate, its cn, dns, email or uri names the username")
+	identityIDFlag = pflag.Bool("tls_identity_client_id", false, "the name in the client certificate is also the client id")
+	peerSecretFlag = pflag.String("peer_secret_file", "", "seal the messages between the peer nodes with the secret of this file, shared by the nodes of the cluster")
 )
 
 func main() {
@@ -36,6 +58,68 @@ func main() {
 		fmt.Printf("The Logger debug mode enable. \n")
 	}
 
+	if *readOnlyFlag {
+		fmt.Printf("The broker is a read-only replica. \n")
+	}
+
+	if *replicateFlag {
+		fmt.Printf("The retained messages are replicated to the other brokers. \n")
+	}
+
+	var relayCfg *relay.Config
+	if *relayFlag {
+		relayCfg = &relay.Config{Rate: *relayRateFlag}
+		fmt.Printf("The broker relays the peer messages, rate per link: %d B/s. \n", *relayRateFlag)
+	}
+
+	for target, via := range *relayViaFlag {
+		fmt.Printf("The peer messages for [%s] go through the relay [%s] \n", target, via)
+	}
+
+	var tlsCfg *certmon.Config
+	if len(*tlsCertFlag) > 0 {
+		tlsCfg = &certmon.Config{CertFile: *tlsCertFlag, KeyFile: *tlsKeyFlag, OCSP: *ocspFlag, ClientCAFile: *clientCAFlag}
+		fmt.Printf("The mqtt listener uses TLS with the certificate [%s]. \n", *tlsCertFlag)
+		if len(*clientCAFlag) > 0 {
+			tlsCfg.ClientAuth = certmon.ClientAuthRequire
+			if *clientOptFlag {
+				tlsCfg.ClientAuth = certmon.ClientAuthOptional
+			}
+			fmt.Printf("The client certificates are verified against [%s], %s. \n", *clientCAFlag, tlsCfg.ClientAuth)
+		}
+	}
+
+	var certIdentity *mqtt.CertIdentity
+	if len(*identityFlag) > 0 {
+		certIdentity = &mqtt.CertIdentity{From: *identityFlag, Username: true, ClientID: *identityIDFlag}
+		fmt.Printf("The clients are authenticated by the %s of their certificate. \n", *identityFlag)
+	}
+
+	var peerSecret []byte
+	if len(*peerSecretFlag) > 0 {
+		var err error
+		if peerSecret, err = seal.LoadSecret(*peerSecretFlag); err != nil {
+			panic(err)
+		}
+		fmt.Printf("The messages between the peer nodes are sealed with the secret of [%s]. \n", *peerSecretFlag)
+	}
+
+	var authProvider string
+	switch {
+	case len(*authFileFlag) > 0:
+		if err := auth.RegisterFileAuthProvider(*authFileFlag); err != nil {
+			panic(err)
+		}
+		authProvider = "file"
+		fmt.Printf("The connect credentials are checked against [%s]. \n", *authFileFlag)
+	case len(*authHookFlag) > 0:
+		if err := auth.RegisterHTTPAuthProvider(auth.HTTPConfig{URL: *authHookFlag}); err != nil {
+			panic(err)
+		}
+		authProvider = "http"
+		fmt.Printf("The connect credentials are checked by [%s]. \n", *authHookFlag)
+	}
+
 	if len(pflag.Args()) > 0 {
 		fmt.Printf("The p2p network bootstrap address is [%s] \n", strings.Join(pflag.Args(), ", "))
 	}
@@ -50,7 +134,13 @@ func main() {
 
 	// Create a new configured node.
 	// Command line : ./mqtt_service_p2p -h 127.0.0.1 -p 9000 -m 1883
-	broker_p2p_module.ServiceWithFlag(*hostFlag, *portFlag, "", *hostFlag, *mqttPortFlag, "", *debugFlag, pflag.Args()...)
+	// A relay : ./mqtt_service_p2p -h 1.2.3.4 -p 9000 -m 1883 --relay --relay_rate 1048576
+	// Devices with certificates : ./mqtt_service_p2p -m 8883 --tls_cert cert.pem --tls_key key.pem --tls_client_ca devices-ca.pem --tls_identity cn
+	// A sealed cluster : ./mqtt_service_p2p -p 9000 -m 1883 --peer_secret_file /etc/beacon/peer.secret 1.2.3.4:9000
+	// A node behind a NAT : ./mqtt_service_p2p -p 9000 -m 1883 --relay_via 10.0.0.2:9000=1.2.3.4:9000 1.2.3.4:9000
+	// The bootstrap addresses can be SRV records or DNS-SD service names, such as
+	// srv://_p2p._udp.beacon.default.svc.cluster.local or dnssd://beacon-p2p._udp.service.consul
+	broker_p2p_module.ServiceWithFlag(*hostFlag, *portFlag, "", *hostFlag, *mqttPortFlag, "", *debugFlag, *readOnlyFlag, *replicateFlag, authProvider, *aclFileFlag, *wsFlag, relayCfg, *relayViaFlag, tlsCfg, certIdentity, peerSecret, pflag.Args()...)
 }
 
 func getLocalFirstIPAddress() (net.IP, error) {
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/broker_core_module"
+	"awesomeProject/beacon/mqtt_network/libs/annotations"
+	"awesomeProject/beacon/mqtt_network/libs/backup"
+	"awesomeProject/beacon/mqtt_network/libs/schedule"
+	"awesomeProject/beacon/mqtt_network/libs/sessions"
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+)
+
+type storeFlags struct {
+	topics      *string
+	schedule    *string
+	annotations *string
+	packetIDs   *string
+}
+
+func newStoreFlags(fs *flag.FlagSet) storeFlags {
+	return storeFlags{
+		topics:      fs.String("topics_file", "", "the BoltDB file of the subscriptions and the retained messages"),
+		schedule:    fs.String("schedule_file", "", "the file of the scheduled publishes"),
+		annotations: fs.String("annotations_file", "", "the file of the topic annotations"),
+		packetIDs:   fs.String("packet_id_file", "", "the file of the packet ids of the persistent sessions"),
+	}
+}
+
+// files returns the files of the stores by store name.
+func (f storeFlags) files() map[string]string {
+	files := make(map[string]string)
+	for name, path := range map[string]string{
+		broker_core_module.BackupStoreTopics:      *f.topics,
+		broker_core_module.BackupStoreSchedule:    *f.schedule,
+		broker_core_module.BackupStoreAnnotations: *f.annotations,
+		broker_core_module.BackupStorePacketIDs:   *f.packetIDs,
+	} {
+		if len(path) > 0 {
+			files[name] = path
+		}
+	}
+	return files
+}
+
+// runBackup archives the store files. The JSON stores are always written whole so they can be read
+// while the broker runs, but the BoltDB file is locked by the running broker: send it SIGUSR1 to
+// have it write the archive itself.
+// Command line : ./mqtt_service_single_node backup [-topics_file f] [-schedule_file f] ... archive.tar.gz
+func runBackup(args []string) int {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	stores := newStoreFlags(fs)
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s backup [flags] archive.tar.gz\n", os.Args[0])
+		fs.PrintDefaults()
+		return 2
+	}
+
+	var list []backup.Store
+	if path := *stores.topics; len(path) > 0 {
+		list = append(list, backup.Store{Name: broker_core_module.BackupStoreTopics, Snapshotter: backup.SnapshotterFunc(func() (backup.Snapshot, error) {
+			return topics.OpenBoltSnapshot(path)
+		})})
+	}
+	if path := *stores.schedule; len(path) > 0 {
+		s, err := schedule.NewScheduler(path, nil)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		list = append(list, backup.Store{Name: broker_core_module.BackupStoreSchedule, Snapshotter: s})
+	}
+	if path := *stores.annotations; len(path) > 0 {
+		s, err := annotations.NewStore(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		list = append(list, backup.Store{Name: broker_core_module.BackupStoreAnnotations, Snapshotter: s})
+	}
+	if path := *stores.packetIDs; len(path) > 0 {
+		s, err := sessions.NewPacketIDStore(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		list = append(list, backup.Store{Name: broker_core_module.BackupStorePacketIDs, Snapshotter: s})
+	}
+
+	out, err := os.Create(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	hostname, _ := os.Hostname()
+	m, err := backup.Write(out, hostname, time.Now(), list)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(fs.Arg(0))
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	printManifest(m)
+	return 0
+}
+
+// runRestore checks the archive then writes its stores to the files, the broker must be stopped.
+// Command line : ./mqtt_service_single_node restore [-verify] [-topics_file f] ... archive.tar.gz
+func runRestore(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	verify := fs.Bool("verify", false, "only check the archive against its manifest")
+	stores := newStoreFlags(fs)
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s restore [flags] archive.tar.gz\n", os.Args[0])
+		fs.PrintDefaults()
+		return 2
+	}
+
+	in, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer in.Close()
+
+	if *verify {
+		m, err := backup.Verify(in)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		printManifest(m)
+		return 0
+	}
+
+	files := stores.files()
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "restore: no store file is set")
+		return 2
+	}
+
+	// The BoltDB file stays locked while the broker runs
+	if path, ok := files[broker_core_module.BackupStoreTopics]; ok {
+		if _, err := os.Stat(path); err == nil {
+			s, err := topics.OpenBoltSnapshot(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "restore: %v, stop the broker first\n", err)
+				return 1
+			}
+			_ = s.Close()
+		}
+	}
+
+	m, err := backup.Restore(in, files)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	printManifest(m)
+	return 0
+}
+
+func printManifest(m *backup.Manifest) {
+	fmt.Printf("node %s, created %s\n", m.Node, m.Created.Format(time.RFC3339))
+	for _, s := range m.Stores {
+		fmt.Printf("  %-12s %10d bytes  sha256 %s\n", s.Name, s.Size, s.SHA256)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"awesomeProject/beacon/mqtt_network/broker_core_module"
+
+	"go.uber.org/zap"
+)
+
+// On SIGUSR1, the running broker writes a backup archive of its stores to the working directory.
+// Command line : kill -USR1 <pid>
+func handleBackupSignal(b *broker_core_module.Broker, logger *zap.Logger) {
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGUSR1)
+
+	for range signalChan {
+		if _, err := b.BackupFile("."); err != nil {
+			logger.Error("Failed to back up the broker stores", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"awesomeProject/beacon/mqtt_network/broker_core_module"
+
+	"go.uber.org/zap"
+)
+
+// The backup of the running broker is triggered by a signal on Linux only.
+func handleBackupSignal(b *broker_core_module.Broker, logger *zap.Logger) {
+}
@@ -1,18 +1,39 @@
 package main
 
 import (
+	"flag"
 	"os"
 	"os/signal"
 	"runtime"
+	"time"
 
 	"awesomeProject/beacon/mqtt_network/broker_core_module"
 
 	"go.uber.org/zap"
 )
 
+// The clients are disconnected over this period on shutdown
+const defaultShutdownDrain = 10 * time.Second
+
 func main() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		os.Exit(runSimulate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "backup" {
+		os.Exit(runBackup(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		os.Exit(runRestore(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "snapshot" {
+		os.Exit(runSnapshot(os.Args[2:]))
+	}
+
+	configFile := flag.String("config", "", "the YAML or JSON config file of the broker, reloaded on SIGHUP")
+	flag.Parse()
+
 	//logger, err := zap.NewDevelopment(zap.AddStacktrace(zap.DebugLevel))
 	logger, err := zap.NewProduction(zap.AddStacktrace(zap.PanicLevel))
 	if err != nil {
@@ -20,16 +41,30 @@ func main() {
 	}
 	defer logger.Sync()
 
-	b, err := broker_core_module.NewBroker(broker_core_module.WithBrokerLogger(logger))
+	opts := []broker_core_module.BrokerOption{broker_core_module.WithBrokerLogger(logger)}
+	if len(*configFile) > 0 {
+		opts = append(opts, broker_core_module.WithConfigFile(*configFile))
+	}
+	b, err := broker_core_module.NewBroker(opts...)
 	if err != nil {
 		panic(err)
 	}
 
+	go handleUpgradeSignal(b, logger)
+	go handleBackupSignal(b, logger)
+	if len(*configFile) > 0 {
+		go handleReloadSignal(b, logger)
+	}
+
 	if err = b.StartListening(); err != nil {
 		panic(err)
 	}
 
 	waitForSignal()
+
+	if err = b.Shutdown(defaultShutdownDrain); err != nil {
+		logger.Error("Failed to shut the broker down", zap.Error(err))
+	}
 }
 
 func waitForSignal() {
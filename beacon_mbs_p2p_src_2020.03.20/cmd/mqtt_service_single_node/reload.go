@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"awesomeProject/beacon/mqtt_network/broker_core_module"
+
+	"go.uber.org/zap"
+)
+
+// On SIGHUP, the broker reads its config file again and applies the reloadable sections.
+// Command line : kill -HUP <pid>
+func handleReloadSignal(b *broker_core_module.Broker, logger *zap.Logger) {
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGHUP)
+
+	for range signalChan {
+		if err := b.ReloadConfig(); err != nil {
+			logger.Error("Failed to reload the broker config", zap.Error(err))
+		}
+	}
+}
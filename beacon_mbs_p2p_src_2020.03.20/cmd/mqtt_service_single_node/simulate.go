@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"awesomeProject/beacon/mqtt_network/libs/simulate"
+)
+
+// runSimulate drives a running broker with the scenario file and prints the report.
+// Command line : ./mqtt_service_single_node simulate [-broker tcp://127.0.0.1:1883] [-json] scenario.json
+func runSimulate(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	broker := fs.String("broker", "", "broker address overriding the one of the scenario, such as tcp://127.0.0.1:1883")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s simulate [flags] scenario.json\n", os.Args[0])
+		fs.PrintDefaults()
+		return 2
+	}
+
+	scenario, err := simulate.LoadScenario(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(*broker) > 0 {
+		scenario.Broker = *broker
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt)
+	defer signal.Stop(signalChan)
+	go func() {
+		<-signalChan
+		cancel()
+	}()
+
+	report, err := simulate.Run(ctx, scenario)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *asJSON {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		fmt.Println(report.String())
+	}
+
+	return 0
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+)
+
+// runSnapshot exports the retained messages and the subscriptions of the persistent sessions of the
+// BoltDB file to a snapshot, or imports a snapshot into the file, to migrate a broker or seed a test
+// environment. The BoltDB file is locked by the running broker, stop it first.
+// Command line : ./mqtt_service_single_node snapshot export|import -topics_file f snapshot.jsonl
+func runSnapshot(args []string) int {
+	if len(args) == 0 || (args[0] != "export" && args[0] != "import") {
+		fmt.Fprintf(os.Stderr, "usage: %s snapshot export|import -topics_file f snapshot.jsonl\n", os.Args[0])
+		return 2
+	}
+	fs := flag.NewFlagSet("snapshot "+args[0], flag.ExitOnError)
+	topicsFile := fs.String("topics_file", "", "the BoltDB file of the subscriptions and the retained messages")
+	_ = fs.Parse(args[1:])
+
+	if fs.NArg() != 1 || len(*topicsFile) == 0 {
+		fmt.Fprintf(os.Stderr, "usage: %s snapshot %s -topics_file f snapshot.jsonl\n", os.Args[0], args[0])
+		fs.PrintDefaults()
+		return 2
+	}
+
+	p, err := topics.NewBoltProvider(*topicsFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "snapshot: %v, stop the broker first\n", err)
+		return 1
+	}
+	topics.Register("bolt", p)
+	m, err := topics.NewManager("bolt")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer m.Close()
+
+	if args[0] == "export" {
+		err = exportSnapshot(m, fs.Arg(0))
+	} else {
+		err = importSnapshot(m, fs.Arg(0))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func exportSnapshot(m *topics.Manager, path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = m.WriteSnapshot(out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+	}
+	return err
+}
+
+func importSnapshot(m *topics.Manager, path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return m.RestoreSnapshot(in)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/broker_core_module"
+
+	"go.uber.org/zap"
+)
+
+// On SIGUSR2, the broker starts the new binary with the listener, and exits after draining
+// the clients. Command line : kill -USR2 <pid>
+func handleUpgradeSignal(b *broker_core_module.Broker, logger *zap.Logger) {
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGUSR2)
+
+	for range signalChan {
+		if err := b.Upgrade(30*time.Second, time.Minute); err != nil {
+			logger.Error("Failed to upgrade the broker", zap.Error(err))
+			continue
+		}
+		_ = logger.Sync()
+		os.Exit(0)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"awesomeProject/beacon/mqtt_network/broker_core_module"
+
+	"go.uber.org/zap"
+)
+
+// The listener handoff is supported on Linux only.
+func handleUpgradeSignal(b *broker_core_module.Broker, logger *zap.Logger) {
+}
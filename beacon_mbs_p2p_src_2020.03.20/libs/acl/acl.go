@@ -0,0 +1,298 @@
+// Package acl authorizes the publishes and the subscriptions of the clients by topic. The rules are
+// checked in order and the first rule which applies decides, the default decides if none applies.
+// The filters of the rules may hold %c and %u, replaced by the client id and the user name, such as
+// devices/%c/# for the topics of each device.
+//
+// A publish is decided by the rules whose filter matches the topic. A subscription is allowed by a
+// rule whose filter holds all the topics of the requested filter, and denied by a rule whose filter
+// shares any topic with it, so a deny rule cannot be bypassed by subscribing to a wider filter.
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+type Access byte
+
+const (
+	Publish Access = 1 << iota
+	Subscribe
+)
+
+type Rule struct {
+	// Action is allow or deny.
+	Action string `json:"action" yaml:"action"`
+	// Access is publish, subscribe or both if it's empty.
+	Access string `json:"access" yaml:"access"`
+	// ClientID and Username restrict the rule to a client and a user, any if they're empty.
+	ClientID string   `json:"client_id" yaml:"client_id"`
+	Username string   `json:"username" yaml:"username"`
+	Topics   []string `json:"topics" yaml:"topics"`
+}
+
+type Config struct {
+	// Default decides when no rule applies, deny if it's empty.
+	Default string `json:"default" yaml:"default"`
+	Rules   []Rule `json:"rules" yaml:"rules"`
+}
+
+type rule struct {
+	allow    bool
+	access   Access
+	clientID string
+	username string
+	topics   []string
+}
+
+type ruleSet struct {
+	allow bool
+	rules []rule
+}
+
+type Engine struct {
+	mu      sync.RWMutex
+	path    string
+	modTime time.Time
+	rules   *ruleSet
+}
+
+// New returns an engine with the rules of the config.
+func New(cfg Config) (*Engine, error) {
+	rs, err := compile(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{rules: rs}, nil
+}
+
+// Load returns an engine with the rules of the JSON or YAML file.
+func Load(path string) (*Engine, error) {
+	e := &Engine{path: path}
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reload reads the file of the rules again, the current rules are kept if it's invalid. The
+// clients connected are checked against the new rules from then on.
+func (e *Engine) Reload() error {
+	if len(e.path) == 0 {
+		return errors.New("acl/acl/Reload: the rules were not loaded from a file")
+	}
+	fi, err := os.Stat(e.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(e.path)
+	if err != nil {
+		return err
+	}
+
+	// JSON is YAML too
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("acl/acl/Reload: %v", err)
+	}
+	rs, err := compile(cfg)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.rules = rs
+	e.modTime = fi.ModTime()
+	e.mu.Unlock()
+	return nil
+}
+
+// Refresh reloads the file of the rules if it has changed since it was read, it reports whether
+// it was reloaded.
+func (e *Engine) Refresh() (bool, error) {
+	if len(e.path) == 0 {
+		return false, nil
+	}
+	fi, err := os.Stat(e.path)
+	if err != nil {
+		return false, err
+	}
+	e.mu.RLock()
+	changed := !fi.ModTime().Equal(e.modTime)
+	e.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	return true, e.Reload()
+}
+
+func compile(cfg Config) (*ruleSet, error) {
+	rs := &ruleSet{}
+	switch cfg.Default {
+	case Allow:
+		rs.allow = true
+	case Deny, "":
+	default:
+		return nil, fmt.Errorf("acl/acl/compile: unknown default %q", cfg.Default)
+	}
+
+	for i, r := range cfg.Rules {
+		cr := rule{clientID: r.ClientID, username: r.Username, topics: r.Topics}
+		switch r.Action {
+		case Allow:
+			cr.allow = true
+		case Deny:
+		default:
+			return nil, fmt.Errorf("acl/acl/compile: rule %d: unknown action %q", i, r.Action)
+		}
+		switch r.Access {
+		case "publish":
+			cr.access = Publish
+		case "subscribe":
+			cr.access = Subscribe
+		case "", "all":
+			cr.access = Publish | Subscribe
+		default:
+			return nil, fmt.Errorf("acl/acl/compile: rule %d: unknown access %q", i, r.Access)
+		}
+		if len(r.Topics) == 0 {
+			return nil, fmt.Errorf("acl/acl/compile: rule %d has no topic", i)
+		}
+		for _, t := range r.Topics {
+			if !validFilter(t) {
+				return nil, fmt.Errorf("acl/acl/compile: rule %d: invalid topic %q", i, t)
+			}
+		}
+		rs.rules = append(rs.rules, cr)
+	}
+	return rs, nil
+}
+
+// validFilter reports whether the wildcards of the filter are whole levels, and # the last one.
+func validFilter(filter string) bool {
+	if len(filter) == 0 {
+		return false
+	}
+	levels := strings.Split(filter, topics.SEP)
+	for i, level := range levels {
+		if level == topics.MWC && i == len(levels)-1 || level == topics.SWC {
+			continue
+		}
+		if strings.ContainsAny(level, topics.MWC+topics.SWC) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check reports whether the client may publish to the topic or subscribe to the filter.
+func (e *Engine) Check(clientID string, username string, access Access, topic string) bool {
+	e.mu.RLock()
+	rs := e.rules
+	e.mu.RUnlock()
+
+	for _, r := range rs.rules {
+		if r.access&access == 0 {
+			continue
+		}
+		if (len(r.clientID) > 0 && r.clientID != clientID) || (len(r.username) > 0 && r.username != username) {
+			continue
+		}
+		for _, t := range r.topics {
+			filter, ok := substitute(t, clientID, username)
+			if !ok {
+				continue
+			}
+			if applies(filter, topic, access, r.allow) {
+				return r.allow
+			}
+		}
+	}
+	return rs.allow
+}
+
+// substitute replaces %c and %u in the filter, the rule doesn't apply if the value is empty or
+// holds a wildcard or a level separator.
+func substitute(filter string, clientID string, username string) (string, bool) {
+	for _, v := range []struct {
+		pattern string
+		value   string
+	}{{"%c", clientID}, {"%u", username}} {
+		if !strings.Contains(filter, v.pattern) {
+			continue
+		}
+		if len(v.value) == 0 || strings.ContainsAny(v.value, "+#/") {
+			return "", false
+		}
+		filter = strings.Replace(filter, v.pattern, v.value, -1)
+	}
+	return filter, true
+}
+
+func applies(filter string, topic string, access Access, allow bool) bool {
+	if access == Publish {
+		ok, err := topics.MatchTopic([]byte(filter), []byte(topic))
+		return err == nil && ok
+	}
+	if allow {
+		return covers(strings.Split(filter, topics.SEP), strings.Split(topic, topics.SEP))
+	}
+	return overlaps(strings.Split(filter, topics.SEP), strings.Split(topic, topics.SEP))
+}
+
+// covers reports whether all the topics matched by the filter sub are matched by the filter.
+func covers(filter []string, sub []string) bool {
+	for i, level := range filter {
+		if level == topics.MWC {
+			return true
+		}
+		if i >= len(sub) {
+			return false
+		}
+		switch {
+		case sub[i] == topics.MWC:
+			return false
+		case level == topics.SWC:
+		case level != sub[i] || sub[i] == topics.SWC:
+			return false
+		}
+	}
+	return len(filter) == len(sub)
+}
+
+// overlaps reports whether a topic is matched by both filters.
+func overlaps(a []string, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == topics.MWC || b[i] == topics.MWC {
+			return true
+		}
+		if a[i] != topics.SWC && b[i] != topics.SWC && a[i] != b[i] {
+			return false
+		}
+	}
+	if len(a) == len(b) {
+		return true
+	}
+	// "a/#" matches "a" too
+	if len(a) == len(b)+1 {
+		return a[len(b)] == topics.MWC
+	}
+	if len(b) == len(a)+1 {
+		return b[len(a)] == topics.MWC
+	}
+	return false
+}
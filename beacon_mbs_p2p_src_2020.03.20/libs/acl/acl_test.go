@@ -0,0 +1,83 @@
+package acl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngineCheck(t *testing.T) {
+	e, err := New(Config{
+		Rules: []Rule{
+			{Action: Deny, Topics: []string{"devices/+/secret/#"}},
+			{Action: Allow, Topics: []string{"devices/%c/#"}},
+			{Action: Allow, Access: "subscribe", Username: "ops", Topics: []string{"devices/#"}},
+			{Action: Allow, Access: "publish", Topics: []string{"users/%u/inbox"}},
+		},
+	})
+	require.NoError(t, err)
+
+	require.True(t, e.Check("d1", "", Publish, "devices/d1/temp"))
+	require.True(t, e.Check("d1", "", Subscribe, "devices/d1/temp/+"))
+	require.False(t, e.Check("d1", "", Subscribe, "devices/d1/+"))
+	require.False(t, e.Check("d1", "", Publish, "devices/d2/temp"))
+	require.False(t, e.Check("d1", "", Publish, "devices/d1/secret/key"))
+
+	// the deny rule applies to the wider filters too
+	require.False(t, e.Check("d1", "", Subscribe, "devices/d1/#"))
+	require.False(t, e.Check("d1", "ops", Subscribe, "#"))
+	require.True(t, e.Check("d1", "ops", Subscribe, "devices/+/temp"))
+	require.False(t, e.Check("d1", "bob", Subscribe, "devices/+/temp"))
+
+	require.True(t, e.Check("d1", "bob", Publish, "users/bob/inbox"))
+	require.False(t, e.Check("d1", "bob", Subscribe, "users/bob/inbox"))
+	require.False(t, e.Check("d1", "", Publish, "users//inbox"))
+
+	// the substituted values cannot widen the filters
+	require.False(t, e.Check("+", "", Publish, "devices/d2/temp"))
+	require.False(t, e.Check("a/b", "", Publish, "devices/a/b"))
+
+	_, err = New(Config{Rules: []Rule{{Action: "maybe", Topics: []string{"a"}}}})
+	require.Error(t, err)
+	_, err = New(Config{Rules: []Rule{{Action: Allow, Topics: []string{"a/#/b"}}}})
+	require.Error(t, err)
+
+	e, err = New(Config{Default: Allow})
+	require.NoError(t, err)
+	require.True(t, e.Check("d1", "", Subscribe, "#"))
+}
+
+func TestEngineReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acl")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "acl.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte("rules:\n  - action: allow\n    topics: [\"a/#\"]\n"), 0600))
+
+	e, err := Load(path)
+	require.NoError(t, err)
+	require.True(t, e.Check("c1", "", Publish, "a/b"))
+	require.False(t, e.Check("c1", "", Publish, "b"))
+
+	reloaded, err := e.Refresh()
+	require.NoError(t, err)
+	require.False(t, reloaded)
+
+	// JSON is read too, an invalid file keeps the current rules
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"rules":[{"action":"allow","topics":["b"]}]}`), 0600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	reloaded, err = e.Refresh()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	require.False(t, e.Check("c1", "", Publish, "a/b"))
+	require.True(t, e.Check("c1", "", Publish, "b"))
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"rules":[{"action":"allow"}]}`), 0600))
+	require.Error(t, e.Reload())
+	require.True(t, e.Check("c1", "", Publish, "b"))
+}
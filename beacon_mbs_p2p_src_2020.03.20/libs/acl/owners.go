@@ -0,0 +1,156 @@
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+)
+
+// Claim is the ownership of the topics of a filter by a service, such as devices/+/config: only
+// the clients of the owner publish to them. The filters of two owners cannot share a topic, so two
+// services never both publish the authoritative state of a topic.
+type Claim struct {
+	Filter string `json:"filter" yaml:"filter"`
+	Owner  string `json:"owner" yaml:"owner"`
+	// ClientIDs and Usernames are the clients of the owner
+	ClientIDs []string `json:"client_ids" yaml:"client_ids"`
+	Usernames []string `json:"usernames" yaml:"usernames"`
+}
+
+func (c *Claim) member(clientID string, username string) bool {
+	for _, id := range c.ClientIDs {
+		if id == clientID {
+			return true
+		}
+	}
+	if len(username) == 0 {
+		return false
+	}
+	for _, u := range c.Usernames {
+		if u == username {
+			return true
+		}
+	}
+	return false
+}
+
+// ConflictError is returned for a claim sharing topics with the claim of another owner.
+type ConflictError struct {
+	Claim    Claim
+	Existing Claim
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("acl/owners: the filter %q of %s shares topics with the filter %q of %s",
+		e.Claim.Filter, e.Claim.Owner, e.Existing.Filter, e.Existing.Owner)
+}
+
+// Owners is the registry of the claims.
+type Owners struct {
+	mu     sync.RWMutex
+	claims []Claim
+}
+
+func NewOwners() *Owners {
+	return &Owners{}
+}
+
+// Claim registers the claim, it replaces the claim of the same owner and filter. A claim sharing
+// topics with the claim of another owner is refused with a ConflictError.
+func (o *Owners) Claim(c Claim) error {
+	if len(c.Owner) == 0 {
+		return errors.New("acl/owners/Claim: the owner cannot be empty")
+	}
+	if !validFilter(c.Filter) {
+		return fmt.Errorf("acl/owners/Claim: invalid filter %q", c.Filter)
+	}
+	if len(c.ClientIDs) == 0 && len(c.Usernames) == 0 {
+		return fmt.Errorf("acl/owners/Claim: the claim of %s on %q has no client", c.Owner, c.Filter)
+	}
+
+	levels := strings.Split(c.Filter, topics.SEP)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	replaced := -1
+	for i, existing := range o.claims {
+		if existing.Owner == c.Owner {
+			if existing.Filter == c.Filter {
+				replaced = i
+			}
+			continue
+		}
+		if overlaps(levels, strings.Split(existing.Filter, topics.SEP)) {
+			return &ConflictError{Claim: c, Existing: existing}
+		}
+	}
+
+	if replaced >= 0 {
+		o.claims[replaced] = c
+	} else {
+		o.claims = append(o.claims, c)
+	}
+	return nil
+}
+
+// Release removes the claim of the owner on the filter, false if there is none.
+func (o *Owners) Release(owner string, filter string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for i, c := range o.claims {
+		if c.Owner == owner && c.Filter == filter {
+			o.claims = append(o.claims[:i], o.claims[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Claims returns the claims sorted by filter.
+func (o *Owners) Claims() []Claim {
+	o.mu.RLock()
+	list := make([]Claim, len(o.claims))
+	copy(list, o.claims)
+	o.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Filter < list[j].Filter })
+	return list
+}
+
+// Owner returns the claims on the topic, they're all of the same owner. A filter ending with #
+// claims its parent topic too.
+func (o *Owners) Owner(topic string) []Claim {
+	levels := strings.Split(topic, topics.SEP)
+
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	var list []Claim
+	for _, c := range o.claims {
+		if covers(strings.Split(c.Filter, topics.SEP), levels) {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// CheckPublish reports whether the client may publish to the topic: the topic is claimed by none,
+// or the client is one of its owner.
+func (o *Owners) CheckPublish(clientID string, username string, topic string) bool {
+	claims := o.Owner(topic)
+	if len(claims) == 0 {
+		return true
+	}
+	for i := range claims {
+		if claims[i].member(clientID, username) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package acl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOwners(t *testing.T) {
+	o := NewOwners()
+	require.NoError(t, o.Claim(Claim{Filter: "devices/+/config", Owner: "provisioning", Usernames: []string{"prov"}}))
+	require.NoError(t, o.Claim(Claim{Filter: "devices/+/config/#", Owner: "provisioning", ClientIDs: []string{"prov-1"}}))
+	require.NoError(t, o.Claim(Claim{Filter: "devices/+/state", Owner: "telemetry", Usernames: []string{"tele"}}))
+
+	err := o.Claim(Claim{Filter: "devices/d1/#", Owner: "telemetry", Usernames: []string{"tele"}})
+	require.IsType(t, &ConflictError{}, err)
+	require.Equal(t, "provisioning", err.(*ConflictError).Existing.Owner)
+	require.Error(t, o.Claim(Claim{Filter: "a/#/b", Owner: "x", Usernames: []string{"x"}}))
+	require.Error(t, o.Claim(Claim{Filter: "a/b", Owner: "x"}))
+
+	require.True(t, o.CheckPublish("c1", "prov", "devices/d1/config"))
+	require.True(t, o.CheckPublish("prov-1", "", "devices/d1/config/wifi"))
+	require.False(t, o.CheckPublish("c1", "tele", "devices/d1/config"))
+	require.False(t, o.CheckPublish("c1", "", "devices/d1/config"))
+	require.True(t, o.CheckPublish("c1", "", "devices/d1/other"))
+
+	require.True(t, o.Release("provisioning", "devices/+/config"))
+	require.False(t, o.Release("provisioning", "devices/+/config"))
+	require.False(t, o.CheckPublish("c1", "prov", "devices/d1/config"))
+	require.True(t, o.CheckPublish("prov-1", "", "devices/d1/config"))
+	require.Len(t, o.Claims(), 2)
+}
@@ -0,0 +1,257 @@
+package annotations
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"awesomeProject/beacon/mqtt_network/libs/atomicfile"
+	"awesomeProject/beacon/mqtt_network/libs/backup"
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+)
+
+const (
+	MaxKeyLength   = 128
+	MaxValueLength = 1024
+	MaxKeysPerItem = 64
+)
+
+// Store associates key/value annotations (such as the device model, firmware or location) with
+// the topics. An annotation set on a filter applies to all the topics it matches, and the longer
+// filters override the shorter ones, so "devices/d1/#" can refine what "devices/#" sets.
+//
+// The store is persisted to a JSON file (if the path is not empty) each time it changes.
+type Store struct {
+	mu    sync.RWMutex
+	path  string
+	items map[string]map[string]string
+}
+
+// NewStore loads the annotations persisted in the file, a missing file is an empty store.
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		path:  path,
+		items: make(map[string]map[string]string),
+	}
+
+	if len(path) == 0 {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &s.items); err != nil {
+		return nil, fmt.Errorf("annotations/annotations/NewStore: invalid annotations file %s => %v", path, err)
+	}
+
+	for filter, kv := range s.items {
+		if err := checkFilter(filter); err != nil {
+			return nil, err
+		}
+		for k, v := range kv {
+			if err := checkKeyValue(k, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return s, nil
+}
+
+func checkFilter(filter string) error {
+	if len(filter) == 0 {
+		return fmt.Errorf("annotations/annotations/checkFilter: filter cannot be empty")
+	}
+
+	for rem := []byte(filter); len(rem) > 0; {
+		var err error
+		if _, rem, err = topics.NextTopicLevel(rem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkKeyValue(key string, value string) error {
+	if len(key) == 0 || len(key) > MaxKeyLength {
+		return fmt.Errorf("annotations/annotations/checkKeyValue: key length must be in [1-%d]", MaxKeyLength)
+	}
+	if len(value) > MaxValueLength {
+		return fmt.Errorf("annotations/annotations/checkKeyValue: value of key %s is longer than %d", key, MaxValueLength)
+	}
+	return nil
+}
+
+// Set annotates the topics matched by the filter.
+func (s *Store) Set(filter string, key string, value string) error {
+	if err := checkFilter(filter); err != nil {
+		return err
+	}
+	if err := checkKeyValue(key, value); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kv, exist := s.items[filter]
+	if !exist {
+		kv = make(map[string]string)
+		s.items[filter] = kv
+	}
+
+	old, oldExist := kv[key]
+	if !oldExist && len(kv) >= MaxKeysPerItem {
+		return fmt.Errorf("annotations/annotations/Set: filter %s has %d keys already", filter, MaxKeysPerItem)
+	}
+	kv[key] = value
+
+	if err := s.save(); err != nil {
+		if oldExist {
+			kv[key] = old
+		} else {
+			delete(kv, key)
+		}
+		if len(kv) == 0 {
+			delete(s.items, filter)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes the key from the annotations of the filter, all of them if the key is empty.
+func (s *Store) Delete(filter string, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kv, exist := s.items[filter]
+	if !exist {
+		return fmt.Errorf("annotations/annotations/Delete: No annotation found for filter %s", filter)
+	}
+
+	old := make(map[string]string, len(kv))
+	for k, v := range kv {
+		old[k] = v
+	}
+
+	if len(key) == 0 {
+		delete(s.items, filter)
+	} else {
+		if _, ok := kv[key]; !ok {
+			return fmt.Errorf("annotations/annotations/Delete: No annotation %s found for filter %s", key, filter)
+		}
+		delete(kv, key)
+		if len(kv) == 0 {
+			delete(s.items, filter)
+		}
+	}
+
+	if err := s.save(); err != nil {
+		s.items[filter] = old
+		return err
+	}
+
+	return nil
+}
+
+// Get returns the annotations set on the filter itself.
+func (s *Store) Get(filter string) map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return copyMap(s.items[filter])
+}
+
+// Lookup returns the annotations of the topic, merged from all the filters matching it.
+func (s *Store) Lookup(topic string) map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matched []string
+	for filter := range s.items {
+		if ok, _ := topics.MatchTopic([]byte(filter), []byte(topic)); ok {
+			matched = append(matched, filter)
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+
+	// The longer filters are applied last, so they override the shorter ones.
+	sort.Slice(matched, func(i, j int) bool {
+		if len(matched[i]) != len(matched[j]) {
+			return len(matched[i]) < len(matched[j])
+		}
+		return matched[i] < matched[j]
+	})
+
+	kv := make(map[string]string)
+	for _, filter := range matched {
+		for k, v := range s.items[filter] {
+			kv[k] = v
+		}
+	}
+	return kv
+}
+
+// Filters returns the annotated filters, sorted.
+func (s *Store) Filters() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]string, 0, len(s.items))
+	for filter := range s.items {
+		list = append(list, filter)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func copyMap(kv map[string]string) map[string]string {
+	if kv == nil {
+		return nil
+	}
+
+	m := make(map[string]string, len(kv))
+	for k, v := range kv {
+		m[k] = v
+	}
+	return m
+}
+
+// Snapshot returns the annotations as they are persisted, for the backups.
+func (s *Store) Snapshot() (backup.Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := json.MarshalIndent(s.items, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return backup.Bytes(data), nil
+}
+
+// Writes to a temporary file then renames it, the file is never left half written.
+func (s *Store) save() error {
+	if len(s.path) == 0 {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.items, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(s.path, data)
+}
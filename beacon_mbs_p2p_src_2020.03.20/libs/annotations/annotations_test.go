@@ -0,0 +1,59 @@
+package annotations
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreLookup(t *testing.T) {
+	s, err := NewStore("")
+	require.NoError(t, err)
+
+	require.Error(t, s.Set("", "model", "x"))
+	require.Error(t, s.Set("devices/#/state", "model", "x"))
+	require.Error(t, s.Set("devices/#", "", "x"))
+	require.Error(t, s.Set("devices/#", "model", strings.Repeat("x", MaxValueLength+1)))
+
+	require.NoError(t, s.Set("devices/#", "model", "generic"))
+	require.NoError(t, s.Set("devices/#", "site", "lab"))
+	require.NoError(t, s.Set("devices/d1/#", "model", "t1000"))
+	require.NoError(t, s.Set("devices/d1/temp", "unit", "celsius"))
+
+	require.Equal(t, map[string]string{"model": "t1000", "site": "lab", "unit": "celsius"}, s.Lookup("devices/d1/temp"))
+	require.Equal(t, map[string]string{"model": "generic", "site": "lab"}, s.Lookup("devices/d2/temp"))
+	require.Nil(t, s.Lookup("other/d1/temp"))
+
+	require.Equal(t, map[string]string{"model": "t1000"}, s.Get("devices/d1/#"))
+	require.Equal(t, []string{"devices/#", "devices/d1/#", "devices/d1/temp"}, s.Filters())
+
+	require.Error(t, s.Delete("devices/d2/#", ""))
+	require.Error(t, s.Delete("devices/#", "firmware"))
+	require.NoError(t, s.Delete("devices/d1/#", "model"))
+	require.Equal(t, "generic", s.Lookup("devices/d1/temp")["model"])
+	require.NoError(t, s.Delete("devices/#", ""))
+	require.Equal(t, map[string]string{"unit": "celsius"}, s.Lookup("devices/d1/temp"))
+}
+
+func TestStorePersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "annotations")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "annotations.json")
+	s, err := NewStore(path)
+	require.NoError(t, err)
+	require.NoError(t, s.Set("devices/d1/#", "firmware", "1.2.0"))
+
+	s, err = NewStore(path)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"firmware": "1.2.0"}, s.Lookup("devices/d1/state"))
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"devices/#/x":{"a":"b"}}`), 0600))
+	_, err = NewStore(path)
+	require.Error(t, err)
+}
@@ -0,0 +1,188 @@
+// Package asyncapi builds an AsyncAPI 2.0 document describing the live topic namespace of the
+// broker, from the topics it has seen, their retained messages, annotations and schemas.
+package asyncapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+)
+
+const Version = "2.0.0"
+
+// Channel is a topic known to the broker.
+type Channel struct {
+	Topic       string
+	Retained    bool
+	LastPublish time.Time
+	// Sample is a payload of the topic (the retained one if any), its schema is inferred from it
+	// if no schema is configured for the topic.
+	Sample      []byte
+	Annotations map[string]string
+}
+
+// Schema is the JSON schema configured for the payloads of the topics matched by the filter.
+type Schema struct {
+	Filter string
+	Schema json.RawMessage
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Server struct {
+	URL             string `json:"url"`
+	Protocol        string `json:"protocol"`
+	ProtocolVersion string `json:"protocolVersion,omitempty"`
+}
+
+type Message struct {
+	ContentType string          `json:"contentType,omitempty"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Examples    []interface{}   `json:"examples,omitempty"`
+}
+
+type Operation struct {
+	Summary string  `json:"summary,omitempty"`
+	Message Message `json:"message"`
+}
+
+type ChannelItem struct {
+	Description string            `json:"description,omitempty"`
+	Subscribe   *Operation        `json:"subscribe,omitempty"`
+	Publish     *Operation        `json:"publish,omitempty"`
+	Extensions  map[string]string `json:"x-annotations,omitempty"`
+	Retained    bool              `json:"x-retained,omitempty"`
+	LastPublish *time.Time        `json:"x-last-publish,omitempty"`
+}
+
+type Document struct {
+	AsyncAPI string                 `json:"asyncapi"`
+	Info     Info                   `json:"info"`
+	Servers  map[string]Server      `json:"servers,omitempty"`
+	Channels map[string]ChannelItem `json:"channels"`
+}
+
+// Build describes the channels, the system topics ($SYS ...) are left out. The longest filter
+// matching a topic gives its schema.
+func Build(info Info, servers map[string]Server, channels []Channel, schemas []Schema) *Document {
+	doc := &Document{
+		AsyncAPI: Version,
+		Info:     info,
+		Servers:  servers,
+		Channels: make(map[string]ChannelItem, len(channels)),
+	}
+
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Topic < channels[j].Topic })
+	for _, ch := range channels {
+		if len(ch.Topic) == 0 || strings.HasPrefix(ch.Topic, topics.SYS) {
+			continue
+		}
+
+		msg := Message{}
+		if schema := schemaOf(ch.Topic, schemas); schema != nil {
+			msg.ContentType = "application/json"
+			msg.Payload = schema
+		} else {
+			msg.ContentType, msg.Payload = inferSchema(ch.Sample)
+		}
+		if example := exampleOf(ch.Sample); example != nil {
+			msg.Examples = []interface{}{map[string]interface{}{"payload": example}}
+		}
+
+		item := ChannelItem{
+			Subscribe:  &Operation{Message: msg},
+			Extensions: ch.Annotations,
+			Retained:   ch.Retained,
+		}
+		if desc, ok := ch.Annotations["description"]; ok {
+			item.Description = desc
+		}
+		if !ch.LastPublish.IsZero() {
+			t := ch.LastPublish.UTC()
+			item.LastPublish = &t
+		}
+
+		doc.Channels[ch.Topic] = item
+	}
+
+	return doc
+}
+
+func (d *Document) Marshal() ([]byte, error) {
+	return json.MarshalIndent(d, "", "  ")
+}
+
+func schemaOf(topic string, schemas []Schema) json.RawMessage {
+	var schema json.RawMessage
+	longest := -1
+	for _, s := range schemas {
+		if ok, _ := topics.MatchTopic([]byte(s.Filter), []byte(topic)); ok && len(s.Filter) > longest {
+			schema, longest = s.Schema, len(s.Filter)
+		}
+	}
+	return schema
+}
+
+// The JSON payloads get the schema of their structure, the other ones are opaque bytes.
+func inferSchema(sample []byte) (string, json.RawMessage) {
+	var v interface{}
+	if len(sample) == 0 || !isJSON(sample, &v) {
+		return "application/octet-stream", json.RawMessage(`{"type":"string","format":"binary"}`)
+	}
+
+	data, _ := json.Marshal(schemaOfValue(v))
+	return "application/json", data
+}
+
+func isJSON(sample []byte, v *interface{}) bool {
+	d := json.NewDecoder(bytes.NewReader(sample))
+	d.UseNumber()
+	if err := d.Decode(v); err != nil {
+		return false
+	}
+	return !d.More()
+}
+
+func schemaOfValue(v interface{}) map[string]interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		props := make(map[string]interface{}, len(t))
+		for k, pv := range t {
+			props[k] = schemaOfValue(pv)
+		}
+		return map[string]interface{}{"type": "object", "properties": props}
+	case []interface{}:
+		s := map[string]interface{}{"type": "array"}
+		if len(t) > 0 {
+			s["items"] = schemaOfValue(t[0])
+		}
+		return s
+	case json.Number:
+		if _, err := t.Int64(); err == nil {
+			return map[string]interface{}{"type": "integer"}
+		}
+		return map[string]interface{}{"type": "number"}
+	case string:
+		return map[string]interface{}{"type": "string"}
+	case bool:
+		return map[string]interface{}{"type": "boolean"}
+	default:
+		return map[string]interface{}{"type": "null"}
+	}
+}
+
+func exampleOf(sample []byte) interface{} {
+	var v interface{}
+	if len(sample) == 0 || !isJSON(sample, &v) {
+		return nil
+	}
+	return v
+}
@@ -0,0 +1,54 @@
+package asyncapi
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuild(t *testing.T) {
+	last := time.Unix(1584662400, 0)
+	channels := []Channel{
+		{Topic: "devices/d1/state", Retained: true, Sample: []byte(`{"on":true,"level":3,"temp":21.5,"tags":["a"]}`),
+			Annotations: map[string]string{"model": "t1000", "description": "device state"}},
+		{Topic: "devices/d1/raw", LastPublish: last, Sample: []byte{0x01, 0x02}},
+		{Topic: "devices/d1/config", LastPublish: last},
+		{Topic: "$SYS/broker/uptime", Retained: true},
+	}
+	schemas := []Schema{
+		{Filter: "devices/#", Schema: json.RawMessage(`{"type":"object"}`)},
+		{Filter: "devices/+/config", Schema: json.RawMessage(`{"type":"object","required":["rev"]}`)},
+	}
+
+	doc := Build(Info{Title: "beacon", Version: "1"}, nil, channels, schemas[1:])
+	require.Equal(t, Version, doc.AsyncAPI)
+	require.Len(t, doc.Channels, 3)
+
+	state := doc.Channels["devices/d1/state"]
+	require.True(t, state.Retained)
+	require.Equal(t, "device state", state.Description)
+	require.Equal(t, "application/json", state.Subscribe.Message.ContentType)
+	require.JSONEq(t, `{"type":"object","properties":{
+		"on":{"type":"boolean"},"level":{"type":"integer"},"temp":{"type":"number"},
+		"tags":{"type":"array","items":{"type":"string"}}}}`, string(state.Subscribe.Message.Payload))
+	require.Len(t, state.Subscribe.Message.Examples, 1)
+
+	raw := doc.Channels["devices/d1/raw"]
+	require.Equal(t, "application/octet-stream", raw.Subscribe.Message.ContentType)
+	require.Equal(t, last.UTC(), *raw.LastPublish)
+	require.Nil(t, raw.Subscribe.Message.Examples)
+
+	config := doc.Channels["devices/d1/config"]
+	require.JSONEq(t, `{"type":"object","required":["rev"]}`, string(config.Subscribe.Message.Payload))
+
+	// The longest matching filter gives the schema
+	doc = Build(Info{Title: "beacon", Version: "1"}, nil, channels, schemas)
+	require.JSONEq(t, `{"type":"object"}`, string(doc.Channels["devices/d1/raw"].Subscribe.Message.Payload))
+	require.JSONEq(t, `{"type":"object","required":["rev"]}`, string(doc.Channels["devices/d1/config"].Subscribe.Message.Payload))
+
+	data, err := doc.Marshal()
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"asyncapi": "2.0.0"`)
+}
@@ -0,0 +1,31 @@
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomicfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+	require.NoError(t, WriteFile(path, []byte("v1")))
+	require.NoError(t, WriteFile(path, []byte("v2")))
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, []byte("v2"), data)
+
+	// no temporary file is left behind
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	require.Error(t, WriteFile(filepath.Join(dir, "missing", "state.json"), []byte("v3")))
+}
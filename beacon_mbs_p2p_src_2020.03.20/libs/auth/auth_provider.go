@@ -0,0 +1,152 @@
+// Package auth checks the credentials of the CONNECT packets. The providers are registered by
+// name like the topics and sessions providers, the broker picks one with WithAuthManager.
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/autosub"
+	"awesomeProject/beacon/mqtt_network/libs/quota"
+)
+
+var (
+	providers = make(map[string]TheAuthProvider)
+)
+
+// Credentials are the fields of a CONNECT the providers decide on.
+type Credentials struct {
+	ClientID   string
+	Username   string
+	Password   []byte
+	RemoteAddr string
+}
+
+// TheAuthProvider is invoked on each CONNECT, it returns false if the credentials are refused, and
+// an error if it cannot decide, such as when its backend is unreachable.
+type TheAuthProvider interface {
+	Authenticate(c Credentials) (bool, error)
+	Close() error
+}
+
+// ExpiringProvider is implemented by the providers of the credentials which expire, such as the
+// access tokens, the broker asks the clients for fresh ones before they expire.
+type ExpiringProvider interface {
+	// AuthenticateExpiry is Authenticate, it also returns when the accepted credentials expire,
+	// zero if they never do.
+	AuthenticateExpiry(c Credentials) (bool, time.Time, error)
+}
+
+// LimitingProvider is implemented by the providers which override the limits of the clients by
+// username, the limits they leave zero are the ones of the broker.
+type LimitingProvider interface {
+	// Limits returns the limits of the username, false if the provider has none for it.
+	Limits(username string) (quota.Limits, bool)
+}
+
+// SubscribingProvider is implemented by the providers which tell the subscriptions the broker makes
+// for a client on CONNECT, besides the ones of the broker.
+type SubscribingProvider interface {
+	// Subscriptions returns the subscriptions of the client, false if the provider has none for it.
+	Subscriptions(clientID string) ([]autosub.Subscription, bool)
+}
+
+// CertificateProvider is implemented by the providers which pin the client certificates of the
+// identities, the certificate is verified by the TLS listener first.
+type CertificateProvider interface {
+	// AuthenticateCertificate returns false if the DER encoded certificate is refused for the
+	// identity.
+	AuthenticateCertificate(identity string, der []byte) (bool, error)
+}
+
+// CredentialManager is implemented by the providers keeping several credentials per identity,
+// each valid in its own window, so the credentials are rotated without a flag day.
+type CredentialManager interface {
+	AddCredential(identity string, c Credential) error
+	ExpireCredential(identity string, id string, notAfter time.Time) error
+	RemoveCredential(identity string, id string) error
+	Credentials(identity string) []Credential
+	Identities() []string
+}
+
+// Register makes an auth provider available by the provided name.
+// If a Register is called twice with the same name or if the provider is nil,
+// it panics.
+func Register(name string, provider TheAuthProvider) {
+	if provider == nil {
+		panic("auth_provider: Register provider is nil")
+	}
+
+	if _, dup := providers[name]; dup {
+		panic("auth_provider: Register called twice for provider " + name)
+	}
+
+	providers[name] = provider
+}
+
+func Unregister(name string) {
+	delete(providers, name)
+}
+
+type Manager struct {
+	p TheAuthProvider
+}
+
+func NewManager(providerName string) (*Manager, error) {
+	p, ok := providers[providerName]
+	if !ok {
+		return nil, fmt.Errorf("auth_provider: unknown provider %q", providerName)
+	}
+
+	return &Manager{p: p}, nil
+}
+
+func (m *Manager) Authenticate(c Credentials) (bool, error) {
+	return m.p.Authenticate(c)
+}
+
+// AuthenticateExpiry returns when the accepted credentials expire, zero if they never do or if
+// the provider doesn't tell.
+func (m *Manager) AuthenticateExpiry(c Credentials) (bool, time.Time, error) {
+	if e, ok := m.p.(ExpiringProvider); ok {
+		return e.AuthenticateExpiry(c)
+	}
+	ok, err := m.p.Authenticate(c)
+	return ok, time.Time{}, err
+}
+
+// Limits returns the limits of the username, false if the provider doesn't override them.
+func (m *Manager) Limits(username string) (quota.Limits, bool) {
+	if l, ok := m.p.(LimitingProvider); ok {
+		return l.Limits(username)
+	}
+	return quota.Limits{}, false
+}
+
+// Subscriptions returns the subscriptions of the client, false if the provider tells none.
+func (m *Manager) Subscriptions(clientID string) ([]autosub.Subscription, bool) {
+	if s, ok := m.p.(SubscribingProvider); ok {
+		return s.Subscriptions(clientID)
+	}
+	return nil, false
+}
+
+// AuthenticateCertificate returns whether the provider accepts the certificate for the identity,
+// true if it doesn't pin the certificates.
+func (m *Manager) AuthenticateCertificate(identity string, der []byte) (bool, error) {
+	if p, ok := m.p.(CertificateProvider); ok {
+		return p.AuthenticateCertificate(identity, der)
+	}
+	return true, nil
+}
+
+// Credentials returns the credential manager of the provider, false if it doesn't manage the
+// credentials.
+func (m *Manager) Credentials() (CredentialManager, bool) {
+	cm, ok := m.p.(CredentialManager)
+	return cm, ok
+}
+
+func (m *Manager) Close() error {
+	return m.p.Close()
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/autosub"
+	"awesomeProject/beacon/mqtt_network/libs/quota"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestFileProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	path := filepath.Join(dir, "credentials")
+	require.NoError(t, ioutil.WriteFile(path, []byte("# users\n\nalice:"+string(hash)+"\n"), 0600))
+
+	UnRegisterFileAuthProvider()
+	require.NoError(t, RegisterFileAuthProvider(path))
+	defer UnRegisterFileAuthProvider()
+	m, err := NewManager("file")
+	require.NoError(t, err)
+
+	ok, err := m.Authenticate(Credentials{Username: "alice", Password: []byte("secret")})
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = m.Authenticate(Credentials{Username: "alice", Password: []byte("wrong")})
+	require.NoError(t, err)
+	require.False(t, ok)
+	ok, err = m.Authenticate(Credentials{Username: "bob", Password: []byte("secret")})
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// an invalid file keeps the current credentials
+	require.NoError(t, ioutil.WriteFile(path, []byte("alice:plain\n"), 0600))
+	p := providers["file"].(*fileProvider)
+	require.Error(t, p.Reload())
+	ok, err = m.Authenticate(Credentials{Username: "alice", Password: []byte("secret")})
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, err = NewManager("unknown")
+	require.Error(t, err)
+}
+
+func TestHTTPProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var c webhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch {
+		case r.Header.Get("Authorization") != "Bearer broker":
+			w.WriteHeader(http.StatusInternalServerError)
+		case c.Username == "alice" && c.Password == "secret" && c.ClientID == "c1":
+			w.WriteHeader(http.StatusOK)
+		case c.Username == "alice" && c.Password == "token" && c.ClientID == "c1":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"expires_in":3600,"limits":{"publish_rate":10,"action":"disconnect"},"subscriptions":[{"filter":"devices/%c/cmd","qos":1}]}`))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	p, err := NewHTTPProvider(HTTPConfig{URL: server.URL, Header: map[string]string{"Authorization": "Bearer broker"}})
+	require.NoError(t, err)
+
+	ok, err := p.Authenticate(Credentials{ClientID: "c1", Username: "alice", Password: []byte("secret")})
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = p.Authenticate(Credentials{ClientID: "c1", Username: "alice", Password: []byte("wrong")})
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ok, expiry, err := p.AuthenticateExpiry(Credentials{ClientID: "c1", Username: "alice", Password: []byte("token")})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.WithinDuration(t, time.Now().Add(time.Hour), expiry, time.Minute)
+	limits, ok := p.Limits("alice")
+	require.True(t, ok)
+	require.Equal(t, quota.Limits{PublishRate: 10, Action: quota.Disconnect}, limits)
+	subs, ok := p.Subscriptions("c1")
+	require.True(t, ok)
+	require.Equal(t, []autosub.Subscription{{Filter: "devices/%c/cmd", Qos: 1}}, subs)
+	ok, expiry, err = p.AuthenticateExpiry(Credentials{ClientID: "c1", Username: "alice", Password: []byte("secret")})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.True(t, expiry.IsZero())
+	_, ok = p.Limits("alice")
+	require.False(t, ok)
+	_, ok = p.Subscriptions("c1")
+	require.False(t, ok)
+
+	p.cfg.Header = nil
+	_, err = p.Authenticate(Credentials{ClientID: "c1", Username: "alice", Password: []byte("secret")})
+	require.Error(t, err)
+}
+
+func TestIdentityProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "identities.json")
+
+	p, err := NewIdentityProvider(path)
+	require.NoError(t, err)
+	now := time.Unix(1000, 0)
+	p.now = func() time.Time { return now }
+
+	require.Error(t, p.AddCredential("dev1", Credential{ID: "pw1"}))
+	require.NoError(t, p.AddCredential("dev1", Credential{ID: "pw1", Password: "old"}))
+	require.NoError(t, p.AddCredential("dev1", Credential{ID: "pw2", Password: "new", NotBefore: now}))
+	require.NoError(t, p.AddCredential("dev1", Credential{ID: "cert1", Fingerprint: Fingerprint([]byte("cert-1"))}))
+
+	// both passwords are valid during the rotation
+	ok, err := p.Authenticate(Credentials{Username: "dev1", Password: []byte("old")})
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = p.Authenticate(Credentials{Username: "dev1", Password: []byte("new")})
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	end := now.Add(time.Hour)
+	require.NoError(t, p.ExpireCredential("dev1", "pw1", end))
+	ok, expiry, err := p.AuthenticateExpiry(Credentials{Username: "dev1", Password: []byte("old")})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, end, expiry)
+	require.Equal(t, ErrNoCredential, p.ExpireCredential("dev1", "pw9", end))
+
+	// the credentials are saved, without the passwords
+	p, err = NewIdentityProvider(path)
+	require.NoError(t, err)
+	p.now = func() time.Time { return end }
+	ok, err = p.Authenticate(Credentials{Username: "dev1", Password: []byte("old")})
+	require.NoError(t, err)
+	require.False(t, ok)
+	creds := p.Credentials("dev1")
+	require.Len(t, creds, 3)
+	for _, c := range creds {
+		require.Empty(t, c.Password)
+		require.Empty(t, c.Hash)
+	}
+
+	ok, err = p.AuthenticateCertificate("dev1", []byte("cert-1"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = p.AuthenticateCertificate("dev1", []byte("cert-2"))
+	require.NoError(t, err)
+	require.False(t, ok)
+	// an identity pinning no certificate accepts the certificates verified by the listener
+	ok, err = p.AuthenticateCertificate("dev2", []byte("cert-2"))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	require.NoError(t, p.RemoveCredential("dev1", "cert1"))
+	require.Equal(t, []string{"dev1"}, p.Identities())
+}
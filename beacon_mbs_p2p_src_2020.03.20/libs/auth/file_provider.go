@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var _ TheAuthProvider = (*fileProvider)(nil)
+
+// fileProvider checks the passwords against the bcrypt hashes of a credentials file, one
+// "username:hash" per line. The empty lines and the lines starting with '#' are skipped.
+type fileProvider struct {
+	mu     sync.RWMutex
+	path   string
+	hashes map[string][]byte
+}
+
+func RegisterFileAuthProvider(path string) error {
+	p, err := NewFileProvider(path)
+	if err != nil {
+		return err
+	}
+	Register("file", p)
+	return nil
+}
+
+func UnRegisterFileAuthProvider() {
+	Unregister("file")
+}
+
+func NewFileProvider(path string) (*fileProvider, error) {
+	p := &fileProvider{path: path}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload reads the credentials file again, the current credentials are kept if it's invalid.
+func (p *fileProvider) Reload() error {
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+
+	hashes := make(map[string][]byte)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ':')
+		if i < 1 {
+			return fmt.Errorf("auth/file_provider/Reload: line %d is not username:hash", n)
+		}
+		hash := []byte(line[i+1:])
+		if _, err := bcrypt.Cost(hash); err != nil {
+			return fmt.Errorf("auth/file_provider/Reload: line %d: %v", n, err)
+		}
+		hashes[line[:i]] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.hashes = hashes
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *fileProvider) Authenticate(c Credentials) (bool, error) {
+	p.mu.RLock()
+	hash, ok := p.hashes[c.Username]
+	p.mu.RUnlock()
+	if !ok {
+		return false, nil
+	}
+
+	err := bcrypt.CompareHashAndPassword(hash, c.Password)
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (p *fileProvider) Close() error {
+	return nil
+}
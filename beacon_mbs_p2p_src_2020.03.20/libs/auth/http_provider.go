@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/autosub"
+	"awesomeProject/beacon/mqtt_network/libs/quota"
+)
+
+const defaultHTTPTimeout = 5 * time.Second
+
+var (
+	_ TheAuthProvider  = (*httpProvider)(nil)
+	_ ExpiringProvider = (*httpProvider)(nil)
+	_ LimitingProvider = (*httpProvider)(nil)
+)
+
+type HTTPConfig struct {
+	// URL receives a POST of the webhookRequest for each CONNECT.
+	URL string `json:"url"`
+	// Timeout of a request, 5 seconds if 0.
+	Timeout time.Duration `json:"timeout"`
+	// Header is added to the requests, such as an Authorization of the broker.
+	Header map[string]string `json:"header"`
+}
+
+// webhookRequest is the JSON body posted to the webhook, the password is sent as a string.
+type webhookRequest struct {
+	ClientID   string `json:"client_id"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	RemoteAddr string `json:"remote_addr"`
+}
+
+// webhookResponse is the optional JSON body (application/json) of a 2xx answer, such as {"expires_in": 3600} for a
+// token valid for an hour, or {"limits": {"publish_rate": 10}} to override the limits of the username.
+// The subscriptions, such as {"subscriptions": [{"filter": "devices/%c/cmd", "qos": 1}]}, are made
+// for the client on CONNECT.
+type webhookResponse struct {
+	ExpiresIn     int64                  `json:"expires_in"`
+	Limits        *quota.Limits          `json:"limits"`
+	Subscriptions []autosub.Subscription `json:"subscriptions"`
+}
+
+// httpProvider asks a webhook: a 2xx answer accepts the credentials, 401 and 403 refuse them, any
+// other answer is an error.
+type httpProvider struct {
+	cfg    HTTPConfig
+	client *http.Client
+
+	// The limits of the last accepted answer for each username, and its subscriptions for each
+	// client id
+	mu            sync.RWMutex
+	limits        map[string]quota.Limits
+	subscriptions map[string][]autosub.Subscription
+}
+
+func RegisterHTTPAuthProvider(cfg HTTPConfig) error {
+	p, err := NewHTTPProvider(cfg)
+	if err != nil {
+		return err
+	}
+	Register("http", p)
+	return nil
+}
+
+func UnRegisterHTTPAuthProvider() {
+	Unregister("http")
+}
+
+func NewHTTPProvider(cfg HTTPConfig) (*httpProvider, error) {
+	if len(cfg.URL) == 0 {
+		return nil, errors.New("auth/http_provider/NewHTTPProvider: the url is needed")
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultHTTPTimeout
+	}
+	return &httpProvider{
+		cfg:           cfg,
+		client:        &http.Client{Timeout: cfg.Timeout},
+		limits:        make(map[string]quota.Limits),
+		subscriptions: make(map[string][]autosub.Subscription),
+	}, nil
+}
+
+func (p *httpProvider) Authenticate(c Credentials) (bool, error) {
+	ok, _, err := p.AuthenticateExpiry(c)
+	return ok, err
+}
+
+func (p *httpProvider) AuthenticateExpiry(c Credentials) (bool, time.Time, error) {
+	body, err := json.Marshal(webhookRequest{
+		ClientID:   c.ClientID,
+		Username:   c.Username,
+		Password:   string(c.Password),
+		RemoteAddr: c.RemoteAddr,
+	})
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range p.cfg.Header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+		_ = resp.Body.Close()
+	}()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		var r webhookResponse
+		if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+			if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&r); err != nil && err != io.EOF {
+				return false, time.Time{}, fmt.Errorf("auth/http_provider/AuthenticateExpiry: invalid webhook answer => %v", err)
+			}
+		}
+		if r.Limits != nil {
+			if err := r.Limits.Validate(); err != nil {
+				return false, time.Time{}, fmt.Errorf("auth/http_provider/AuthenticateExpiry: invalid webhook limits => %v", err)
+			}
+		}
+		if err := autosub.Validate(r.Subscriptions); err != nil {
+			return false, time.Time{}, fmt.Errorf("auth/http_provider/AuthenticateExpiry: invalid webhook subscriptions => %v", err)
+		}
+		p.setLimits(c.Username, r.Limits)
+		p.setSubscriptions(c.ClientID, r.Subscriptions)
+		if r.ExpiresIn > 0 {
+			return true, time.Now().Add(time.Duration(r.ExpiresIn) * time.Second), nil
+		}
+		return true, time.Time{}, nil
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return false, time.Time{}, nil
+	default:
+		return false, time.Time{}, fmt.Errorf("auth/http_provider/AuthenticateExpiry: the webhook answered %s", resp.Status)
+	}
+}
+
+func (p *httpProvider) setLimits(username string, l *quota.Limits) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if l == nil {
+		delete(p.limits, username)
+		return
+	}
+	p.limits[username] = *l
+}
+
+// Limits returns the limits of the last answer accepting the username.
+func (p *httpProvider) Limits(username string) (quota.Limits, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	l, ok := p.limits[username]
+	return l, ok
+}
+
+func (p *httpProvider) setSubscriptions(clientID string, list []autosub.Subscription) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(list) == 0 {
+		delete(p.subscriptions, clientID)
+		return
+	}
+	p.subscriptions[clientID] = list
+}
+
+// Subscriptions returns the subscriptions of the last answer accepting the client id.
+func (p *httpProvider) Subscriptions(clientID string) ([]autosub.Subscription, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	list, ok := p.subscriptions[clientID]
+	return list, ok
+}
+
+func (p *httpProvider) Close() error {
+	p.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,278 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/atomicfile"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	_ TheAuthProvider     = (*identityProvider)(nil)
+	_ ExpiringProvider    = (*identityProvider)(nil)
+	_ CertificateProvider = (*identityProvider)(nil)
+	_ CredentialManager   = (*identityProvider)(nil)
+)
+
+var ErrNoCredential = errors.New("auth/identity_provider: no such credential")
+
+// Credential is one of the credentials of an identity, a password kept as its bcrypt hash or a
+// client certificate pinned by the SHA-256 fingerprint of its DER encoding. It's only valid from
+// NotBefore until NotAfter, when they're set, so the old and the new credentials of an identity
+// are both accepted during a rotation.
+type Credential struct {
+	ID string `json:"id"`
+	// Password is hashed when the credential is added, it's never kept
+	Password    string    `json:"password,omitempty"`
+	Hash        string    `json:"hash,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	NotBefore   time.Time `json:"not_before,omitempty"`
+	NotAfter    time.Time `json:"not_after,omitempty"`
+}
+
+// Valid reports whether the credential is in its validity window.
+func (c Credential) Valid(now time.Time) bool {
+	return (c.NotBefore.IsZero() || !now.Before(c.NotBefore)) && (c.NotAfter.IsZero() || now.Before(c.NotAfter))
+}
+
+// Fingerprint returns the fingerprint of the DER encoded certificate, as pinned by a Credential.
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// identityProvider keeps the credentials of the identities, the username of a CONNECT or the
+// identity of its client certificate. The credentials are saved to a JSON file if it has one.
+type identityProvider struct {
+	mu         sync.RWMutex
+	path       string
+	identities map[string][]Credential
+	now        func() time.Time
+}
+
+func RegisterIdentityAuthProvider(path string) error {
+	p, err := NewIdentityProvider(path)
+	if err != nil {
+		return err
+	}
+	Register("identities", p)
+	return nil
+}
+
+func UnRegisterIdentityAuthProvider() {
+	Unregister("identities")
+}
+
+// NewIdentityProvider loads the credentials of the file, the file is created by the first change
+// if it doesn't exist. An empty path keeps the credentials in memory only.
+func NewIdentityProvider(path string) (*identityProvider, error) {
+	p := &identityProvider{path: path, identities: make(map[string][]Credential), now: time.Now}
+	if len(path) == 0 {
+		return p, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &p.identities); err != nil {
+		return nil, fmt.Errorf("auth/identity_provider/NewIdentityProvider: %s => %v", path, err)
+	}
+	return p, nil
+}
+
+func (p *identityProvider) Authenticate(c Credentials) (bool, error) {
+	ok, _, err := p.AuthenticateExpiry(c)
+	return ok, err
+}
+
+// AuthenticateExpiry accepts the password of any valid credential of the identity, the client is
+// asked for fresh credentials before the one it used expires.
+func (p *identityProvider) AuthenticateExpiry(c Credentials) (bool, time.Time, error) {
+	p.mu.RLock()
+	creds := p.identities[c.Username]
+	p.mu.RUnlock()
+
+	now := p.now()
+	for _, cred := range creds {
+		if len(cred.Hash) == 0 || !cred.Valid(now) {
+			continue
+		}
+		err := bcrypt.CompareHashAndPassword([]byte(cred.Hash), c.Password)
+		if err == nil {
+			return true, cred.NotAfter, nil
+		}
+		if err != bcrypt.ErrMismatchedHashAndPassword {
+			return false, time.Time{}, err
+		}
+	}
+	return false, time.Time{}, nil
+}
+
+// AuthenticateCertificate accepts the certificate if one of the valid credentials of the identity
+// pins it, or if the identity pins no certificate at all.
+func (p *identityProvider) AuthenticateCertificate(identity string, der []byte) (bool, error) {
+	p.mu.RLock()
+	creds := p.identities[identity]
+	p.mu.RUnlock()
+
+	now := p.now()
+	fingerprint := Fingerprint(der)
+	pinned := false
+	for _, cred := range creds {
+		if len(cred.Fingerprint) == 0 {
+			continue
+		}
+		pinned = true
+		if cred.Valid(now) && strings.EqualFold(cred.Fingerprint, fingerprint) {
+			return true, nil
+		}
+	}
+	return !pinned, nil
+}
+
+// AddCredential adds the credential to the identity, or replaces its credential of the same ID.
+func (p *identityProvider) AddCredential(identity string, c Credential) error {
+	if len(identity) == 0 || len(c.ID) == 0 {
+		return errors.New("auth/identity_provider/AddCredential: the identity and the credential ID are needed")
+	}
+	if len(c.Password) > 0 {
+		hash, err := bcrypt.GenerateFromPassword([]byte(c.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		c.Password, c.Hash = "", string(hash)
+	}
+	if (len(c.Hash) == 0) == (len(c.Fingerprint) == 0) {
+		return errors.New("auth/identity_provider/AddCredential: a credential is either a password or a certificate fingerprint")
+	}
+	if len(c.Hash) > 0 {
+		if _, err := bcrypt.Cost([]byte(c.Hash)); err != nil {
+			return fmt.Errorf("auth/identity_provider/AddCredential: invalid hash => %v", err)
+		}
+	}
+	if !c.NotBefore.IsZero() && !c.NotAfter.IsZero() && !c.NotAfter.After(c.NotBefore) {
+		return errors.New("auth/identity_provider/AddCredential: the credential expires before it's valid")
+	}
+
+	return p.update(identity, func(creds []Credential) ([]Credential, error) {
+		for i := range creds {
+			if creds[i].ID == c.ID {
+				creds[i] = c
+				return creds, nil
+			}
+		}
+		return append(creds, c), nil
+	})
+}
+
+// ExpireCredential sets when the credential of the identity expires, such as the end of the
+// rotation window of the credential replaced.
+func (p *identityProvider) ExpireCredential(identity string, id string, notAfter time.Time) error {
+	return p.update(identity, func(creds []Credential) ([]Credential, error) {
+		for i := range creds {
+			if creds[i].ID == id {
+				creds[i].NotAfter = notAfter
+				return creds, nil
+			}
+		}
+		return nil, ErrNoCredential
+	})
+}
+
+// RemoveCredential removes the credential of the identity, the identity is gone with its last
+// credential.
+func (p *identityProvider) RemoveCredential(identity string, id string) error {
+	return p.update(identity, func(creds []Credential) ([]Credential, error) {
+		for i := range creds {
+			if creds[i].ID == id {
+				return append(creds[:i:i], creds[i+1:]...), nil
+			}
+		}
+		return nil, ErrNoCredential
+	})
+}
+
+// Credentials returns the credentials of the identity without the password hashes.
+func (p *identityProvider) Credentials(identity string) []Credential {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	creds := make([]Credential, 0, len(p.identities[identity]))
+	for _, c := range p.identities[identity] {
+		c.Hash = ""
+		creds = append(creds, c)
+	}
+	return creds
+}
+
+// Identities returns the identities with credentials, in order.
+func (p *identityProvider) Identities() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	ids := make([]string, 0, len(p.identities))
+	for id := range p.identities {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// update applies the change to a copy of the credentials of the identity and saves them, the
+// credentials are kept as they were if the change or the save fails.
+func (p *identityProvider) update(identity string, change func([]Credential) ([]Credential, error)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	old := p.identities[identity]
+	creds, err := change(append([]Credential(nil), old...))
+	if err != nil {
+		return err
+	}
+	if len(creds) == 0 {
+		delete(p.identities, identity)
+	} else {
+		p.identities[identity] = creds
+	}
+	if err := p.save(); err != nil {
+		if len(old) == 0 {
+			delete(p.identities, identity)
+		} else {
+			p.identities[identity] = old
+		}
+		return err
+	}
+	return nil
+}
+
+// save writes the credentials to a temporary file renamed over the file, so a crash never leaves
+// it half written.
+func (p *identityProvider) save() error {
+	if len(p.path) == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(p.identities, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(p.path, data); err != nil {
+		return fmt.Errorf("auth/identity_provider/save: %s => %v", p.path, err)
+	}
+	return nil
+}
+
+func (p *identityProvider) Close() error {
+	return nil
+}
@@ -0,0 +1,79 @@
+// Package autosub holds the subscriptions the broker makes for the clients on CONNECT, so a device
+// whose firmware sends no SUBSCRIBE still gets its commands. The filters are templates: %c and %u
+// are replaced by the client id and the user name, such as devices/%c/cmd for the commands of each
+// device.
+package autosub
+
+import (
+	"fmt"
+	"strings"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+)
+
+// Subscription is the template of a subscription made on CONNECT.
+type Subscription struct {
+	Filter string `json:"filter" yaml:"filter"`
+	Qos    byte   `json:"qos" yaml:"qos"`
+}
+
+// Validate checks the filter, with its placeholders replaced, and the QoS.
+func (s Subscription) Validate() error {
+	if s.Qos > 2 {
+		return fmt.Errorf("autosub/autosub/Validate: invalid QoS %d for %s", s.Qos, s.Filter)
+	}
+	filter := strings.NewReplacer("%c", "c", "%u", "u").Replace(s.Filter)
+	if err := topics.ValidateTopicFilter([]byte(filter)); err != nil {
+		return fmt.Errorf("autosub/autosub/Validate: invalid filter %s => %v", s.Filter, err)
+	}
+	return nil
+}
+
+// Validate checks the subscriptions of the list.
+func Validate(list []Subscription) error {
+	for _, s := range list {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Expand returns the subscriptions of the client, with the placeholders replaced. A template is
+// skipped if the value of its placeholder is empty or holds a wildcard or a level separator, like
+// the ACL rules; the duplicate filters are subscribed once, at the highest QoS.
+func Expand(list []Subscription, clientID string, username string) []Subscription {
+	var expanded []Subscription
+	index := make(map[string]int, len(list))
+	for _, s := range list {
+		filter, ok := substitute(s.Filter, clientID, username)
+		if !ok {
+			continue
+		}
+		if i, dup := index[filter]; dup {
+			if s.Qos > expanded[i].Qos {
+				expanded[i].Qos = s.Qos
+			}
+			continue
+		}
+		index[filter] = len(expanded)
+		expanded = append(expanded, Subscription{Filter: filter, Qos: s.Qos})
+	}
+	return expanded
+}
+
+func substitute(filter string, clientID string, username string) (string, bool) {
+	for _, v := range []struct {
+		pattern string
+		value   string
+	}{{"%c", clientID}, {"%u", username}} {
+		if !strings.Contains(filter, v.pattern) {
+			continue
+		}
+		if len(v.value) == 0 || strings.ContainsAny(v.value, "+#/") {
+			return "", false
+		}
+		filter = strings.Replace(filter, v.pattern, v.value, -1)
+	}
+	return filter, true
+}
@@ -0,0 +1,31 @@
+package autosub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Validate([]Subscription{{Filter: "devices/%c/cmd", Qos: 1}, {Filter: "$share/g/users/%u/#"}}))
+	require.Error(t, Subscription{Filter: "devices/%c/cmd", Qos: 3}.Validate())
+	require.Error(t, Subscription{Filter: "devices/%c#"}.Validate())
+	require.Error(t, Subscription{Filter: ""}.Validate())
+}
+
+func TestExpand(t *testing.T) {
+	list := []Subscription{
+		{Filter: "devices/%c/cmd", Qos: 1},
+		{Filter: "users/%u/notify"},
+		{Filter: "broadcast"},
+		{Filter: "broadcast", Qos: 2},
+	}
+	require.Equal(t, []Subscription{
+		{Filter: "devices/d1/cmd", Qos: 1},
+		{Filter: "users/alice/notify"},
+		{Filter: "broadcast", Qos: 2},
+	}, Expand(list, "d1", "alice"))
+
+	// the templates whose value is missing or would widen the filter are skipped
+	require.Equal(t, []Subscription{{Filter: "broadcast", Qos: 2}}, Expand(list, "d/+", ""))
+}
@@ -0,0 +1,280 @@
+// Package backup archives the persistent stores of the broker and restores them. An archive is a
+// gzipped tar holding a manifest, then a file for each store, the manifest records the size and
+// the SHA-256 of each store so a damaged or truncated archive is never restored.
+//
+// The snapshots of all the stores are taken first, then written out, so the archive holds the
+// stores as they were at the same point even though the broker keeps running: a store snapshot
+// is cheap to take (a read transaction or a copy of its state) and writing it doesn't block the
+// store.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	ManifestName = "manifest.json"
+
+	// The version of the archive format
+	FormatVersion = 1
+)
+
+// Snapshot is a consistent copy of a store, it's written once then closed.
+type Snapshot interface {
+	WriteTo(w io.Writer) (int64, error)
+	Close() error
+}
+
+// Snapshotter is implemented by the stores which can be backed up while they're in use.
+type Snapshotter interface {
+	Snapshot() (Snapshot, error)
+}
+
+// SnapshotterFunc adapts a function to a Snapshotter.
+type SnapshotterFunc func() (Snapshot, error)
+
+func (f SnapshotterFunc) Snapshot() (Snapshot, error) {
+	return f()
+}
+
+// Bytes is the snapshot of a store small enough to be copied in memory.
+type Bytes []byte
+
+func (b Bytes) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+func (b Bytes) Close() error {
+	return nil
+}
+
+type Store struct {
+	Name        string
+	Snapshotter Snapshotter
+}
+
+type StoreInfo struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type Manifest struct {
+	Version int         `json:"version"`
+	Node    string      `json:"node"`
+	Created time.Time   `json:"created"`
+	Stores  []StoreInfo `json:"stores"`
+}
+
+func (m *Manifest) store(name string) (StoreInfo, bool) {
+	for _, s := range m.Stores {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return StoreInfo{}, false
+}
+
+// Write takes the snapshots of the stores then writes them to the archive, the snapshots are
+// spooled to temporary files to learn their size and checksum before the manifest is written.
+func Write(w io.Writer, node string, now time.Time, stores []Store) (*Manifest, error) {
+	snapshots := make([]Snapshot, 0, len(stores))
+	defer func() {
+		for _, s := range snapshots {
+			_ = s.Close()
+		}
+	}()
+	for _, s := range stores {
+		snapshot, err := s.Snapshotter.Snapshot()
+		if err != nil {
+			return nil, fmt.Errorf("backup/backup/Write: snapshot of the %s store => %v", s.Name, err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	m := &Manifest{Version: FormatVersion, Node: node, Created: now.UTC(), Stores: make([]StoreInfo, 0, len(stores))}
+	spools := make([]*os.File, 0, len(stores))
+	defer func() {
+		for _, f := range spools {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+	for i, s := range stores {
+		f, err := ioutil.TempFile("", "backup-"+s.Name)
+		if err != nil {
+			return nil, err
+		}
+		spools = append(spools, f)
+
+		h := sha256.New()
+		size, err := snapshots[i].WriteTo(io.MultiWriter(f, h))
+		if err != nil {
+			return nil, fmt.Errorf("backup/backup/Write: write the %s store => %v", s.Name, err)
+		}
+		m.Stores = append(m.Stores, StoreInfo{Name: s.Name, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))})
+	}
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	if err := writeEntry(tw, ManifestName, int64(len(manifest)), now, bytes.NewReader(manifest)); err != nil {
+		return nil, err
+	}
+	for i, f := range spools {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if err := writeEntry(tw, m.Stores[i].Name, m.Stores[i].Size, now, f); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func writeEntry(tw *tar.Writer, name string, size int64, now time.Time, r io.Reader) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: size, ModTime: now}); err != nil {
+		return err
+	}
+	_, err := io.CopyN(tw, r, size)
+	return err
+}
+
+// Verify reads the whole archive and checks each store against the manifest.
+func Verify(r io.Reader) (*Manifest, error) {
+	return read(r, func(StoreInfo) (io.Writer, error) {
+		return ioutil.Discard, nil
+	})
+}
+
+// Restore writes the stores of the archive to the files of the targets, keyed by store name. The
+// stores are written to temporary files next to the targets and all checked before any target is
+// replaced, a store of the archive without a target is skipped. The broker must be stopped.
+func Restore(r io.Reader, targets map[string]string) (*Manifest, error) {
+	tmps := make(map[string]*os.File)
+	defer func() {
+		for _, f := range tmps {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	m, err := read(r, func(s StoreInfo) (io.Writer, error) {
+		path, ok := targets[s.Name]
+		if !ok {
+			return ioutil.Discard, nil
+		}
+		f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".restore")
+		if err != nil {
+			return nil, err
+		}
+		tmps[s.Name] = f
+		return f, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range targets {
+		if _, ok := m.store(name); !ok {
+			return nil, fmt.Errorf("backup/backup/Restore: the archive has no %s store", name)
+		}
+	}
+
+	for name, f := range tmps {
+		if err := f.Sync(); err != nil {
+			return nil, err
+		}
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(f.Name(), targets[name]); err != nil {
+			return nil, err
+		}
+		delete(tmps, name)
+	}
+	return m, nil
+}
+
+// read checks the manifest then copies each store to the writer open returns for it.
+func read(r io.Reader, open func(StoreInfo) (io.Writer, error)) (*Manifest, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gr)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Name != ManifestName {
+		return nil, fmt.Errorf("backup/backup/read: the archive starts with %q, not the manifest", hdr.Name)
+	}
+	var m Manifest
+	if err := json.NewDecoder(tr).Decode(&m); err != nil {
+		return nil, fmt.Errorf("backup/backup/read: invalid manifest => %v", err)
+	}
+	if m.Version != FormatVersion {
+		return nil, fmt.Errorf("backup/backup/read: unknown archive version %d", m.Version)
+	}
+
+	seen := make(map[string]bool, len(m.Stores))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		s, ok := m.store(hdr.Name)
+		if !ok || seen[hdr.Name] {
+			return nil, fmt.Errorf("backup/backup/read: unexpected entry %q", hdr.Name)
+		}
+		seen[hdr.Name] = true
+
+		w, err := open(s)
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		size, err := io.Copy(io.MultiWriter(w, h), tr)
+		if err != nil {
+			return nil, err
+		}
+		if size != s.Size || hex.EncodeToString(h.Sum(nil)) != s.SHA256 {
+			return nil, fmt.Errorf("backup/backup/read: the %s store doesn't match the manifest", s.Name)
+		}
+	}
+
+	for _, s := range m.Stores {
+		if !seen[s.Name] {
+			return nil, fmt.Errorf("backup/backup/read: the %s store is missing", s.Name)
+		}
+	}
+	return &m, nil
+}
@@ -0,0 +1,94 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type bytesStore []byte
+
+func (s bytesStore) Snapshot() (Snapshot, error) {
+	return Bytes(s), nil
+}
+
+func TestWriteRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Unix(1584662400, 0)
+	var archive bytes.Buffer
+	m, err := Write(&archive, "node1", now, []Store{
+		{Name: "schedule", Snapshotter: bytesStore(`[{"id":"s1"}]`)},
+		{Name: "annotations", Snapshotter: bytesStore(`{}`)},
+	})
+	require.NoError(t, err)
+	require.Len(t, m.Stores, 2)
+	require.Equal(t, int64(13), m.Stores[0].Size)
+
+	verified, err := Verify(bytes.NewReader(archive.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, "node1", verified.Node)
+	require.True(t, now.Equal(verified.Created))
+
+	// the stores without a target are skipped
+	schedulePath := filepath.Join(dir, "schedule.json")
+	require.NoError(t, ioutil.WriteFile(schedulePath, []byte("[]"), 0600))
+	_, err = Restore(bytes.NewReader(archive.Bytes()), map[string]string{"schedule": schedulePath})
+	require.NoError(t, err)
+	data, err := ioutil.ReadFile(schedulePath)
+	require.NoError(t, err)
+	require.Equal(t, `[{"id":"s1"}]`, string(data))
+
+	_, err = Restore(bytes.NewReader(archive.Bytes()), map[string]string{"packet_ids": filepath.Join(dir, "ids.json")})
+	require.Error(t, err)
+
+	// a damaged store is never restored
+	var damaged bytes.Buffer
+	_, err = Write(&damaged, "node1", now, []Store{{Name: "schedule", Snapshotter: bytesStore(`[{"id":"s2"}]`)}})
+	require.NoError(t, err)
+	corrupt := corruptStore(t, damaged.Bytes(), "schedule")
+	_, err = Restore(bytes.NewReader(corrupt), map[string]string{"schedule": schedulePath})
+	require.Error(t, err)
+	data, err = ioutil.ReadFile(schedulePath)
+	require.NoError(t, err)
+	require.Equal(t, `[{"id":"s1"}]`, string(data))
+}
+
+// corruptStore rewrites the archive with a byte of the store flipped, the manifest is kept.
+func corruptStore(t *testing.T, archive []byte, name string) []byte {
+	gr, err := gzip.NewReader(bytes.NewReader(archive))
+	require.NoError(t, err)
+	tr := tar.NewReader(gr)
+
+	var out bytes.Buffer
+	gw := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gw)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		if hdr.Name == name {
+			data[0] ^= 0xFF
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err = tw.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return out.Bytes()
+}
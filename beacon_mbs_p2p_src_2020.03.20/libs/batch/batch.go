@@ -0,0 +1,98 @@
+// Package batch coalesces the messages delivered to a subscriber into container messages, so a
+// battery-powered gateway client wakes its radio once per interval rather than once per message.
+package batch
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TopicPrefix is the prefix of the container topics, "$batch/500" is the container flushed
+// every 500ms. A client opts in by subscribing to it.
+const TopicPrefix = "$batch/"
+
+// Item is a message in a container, the payload is base64 encoded in the JSON array.
+type Item struct {
+	Topic   string `json:"topic"`
+	Qos     byte   `json:"qos,omitempty"`
+	Retain  bool   `json:"retain,omitempty"`
+	Payload []byte `json:"payload"`
+}
+
+// Topic returns the container topic of the interval.
+func Topic(interval time.Duration) string {
+	return TopicPrefix + strconv.FormatInt(int64(interval/time.Millisecond), 10)
+}
+
+// ParseTopic returns the interval of the container topic, ok is false if it's not one.
+func ParseTopic(topic string) (time.Duration, bool) {
+	if !strings.HasPrefix(topic, TopicPrefix) {
+		return 0, false
+	}
+
+	ms, err := strconv.ParseUint(topic[len(TopicPrefix):], 10, 32)
+	if err != nil || ms == 0 {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+func Encode(items []Item) ([]byte, error) {
+	return json.Marshal(items)
+}
+
+func Decode(payload []byte) ([]Item, error) {
+	var items []Item
+	err := json.Unmarshal(payload, &items)
+	return items, err
+}
+
+// Batch accumulates the items until they are taken, it's full once it reaches the maximum number
+// of items or payload bytes, so a burst is flushed early rather than growing without bound.
+type Batch struct {
+	mu       sync.Mutex
+	items    []Item
+	size     int
+	maxItems int
+	maxBytes int
+}
+
+func New(maxItems int, maxBytes int) *Batch {
+	return &Batch{
+		maxItems: maxItems,
+		maxBytes: maxBytes,
+	}
+}
+
+// Add appends the item and reports whether the batch is full.
+func (b *Batch) Add(item Item) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.items = append(b.items, item)
+	b.size += len(item.Topic) + len(item.Payload)
+
+	return len(b.items) >= b.maxItems || b.size >= b.maxBytes
+}
+
+// Take returns the accumulated items and empties the batch.
+func (b *Batch) Take() []Item {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	items := b.items
+	b.items = nil
+	b.size = 0
+
+	return items
+}
+
+func (b *Batch) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.items)
+}
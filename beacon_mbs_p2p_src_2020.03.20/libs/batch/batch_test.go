@@ -0,0 +1,49 @@
+package batch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopic(t *testing.T) {
+	require.Equal(t, "$batch/500", Topic(500*time.Millisecond))
+
+	d, ok := ParseTopic("$batch/500")
+	require.True(t, ok)
+	require.Equal(t, 500*time.Millisecond, d)
+
+	for _, topic := range []string{"batch/500", "$batch/", "$batch/0", "$batch/-1", "$batch/5s", "$batch/500/x"} {
+		_, ok := ParseTopic(topic)
+		require.False(t, ok, topic)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	b := New(3, 20)
+
+	require.False(t, b.Add(Item{Topic: "a/b", Payload: []byte("1")}))
+	require.False(t, b.Add(Item{Topic: "a/c", Payload: []byte("2"), Qos: 1}))
+	require.Equal(t, 2, b.Len())
+
+	items := b.Take()
+	require.Len(t, items, 2)
+	require.Equal(t, 0, b.Len())
+	require.Nil(t, b.Take())
+
+	// Full by bytes
+	require.True(t, b.Add(Item{Topic: "a/b", Payload: make([]byte, 20)}))
+	b.Take()
+
+	// Full by items
+	require.False(t, b.Add(Item{Topic: "a"}))
+	require.False(t, b.Add(Item{Topic: "a"}))
+	require.True(t, b.Add(Item{Topic: "a"}))
+
+	payload, err := Encode(items)
+	require.NoError(t, err)
+	decoded, err := Decode(payload)
+	require.NoError(t, err)
+	require.Equal(t, items, decoded)
+}
@@ -0,0 +1,203 @@
+// Package bridge holds the configuration of the bridges to external brokers, in the way of the
+// mosquitto bridges: each rule forwards the topics of a pattern in a direction, the local prefix
+// of the topics replaced by the remote one on the way out and back on the way in.
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+)
+
+const (
+	// In forwards the remote messages to the local subscribers
+	In = "in"
+	// Out forwards the local messages to the remote broker
+	Out = "out"
+	// Both forwards them both ways
+	Both = "both"
+
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = 2 * time.Minute
+	defaultEchoWindow = 5 * time.Second
+)
+
+// Rule forwards the topics of the pattern: the local topic LocalPrefix+topic is the remote topic
+// RemotePrefix+topic. The prefixes end with a '/' unless they're empty.
+type Rule struct {
+	Pattern      string `json:"pattern" yaml:"pattern"`
+	Direction    string `json:"direction" yaml:"direction"`
+	Qos          byte   `json:"qos" yaml:"qos"`
+	LocalPrefix  string `json:"local_prefix" yaml:"local_prefix"`
+	RemotePrefix string `json:"remote_prefix" yaml:"remote_prefix"`
+}
+
+func (r *Rule) Inbound() bool {
+	return r.Direction == In || r.Direction == Both
+}
+
+func (r *Rule) Outbound() bool {
+	return r.Direction == Out || r.Direction == Both
+}
+
+func (r *Rule) LocalFilter() string {
+	return r.LocalPrefix + r.Pattern
+}
+
+func (r *Rule) RemoteFilter() string {
+	return r.RemotePrefix + r.Pattern
+}
+
+// ToRemote maps the local topic to its remote topic, false if the rule doesn't forward it.
+func (r *Rule) ToRemote(topic string) (string, bool) {
+	return remap(topic, r.LocalPrefix, r.RemotePrefix, r.Pattern)
+}
+
+// ToLocal maps the remote topic to its local topic, false if the rule doesn't forward it.
+func (r *Rule) ToLocal(topic string) (string, bool) {
+	return remap(topic, r.RemotePrefix, r.LocalPrefix, r.Pattern)
+}
+
+func remap(topic string, from string, to string, pattern string) (string, bool) {
+	if !strings.HasPrefix(topic, from) {
+		return "", false
+	}
+	rest := topic[len(from):]
+	if ok, err := topics.MatchTopic([]byte(pattern), []byte(rest)); err != nil || !ok {
+		return "", false
+	}
+	return to + rest, true
+}
+
+type Config struct {
+	// Name identifies the bridge in the logs and the stats
+	Name string `json:"name" yaml:"name"`
+	// Addrs are the addresses of the remote broker, such as tcp://cloud:1883 or ssl://cloud:8883
+	Addrs    []string `json:"addrs" yaml:"addrs"`
+	ClientID string   `json:"client_id" yaml:"client_id"`
+	Username string   `json:"username" yaml:"username"`
+	Password string   `json:"password" yaml:"password"`
+	// CleanSession drops the remote subscriptions and the queued messages while disconnected
+	CleanSession bool          `json:"clean_session" yaml:"clean_session"`
+	KeepAlive    time.Duration `json:"keep_alive" yaml:"keep_alive"`
+
+	// The backoff of the connection attempts doubles from the min to the max, 1 second and 2
+	// minutes by default
+	MinBackoff time.Duration `json:"min_backoff" yaml:"min_backoff"`
+	MaxBackoff time.Duration `json:"max_backoff" yaml:"max_backoff"`
+
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+func (c *Config) Validate() error {
+	if len(c.Name) == 0 {
+		return errors.New("bridge/bridge/Validate: the bridge has no name")
+	}
+	if len(c.Addrs) == 0 {
+		return fmt.Errorf("bridge/bridge/Validate: the bridge %s has no remote address", c.Name)
+	}
+	if len(c.ClientID) == 0 {
+		return fmt.Errorf("bridge/bridge/Validate: the bridge %s has no client id", c.Name)
+	}
+	if len(c.Rules) == 0 {
+		return fmt.Errorf("bridge/bridge/Validate: the bridge %s has no rule", c.Name)
+	}
+	if c.MinBackoff < 0 || c.MaxBackoff < 0 || c.MaxBackoff > 0 && c.MinBackoff > c.MaxBackoff {
+		return fmt.Errorf("bridge/bridge/Validate: invalid backoff of the bridge %s", c.Name)
+	}
+
+	for i, r := range c.Rules {
+		switch r.Direction {
+		case In, Out, Both:
+		default:
+			return fmt.Errorf("bridge/bridge/Validate: rule %d of the bridge %s: unknown direction %q", i, c.Name, r.Direction)
+		}
+		if !topics.ValidQos(r.Qos) {
+			return fmt.Errorf("bridge/bridge/Validate: rule %d of the bridge %s: invalid QoS %d", i, c.Name, r.Qos)
+		}
+		if err := topics.ValidateTopicFilter([]byte(r.LocalFilter())); err != nil {
+			return fmt.Errorf("bridge/bridge/Validate: rule %d of the bridge %s => %v", i, c.Name, err)
+		}
+		if err := topics.ValidateTopicFilter([]byte(r.RemoteFilter())); err != nil {
+			return fmt.Errorf("bridge/bridge/Validate: rule %d of the bridge %s => %v", i, c.Name, err)
+		}
+		for _, prefix := range []string{r.LocalPrefix, r.RemotePrefix} {
+			if strings.ContainsAny(prefix, "+#") || len(prefix) > 0 && !strings.HasSuffix(prefix, topics.SEP) {
+				return fmt.Errorf("bridge/bridge/Validate: rule %d of the bridge %s: invalid prefix %q", i, c.Name, prefix)
+			}
+		}
+	}
+	return nil
+}
+
+// Backoff returns the delay before the connection attempt after the failed ones.
+func (c *Config) Backoff(failures int) time.Duration {
+	min, max := c.MinBackoff, c.MaxBackoff
+	if min <= 0 {
+		min = defaultMinBackoff
+	}
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+
+	d := min
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// EchoGuard drops the messages coming back from the other side of a bridge: a message forwarded
+// both ways would loop between the brokers otherwise, since MQTT 3.1.1 delivers its own publishes
+// to a subscriber. A message is an echo if the same topic and payload were forwarded the other way
+// within the window.
+type EchoGuard struct {
+	mu     sync.Mutex
+	window time.Duration
+	sent   map[string]time.Time
+}
+
+func NewEchoGuard(window time.Duration) *EchoGuard {
+	if window <= 0 {
+		window = defaultEchoWindow
+	}
+	return &EchoGuard{window: window, sent: make(map[string]time.Time)}
+}
+
+// Forwarded records the message forwarded to the other side, by its topic on the other side.
+func (g *EchoGuard) Forwarded(topic string, payload []byte, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for k, t := range g.sent {
+		if now.Sub(t) >= g.window {
+			delete(g.sent, k)
+		}
+	}
+	g.sent[echoKey(topic, payload)] = now
+}
+
+// Echo reports whether the message received is the echo of a forwarded one, the record is removed.
+func (g *EchoGuard) Echo(topic string, payload []byte, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	k := echoKey(topic, payload)
+	t, ok := g.sent[k]
+	if !ok {
+		return false
+	}
+	delete(g.sent, k)
+	return now.Sub(t) < g.window
+}
+
+func echoKey(topic string, payload []byte) string {
+	return topic + "\x00" + string(payload)
+}
@@ -0,0 +1,64 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleRemap(t *testing.T) {
+	r := Rule{Pattern: "sensors/#", Direction: Both, LocalPrefix: "edge/", RemotePrefix: "site/edge1/"}
+	require.Equal(t, "edge/sensors/#", r.LocalFilter())
+	require.Equal(t, "site/edge1/sensors/#", r.RemoteFilter())
+
+	topic, ok := r.ToRemote("edge/sensors/t1")
+	require.True(t, ok)
+	require.Equal(t, "site/edge1/sensors/t1", topic)
+	topic, ok = r.ToLocal("site/edge1/sensors/t1")
+	require.True(t, ok)
+	require.Equal(t, "edge/sensors/t1", topic)
+
+	_, ok = r.ToRemote("edge/actuators/a1")
+	require.False(t, ok)
+	_, ok = r.ToLocal("edge/sensors/t1")
+	require.False(t, ok)
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := Config{
+		Name:     "cloud",
+		Addrs:    []string{"tcp://cloud:1883"},
+		ClientID: "edge1",
+		Rules:    []Rule{{Pattern: "sensors/#", Direction: Out, Qos: 1}},
+	}
+	require.NoError(t, cfg.Validate())
+
+	cfg.Rules[0].Direction = "up"
+	require.Error(t, cfg.Validate())
+	cfg.Rules[0].Direction = In
+	cfg.Rules[0].LocalPrefix = "edge"
+	require.Error(t, cfg.Validate())
+	cfg.Rules[0].LocalPrefix = "edge/+/"
+	require.Error(t, cfg.Validate())
+	cfg.Rules[0].LocalPrefix = "edge/"
+	cfg.Rules[0].Qos = 3
+	require.Error(t, cfg.Validate())
+
+	require.Equal(t, time.Second, cfg.Backoff(1))
+	require.Equal(t, 8*time.Second, cfg.Backoff(4))
+	require.Equal(t, 2*time.Minute, cfg.Backoff(100))
+}
+
+func TestEchoGuard(t *testing.T) {
+	g := NewEchoGuard(time.Second)
+	now := time.Unix(1584700000, 0)
+
+	g.Forwarded("a/b", []byte("1"), now)
+	require.False(t, g.Echo("a/b", []byte("2"), now))
+	require.True(t, g.Echo("a/b", []byte("1"), now.Add(time.Millisecond)))
+	require.False(t, g.Echo("a/b", []byte("1"), now.Add(time.Millisecond)))
+
+	g.Forwarded("a/b", []byte("1"), now)
+	require.False(t, g.Echo("a/b", []byte("1"), now.Add(time.Second)))
+}
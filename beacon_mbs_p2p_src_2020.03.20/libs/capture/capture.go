@@ -0,0 +1,235 @@
+// Package capture records the MQTT packets of the clients for protocol-level debugging, in a pcap
+// file Wireshark decodes (the packets are framed in synthesized IPv4 and TCP headers) or in JSON
+// lines. A capture stops by itself at its size, packet or time limit.
+package capture
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+)
+
+const (
+	FormatPcap = "pcap"
+	FormatJSON = "json"
+
+	defaultMaxBytes = 64 << 20
+)
+
+var ErrCaptureDone = errors.New("capture: the capture is done")
+
+type Config struct {
+	// Path of the capture file, it's created
+	Path string `json:"path"`
+	// Format is pcap (by default) or json
+	Format string `json:"format"`
+
+	// ClientID captures the packets of this client only if it's set
+	ClientID string `json:"client_id"`
+	// Filter captures the PUBLISH packets matching the topic filter only if it's set, the other
+	// packets are skipped
+	Filter string `json:"filter"`
+
+	// MaxBytes bounds the bytes of the captured packets, 64 MiB if it's 0
+	MaxBytes int64 `json:"max_bytes"`
+	// MaxPackets bounds the captured packets, 0 is unlimited
+	MaxPackets int `json:"max_packets"`
+	// Duration stops the capture once elapsed, 0 is unlimited
+	Duration time.Duration `json:"duration"`
+
+	// Redact replaces the payloads with as many 'x', the lengths are kept
+	Redact bool `json:"redact"`
+}
+
+func (c *Config) Validate() error {
+	if len(c.Path) == 0 {
+		return errors.New("capture/capture/Validate: missing capture path")
+	}
+	switch c.Format {
+	case "", FormatPcap, FormatJSON:
+	default:
+		return fmt.Errorf("capture/capture/Validate: unknown capture format %q", c.Format)
+	}
+	if len(c.Filter) > 0 {
+		if _, err := topics.MatchTopic([]byte(c.Filter), []byte("capture")); err != nil {
+			return fmt.Errorf("capture/capture/Validate: invalid filter %q => %v", c.Filter, err)
+		}
+	}
+	if c.MaxBytes < 0 || c.MaxPackets < 0 || c.Duration < 0 {
+		return errors.New("capture/capture/Validate: the limits cannot be negative")
+	}
+	return nil
+}
+
+// Record is a packet of a client, Data is the packet as encoded on the connection.
+type Record struct {
+	Time     time.Time
+	ClientID string
+	Inbound  bool
+	Client   net.Addr
+	Broker   net.Addr
+
+	Type  string
+	Topic string
+	Qos   byte
+	Data  []byte
+	// Payload is the payload of a PUBLISH, redacted if the capture redacts them
+	Payload []byte
+}
+
+// Summary describes a capture.
+type Summary struct {
+	Config  Config    `json:"config"`
+	Started time.Time `json:"started"`
+	Stopped time.Time `json:"stopped,omitempty"`
+	Packets int       `json:"packets"`
+	Bytes   int64     `json:"bytes"`
+	Done    bool      `json:"done"`
+}
+
+type recordWriter interface {
+	write(r *Record) error
+}
+
+// Session is a running capture.
+type Session struct {
+	mu      sync.Mutex
+	cfg     Config
+	file    *os.File
+	buf     *bufio.Writer
+	w       recordWriter
+	summary Summary
+}
+
+// Start creates the capture file and starts the capture.
+func Start(cfg Config, now time.Time) (*Session, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if len(cfg.Format) == 0 {
+		cfg.Format = FormatPcap
+	}
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = defaultMaxBytes
+	}
+
+	f, err := os.OpenFile(cfg.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("capture/capture/Start: create capture file error => %v", err)
+	}
+
+	s := &Session{
+		cfg:     cfg,
+		file:    f,
+		buf:     bufio.NewWriter(f),
+		summary: Summary{Config: cfg, Started: now},
+	}
+	if cfg.Format == FormatJSON {
+		s.w = newJSONWriter(s.buf)
+	} else {
+		pw, err := newPcapWriter(s.buf)
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		s.w = pw
+	}
+	return s, nil
+}
+
+func (s *Session) Config() Config {
+	return s.cfg
+}
+
+// Match reports whether the packet of the client is captured, topic is the topic of a PUBLISH.
+func (s *Session) Match(clientID string, publish bool, topic string) bool {
+	if len(s.cfg.ClientID) > 0 && s.cfg.ClientID != clientID {
+		return false
+	}
+	if len(s.cfg.Filter) == 0 {
+		return true
+	}
+	if !publish {
+		return false
+	}
+	ok, _ := topics.MatchTopic([]byte(s.cfg.Filter), []byte(topic))
+	return ok
+}
+
+// Redact returns the payload as captured.
+func (s *Session) Redact(payload []byte) []byte {
+	if !s.cfg.Redact || len(payload) == 0 {
+		return payload
+	}
+	redacted := make([]byte, len(payload))
+	for i := range redacted {
+		redacted[i] = 'x'
+	}
+	return redacted
+}
+
+// Add writes the record, it returns ErrCaptureDone once a limit is reached: the capture is closed.
+func (s *Session) Add(r *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.summary.Done {
+		return ErrCaptureDone
+	}
+	if s.cfg.Duration > 0 && r.Time.Sub(s.summary.Started) >= s.cfg.Duration {
+		s.close(r.Time)
+		return ErrCaptureDone
+	}
+	if s.summary.Bytes+int64(len(r.Data)) > s.cfg.MaxBytes {
+		s.close(r.Time)
+		return ErrCaptureDone
+	}
+
+	if err := s.w.write(r); err != nil {
+		s.close(r.Time)
+		return err
+	}
+	s.summary.Packets++
+	s.summary.Bytes += int64(len(r.Data))
+
+	if s.cfg.MaxPackets > 0 && s.summary.Packets >= s.cfg.MaxPackets {
+		s.close(r.Time)
+		return ErrCaptureDone
+	}
+	return nil
+}
+
+// Stop closes the capture file, it may be called more than once.
+func (s *Session) Stop(now time.Time) (Summary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if !s.summary.Done {
+		err = s.close(now)
+	}
+	return s.summary, err
+}
+
+func (s *Session) Summary() Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.summary
+}
+
+func (s *Session) close(now time.Time) error {
+	s.summary.Done = true
+	s.summary.Stopped = now
+
+	err := s.buf.Flush()
+	if errC := s.file.Close(); err == nil {
+		err = errC
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapturePcap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Unix(1584700000, 0)
+	s, err := Start(Config{Path: filepath.Join(dir, "c.pcap"), ClientID: "c1", MaxPackets: 2}, now)
+	require.NoError(t, err)
+	require.False(t, s.Match("c2", false, ""))
+	require.True(t, s.Match("c1", false, ""))
+
+	client := &net.TCPAddr{IP: net.IPv4(192, 168, 1, 9), Port: 40000}
+	broker := &net.TCPAddr{IP: net.IPv4(192, 168, 1, 1), Port: 1883}
+	r := &Record{Time: now, ClientID: "c1", Inbound: true, Client: client, Broker: broker, Data: []byte{0xc0, 0x00}}
+	require.NoError(t, s.Add(r))
+	require.Equal(t, ErrCaptureDone, s.Add(r))
+	require.Equal(t, ErrCaptureDone, s.Add(r))
+
+	summary, err := s.Stop(now)
+	require.NoError(t, err)
+	require.True(t, summary.Done)
+	require.Equal(t, 2, summary.Packets)
+	require.Equal(t, int64(4), summary.Bytes)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "c.pcap"))
+	require.NoError(t, err)
+	require.Len(t, data, 24+2*(16+40+2))
+	require.Equal(t, uint32(pcapMagic), binary.LittleEndian.Uint32(data))
+	require.Equal(t, uint32(linktypeRaw), binary.LittleEndian.Uint32(data[20:]))
+
+	// the second packet follows the first one in the stream
+	second := data[24+16+40+2+16:]
+	require.Equal(t, net.IPv4(192, 168, 1, 9).To4(), net.IP(second[12:16]))
+	require.Equal(t, uint16(1883), binary.BigEndian.Uint16(second[22:]))
+	require.Equal(t, uint32(2), binary.BigEndian.Uint32(second[24:]))
+	require.Equal(t, []byte{0xc0, 0x00}, second[40:])
+}
+
+func TestCaptureJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.Error(t, (&Config{Path: "p", Format: "xml"}).Validate())
+
+	now := time.Unix(1584700000, 0)
+	path := filepath.Join(dir, "c.json")
+	s, err := Start(Config{Path: path, Format: FormatJSON, Filter: "a/+", Redact: true, Duration: time.Minute}, now)
+	require.NoError(t, err)
+	require.False(t, s.Match("c1", false, ""))
+	require.False(t, s.Match("c1", true, "b/c"))
+	require.True(t, s.Match("c1", true, "a/c"))
+
+	payload := s.Redact([]byte("secret"))
+	require.Equal(t, []byte("xxxxxx"), payload)
+	require.NoError(t, s.Add(&Record{Time: now, ClientID: "c1", Type: "PublishPacket", Topic: "a/c", Payload: payload, Data: []byte{0x30}}))
+	require.Equal(t, ErrCaptureDone, s.Add(&Record{Time: now.Add(time.Minute), ClientID: "c1"}))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	var records []jsonRecord
+	for scanner.Scan() {
+		var r jsonRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		records = append(records, r)
+	}
+	require.Len(t, records, 1)
+	require.Equal(t, "out", records[0].Direction)
+	require.Equal(t, "a/c", records[0].Topic)
+	require.Equal(t, []byte("xxxxxx"), records[0].Payload)
+}
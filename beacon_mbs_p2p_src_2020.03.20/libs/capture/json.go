@@ -0,0 +1,46 @@
+package capture
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// jsonRecord is a line of the JSON capture, the packet is base64 encoded.
+type jsonRecord struct {
+	Time      time.Time `json:"time"`
+	ClientID  string    `json:"client_id"`
+	Direction string    `json:"direction"`
+	Type      string    `json:"type"`
+	Topic     string    `json:"topic,omitempty"`
+	Qos       byte      `json:"qos"`
+	Size      int       `json:"size"`
+	Payload   []byte    `json:"payload,omitempty"`
+	Packet    []byte    `json:"packet"`
+}
+
+type jsonWriter struct {
+	enc *json.Encoder
+}
+
+func newJSONWriter(w io.Writer) *jsonWriter {
+	return &jsonWriter{enc: json.NewEncoder(w)}
+}
+
+func (j *jsonWriter) write(r *Record) error {
+	direction := "out"
+	if r.Inbound {
+		direction = "in"
+	}
+	return j.enc.Encode(&jsonRecord{
+		Time:      r.Time,
+		ClientID:  r.ClientID,
+		Direction: direction,
+		Type:      r.Type,
+		Topic:     r.Topic,
+		Qos:       r.Qos,
+		Size:      len(r.Data),
+		Payload:   r.Payload,
+		Packet:    r.Data,
+	})
+}
@@ -0,0 +1,104 @@
+package capture
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+)
+
+const (
+	pcapMagic = 0xa1b2c3d4
+	// The packets are IPv4 datagrams without a link layer
+	linktypeRaw = 101
+	snaplen     = 65535
+
+	ipHeaderLen  = 20
+	tcpHeaderLen = 20
+	// The data of a record beyond it is truncated, the length of an IPv4 datagram is 16 bits
+	maxTCPData = snaplen - ipHeaderLen - tcpHeaderLen
+)
+
+// The addresses of the records without an IPv4 address, such as the WebSocket clients
+var (
+	defaultClientIP = net.IPv4(10, 0, 0, 2)
+	defaultBrokerIP = net.IPv4(10, 0, 0, 1)
+)
+
+// pcapWriter frames each packet in an IPv4 and a TCP header, the sequence numbers of each
+// connection and direction follow the bytes sent, so the streams are reassembled. The checksums
+// are left to 0.
+type pcapWriter struct {
+	w   io.Writer
+	seq map[string]uint32
+}
+
+func newPcapWriter(w io.Writer) (*pcapWriter, error) {
+	var h [24]byte
+	binary.LittleEndian.PutUint32(h[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(h[4:], 2)
+	binary.LittleEndian.PutUint16(h[6:], 4)
+	binary.LittleEndian.PutUint32(h[16:], snaplen)
+	binary.LittleEndian.PutUint32(h[20:], linktypeRaw)
+	if _, err := w.Write(h[:]); err != nil {
+		return nil, err
+	}
+	return &pcapWriter{w: w, seq: make(map[string]uint32)}, nil
+}
+
+func endpoint(addr net.Addr, ip net.IP, port int) (net.IP, int) {
+	if a, ok := addr.(*net.TCPAddr); ok {
+		if v4 := a.IP.To4(); v4 != nil {
+			return v4, a.Port
+		}
+	}
+	return ip.To4(), port
+}
+
+func (p *pcapWriter) write(r *Record) error {
+	clientIP, clientPort := endpoint(r.Client, defaultClientIP, 50000)
+	brokerIP, brokerPort := endpoint(r.Broker, defaultBrokerIP, 1883)
+
+	srcIP, srcPort, dstIP, dstPort := clientIP, clientPort, brokerIP, brokerPort
+	if !r.Inbound {
+		srcIP, srcPort, dstIP, dstPort = brokerIP, brokerPort, clientIP, clientPort
+	}
+
+	data := r.Data
+	if len(data) > maxTCPData {
+		data = data[:maxTCPData]
+	}
+
+	stream := r.ClientID + "|" + srcIP.String() + "|" + strconv.Itoa(srcPort)
+	seq := p.seq[stream]
+	p.seq[stream] = seq + uint32(len(r.Data))
+
+	var h [16 + ipHeaderLen + tcpHeaderLen]byte
+	ts := r.Time
+	binary.LittleEndian.PutUint32(h[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(h[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(h[8:], uint32(ipHeaderLen+tcpHeaderLen+len(data)))
+	binary.LittleEndian.PutUint32(h[12:], uint32(ipHeaderLen+tcpHeaderLen+len(r.Data)))
+
+	ip := h[16:]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(ipHeaderLen+tcpHeaderLen+len(data)))
+	ip[8] = 64
+	ip[9] = 6 // TCP
+	copy(ip[12:16], srcIP)
+	copy(ip[16:20], dstIP)
+
+	tcp := ip[ipHeaderLen:]
+	binary.BigEndian.PutUint16(tcp[0:], uint16(srcPort))
+	binary.BigEndian.PutUint16(tcp[2:], uint16(dstPort))
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	tcp[12] = (tcpHeaderLen / 4) << 4
+	tcp[13] = 0x18 // PSH, ACK
+	binary.BigEndian.PutUint16(tcp[14:], 0xffff)
+
+	if _, err := p.w.Write(h[:]); err != nil {
+		return err
+	}
+	_, err := p.w.Write(data)
+	return err
+}
@@ -0,0 +1,447 @@
+// Package certmon serves the TLS certificate of a listener and watches it: the certificate files
+// are reloaded when they change, the approaching expiry is reported, and the OCSP response of the
+// certificate is fetched from its responder and stapled to the handshakes. The listener may also
+// verify the client certificates against a CA bundle, which is reloaded the same way.
+package certmon
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	defaultWarn = 30 * 24 * time.Hour
+
+	// The OCSP response is refreshed after this long if it has no next update.
+	defaultOCSPRefresh = time.Hour
+)
+
+const (
+	// ClientAuthRequire rejects the clients without a certificate issued by the client CAs
+	ClientAuthRequire = "require"
+	// ClientAuthOptional verifies the certificate of the clients which present one
+	ClientAuthOptional = "optional"
+)
+
+const (
+	AlertExpiring = "expiring"
+	AlertExpired  = "expired"
+	AlertOCSP     = "ocsp"
+)
+
+// oidTLSFeature is the TLS feature extension (RFC 7633), status_request (5) in it is must-staple.
+var oidTLSFeature = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+
+type Config struct {
+	CertFile string `json:"cert_file" yaml:"cert_file"`
+	KeyFile  string `json:"key_file" yaml:"key_file"`
+	// Warn is how long before its expiry the certificate is reported as expiring, 30 days if 0.
+	Warn time.Duration `json:"warn" yaml:"warn"`
+	// OCSP staples the OCSP response of the certificate, it's always done for a must-staple
+	// certificate.
+	OCSP bool `json:"ocsp" yaml:"ocsp"`
+	// ClientCAFile is the PEM bundle of the CAs issuing the client certificates, the clients
+	// aren't asked for a certificate if it's empty.
+	ClientCAFile string `json:"client_ca_file" yaml:"client_ca_file"`
+	// ClientAuth is ClientAuthRequire or ClientAuthOptional, ClientAuthRequire if empty.
+	ClientAuth string `json:"client_auth" yaml:"client_auth"`
+}
+
+type Status struct {
+	Subject        string    `json:"subject"`
+	Issuer         string    `json:"issuer"`
+	DNSNames       []string  `json:"dns_names"`
+	NotAfter       time.Time `json:"not_after"`
+	ExpiresIn      int64     `json:"expires_in_seconds"`
+	MustStaple     bool      `json:"must_staple"`
+	OCSPStatus     string    `json:"ocsp_status,omitempty"`
+	OCSPNextUpdate time.Time `json:"ocsp_next_update,omitempty"`
+	OCSPError      string    `json:"ocsp_error,omitempty"`
+}
+
+type Alert struct {
+	Kind    string `json:"kind"`
+	Subject string `json:"subject"`
+	Message string `json:"message"`
+}
+
+// FetchFunc returns the DER OCSP response for the certificate.
+type FetchFunc func(leaf *x509.Certificate, issuer *x509.Certificate) ([]byte, error)
+
+type Monitor struct {
+	mu    sync.RWMutex
+	cfg   Config
+	clock clock.Clock
+	fetch FetchFunc
+
+	cert    *tls.Certificate
+	leaf    *x509.Certificate
+	issuer  *x509.Certificate
+	modTime time.Time
+
+	staple     *ocsp.Response
+	stapleErr  error
+	mustStaple bool
+
+	clientCAModTime time.Time
+	clientConfig    *tls.Config
+
+	tlsConfig *tls.Config
+}
+
+// New loads the certificate, the wall clock is used if c is nil. The OCSP response is fetched
+// by the first Refresh.
+func New(cfg Config, c clock.Clock) (*Monitor, error) {
+	if len(cfg.CertFile) == 0 || len(cfg.KeyFile) == 0 {
+		return nil, errors.New("certmon/certmon/New: the certificate and key files are needed")
+	}
+	if cfg.Warn == 0 {
+		cfg.Warn = defaultWarn
+	}
+	if len(cfg.ClientCAFile) > 0 && len(cfg.ClientAuth) == 0 {
+		cfg.ClientAuth = ClientAuthRequire
+	}
+	if cfg.ClientAuth != "" && cfg.ClientAuth != ClientAuthRequire && cfg.ClientAuth != ClientAuthOptional {
+		return nil, fmt.Errorf("certmon/certmon/New: unknown client auth %q", cfg.ClientAuth)
+	}
+	if len(cfg.ClientAuth) > 0 && len(cfg.ClientCAFile) == 0 {
+		return nil, errors.New("certmon/certmon/New: the client auth needs the client CA file")
+	}
+
+	m := &Monitor{
+		cfg:   cfg,
+		clock: clock.OrReal(c),
+		fetch: FetchOCSP,
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+
+	m.tlsConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return m.Certificate(), nil
+		},
+	}
+	if len(cfg.ClientCAFile) > 0 {
+		if err := m.loadClientCAs(); err != nil {
+			return nil, err
+		}
+		// the handshakes take the client CAs of the time
+		m.tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			m.mu.RLock()
+			defer m.mu.RUnlock()
+			return m.clientConfig, nil
+		}
+	}
+	return m, nil
+}
+
+// SetFetch replaces the OCSP responder client, for the tests and the proxies.
+func (m *Monitor) SetFetch(fetch FetchFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fetch = fetch
+}
+
+// TLSConfig returns the server config serving the current certificate.
+func (m *Monitor) TLSConfig() *tls.Config {
+	return m.tlsConfig
+}
+
+// Certificate returns the current certificate, with its OCSP staple if any.
+func (m *Monitor) Certificate() *tls.Certificate {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cert
+}
+
+func (m *Monitor) load() error {
+	fi, err := os.Stat(m.cfg.CertFile)
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(m.cfg.CertFile, m.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("certmon/certmon/load: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	var issuer *x509.Certificate
+	if len(cert.Certificate) > 1 {
+		if issuer, err = x509.ParseCertificate(cert.Certificate[1]); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cert = &cert
+	m.leaf = leaf
+	m.issuer = issuer
+	m.modTime = fi.ModTime()
+	m.mustStaple = isMustStaple(leaf)
+	m.staple = nil
+	m.stapleErr = nil
+	return nil
+}
+
+func (m *Monitor) loadClientCAs() error {
+	fi, err := os.Stat(m.cfg.ClientCAFile)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(m.cfg.ClientCAFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("certmon/certmon/loadClientCAs: no certificate in %s", m.cfg.ClientCAFile)
+	}
+
+	clientAuth := tls.RequireAndVerifyClientCert
+	if m.cfg.ClientAuth == ClientAuthOptional {
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.clientConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return m.Certificate(), nil
+		},
+		ClientAuth: clientAuth,
+		ClientCAs:  pool,
+	}
+	m.clientCAModTime = fi.ModTime()
+	return nil
+}
+
+func isMustStaple(leaf *x509.Certificate) bool {
+	for _, ext := range leaf.Extensions {
+		if !ext.Id.Equal(oidTLSFeature) {
+			continue
+		}
+		var features []int
+		if _, err := asn1.Unmarshal(ext.Value, &features); err != nil {
+			return false
+		}
+		for _, f := range features {
+			if f == 5 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Refresh reloads the certificate files and the client CAs if they have changed, and fetches a new
+// OCSP response if the current one is past the half of its validity. A response which cannot be
+// fetched keeps the current one until its next update.
+func (m *Monitor) Refresh() error {
+	fi, err := os.Stat(m.cfg.CertFile)
+	if err != nil {
+		return err
+	}
+	m.mu.RLock()
+	changed := !fi.ModTime().Equal(m.modTime)
+	m.mu.RUnlock()
+	if changed {
+		if err := m.load(); err != nil {
+			return err
+		}
+	}
+
+	if len(m.cfg.ClientCAFile) > 0 {
+		fi, err := os.Stat(m.cfg.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		m.mu.RLock()
+		changed := !fi.ModTime().Equal(m.clientCAModTime)
+		m.mu.RUnlock()
+		if changed {
+			if err := m.loadClientCAs(); err != nil {
+				return err
+			}
+		}
+	}
+
+	m.mu.RLock()
+	stapling := m.cfg.OCSP || m.mustStaple
+	due := m.stapleDue(m.clock.Now())
+	leaf, issuer, fetch := m.leaf, m.issuer, m.fetch
+	m.mu.RUnlock()
+	if !stapling || !due {
+		return nil
+	}
+
+	resp, err := fetchResponse(fetch, leaf, issuer)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if leaf != m.leaf {
+		// reloaded meanwhile
+		return nil
+	}
+
+	now := m.clock.Now()
+	m.stapleErr = err
+	if err != nil {
+		if m.staple != nil && !m.staple.NextUpdate.IsZero() && now.After(m.staple.NextUpdate) {
+			m.setStaple(nil)
+		}
+		return nil
+	}
+	if resp.Status != ocsp.Good {
+		m.stapleErr = fmt.Errorf("certmon/certmon/Refresh: the OCSP status is %s", ocspStatus(resp.Status))
+		m.setStaple(nil)
+		return nil
+	}
+	m.setStaple(resp)
+	return nil
+}
+
+func (m *Monitor) stapleDue(now time.Time) bool {
+	if m.staple == nil {
+		return true
+	}
+	if m.staple.NextUpdate.IsZero() {
+		return now.Sub(m.staple.ThisUpdate) >= defaultOCSPRefresh
+	}
+	half := m.staple.NextUpdate.Sub(m.staple.ThisUpdate) / 2
+	return !now.Before(m.staple.ThisUpdate.Add(half))
+}
+
+// The certificate is copied, the handshakes in progress keep the one they got.
+func (m *Monitor) setStaple(resp *ocsp.Response) {
+	cert := *m.cert
+	cert.OCSPStaple = nil
+	if resp != nil {
+		cert.OCSPStaple = resp.Raw
+	}
+	m.cert = &cert
+	m.staple = resp
+}
+
+func fetchResponse(fetch FetchFunc, leaf *x509.Certificate, issuer *x509.Certificate) (*ocsp.Response, error) {
+	if issuer == nil {
+		return nil, errors.New("certmon/certmon/fetchResponse: no issuer certificate in the chain")
+	}
+	raw, err := fetch(leaf, issuer)
+	if err != nil {
+		return nil, err
+	}
+	return ocsp.ParseResponseForCert(raw, leaf, issuer)
+}
+
+// FetchOCSP asks the first OCSP responder of the certificate.
+func FetchOCSP(leaf *x509.Certificate, issuer *x509.Certificate) ([]byte, error) {
+	if len(leaf.OCSPServer) == 0 {
+		return nil, errors.New("certmon/certmon/FetchOCSP: the certificate has no OCSP responder")
+	}
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	resp, err := httpClient.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("certmon/certmon/FetchOCSP: the responder answered %s", resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+func ocspStatus(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
+
+func (m *Monitor) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s := Status{
+		Subject:    m.leaf.Subject.String(),
+		Issuer:     m.leaf.Issuer.String(),
+		DNSNames:   m.leaf.DNSNames,
+		NotAfter:   m.leaf.NotAfter,
+		ExpiresIn:  int64(m.leaf.NotAfter.Sub(m.clock.Now()) / time.Second),
+		MustStaple: m.mustStaple,
+	}
+	if m.staple != nil {
+		s.OCSPStatus = ocspStatus(m.staple.Status)
+		s.OCSPNextUpdate = m.staple.NextUpdate
+	}
+	if m.stapleErr != nil {
+		s.OCSPError = m.stapleErr.Error()
+	}
+	return s
+}
+
+// Alerts returns what needs to be looked at: the certificate expires within the warning period or
+// has expired, or it needs an OCSP staple which could not be fetched.
+func (m *Monitor) Alerts() []Alert {
+	s := m.Status()
+
+	var alerts []Alert
+	left := time.Duration(s.ExpiresIn) * time.Second
+	switch {
+	case left <= 0:
+		alerts = append(alerts, Alert{
+			Kind:    AlertExpired,
+			Subject: s.Subject,
+			Message: fmt.Sprintf("the certificate expired at %s", s.NotAfter.UTC().Format(time.RFC3339)),
+		})
+	case left <= m.cfg.Warn:
+		alerts = append(alerts, Alert{
+			Kind:    AlertExpiring,
+			Subject: s.Subject,
+			Message: fmt.Sprintf("the certificate expires at %s, in %s", s.NotAfter.UTC().Format(time.RFC3339), left),
+		})
+	}
+
+	if len(s.OCSPError) > 0 {
+		msg := s.OCSPError
+		if s.MustStaple && len(s.OCSPStatus) == 0 {
+			msg = "must-staple certificate served without staple: " + msg
+		}
+		alerts = append(alerts, Alert{Kind: AlertOCSP, Subject: s.Subject, Message: msg})
+	}
+	return alerts
+}
@@ -0,0 +1,260 @@
+package certmon
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func newTestCA(t *testing.T, now time.Time) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+// writeLeaf writes the certificate issued by the CA, and the chain, to the files.
+func (ca *testCA) writeLeaf(t *testing.T, dir string, now time.Time, notAfter time.Time, mustStaple bool) Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "broker.test"},
+		DNSNames:     []string{"broker.test"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+		OCSPServer:   []string{"http://ocsp.test"},
+	}
+	if mustStaple {
+		tmpl.ExtraExtensions = []pkix.Extension{{Id: oidTLSFeature, Value: []byte{0x30, 0x03, 0x02, 0x01, 0x05}}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, key.Public(), ca.key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	cfg := Config{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}
+	certPEM := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+	require.NoError(t, ioutil.WriteFile(cfg.CertFile, certPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(cfg.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return cfg
+}
+
+func (ca *testCA) responder(m *clock.Mock, status int, fetched *int) FetchFunc {
+	return func(leaf *x509.Certificate, issuer *x509.Certificate) ([]byte, error) {
+		*fetched++
+		now := m.Now()
+		return ocsp.CreateResponse(issuer, issuer, ocsp.Response{
+			Status:       status,
+			SerialNumber: leaf.SerialNumber,
+			ThisUpdate:   now,
+			NextUpdate:   now.Add(4 * time.Hour),
+			RevokedAt:    now,
+		}, ca.key)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certmon")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	start := time.Now().Truncate(time.Second)
+	mock := clock.NewMock(start)
+	ca := newTestCA(t, start)
+	cfg := ca.writeLeaf(t, dir, start, start.Add(60*24*time.Hour), false)
+
+	m, err := New(cfg, mock)
+	require.NoError(t, err)
+	require.NoError(t, m.Refresh())
+	require.Equal(t, "CN=broker.test", m.Status().Subject)
+	require.Empty(t, m.Alerts())
+	require.Nil(t, m.Certificate().OCSPStaple)
+
+	mock.Add(45 * 24 * time.Hour)
+	alerts := m.Alerts()
+	require.Len(t, alerts, 1)
+	require.Equal(t, AlertExpiring, alerts[0].Kind)
+
+	mock.Add(30 * 24 * time.Hour)
+	alerts = m.Alerts()
+	require.Len(t, alerts, 1)
+	require.Equal(t, AlertExpired, alerts[0].Kind)
+
+	// the renewed certificate is picked up
+	cfg = ca.writeLeaf(t, dir, mock.Now(), mock.Now().Add(90*24*time.Hour), false)
+	require.NoError(t, os.Chtimes(cfg.CertFile, mock.Now(), start.Add(time.Minute)))
+	require.NoError(t, m.Refresh())
+	require.Empty(t, m.Alerts())
+
+	_, err = New(Config{CertFile: cfg.CertFile}, nil)
+	require.Error(t, err)
+}
+
+func TestOCSPStaple(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certmon")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	start := time.Now().Truncate(time.Second)
+	mock := clock.NewMock(start)
+	ca := newTestCA(t, start)
+	cfg := ca.writeLeaf(t, dir, start, start.Add(90*24*time.Hour), true)
+
+	m, err := New(cfg, mock)
+	require.NoError(t, err)
+	require.True(t, m.Status().MustStaple)
+
+	fetched := 0
+	m.SetFetch(ca.responder(mock, ocsp.Good, &fetched))
+	require.NoError(t, m.Refresh())
+	require.Equal(t, 1, fetched)
+	require.NotNil(t, m.Certificate().OCSPStaple)
+	require.Equal(t, "good", m.Status().OCSPStatus)
+
+	// refreshed past the half of its validity only
+	mock.Add(time.Hour)
+	require.NoError(t, m.Refresh())
+	require.Equal(t, 1, fetched)
+	mock.Add(time.Hour)
+	require.NoError(t, m.Refresh())
+	require.Equal(t, 2, fetched)
+
+	// the staple is kept until its next update if the responder fails
+	m.SetFetch(func(*x509.Certificate, *x509.Certificate) ([]byte, error) {
+		return nil, errors.New("responder down")
+	})
+	mock.Add(3 * time.Hour)
+	require.NoError(t, m.Refresh())
+	require.NotNil(t, m.Certificate().OCSPStaple)
+	mock.Add(2 * time.Hour)
+	require.NoError(t, m.Refresh())
+	require.Nil(t, m.Certificate().OCSPStaple)
+	alerts := m.Alerts()
+	require.Len(t, alerts, 1)
+	require.Equal(t, AlertOCSP, alerts[0].Kind)
+
+	// a revoked certificate is not stapled
+	m.SetFetch(ca.responder(mock, ocsp.Revoked, &fetched))
+	require.NoError(t, m.Refresh())
+	require.Nil(t, m.Certificate().OCSPStaple)
+	require.Contains(t, m.Status().OCSPError, "revoked")
+}
+
+// clientCert returns a client certificate issued by the CA.
+func (ca *testCA) clientCert(t *testing.T, now time.Time, cn string, email string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:   big.NewInt(3),
+		Subject:        pkix.Name{CommonName: cn},
+		EmailAddresses: []string{email},
+		NotBefore:      now.Add(-time.Hour),
+		NotAfter:       now.Add(24 * time.Hour),
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, key.Public(), ca.key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// handshake returns the state of the server side, and its handshake error.
+func handshake(t *testing.T, m *Monitor, ca *testCA, certs []tls.Certificate) (tls.ConnectionState, error) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := tls.Client(clientConn, &tls.Config{RootCAs: roots, ServerName: "broker.test", Certificates: certs})
+	go func() {
+		// reads the alert of the server refusing the certificate
+		_ = client.Handshake()
+		_, _ = io.Copy(ioutil.Discard, client)
+	}()
+
+	server := tls.Server(serverConn, m.TLSConfig())
+	err := server.Handshake()
+	return server.ConnectionState(), err
+}
+
+func TestClientCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certmon")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	ca := newTestCA(t, now)
+	cfg := ca.writeLeaf(t, dir, now, now.Add(24*time.Hour), false)
+	cfg.ClientCAFile = filepath.Join(dir, "clients.pem")
+	require.NoError(t, ioutil.WriteFile(cfg.ClientCAFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0600))
+
+	m, err := New(cfg, nil)
+	require.NoError(t, err)
+
+	device := ca.clientCert(t, now, "device-1", "device-1@fleet.test")
+	state, err := handshake(t, m, ca, []tls.Certificate{device})
+	require.NoError(t, err)
+	require.NotEmpty(t, state.VerifiedChains)
+	require.Equal(t, "device-1", Identity(state.PeerCertificates[0], IdentityCN))
+	require.Equal(t, "device-1@fleet.test", Identity(state.PeerCertificates[0], IdentityEmail))
+	require.Empty(t, Identity(state.PeerCertificates[0], IdentityURI))
+
+	// required by default
+	_, err = handshake(t, m, ca, nil)
+	require.Error(t, err)
+
+	// a certificate of another CA is refused
+	other := newTestCA(t, now)
+	_, err = handshake(t, m, ca, []tls.Certificate{other.clientCert(t, now, "device-2", "device-2@fleet.test")})
+	require.Error(t, err)
+
+	cfg.ClientAuth = ClientAuthOptional
+	m, err = New(cfg, nil)
+	require.NoError(t, err)
+	state, err = handshake(t, m, ca, nil)
+	require.NoError(t, err)
+	require.Empty(t, state.PeerCertificates)
+
+	_, err = New(Config{CertFile: cfg.CertFile, KeyFile: cfg.KeyFile, ClientAuth: ClientAuthRequire}, nil)
+	require.Error(t, err)
+	require.Error(t, CheckIdentity("serial"))
+}
@@ -0,0 +1,48 @@
+package certmon
+
+import (
+	"crypto/x509"
+	"fmt"
+)
+
+// The fields of a client certificate naming the client
+const (
+	IdentityCN    = "cn"
+	IdentityDNS   = "dns"
+	IdentityEmail = "email"
+	IdentityURI   = "uri"
+)
+
+// CheckIdentity returns an error if from names no field of the certificate.
+func CheckIdentity(from string) error {
+	switch from {
+	case IdentityCN, IdentityDNS, IdentityEmail, IdentityURI:
+		return nil
+	}
+	return fmt.Errorf("certmon/identity/CheckIdentity: unknown certificate identity %q", from)
+}
+
+// Identity returns the name of the client in the field of its certificate, the first one of the
+// subject alternative names of the kind, empty if the certificate has none.
+func Identity(cert *x509.Certificate, from string) string {
+	if cert == nil {
+		return ""
+	}
+	switch from {
+	case IdentityCN:
+		return cert.Subject.CommonName
+	case IdentityDNS:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	case IdentityEmail:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case IdentityURI:
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String()
+		}
+	}
+	return ""
+}
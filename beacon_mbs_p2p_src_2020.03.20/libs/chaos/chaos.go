@@ -0,0 +1,221 @@
+// Package chaos keeps the faults injected into a broker for the resilience tests. The injection
+// points are compiled into the broker with the "chaos" build tag only, the production builds
+// never consult the injector.
+package chaos
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+)
+
+type Kind string
+
+const (
+	// DropPacket drops the publishes whose topic matches the filter.
+	DropPacket Kind = "drop_packet"
+	// DelayPacket delays the publishes whose topic matches the filter.
+	DelayPacket Kind = "delay_packet"
+	// FailStoreWrite fails the writes to the store named by the target ("retained", "sessions"),
+	// to all of them if the target is empty.
+	FailStoreWrite Kind = "fail_store_write"
+	// PartitionLink drops the traffic from and to the peer broker whose id is the target.
+	PartitionLink Kind = "partition_link"
+	// SlowSubscriber delays the writes to the client whose id is the target.
+	SlowSubscriber Kind = "slow_subscriber"
+)
+
+var (
+	ErrInjected    = errors.New("chaos: injected failure")
+	ErrUnknownKind = errors.New("chaos: unknown fault kind")
+)
+
+// Fault is applied with the probability (1 if it's 0) until it's removed or expires.
+type Fault struct {
+	ID          string        `json:"id"`
+	Kind        Kind          `json:"kind"`
+	Filter      string        `json:"filter,omitempty"`
+	Target      string        `json:"target,omitempty"`
+	Probability float64       `json:"probability,omitempty"`
+	Delay       time.Duration `json:"delay,omitempty"`
+	Expires     time.Time     `json:"expires,omitempty"`
+}
+
+func (f *Fault) check() error {
+	if len(f.ID) == 0 {
+		return errors.New("chaos/chaos/check: fault id cannot be empty")
+	}
+	if f.Probability < 0 || f.Probability > 1 {
+		return fmt.Errorf("chaos/chaos/check: probability of fault %s must be in [0-1]", f.ID)
+	}
+
+	switch f.Kind {
+	case DropPacket, DelayPacket:
+		if len(f.Filter) == 0 {
+			return fmt.Errorf("chaos/chaos/check: fault %s needs a topic filter", f.ID)
+		}
+		for rem := []byte(f.Filter); len(rem) > 0; {
+			var err error
+			if _, rem, err = topics.NextTopicLevel(rem); err != nil {
+				return err
+			}
+		}
+	case PartitionLink, SlowSubscriber:
+		if len(f.Target) == 0 {
+			return fmt.Errorf("chaos/chaos/check: fault %s needs a target", f.ID)
+		}
+	case FailStoreWrite:
+	default:
+		return ErrUnknownKind
+	}
+
+	if (f.Kind == DelayPacket || f.Kind == SlowSubscriber) && f.Delay <= 0 {
+		return fmt.Errorf("chaos/chaos/check: fault %s needs a positive delay", f.ID)
+	}
+
+	return nil
+}
+
+// Injector decides, at each injection point, whether a fault applies.
+type Injector struct {
+	mu     sync.Mutex
+	rnd    *rand.Rand
+	clock  clock.Clock
+	faults map[string]Fault
+}
+
+// NewInjector returns an injector without fault, the seed makes the probabilistic faults
+// reproducible. The wall clock is used if c is nil.
+func NewInjector(seed int64, c clock.Clock) *Injector {
+	return &Injector{
+		rnd:    rand.New(rand.NewSource(seed)),
+		clock:  clock.OrReal(c),
+		faults: make(map[string]Fault),
+	}
+}
+
+// Set adds the fault, replacing the one with the same ID.
+func (in *Injector) Set(f Fault) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	in.faults[f.ID] = f
+	return nil
+}
+
+func (in *Injector) Remove(id string) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if _, ok := in.faults[id]; !ok {
+		return fmt.Errorf("chaos/chaos/Remove: No fault found for id %s", id)
+	}
+	delete(in.faults, id)
+	return nil
+}
+
+func (in *Injector) Clear() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	in.faults = make(map[string]Fault)
+}
+
+// Faults returns the active faults sorted by ID.
+func (in *Injector) Faults() []Fault {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	now := in.clock.Now()
+	list := make([]Fault, 0, len(in.faults))
+	for _, f := range in.faults {
+		if f.Expires.IsZero() || now.Before(f.Expires) {
+			list = append(list, f)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// apply returns the first fault of the kind matching and drawn, the expired ones are removed.
+func (in *Injector) apply(kind Kind, match func(f *Fault) bool) (Fault, bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	now := in.clock.Now()
+	for id, f := range in.faults {
+		if f.Kind != kind {
+			continue
+		}
+		if !f.Expires.IsZero() && !now.Before(f.Expires) {
+			delete(in.faults, id)
+			continue
+		}
+		if !match(&f) {
+			continue
+		}
+		if f.Probability > 0 && in.rnd.Float64() >= f.Probability {
+			continue
+		}
+		return f, true
+	}
+	return Fault{}, false
+}
+
+func matchTopic(topic string) func(f *Fault) bool {
+	return func(f *Fault) bool {
+		ok, _ := topics.MatchTopic([]byte(f.Filter), []byte(topic))
+		return ok
+	}
+}
+
+func matchTarget(target string) func(f *Fault) bool {
+	return func(f *Fault) bool {
+		return f.Target == target
+	}
+}
+
+// DropPacket reports whether the publish of the topic is dropped.
+func (in *Injector) DropPacket(topic string) bool {
+	_, ok := in.apply(DropPacket, matchTopic(topic))
+	return ok
+}
+
+// PacketDelay returns how long the publish of the topic is delayed.
+func (in *Injector) PacketDelay(topic string) time.Duration {
+	f, _ := in.apply(DelayPacket, matchTopic(topic))
+	return f.Delay
+}
+
+// StoreWrite returns ErrInjected if the write to the store fails.
+func (in *Injector) StoreWrite(store string) error {
+	_, ok := in.apply(FailStoreWrite, func(f *Fault) bool {
+		return len(f.Target) == 0 || f.Target == store
+	})
+	if ok {
+		return ErrInjected
+	}
+	return nil
+}
+
+// Partitioned reports whether the link with the peer broker is cut.
+func (in *Injector) Partitioned(brokerID string) bool {
+	_, ok := in.apply(PartitionLink, matchTarget(brokerID))
+	return ok
+}
+
+// SubscriberDelay returns how long the writes to the client are delayed.
+func (in *Injector) SubscriberDelay(clientID string) time.Duration {
+	f, _ := in.apply(SlowSubscriber, matchTarget(clientID))
+	return f.Delay
+}
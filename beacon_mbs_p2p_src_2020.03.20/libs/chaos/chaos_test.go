@@ -0,0 +1,75 @@
+package chaos
+
+import (
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInjectorSet(t *testing.T) {
+	in := NewInjector(1, nil)
+
+	require.Error(t, in.Set(Fault{Kind: DropPacket, Filter: "#"}))
+	require.Error(t, in.Set(Fault{ID: "f", Kind: "unknown"}))
+	require.Error(t, in.Set(Fault{ID: "f", Kind: DropPacket}))
+	require.Error(t, in.Set(Fault{ID: "f", Kind: DropPacket, Filter: "a/#/b"}))
+	require.Error(t, in.Set(Fault{ID: "f", Kind: DropPacket, Filter: "#", Probability: 2}))
+	require.Error(t, in.Set(Fault{ID: "f", Kind: DelayPacket, Filter: "#"}))
+	require.Error(t, in.Set(Fault{ID: "f", Kind: PartitionLink}))
+	require.Error(t, in.Set(Fault{ID: "f", Kind: SlowSubscriber, Target: "c1"}))
+
+	require.NoError(t, in.Set(Fault{ID: "f", Kind: FailStoreWrite}))
+	require.NoError(t, in.Set(Fault{ID: "f", Kind: FailStoreWrite, Target: "retained"}))
+	require.Len(t, in.Faults(), 1)
+
+	require.NoError(t, in.Remove("f"))
+	require.Error(t, in.Remove("f"))
+	require.Len(t, in.Faults(), 0)
+}
+
+func TestInjectorApply(t *testing.T) {
+	in := NewInjector(1, nil)
+
+	require.NoError(t, in.Set(Fault{ID: "drop", Kind: DropPacket, Filter: "sensors/+/temp"}))
+	require.NoError(t, in.Set(Fault{ID: "delay", Kind: DelayPacket, Filter: "sensors/#", Delay: time.Second}))
+	require.NoError(t, in.Set(Fault{ID: "store", Kind: FailStoreWrite, Target: "sessions"}))
+	require.NoError(t, in.Set(Fault{ID: "link", Kind: PartitionLink, Target: "b1"}))
+	require.NoError(t, in.Set(Fault{ID: "slow", Kind: SlowSubscriber, Target: "c1", Delay: time.Millisecond}))
+
+	require.True(t, in.DropPacket("sensors/s1/temp"))
+	require.False(t, in.DropPacket("sensors/s1/hum"))
+	require.Equal(t, time.Second, in.PacketDelay("sensors/s1/hum"))
+	require.Equal(t, time.Duration(0), in.PacketDelay("other"))
+	require.Equal(t, ErrInjected, in.StoreWrite("sessions"))
+	require.NoError(t, in.StoreWrite("retained"))
+	require.True(t, in.Partitioned("b1"))
+	require.False(t, in.Partitioned("b2"))
+	require.Equal(t, time.Millisecond, in.SubscriberDelay("c1"))
+	require.Equal(t, time.Duration(0), in.SubscriberDelay("c2"))
+
+	in.Clear()
+	require.False(t, in.DropPacket("sensors/s1/temp"))
+}
+
+func TestInjectorProbabilityAndExpiry(t *testing.T) {
+	mock := clock.NewMock(time.Unix(1584662400, 0))
+	in := NewInjector(1, mock)
+
+	require.NoError(t, in.Set(Fault{ID: "drop", Kind: DropPacket, Filter: "#", Probability: 0.5}))
+	dropped := 0
+	for i := 0; i < 1000; i++ {
+		if in.DropPacket("a") {
+			dropped++
+		}
+	}
+	require.InDelta(t, 500, dropped, 100)
+
+	require.NoError(t, in.Set(Fault{ID: "drop", Kind: DropPacket, Filter: "#", Expires: mock.Now().Add(time.Minute)}))
+	require.True(t, in.DropPacket("a"))
+	mock.Add(time.Minute)
+	require.False(t, in.DropPacket("a"))
+	require.Len(t, in.Faults(), 0)
+}
@@ -0,0 +1,71 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReal(t *testing.T) {
+	require.Equal(t, Real, OrReal(nil))
+
+	c := OrReal(Real)
+	timer := c.NewTimer(time.Millisecond)
+	<-timer.C()
+	require.False(t, timer.Stop())
+
+	ticker := c.NewTicker(time.Millisecond)
+	<-ticker.C()
+	ticker.Stop()
+}
+
+func TestMockTimer(t *testing.T) {
+	start := time.Date(2020, 3, 20, 0, 0, 0, 0, time.UTC)
+	m := NewMock(start)
+
+	timer := m.NewTimer(time.Minute)
+	after := m.After(2 * time.Minute)
+
+	m.Add(30 * time.Second)
+	require.Len(t, timer.C(), 0)
+	require.Equal(t, start.Add(30*time.Second), m.Now())
+
+	m.Add(time.Minute)
+	require.Equal(t, start.Add(time.Minute), <-timer.C())
+	require.Len(t, after, 0)
+	require.False(t, timer.Stop())
+
+	require.False(t, timer.Reset(time.Minute))
+	m.Add(time.Minute)
+	require.Equal(t, start.Add(2*time.Minute), <-after)
+	require.Equal(t, start.Add(150*time.Second), <-timer.C())
+
+	stopped := m.NewTimer(time.Second)
+	require.True(t, stopped.Stop())
+	m.Add(time.Minute)
+	require.Len(t, stopped.C(), 0)
+	require.Equal(t, 3*time.Minute+30*time.Second, m.Since(start))
+}
+
+func TestMockTicker(t *testing.T) {
+	start := time.Date(2020, 3, 20, 0, 0, 0, 0, time.UTC)
+	m := NewMock(start)
+
+	ticker := m.NewTicker(time.Second)
+	var ticks []time.Time
+	for i := 0; i < 3; i++ {
+		m.Add(time.Second)
+		ticks = append(ticks, <-ticker.C())
+	}
+	require.Equal(t, []time.Time{start.Add(time.Second), start.Add(2 * time.Second), start.Add(3 * time.Second)}, ticks)
+
+	// the ticks not received are dropped
+	m.Add(10 * time.Second)
+	require.Equal(t, start.Add(4*time.Second), <-ticker.C())
+	require.Len(t, ticker.C(), 0)
+
+	ticker.Stop()
+	m.Add(time.Second)
+	require.Len(t, ticker.C(), 0)
+}
@@ -0,0 +1,148 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Mock is a deterministic clock, the time moves only by Add or Set. The timers and the tickers
+// fire in order while the time moves, as the real ones, a tick is dropped if the previous one
+// has not been received yet.
+type Mock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*mockWaiter
+}
+
+type mockWaiter struct {
+	mock   *Mock
+	at     time.Time
+	period time.Duration
+	ch     chan time.Time
+}
+
+func NewMock(start time.Time) *Mock {
+	return &Mock{now: start}
+}
+
+func (m *Mock) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.now
+}
+
+func (m *Mock) Since(t time.Time) time.Duration {
+	return m.Now().Sub(t)
+}
+
+func (m *Mock) After(d time.Duration) <-chan time.Time {
+	return m.NewTimer(d).C()
+}
+
+func (m *Mock) NewTimer(d time.Duration) Timer {
+	return m.addWaiter(d, 0)
+}
+
+func (m *Mock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return &mockTicker{w: m.addWaiter(d, d)}
+}
+
+func (m *Mock) addWaiter(d time.Duration, period time.Duration) *mockWaiter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w := &mockWaiter{mock: m, at: m.now.Add(d), period: period, ch: make(chan time.Time, 1)}
+	m.waiters = append(m.waiters, w)
+	return w
+}
+
+// Add moves the time forward, and fires the timers and the tickers due meanwhile.
+func (m *Mock) Add(d time.Duration) {
+	m.Set(m.Now().Add(d))
+}
+
+// Set moves the time to t, the time never moves backward.
+func (m *Mock) Set(t time.Time) {
+	for {
+		m.mu.Lock()
+		if len(m.waiters) == 0 {
+			break
+		}
+		sort.SliceStable(m.waiters, func(i, j int) bool { return m.waiters[i].at.Before(m.waiters[j].at) })
+		w := m.waiters[0]
+		if w.at.After(t) {
+			break
+		}
+
+		if w.at.After(m.now) {
+			m.now = w.at
+		}
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+		} else {
+			m.waiters = m.waiters[1:]
+		}
+		now := m.now
+		m.mu.Unlock()
+
+		select {
+		case w.ch <- now:
+		default:
+		}
+	}
+
+	if t.After(m.now) {
+		m.now = t
+	}
+	m.mu.Unlock()
+}
+
+func (w *mockWaiter) C() <-chan time.Time {
+	return w.ch
+}
+
+// Returns true if the waiter was still pending.
+func (w *mockWaiter) remove() bool {
+	m := w.mock
+	for i, o := range m.waiters {
+		if o == w {
+			m.waiters = append(m.waiters[:i], m.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (w *mockWaiter) Stop() bool {
+	w.mock.mu.Lock()
+	defer w.mock.mu.Unlock()
+
+	return w.remove()
+}
+
+func (w *mockWaiter) Reset(d time.Duration) bool {
+	w.mock.mu.Lock()
+	defer w.mock.mu.Unlock()
+
+	active := w.remove()
+	w.at = w.mock.now.Add(d)
+	w.mock.waiters = append(w.mock.waiters, w)
+	return active
+}
+
+type mockTicker struct {
+	w *mockWaiter
+}
+
+func (t *mockTicker) C() <-chan time.Time {
+	return t.w.ch
+}
+
+func (t *mockTicker) Stop() {
+	t.w.Stop()
+}
@@ -0,0 +1,366 @@
+// Package compress compresses the large payloads the broker keeps, the retained messages and the
+// messages queued to the offline sessions, and decompresses them once they're delivered. The
+// codecs are registered by name like the topics providers, zstd and snappy are registered by
+// default. The policies of the topic prefixes pick their own codec and threshold, or keep their
+// payloads as they are, and count what they save.
+package compress
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultCodec is the codec of a Config without one.
+	DefaultCodec = "zstd"
+	// DefaultThreshold is the payload size from which the payloads are compressed.
+	DefaultThreshold = 16 << 10
+)
+
+var (
+	codecs = make(map[string]Codec)
+)
+
+// frameMagic starts the framed payloads, which carry the name of their codec.
+var frameMagic = []byte{0x00, 0xff, 'B', 'M', 'C', 'Z', 0x01}
+
+// Codec compresses and decompresses the payloads, it must be safe for concurrent use.
+type Codec interface {
+	Encode(src []byte) ([]byte, error)
+	Decode(src []byte) ([]byte, error)
+}
+
+// Register makes a codec available by the provided name.
+// If a Register is called twice with the same name or if the codec is nil,
+// it panics.
+func Register(name string, codec Codec) {
+	if codec == nil {
+		panic("compress: Register codec is nil")
+	}
+	if len(name) == 0 || len(name) > 255 {
+		panic("compress: Register codec name must be 1 to 255 bytes")
+	}
+
+	if _, dup := codecs[name]; dup {
+		panic("compress: Register called twice for codec " + name)
+	}
+
+	codecs[name] = codec
+}
+
+func Unregister(name string) {
+	delete(codecs, name)
+}
+
+// Config of the compression, the payloads from Threshold bytes are compressed with the Codec, or as
+// the policy of the longest prefix of their topic says.
+type Config struct {
+	Codec     string   `json:"codec" yaml:"codec"`
+	Threshold int      `json:"threshold" yaml:"threshold"`
+	Policies  []Policy `json:"policies" yaml:"policies"`
+}
+
+// Policy compresses the payloads of the topics starting with Prefix with its Codec from its
+// Threshold, the ones of the Config if they're not set. Disabled keeps the payloads as they are.
+type Policy struct {
+	Prefix    string `json:"prefix" yaml:"prefix"`
+	Codec     string `json:"codec" yaml:"codec"`
+	Threshold int    `json:"threshold" yaml:"threshold"`
+	Disabled  bool   `json:"disabled" yaml:"disabled"`
+}
+
+// Stats are the counters of a Compressor, the durations are the CPU time spent by the codec.
+type Stats struct {
+	Codec      string        `json:"codec"`
+	Compressed uint64        `json:"compressed"`
+	Skipped    uint64        `json:"skipped"`
+	BytesIn    uint64        `json:"bytes_in"`
+	BytesOut   uint64        `json:"bytes_out"`
+	Saved      uint64        `json:"saved"`
+	Ratio      float64       `json:"ratio"`
+	EncodeTime time.Duration `json:"encode_time"`
+	Decoded    uint64        `json:"decoded"`
+	DecodeTime time.Duration `json:"decode_time"`
+	Errors     uint64        `json:"errors"`
+	// Prefixes are the counters of the policies, in the order of the config
+	Prefixes []PrefixStats `json:"prefixes,omitempty"`
+}
+
+// PrefixStats are the counters of the payloads of a policy, to tune which prefixes are worth
+// compressing.
+type PrefixStats struct {
+	Prefix     string  `json:"prefix"`
+	Codec      string  `json:"codec"`
+	Disabled   bool    `json:"disabled"`
+	Compressed uint64  `json:"compressed"`
+	Skipped    uint64  `json:"skipped"`
+	BytesIn    uint64  `json:"bytes_in"`
+	BytesOut   uint64  `json:"bytes_out"`
+	Saved      uint64  `json:"saved"`
+	Ratio      float64 `json:"ratio"`
+}
+
+// counters count the payloads compressed, and the ones not worth it.
+type counters struct {
+	compressed uint64
+	skipped    uint64
+	bytesIn    uint64
+	bytesOut   uint64
+}
+
+// policy is a Policy with its codec and its counters.
+type policy struct {
+	Policy
+	codec Codec
+	counters
+}
+
+// Compressor compresses the payloads over the threshold. A nil Compressor compresses nothing, it
+// still decompresses the payloads compressed before, such as the ones of a persisted store.
+type Compressor struct {
+	name      string
+	codec     Codec
+	threshold int
+	// The policies in the order of the config, and by the longest prefix first
+	policies []*policy
+	byPrefix []*policy
+
+	counters
+	encodeNs int64
+	decoded  uint64
+	decodeNs int64
+	errors   uint64
+}
+
+// New returns the Compressor of the config, the codec must be registered.
+func New(cfg Config) (*Compressor, error) {
+	if len(cfg.Codec) == 0 {
+		cfg.Codec = DefaultCodec
+	}
+	if cfg.Threshold == 0 {
+		cfg.Threshold = DefaultThreshold
+	}
+	if cfg.Threshold < 0 {
+		return nil, errors.New("compress/New: the threshold cannot be negative")
+	}
+	codec, ok := codecs[cfg.Codec]
+	if !ok {
+		return nil, fmt.Errorf("compress: unknown codec %q", cfg.Codec)
+	}
+	c := &Compressor{name: cfg.Codec, codec: codec, threshold: cfg.Threshold}
+
+	prefixes := make(map[string]bool, len(cfg.Policies))
+	for _, p := range cfg.Policies {
+		if len(p.Prefix) == 0 {
+			return nil, errors.New("compress/New: a policy has no prefix")
+		}
+		if prefixes[p.Prefix] {
+			return nil, fmt.Errorf("compress/New: the prefix %q has two policies", p.Prefix)
+		}
+		prefixes[p.Prefix] = true
+		if p.Threshold < 0 {
+			return nil, fmt.Errorf("compress/New: the threshold of the prefix %q cannot be negative", p.Prefix)
+		}
+		if len(p.Codec) == 0 {
+			p.Codec = cfg.Codec
+		}
+		if p.Threshold == 0 {
+			p.Threshold = cfg.Threshold
+		}
+		pc, ok := codecs[p.Codec]
+		if !ok {
+			return nil, fmt.Errorf("compress: unknown codec %q", p.Codec)
+		}
+		c.policies = append(c.policies, &policy{Policy: p, codec: pc})
+	}
+	c.byPrefix = append([]*policy(nil), c.policies...)
+	sort.SliceStable(c.byPrefix, func(i, j int) bool {
+		return len(c.byPrefix[i].Prefix) > len(c.byPrefix[j].Prefix)
+	})
+	return c, nil
+}
+
+// policy returns the policy of the longest prefix of the topic, nil if there is none.
+func (c *Compressor) policy(topic string) *policy {
+	for _, p := range c.byPrefix {
+		if strings.HasPrefix(topic, p.Prefix) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Compress returns the payload compressed and the name of its codec, or the payload itself and
+// an empty name if it's under the threshold or doesn't get smaller. The policies are not applied.
+func (c *Compressor) Compress(payload []byte) ([]byte, string) {
+	if c == nil {
+		return payload, ""
+	}
+	return c.compress(payload, c.name, c.codec, c.threshold, nil)
+}
+
+// CompressTopic compresses the payload like Compress, as the policy of the topic says.
+func (c *Compressor) CompressTopic(topic string, payload []byte) ([]byte, string) {
+	if c == nil {
+		return payload, ""
+	}
+	p := c.policy(topic)
+	if p == nil {
+		return c.compress(payload, c.name, c.codec, c.threshold, nil)
+	}
+	if p.Disabled {
+		return payload, ""
+	}
+	return c.compress(payload, p.Codec, p.codec, p.Threshold, &p.counters)
+}
+
+// compress counts the payload in the counters of the compressor, and in the ones of its policy.
+func (c *Compressor) compress(payload []byte, name string, codec Codec, threshold int, pc *counters) ([]byte, string) {
+	if len(payload) < threshold {
+		return payload, ""
+	}
+
+	start := time.Now()
+	out, err := codec.Encode(payload)
+	atomic.AddInt64(&c.encodeNs, int64(time.Since(start)))
+	if err != nil {
+		atomic.AddUint64(&c.errors, 1)
+		return payload, ""
+	}
+	c.counters.add(len(payload), len(out))
+	if pc != nil {
+		pc.add(len(payload), len(out))
+	}
+	if len(out) >= len(payload) {
+		return payload, ""
+	}
+	return out, name
+}
+
+// add counts a payload of in bytes compressed to out bytes, skipped if it didn't get smaller.
+func (cs *counters) add(in, out int) {
+	if out >= in {
+		atomic.AddUint64(&cs.skipped, 1)
+		return
+	}
+	atomic.AddUint64(&cs.compressed, 1)
+	atomic.AddUint64(&cs.bytesIn, uint64(in))
+	atomic.AddUint64(&cs.bytesOut, uint64(out))
+}
+
+// Decompress returns the payload compressed by the named codec, the payload itself if the name is
+// empty.
+func (c *Compressor) Decompress(payload []byte, codecName string) ([]byte, error) {
+	if len(codecName) == 0 {
+		return payload, nil
+	}
+	codec, ok := codecs[codecName]
+	if !ok {
+		return nil, fmt.Errorf("compress: unknown codec %q", codecName)
+	}
+
+	start := time.Now()
+	out, err := codec.Decode(payload)
+	if c != nil {
+		atomic.AddInt64(&c.decodeNs, int64(time.Since(start)))
+		atomic.AddUint64(&c.decoded, 1)
+		if err != nil {
+			atomic.AddUint64(&c.errors, 1)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("compress/Decompress: %s payload => %v", codecName, err)
+	}
+	return out, nil
+}
+
+// Frame compresses the payload like Compress, the name of the codec is written ahead of the
+// compressed payload, for the stores which keep the payloads only. The payloads which look like
+// a frame are framed too, with no codec, so Unframe never mistakes them.
+func (c *Compressor) Frame(payload []byte) []byte {
+	out, name := c.Compress(payload)
+	return frame(payload, out, name)
+}
+
+// FrameTopic frames the payload like Frame, compressed as the policy of the topic says.
+func (c *Compressor) FrameTopic(topic string, payload []byte) []byte {
+	out, name := c.CompressTopic(topic, payload)
+	return frame(payload, out, name)
+}
+
+func frame(payload, out []byte, name string) []byte {
+	if len(name) == 0 && !bytes.HasPrefix(payload, frameMagic) {
+		return payload
+	}
+
+	framed := make([]byte, 0, len(frameMagic)+1+len(name)+len(out))
+	framed = append(framed, frameMagic...)
+	framed = append(framed, byte(len(name)))
+	framed = append(framed, name...)
+	return append(framed, out...)
+}
+
+// Framed reports whether the payload is framed by Frame.
+func Framed(payload []byte) bool {
+	return bytes.HasPrefix(payload, frameMagic) && len(payload) > len(frameMagic)
+}
+
+// Unframe returns the payload framed by Frame decompressed, the payloads which are not framed are
+// returned as they are.
+func (c *Compressor) Unframe(payload []byte) ([]byte, error) {
+	if !Framed(payload) {
+		return payload, nil
+	}
+	rest := payload[len(frameMagic):]
+	n := int(rest[0])
+	if len(rest) < 1+n {
+		return nil, errors.New("compress/Unframe: the frame is truncated")
+	}
+	return c.Decompress(rest[1+n:], string(rest[1:1+n]))
+}
+
+// Stats returns the counters of the compressor, zero for a nil one.
+func (c *Compressor) Stats() Stats {
+	if c == nil {
+		return Stats{}
+	}
+	s := Stats{
+		Codec:      c.name,
+		Compressed: atomic.LoadUint64(&c.compressed),
+		Skipped:    atomic.LoadUint64(&c.skipped),
+		BytesIn:    atomic.LoadUint64(&c.bytesIn),
+		BytesOut:   atomic.LoadUint64(&c.bytesOut),
+		EncodeTime: time.Duration(atomic.LoadInt64(&c.encodeNs)),
+		Decoded:    atomic.LoadUint64(&c.decoded),
+		DecodeTime: time.Duration(atomic.LoadInt64(&c.decodeNs)),
+		Errors:     atomic.LoadUint64(&c.errors),
+	}
+	s.Saved, s.Ratio = saved(s.BytesIn, s.BytesOut)
+	for _, p := range c.policies {
+		ps := PrefixStats{
+			Prefix:     p.Prefix,
+			Codec:      p.Codec,
+			Disabled:   p.Disabled,
+			Compressed: atomic.LoadUint64(&p.compressed),
+			Skipped:    atomic.LoadUint64(&p.skipped),
+			BytesIn:    atomic.LoadUint64(&p.bytesIn),
+			BytesOut:   atomic.LoadUint64(&p.bytesOut),
+		}
+		ps.Saved, ps.Ratio = saved(ps.BytesIn, ps.BytesOut)
+		s.Prefixes = append(s.Prefixes, ps)
+	}
+	return s
+}
+
+// saved returns the bytes saved by the compression and the ratio of the compressed bytes.
+func saved(in, out uint64) (uint64, float64) {
+	if in == 0 {
+		return 0, 0
+	}
+	return in - out, float64(out) / float64(in)
+}
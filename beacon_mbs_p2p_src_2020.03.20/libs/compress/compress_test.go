@@ -0,0 +1,102 @@
+package compress
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompress(t *testing.T) {
+	_, err := New(Config{Codec: "lz9"})
+	require.Error(t, err)
+
+	c, err := New(Config{Threshold: 1024})
+	require.NoError(t, err)
+
+	small := []byte("small")
+	out, name := c.Compress(small)
+	require.Equal(t, small, out)
+	require.Empty(t, name)
+
+	large := bytes.Repeat([]byte("image-bytes "), 1000)
+	out, name = c.Compress(large)
+	require.Equal(t, "zstd", name)
+	require.Less(t, len(out), len(large))
+
+	back, err := c.Decompress(out, name)
+	require.NoError(t, err)
+	require.Equal(t, large, back)
+
+	s := c.Stats()
+	require.EqualValues(t, 1, s.Compressed)
+	require.EqualValues(t, len(large), s.BytesIn)
+	require.Less(t, s.Ratio, 0.5)
+	require.EqualValues(t, 1, s.Decoded)
+}
+
+func TestFrame(t *testing.T) {
+	c, err := New(Config{Threshold: 1024})
+	require.NoError(t, err)
+
+	large := bytes.Repeat([]byte("x"), 4096)
+	framed := c.Frame(large)
+	require.True(t, Framed(framed))
+	back, err := c.Unframe(framed)
+	require.NoError(t, err)
+	require.Equal(t, large, back)
+
+	// the small payloads are kept as they are, unless they look like a frame
+	require.Equal(t, []byte("plain"), c.Frame([]byte("plain")))
+	tricky := append(append([]byte(nil), frameMagic...), 0, 'a')
+	back, err = c.Unframe(c.Frame(tricky))
+	require.NoError(t, err)
+	require.Equal(t, tricky, back)
+
+	// a nil compressor frames nothing and still unframes
+	var none *Compressor
+	require.Equal(t, large, none.Frame(large))
+	back, err = none.Unframe(framed)
+	require.NoError(t, err)
+	require.Equal(t, large, back)
+	require.Equal(t, Stats{}, none.Stats())
+}
+
+func TestPolicies(t *testing.T) {
+	_, err := New(Config{Policies: []Policy{{Codec: "snappy"}}})
+	require.Error(t, err)
+	_, err = New(Config{Policies: []Policy{{Prefix: "a/", Codec: "lz9"}}})
+	require.Error(t, err)
+
+	c, err := New(Config{Threshold: 1024, Policies: []Policy{
+		{Prefix: "json/", Codec: "snappy", Threshold: 64},
+		{Prefix: "json/raw/", Disabled: true},
+	}})
+	require.NoError(t, err)
+
+	payload := bytes.Repeat([]byte(`{"temp":21.5}`), 20)
+	out, name := c.CompressTopic("json/sensors", payload)
+	require.Equal(t, "snappy", name)
+	back, err := c.Decompress(out, name)
+	require.NoError(t, err)
+	require.Equal(t, payload, back)
+
+	// the longest prefix wins, the other topics get the defaults
+	out, name = c.CompressTopic("json/raw/sensors", payload)
+	require.Equal(t, payload, out)
+	require.Empty(t, name)
+	_, name = c.CompressTopic("images/cam", payload)
+	require.Empty(t, name)
+	back, err = c.Unframe(c.FrameTopic("json/sensors", payload))
+	require.NoError(t, err)
+	require.Equal(t, payload, back)
+
+	s := c.Stats()
+	require.EqualValues(t, 2, s.Compressed)
+	require.Len(t, s.Prefixes, 2)
+	require.Equal(t, "json/", s.Prefixes[0].Prefix)
+	require.EqualValues(t, 2, s.Prefixes[0].Compressed)
+	require.EqualValues(t, s.Prefixes[0].BytesIn-s.Prefixes[0].BytesOut, s.Prefixes[0].Saved)
+	require.True(t, s.Prefixes[1].Disabled)
+	require.Zero(t, s.Prefixes[1].Compressed)
+}
@@ -0,0 +1,21 @@
+package compress
+
+import (
+	"github.com/klauspost/compress/s2"
+)
+
+func init() {
+	Register("snappy", snappyCodec{})
+}
+
+// snappyCodec writes the blocks in the snappy format, faster than zstd but compressing less, for
+// the payloads read often.
+type snappyCodec struct{}
+
+func (snappyCodec) Encode(src []byte) ([]byte, error) {
+	return s2.EncodeSnappy(nil, src), nil
+}
+
+func (snappyCodec) Decode(src []byte) ([]byte, error) {
+	return s2.Decode(nil, src)
+}
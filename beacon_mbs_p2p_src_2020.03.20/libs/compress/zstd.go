@@ -0,0 +1,31 @@
+package compress
+
+import (
+	"github.com/klauspost/compress/zstd"
+)
+
+func init() {
+	Register("zstd", newZstdCodec())
+}
+
+// zstdCodec shares one encoder and one decoder, their EncodeAll and DecodeAll are safe for
+// concurrent use.
+type zstdCodec struct {
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+func newZstdCodec() *zstdCodec {
+	// the options are valid, the writer and the reader are nil, so they cannot fail
+	enc, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+	dec, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	return &zstdCodec{enc: enc, dec: dec}
+}
+
+func (z *zstdCodec) Encode(src []byte) ([]byte, error) {
+	return z.enc.EncodeAll(src, make([]byte, 0, len(src)/2)), nil
+}
+
+func (z *zstdCodec) Decode(src []byte) ([]byte, error) {
+	return z.dec.DecodeAll(src, nil)
+}
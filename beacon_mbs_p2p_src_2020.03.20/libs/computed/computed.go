@@ -0,0 +1,182 @@
+package computed
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+)
+
+const (
+	FuncAvg   = "avg"
+	FuncMin   = "min"
+	FuncMax   = "max"
+	FuncSum   = "sum"
+	FuncCount = "count"
+	FuncLast  = "last"
+
+	defaultWindow = time.Minute
+)
+
+// Definition describes a computed topic, e.g. `stats/room1/avg_temp` is the "avg" of the
+// values published on `sensors/room1/+/temp` over the last minute. If Field is set, the
+// payloads are JSON objects and the value is read from this field, otherwise the whole
+// payload is the value. The result is published every Interval, which defaults to Window.
+type Definition struct {
+	Topic    string        `json:"topic"`
+	Source   string        `json:"source"`
+	Function string        `json:"function"`
+	Field    string        `json:"field,omitempty"`
+	Window   time.Duration `json:"window"`
+	Interval time.Duration `json:"interval"`
+}
+
+func (d *Definition) Validate() error {
+	switch d.Function {
+	case FuncAvg, FuncMin, FuncMax, FuncSum, FuncCount, FuncLast:
+	default:
+		return fmt.Errorf("computed/computed/Validate: unknown function %q", d.Function)
+	}
+
+	if len(d.Topic) == 0 || len(d.Source) == 0 {
+		return fmt.Errorf("computed/computed/Validate: topic and source cannot be empty")
+	}
+
+	if bytes.ContainsAny([]byte(d.Topic), "#+") {
+		return fmt.Errorf("computed/computed/Validate: topic [%s] cannot contain wildcards", d.Topic)
+	}
+
+	// The computed topic must not feed itself
+	match, err := topics.MatchTopic([]byte(d.Source), []byte(d.Topic))
+	if err != nil {
+		return err
+	}
+	if match {
+		return fmt.Errorf("computed/computed/Validate: source [%s] matches the computed topic [%s]", d.Source, d.Topic)
+	}
+
+	if d.Window <= 0 {
+		d.Window = defaultWindow
+	}
+	if d.Interval <= 0 {
+		d.Interval = d.Window
+	}
+
+	return nil
+}
+
+type sample struct {
+	at    time.Time
+	value float64
+}
+
+// Computed keeps the samples of the window of a definition.
+type Computed struct {
+	mu      sync.Mutex
+	def     Definition
+	samples []sample
+}
+
+func New(def Definition) (*Computed, error) {
+	if err := def.Validate(); err != nil {
+		return nil, err
+	}
+	return &Computed{def: def}, nil
+}
+
+func (c *Computed) Definition() Definition {
+	return c.def
+}
+
+// Observe adds the value carried by the payload to the window.
+func (c *Computed) Observe(payload []byte, now time.Time) error {
+	v, err := c.value(payload)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.samples = append(c.samples, sample{at: now, value: v})
+	c.mu.Unlock()
+
+	return nil
+}
+
+// Evaluate drops the samples out of the window and computes the value of the remaining ones,
+// false is returned if there is no sample.
+func (c *Computed) Evaluate(now time.Time) (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	from := now.Add(-c.def.Window)
+	i := 0
+	for i < len(c.samples) && c.samples[i].at.Before(from) {
+		i++
+	}
+	c.samples = append(c.samples[:0], c.samples[i:]...)
+
+	if len(c.samples) == 0 {
+		if c.def.Function == FuncCount || c.def.Function == FuncSum {
+			return 0, true
+		}
+		return 0, false
+	}
+
+	switch c.def.Function {
+	case FuncCount:
+		return float64(len(c.samples)), true
+	case FuncLast:
+		return c.samples[len(c.samples)-1].value, true
+	}
+
+	sum, min, max := 0.0, math.Inf(1), math.Inf(-1)
+	for _, s := range c.samples {
+		sum += s.value
+		min = math.Min(min, s.value)
+		max = math.Max(max, s.value)
+	}
+
+	switch c.def.Function {
+	case FuncMin:
+		return min, true
+	case FuncMax:
+		return max, true
+	case FuncSum:
+		return sum, true
+	default:
+		return sum / float64(len(c.samples)), true
+	}
+}
+
+func (c *Computed) value(payload []byte) (float64, error) {
+	if c.def.Function == FuncCount {
+		return 0, nil
+	}
+
+	if len(c.def.Field) == 0 {
+		return strconv.ParseFloat(string(bytes.TrimSpace(payload)), 64)
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal(payload, &obj); err != nil {
+		return 0, err
+	}
+
+	switch v := obj[c.def.Field].(type) {
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+
+	return 0, fmt.Errorf("computed/computed/value: field %q is not a number", c.def.Field)
+}
+
+func FormatValue(v float64) []byte {
+	return []byte(strconv.FormatFloat(v, 'f', -1, 64))
+}
@@ -0,0 +1,61 @@
+package computed
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefinitionValidate(t *testing.T) {
+	d := Definition{Topic: "stats/room1/avg_temp", Source: "sensors/room1/+/temp", Function: FuncAvg}
+	require.NoError(t, d.Validate())
+	require.Equal(t, defaultWindow, d.Window)
+	require.Equal(t, defaultWindow, d.Interval)
+
+	d = Definition{Topic: "stats/room1/avg_temp", Source: "stats/#", Function: FuncAvg}
+	require.Error(t, d.Validate())
+
+	d = Definition{Topic: "stats/+/avg_temp", Source: "sensors/#", Function: FuncAvg}
+	require.Error(t, d.Validate())
+
+	d = Definition{Topic: "stats/room1/avg_temp", Source: "sensors/#", Function: "median"}
+	require.Error(t, d.Validate())
+}
+
+func TestComputedEvaluate(t *testing.T) {
+	c, err := New(Definition{Topic: "stats/room1/avg_temp", Source: "sensors/room1/+/temp", Function: FuncAvg, Window: time.Minute})
+	require.NoError(t, err)
+
+	now := time.Now()
+	_, ok := c.Evaluate(now)
+	require.False(t, ok)
+
+	require.NoError(t, c.Observe([]byte("10"), now.Add(-2*time.Minute)))
+	require.NoError(t, c.Observe([]byte("20"), now.Add(-30*time.Second)))
+	require.NoError(t, c.Observe([]byte(" 30 "), now))
+	require.Error(t, c.Observe([]byte("warm"), now))
+
+	v, ok := c.Evaluate(now)
+	require.True(t, ok)
+	require.Equal(t, 25.0, v)
+	require.Equal(t, "25", string(FormatValue(v)))
+
+	c, err = New(Definition{Topic: "stats/room1/max_temp", Source: "sensors/room1/+/temp", Function: FuncMax, Field: "temp"})
+	require.NoError(t, err)
+	require.NoError(t, c.Observe([]byte(`{"temp":21.5}`), now))
+	require.NoError(t, c.Observe([]byte(`{"temp":"23.5"}`), now))
+	require.Error(t, c.Observe([]byte(`{"hum":40}`), now))
+
+	v, ok = c.Evaluate(now)
+	require.True(t, ok)
+	require.Equal(t, 23.5, v)
+
+	c, err = New(Definition{Topic: "stats/room1/count", Source: "sensors/room1/#", Function: FuncCount})
+	require.NoError(t, err)
+	require.NoError(t, c.Observe([]byte("anything"), now))
+
+	v, ok = c.Evaluate(now)
+	require.True(t, ok)
+	require.Equal(t, 1.0, v)
+}
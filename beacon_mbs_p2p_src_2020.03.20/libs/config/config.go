@@ -0,0 +1,348 @@
+// Package config reads the config file of the broker, instead of the defaults compiled in it. The
+// file is YAML, or JSON which is YAML too, with the sections:
+//
+//	listeners:      the TCP listener and the TLS, WebSocket, QUIC and admin ones
+//	providers:      the topics, sessions and auth providers
+//	limits:         the limits of the clients, the payload limits by topic, the memory guard and
+//	                the bounds of the subscription filters
+//	acl_file:       the file of the ACL rules, read again on each reload
+//	bridges:        the bridges to the remote brokers
+//	logging:        the level and the format of the logs, and the levels of the subsystems
+//	auto_subscribe: the subscriptions made for each client on CONNECT
+//	tenants:        the topic namespaces of the tenants and the topics they share
+//	schemas:        the schemas the payloads of the publishes are validated against, by topic prefix
+//
+// The sections of Reloadable apply to the running broker once the file is reloaded, the others
+// once the broker restarts.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/autosub"
+	"awesomeProject/beacon/mqtt_network/libs/bridge"
+	"awesomeProject/beacon/mqtt_network/libs/certmon"
+	"awesomeProject/beacon/mqtt_network/libs/memacct"
+	"awesomeProject/beacon/mqtt_network/libs/namespace"
+	"awesomeProject/beacon/mqtt_network/libs/quota"
+	"awesomeProject/beacon/mqtt_network/libs/schema"
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+// The sections of the file, as reported by Changed.
+const (
+	SectionListeners     = "listeners"
+	SectionProviders     = "providers"
+	SectionLimits        = "limits"
+	SectionACL           = "acl_file"
+	SectionBridges       = "bridges"
+	SectionLogging       = "logging"
+	SectionAutoSubscribe = "auto_subscribe"
+	SectionTenants       = "tenants"
+	SectionSchemas       = "schemas"
+)
+
+// The providers of the topics, the sessions and the auth.
+const (
+	ProviderMem  = "mem"
+	ProviderBolt = "bolt"
+	ProviderFile = "file"
+	ProviderHTTP = "http"
+)
+
+type Config struct {
+	Listeners Listeners       `json:"listeners" yaml:"listeners"`
+	Providers Providers       `json:"providers" yaml:"providers"`
+	Limits    Limits          `json:"limits" yaml:"limits"`
+	ACLFile   string          `json:"acl_file" yaml:"acl_file"`
+	Bridges   []bridge.Config `json:"bridges" yaml:"bridges"`
+	Logging   Logging         `json:"logging" yaml:"logging"`
+	// AutoSubscribe are the templates of the subscriptions made for each client on CONNECT
+	AutoSubscribe []autosub.Subscription `json:"auto_subscribe" yaml:"auto_subscribe"`
+	// Tenants isolates the clients of the tenants in the namespaces of their topics
+	Tenants *namespace.TenantConfig `json:"tenants" yaml:"tenants"`
+	// Schemas bind the topic prefixes to the schemas of their payloads
+	Schemas []schema.Binding `json:"schemas" yaml:"schemas"`
+}
+
+type Listeners struct {
+	// MQTT is the host:port of the TCP listener, the default of the broker if it's empty
+	MQTT string `json:"mqtt" yaml:"mqtt"`
+	// TLS serves the TCP listener over TLS with the certificate
+	TLS       *certmon.Config `json:"tls" yaml:"tls"`
+	WebSocket *WebSocket      `json:"websocket" yaml:"websocket"`
+	QUIC      *QUIC           `json:"quic" yaml:"quic"`
+	Admin     *Admin          `json:"admin" yaml:"admin"`
+	Gateway   *Gateway        `json:"gateway" yaml:"gateway"`
+	MQTTSN    *MQTTSN         `json:"mqttsn" yaml:"mqttsn"`
+}
+
+type WebSocket struct {
+	Addr string `json:"addr" yaml:"addr"`
+	// Path of the upgrade requests, /mqtt if empty
+	Path string `json:"path" yaml:"path"`
+	// TLS serves wss:// with the certificate of the TCP listener
+	TLS bool `json:"tls" yaml:"tls"`
+}
+
+type QUIC struct {
+	Addr            string        `json:"addr" yaml:"addr"`
+	Allow0RTT       bool          `json:"allow_0rtt" yaml:"allow_0rtt"`
+	MaxIdleTimeout  time.Duration `json:"max_idle_timeout" yaml:"max_idle_timeout"`
+	KeepAlivePeriod time.Duration `json:"keep_alive_period" yaml:"keep_alive_period"`
+}
+
+type Admin struct {
+	Addr  string `json:"addr" yaml:"addr"`
+	Token string `json:"token" yaml:"token"`
+}
+
+type Gateway struct {
+	Addr  string `json:"addr" yaml:"addr"`
+	Token string `json:"token" yaml:"token"`
+	// Username is the user the ACL checks the publishes of the gateway as
+	Username string `json:"username" yaml:"username"`
+}
+
+// MQTTSN is the UDP listener of the MQTT-SN gateway.
+type MQTTSN struct {
+	Addr string `json:"addr" yaml:"addr"`
+	// Username is the user the ACL checks the clients of the gateway as
+	Username string `json:"username" yaml:"username"`
+	// Predefined are the topic names of the predefined topic ids
+	Predefined map[uint16]string `json:"predefined" yaml:"predefined"`
+	// MaxBuffered is the number of messages kept for a sleeping client
+	MaxBuffered int `json:"max_buffered" yaml:"max_buffered"`
+}
+
+type Providers struct {
+	// Topics is mem or bolt, mem if empty; bolt persists to TopicsFile
+	Topics     string `json:"topics" yaml:"topics"`
+	TopicsFile string `json:"topics_file" yaml:"topics_file"`
+	// Sessions is mem or file, mem if empty; file persists to SessionsDir
+	Sessions    string `json:"sessions" yaml:"sessions"`
+	SessionsDir string `json:"sessions_dir" yaml:"sessions_dir"`
+	// Auth is file or http, the clients are not authenticated if it's empty; file reads the
+	// users of AuthFile, http posts the CONNECT to AuthURL
+	Auth        string        `json:"auth" yaml:"auth"`
+	AuthFile    string        `json:"auth_file" yaml:"auth_file"`
+	AuthURL     string        `json:"auth_url" yaml:"auth_url"`
+	AuthTimeout time.Duration `json:"auth_timeout" yaml:"auth_timeout"`
+}
+
+type Limits struct {
+	// Clients are the limits of each client, the auth provider may override them by user
+	Clients quota.Limits `json:"clients" yaml:"clients"`
+	// Payloads bound the payload size of the publishes by topic filter
+	Payloads []quota.PayloadLimit `json:"payloads" yaml:"payloads"`
+	// Memory accounts the memory of the connections and sheds the load over its high-water mark
+	Memory *memacct.Config `json:"memory" yaml:"memory"`
+	// Filters bound the topic filters of the subscriptions, with the in-memory topics provider
+	Filters *topics.FilterLimits `json:"filters" yaml:"filters"`
+}
+
+type Logging struct {
+	// Level is debug, info, warn or error; the logger of the broker is kept if it's empty
+	Level string `json:"level" yaml:"level"`
+	// Development logs in the console format, with the stack traces of the warnings
+	Development bool `json:"development" yaml:"development"`
+	// Levels are the levels of the subsystems of the broker by name, such as packets: debug;
+	// default has a subsystem follow Level
+	Levels map[string]string `json:"levels" yaml:"levels"`
+}
+
+// Load reads and checks the config file. The unknown keys are refused, so a misspelt key is not
+// silently ignored.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse reads and checks the config of the YAML or JSON document.
+func Parse(data []byte) (*Config, error) {
+	cfg := &Config{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("config/config/Parse: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) Validate() error {
+	if len(c.Listeners.MQTT) > 0 {
+		if _, err := c.Port(); err != nil {
+			return err
+		}
+	}
+
+	p := c.Providers
+	switch p.Topics {
+	case "", ProviderMem:
+	case ProviderBolt:
+		if len(p.TopicsFile) == 0 {
+			return errors.New("config/config/Validate: the bolt topics provider needs a topics_file")
+		}
+	default:
+		return fmt.Errorf("config/config/Validate: unknown topics provider %q", p.Topics)
+	}
+	switch p.Sessions {
+	case "", ProviderMem:
+	case ProviderFile:
+		if len(p.SessionsDir) == 0 {
+			return errors.New("config/config/Validate: the file sessions provider needs a sessions_dir")
+		}
+	default:
+		return fmt.Errorf("config/config/Validate: unknown sessions provider %q", p.Sessions)
+	}
+	switch p.Auth {
+	case "":
+	case ProviderFile:
+		if len(p.AuthFile) == 0 {
+			return errors.New("config/config/Validate: the file auth provider needs an auth_file")
+		}
+	case ProviderHTTP:
+		if len(p.AuthURL) == 0 {
+			return errors.New("config/config/Validate: the http auth provider needs an auth_url")
+		}
+	default:
+		return fmt.Errorf("config/config/Validate: unknown auth provider %q", p.Auth)
+	}
+
+	if err := c.Limits.Clients.Validate(); err != nil {
+		return err
+	}
+	if _, err := quota.NewPayloadLimits(c.Limits.Payloads); err != nil {
+		return err
+	}
+	if err := autosub.Validate(c.AutoSubscribe); err != nil {
+		return err
+	}
+	if _, err := schema.New(c.Schemas); err != nil {
+		return err
+	}
+	if c.Limits.Memory != nil {
+		if err := c.Limits.Memory.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Limits.Filters != nil {
+		if err := c.Limits.Filters.Validate(); err != nil {
+			return err
+		}
+	}
+
+	names := make(map[string]bool, len(c.Bridges))
+	for i := range c.Bridges {
+		if err := c.Bridges[i].Validate(); err != nil {
+			return err
+		}
+		if names[c.Bridges[i].Name] {
+			return fmt.Errorf("config/config/Validate: duplicate bridge %s", c.Bridges[i].Name)
+		}
+		names[c.Bridges[i].Name] = true
+	}
+
+	if _, err := c.Level(); err != nil {
+		return err
+	}
+	for name, level := range c.Logging.Levels {
+		var l zapcore.Level
+		if err := l.UnmarshalText([]byte(level)); err != nil && level != "default" {
+			return fmt.Errorf("config/config/Validate: unknown log level %q of %s", level, name)
+		}
+	}
+	return nil
+}
+
+// Host returns the host of the TCP listener, nil if it's empty.
+func (c *Config) Host() (net.IP, error) {
+	host, _, err := net.SplitHostPort(c.Listeners.MQTT)
+	if err != nil {
+		return nil, fmt.Errorf("config/config/Host: invalid mqtt listener %q => %v", c.Listeners.MQTT, err)
+	}
+	if len(host) == 0 {
+		return nil, nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("config/config/Host: the mqtt listener host %q is not an IP", host)
+	}
+	return ip, nil
+}
+
+// Port returns the port of the TCP listener.
+func (c *Config) Port() (uint16, error) {
+	if _, err := c.Host(); err != nil {
+		return 0, err
+	}
+	_, port, _ := net.SplitHostPort(c.Listeners.MQTT)
+	p, err := net.LookupPort("tcp", port)
+	if err != nil || p == 0 {
+		return 0, fmt.Errorf("config/config/Port: invalid mqtt listener port %q", port)
+	}
+	return uint16(p), nil
+}
+
+// Level returns the level of the logs, info if the logging section sets none.
+func (c *Config) Level() (zapcore.Level, error) {
+	level := zapcore.InfoLevel
+	if len(c.Logging.Level) == 0 {
+		return level, nil
+	}
+	if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+		return level, fmt.Errorf("config/config/Level: unknown log level %q", c.Logging.Level)
+	}
+	return level, nil
+}
+
+// Reloadable reports whether the section applies to the running broker once the file is
+// reloaded: the new limits apply to the clients connecting from then on, the bridges added,
+// removed or changed are connected or disconnected, and the log levels are changed.
+func Reloadable(section string) bool {
+	switch section {
+	case SectionLimits, SectionBridges, SectionLogging, SectionAutoSubscribe:
+		return true
+	}
+	return false
+}
+
+// Changed returns the sections which differ between the configs, in the order of the file.
+func Changed(old, new *Config) []string {
+	var changed []string
+	for _, s := range []struct {
+		name     string
+		old, new interface{}
+	}{
+		{SectionListeners, old.Listeners, new.Listeners},
+		{SectionProviders, old.Providers, new.Providers},
+		{SectionLimits, old.Limits, new.Limits},
+		{SectionACL, old.ACLFile, new.ACLFile},
+		{SectionBridges, old.Bridges, new.Bridges},
+		{SectionLogging, old.Logging, new.Logging},
+		{SectionAutoSubscribe, old.AutoSubscribe, new.AutoSubscribe},
+		{SectionTenants, old.Tenants, new.Tenants},
+		{SectionSchemas, old.Schemas, new.Schemas},
+	} {
+		if !reflect.DeepEqual(s.old, s.new) {
+			changed = append(changed, s.name)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/autosub"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+const testConfig = `
+listeners:
+  mqtt: 127.0.0.1:1884
+  websocket:
+    addr: :8083
+providers:
+  topics: bolt
+  topics_file: topics.db
+limits:
+  clients:
+    publish_rate: 100
+  payloads:
+    - filter: telemetry/#
+      max_payload: 4096
+acl_file: acl.yaml
+bridges:
+  - name: cloud
+    addrs: [tcp://cloud:1883]
+    client_id: edge-1
+    keep_alive: 30s
+    rules:
+      - direction: out
+        local_prefix: edge/
+        pattern: "#"
+logging:
+  level: warn
+  levels:
+    packets: debug
+`
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "broker.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(testConfig), 0600))
+
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	host, err := cfg.Host()
+	require.NoError(t, err)
+	require.Equal(t, net.ParseIP("127.0.0.1"), host)
+	port, err := cfg.Port()
+	require.NoError(t, err)
+	require.Equal(t, uint16(1884), port)
+	require.Equal(t, ":8083", cfg.Listeners.WebSocket.Addr)
+	require.Equal(t, 100.0, cfg.Limits.Clients.PublishRate)
+	require.Equal(t, 30*time.Second, cfg.Bridges[0].KeepAlive)
+	level, err := cfg.Level()
+	require.NoError(t, err)
+	require.Equal(t, zapcore.WarnLevel, level)
+
+	// JSON is YAML too, and an empty document is the defaults
+	_, err = Parse([]byte(`{"acl_file": "acl.json"}`))
+	require.NoError(t, err)
+	_, err = Parse(nil)
+	require.NoError(t, err)
+
+	for _, doc := range []string{
+		"listner:\n  mqtt: :1883",
+		"listeners:\n  mqtt: broker:1883",
+		"providers:\n  topics: bolt",
+		"providers:\n  auth: ldap",
+		"limits:\n  clients:\n    publish_rate: -1",
+		"logging:\n  level: loud",
+		"logging:\n  levels:\n    packets: loud",
+		"auto_subscribe:\n  - filter: devices/%c/#/cmd",
+		"schemas:\n  - prefix: sensors/\n    kind: xml",
+		"schemas:\n  - prefix: sensors/\n    kind: json_schema\n    schema: '{\"type\": 1}'",
+	} {
+		_, err = Parse([]byte(doc))
+		require.Error(t, err, doc)
+	}
+}
+
+func TestChanged(t *testing.T) {
+	old, err := Parse([]byte(testConfig))
+	require.NoError(t, err)
+	new, err := Parse([]byte(testConfig))
+	require.NoError(t, err)
+	require.Empty(t, Changed(old, new))
+
+	new.Limits.Clients.PublishRate = 10
+	new.Listeners.MQTT = ":1883"
+	new.Bridges = nil
+	new.AutoSubscribe = []autosub.Subscription{{Filter: "devices/%c/cmd", Qos: 1}}
+	changed := Changed(old, new)
+	require.Equal(t, []string{SectionListeners, SectionLimits, SectionBridges, SectionAutoSubscribe}, changed)
+	require.False(t, Reloadable(changed[0]))
+	require.True(t, Reloadable(changed[1]))
+	require.True(t, Reloadable(changed[2]))
+	require.True(t, Reloadable(changed[3]))
+}
@@ -0,0 +1,225 @@
+// Package delayed holds the publishes to $delayed/<seconds>/<topic> until their delay has elapsed,
+// then they're published to <topic>. The pending messages are kept in a min-heap by due time and,
+// if the queue has a file, persisted to it each time they change, so they survive the restarts.
+package delayed
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/atomicfile"
+)
+
+// Prefix is the prefix of the topics of the delayed publishes.
+const Prefix = "$delayed/"
+
+// MaxDelay is the longest delay of a publish, the largest 32 bits number of seconds.
+const MaxDelay = 4294967295 * time.Second
+
+// ErrFull is returned by Add once the queue holds its maximum number of messages.
+var ErrFull = errors.New("delayed/delayed: the queue of the delayed publishes is full")
+
+// Message is a delayed publish, to the target topic.
+type Message struct {
+	ID      uint64    `json:"id"`
+	Topic   string    `json:"topic"`
+	Payload []byte    `json:"payload"`
+	Qos     byte      `json:"qos"`
+	Retain  bool      `json:"retain"`
+	Due     time.Time `json:"due"`
+	// ClientID is the client which published the message
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// ParseTopic splits the topic of a delayed publish into its delay and its target topic, ok is false
+// if the topic has not the prefix.
+func ParseTopic(topic string) (delay time.Duration, target string, ok bool, err error) {
+	if !strings.HasPrefix(topic, Prefix) {
+		return 0, "", false, nil
+	}
+	rest := topic[len(Prefix):]
+	i := strings.IndexByte(rest, '/')
+	if i < 0 {
+		return 0, "", true, fmt.Errorf("delayed/delayed/ParseTopic: no topic after the delay in %s", topic)
+	}
+	seconds, err := strconv.ParseUint(rest[:i], 10, 32)
+	if err != nil {
+		return 0, "", true, fmt.Errorf("delayed/delayed/ParseTopic: invalid delay in %s", topic)
+	}
+	target = rest[i+1:]
+	if len(target) == 0 || strings.ContainsAny(target, "+#") {
+		return 0, "", true, fmt.Errorf("delayed/delayed/ParseTopic: invalid target topic in %s", topic)
+	}
+	return time.Duration(seconds) * time.Second, target, true, nil
+}
+
+// Queue holds the delayed publishes until they're due.
+type Queue struct {
+	mu   sync.Mutex
+	path string
+	max  int
+	seq  uint64
+	msgs messageHeap
+}
+
+// NewQueue loads the messages persisted in the file, a missing file is an empty queue. The queue is
+// kept in memory only if path is empty, and it's unbounded if max is 0.
+func NewQueue(path string, max int) (*Queue, error) {
+	q := &Queue{path: path, max: max}
+	if len(path) == 0 {
+		return q, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Message
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("delayed/delayed/NewQueue: invalid delayed publishes file %s => %v", path, err)
+	}
+	for i := range list {
+		m := list[i]
+		q.msgs = append(q.msgs, &m)
+		if m.ID > q.seq {
+			q.seq = m.ID
+		}
+	}
+	heap.Init(&q.msgs)
+	return q, nil
+}
+
+// Add queues the message until its due time, its ID is set by the queue and returned.
+func (q *Queue) Add(m Message) (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.max > 0 && len(q.msgs) >= q.max {
+		return 0, ErrFull
+	}
+	q.seq++
+	m.ID = q.seq
+	heap.Push(&q.msgs, &m)
+
+	if err := q.save(); err != nil {
+		for i, pending := range q.msgs {
+			if pending.ID == m.ID {
+				heap.Remove(&q.msgs, i)
+				break
+			}
+		}
+		return 0, err
+	}
+	return m.ID, nil
+}
+
+// Due removes the messages whose due time has come from the queue and returns them, the soonest
+// first. They're removed from the file too, a message is not published twice after a restart.
+func (q *Queue) Due(now time.Time) ([]Message, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var due []Message
+	for len(q.msgs) > 0 && !now.Before(q.msgs[0].Due) {
+		due = append(due, *heap.Pop(&q.msgs).(*Message))
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+	return due, q.save()
+}
+
+// Remove drops the pending message, false if there's none with the ID.
+func (q *Queue) Remove(id uint64) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, m := range q.msgs {
+		if m.ID == id {
+			heap.Remove(&q.msgs, i)
+			return true, q.save()
+		}
+	}
+	return false, nil
+}
+
+// Messages returns the pending messages, the soonest first.
+func (q *Queue) Messages() []Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.list()
+}
+
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.msgs)
+}
+
+func (q *Queue) list() []Message {
+	list := make([]Message, 0, len(q.msgs))
+	for _, m := range q.msgs {
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Due.Equal(list[j].Due) {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].Due.Before(list[j].Due)
+	})
+	return list
+}
+
+// Writes to a temporary file then renames it, the file is never left half written.
+func (q *Queue) save() error {
+	if len(q.path) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(q.list())
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(q.path, data)
+}
+
+// messageHeap orders the messages by due time, then by ID so the messages due at the same time are
+// published in the order they were received.
+type messageHeap []*Message
+
+func (h messageHeap) Len() int { return len(h) }
+
+func (h messageHeap) Less(i, j int) bool {
+	if h[i].Due.Equal(h[j].Due) {
+		return h[i].ID < h[j].ID
+	}
+	return h[i].Due.Before(h[j].Due)
+}
+
+func (h messageHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *messageHeap) Push(x interface{}) { *h = append(*h, x.(*Message)) }
+
+func (h *messageHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	m := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return m
+}
@@ -0,0 +1,89 @@
+package delayed
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTopic(t *testing.T) {
+	delay, target, ok, err := ParseTopic("$delayed/30/sensors/cmd")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 30*time.Second, delay)
+	require.Equal(t, "sensors/cmd", target)
+
+	_, _, ok, err = ParseTopic("sensors/cmd")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	for _, topic := range []string{"$delayed/30", "$delayed/30/", "$delayed/-1/a", "$delayed/x/a", "$delayed/4294967296/a", "$delayed/1/a/#"} {
+		_, _, ok, err = ParseTopic(topic)
+		require.True(t, ok, topic)
+		require.Error(t, err, topic)
+	}
+}
+
+func TestQueueDue(t *testing.T) {
+	q, err := NewQueue("", 2)
+	require.NoError(t, err)
+
+	now := time.Unix(1584662400, 0)
+	_, err = q.Add(Message{Topic: "b", Due: now.Add(2 * time.Second)})
+	require.NoError(t, err)
+	id, err := q.Add(Message{Topic: "a", Due: now.Add(time.Second)})
+	require.NoError(t, err)
+	_, err = q.Add(Message{Topic: "c", Due: now})
+	require.Equal(t, ErrFull, err)
+	require.Equal(t, "a", q.Messages()[0].Topic)
+
+	due, err := q.Due(now)
+	require.NoError(t, err)
+	require.Len(t, due, 0)
+
+	due, err = q.Due(now.Add(time.Second))
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	require.Equal(t, id, due[0].ID)
+	require.Equal(t, 1, q.Len())
+
+	removed, err := q.Remove(id)
+	require.NoError(t, err)
+	require.False(t, removed)
+	removed, err = q.Remove(q.Messages()[0].ID)
+	require.NoError(t, err)
+	require.True(t, removed)
+	require.Equal(t, 0, q.Len())
+}
+
+func TestQueuePersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "delayed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "delayed.json")
+
+	now := time.Unix(1584662400, 0).UTC()
+	q, err := NewQueue(path, 0)
+	require.NoError(t, err)
+	_, err = q.Add(Message{Topic: "a", Payload: []byte("1"), Qos: 1, Due: now.Add(time.Minute)})
+	require.NoError(t, err)
+	_, err = q.Add(Message{Topic: "b", Payload: []byte("2"), Due: now.Add(time.Hour)})
+	require.NoError(t, err)
+	_, err = q.Due(now.Add(time.Minute))
+	require.NoError(t, err)
+
+	// the due message is not loaded again, the IDs keep increasing
+	q, err = NewQueue(path, 0)
+	require.NoError(t, err)
+	list := q.Messages()
+	require.Len(t, list, 1)
+	require.Equal(t, "b", list[0].Topic)
+	require.True(t, now.Add(time.Hour).Equal(list[0].Due))
+	id, err := q.Add(Message{Topic: "c", Due: now})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), id)
+}
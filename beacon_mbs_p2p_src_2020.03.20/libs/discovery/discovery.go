@@ -0,0 +1,212 @@
+// Package discovery resolves the addresses given as DNS names, so the cluster seeds (and the
+// bridges) can be configured as SRV records or DNS-SD service names, as in Kubernetes headless
+// services and Consul, or as an mDNS service browsed on the LAN. The peers found are health
+// checked, the ones failing are removed until they answer again.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// srv://_p2p._udp.beacon.default.svc.cluster.local resolves the SRV record of the full name.
+	SchemeSRV = "srv://"
+
+	// dnssd://beacon-p2p._udp.service.consul resolves the SRV record of the DNS-SD service
+	// instance, that is _beacon-p2p._udp.service.consul.
+	SchemeDNSSD = "dnssd://"
+
+	defaultWatchInterval = 30 * time.Second
+)
+
+// Resolver is satisfied by *net.Resolver.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// IsDNSName reports whether the address needs to be resolved.
+func IsDNSName(spec string) bool {
+	return strings.HasPrefix(spec, SchemeSRV) || strings.HasPrefix(spec, SchemeDNSSD) || strings.HasPrefix(spec, SchemeMDNS)
+}
+
+// Resolve returns the host:port addresses of the spec ordered by priority and weight, a plain
+// address is returned as is.
+func Resolve(ctx context.Context, r Resolver, spec string) ([]string, error) {
+	var service, proto, name string
+
+	switch {
+	case strings.HasPrefix(spec, SchemeMDNS):
+		service, err := parseMDNSService(spec)
+		if err != nil {
+			return nil, err
+		}
+		if b, ok := r.(Browser); ok {
+			return b.Browse(ctx, service)
+		}
+		return Browse(ctx, service)
+	case strings.HasPrefix(spec, SchemeSRV):
+		name = strings.TrimPrefix(spec, SchemeSRV)
+	case strings.HasPrefix(spec, SchemeDNSSD):
+		parts := strings.SplitN(strings.TrimPrefix(spec, SchemeDNSSD), ".", 3)
+		if len(parts) != 3 || !strings.HasPrefix(parts[1], "_") {
+			return nil, fmt.Errorf("discovery/discovery/Resolve: invalid DNS-SD service name %q, expected <service>._<proto>.<domain>", spec)
+		}
+		service, proto, name = strings.TrimPrefix(parts[0], "_"), strings.TrimPrefix(parts[1], "_"), parts[2]
+	default:
+		return []string{spec}, nil
+	}
+
+	if len(name) == 0 {
+		return nil, fmt.Errorf("discovery/discovery/Resolve: empty name in %q", spec)
+	}
+
+	_, records, err := r.LookupSRV(ctx, service, proto, name)
+	if err != nil {
+		return nil, err
+	}
+
+	addrList := make([]string, 0, len(records))
+	for _, srv := range records {
+		host := strings.TrimSuffix(srv.Target, ".")
+		addrList = append(addrList, net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+	}
+	return addrList, nil
+}
+
+// ResolveAll resolves all the specs, the addresses are deduplicated. The specs failed to be
+// resolved are skipped, and the last error is returned along with the other addresses.
+func ResolveAll(ctx context.Context, r Resolver, specs []string) ([]string, error) {
+	var lastErr error
+	seen := make(map[string]bool)
+	addrList := make([]string, 0, len(specs))
+
+	for _, spec := range specs {
+		list, err := Resolve(ctx, r, spec)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, addr := range list {
+			if !seen[addr] {
+				seen[addr] = true
+				addrList = append(addrList, addr)
+			}
+		}
+	}
+
+	return addrList, lastErr
+}
+
+// Watcher resolves the specs periodically, and reports the addresses which appeared or
+// disappeared since the previous resolution.
+type Watcher struct {
+	mu       sync.Mutex
+	resolver Resolver
+	specs    []string
+	interval time.Duration
+	known    map[string]bool
+
+	onChange func(added []string, removed []string)
+	stop     chan struct{}
+}
+
+func NewWatcher(r Resolver, specs []string, interval time.Duration, onChange func(added []string, removed []string)) *Watcher {
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	return &Watcher{
+		resolver: r,
+		specs:    specs,
+		interval: interval,
+		known:    make(map[string]bool),
+		onChange: onChange,
+	}
+}
+
+// Poll resolves the specs once. If a spec failed to be resolved, the addresses are not
+// considered as removed, a DNS outage must not evict the peers.
+func (w *Watcher) Poll(ctx context.Context) (added []string, removed []string, err error) {
+	addrList, err := ResolveAll(ctx, w.resolver, w.specs)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	current := make(map[string]bool, len(addrList))
+	for _, addr := range addrList {
+		current[addr] = true
+		if !w.known[addr] {
+			added = append(added, addr)
+			w.known[addr] = true
+		}
+	}
+
+	if err == nil {
+		for addr := range w.known {
+			if !current[addr] {
+				removed = append(removed, addr)
+				delete(w.known, addr)
+			}
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	return added, removed, err
+}
+
+// Start polls in the background, the first poll happens at once.
+func (w *Watcher) Start() error {
+	w.mu.Lock()
+	if w.stop != nil {
+		w.mu.Unlock()
+		return errors.New("discovery/discovery/Start: watcher is already started")
+	}
+	w.stop = make(chan struct{})
+	stop := w.stop
+	w.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), w.interval)
+			added, removed, _ := w.Poll(ctx)
+			cancel()
+
+			if (len(added) > 0 || len(removed) > 0) && w.onChange != nil {
+				w.onChange(added, removed)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (w *Watcher) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stop != nil {
+		close(w.stop)
+		w.stop = nil
+	}
+}
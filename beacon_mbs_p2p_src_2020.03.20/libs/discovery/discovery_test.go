@@ -0,0 +1,162 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeResolver struct {
+	records map[string][]*net.SRV
+	err     error
+}
+
+func (f *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if f.err != nil {
+		return "", nil, f.err
+	}
+	cname := name
+	if service != "" || proto != "" {
+		cname = "_" + service + "._" + proto + "." + name
+	}
+	records, ok := f.records[cname]
+	if !ok {
+		return "", nil, errors.New("no such host")
+	}
+	return cname, records, nil
+}
+
+func TestResolve(t *testing.T) {
+	r := &fakeResolver{records: map[string][]*net.SRV{
+		"_p2p._udp.beacon.svc.cluster.local": {
+			{Target: "beacon-0.beacon.svc.cluster.local.", Port: 15666},
+			{Target: "beacon-1.beacon.svc.cluster.local.", Port: 15666},
+		},
+		"_beacon-p2p._udp.service.consul": {
+			{Target: "10.0.0.7.", Port: 15667},
+		},
+	}}
+	ctx := context.Background()
+
+	addrList, err := Resolve(ctx, r, "127.0.0.1:15666")
+	require.NoError(t, err)
+	require.Equal(t, []string{"127.0.0.1:15666"}, addrList)
+
+	addrList, err = Resolve(ctx, r, "srv://_p2p._udp.beacon.svc.cluster.local")
+	require.NoError(t, err)
+	require.Equal(t, []string{"beacon-0.beacon.svc.cluster.local:15666", "beacon-1.beacon.svc.cluster.local:15666"}, addrList)
+
+	addrList, err = Resolve(ctx, r, "dnssd://beacon-p2p._udp.service.consul")
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.0.7:15667"}, addrList)
+
+	_, err = Resolve(ctx, r, "dnssd://beacon-p2p.service.consul")
+	require.Error(t, err)
+
+	addrList, err = ResolveAll(ctx, r, []string{"10.0.0.7:15667", "dnssd://beacon-p2p._udp.service.consul", "srv://_missing._udp.local"})
+	require.Error(t, err)
+	require.Equal(t, []string{"10.0.0.7:15667"}, addrList)
+}
+
+func TestWatcherPoll(t *testing.T) {
+	r := &fakeResolver{records: map[string][]*net.SRV{
+		"_p2p._udp.local": {{Target: "a.local.", Port: 1}, {Target: "b.local.", Port: 1}},
+	}}
+	w := NewWatcher(r, []string{"srv://_p2p._udp.local"}, 0, nil)
+	ctx := context.Background()
+
+	added, removed, err := w.Poll(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.local:1", "b.local:1"}, added)
+	require.Len(t, removed, 0)
+
+	r.records["_p2p._udp.local"] = []*net.SRV{{Target: "b.local.", Port: 1}, {Target: "c.local.", Port: 1}}
+	added, removed, err = w.Poll(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"c.local:1"}, added)
+	require.Equal(t, []string{"a.local:1"}, removed)
+
+	// nothing is removed while the DNS is failing
+	r.err = errors.New("timeout")
+	added, removed, err = w.Poll(ctx)
+	require.Error(t, err)
+	require.Len(t, added, 0)
+	require.Len(t, removed, 0)
+}
+
+type fakeBrowser struct {
+	fakeResolver
+	instances map[string][]string
+}
+
+func (f *fakeBrowser) Browse(ctx context.Context, service string) ([]string, error) {
+	return f.instances[service], nil
+}
+
+func TestResolveMDNS(t *testing.T) {
+	r := &fakeBrowser{instances: map[string][]string{"_beacon-p2p._udp": {"10.0.0.8:15666"}}}
+	ctx := context.Background()
+
+	require.True(t, IsDNSName("mdns://_beacon-p2p._udp"))
+	addrList, err := Resolve(ctx, r, "mdns://_beacon-p2p._udp")
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.0.8:15666"}, addrList)
+
+	_, err = Resolve(ctx, r, "mdns://beacon-p2p")
+	require.Error(t, err)
+}
+
+func TestMDNSAnswer(t *testing.T) {
+	r, err := NewResponder("_beacon-p2p._udp", "192.168.1.20:15666")
+	require.NoError(t, err)
+
+	query, err := mdnsQuery("_beacon-p2p._udp")
+	require.NoError(t, err)
+	answer, ok := r.answer(query, false)
+	require.True(t, ok)
+	require.Equal(t, []string{"192.168.1.20:15666"}, parseMDNSAnswer(answer, "_beacon-p2p._udp"))
+
+	// the legacy queries get their questions back, the answer is parsed the same
+	answer, ok = r.answer(query, true)
+	require.True(t, ok)
+	require.Equal(t, []string{"192.168.1.20:15666"}, parseMDNSAnswer(answer, "_beacon-p2p._udp"))
+
+	other, err := mdnsQuery("_other._tcp")
+	require.NoError(t, err)
+	_, ok = r.answer(other, false)
+	require.False(t, ok)
+	require.Empty(t, parseMDNSAnswer(answer, "_other._tcp"))
+}
+
+func TestHealth(t *testing.T) {
+	failing := map[string]bool{"b:1": true}
+	var down, up []string
+	h := NewHealth(func(ctx context.Context, addr string) error {
+		if failing[addr] {
+			return errors.New("timeout")
+		}
+		return nil
+	}, time.Second, 2, func(addr string) { down = append(down, addr) }, func(addr string) { up = append(up, addr) })
+	h.Add("a:1")
+	h.Add("b:1")
+	ctx := context.Background()
+
+	h.CheckAll(ctx)
+	require.Empty(t, down)
+	h.CheckAll(ctx)
+	require.Equal(t, []string{"b:1"}, down)
+	h.CheckAll(ctx)
+	require.Equal(t, []string{"b:1"}, down)
+	require.Equal(t, []PeerStatus{{Addr: "a:1"}, {Addr: "b:1", Failed: 3, Down: true}}, h.Status())
+
+	failing["b:1"] = false
+	h.CheckAll(ctx)
+	require.Equal(t, []string{"b:1"}, up)
+
+	h.Remove("b:1")
+	require.Len(t, h.Status(), 1)
+}
@@ -0,0 +1,185 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHealthInterval = 15 * time.Second
+	defaultHealthFailures = 3
+)
+
+// CheckFunc checks the peer at the address, such as with a ping.
+type CheckFunc func(ctx context.Context, addr string) error
+
+// Health checks the discovered peers periodically. A peer failing the check failures times in a
+// row is down, it's removed by onDown; it's added back by onUp once it passes the check again.
+type Health struct {
+	mu       sync.Mutex
+	check    CheckFunc
+	interval time.Duration
+	failures int
+	peers    map[string]*peerHealth
+
+	onDown func(addr string)
+	onUp   func(addr string)
+	stop   chan struct{}
+}
+
+type peerHealth struct {
+	failed int
+	down   bool
+}
+
+// PeerStatus is the health of a peer.
+type PeerStatus struct {
+	Addr   string `json:"addr"`
+	Failed int    `json:"failed"`
+	Down   bool   `json:"down"`
+}
+
+// NewHealth returns the health check of the peers, every 15s and 3 failures by default.
+func NewHealth(check CheckFunc, interval time.Duration, failures int, onDown func(addr string), onUp func(addr string)) *Health {
+	if interval <= 0 {
+		interval = defaultHealthInterval
+	}
+	if failures <= 0 {
+		failures = defaultHealthFailures
+	}
+	return &Health{
+		check:    check,
+		interval: interval,
+		failures: failures,
+		peers:    make(map[string]*peerHealth),
+		onDown:   onDown,
+		onUp:     onUp,
+	}
+}
+
+// Add checks the peer from now on, it's considered up.
+func (h *Health) Add(addr string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.peers[addr]; !ok {
+		h.peers[addr] = &peerHealth{}
+	}
+}
+
+// Remove stops checking the peer, such as when it's gone from the DNS.
+func (h *Health) Remove(addr string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.peers, addr)
+}
+
+// CheckAll checks the peers once, concurrently, and calls onDown and onUp for the peers whose
+// health changed.
+func (h *Health) CheckAll(ctx context.Context) {
+	h.mu.Lock()
+	addrList := make([]string, 0, len(h.peers))
+	for addr := range h.peers {
+		addrList = append(addrList, addr)
+	}
+	h.mu.Unlock()
+
+	errs := make([]error, len(addrList))
+	var wg sync.WaitGroup
+	for i, addr := range addrList {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			errs[i] = h.check(ctx, addr)
+		}(i, addr)
+	}
+	wg.Wait()
+
+	var down, up []string
+	h.mu.Lock()
+	for i, addr := range addrList {
+		p, ok := h.peers[addr]
+		if !ok {
+			continue
+		}
+		if errs[i] == nil {
+			p.failed = 0
+			if p.down {
+				p.down = false
+				up = append(up, addr)
+			}
+			continue
+		}
+		p.failed++
+		if !p.down && p.failed >= h.failures {
+			p.down = true
+			down = append(down, addr)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, addr := range down {
+		if h.onDown != nil {
+			h.onDown(addr)
+		}
+	}
+	for _, addr := range up {
+		if h.onUp != nil {
+			h.onUp(addr)
+		}
+	}
+}
+
+// Status returns the health of the peers, by address.
+func (h *Health) Status() []PeerStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status := make([]PeerStatus, 0, len(h.peers))
+	for addr, p := range h.peers {
+		status = append(status, PeerStatus{Addr: addr, Failed: p.failed, Down: p.down})
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Addr < status[j].Addr })
+	return status
+}
+
+// Start checks the peers in the background, every interval.
+func (h *Health) Start() error {
+	h.mu.Lock()
+	if h.stop != nil {
+		h.mu.Unlock()
+		return errors.New("discovery/health/Start: health check is already started")
+	}
+	h.stop = make(chan struct{})
+	stop := h.stop
+	h.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), h.interval)
+			h.CheckAll(ctx)
+			cancel()
+		}
+	}()
+	return nil
+}
+
+// Stop stops the checks in the background.
+func (h *Health) Stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.stop != nil {
+		close(h.stop)
+		h.stop = nil
+	}
+}
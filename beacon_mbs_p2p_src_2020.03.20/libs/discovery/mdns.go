@@ -0,0 +1,334 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// mdns://_beacon-p2p._udp browses the instances of the service on the LAN with multicast DNS,
+	// the nodes announce themselves with a Responder.
+	SchemeMDNS = "mdns://"
+
+	mdnsDomain     = "local."
+	mdnsTTL        = 120
+	defaultBrowse  = time.Second
+	mdnsTXTAddrKey = "addr="
+	// the top bit of the class of an mDNS answer flushes the caches of the record
+	mdnsCacheFlush = 1 << 15
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Browser is implemented by the resolvers browsing the mDNS services themselves, the others
+// browse the LAN with Browse.
+type Browser interface {
+	Browse(ctx context.Context, service string) ([]string, error)
+}
+
+// parseMDNSService returns the service of an mdns:// spec, such as _beacon-p2p._udp.
+func parseMDNSService(spec string) (string, error) {
+	service := strings.TrimSuffix(strings.TrimPrefix(spec, SchemeMDNS), ".")
+	parts := strings.Split(service, ".")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "_") || !strings.HasPrefix(parts[1], "_") {
+		return "", fmt.Errorf("discovery/mdns/parseMDNSService: invalid mDNS service %q, expected _<service>._<proto>", spec)
+	}
+	return service, nil
+}
+
+// Browse asks the LAN for the instances of the service, and returns the addresses of the ones
+// answering until the context is done, or for a second if it has no deadline.
+func Browse(ctx context.Context, service string) ([]string, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, fmt.Errorf("discovery/mdns/Browse: listen => %v", err)
+	}
+	defer conn.Close()
+
+	query, err := mdnsQuery(service)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteTo(query, mdnsGroup); err != nil {
+		return nil, fmt.Errorf("discovery/mdns/Browse: send query => %v", err)
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultBrowse)
+	}
+	_ = conn.SetReadDeadline(deadline)
+
+	seen := make(map[string]bool)
+	var addrList []string
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			// the deadline ends the browsing
+			break
+		}
+		for _, addr := range parseMDNSAnswer(buf[:n], service) {
+			if !seen[addr] {
+				seen[addr] = true
+				addrList = append(addrList, addr)
+			}
+		}
+	}
+	sort.Strings(addrList)
+	return addrList, nil
+}
+
+func mdnsQuery(service string) ([]byte, error) {
+	name, err := dnsmessage.NewName(service + "." + mdnsDomain)
+	if err != nil {
+		return nil, fmt.Errorf("discovery/mdns/mdnsQuery: %v", err)
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// parseMDNSAnswer returns the addresses of the instances of the service in the answer, from their
+// TXT record or from their SRV and A records.
+func parseMDNSAnswer(msg []byte, service string) []string {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil || !h.Response {
+		return nil
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil
+	}
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return nil
+	}
+	additionals, _ := p.AllAdditionals()
+
+	serviceName := strings.ToLower(service + "." + mdnsDomain)
+	instances := make(map[string]bool)
+	txt := make(map[string]string)
+	srv := make(map[string]*dnsmessage.SRVResource)
+	hosts := make(map[string]net.IP)
+	for _, r := range append(answers, additionals...) {
+		name := strings.ToLower(r.Header.Name.String())
+		switch body := r.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if name == serviceName {
+				instances[strings.ToLower(body.PTR.String())] = true
+			}
+		case *dnsmessage.TXTResource:
+			for _, s := range body.TXT {
+				if strings.HasPrefix(s, mdnsTXTAddrKey) {
+					txt[name] = strings.TrimPrefix(s, mdnsTXTAddrKey)
+				}
+			}
+		case *dnsmessage.SRVResource:
+			srv[name] = body
+		case *dnsmessage.AResource:
+			hosts[name] = net.IP(body.A[:])
+		}
+	}
+
+	var addrList []string
+	for instance := range instances {
+		if addr, ok := txt[instance]; ok {
+			addrList = append(addrList, addr)
+			continue
+		}
+		s, ok := srv[instance]
+		if !ok {
+			continue
+		}
+		target := strings.ToLower(s.Target.String())
+		host := strings.TrimSuffix(target, ".")
+		if ip, ok := hosts[target]; ok {
+			host = ip.String()
+		}
+		addrList = append(addrList, net.JoinHostPort(host, strconv.Itoa(int(s.Port))))
+	}
+	sort.Strings(addrList)
+	return addrList
+}
+
+// Responder announces an instance of a service on the LAN, it answers the mDNS queries of the
+// service with the address of the instance.
+type Responder struct {
+	service  string
+	instance string
+	addr     string
+
+	mu   sync.Mutex
+	conn *net.UDPConn
+}
+
+// NewResponder returns the responder of the instance of the service at the host:port address, the
+// instance is named after the address.
+func NewResponder(service string, addr string) (*Responder, error) {
+	service = strings.TrimSuffix(service, ".")
+	if _, err := parseMDNSService(SchemeMDNS + service); err != nil {
+		return nil, err
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("discovery/mdns/NewResponder: invalid address %q => %v", addr, err)
+	}
+	instance := strings.NewReplacer(".", "-", ":", "-", "[", "", "]", "").Replace(addr)
+	return &Responder{service: service, instance: instance, addr: addr}, nil
+}
+
+// Start listens to the mDNS group and answers in the background.
+func (r *Responder) Start() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn != nil {
+		return errors.New("discovery/mdns/Start: responder is already started")
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return fmt.Errorf("discovery/mdns/Start: join the mDNS group => %v", err)
+	}
+	r.conn = conn
+
+	go func() {
+		buf := make([]byte, 9000)
+		for {
+			n, src, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			// a query from another port than 5353 is a legacy one, answered to its sender only
+			legacy := src.Port != mdnsGroup.Port
+			answer, ok := r.answer(buf[:n], legacy)
+			if !ok {
+				continue
+			}
+			dst := mdnsGroup
+			if legacy {
+				dst = src
+			}
+			_, _ = conn.WriteToUDP(answer, dst)
+		}
+	}()
+	return nil
+}
+
+// Close stops answering.
+func (r *Responder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn = nil
+	return err
+}
+
+// answer returns the answer to the query if it asks for the service or the instance, the answer
+// to a legacy query carries its ID and its questions.
+func (r *Responder) answer(query []byte, legacy bool) ([]byte, bool) {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil || h.Response {
+		return nil, false
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return nil, false
+	}
+
+	serviceName := r.service + "." + mdnsDomain
+	instanceName := r.instance + "." + serviceName
+	asked := false
+	for _, q := range questions {
+		name := q.Name.String()
+		if (strings.EqualFold(name, serviceName) || strings.EqualFold(name, instanceName)) &&
+			(q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeSRV || q.Type == dnsmessage.TypeTXT || q.Type == dnsmessage.TypeALL) {
+			asked = true
+		}
+	}
+	if !asked {
+		return nil, false
+	}
+
+	if !legacy {
+		h.ID, questions = 0, nil
+	}
+	msg, err := r.records(h.ID, questions)
+	if err != nil {
+		return nil, false
+	}
+	return msg, true
+}
+
+func (r *Responder) records(id uint16, questions []dnsmessage.Question) ([]byte, error) {
+	service, err := dnsmessage.NewName(r.service + "." + mdnsDomain)
+	if err != nil {
+		return nil, err
+	}
+	instance, err := dnsmessage.NewName(r.instance + "." + r.service + "." + mdnsDomain)
+	if err != nil {
+		return nil, err
+	}
+	target, err := dnsmessage.NewName(r.instance + "." + mdnsDomain)
+	if err != nil {
+		return nil, err
+	}
+	host, portStr, _ := net.SplitHostPort(r.addr)
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, Response: true, Authoritative: true})
+	b.EnableCompression()
+	if len(questions) > 0 {
+		if err := b.StartQuestions(); err != nil {
+			return nil, err
+		}
+		for _, q := range questions {
+			if err := b.Question(q); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	unique := dnsmessage.ClassINET | mdnsCacheFlush
+	if err := b.PTRResource(dnsmessage.ResourceHeader{Name: service, Class: dnsmessage.ClassINET, TTL: mdnsTTL},
+		dnsmessage.PTRResource{PTR: instance}); err != nil {
+		return nil, err
+	}
+	if err := b.SRVResource(dnsmessage.ResourceHeader{Name: instance, Class: unique, TTL: mdnsTTL},
+		dnsmessage.SRVResource{Port: uint16(port), Target: target}); err != nil {
+		return nil, err
+	}
+	if err := b.TXTResource(dnsmessage.ResourceHeader{Name: instance, Class: unique, TTL: mdnsTTL},
+		dnsmessage.TXTResource{TXT: []string{mdnsTXTAddrKey + r.addr}}); err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host).To4(); ip != nil {
+		var a dnsmessage.AResource
+		copy(a.A[:], ip)
+		if err := b.AResource(dnsmessage.ResourceHeader{Name: target, Class: unique, TTL: mdnsTTL}, a); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
@@ -0,0 +1,113 @@
+// Package fanout encodes a publish once for all the subscribers it's delivered to: the writes
+// share the encoded topic and the payload, only the fixed header and the packet id are written
+// per subscriber.
+package fanout
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+
+	"awesomeProject/beacon/mqtt_network/libs/netbuf"
+)
+
+const publishType = 0x30
+
+// Message is the immutable part of a publish shared by its deliveries. A delivery whose topic or
+// payload has been changed (by a transform, a downgrade envelope, ...) doesn't share it anymore,
+// it's encoded on its own.
+type Message struct {
+	topic   string
+	payload []byte
+
+	// The topic with its length prefix
+	encodedTopic []byte
+}
+
+func NewMessage(topic string, payload []byte) *Message {
+	encoded := make([]byte, 2+len(topic))
+	binary.BigEndian.PutUint16(encoded, uint16(len(topic)))
+	copy(encoded[2:], topic)
+	return &Message{topic: topic, payload: payload, encodedTopic: encoded}
+}
+
+// Shares reports whether the delivery still carries the topic and the very payload of the message.
+func (m *Message) Shares(topic string, payload []byte) bool {
+	if topic != m.topic || len(payload) != len(m.payload) {
+		return false
+	}
+	return len(payload) == 0 || &payload[0] == &m.payload[0]
+}
+
+// Header holds the fields of a delivery, V5 writes the empty properties of a 5.0 PUBLISH.
+type Header struct {
+	Qos       byte
+	Retain    bool
+	Dup       bool
+	MessageID uint16
+	V5        bool
+}
+
+// WriteTo writes the PUBLISH of the delivery, the shared parts are handed to w as they are, in
+// one system call if it's a network connection.
+func (m *Message) WriteTo(w io.Writer, h Header) (int64, error) {
+	var head [5]byte
+	var mid [3]byte
+	l, n := m.header(h, &head, &mid)
+
+	bufs := net.Buffers{head[:l], m.encodedTopic, mid[:n], m.payload}
+	return bufs.WriteTo(w)
+}
+
+// WriteBuffered adds the PUBLISH of the delivery to the pending bytes of the writer, the payload
+// is not copied. It returns the size of the PUBLISH.
+func (m *Message) WriteBuffered(w *netbuf.Writer, h Header) int {
+	var head [5]byte
+	var mid [3]byte
+	l, n := m.header(h, &head, &mid)
+
+	_, _ = w.Write(head[:l])
+	_, _ = w.Write(m.encodedTopic)
+	_, _ = w.Write(mid[:n])
+	w.WriteShared(m.payload)
+	return l + len(m.encodedTopic) + n + len(m.payload)
+}
+
+// header encodes the fixed header of the delivery, and the packet id and the properties which
+// follow the topic. It returns their lengths.
+func (m *Message) header(h Header, head *[5]byte, mid *[3]byte) (int, int) {
+	head[0] = publishType | h.Qos<<1
+	if h.Dup {
+		head[0] |= 0x08
+	}
+	if h.Retain {
+		head[0] |= 0x01
+	}
+
+	n := 0
+	if h.Qos > 0 {
+		binary.BigEndian.PutUint16(mid[:], h.MessageID)
+		n = 2
+	}
+	if h.V5 {
+		mid[n] = 0
+		n++
+	}
+
+	// the remaining length is a variable byte integer
+	remaining := len(m.encodedTopic) + n + len(m.payload)
+	l := 1
+	for {
+		b := byte(remaining % 128)
+		remaining /= 128
+		if remaining > 0 {
+			b |= 0x80
+		}
+		head[l] = b
+		l++
+		if remaining == 0 {
+			break
+		}
+	}
+	return l, n
+}
@@ -0,0 +1,143 @@
+package fanout
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"awesomeProject/beacon/mqtt_network/libs/mqtt5"
+	"awesomeProject/beacon/mqtt_network/libs/netbuf"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteTo(t *testing.T) {
+	payload := bytes.Repeat([]byte("21.5;"), 40)
+	m := NewMessage("sensors/s1/temp", payload)
+
+	require.True(t, m.Shares("sensors/s1/temp", payload))
+	require.False(t, m.Shares("sensors/s1/temp", append([]byte(nil), payload...)))
+	require.False(t, m.Shares("sensors/s2/temp", payload))
+
+	for _, h := range []Header{
+		{},
+		{Qos: 1, MessageID: 7, Dup: true},
+		{Qos: 2, MessageID: 300, Retain: true},
+		{Qos: 1, MessageID: 9, V5: true},
+		{V5: true},
+	} {
+		publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		publish.TopicName = "sensors/s1/temp"
+		publish.Payload = payload
+		publish.Qos = h.Qos
+		publish.Retain = h.Retain
+		publish.Dup = h.Dup
+		publish.MessageID = h.MessageID
+
+		var want bytes.Buffer
+		if h.V5 {
+			require.NoError(t, mqtt5.Write(&want, &mqtt5.Packet{Control: publish}))
+		} else {
+			require.NoError(t, publish.Write(&want))
+		}
+
+		var got bytes.Buffer
+		n, err := m.WriteTo(&got, h)
+		require.NoError(t, err)
+		require.Equal(t, int64(want.Len()), n)
+		require.Equal(t, want.Bytes(), got.Bytes(), "header %+v", h)
+
+		var w netbuf.Writer
+		require.Equal(t, want.Len(), m.WriteBuffered(&w, h))
+		got.Reset()
+		_, err = w.Flush(&got)
+		require.NoError(t, err)
+		require.Equal(t, want.Bytes(), got.Bytes(), "buffered header %+v", h)
+	}
+}
+
+func tcpPair(tb testing.TB) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(tb, err)
+	defer l.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+	client, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(tb, err)
+	server := <-accepted
+	require.NotNil(tb, server)
+	return server, client
+}
+
+// BenchmarkFanout10k delivers each publish of one publisher to 10k subscribers, multiplexed over
+// loopback connections: encoded by paho for each subscriber, encoded once and written with one
+// system call per delivery, and gathered by the writer of each subscriber into one vectored write
+// per burst of publishes, as the outbound queue of a subscriber does once it holds the burst.
+func BenchmarkFanout10k(b *testing.B) {
+	const (
+		subscribers = 10000
+		conns       = 100
+		burst       = 16
+	)
+	payload := bytes.Repeat([]byte("21.5;"), 50)
+
+	for _, bc := range []struct {
+		name    string
+		deliver func(w io.Writer, sw *netbuf.Writer, m *Message, p *packets.PublishPacket) error
+		flush   bool
+	}{
+		{"paho", func(w io.Writer, _ *netbuf.Writer, _ *Message, p *packets.PublishPacket) error {
+			return p.Write(w)
+		}, false},
+		{"shared", func(w io.Writer, _ *netbuf.Writer, m *Message, p *packets.PublishPacket) error {
+			_, err := m.WriteTo(w, Header{Qos: p.Qos, MessageID: p.MessageID})
+			return err
+		}, false},
+		{"batched", func(_ io.Writer, sw *netbuf.Writer, m *Message, p *packets.PublishPacket) error {
+			m.WriteBuffered(sw, Header{Qos: p.Qos, MessageID: p.MessageID})
+			return nil
+		}, true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var clients []net.Conn
+			for i := 0; i < conns; i++ {
+				server, client := tcpPair(b)
+				go func() { _, _ = io.Copy(ioutil.Discard, server) }()
+				defer server.Close()
+				defer client.Close()
+				clients = append(clients, client)
+			}
+			writers := make([]netbuf.Writer, subscribers)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+				p.TopicName = "sensors/s1/temp"
+				p.Payload = payload
+				p.Qos = 1
+				p.MessageID = uint16(i)
+				m := NewMessage(p.TopicName, p.Payload)
+				for s := 0; s < subscribers; s++ {
+					if err := bc.deliver(clients[s%conns], &writers[s], m, p); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if bc.flush && (i+1)%burst == 0 {
+					for s := range writers {
+						if _, err := writers[s].Flush(clients[s%conns]); err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+			}
+		})
+	}
+}
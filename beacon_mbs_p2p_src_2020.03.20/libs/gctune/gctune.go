@@ -0,0 +1,174 @@
+// Package gctune paces the garbage collector under a soft memory limit: the GOGC is lowered as the
+// live heap grows toward the limit, so the heap stays under it without collecting too often while
+// it's far from it. The pauses of the collections are kept in a histogram.
+package gctune
+
+import (
+	"errors"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/latency"
+)
+
+const (
+	defaultGOGC    = 100
+	defaultMinGOGC = 10
+
+	// The runtime keeps the pauses of the last 256 collections
+	pauseRing = 256
+)
+
+type Config struct {
+	// GOGC is the percent when the heap is far from the soft limit, 100 if it's 0
+	GOGC int `json:"gogc"`
+	// MinGOGC bounds the percent near the soft limit, 10 if it's 0
+	MinGOGC int `json:"min_gogc"`
+	// SoftLimit is the heap in bytes the collections are paced to stay under, 0 keeps the GOGC
+	SoftLimit uint64 `json:"soft_limit"`
+}
+
+func (c *Config) Validate() error {
+	if c.GOGC < 0 || c.MinGOGC < 0 {
+		return errors.New("gctune/gctune/Validate: the GOGC cannot be negative")
+	}
+	if c.GOGC > 0 && c.MinGOGC > c.GOGC {
+		return errors.New("gctune/gctune/Validate: the min GOGC is above the GOGC")
+	}
+	return nil
+}
+
+func (c Config) withDefaults() Config {
+	if c.GOGC == 0 {
+		c.GOGC = defaultGOGC
+	}
+	if c.MinGOGC == 0 {
+		c.MinGOGC = defaultMinGOGC
+	}
+	if c.MinGOGC > c.GOGC {
+		c.MinGOGC = c.GOGC
+	}
+	return c
+}
+
+// Percent returns the GOGC keeping the target of the next collection, live*(1+GOGC/100), under
+// the soft limit, between the min GOGC and the GOGC.
+func Percent(cfg Config, live uint64) int {
+	cfg = cfg.withDefaults()
+	if cfg.SoftLimit == 0 || live == 0 {
+		return cfg.GOGC
+	}
+	if live >= cfg.SoftLimit {
+		return cfg.MinGOGC
+	}
+
+	percent := (cfg.SoftLimit - live) * 100 / live
+	if percent > uint64(cfg.GOGC) {
+		return cfg.GOGC
+	}
+	if percent < uint64(cfg.MinGOGC) {
+		return cfg.MinGOGC
+	}
+	return int(percent)
+}
+
+type Stats struct {
+	GOGC int `json:"gogc"`
+	// Live is the larger of the heap live after the last collection and the estimate
+	Live      uint64 `json:"live"`
+	Estimate  uint64 `json:"estimate"`
+	HeapAlloc uint64 `json:"heap_alloc"`
+	NextGC    uint64 `json:"next_gc"`
+	NumGC     uint32 `json:"num_gc"`
+
+	PauseTotal time.Duration `json:"pause_total"`
+	// Pauses are the pauses of the collections since the tuner started
+	Pauses latency.Summary `json:"pauses"`
+}
+
+// Tuner sets the GOGC at each Tune from the heap and the estimate of the live memory held by the
+// application, such as its queues and its retained messages.
+type Tuner struct {
+	cfg      Config
+	estimate func() uint64
+
+	readMemStats func(*runtime.MemStats)
+	setGCPercent func(int) int
+
+	mu     sync.Mutex
+	tuned  bool
+	gogc   int
+	numGC  uint32
+	pauses *latency.Histogram
+	stats  Stats
+}
+
+// New returns the tuner, estimate may be nil. The GOGC is set on the first Tune.
+func New(cfg Config, estimate func() uint64) *Tuner {
+	cfg = cfg.withDefaults()
+	return &Tuner{
+		cfg:          cfg,
+		estimate:     estimate,
+		readMemStats: runtime.ReadMemStats,
+		setGCPercent: debug.SetGCPercent,
+		gogc:         cfg.GOGC,
+		pauses:       latency.NewHistogram(),
+	}
+}
+
+// Tune observes the pauses of the collections since the last call and sets the GOGC.
+func (t *Tuner) Tune() Stats {
+	var ms runtime.MemStats
+	t.readMemStats(&ms)
+
+	var estimate uint64
+	if t.estimate != nil {
+		estimate = t.estimate()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	first := t.numGC + 1
+	if ms.NumGC > pauseRing && first < ms.NumGC-pauseRing+1 {
+		first = ms.NumGC - pauseRing + 1
+	}
+	for i := first; i <= ms.NumGC; i++ {
+		t.pauses.Observe(time.Duration(ms.PauseNs[(i+pauseRing-1)%pauseRing]))
+	}
+	t.numGC = ms.NumGC
+
+	// the target of the next collection was set from the heap live after the last one
+	live := ms.NextGC * 100 / uint64(100+t.gogc)
+	if estimate > live {
+		live = estimate
+	}
+
+	gogc := Percent(t.cfg, live)
+	if gogc != t.gogc || !t.tuned {
+		t.setGCPercent(gogc)
+		t.gogc = gogc
+		t.tuned = true
+	}
+
+	t.stats = Stats{
+		GOGC:       t.gogc,
+		Live:       live,
+		Estimate:   estimate,
+		HeapAlloc:  ms.HeapAlloc,
+		NextGC:     ms.NextGC,
+		NumGC:      ms.NumGC,
+		PauseTotal: time.Duration(ms.PauseTotalNs),
+		Pauses:     t.pauses.Summary(),
+	}
+	return t.stats
+}
+
+// Stats returns the stats of the last Tune.
+func (t *Tuner) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats
+}
@@ -0,0 +1,52 @@
+package gctune
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPercent(t *testing.T) {
+	cfg := Config{SoftLimit: 1000}
+	require.Equal(t, 100, Percent(Config{}, 500))
+	require.Equal(t, 100, Percent(cfg, 400))
+	require.Equal(t, 66, Percent(cfg, 600))
+	require.Equal(t, 10, Percent(cfg, 950))
+	require.Equal(t, 10, Percent(cfg, 2000))
+	require.Equal(t, 50, Percent(Config{GOGC: 50, MinGOGC: 20, SoftLimit: 1000}, 100))
+
+	require.Error(t, (&Config{MinGOGC: 50, GOGC: 20}).Validate())
+	require.NoError(t, (&Config{SoftLimit: 1 << 30}).Validate())
+}
+
+func TestTuner(t *testing.T) {
+	ms := runtime.MemStats{NextGC: 1200, NumGC: 2}
+	ms.PauseNs[0] = uint64(time.Millisecond)
+	ms.PauseNs[1] = uint64(3 * time.Millisecond)
+
+	var estimate uint64
+	var set []int
+	tuner := New(Config{SoftLimit: 1000}, func() uint64 { return estimate })
+	tuner.readMemStats = func(m *runtime.MemStats) { *m = ms }
+	tuner.setGCPercent = func(percent int) int {
+		set = append(set, percent)
+		return 0
+	}
+
+	s := tuner.Tune()
+	require.Equal(t, uint64(600), s.Live)
+	require.Equal(t, 66, s.GOGC)
+	require.Equal(t, uint64(2), s.Pauses.Count)
+	require.Equal(t, []int{66}, set)
+
+	// the estimate above the live heap wins, the pauses are observed once
+	estimate = 900
+	s = tuner.Tune()
+	require.Equal(t, uint64(900), s.Live)
+	require.Equal(t, 11, s.GOGC)
+	require.Equal(t, uint64(2), s.Pauses.Count)
+	require.Equal(t, []int{66, 11}, set)
+	require.Equal(t, s, tuner.Stats())
+}
@@ -0,0 +1,184 @@
+// Package handoff passes the listening sockets to a new process of the broker, so an upgrade
+// doesn't refuse the connections while the new process starts: the old process starts the new
+// binary with the listeners as extra files, waits until it's ready, then stops accepting.
+package handoff
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	envListenFDs   = "BEACON_LISTEN_FDS"
+	envListenNames = "BEACON_LISTEN_NAMES"
+	envReadyFD     = "BEACON_READY_FD"
+
+	// The first extra file of a child process.
+	firstFD = 3
+)
+
+var (
+	inheritedOnce sync.Once
+	inherited     map[string]net.Listener
+	inheritedErr  error
+)
+
+type filer interface {
+	File() (*os.File, error)
+}
+
+// Inherited returns the listeners passed by the parent process keyed by name, the map is empty
+// if the process was not started by Upgrade.
+func Inherited() (map[string]net.Listener, error) {
+	inheritedOnce.Do(func() {
+		inherited = make(map[string]net.Listener)
+
+		count, err := strconv.Atoi(os.Getenv(envListenFDs))
+		if err != nil || count <= 0 {
+			return
+		}
+		names := strings.Split(os.Getenv(envListenNames), ",")
+		if len(names) != count {
+			inheritedErr = fmt.Errorf("handoff/handoff/Inherited: %d listeners for %d names", count, len(names))
+			return
+		}
+
+		for i, name := range names {
+			f := os.NewFile(uintptr(firstFD+i), name)
+			l, err := net.FileListener(f)
+			_ = f.Close()
+			if err != nil {
+				inheritedErr = fmt.Errorf("handoff/handoff/Inherited: listener %s => %v", name, err)
+				return
+			}
+			inherited[name] = l
+		}
+	})
+
+	return inherited, inheritedErr
+}
+
+// Listen returns the listener of the name inherited from the parent process, or listens on the
+// address with SO_REUSEPORT set (on Linux).
+func Listen(name string, network string, address string) (net.Listener, error) {
+	listeners, err := Inherited()
+	if err != nil {
+		return nil, err
+	}
+	if l, ok := listeners[name]; ok {
+		return l, nil
+	}
+
+	return listen(network, address)
+}
+
+// ListenPacket listens on the address with SO_REUSEPORT set (on Linux). The packet sockets are not
+// passed to the new process, it binds the address beside the current one.
+func ListenPacket(network string, address string) (net.PacketConn, error) {
+	return listenPacket(network, address)
+}
+
+// Ready tells the parent process that this process accepts the connections now, the parent
+// can stop accepting. Nothing is done if the process was not started by Upgrade.
+func Ready() error {
+	fd, err := strconv.Atoi(os.Getenv(envReadyFD))
+	if err != nil {
+		return nil
+	}
+	_ = os.Unsetenv(envReadyFD)
+
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+
+	_, err = f.Write([]byte{1})
+	return err
+}
+
+// Upgrade starts the current executable again with the same arguments and the listeners, and
+// waits until the new process called Ready. The listeners of the current process keep accepting
+// until Upgrade returns, the caller closes them then.
+func Upgrade(listeners map[string]net.Listener, timeout time.Duration) (*os.Process, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(listeners))
+	files := make([]*os.File, 0, len(listeners)+1)
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+
+	for name, l := range listeners {
+		fl, ok := l.(filer)
+		if !ok {
+			return nil, fmt.Errorf("handoff/handoff/Upgrade: listener %s cannot be passed", name)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		files = append(files, f)
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyR.Close()
+	files = append(files, readyW)
+
+	env := make([]string, 0, len(os.Environ())+3)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envListenFDs+"=") && !strings.HasPrefix(kv, envListenNames+"=") && !strings.HasPrefix(kv, envReadyFD+"=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env,
+		envListenFDs+"="+strconv.Itoa(len(names)),
+		envListenNames+"="+strings.Join(names, ","),
+		envReadyFD+"="+strconv.Itoa(firstFD+len(names)),
+	)
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	// Close the writer of this process, so the read fails if the new process exits before ready.
+	_ = readyW.Close()
+	files = files[:len(files)-1]
+
+	ready := make(chan error, 1)
+	go func() {
+		b := make([]byte, 1)
+		_, err := readyR.Read(b)
+		ready <- err
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			_ = cmd.Process.Kill()
+			return nil, fmt.Errorf("handoff/handoff/Upgrade: new process exited before ready => %v", err)
+		}
+		return cmd.Process, nil
+	case <-time.After(timeout):
+		_ = cmd.Process.Kill()
+		return nil, errors.New("handoff/handoff/Upgrade: timed out waiting for the new process")
+	}
+}
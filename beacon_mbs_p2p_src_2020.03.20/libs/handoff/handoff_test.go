@@ -0,0 +1,34 @@
+package handoff
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenWithoutParent(t *testing.T) {
+	listeners, err := Inherited()
+	require.NoError(t, err)
+	require.Len(t, listeners, 0)
+
+	l, err := Listen("mqtt", "tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	// the listener can be passed to the new process
+	_, ok := l.(filer)
+	require.True(t, ok)
+
+	require.NoError(t, Ready())
+}
+
+type fakeListener struct {
+	net.Listener
+}
+
+func TestUpgradeInvalidListener(t *testing.T) {
+	_, err := Upgrade(map[string]net.Listener{"mqtt": fakeListener{}}, time.Second)
+	require.Error(t, err)
+}
@@ -0,0 +1,33 @@
+package handoff
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// SO_REUSEPORT of Linux, not defined by the syscall package.
+const soReusePort = 0xf
+
+// SO_REUSEPORT lets the new process bind the same address too, if it's started by hand instead
+// of by Upgrade.
+var reusePort = net.ListenConfig{
+	Control: func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	},
+}
+
+func listen(network string, address string) (net.Listener, error) {
+	return reusePort.Listen(context.Background(), network, address)
+}
+
+func listenPacket(network string, address string) (net.PacketConn, error) {
+	return reusePort.ListenPacket(context.Background(), network, address)
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package handoff
+
+import (
+	"net"
+)
+
+func listen(network string, address string) (net.Listener, error) {
+	return net.Listen(network, address)
+}
+
+func listenPacket(network string, address string) (net.PacketConn, error) {
+	return net.ListenPacket(network, address)
+}
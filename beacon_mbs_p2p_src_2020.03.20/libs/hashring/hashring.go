@@ -0,0 +1,78 @@
+// Package hashring maps keys to the members of a cluster by consistent hashing, a change of the
+// members only moves the keys of the added or the removed ones.
+package hashring
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+const DefaultReplicas = 64
+
+// Ring holds the points of the members on the ring, each member has replicas points spreading its
+// keys. A ring is immutable, a new one is built when the members change.
+type Ring struct {
+	points  []uint32
+	owners  map[uint32]string
+	members []string
+}
+
+// New returns the ring of the members, replicas is DefaultReplicas if it's not positive. The
+// rings of the same members are the same whatever their order.
+func New(replicas int, members ...string) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+
+	r := &Ring{owners: make(map[uint32]string, replicas*len(members))}
+	seen := make(map[string]bool, len(members))
+	for _, m := range members {
+		if seen[m] {
+			continue
+		}
+		seen[m] = true
+		r.members = append(r.members, m)
+
+		for i := 0; i < replicas; i++ {
+			p := hash(m + "#" + strconv.Itoa(i))
+			// a point of two members goes to the lowest one, on every node
+			if owner, ok := r.owners[p]; ok {
+				if owner < m {
+					continue
+				}
+			} else {
+				r.points = append(r.points, p)
+			}
+			r.owners[p] = m
+		}
+	}
+	sort.Strings(r.members)
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// Owner returns the member owning the key, the one of the first point from the hash of the key
+// clockwise. It's empty if the ring has no member.
+func (r *Ring) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// Members returns the members of the ring, sorted.
+func (r *Ring) Members() []string {
+	return r.members
+}
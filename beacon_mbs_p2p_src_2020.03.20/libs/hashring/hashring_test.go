@@ -0,0 +1,37 @@
+package hashring
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRing(t *testing.T) {
+	require.Equal(t, "", New(0).Owner("dev1"))
+
+	r := New(0, "b1", "b2", "b3")
+	require.Equal(t, []string{"b1", "b2", "b3"}, r.Members())
+	require.Equal(t, r.Owner("dev1"), New(0, "b3", "b1", "b2", "b1").Owner("dev1"))
+
+	owners := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := "dev" + strconv.Itoa(i)
+		owners[key] = r.Owner(key)
+		counts[owners[key]]++
+	}
+	for _, m := range r.Members() {
+		require.True(t, counts[m] > 500, "member %s owns %d keys", m, counts[m])
+	}
+
+	// only the keys of the removed member move
+	r = New(0, "b1", "b3")
+	for key, owner := range owners {
+		if owner != "b2" {
+			require.Equal(t, owner, r.Owner(key))
+		} else {
+			require.NotEqual(t, "b2", r.Owner(key))
+		}
+	}
+}
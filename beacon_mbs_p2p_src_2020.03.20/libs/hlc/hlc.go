@@ -0,0 +1,137 @@
+// Package hlc is a hybrid logical clock: the timestamps follow the wall clock, and a logical
+// counter orders the events within the same wall time or behind a timestamp received from a node
+// whose clock is ahead. The timestamps of the nodes are totally ordered, the node id breaking the
+// ties, so they can decide which of two concurrent writes wins.
+package hlc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+)
+
+const defaultMaxOffset = time.Minute
+
+var ErrClockOffset = errors.New("hlc: the remote timestamp is too far ahead of the wall clock")
+
+type Timestamp struct {
+	// Wall is the wall time in nanoseconds since the epoch.
+	Wall    int64  `json:"wall"`
+	Logical uint32 `json:"logical"`
+	Node    string `json:"node"`
+}
+
+// Compare returns -1, 0 or +1 if t is before, the same as or after o.
+func (t Timestamp) Compare(o Timestamp) int {
+	switch {
+	case t.Wall != o.Wall:
+		if t.Wall < o.Wall {
+			return -1
+		}
+		return 1
+	case t.Logical != o.Logical:
+		if t.Logical < o.Logical {
+			return -1
+		}
+		return 1
+	case t.Node != o.Node:
+		if t.Node < o.Node {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+func (t Timestamp) Less(o Timestamp) bool {
+	return t.Compare(o) < 0
+}
+
+func (t Timestamp) IsZero() bool {
+	return t.Wall == 0 && t.Logical == 0
+}
+
+func (t Timestamp) Time() time.Time {
+	return time.Unix(0, t.Wall)
+}
+
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%d.%d@%s", t.Wall, t.Logical, t.Node)
+}
+
+type Clock struct {
+	mu        sync.Mutex
+	node      string
+	wall      clock.Clock
+	maxOffset time.Duration
+	last      Timestamp
+}
+
+// New returns the clock of the node, the wall clock is used if c is nil. The remote timestamps
+// more than maxOffset ahead of the wall clock are refused, 1 minute if it's 0.
+func New(node string, c clock.Clock, maxOffset time.Duration) *Clock {
+	if maxOffset <= 0 {
+		maxOffset = defaultMaxOffset
+	}
+	return &Clock{
+		node:      node,
+		wall:      clock.OrReal(c),
+		maxOffset: maxOffset,
+		last:      Timestamp{Node: node},
+	}
+}
+
+// Now returns the timestamp of a local event, after all the timestamps returned or seen so far.
+func (c *Clock) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pt := c.wall.Now().UnixNano()
+	if pt > c.last.Wall {
+		c.last.Wall = pt
+		c.last.Logical = 0
+	} else {
+		c.last.Logical++
+	}
+	return c.last
+}
+
+// Update moves the clock past a timestamp received from another node, and returns the timestamp
+// of the receipt.
+func (c *Clock) Update(remote Timestamp) (Timestamp, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pt := c.wall.Now().UnixNano()
+	if time.Duration(remote.Wall-pt) > c.maxOffset {
+		return c.last, ErrClockOffset
+	}
+
+	wall := pt
+	if c.last.Wall > wall {
+		wall = c.last.Wall
+	}
+	if remote.Wall > wall {
+		wall = remote.Wall
+	}
+
+	switch {
+	case wall == c.last.Wall && wall == remote.Wall:
+		logical := c.last.Logical
+		if remote.Logical > logical {
+			logical = remote.Logical
+		}
+		c.last.Logical = logical + 1
+	case wall == c.last.Wall:
+		c.last.Logical++
+	case wall == remote.Wall:
+		c.last.Logical = remote.Logical + 1
+	default:
+		c.last.Logical = 0
+	}
+	c.last.Wall = wall
+	return c.last, nil
+}
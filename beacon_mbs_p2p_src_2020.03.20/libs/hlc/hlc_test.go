@@ -0,0 +1,59 @@
+package hlc
+
+import (
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNow(t *testing.T) {
+	mock := clock.NewMock(time.Unix(1584662400, 0))
+	c := New("a", mock, 0)
+
+	t1 := c.Now()
+	t2 := c.Now()
+	require.Equal(t, t1.Wall, t2.Wall)
+	require.Equal(t, t1.Logical+1, t2.Logical)
+	require.True(t, t1.Less(t2))
+
+	mock.Add(time.Millisecond)
+	t3 := c.Now()
+	require.Equal(t, uint32(0), t3.Logical)
+	require.True(t, t2.Less(t3))
+
+	// the wall clock going back does not move the timestamps back
+	mock.Add(-time.Second)
+	t4 := c.Now()
+	require.Equal(t, t3.Wall, t4.Wall)
+	require.True(t, t3.Less(t4))
+}
+
+func TestUpdate(t *testing.T) {
+	mock := clock.NewMock(time.Unix(1584662400, 0))
+	a := New("a", mock, 0)
+	b := New("b", mock, 0)
+
+	// the remote clock is ahead, its timestamp is followed
+	remote := Timestamp{Wall: mock.Now().Add(10 * time.Second).UnixNano(), Logical: 3, Node: "b"}
+	got, err := a.Update(remote)
+	require.NoError(t, err)
+	require.Equal(t, remote.Wall, got.Wall)
+	require.Equal(t, uint32(4), got.Logical)
+	require.True(t, remote.Less(a.Now()))
+
+	// a timestamp too far ahead is refused
+	_, err = a.Update(Timestamp{Wall: mock.Now().Add(2 * time.Minute).UnixNano(), Node: "b"})
+	require.Equal(t, ErrClockOffset, err)
+
+	// the same wall time and logical counter are ordered by the node
+	ta := Timestamp{Wall: 1, Logical: 1, Node: "a"}
+	tb := Timestamp{Wall: 1, Logical: 1, Node: "b"}
+	require.Equal(t, -1, ta.Compare(tb))
+	require.Equal(t, 1, tb.Compare(ta))
+	require.Equal(t, 0, ta.Compare(ta))
+
+	require.True(t, b.Now().Less(a.Now()))
+}
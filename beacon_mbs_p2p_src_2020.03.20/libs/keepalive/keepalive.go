@@ -0,0 +1,247 @@
+// Package keepalive closes the dead connections: the ones silent for 1.5 times their keepalive
+// [MQTT-3.1.2-24], and the ones idle past an absolute timeout. The connections are checked by a
+// few timer wheels shared by all of them, instead of a timer per connection, so the idle
+// connections stay cheap; the checks are jittered so the connections opened together are not all
+// checked on the same tick.
+package keepalive
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+)
+
+const (
+	defaultResolution = time.Second
+	wheelSlots        = 512
+	wheelShards       = 16
+)
+
+// Reason is why a connection expired.
+type Reason string
+
+const (
+	// Keepalive is a connection silent for 1.5 times its keepalive
+	Keepalive Reason = "keepalive"
+	// Idle is a connection which sent nothing but PINGREQ for the idle timeout
+	Idle Reason = "idle"
+)
+
+type Config struct {
+	// IdleTimeout closes the connections which sent nothing but PINGREQ for the duration, the
+	// ones without a keepalive too; 0 disables it.
+	IdleTimeout time.Duration `json:"idle_timeout" yaml:"idle_timeout"`
+	// Resolution is the tick of the wheels, the connections expire up to a tick late. 1s if
+	// it's 0.
+	Resolution time.Duration `json:"resolution" yaml:"resolution"`
+	// Jitter delays each check by a random duration up to it, the resolution if it's 0.
+	Jitter time.Duration `json:"jitter" yaml:"jitter"`
+}
+
+func (c Config) Validate() error {
+	if c.IdleTimeout < 0 || c.Resolution < 0 || c.Jitter < 0 {
+		return errors.New("keepalive/keepalive/Validate: the durations cannot be negative")
+	}
+	return nil
+}
+
+// Entry is a connection checked by the wheels.
+type Entry struct {
+	w         *Wheels
+	keepalive time.Duration
+	idle      time.Duration
+	expire    func(Reason)
+
+	// the unix nanoseconds of the last packet and of the last packet other than PINGREQ
+	seen    int64
+	active  int64
+	removed int32
+
+	rounds int
+}
+
+// Touch notes a packet, or a part of it, read from the connection; active is false for a
+// PINGREQ.
+func (e *Entry) Touch(active bool) {
+	if e == nil {
+		return
+	}
+	now := e.w.clock.Now().UnixNano()
+	atomic.StoreInt64(&e.seen, now)
+	if active {
+		atomic.StoreInt64(&e.active, now)
+	}
+}
+
+// Remove stops checking the connection, it's dropped from its wheel on its next check.
+func (e *Entry) Remove() {
+	if e == nil {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&e.removed, 0, 1) {
+		atomic.AddInt64(&e.w.count, -1)
+	}
+}
+
+// due returns the time the connection expires if it's silent until then, and why.
+func (e *Entry) due() (int64, Reason) {
+	at, reason := int64(0), Keepalive
+	if e.keepalive > 0 {
+		at = atomic.LoadInt64(&e.seen) + int64(e.keepalive)
+	}
+	if e.idle > 0 {
+		if idle := atomic.LoadInt64(&e.active) + int64(e.idle); at == 0 || idle < at {
+			at, reason = idle, Idle
+		}
+	}
+	return at, reason
+}
+
+type wheel struct {
+	mu     sync.Mutex
+	slots  [wheelSlots][]*Entry
+	cursor int
+	rand   *rand.Rand
+}
+
+// Wheels are the timer wheels checking the connections, the connections are spread across them so
+// the publishers touching the entries and the checks don't contend on a single lock.
+type Wheels struct {
+	clock      clock.Clock
+	resolution time.Duration
+	jitter     time.Duration
+	idle       time.Duration
+
+	next   uint32
+	shards [wheelShards]*wheel
+	count  int64
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// New returns the wheels, Start ticks them.
+func New(cfg Config, c clock.Clock) *Wheels {
+	if cfg.Resolution == 0 {
+		cfg.Resolution = defaultResolution
+	}
+	if cfg.Jitter == 0 {
+		cfg.Jitter = cfg.Resolution
+	}
+	w := &Wheels{
+		clock:      clock.OrReal(c),
+		resolution: cfg.Resolution,
+		jitter:     cfg.Jitter,
+		idle:       cfg.IdleTimeout,
+		stop:       make(chan struct{}),
+	}
+	seed := w.clock.Now().UnixNano()
+	for i := range w.shards {
+		w.shards[i] = &wheel{rand: rand.New(rand.NewSource(seed + int64(i)))}
+	}
+	return w
+}
+
+// Start ticks the wheels until Stop.
+func (w *Wheels) Start() {
+	go func() {
+		ticker := w.clock.NewTicker(w.resolution)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C():
+				w.tick()
+			}
+		}
+	}()
+}
+
+func (w *Wheels) Stop() {
+	w.once.Do(func() { close(w.stop) })
+}
+
+// Add checks the connection, expire is called once, on the goroutine of the wheels, if it's
+// silent for 1.5 times its keepalive or idle for the idle timeout. It returns nil if neither
+// applies.
+func (w *Wheels) Add(keepalive time.Duration, expire func(Reason)) *Entry {
+	e := &Entry{w: w, keepalive: keepalive + keepalive/2, idle: w.idle, expire: expire}
+	if e.keepalive == 0 && e.idle == 0 {
+		return nil
+	}
+	e.Touch(true)
+	atomic.AddInt64(&w.count, 1)
+
+	shard := w.shards[atomic.AddUint32(&w.next, 1)%wheelShards]
+	at, _ := e.due()
+	shard.mu.Lock()
+	w.schedule(shard, e, at)
+	shard.mu.Unlock()
+	return e
+}
+
+// Len returns the number of the connections checked.
+func (w *Wheels) Len() int {
+	return int(atomic.LoadInt64(&w.count))
+}
+
+// schedule puts the entry in the slot of the jittered time it's due, it's never checked early.
+func (w *Wheels) schedule(shard *wheel, e *Entry, at int64) {
+	d := time.Duration(at - w.clock.Now().UnixNano())
+	if w.jitter > 0 {
+		d += time.Duration(shard.rand.Int63n(int64(w.jitter)))
+	}
+	ticks := int((d + w.resolution - 1) / w.resolution)
+	if ticks < 1 {
+		ticks = 1
+	}
+	e.rounds = (ticks - 1) / wheelSlots
+	slot := (shard.cursor + ticks) % wheelSlots
+	shard.slots[slot] = append(shard.slots[slot], e)
+}
+
+// tick moves the wheels a slot forward, and checks the connections of the slots.
+func (w *Wheels) tick() {
+	now := w.clock.Now().UnixNano()
+	var expired []*Entry
+	var reasons []Reason
+
+	for _, shard := range w.shards {
+		shard.mu.Lock()
+		shard.cursor = (shard.cursor + 1) % wheelSlots
+		list := shard.slots[shard.cursor]
+		shard.slots[shard.cursor] = nil
+		for _, e := range list {
+			if atomic.LoadInt32(&e.removed) == 1 {
+				continue
+			}
+			if e.rounds > 0 {
+				e.rounds--
+				shard.slots[shard.cursor] = append(shard.slots[shard.cursor], e)
+				continue
+			}
+			at, reason := e.due()
+			if at > now {
+				w.schedule(shard, e, at)
+				continue
+			}
+			if !atomic.CompareAndSwapInt32(&e.removed, 0, 1) {
+				continue
+			}
+			atomic.AddInt64(&w.count, -1)
+			expired = append(expired, e)
+			reasons = append(reasons, reason)
+		}
+		shard.mu.Unlock()
+	}
+
+	for i, e := range expired {
+		e.expire(reasons[i])
+	}
+}
@@ -0,0 +1,75 @@
+package keepalive
+
+import (
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+
+	"github.com/stretchr/testify/require"
+)
+
+// advance moves the clock by the duration a tick at a time.
+func advance(mock *clock.Mock, w *Wheels, d time.Duration) {
+	for end := mock.Now().Add(d); mock.Now().Before(end); {
+		mock.Add(w.resolution)
+		w.tick()
+	}
+}
+
+func TestWheelsExpire(t *testing.T) {
+	mock := clock.NewMock(time.Unix(1584662400, 0))
+	w := New(Config{IdleTimeout: time.Minute, Resolution: time.Second, Jitter: time.Second}, mock)
+
+	expired := make(map[string]Reason)
+	add := func(name string, keepalive time.Duration) *Entry {
+		return w.Add(keepalive, func(r Reason) { expired[name] = r })
+	}
+
+	silent := add("silent", 10*time.Second)
+	pinging := add("pinging", 10*time.Second)
+	active := add("active", 10*time.Second)
+	idle := add("idle", 0)
+	require.NotNil(t, silent)
+	require.NotNil(t, idle)
+	require.Equal(t, 4, w.Len())
+
+	// 1.5 times the keepalive or the idle timeout, up to a tick and the jitter late
+	for i := 0; i < 13; i++ {
+		advance(mock, w, 5*time.Second)
+		pinging.Touch(false)
+		active.Touch(true)
+	}
+	require.Equal(t, Keepalive, expired["silent"])
+	require.Equal(t, Idle, expired["pinging"])
+	require.Equal(t, Idle, expired["idle"])
+	require.NotContains(t, expired, "active")
+	require.Equal(t, 1, w.Len())
+
+	// the expired and the removed connections are not checked again
+	delete(expired, "silent")
+	active.Remove()
+	silent.Remove()
+	advance(mock, w, 2*time.Minute)
+	require.NotContains(t, expired, "active")
+	require.NotContains(t, expired, "silent")
+	require.Equal(t, 0, w.Len())
+
+	// no keepalive and no idle timeout
+	w = New(Config{}, mock)
+	require.Nil(t, w.Add(0, func(Reason) {}))
+}
+
+func TestWheelsRounds(t *testing.T) {
+	mock := clock.NewMock(time.Unix(1584662400, 0))
+	w := New(Config{Resolution: time.Millisecond, Jitter: time.Millisecond}, mock)
+
+	var reason Reason
+	w.Add(time.Second, func(r Reason) { reason = r })
+
+	// the deadline is past the slots of the wheel
+	advance(mock, w, 1499*time.Millisecond)
+	require.Empty(t, reason)
+	advance(mock, w, 3*time.Millisecond)
+	require.Equal(t, Keepalive, reason)
+}
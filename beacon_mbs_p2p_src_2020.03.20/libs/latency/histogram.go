@@ -0,0 +1,69 @@
+package latency
+
+import (
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+const (
+	histogramMax     = time.Minute
+	histogramSigfigs = 3
+)
+
+// Histogram counts every sample in an HDR histogram, unlike the Recorder the percentiles cover all
+// the samples since the last reset, at a fixed precision of 3 significant digits up to one minute.
+// The longer samples are counted as one minute.
+type Histogram struct {
+	mu sync.Mutex
+	h  *hdrhistogram.Histogram
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{
+		h: hdrhistogram.New(1, int64(histogramMax), histogramSigfigs),
+	}
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	if d < 1 {
+		d = 1
+	} else if d > histogramMax {
+		d = histogramMax
+	}
+
+	h.mu.Lock()
+	_ = h.h.RecordValue(int64(d))
+	h.mu.Unlock()
+}
+
+func (h *Histogram) Summary() Summary {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.summary()
+}
+
+// SummaryAndReset returns the summary of the samples and starts a new window.
+func (h *Histogram) SummaryAndReset() Summary {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.summary()
+	h.h.Reset()
+	return s
+}
+
+func (h *Histogram) summary() Summary {
+	s := Summary{Count: uint64(h.h.TotalCount())}
+	if s.Count == 0 {
+		return s
+	}
+
+	s.P50 = time.Duration(h.h.ValueAtQuantile(50))
+	s.P90 = time.Duration(h.h.ValueAtQuantile(90))
+	s.P99 = time.Duration(h.h.ValueAtQuantile(99))
+	s.Max = time.Duration(h.h.Max())
+	return s
+}
@@ -0,0 +1,80 @@
+package latency
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultSize = 256
+
+// Recorder keeps the latest samples in a ring, the percentiles are computed over them, so they
+// follow the recent behaviour rather than the whole lifetime.
+type Recorder struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	count   uint64
+}
+
+type Summary struct {
+	Count uint64        `json:"count"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+func NewRecorder(size int) *Recorder {
+	if size <= 0 {
+		size = defaultSize
+	}
+	return &Recorder{
+		samples: make([]time.Duration, 0, size),
+	}
+}
+
+func (r *Recorder) Observe(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.count++
+	if len(r.samples) < cap(r.samples) {
+		r.samples = append(r.samples, d)
+		return
+	}
+	r.samples[r.next] = d
+	r.next = (r.next + 1) % len(r.samples)
+}
+
+func (r *Recorder) Summary() Summary {
+	r.mu.Lock()
+	sorted := make([]time.Duration, len(r.samples))
+	copy(sorted, r.samples)
+	s := Summary{Count: r.count}
+	r.mu.Unlock()
+
+	if len(sorted) == 0 {
+		return s
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	s.P50 = percentile(sorted, 0.50)
+	s.P90 = percentile(sorted, 0.90)
+	s.P99 = percentile(sorted, 0.99)
+	s.Max = sorted[len(sorted)-1]
+
+	return s
+}
+
+// Nearest-rank percentile of the sorted samples.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(p*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
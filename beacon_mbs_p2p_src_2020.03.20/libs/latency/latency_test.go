@@ -0,0 +1,61 @@
+package latency
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorderSummary(t *testing.T) {
+	r := NewRecorder(100)
+	require.Equal(t, Summary{}, r.Summary())
+
+	for i := 1; i <= 100; i++ {
+		r.Observe(time.Duration(i) * time.Millisecond)
+	}
+
+	s := r.Summary()
+	require.Equal(t, uint64(100), s.Count)
+	require.Equal(t, 50*time.Millisecond, s.P50)
+	require.Equal(t, 90*time.Millisecond, s.P90)
+	require.Equal(t, 99*time.Millisecond, s.P99)
+	require.Equal(t, 100*time.Millisecond, s.Max)
+}
+
+func TestRecorderRing(t *testing.T) {
+	r := NewRecorder(10)
+
+	for i := 0; i < 10; i++ {
+		r.Observe(time.Second)
+	}
+	for i := 0; i < 10; i++ {
+		r.Observe(time.Millisecond)
+	}
+
+	// the old samples are overwritten
+	s := r.Summary()
+	require.Equal(t, uint64(20), s.Count)
+	require.Equal(t, time.Millisecond, s.Max)
+}
+
+func TestHistogramSummary(t *testing.T) {
+	h := NewHistogram()
+	require.Equal(t, Summary{}, h.Summary())
+
+	for i := 1; i <= 1000; i++ {
+		h.Observe(time.Duration(i) * time.Microsecond)
+	}
+	h.Observe(2 * time.Minute)
+
+	s := h.Summary()
+	require.Equal(t, uint64(1001), s.Count)
+	require.InEpsilon(t, float64(500*time.Microsecond), float64(s.P50), 0.01)
+	require.InEpsilon(t, float64(900*time.Microsecond), float64(s.P90), 0.01)
+	require.InEpsilon(t, float64(990*time.Microsecond), float64(s.P99), 0.01)
+	// the samples over the range are counted at the max
+	require.InEpsilon(t, float64(time.Minute), float64(s.Max), 0.01)
+
+	require.Equal(t, s, h.SummaryAndReset())
+	require.Equal(t, Summary{}, h.Summary())
+}
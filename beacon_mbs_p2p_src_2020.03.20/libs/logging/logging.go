@@ -0,0 +1,173 @@
+// Package logging gives each subsystem of the broker its own logger, named after it, with a level
+// changed at runtime apart from the others: the packets of a client may be logged at debug while
+// the rest of the broker stays at info. The subsystem loggers write to the core of the base logger,
+// any backend implementing zapcore.Core, such as an adapter of zerolog, plugs in there.
+//
+// The fields shared by the logs of the subsystems are named by the helpers of the package, so the
+// logs of a client or a topic are searched with one key.
+package logging
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// The keys of the shared fields
+const (
+	KeyClientID   = "client_id"
+	KeyTopic      = "topic"
+	KeyPacketType = "packet_type"
+	KeyPeer       = "peer"
+)
+
+func ClientID(id string) zap.Field {
+	return zap.String(KeyClientID, id)
+}
+
+func Topic(topic string) zap.Field {
+	return zap.String(KeyTopic, topic)
+}
+
+func PacketType(name string) zap.Field {
+	return zap.String(KeyPacketType, name)
+}
+
+func Peer(id string) zap.Field {
+	return zap.String(KeyPeer, id)
+}
+
+// Levels holds the loggers of the subsystems and their levels. A subsystem follows the level of the
+// base logger until its own level is set.
+type Levels struct {
+	base *zap.Logger
+
+	mu      sync.Mutex
+	levels  map[string]*subsystemLevel
+	loggers map[string]*zap.Logger
+}
+
+type subsystemLevel struct {
+	level zap.AtomicLevel
+	set   int32
+}
+
+func New(base *zap.Logger) *Levels {
+	return &Levels{
+		base:    base,
+		levels:  make(map[string]*subsystemLevel),
+		loggers: make(map[string]*zap.Logger),
+	}
+}
+
+// Logger returns the logger of the subsystem, the same one on each call.
+func (l *Levels) Logger(subsystem string) *zap.Logger {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if logger, ok := l.loggers[subsystem]; ok {
+		return logger
+	}
+	level := &subsystemLevel{level: zap.NewAtomicLevel()}
+	logger := l.base.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &levelCore{Core: core, level: level}
+	})).Named(subsystem)
+	l.levels[subsystem] = level
+	l.loggers[subsystem] = logger
+	return logger
+}
+
+// SetLevel changes the level of the subsystem, it fails if it has no logger.
+func (l *Levels) SetLevel(subsystem string, level zapcore.Level) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s, ok := l.levels[subsystem]
+	if !ok {
+		return fmt.Errorf("logging/logging/SetLevel: unknown subsystem %q", subsystem)
+	}
+	s.level.SetLevel(level)
+	atomic.StoreInt32(&s.set, 1)
+	return nil
+}
+
+// Reset has the subsystem follow the level of the base logger again.
+func (l *Levels) Reset(subsystem string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if s, ok := l.levels[subsystem]; ok {
+		atomic.StoreInt32(&s.set, 0)
+	}
+}
+
+// Levels returns the level of each subsystem, by name.
+func (l *Levels) Levels() map[string]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	levels := make(map[string]string, len(l.levels))
+	for name, s := range l.levels {
+		if atomic.LoadInt32(&s.set) == 1 {
+			levels[name] = s.level.Level().String()
+		} else {
+			levels[name] = baseLevel(l.base.Core()).String()
+		}
+	}
+	return levels
+}
+
+// Subsystems returns the names of the subsystems, sorted.
+func (l *Levels) Subsystems() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	names := make([]string, 0, len(l.levels))
+	for name := range l.levels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// baseLevel returns the lowest level the core writes.
+func baseLevel(core zapcore.Core) zapcore.Level {
+	for l := zapcore.DebugLevel; l < zapcore.FatalLevel; l++ {
+		if core.Enabled(l) {
+			return l
+		}
+	}
+	return zapcore.FatalLevel
+}
+
+// levelCore filters the entries by the level of its subsystem once it's set, instead of the one of
+// the wrapped core, so a subsystem logs below the level of the base logger.
+type levelCore struct {
+	zapcore.Core
+	level *subsystemLevel
+}
+
+func (c *levelCore) Enabled(l zapcore.Level) bool {
+	if atomic.LoadInt32(&c.level.set) == 0 {
+		return c.Core.Enabled(l)
+	}
+	return c.level.level.Enabled(l)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c *levelCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if atomic.LoadInt32(&c.level.set) == 0 {
+		return c.Core.Check(e, ce)
+	}
+	if c.level.level.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
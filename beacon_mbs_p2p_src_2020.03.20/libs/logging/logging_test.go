@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLevels(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	levels := New(zap.New(core))
+
+	client := levels.Logger("client")
+	bridge := levels.Logger("bridge")
+	require.Equal(t, client, levels.Logger("client"))
+	require.Equal(t, []string{"bridge", "client"}, levels.Subsystems())
+
+	// the subsystems start at the level of the base logger
+	client.Debug("dropped")
+	client.Info("written", ClientID("c1"))
+	require.Equal(t, 1, logs.Len())
+	entry := logs.TakeAll()[0]
+	require.Equal(t, "client", entry.LoggerName)
+	require.Equal(t, "c1", entry.ContextMap()[KeyClientID])
+
+	// a subsystem logs below the level of the base logger, the others don't
+	require.NoError(t, levels.SetLevel("client", zapcore.DebugLevel))
+	client.With(Topic("a/b")).Debug("written")
+	bridge.Debug("dropped")
+	require.Equal(t, 1, logs.Len())
+	require.Equal(t, "a/b", logs.TakeAll()[0].ContextMap()[KeyTopic])
+
+	require.NoError(t, levels.SetLevel("bridge", zapcore.ErrorLevel))
+	bridge.Warn("dropped")
+	require.Equal(t, 0, logs.Len())
+	require.Equal(t, map[string]string{"client": "debug", "bridge": "error"}, levels.Levels())
+
+	// the subsystem follows the base logger again
+	levels.Reset("client")
+	client.Debug("dropped")
+	require.Equal(t, 0, logs.Len())
+	require.Equal(t, "info", levels.Levels()["client"])
+
+	require.Error(t, levels.SetLevel("p2p", zapcore.DebugLevel))
+}
@@ -0,0 +1,281 @@
+// Package memacct attributes the memory held by the broker to the connections holding it and
+// guards the process with a high-water mark: once the accounted memory reaches it the broker sheds
+// the load, refusing the new connections and pausing the reads of the clients, until the memory
+// falls back under the low-water mark.
+//
+// The accounting counts the bytes the broker holds for a connection, not the heap: the buffers of a
+// connection are an estimate, the packets read and not processed yet and the deliveries queued are
+// counted by their size. The retained messages belong to no connection, they're counted apart.
+package memacct
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Kind is what an accounted buffer holds.
+type Kind int
+
+const (
+	// Buffers are the read and write buffers of the connection
+	Buffers Kind = iota
+	// Read are the packets read and not processed yet
+	Read
+	// Queued are the deliveries waiting in the outbound queue
+	Queued
+
+	kinds
+)
+
+// Config sets the marks of the guard, in bytes. The guard is off if HighWater is 0.
+type Config struct {
+	HighWater int64 `json:"high_water" yaml:"high_water"`
+	// LowWater is 90% of HighWater if it's 0
+	LowWater int64 `json:"low_water" yaml:"low_water"`
+	// ConnectionBuffers is the estimate of the buffers of a connection, 16 KiB if it's 0
+	ConnectionBuffers int64 `json:"connection_buffers" yaml:"connection_buffers"`
+}
+
+const defaultConnectionBuffers = 16 << 10
+
+// Validate checks the marks are ordered.
+func (c Config) Validate() error {
+	if c.HighWater < 0 || c.LowWater < 0 || c.ConnectionBuffers < 0 {
+		return errors.New("memacct/memacct/Validate: the marks cannot be negative")
+	}
+	if c.LowWater > c.HighWater {
+		return errors.New("memacct/memacct/Validate: the low-water mark is over the high-water mark")
+	}
+	return nil
+}
+
+// Stats is the accounted memory, in bytes.
+type Stats struct {
+	Total       int64  `json:"total"`
+	Buffers     int64  `json:"buffers"`
+	Read        int64  `json:"read"`
+	Queued      int64  `json:"queued"`
+	Retained    int64  `json:"retained"`
+	HighWater   int64  `json:"high_water,omitempty"`
+	LowWater    int64  `json:"low_water,omitempty"`
+	Shedding    bool   `json:"shedding"`
+	Connections int    `json:"connections"`
+	Shed        uint64 `json:"shed"`
+}
+
+// AccountStats is the memory accounted to a connection, in bytes.
+type AccountStats struct {
+	ID      string `json:"id"`
+	Total   int64  `json:"total"`
+	Buffers int64  `json:"buffers"`
+	Read    int64  `json:"read"`
+	Queued  int64  `json:"queued"`
+}
+
+// Accountant sums the memory of the accounts and guards the marks.
+type Accountant struct {
+	cfg Config
+
+	total    int64
+	kinds    [kinds]int64
+	retained int64
+	shed     uint64
+
+	mu       sync.Mutex
+	accounts map[*Account]struct{}
+	// resume is closed once the memory falls under the low-water mark, it's nil while the broker
+	// doesn't shed the load
+	resume chan struct{}
+}
+
+func New(cfg Config) *Accountant {
+	if cfg.LowWater == 0 {
+		cfg.LowWater = cfg.HighWater / 10 * 9
+	}
+	if cfg.ConnectionBuffers == 0 {
+		cfg.ConnectionBuffers = defaultConnectionBuffers
+	}
+	return &Accountant{cfg: cfg, accounts: make(map[*Account]struct{})}
+}
+
+// Open opens the account of the connection, charged with the estimate of its buffers.
+func (a *Accountant) Open(id string) *Account {
+	acc := &Account{a: a, id: id}
+	a.mu.Lock()
+	a.accounts[acc] = struct{}{}
+	a.mu.Unlock()
+	acc.Add(Buffers, a.cfg.ConnectionBuffers)
+	return acc
+}
+
+// SetRetained sets the size of the retained messages.
+func (a *Accountant) SetRetained(n int64) {
+	old := atomic.SwapInt64(&a.retained, n)
+	a.add(n - old)
+}
+
+func (a *Accountant) add(n int64) {
+	if n == 0 {
+		return
+	}
+	total := atomic.AddInt64(&a.total, n)
+	if a.cfg.HighWater <= 0 {
+		return
+	}
+	// the state changes under the lock, the marks are crossed once
+	if n > 0 && total >= a.cfg.HighWater {
+		a.mu.Lock()
+		if a.resume == nil && atomic.LoadInt64(&a.total) >= a.cfg.HighWater {
+			a.resume = make(chan struct{})
+		}
+		a.mu.Unlock()
+	} else if n < 0 && total < a.cfg.LowWater {
+		a.mu.Lock()
+		if a.resume != nil && atomic.LoadInt64(&a.total) < a.cfg.LowWater {
+			close(a.resume)
+			a.resume = nil
+		}
+		a.mu.Unlock()
+	}
+}
+
+// Shedding reports whether the memory has reached the high-water mark and not fallen under the
+// low-water mark since, the new connections are refused meanwhile. It counts the connection shed.
+func (a *Accountant) Shedding() bool {
+	a.mu.Lock()
+	shedding := a.resume != nil
+	a.mu.Unlock()
+	if shedding {
+		atomic.AddUint64(&a.shed, 1)
+	}
+	return shedding
+}
+
+// Paused returns the channel closed once the reads may go on, nil if they're not paused.
+func (a *Accountant) Paused() <-chan struct{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.resume == nil {
+		return nil
+	}
+	return a.resume
+}
+
+// Stats returns the memory accounted by kind.
+func (a *Accountant) Stats() Stats {
+	a.mu.Lock()
+	s := Stats{
+		Shedding:    a.resume != nil,
+		Connections: len(a.accounts),
+	}
+	a.mu.Unlock()
+	s.Total = atomic.LoadInt64(&a.total)
+	s.Buffers = atomic.LoadInt64(&a.kinds[Buffers])
+	s.Read = atomic.LoadInt64(&a.kinds[Read])
+	s.Queued = atomic.LoadInt64(&a.kinds[Queued])
+	s.Retained = atomic.LoadInt64(&a.retained)
+	s.HighWater = a.cfg.HighWater
+	s.LowWater = a.cfg.LowWater
+	s.Shed = atomic.LoadUint64(&a.shed)
+	if s.HighWater == 0 {
+		s.LowWater = 0
+	}
+	return s
+}
+
+// Top returns the n accounts holding the most memory, the most first.
+func (a *Accountant) Top(n int) []AccountStats {
+	a.mu.Lock()
+	list := make([]AccountStats, 0, len(a.accounts))
+	for acc := range a.accounts {
+		list = append(list, acc.Stats())
+	}
+	a.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Total == list[j].Total {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].Total > list[j].Total
+	})
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// Account is the memory held for a connection. Its methods are safe on a nil account, which
+// accounts nothing.
+type Account struct {
+	a  *Accountant
+	id string
+
+	mu     sync.Mutex
+	kinds  [kinds]int64
+	closed bool
+}
+
+// Add charges n bytes of the kind to the account, n is negative for the released ones. Nothing is
+// charged once the account is closed.
+func (acc *Account) Add(kind Kind, n int64) {
+	if acc == nil || n == 0 {
+		return
+	}
+	acc.mu.Lock()
+	if acc.closed {
+		acc.mu.Unlock()
+		return
+	}
+	acc.kinds[kind] += n
+	acc.mu.Unlock()
+
+	atomic.AddInt64(&acc.a.kinds[kind], n)
+	acc.a.add(n)
+}
+
+// Release releases n bytes of the kind.
+func (acc *Account) Release(kind Kind, n int64) {
+	acc.Add(kind, -n)
+}
+
+// Close releases all the memory of the account, the buffers released afterwards are ignored.
+func (acc *Account) Close() {
+	if acc == nil {
+		return
+	}
+	acc.mu.Lock()
+	if acc.closed {
+		acc.mu.Unlock()
+		return
+	}
+	acc.closed = true
+	held := acc.kinds
+	acc.mu.Unlock()
+
+	a := acc.a
+	a.mu.Lock()
+	delete(a.accounts, acc)
+	a.mu.Unlock()
+	var total int64
+	for kind, n := range held {
+		atomic.AddInt64(&a.kinds[kind], -n)
+		total += n
+	}
+	a.add(-total)
+}
+
+// Stats returns the memory held for the connection.
+func (acc *Account) Stats() AccountStats {
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+	s := AccountStats{
+		ID:      acc.id,
+		Buffers: acc.kinds[Buffers],
+		Read:    acc.kinds[Read],
+		Queued:  acc.kinds[Queued],
+	}
+	s.Total = s.Buffers + s.Read + s.Queued
+	return s
+}
@@ -0,0 +1,59 @@
+package memacct
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountant(t *testing.T) {
+	require.Error(t, Config{HighWater: 100, LowWater: 200}.Validate())
+
+	a := New(Config{HighWater: 1000, ConnectionBuffers: 100})
+	c1 := a.Open("c1")
+	c2 := a.Open("c2")
+	c1.Add(Read, 300)
+	c2.Add(Queued, 200)
+	a.SetRetained(100)
+	require.Nil(t, a.Paused())
+	require.False(t, a.Shedding())
+
+	// the broker sheds the load at the high-water mark
+	c2.Add(Queued, 200)
+	paused := a.Paused()
+	require.NotNil(t, paused)
+	require.True(t, a.Shedding())
+
+	s := a.Stats()
+	require.Equal(t, int64(1000), s.Total)
+	require.Equal(t, int64(400), s.Queued)
+	require.Equal(t, int64(900), s.LowWater)
+	require.Equal(t, uint64(1), s.Shed)
+	top := a.Top(1)
+	require.Len(t, top, 1)
+	require.Equal(t, AccountStats{ID: "c2", Total: 500, Buffers: 100, Queued: 400}, top[0])
+
+	// until the memory falls under the low-water mark
+	c1.Release(Read, 100)
+	require.NotNil(t, a.Paused())
+	c1.Release(Read, 1)
+	require.Nil(t, a.Paused())
+	select {
+	case <-paused:
+	default:
+		t.Fatal("the reads are not resumed")
+	}
+
+	c2.Close()
+	c2.Release(Queued, 400)
+	s = a.Stats()
+	require.Equal(t, int64(399), s.Total)
+	require.Equal(t, int64(0), s.Queued)
+	require.Equal(t, 1, s.Connections)
+
+	// a nil account accounts nothing
+	var none *Account
+	none.Add(Read, 10)
+	none.Close()
+	require.Equal(t, int64(399), a.Stats().Total)
+}
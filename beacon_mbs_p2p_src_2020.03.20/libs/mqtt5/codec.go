@@ -0,0 +1,405 @@
+// Package mqtt5 reads and writes the MQTT 5.0 packets. They are decoded to the 3.1.1 packets of
+// paho, which the broker handles whatever the version of the client, and the properties, the
+// reason codes and the subscription options 5.0 adds are kept beside them in a Packet.
+package mqtt5
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+const ProtocolVersion = byte(5)
+
+// Auth is the type of the AUTH packet, which 3.1.1 doesn't have.
+const Auth = byte(15)
+
+// AuthPacket is the AUTH of the extended authentication, its reason code and properties are in
+// the Packet.
+type AuthPacket struct {
+	packets.FixedHeader
+}
+
+func NewAuthPacket() *AuthPacket {
+	return &AuthPacket{FixedHeader: packets.FixedHeader{MessageType: Auth}}
+}
+
+func (a *AuthPacket) Write(w io.Writer) error {
+	return errors.New("mqtt5/codec/AuthPacket: AUTH is a 5.0 packet")
+}
+
+func (a *AuthPacket) Unpack(r io.Reader) error {
+	return errors.New("mqtt5/codec/AuthPacket: AUTH is a 5.0 packet")
+}
+
+func (a *AuthPacket) String() string {
+	return fmt.Sprintf("AUTH: rLength: %d", a.RemainingLength)
+}
+
+func (a *AuthPacket) Details() packets.Details {
+	return packets.Details{}
+}
+
+// Packet is a 5.0 packet, Control holds the fields it shares with 3.1.1.
+type Packet struct {
+	Control    packets.ControlPacket
+	Properties *Properties
+
+	// WillProperties are the properties of the will message of a CONNECT.
+	WillProperties *Properties
+
+	// ReasonCode of a CONNACK, PUBACK, PUBREC, PUBREL, PUBCOMP, DISCONNECT or AUTH. The reason code
+	// of a CONNACK is mapped from its return code if it's Success.
+	ReasonCode byte
+
+	// ReasonCodes of an UNSUBACK, one per topic filter. The reason codes of a SUBACK are its
+	// return codes.
+	ReasonCodes []byte
+
+	// SubOptions of a SUBSCRIBE, one per topic filter, the QoS bits are in its Qoss too.
+	SubOptions []byte
+}
+
+// ReadConnect reads the first packet of a connection, which must be a CONNECT of either version.
+// A 3.1.1 (or 3.1) CONNECT is decoded by paho, and has no properties.
+func ReadConnect(r io.Reader) (*Packet, error) {
+	fh, body, err := readFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	if fh.MessageType != packets.Connect {
+		return nil, errors.New("mqtt5/codec/ReadConnect: the first packet is not a CONNECT")
+	}
+
+	// the protocol level follows the protocol name
+	d := &decoder{buf: body}
+	_ = d.string()
+	level := d.byte()
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	if level != ProtocolVersion {
+		var frame bytes.Buffer
+		frame.WriteByte(packets.Connect << 4)
+		frame.Write(encodeVarint(len(body)))
+		frame.Write(body)
+		cp, err := packets.ReadPacket(&frame)
+		if err != nil {
+			return nil, err
+		}
+		return &Packet{Control: cp}, nil
+	}
+
+	return decode(fh, body)
+}
+
+// ReadPacket reads a 5.0 packet.
+func ReadPacket(r io.Reader) (*Packet, error) {
+	fh, body, err := readFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	return decode(fh, body)
+}
+
+func readFrame(r io.Reader) (packets.FixedHeader, []byte, error) {
+	var fh packets.FixedHeader
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return fh, nil, err
+	}
+	fh.MessageType = b[0] >> 4
+	fh.Dup = (b[0]>>3)&0x01 > 0
+	fh.Qos = (b[0] >> 1) & 0x03
+	fh.Retain = b[0]&0x01 > 0
+
+	var err error
+	if fh.RemainingLength, err = readVarint(r); err != nil {
+		return fh, nil, err
+	}
+
+	body := make([]byte, fh.RemainingLength)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return fh, nil, err
+	}
+	return fh, body, nil
+}
+
+func decode(fh packets.FixedHeader, body []byte) (*Packet, error) {
+	var cp packets.ControlPacket
+	var err error
+	if fh.MessageType == Auth {
+		cp = &AuthPacket{FixedHeader: fh}
+	} else if cp, err = packets.NewControlPacketWithHeader(fh); err != nil {
+		return nil, err
+	}
+
+	p := &Packet{Control: cp}
+	d := &decoder{buf: body}
+
+	switch c := cp.(type) {
+	case *packets.ConnectPacket:
+		c.ProtocolName = d.string()
+		c.ProtocolVersion = d.byte()
+		flags := d.byte()
+		c.ReservedBit = flags & 0x01
+		c.CleanSession = flags&0x02 > 0
+		c.WillFlag = flags&0x04 > 0
+		c.WillQos = (flags >> 3) & 0x03
+		c.WillRetain = flags&0x20 > 0
+		c.PasswordFlag = flags&0x40 > 0
+		c.UsernameFlag = flags&0x80 > 0
+		c.Keepalive = d.uint16()
+		if p.Properties, err = decodeProperties(d); err != nil {
+			return nil, err
+		}
+		c.ClientIdentifier = d.string()
+		if c.WillFlag {
+			if p.WillProperties, err = decodeProperties(d); err != nil {
+				return nil, err
+			}
+			c.WillTopic = d.string()
+			c.WillMessage = d.binary()
+		}
+		if c.UsernameFlag {
+			c.Username = d.string()
+		}
+		if c.PasswordFlag {
+			c.Password = d.binary()
+		}
+	case *packets.ConnackPacket:
+		c.SessionPresent = d.byte()&0x01 > 0
+		p.ReasonCode = d.byte()
+		c.ReturnCode = p.ReasonCode
+		if p.Properties, err = decodeProperties(d); err != nil {
+			return nil, err
+		}
+	case *packets.PublishPacket:
+		c.TopicName = d.string()
+		if c.Qos > 0 {
+			c.MessageID = d.uint16()
+		}
+		if p.Properties, err = decodeProperties(d); err != nil {
+			return nil, err
+		}
+		c.Payload = d.rest()
+	case *packets.PubackPacket:
+		c.MessageID = d.uint16()
+		err = decodeAck(d, p)
+	case *packets.PubrecPacket:
+		c.MessageID = d.uint16()
+		err = decodeAck(d, p)
+	case *packets.PubrelPacket:
+		c.MessageID = d.uint16()
+		err = decodeAck(d, p)
+	case *packets.PubcompPacket:
+		c.MessageID = d.uint16()
+		err = decodeAck(d, p)
+	case *packets.SubscribePacket:
+		c.MessageID = d.uint16()
+		if p.Properties, err = decodeProperties(d); err != nil {
+			return nil, err
+		}
+		for len(d.buf) > 0 && d.err == nil {
+			topic := d.string()
+			options := d.byte()
+			c.Topics = append(c.Topics, topic)
+			c.Qoss = append(c.Qoss, options&0x03)
+			p.SubOptions = append(p.SubOptions, options)
+		}
+		if len(c.Topics) == 0 && d.err == nil {
+			return nil, errors.New("mqtt5/codec/decode: SUBSCRIBE without topic filter")
+		}
+	case *packets.SubackPacket:
+		c.MessageID = d.uint16()
+		if p.Properties, err = decodeProperties(d); err != nil {
+			return nil, err
+		}
+		c.ReturnCodes = d.rest()
+	case *packets.UnsubscribePacket:
+		c.MessageID = d.uint16()
+		if p.Properties, err = decodeProperties(d); err != nil {
+			return nil, err
+		}
+		for len(d.buf) > 0 && d.err == nil {
+			c.Topics = append(c.Topics, d.string())
+		}
+		if len(c.Topics) == 0 && d.err == nil {
+			return nil, errors.New("mqtt5/codec/decode: UNSUBSCRIBE without topic filter")
+		}
+	case *packets.UnsubackPacket:
+		c.MessageID = d.uint16()
+		if p.Properties, err = decodeProperties(d); err != nil {
+			return nil, err
+		}
+		p.ReasonCodes = d.rest()
+	case *packets.DisconnectPacket, *AuthPacket:
+		err = decodeAck(d, p)
+	case *packets.PingreqPacket, *packets.PingrespPacket:
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.buf) > 0 {
+		return nil, ErrMalformed
+	}
+	return p, nil
+}
+
+// decodeAck reads the optional reason code and properties ending the PUBACK, PUBREC, PUBREL,
+// PUBCOMP, DISCONNECT and AUTH.
+func decodeAck(d *decoder, p *Packet) error {
+	if len(d.buf) == 0 {
+		return nil
+	}
+	p.ReasonCode = d.byte()
+	if len(d.buf) == 0 {
+		return nil
+	}
+	var err error
+	p.Properties, err = decodeProperties(d)
+	return err
+}
+
+// Write writes the packet in the 5.0 format.
+func Write(w io.Writer, p *Packet) error {
+	var body bytes.Buffer
+	var fh packets.FixedHeader
+
+	switch c := p.Control.(type) {
+	case *packets.ConnectPacket:
+		fh = c.FixedHeader
+		body.Write(encodeString("MQTT"))
+		body.WriteByte(ProtocolVersion)
+		body.WriteByte(boolToByte(c.CleanSession)<<1 | boolToByte(c.WillFlag)<<2 | c.WillQos<<3 | boolToByte(c.WillRetain)<<5 | boolToByte(c.PasswordFlag)<<6 | boolToByte(c.UsernameFlag)<<7)
+		body.Write(encodeUint16(c.Keepalive))
+		encodeProperties(&body, p.Properties)
+		body.Write(encodeString(c.ClientIdentifier))
+		if c.WillFlag {
+			encodeProperties(&body, p.WillProperties)
+			body.Write(encodeString(c.WillTopic))
+			body.Write(encodeBinary(c.WillMessage))
+		}
+		if c.UsernameFlag {
+			body.Write(encodeString(c.Username))
+		}
+		if c.PasswordFlag {
+			body.Write(encodeBinary(c.Password))
+		}
+	case *packets.ConnackPacket:
+		fh = c.FixedHeader
+		reasonCode := p.ReasonCode
+		if reasonCode == Success {
+			reasonCode = ConnackReasonCode(c.ReturnCode)
+		}
+		body.WriteByte(boolToByte(c.SessionPresent))
+		body.WriteByte(reasonCode)
+		encodeProperties(&body, p.Properties)
+	case *packets.PublishPacket:
+		fh = c.FixedHeader
+		body.Write(encodeString(c.TopicName))
+		if c.Qos > 0 {
+			body.Write(encodeUint16(c.MessageID))
+		}
+		encodeProperties(&body, p.Properties)
+		body.Write(c.Payload)
+	case *packets.PubackPacket:
+		fh = c.FixedHeader
+		body.Write(encodeUint16(c.MessageID))
+		encodeAck(&body, p)
+	case *packets.PubrecPacket:
+		fh = c.FixedHeader
+		body.Write(encodeUint16(c.MessageID))
+		encodeAck(&body, p)
+	case *packets.PubrelPacket:
+		fh = c.FixedHeader
+		body.Write(encodeUint16(c.MessageID))
+		encodeAck(&body, p)
+	case *packets.PubcompPacket:
+		fh = c.FixedHeader
+		body.Write(encodeUint16(c.MessageID))
+		encodeAck(&body, p)
+	case *packets.SubscribePacket:
+		fh = c.FixedHeader
+		body.Write(encodeUint16(c.MessageID))
+		encodeProperties(&body, p.Properties)
+		for i, topic := range c.Topics {
+			options := c.Qoss[i]
+			if i < len(p.SubOptions) {
+				options = p.SubOptions[i]
+			}
+			body.Write(encodeString(topic))
+			body.WriteByte(options)
+		}
+	case *packets.SubackPacket:
+		fh = c.FixedHeader
+		body.Write(encodeUint16(c.MessageID))
+		encodeProperties(&body, p.Properties)
+		body.Write(c.ReturnCodes)
+	case *packets.UnsubscribePacket:
+		fh = c.FixedHeader
+		body.Write(encodeUint16(c.MessageID))
+		encodeProperties(&body, p.Properties)
+		for _, topic := range c.Topics {
+			body.Write(encodeString(topic))
+		}
+	case *packets.UnsubackPacket:
+		fh = c.FixedHeader
+		if len(p.ReasonCodes) == 0 {
+			return errors.New("mqtt5/codec/Write: UNSUBACK without reason code")
+		}
+		body.Write(encodeUint16(c.MessageID))
+		encodeProperties(&body, p.Properties)
+		body.Write(p.ReasonCodes)
+	case *packets.DisconnectPacket:
+		fh = c.FixedHeader
+		encodeAck(&body, p)
+	case *AuthPacket:
+		fh = c.FixedHeader
+		encodeAck(&body, p)
+	case *packets.PingreqPacket:
+		fh = c.FixedHeader
+	case *packets.PingrespPacket:
+		fh = c.FixedHeader
+	default:
+		return fmt.Errorf("mqtt5/codec/Write: unsupported packet %T", p.Control)
+	}
+
+	if body.Len() > maxVarint {
+		return errors.New("mqtt5/codec/Write: packet too large")
+	}
+
+	var frame bytes.Buffer
+	frame.WriteByte(fh.MessageType<<4 | boolToByte(fh.Dup)<<3 | fh.Qos<<1 | boolToByte(fh.Retain))
+	frame.Write(encodeVarint(body.Len()))
+	frame.Write(body.Bytes())
+	_, err := frame.WriteTo(w)
+	return err
+}
+
+// encodeAck omits the reason code and the properties if the reason code is Success and there
+// are no properties.
+func encodeAck(body *bytes.Buffer, p *Packet) {
+	if p.ReasonCode == Success && p.Properties == nil {
+		return
+	}
+	body.WriteByte(p.ReasonCode)
+	if p.Properties != nil {
+		encodeProperties(body, p.Properties)
+	}
+}
+
+func boolToByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,149 @@
+package mqtt5
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func roundTrip(t *testing.T, p *Packet) *Packet {
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, p))
+	got, err := ReadPacket(&buf)
+	require.NoError(t, err)
+	require.Zero(t, buf.Len())
+	return got
+}
+
+func TestConnect(t *testing.T) {
+	connect := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+	connect.ProtocolName = "MQTT"
+	connect.ProtocolVersion = ProtocolVersion
+	connect.CleanSession = true
+	connect.WillFlag = true
+	connect.WillQos = 1
+	connect.WillTopic = "will/a"
+	connect.WillMessage = []byte("gone")
+	connect.PasswordFlag = true
+	connect.Password = []byte("secret")
+	connect.Keepalive = 30
+	connect.ClientIdentifier = "c1"
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, &Packet{
+		Control: connect,
+		Properties: &Properties{
+			SessionExpiryInterval: Uint32(3600),
+			TopicAliasMaximum:     Uint16(8),
+			User:                  []UserProperty{{Key: "region", Value: "eu"}, {Key: "region", Value: "us"}},
+		},
+		WillProperties: &Properties{WillDelayInterval: Uint32(5)},
+	}))
+
+	p, err := ReadConnect(&buf)
+	require.NoError(t, err)
+	got := p.Control.(*packets.ConnectPacket)
+	require.Equal(t, ProtocolVersion, got.ProtocolVersion)
+	require.Equal(t, "c1", got.ClientIdentifier)
+	require.Equal(t, "will/a", got.WillTopic)
+	require.Equal(t, []byte("gone"), got.WillMessage)
+	require.Equal(t, []byte("secret"), got.Password)
+	require.True(t, got.CleanSession)
+	require.Equal(t, uint32(3600), *p.Properties.SessionExpiryInterval)
+	require.Equal(t, uint16(8), *p.Properties.TopicAliasMaximum)
+	require.Len(t, p.Properties.User, 2)
+	v, ok := p.Properties.Get("region")
+	require.True(t, ok)
+	require.Equal(t, "eu", v)
+	require.Equal(t, uint32(5), *p.WillProperties.WillDelayInterval)
+	require.Equal(t, byte(packets.Accepted), Validate(got))
+
+	// a 3.1.1 CONNECT is decoded by paho
+	connect.ProtocolVersion = 4
+	buf.Reset()
+	require.NoError(t, connect.Write(&buf))
+	p, err = ReadConnect(&buf)
+	require.NoError(t, err)
+	require.Nil(t, p.Properties)
+	require.Equal(t, byte(4), p.Control.(*packets.ConnectPacket).ProtocolVersion)
+	require.Equal(t, "will/a", p.Control.(*packets.ConnectPacket).WillTopic)
+}
+
+func TestPublish(t *testing.T) {
+	publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	publish.Qos = 1
+	publish.Retain = true
+	publish.MessageID = 7
+	publish.Payload = []byte("21.5")
+
+	got := roundTrip(t, &Packet{
+		Control:    publish,
+		Properties: &Properties{TopicAlias: Uint16(3), MessageExpiry: Uint32(60), ContentType: "text/plain"},
+	})
+	pp := got.Control.(*packets.PublishPacket)
+	require.Equal(t, "", pp.TopicName)
+	require.Equal(t, uint16(7), pp.MessageID)
+	require.True(t, pp.Retain)
+	require.Equal(t, []byte("21.5"), pp.Payload)
+	require.Equal(t, uint16(3), *got.Properties.TopicAlias)
+	require.Equal(t, uint32(60), *got.Properties.MessageExpiry)
+	require.Equal(t, "text/plain", got.Properties.ContentType)
+}
+
+func TestAcks(t *testing.T) {
+	connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	connack.ReturnCode = packets.ErrRefusedNotAuthorised
+	got := roundTrip(t, &Packet{Control: connack, Properties: &Properties{AssignedClientIdentifier: "auto-1"}})
+	require.Equal(t, NotAuthorized, got.ReasonCode)
+	require.Equal(t, "auto-1", got.Properties.AssignedClientIdentifier)
+
+	puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+	puback.MessageID = 9
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, &Packet{Control: puback}))
+	require.Equal(t, []byte{0x40, 0x02, 0x00, 0x09}, buf.Bytes())
+	got = roundTrip(t, &Packet{Control: puback, ReasonCode: NoMatchingSubscribers})
+	require.Equal(t, NoMatchingSubscribers, got.ReasonCode)
+
+	subscribe := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
+	subscribe.MessageID = 1
+	subscribe.Topics = []string{"a/+", "b/#"}
+	subscribe.Qoss = []byte{1, 2}
+	got = roundTrip(t, &Packet{Control: subscribe, SubOptions: []byte{0x05, 0x22}})
+	require.Equal(t, []byte{1, 2}, got.Control.(*packets.SubscribePacket).Qoss)
+	require.Equal(t, []byte{0x05, 0x22}, got.SubOptions)
+
+	unsuback := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+	require.Error(t, Write(&buf, &Packet{Control: unsuback}))
+	got = roundTrip(t, &Packet{Control: unsuback, ReasonCodes: []byte{Success, NoSubscriptionExisted}})
+	require.Equal(t, []byte{Success, NoSubscriptionExisted}, got.ReasonCodes)
+
+	disconnect := packets.NewControlPacket(packets.Disconnect).(*packets.DisconnectPacket)
+	got = roundTrip(t, &Packet{Control: disconnect, ReasonCode: SessionTakenOver, Properties: &Properties{ReasonString: "taken over"}})
+	require.Equal(t, SessionTakenOver, got.ReasonCode)
+	require.Equal(t, "taken over", got.Properties.ReasonString)
+
+	got = roundTrip(t, &Packet{Control: NewAuthPacket(), ReasonCode: ReAuthenticate, Properties: &Properties{AuthMethod: "token", AuthData: []byte("t2")}})
+	require.IsType(t, &AuthPacket{}, got.Control)
+	require.Equal(t, ReAuthenticate, got.ReasonCode)
+	require.Equal(t, "token", got.Properties.AuthMethod)
+	require.Equal(t, []byte("t2"), got.Properties.AuthData)
+	buf.Reset()
+	require.NoError(t, Write(&buf, &Packet{Control: NewAuthPacket()}))
+	require.Equal(t, []byte{0xF0, 0x00}, buf.Bytes())
+}
+
+func TestMalformed(t *testing.T) {
+	// the message expiry is present twice
+	_, err := ReadPacket(bytes.NewReader([]byte{0x30, 0x0E, 0x00, 0x01, 'a', 0x0A, 0x02, 0, 0, 0, 1, 0x02, 0, 0, 0, 2}))
+	require.Error(t, err)
+
+	// the properties are longer than the packet
+	_, err = ReadPacket(bytes.NewReader([]byte{0x30, 0x04, 0x00, 0x01, 'a', 0x09}))
+	require.Error(t, err)
+
+	_, err = ReadConnect(bytes.NewReader([]byte{0xC0, 0x00}))
+	require.Error(t, err)
+}
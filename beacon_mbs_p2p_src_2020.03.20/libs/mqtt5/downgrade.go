@@ -0,0 +1,89 @@
+package mqtt5
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The policies of the properties of a 5.0 PUBLISH delivered to a 3.1.1 subscriber, which cannot
+// receive them: they are stripped, encoded with the payload into a JSON envelope, or the
+// subscriber is skipped.
+const (
+	DowngradeStrip    = "strip"
+	DowngradeEnvelope = "envelope"
+	DowngradeSkip     = "skip"
+)
+
+func CheckDowngradePolicy(policy string) error {
+	switch policy {
+	case DowngradeStrip, DowngradeEnvelope, DowngradeSkip:
+		return nil
+	}
+	return fmt.Errorf("mqtt5/downgrade/CheckDowngradePolicy: unknown downgrade policy %q", policy)
+}
+
+// PublishProperties returns the properties of a PUBLISH forwarded to the subscribers, nil if there
+// are none. The topic alias and the subscription identifiers belong to the connections.
+func PublishProperties(p *Properties) *Properties {
+	if p == nil {
+		return nil
+	}
+	fwd := &Properties{
+		PayloadFormat:   p.PayloadFormat,
+		MessageExpiry:   p.MessageExpiry,
+		ContentType:     p.ContentType,
+		ResponseTopic:   p.ResponseTopic,
+		CorrelationData: p.CorrelationData,
+		User:            p.User,
+	}
+	if fwd.MessageExpiry == nil && !fwd.Downgraded() {
+		return nil
+	}
+	return fwd
+}
+
+// Downgraded reports whether a 3.1.1 subscriber loses some of the properties of the PUBLISH. The
+// message expiry is applied by the broker, it's not lost.
+func (p *Properties) Downgraded() bool {
+	return p != nil && (p.PayloadFormat != nil || len(p.ContentType) > 0 || len(p.ResponseTopic) > 0 ||
+		len(p.CorrelationData) > 0 || len(p.User) > 0)
+}
+
+// Envelope is the payload delivered to a 3.1.1 subscriber with the downgrade policy envelope, the
+// original payload is base64 encoded.
+type Envelope struct {
+	PayloadFormat   *byte          `json:"payload_format,omitempty"`
+	ContentType     string         `json:"content_type,omitempty"`
+	ResponseTopic   string         `json:"response_topic,omitempty"`
+	CorrelationData []byte         `json:"correlation_data,omitempty"`
+	User            []UserProperty `json:"user_properties,omitempty"`
+	Payload         []byte         `json:"payload"`
+}
+
+func EncodeEnvelope(p *Properties, payload []byte) ([]byte, error) {
+	e := Envelope{Payload: payload}
+	if p != nil {
+		e.PayloadFormat = p.PayloadFormat
+		e.ContentType = p.ContentType
+		e.ResponseTopic = p.ResponseTopic
+		e.CorrelationData = p.CorrelationData
+		e.User = p.User
+	}
+	return json.Marshal(&e)
+}
+
+// DecodeEnvelope returns the properties and the payload of an envelope.
+func DecodeEnvelope(data []byte) (*Properties, []byte, error) {
+	var e Envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, nil, fmt.Errorf("mqtt5/downgrade/DecodeEnvelope: invalid envelope => %v", err)
+	}
+	p := &Properties{
+		PayloadFormat:   e.PayloadFormat,
+		ContentType:     e.ContentType,
+		ResponseTopic:   e.ResponseTopic,
+		CorrelationData: e.CorrelationData,
+		User:            e.User,
+	}
+	return p, e.Payload, nil
+}
@@ -0,0 +1,42 @@
+package mqtt5
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDowngrade(t *testing.T) {
+	require.NoError(t, CheckDowngradePolicy(DowngradeEnvelope))
+	require.Error(t, CheckDowngradePolicy("drop"))
+
+	require.Nil(t, PublishProperties(nil))
+	require.Nil(t, PublishProperties(&Properties{TopicAlias: Uint16(2)}))
+
+	// the message expiry alone is forwarded, but not lost by a 3.1.1 subscriber
+	p := PublishProperties(&Properties{TopicAlias: Uint16(2), MessageExpiry: Uint32(30)})
+	require.NotNil(t, p)
+	require.Nil(t, p.TopicAlias)
+	require.False(t, p.Downgraded())
+
+	p = PublishProperties(&Properties{
+		ContentType:     "application/json",
+		ResponseTopic:   "replies/c1",
+		CorrelationData: []byte{1, 2},
+		User:            []UserProperty{{Key: "trace", Value: "t1"}},
+	})
+	require.True(t, p.Downgraded())
+
+	data, err := EncodeEnvelope(p, []byte(`{"t":21.5}`))
+	require.NoError(t, err)
+	got, payload, err := DecodeEnvelope(data)
+	require.NoError(t, err)
+	require.Equal(t, []byte(`{"t":21.5}`), payload)
+	require.Equal(t, "application/json", got.ContentType)
+	require.Equal(t, "replies/c1", got.ResponseTopic)
+	require.Equal(t, []byte{1, 2}, got.CorrelationData)
+	require.Equal(t, []UserProperty{{Key: "trace", Value: "t1"}}, got.User)
+
+	_, _, err = DecodeEnvelope([]byte("21.5"))
+	require.Error(t, err)
+}
@@ -0,0 +1,144 @@
+package mqtt5
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// maxVarint is the largest variable byte integer, 4 bytes.
+const maxVarint = 268435455
+
+var ErrMalformed = errors.New("mqtt5: malformed packet")
+
+// decoder reads the fields of a packet body, the first error stops the decoding and the
+// following reads return zero values.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.buf) {
+		d.err = ErrMalformed
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) byte() byte {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) uint16() uint16 {
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (d *decoder) uint32() uint32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *decoder) binary() []byte {
+	n := d.uint16()
+	b := d.next(int(n))
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
+
+func (d *decoder) string() string {
+	return string(d.binary())
+}
+
+func (d *decoder) varint() int {
+	v, mul := 0, 1
+	for i := 0; i < 4; i++ {
+		b := d.byte()
+		if d.err != nil {
+			return 0
+		}
+		v += int(b&0x7F) * mul
+		if b&0x80 == 0 {
+			return v
+		}
+		mul *= 128
+	}
+	d.err = ErrMalformed
+	return 0
+}
+
+// rest returns the remaining bytes, such as the payload of a PUBLISH.
+func (d *decoder) rest() []byte {
+	b := d.buf
+	d.buf = nil
+	return b
+}
+
+func readVarint(r io.Reader) (int, error) {
+	v, mul := 0, 1
+	b := make([]byte, 1)
+	for i := 0; i < 4; i++ {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, err
+		}
+		v += int(b[0]&0x7F) * mul
+		if b[0]&0x80 == 0 {
+			return v, nil
+		}
+		mul *= 128
+	}
+	return 0, ErrMalformed
+}
+
+func encodeVarint(v int) []byte {
+	var b []byte
+	for {
+		digit := byte(v % 128)
+		v /= 128
+		if v > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if v == 0 {
+			return b
+		}
+	}
+}
+
+func encodeUint16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func encodeUint32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func encodeBinary(v []byte) []byte {
+	return append(encodeUint16(uint16(len(v))), v...)
+}
+
+func encodeString(v string) []byte {
+	return encodeBinary([]byte(v))
+}
@@ -0,0 +1,318 @@
+package mqtt5
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// The property identifiers of MQTT 5.0.
+const (
+	PropPayloadFormat                   = byte(0x01)
+	PropMessageExpiry                   = byte(0x02)
+	PropContentType                     = byte(0x03)
+	PropResponseTopic                   = byte(0x08)
+	PropCorrelationData                 = byte(0x09)
+	PropSubscriptionIdentifier          = byte(0x0B)
+	PropSessionExpiryInterval           = byte(0x11)
+	PropAssignedClientIdentifier        = byte(0x12)
+	PropServerKeepAlive                 = byte(0x13)
+	PropAuthMethod                      = byte(0x15)
+	PropAuthData                        = byte(0x16)
+	PropRequestProblemInfo              = byte(0x17)
+	PropWillDelayInterval               = byte(0x18)
+	PropRequestResponseInfo             = byte(0x19)
+	PropResponseInfo                    = byte(0x1A)
+	PropServerReference                 = byte(0x1C)
+	PropReasonString                    = byte(0x1F)
+	PropReceiveMaximum                  = byte(0x21)
+	PropTopicAliasMaximum               = byte(0x22)
+	PropTopicAlias                      = byte(0x23)
+	PropMaximumQoS                      = byte(0x24)
+	PropRetainAvailable                 = byte(0x25)
+	PropUser                            = byte(0x26)
+	PropMaximumPacketSize               = byte(0x27)
+	PropWildcardSubscriptionAvailable   = byte(0x28)
+	PropSubscriptionIdentifierAvailable = byte(0x29)
+	PropSharedSubscriptionAvailable     = byte(0x2A)
+)
+
+// SessionNeverExpires is the session expiry interval of the sessions which are never removed.
+const SessionNeverExpires = uint32(0xFFFFFFFF)
+
+type UserProperty struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Properties holds the properties of a packet, the optional ones are nil if they are absent.
+type Properties struct {
+	PayloadFormat                   *byte
+	MessageExpiry                   *uint32
+	ContentType                     string
+	ResponseTopic                   string
+	CorrelationData                 []byte
+	SubscriptionIdentifier          []int
+	SessionExpiryInterval           *uint32
+	AssignedClientIdentifier        string
+	ServerKeepAlive                 *uint16
+	AuthMethod                      string
+	AuthData                        []byte
+	RequestProblemInfo              *byte
+	WillDelayInterval               *uint32
+	RequestResponseInfo             *byte
+	ResponseInfo                    string
+	ServerReference                 string
+	ReasonString                    string
+	ReceiveMaximum                  *uint16
+	TopicAliasMaximum               *uint16
+	TopicAlias                      *uint16
+	MaximumQoS                      *byte
+	RetainAvailable                 *byte
+	User                            []UserProperty
+	MaximumPacketSize               *uint32
+	WildcardSubscriptionAvailable   *byte
+	SubscriptionIdentifierAvailable *byte
+	SharedSubscriptionAvailable     *byte
+}
+
+func Byte(v byte) *byte {
+	return &v
+}
+
+func Uint16(v uint16) *uint16 {
+	return &v
+}
+
+func Uint32(v uint32) *uint32 {
+	return &v
+}
+
+// Get returns the value of the first user property with the key.
+func (p *Properties) Get(key string) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	for _, u := range p.User {
+		if u.Key == key {
+			return u.Value, true
+		}
+	}
+	return "", false
+}
+
+// decodeProperties reads the property length and the properties following it. A property which
+// is not allowed twice but is present twice is a protocol error.
+func decodeProperties(d *decoder) (*Properties, error) {
+	n := d.varint()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if n > len(d.buf) {
+		return nil, ErrMalformed
+	}
+
+	pd := &decoder{buf: d.buf[:n]}
+	d.buf = d.buf[n:]
+	if n == 0 {
+		return nil, nil
+	}
+
+	p := &Properties{}
+	seen := make(map[byte]bool)
+	for len(pd.buf) > 0 && pd.err == nil {
+		id := pd.byte()
+		if id != PropUser && id != PropSubscriptionIdentifier {
+			if seen[id] {
+				return nil, fmt.Errorf("mqtt5/properties/decodeProperties: property 0x%02X present twice", id)
+			}
+			seen[id] = true
+		}
+
+		switch id {
+		case PropPayloadFormat:
+			p.PayloadFormat = Byte(pd.byte())
+		case PropMessageExpiry:
+			p.MessageExpiry = Uint32(pd.uint32())
+		case PropContentType:
+			p.ContentType = pd.string()
+		case PropResponseTopic:
+			p.ResponseTopic = pd.string()
+		case PropCorrelationData:
+			p.CorrelationData = pd.binary()
+		case PropSubscriptionIdentifier:
+			p.SubscriptionIdentifier = append(p.SubscriptionIdentifier, pd.varint())
+		case PropSessionExpiryInterval:
+			p.SessionExpiryInterval = Uint32(pd.uint32())
+		case PropAssignedClientIdentifier:
+			p.AssignedClientIdentifier = pd.string()
+		case PropServerKeepAlive:
+			p.ServerKeepAlive = Uint16(pd.uint16())
+		case PropAuthMethod:
+			p.AuthMethod = pd.string()
+		case PropAuthData:
+			p.AuthData = pd.binary()
+		case PropRequestProblemInfo:
+			p.RequestProblemInfo = Byte(pd.byte())
+		case PropWillDelayInterval:
+			p.WillDelayInterval = Uint32(pd.uint32())
+		case PropRequestResponseInfo:
+			p.RequestResponseInfo = Byte(pd.byte())
+		case PropResponseInfo:
+			p.ResponseInfo = pd.string()
+		case PropServerReference:
+			p.ServerReference = pd.string()
+		case PropReasonString:
+			p.ReasonString = pd.string()
+		case PropReceiveMaximum:
+			p.ReceiveMaximum = Uint16(pd.uint16())
+		case PropTopicAliasMaximum:
+			p.TopicAliasMaximum = Uint16(pd.uint16())
+		case PropTopicAlias:
+			p.TopicAlias = Uint16(pd.uint16())
+		case PropMaximumQoS:
+			p.MaximumQoS = Byte(pd.byte())
+		case PropRetainAvailable:
+			p.RetainAvailable = Byte(pd.byte())
+		case PropUser:
+			k := pd.string()
+			v := pd.string()
+			p.User = append(p.User, UserProperty{Key: k, Value: v})
+		case PropMaximumPacketSize:
+			p.MaximumPacketSize = Uint32(pd.uint32())
+		case PropWildcardSubscriptionAvailable:
+			p.WildcardSubscriptionAvailable = Byte(pd.byte())
+		case PropSubscriptionIdentifierAvailable:
+			p.SubscriptionIdentifierAvailable = Byte(pd.byte())
+		case PropSharedSubscriptionAvailable:
+			p.SharedSubscriptionAvailable = Byte(pd.byte())
+		default:
+			return nil, fmt.Errorf("mqtt5/properties/decodeProperties: unknown property 0x%02X", id)
+		}
+	}
+	if pd.err != nil {
+		return nil, pd.err
+	}
+	return p, nil
+}
+
+// encodeProperties writes the property length and the properties, by identifier.
+func encodeProperties(buf *bytes.Buffer, p *Properties) {
+	if p == nil {
+		buf.Write(encodeVarint(0))
+		return
+	}
+
+	var e bytes.Buffer
+	if p.PayloadFormat != nil {
+		e.WriteByte(PropPayloadFormat)
+		e.WriteByte(*p.PayloadFormat)
+	}
+	if p.MessageExpiry != nil {
+		e.WriteByte(PropMessageExpiry)
+		e.Write(encodeUint32(*p.MessageExpiry))
+	}
+	if len(p.ContentType) > 0 {
+		e.WriteByte(PropContentType)
+		e.Write(encodeString(p.ContentType))
+	}
+	if len(p.ResponseTopic) > 0 {
+		e.WriteByte(PropResponseTopic)
+		e.Write(encodeString(p.ResponseTopic))
+	}
+	if p.CorrelationData != nil {
+		e.WriteByte(PropCorrelationData)
+		e.Write(encodeBinary(p.CorrelationData))
+	}
+	for _, id := range p.SubscriptionIdentifier {
+		e.WriteByte(PropSubscriptionIdentifier)
+		e.Write(encodeVarint(id))
+	}
+	if p.SessionExpiryInterval != nil {
+		e.WriteByte(PropSessionExpiryInterval)
+		e.Write(encodeUint32(*p.SessionExpiryInterval))
+	}
+	if len(p.AssignedClientIdentifier) > 0 {
+		e.WriteByte(PropAssignedClientIdentifier)
+		e.Write(encodeString(p.AssignedClientIdentifier))
+	}
+	if p.ServerKeepAlive != nil {
+		e.WriteByte(PropServerKeepAlive)
+		e.Write(encodeUint16(*p.ServerKeepAlive))
+	}
+	if len(p.AuthMethod) > 0 {
+		e.WriteByte(PropAuthMethod)
+		e.Write(encodeString(p.AuthMethod))
+	}
+	if p.AuthData != nil {
+		e.WriteByte(PropAuthData)
+		e.Write(encodeBinary(p.AuthData))
+	}
+	if p.RequestProblemInfo != nil {
+		e.WriteByte(PropRequestProblemInfo)
+		e.WriteByte(*p.RequestProblemInfo)
+	}
+	if p.WillDelayInterval != nil {
+		e.WriteByte(PropWillDelayInterval)
+		e.Write(encodeUint32(*p.WillDelayInterval))
+	}
+	if p.RequestResponseInfo != nil {
+		e.WriteByte(PropRequestResponseInfo)
+		e.WriteByte(*p.RequestResponseInfo)
+	}
+	if len(p.ResponseInfo) > 0 {
+		e.WriteByte(PropResponseInfo)
+		e.Write(encodeString(p.ResponseInfo))
+	}
+	if len(p.ServerReference) > 0 {
+		e.WriteByte(PropServerReference)
+		e.Write(encodeString(p.ServerReference))
+	}
+	if len(p.ReasonString) > 0 {
+		e.WriteByte(PropReasonString)
+		e.Write(encodeString(p.ReasonString))
+	}
+	if p.ReceiveMaximum != nil {
+		e.WriteByte(PropReceiveMaximum)
+		e.Write(encodeUint16(*p.ReceiveMaximum))
+	}
+	if p.TopicAliasMaximum != nil {
+		e.WriteByte(PropTopicAliasMaximum)
+		e.Write(encodeUint16(*p.TopicAliasMaximum))
+	}
+	if p.TopicAlias != nil {
+		e.WriteByte(PropTopicAlias)
+		e.Write(encodeUint16(*p.TopicAlias))
+	}
+	if p.MaximumQoS != nil {
+		e.WriteByte(PropMaximumQoS)
+		e.WriteByte(*p.MaximumQoS)
+	}
+	if p.RetainAvailable != nil {
+		e.WriteByte(PropRetainAvailable)
+		e.WriteByte(*p.RetainAvailable)
+	}
+	for _, u := range p.User {
+		e.WriteByte(PropUser)
+		e.Write(encodeString(u.Key))
+		e.Write(encodeString(u.Value))
+	}
+	if p.MaximumPacketSize != nil {
+		e.WriteByte(PropMaximumPacketSize)
+		e.Write(encodeUint32(*p.MaximumPacketSize))
+	}
+	if p.WildcardSubscriptionAvailable != nil {
+		e.WriteByte(PropWildcardSubscriptionAvailable)
+		e.WriteByte(*p.WildcardSubscriptionAvailable)
+	}
+	if p.SubscriptionIdentifierAvailable != nil {
+		e.WriteByte(PropSubscriptionIdentifierAvailable)
+		e.WriteByte(*p.SubscriptionIdentifierAvailable)
+	}
+	if p.SharedSubscriptionAvailable != nil {
+		e.WriteByte(PropSharedSubscriptionAvailable)
+		e.WriteByte(*p.SharedSubscriptionAvailable)
+	}
+
+	buf.Write(encodeVarint(e.Len()))
+	buf.Write(e.Bytes())
+}
@@ -0,0 +1,89 @@
+package mqtt5
+
+import "github.com/eclipse/paho.mqtt.golang/packets"
+
+// The reason codes of MQTT 5.0, the granted QoS of a SUBACK are the same as in 3.1.1.
+const (
+	Success                         = byte(0x00)
+	NormalDisconnection             = byte(0x00)
+	GrantedQoS0                     = byte(0x00)
+	GrantedQoS1                     = byte(0x01)
+	GrantedQoS2                     = byte(0x02)
+	DisconnectWithWillMessage       = byte(0x04)
+	NoMatchingSubscribers           = byte(0x10)
+	NoSubscriptionExisted           = byte(0x11)
+	ContinueAuthentication          = byte(0x18)
+	ReAuthenticate                  = byte(0x19)
+	UnspecifiedError                = byte(0x80)
+	MalformedPacket                 = byte(0x81)
+	ProtocolError                   = byte(0x82)
+	ImplementationSpecificError     = byte(0x83)
+	UnsupportedProtocolVersion      = byte(0x84)
+	ClientIdentifierNotValid        = byte(0x85)
+	BadUserNameOrPassword           = byte(0x86)
+	NotAuthorized                   = byte(0x87)
+	ServerUnavailable               = byte(0x88)
+	ServerBusy                      = byte(0x89)
+	ServerShuttingDown              = byte(0x8B)
+	BadAuthenticationMethod         = byte(0x8C)
+	KeepAliveTimeout                = byte(0x8D)
+	SessionTakenOver                = byte(0x8E)
+	TopicFilterInvalid              = byte(0x8F)
+	TopicNameInvalid                = byte(0x90)
+	PacketIdentifierInUse           = byte(0x91)
+	PacketIdentifierNotFound        = byte(0x92)
+	ReceiveMaximumExceeded          = byte(0x93)
+	TopicAliasInvalid               = byte(0x94)
+	PacketTooLarge                  = byte(0x95)
+	MessageRateTooHigh              = byte(0x96)
+	QuotaExceeded                   = byte(0x97)
+	PayloadFormatInvalid            = byte(0x99)
+	RetainNotSupported              = byte(0x9A)
+	QoSNotSupported                 = byte(0x9B)
+	UseAnotherServer                = byte(0x9C)
+	SharedSubscriptionsNotSupported = byte(0x9E)
+	AdministrativeAction            = byte(0x98)
+	MaximumConnectTime              = byte(0xA0)
+)
+
+// ConnackReasonCode returns the reason code of a CONNACK for the 3.1.1 return code, the broker
+// checks the connections with the 3.1.1 codes whatever the version of the client.
+func ConnackReasonCode(returnCode byte) byte {
+	switch returnCode {
+	case packets.Accepted:
+		return Success
+	case packets.ErrRefusedBadProtocolVersion:
+		return UnsupportedProtocolVersion
+	case packets.ErrRefusedIDRejected:
+		return ClientIdentifierNotValid
+	case packets.ErrRefusedServerUnavailable:
+		return ServerUnavailable
+	case packets.ErrRefusedBadUsernameOrPassword:
+		return BadUserNameOrPassword
+	case packets.ErrRefusedNotAuthorised:
+		return NotAuthorized
+	case packets.ErrProtocolViolation:
+		return ProtocolError
+	default:
+		return UnspecifiedError
+	}
+}
+
+// Validate checks the CONNECT of a 5.0 client like ConnectPacket.Validate does for 3.1.1, it
+// returns a 3.1.1 return code. Unlike 3.1.1, a password without a user name and an empty client
+// identifier with a persistent session are allowed, the server assigns the identifier.
+func Validate(c *packets.ConnectPacket) byte {
+	if c.ReservedBit != 0 {
+		return packets.ErrProtocolViolation
+	}
+	if c.ProtocolName != "MQTT" {
+		return packets.ErrProtocolViolation
+	}
+	if c.ProtocolVersion != ProtocolVersion {
+		return packets.ErrRefusedBadProtocolVersion
+	}
+	if len(c.ClientIdentifier) > 65535 || len(c.Username) > 65535 || len(c.Password) > 65535 {
+		return packets.ErrProtocolViolation
+	}
+	return packets.Accepted
+}
@@ -0,0 +1,219 @@
+// Package mqttclient is a thin wrapper of the paho client, preconfigured for the conventions of
+// this broker: auto-reconnect with the subscriptions restored, the tenant prefix added to and
+// stripped from the topics, and a request/response helper.
+package mqttclient
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	defaultKeepAlive            = 30 * time.Second
+	defaultConnectTimeout       = 10 * time.Second
+	defaultOperationTimeout     = 10 * time.Second
+	defaultMaxReconnectInterval = time.Minute
+)
+
+var (
+	ErrTimeout = errors.New("mqttclient: operation timed out")
+	ErrClosed  = errors.New("mqttclient: client is closed")
+)
+
+type Options struct {
+	Brokers  []string // such as tcp://127.0.0.1:1883
+	ClientID string
+	Username string
+	Password string
+
+	// Tenant is the topic prefix of the tenant namespace, it's added to all the topics published
+	// or subscribed, and stripped from the topics received.
+	Tenant string
+
+	KeepAlive        time.Duration
+	ConnectTimeout   time.Duration
+	OperationTimeout time.Duration
+	CleanSession     bool
+
+	OnConnect        func(c *Client)
+	OnConnectionLost func(c *Client, err error)
+}
+
+// Handler receives the messages of a subscription, the topic is without the tenant prefix.
+type Handler func(topic string, payload []byte)
+
+// Message is a message received with its flags, the topic is without the tenant prefix.
+type Message struct {
+	Topic    string
+	Payload  []byte
+	Qos      byte
+	Retained bool
+}
+
+// MessageHandler receives the messages of a subscription with their flags.
+type MessageHandler func(m Message)
+
+type subscription struct {
+	qos     byte
+	handler paho.MessageHandler
+}
+
+type Client struct {
+	mu            sync.Mutex
+	opts          Options
+	paho          paho.Client
+	subscriptions map[string]subscription
+
+	replies *replyRouter
+}
+
+func New(opts Options) (*Client, error) {
+	if len(opts.Brokers) == 0 {
+		return nil, errors.New("mqttclient: no broker address")
+	}
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = defaultKeepAlive
+	}
+	if opts.ConnectTimeout <= 0 {
+		opts.ConnectTimeout = defaultConnectTimeout
+	}
+	if opts.OperationTimeout <= 0 {
+		opts.OperationTimeout = defaultOperationTimeout
+	}
+	opts.Tenant = strings.TrimSuffix(opts.Tenant, "/")
+
+	c := &Client{
+		opts:          opts,
+		subscriptions: make(map[string]subscription),
+	}
+
+	po := paho.NewClientOptions().
+		SetClientID(opts.ClientID).
+		SetUsername(opts.Username).
+		SetPassword(opts.Password).
+		SetKeepAlive(opts.KeepAlive).
+		SetConnectTimeout(opts.ConnectTimeout).
+		SetCleanSession(opts.CleanSession).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(defaultMaxReconnectInterval).
+		SetOnConnectHandler(func(paho.Client) { c.onConnect() }).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			if c.opts.OnConnectionLost != nil {
+				c.opts.OnConnectionLost(c, err)
+			}
+		})
+	for _, broker := range opts.Brokers {
+		po.AddBroker(broker)
+	}
+	c.paho = paho.NewClient(po)
+
+	return c, nil
+}
+
+func (c *Client) Connect() error {
+	return c.wait(c.paho.Connect())
+}
+
+func (c *Client) Close() {
+	c.paho.Disconnect(uint(c.opts.OperationTimeout / time.Millisecond))
+}
+
+func (c *Client) IsConnected() bool {
+	return c.paho.IsConnected()
+}
+
+// The broker forgets the subscriptions of a clean session when the connection is lost, they're
+// subscribed again after each reconnection.
+func (c *Client) onConnect() {
+	c.mu.Lock()
+	subscriptions := make(map[string]subscription, len(c.subscriptions))
+	for filter, sub := range c.subscriptions {
+		subscriptions[filter] = sub
+	}
+	c.mu.Unlock()
+
+	for filter, sub := range subscriptions {
+		_ = c.wait(c.paho.Subscribe(filter, sub.qos, sub.handler))
+	}
+
+	if c.opts.OnConnect != nil {
+		c.opts.OnConnect(c)
+	}
+}
+
+func (c *Client) messageHandler(handler Handler) paho.MessageHandler {
+	return func(_ paho.Client, msg paho.Message) {
+		handler(c.stripTenant(msg.Topic()), msg.Payload())
+	}
+}
+
+func (c *Client) Publish(topic string, qos byte, retain bool, payload []byte) error {
+	return c.wait(c.paho.Publish(c.tenantTopic(topic), qos, retain, payload))
+}
+
+// Subscribe the filter (without the tenant prefix), the handler is kept for the reconnections.
+func (c *Client) Subscribe(filter string, qos byte, handler Handler) error {
+	return c.subscribe(filter, qos, c.messageHandler(handler))
+}
+
+// SubscribeMessages subscribes the filter like Subscribe, the handler gets the flags of the
+// messages too.
+func (c *Client) SubscribeMessages(filter string, qos byte, handler MessageHandler) error {
+	return c.subscribe(filter, qos, func(_ paho.Client, msg paho.Message) {
+		handler(Message{
+			Topic:    c.stripTenant(msg.Topic()),
+			Payload:  msg.Payload(),
+			Qos:      msg.Qos(),
+			Retained: msg.Retained(),
+		})
+	})
+}
+
+func (c *Client) subscribe(filter string, qos byte, handler paho.MessageHandler) error {
+	full := c.tenantTopic(filter)
+
+	if err := c.wait(c.paho.Subscribe(full, qos, handler)); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.subscriptions[full] = subscription{qos: qos, handler: handler}
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *Client) Unsubscribe(filter string) error {
+	full := c.tenantTopic(filter)
+
+	c.mu.Lock()
+	delete(c.subscriptions, full)
+	c.mu.Unlock()
+
+	return c.wait(c.paho.Unsubscribe(full))
+}
+
+func (c *Client) wait(token paho.Token) error {
+	if !token.WaitTimeout(c.opts.OperationTimeout) {
+		return ErrTimeout
+	}
+	return token.Error()
+}
+
+func (c *Client) tenantTopic(topic string) string {
+	if len(c.opts.Tenant) == 0 {
+		return topic
+	}
+	return c.opts.Tenant + "/" + topic
+}
+
+func (c *Client) stripTenant(topic string) string {
+	if len(c.opts.Tenant) == 0 {
+		return topic
+	}
+	return strings.TrimPrefix(topic, c.opts.Tenant+"/")
+}
@@ -0,0 +1,50 @@
+package mqttclient
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewOptions(t *testing.T) {
+	_, err := New(Options{})
+	require.Error(t, err)
+
+	c, err := New(Options{Brokers: []string{"tcp://127.0.0.1:1883"}, ClientID: "svc-1", Tenant: "tenants/acme/"})
+	require.NoError(t, err)
+	require.Equal(t, defaultKeepAlive, c.opts.KeepAlive)
+	require.Equal(t, defaultOperationTimeout, c.opts.OperationTimeout)
+	require.False(t, c.IsConnected())
+}
+
+func TestTenantTopic(t *testing.T) {
+	c, err := New(Options{Brokers: []string{"tcp://127.0.0.1:1883"}, Tenant: "tenants/acme"})
+	require.NoError(t, err)
+
+	require.Equal(t, "tenants/acme/room1/temp", c.tenantTopic("room1/temp"))
+	require.Equal(t, "room1/temp", c.stripTenant("tenants/acme/room1/temp"))
+
+	c, err = New(Options{Brokers: []string{"tcp://127.0.0.1:1883"}})
+	require.NoError(t, err)
+
+	require.Equal(t, "room1/temp", c.tenantTopic("room1/temp"))
+	require.Equal(t, "room1/temp", c.stripTenant("room1/temp"))
+}
+
+func TestReplyRouterDispatch(t *testing.T) {
+	r := &replyRouter{pending: make(map[string]chan envelope)}
+	ch := make(chan envelope, 1)
+	r.pending["c1"] = ch
+
+	data, err := json.Marshal(envelope{CorrelationID: "c1", Payload: []byte("pong")})
+	require.NoError(t, err)
+
+	r.dispatch([]byte("not json"))
+	r.dispatch(data)
+	r.dispatch(data)
+
+	resp := <-ch
+	require.Equal(t, []byte("pong"), resp.Payload)
+	require.Len(t, ch, 0)
+}
@@ -0,0 +1,145 @@
+package mqttclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/rs/xid"
+)
+
+const replyTopicPrefix = "_reply/"
+
+// envelope carries the request/response metadata in the payload, since MQTT 3.1.1 has no
+// response topic nor correlation data. Both sides use this package.
+type envelope struct {
+	ReplyTo       string `json:"reply_to,omitempty"`
+	CorrelationID string `json:"correlation_id"`
+	Payload       []byte `json:"payload"`
+	Error         string `json:"error,omitempty"`
+}
+
+type replyRouter struct {
+	mu      sync.Mutex
+	topic   string
+	pending map[string]chan envelope
+}
+
+// ResponderFunc handles a request, the returned error is sent back to the requester.
+type ResponderFunc func(topic string, payload []byte) ([]byte, error)
+
+// Request publishes the payload to the topic, and waits for the response of the responder
+// subscribed to it by Respond. The requester and the responder must be in the same tenant.
+func (c *Client) Request(ctx context.Context, topic string, payload []byte) ([]byte, error) {
+	r, err := c.replyRouter()
+	if err != nil {
+		return nil, err
+	}
+
+	id := xid.New().String()
+	ch := make(chan envelope, 1)
+
+	r.mu.Lock()
+	r.pending[id] = ch
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, id)
+		r.mu.Unlock()
+	}()
+
+	data, err := json.Marshal(envelope{ReplyTo: r.topic, CorrelationID: id, Payload: payload})
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Publish(topic, 1, false, data); err != nil {
+		return nil, err
+	}
+
+	select {
+	case env := <-ch:
+		if len(env.Error) > 0 {
+			return nil, errors.New(env.Error)
+		}
+		return env.Payload, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Respond subscribes the filter, and publishes the result of the handler back to each requester.
+func (c *Client) Respond(filter string, qos byte, handler ResponderFunc) error {
+	return c.Subscribe(filter, qos, func(topic string, payload []byte) {
+		var req envelope
+		if err := json.Unmarshal(payload, &req); err != nil || len(req.ReplyTo) == 0 {
+			return
+		}
+
+		// Don't block the paho router while publishing the response.
+		go func() {
+			resp := envelope{CorrelationID: req.CorrelationID}
+			result, err := handler(topic, req.Payload)
+			if err != nil {
+				resp.Error = err.Error()
+			} else {
+				resp.Payload = result
+			}
+
+			if data, err := json.Marshal(resp); err == nil {
+				_ = c.Publish(req.ReplyTo, 1, false, data)
+			}
+		}()
+	})
+}
+
+// The reply topic of the client is subscribed at the first request.
+func (c *Client) replyRouter() (*replyRouter, error) {
+	c.mu.Lock()
+	r := c.replies
+	c.mu.Unlock()
+	if r != nil {
+		return r, nil
+	}
+
+	r = &replyRouter{
+		topic:   replyTopicPrefix + c.opts.ClientID + "/" + xid.New().String(),
+		pending: make(map[string]chan envelope),
+	}
+
+	err := c.Subscribe(r.topic, 1, func(topic string, payload []byte) {
+		r.dispatch(payload)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.replies != nil {
+		r = c.replies
+	} else {
+		c.replies = r
+	}
+	c.mu.Unlock()
+
+	return r, nil
+}
+
+func (r *replyRouter) dispatch(payload []byte) {
+	var resp envelope
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	ch, ok := r.pending[resp.CorrelationID]
+	r.mu.Unlock()
+
+	if ok {
+		select {
+		case ch <- resp:
+		default:
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package mqttsn
+
+import "sync"
+
+// Message is a message delivered to a client of the gateway.
+type Message struct {
+	Topic   string
+	Qos     byte
+	Retain  bool
+	Payload []byte
+}
+
+// Buffer keeps the messages delivered to a sleeping client until it wakes up, the oldest ones are
+// dropped beyond max.
+type Buffer struct {
+	mu   sync.Mutex
+	max  int
+	msgs []Message
+}
+
+func NewBuffer(max int) *Buffer {
+	return &Buffer{max: max}
+}
+
+// Push keeps the message, it reports whether a message was dropped: the oldest one, or this one
+// if the buffer keeps none.
+func (b *Buffer) Push(m Message) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.max <= 0 {
+		return true
+	}
+	dropped := false
+	if len(b.msgs) >= b.max {
+		b.msgs = b.msgs[1:]
+		dropped = true
+	}
+	b.msgs = append(b.msgs, m)
+	return dropped
+}
+
+// Take returns the kept messages, oldest first, and empties the buffer.
+func (b *Buffer) Take() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	msgs := b.msgs
+	b.msgs = nil
+	return msgs
+}
+
+func (b *Buffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.msgs)
+}
@@ -0,0 +1,19 @@
+package mqttsn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuffer(t *testing.T) {
+	b := NewBuffer(2)
+	require.False(t, b.Push(Message{Topic: "a"}))
+	require.False(t, b.Push(Message{Topic: "b"}))
+	require.True(t, b.Push(Message{Topic: "c"}))
+	require.Equal(t, 2, b.Len())
+	require.Equal(t, []Message{{Topic: "b"}, {Topic: "c"}}, b.Take())
+	require.Equal(t, 0, b.Len())
+
+	require.True(t, NewBuffer(0).Push(Message{Topic: "a"}))
+}
@@ -0,0 +1,300 @@
+// Package mqttsn reads and writes the MQTT-SN 1.2 messages of the constrained devices, one per UDP
+// datagram, and keeps the topic ids and the buffered messages of the gateway clients. The broker
+// translates them into its publish and subscribe pipeline.
+package mqttsn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The types of the messages, the ones of the gateway discovery and of the will are not supported.
+const (
+	Connect     = byte(0x04)
+	Connack     = byte(0x05)
+	Register    = byte(0x0A)
+	Regack      = byte(0x0B)
+	Publish     = byte(0x0C)
+	Puback      = byte(0x0D)
+	Pubcomp     = byte(0x0E)
+	Pubrec      = byte(0x0F)
+	Pubrel      = byte(0x10)
+	Subscribe   = byte(0x12)
+	Suback      = byte(0x13)
+	Unsubscribe = byte(0x14)
+	Unsuback    = byte(0x15)
+	Pingreq     = byte(0x16)
+	Pingresp    = byte(0x17)
+	Disconnect  = byte(0x18)
+)
+
+// The return codes of CONNACK, REGACK, PUBACK and SUBACK.
+const (
+	Accepted          = byte(0x00)
+	RejectedCongested = byte(0x01)
+	RejectedTopicID   = byte(0x02)
+	RejectedNotSupp   = byte(0x03)
+)
+
+// The types of the topic of PUBLISH, SUBSCRIBE and UNSUBSCRIBE, the two low bits of the flags.
+const (
+	// TopicNormal is a topic id registered with REGISTER, or a topic name in a SUBSCRIBE
+	TopicNormal = byte(0x00)
+	// TopicPredefined is a topic id both the client and the gateway know beforehand
+	TopicPredefined = byte(0x01)
+	// TopicShort is a topic name of two characters, sent in place of the topic id
+	TopicShort = byte(0x02)
+)
+
+// QosMinusOne is the QoS -1 of the PUBLISH sent without connecting, to a predefined or short topic.
+const QosMinusOne = byte(3)
+
+// ProtocolID is the protocol id of the CONNECT of MQTT-SN 1.2.
+const ProtocolID = byte(0x01)
+
+var (
+	errShort   = errors.New("mqttsn/codec: the message is too short")
+	errLength  = errors.New("mqttsn/codec: the length of the message doesn't match the datagram")
+	errUnknown = errors.New("mqttsn/codec: unknown or unsupported message type")
+)
+
+// Flags is the flags byte of CONNECT, PUBLISH, SUBSCRIBE and UNSUBSCRIBE.
+type Flags struct {
+	Dup          bool
+	Qos          byte
+	Retain       bool
+	Will         bool
+	CleanSession bool
+	TopicIDType  byte
+}
+
+func (f Flags) byte() byte {
+	b := f.Qos<<5 | f.TopicIDType&0x03
+	if f.Dup {
+		b |= 0x80
+	}
+	if f.Retain {
+		b |= 0x10
+	}
+	if f.Will {
+		b |= 0x08
+	}
+	if f.CleanSession {
+		b |= 0x04
+	}
+	return b
+}
+
+func decodeFlags(b byte) Flags {
+	return Flags{
+		Dup:          b&0x80 != 0,
+		Qos:          b >> 5 & 0x03,
+		Retain:       b&0x10 != 0,
+		Will:         b&0x08 != 0,
+		CleanSession: b&0x04 != 0,
+		TopicIDType:  b & 0x03,
+	}
+}
+
+// Packet is an MQTT-SN message, the fields of its type are set.
+type Packet struct {
+	Type  byte
+	Flags Flags
+
+	// TopicID of REGISTER, REGACK, PUBLISH, PUBACK and SUBACK, or the two characters of a short
+	// topic name
+	TopicID uint16
+	MsgID   uint16
+	// ReturnCode of CONNACK, REGACK, PUBACK and SUBACK
+	ReturnCode byte
+	// Duration is the keep alive of CONNECT, or the sleep duration of DISCONNECT, in seconds
+	Duration uint16
+	// ClientID of CONNECT, or of the PINGREQ of a sleeping client
+	ClientID string
+	// TopicName of REGISTER, or the topic filter of a SUBSCRIBE and UNSUBSCRIBE of the normal type
+	TopicName string
+	// Data is the payload of a PUBLISH
+	Data []byte
+}
+
+// Decode reads the message of the datagram.
+func Decode(data []byte) (*Packet, error) {
+	if len(data) < 2 {
+		return nil, errShort
+	}
+	length, header := int(data[0]), 1
+	if data[0] == 0x01 {
+		if len(data) < 4 {
+			return nil, errShort
+		}
+		length, header = int(binary.BigEndian.Uint16(data[1:3])), 3
+	}
+	if length != len(data) || length <= header {
+		return nil, errLength
+	}
+
+	p := &Packet{Type: data[header]}
+	body := data[header+1:]
+	need := func(n int) error {
+		if len(body) < n {
+			return fmt.Errorf("mqttsn/codec/Decode: the message 0x%02x is too short", p.Type)
+		}
+		return nil
+	}
+
+	switch p.Type {
+	case Connect:
+		if err := need(4); err != nil {
+			return nil, err
+		}
+		p.Flags = decodeFlags(body[0])
+		if body[1] != ProtocolID {
+			return nil, fmt.Errorf("mqttsn/codec/Decode: unsupported protocol id 0x%02x", body[1])
+		}
+		p.Duration = binary.BigEndian.Uint16(body[2:4])
+		p.ClientID = string(body[4:])
+	case Connack:
+		if err := need(1); err != nil {
+			return nil, err
+		}
+		p.ReturnCode = body[0]
+	case Register:
+		if err := need(4); err != nil {
+			return nil, err
+		}
+		p.TopicID = binary.BigEndian.Uint16(body[0:2])
+		p.MsgID = binary.BigEndian.Uint16(body[2:4])
+		p.TopicName = string(body[4:])
+	case Regack, Puback:
+		if err := need(5); err != nil {
+			return nil, err
+		}
+		p.TopicID = binary.BigEndian.Uint16(body[0:2])
+		p.MsgID = binary.BigEndian.Uint16(body[2:4])
+		p.ReturnCode = body[4]
+	case Publish:
+		if err := need(5); err != nil {
+			return nil, err
+		}
+		p.Flags = decodeFlags(body[0])
+		p.TopicID = binary.BigEndian.Uint16(body[1:3])
+		p.MsgID = binary.BigEndian.Uint16(body[3:5])
+		p.Data = append([]byte(nil), body[5:]...)
+	case Pubcomp, Pubrec, Pubrel, Unsuback:
+		if err := need(2); err != nil {
+			return nil, err
+		}
+		p.MsgID = binary.BigEndian.Uint16(body[0:2])
+	case Subscribe, Unsubscribe:
+		if err := need(3); err != nil {
+			return nil, err
+		}
+		p.Flags = decodeFlags(body[0])
+		p.MsgID = binary.BigEndian.Uint16(body[1:3])
+		if p.Flags.TopicIDType == TopicNormal {
+			p.TopicName = string(body[3:])
+		} else {
+			if err := need(5); err != nil {
+				return nil, err
+			}
+			p.TopicID = binary.BigEndian.Uint16(body[3:5])
+		}
+	case Suback:
+		if err := need(6); err != nil {
+			return nil, err
+		}
+		p.Flags = decodeFlags(body[0])
+		p.TopicID = binary.BigEndian.Uint16(body[1:3])
+		p.MsgID = binary.BigEndian.Uint16(body[3:5])
+		p.ReturnCode = body[5]
+	case Pingreq:
+		p.ClientID = string(body)
+	case Pingresp:
+	case Disconnect:
+		if len(body) >= 2 {
+			p.Duration = binary.BigEndian.Uint16(body[0:2])
+		}
+	default:
+		return nil, errUnknown
+	}
+	return p, nil
+}
+
+// Encode returns the datagram of the message.
+func Encode(p *Packet) ([]byte, error) {
+	var body []byte
+	u16 := func(v uint16) {
+		body = append(body, byte(v>>8), byte(v))
+	}
+
+	switch p.Type {
+	case Connect:
+		body = append(body, p.Flags.byte(), ProtocolID)
+		u16(p.Duration)
+		body = append(body, p.ClientID...)
+	case Connack:
+		body = append(body, p.ReturnCode)
+	case Register:
+		u16(p.TopicID)
+		u16(p.MsgID)
+		body = append(body, p.TopicName...)
+	case Regack, Puback:
+		u16(p.TopicID)
+		u16(p.MsgID)
+		body = append(body, p.ReturnCode)
+	case Publish:
+		body = append(body, p.Flags.byte())
+		u16(p.TopicID)
+		u16(p.MsgID)
+		body = append(body, p.Data...)
+	case Pubcomp, Pubrec, Pubrel, Unsuback:
+		u16(p.MsgID)
+	case Subscribe, Unsubscribe:
+		body = append(body, p.Flags.byte())
+		u16(p.MsgID)
+		if p.Flags.TopicIDType == TopicNormal {
+			body = append(body, p.TopicName...)
+		} else {
+			u16(p.TopicID)
+		}
+	case Suback:
+		body = append(body, p.Flags.byte())
+		u16(p.TopicID)
+		u16(p.MsgID)
+		body = append(body, p.ReturnCode)
+	case Pingreq:
+		body = append(body, p.ClientID...)
+	case Pingresp:
+	case Disconnect:
+		if p.Duration > 0 {
+			u16(p.Duration)
+		}
+	default:
+		return nil, errUnknown
+	}
+
+	// the length byte counts itself, the 3 bytes form is used beyond 255
+	n := len(body) + 2
+	if n <= 0xFF {
+		return append([]byte{byte(n), p.Type}, body...), nil
+	}
+	n += 2
+	if n > 0xFFFF {
+		return nil, fmt.Errorf("mqttsn/codec/Encode: the message is too long, %d bytes", n)
+	}
+	return append([]byte{0x01, byte(n >> 8), byte(n), p.Type}, body...), nil
+}
+
+// ShortTopic returns the topic name of the topic id of the short type.
+func ShortTopic(id uint16) string {
+	return string([]byte{byte(id >> 8), byte(id)})
+}
+
+// ShortTopicID returns the topic id of the short topic name, false if it's not two characters.
+func ShortTopicID(topic string) (uint16, bool) {
+	if len(topic) != 2 {
+		return 0, false
+	}
+	return uint16(topic[0])<<8 | uint16(topic[1]), true
+}
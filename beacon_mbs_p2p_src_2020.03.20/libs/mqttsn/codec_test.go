@@ -0,0 +1,67 @@
+package mqttsn
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodec(t *testing.T) {
+	for _, p := range []*Packet{
+		{Type: Connect, Flags: Flags{CleanSession: true}, Duration: 60, ClientID: "sensor-1"},
+		{Type: Connack, ReturnCode: Accepted},
+		{Type: Register, TopicID: 7, MsgID: 1, TopicName: "sensors/1/temp"},
+		{Type: Regack, TopicID: 7, MsgID: 1, ReturnCode: Accepted},
+		{Type: Publish, Flags: Flags{Qos: 1, Retain: true, TopicIDType: TopicNormal}, TopicID: 7, MsgID: 2, Data: []byte("21.5")},
+		{Type: Publish, Flags: Flags{Qos: QosMinusOne, TopicIDType: TopicPredefined}, TopicID: 1, Data: []byte("on")},
+		{Type: Puback, TopicID: 7, MsgID: 2, ReturnCode: RejectedTopicID},
+		{Type: Pubrel, MsgID: 3},
+		{Type: Subscribe, Flags: Flags{Qos: 1}, MsgID: 4, TopicName: "sensors/+/temp"},
+		{Type: Subscribe, Flags: Flags{TopicIDType: TopicShort}, MsgID: 5, TopicID: 0x6162},
+		{Type: Suback, Flags: Flags{Qos: 1}, TopicID: 0, MsgID: 4, ReturnCode: Accepted},
+		{Type: Unsubscribe, MsgID: 6, TopicName: "sensors/+/temp"},
+		{Type: Unsuback, MsgID: 6},
+		{Type: Pingreq, ClientID: "sensor-1"},
+		{Type: Pingresp},
+		{Type: Disconnect, Duration: 300},
+		{Type: Disconnect},
+	} {
+		data, err := Encode(p)
+		require.NoError(t, err)
+		require.Equal(t, len(data), int(data[0]))
+
+		decoded, err := Decode(data)
+		require.NoError(t, err)
+		require.Equal(t, p, decoded)
+	}
+}
+
+func TestCodecLongMessage(t *testing.T) {
+	p := &Packet{Type: Publish, Flags: Flags{Qos: 1}, TopicID: 1, MsgID: 1, Data: bytes.Repeat([]byte("x"), 300)}
+	data, err := Encode(p)
+	require.NoError(t, err)
+	require.Equal(t, byte(0x01), data[0])
+
+	decoded, err := Decode(data)
+	require.NoError(t, err)
+	require.Equal(t, p, decoded)
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		{0x02},
+		// the length doesn't match the datagram
+		{0x05, Pingresp},
+		// too short for a PUBLISH
+		{0x04, Publish, 0x00, 0x01},
+		// not MQTT-SN 1.2
+		{0x06, Connect, 0x04, 0x02, 0x00, 0x3C},
+		// a will topic request is not supported
+		{0x02, 0x06},
+	} {
+		_, err := Decode(data)
+		require.Error(t, err)
+	}
+}
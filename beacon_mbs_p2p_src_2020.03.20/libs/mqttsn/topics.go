@@ -0,0 +1,87 @@
+package mqttsn
+
+import (
+	"errors"
+	"sync"
+)
+
+var errTopicIDs = errors.New("mqttsn/topics/Register: no topic id left")
+
+// Topics are the topic ids of a client: the predefined ones, shared by all the clients of the
+// gateway, and the ones registered by the client with REGISTER or by the gateway for the
+// deliveries to it. The ids live as long as the connection of the client.
+type Topics struct {
+	predefined    map[uint16]string
+	predefinedIDs map[string]uint16
+
+	mu     sync.Mutex
+	byID   map[uint16]string
+	byName map[string]uint16
+	last   uint16
+}
+
+// NewTopics returns the topic ids of a new client, the predefined map is not changed.
+func NewTopics(predefined map[uint16]string) *Topics {
+	t := &Topics{
+		predefined:    predefined,
+		predefinedIDs: make(map[string]uint16, len(predefined)),
+		byID:          make(map[uint16]string),
+		byName:        make(map[string]uint16),
+	}
+	for id, name := range predefined {
+		t.predefinedIDs[name] = id
+	}
+	return t
+}
+
+// Register returns the normal topic id of the topic name, a new one if it has none yet.
+func (t *Topics) Register(name string) (uint16, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if id, ok := t.byName[name]; ok {
+		return id, nil
+	}
+	// 0x0000 and 0xFFFF are reserved
+	if t.last >= 0xFFFE {
+		return 0, errTopicIDs
+	}
+	t.last++
+	t.byID[t.last] = name
+	t.byName[name] = t.last
+	return t.last, nil
+}
+
+// Name returns the topic name of the topic id of the type, false if it's unknown.
+func (t *Topics) Name(idType byte, id uint16) (string, bool) {
+	switch idType {
+	case TopicPredefined:
+		name, ok := t.predefined[id]
+		return name, ok
+	case TopicShort:
+		return ShortTopic(id), true
+	case TopicNormal:
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		name, ok := t.byID[id]
+		return name, ok
+	}
+	return "", false
+}
+
+// ID returns the topic id of the topic name and its type, a predefined id first, then a short
+// topic name and a registered id. It's false if the topic has no id yet, it's registered to the
+// client before the message is delivered.
+func (t *Topics) ID(name string) (byte, uint16, bool) {
+	if id, ok := t.predefinedIDs[name]; ok {
+		return TopicPredefined, id, true
+	}
+	if id, ok := ShortTopicID(name); ok {
+		return TopicShort, id, true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id, ok := t.byName[name]
+	return TopicNormal, id, ok
+}
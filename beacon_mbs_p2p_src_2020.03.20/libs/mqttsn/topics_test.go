@@ -0,0 +1,40 @@
+package mqttsn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopics(t *testing.T) {
+	topics := NewTopics(map[uint16]string{1: "devices/cmd"})
+
+	id, err := topics.Register("sensors/1/temp")
+	require.NoError(t, err)
+	require.Equal(t, uint16(1), id)
+	again, err := topics.Register("sensors/1/temp")
+	require.NoError(t, err)
+	require.Equal(t, id, again)
+
+	name, ok := topics.Name(TopicNormal, id)
+	require.True(t, ok)
+	require.Equal(t, "sensors/1/temp", name)
+	name, ok = topics.Name(TopicPredefined, 1)
+	require.True(t, ok)
+	require.Equal(t, "devices/cmd", name)
+	name, ok = topics.Name(TopicShort, 0x6162)
+	require.True(t, ok)
+	require.Equal(t, "ab", name)
+	_, ok = topics.Name(TopicNormal, 2)
+	require.False(t, ok)
+
+	idType, id, ok := topics.ID("devices/cmd")
+	require.True(t, ok)
+	require.Equal(t, TopicPredefined, idType)
+	require.Equal(t, uint16(1), id)
+	idType, _, ok = topics.ID("ab")
+	require.True(t, ok)
+	require.Equal(t, TopicShort, idType)
+	_, _, ok = topics.ID("sensors/2/temp")
+	require.False(t, ok)
+}
@@ -0,0 +1,245 @@
+package namespace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"awesomeProject/beacon/mqtt_network/libs/acl"
+)
+
+// ClientSeparators may end the client prefix of a namespace, so the prefix acme- doesn't own the
+// clients of acmeco-.
+const ClientSeparators = "-_.:"
+
+var (
+	ErrUnknownToken = errors.New("namespace: unknown admin token")
+	ErrOutOfScope   = errors.New("namespace: out of the namespace scope")
+)
+
+// Namespace is a topic subtree and the clients of a tenant. An empty Prefix is the root namespace,
+// which holds all the topics and the clients. The ClientPrefix of the other namespaces ends with
+// one of the ClientSeparators.
+type Namespace struct {
+	Name         string `json:"name"`
+	Prefix       string `json:"prefix"`
+	ClientPrefix string `json:"client_prefix"`
+
+	parent *Namespace
+	// the ACL set by the admin of the namespace and its rules, nil until it's set
+	acl      *acl.Engine
+	aclRules acl.Config
+}
+
+// Parent returns the namespace which delegated this one, nil for the root delegations.
+func (ns *Namespace) Parent() *Namespace {
+	return ns.parent
+}
+
+// ContainsTopic reports whether the topic is in the subtree.
+func (ns *Namespace) ContainsTopic(topic string) bool {
+	if len(ns.Prefix) == 0 {
+		return true
+	}
+	return topic == ns.Prefix || strings.HasPrefix(topic, ns.Prefix+"/")
+}
+
+// ContainsFilter reports whether all the topics matched by the filter are in the subtree, the
+// wildcards are allowed below the prefix only.
+func (ns *Namespace) ContainsFilter(filter string) bool {
+	if len(ns.Prefix) == 0 {
+		return true
+	}
+	if filter == ns.Prefix+"/#" {
+		return true
+	}
+	return ns.ContainsTopic(filter)
+}
+
+// OwnsClient reports whether the client id starts with the client prefix.
+func (ns *Namespace) OwnsClient(clientID string) bool {
+	return strings.HasPrefix(clientID, ns.ClientPrefix)
+}
+
+// Contains reports whether the other namespace is nested in this one.
+func (ns *Namespace) Contains(other *Namespace) bool {
+	return ns.ContainsFilter(other.Prefix) && strings.HasPrefix(other.ClientPrefix, ns.ClientPrefix)
+}
+
+// Registry maps the admin tokens to their namespaces, only the SHA-256 digests of the tokens are
+// kept in memory.
+type Registry struct {
+	mu     sync.RWMutex
+	tokens map[string]*Namespace
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		tokens: make(map[string]*Namespace),
+	}
+}
+
+func digest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func validatePrefix(prefix string) error {
+	if strings.ContainsAny(prefix, "+#") || strings.HasSuffix(prefix, "/") {
+		return fmt.Errorf("namespace/namespace/validate: invalid prefix %q", prefix)
+	}
+	return nil
+}
+
+// validate checks the prefixes, only the root namespace may own all the clients.
+func validate(ns Namespace) error {
+	if err := validatePrefix(ns.Prefix); err != nil {
+		return err
+	}
+	if strings.ContainsAny(ns.ClientPrefix, "+#/") {
+		return fmt.Errorf("namespace/namespace/validate: invalid client prefix %q", ns.ClientPrefix)
+	}
+	if len(ns.ClientPrefix) == 0 && len(ns.Prefix) > 0 {
+		return fmt.Errorf("namespace/namespace/validate: the namespace %q needs a client prefix", ns.Name)
+	}
+	if len(ns.ClientPrefix) > 0 && !strings.ContainsAny(ns.ClientPrefix[len(ns.ClientPrefix)-1:], ClientSeparators) {
+		return fmt.Errorf("namespace/namespace/validate: the client prefix %q must end with one of %q", ns.ClientPrefix, ClientSeparators)
+	}
+	return nil
+}
+
+// Delegate grants the token the admin rights over the namespace, it's used by the operator.
+func (r *Registry) Delegate(ns Namespace, token string) error {
+	if len(token) == 0 {
+		return errors.New("namespace/namespace/Delegate: token cannot be empty")
+	}
+	if err := validate(ns); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tokens[digest(token)] = &ns
+	return nil
+}
+
+// DelegateFrom lets a tenant admin delegate a part of its own namespace to another token, such
+// as a site of the customer.
+func (r *Registry) DelegateFrom(parentToken string, ns Namespace, token string) error {
+	if len(token) == 0 {
+		return errors.New("namespace/namespace/DelegateFrom: token cannot be empty")
+	}
+
+	parent, err := r.Resolve(parentToken)
+	if err != nil {
+		return err
+	}
+
+	if err := validate(ns); err != nil {
+		return err
+	}
+	if !parent.Contains(&ns) {
+		return ErrOutOfScope
+	}
+
+	ns.parent = parent
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tokens[digest(token)] = &ns
+	return nil
+}
+
+// Revoke removes the token, and the tokens delegated from it.
+func (r *Registry) Revoke(token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := digest(token)
+	ns, ok := r.tokens[key]
+	if !ok {
+		return ErrUnknownToken
+	}
+	delete(r.tokens, key)
+
+	for k, child := range r.tokens {
+		for p := child.parent; p != nil; p = p.parent {
+			if p == ns {
+				delete(r.tokens, k)
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *Registry) Resolve(token string) (*Namespace, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ns, ok := r.tokens[digest(token)]
+	if !ok {
+		return nil, ErrUnknownToken
+	}
+	return ns, nil
+}
+
+// SetACL sets the ACL of the namespace of the token, the topics of its rules must be in the
+// namespace. The rules apply to the clients of the namespace on top of the ACL of the broker, and
+// of the namespaces it's delegated from: all of them must allow a publish or a subscription.
+func (r *Registry) SetACL(token string, cfg acl.Config) error {
+	ns, err := r.Resolve(token)
+	if err != nil {
+		return err
+	}
+	for _, rule := range cfg.Rules {
+		for _, t := range rule.Topics {
+			if !ns.ContainsFilter(t) {
+				return ErrOutOfScope
+			}
+		}
+	}
+	e, err := acl.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ns.acl, ns.aclRules = e, cfg
+	return nil
+}
+
+// ACL returns the rules of the ACL of the namespace of the token, false if none is set.
+func (r *Registry) ACL(token string) (acl.Config, bool, error) {
+	ns, err := r.Resolve(token)
+	if err != nil {
+		return acl.Config{}, false, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return ns.aclRules, ns.acl != nil, nil
+}
+
+// Check reports whether the ACLs of all the namespaces owning the client allow it to publish to
+// the topic or to subscribe to the filter, it's allowed if none has an ACL.
+func (r *Registry) Check(clientID string, username string, access acl.Access, topic string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, ns := range r.tokens {
+		if ns.acl != nil && ns.OwnsClient(clientID) && !ns.acl.Check(clientID, username, access, topic) {
+			return false
+		}
+	}
+	return true
+}
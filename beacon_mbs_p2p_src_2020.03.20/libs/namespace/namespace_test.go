@@ -0,0 +1,104 @@
+package namespace
+
+import (
+	"testing"
+
+	"awesomeProject/beacon/mqtt_network/libs/acl"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespaceContains(t *testing.T) {
+	ns := &Namespace{Name: "acme", Prefix: "tenants/acme", ClientPrefix: "acme-"}
+
+	require.True(t, ns.ContainsTopic("tenants/acme"))
+	require.True(t, ns.ContainsTopic("tenants/acme/room1/temp"))
+	require.False(t, ns.ContainsTopic("tenants/acmeco/room1"))
+	require.False(t, ns.ContainsTopic("tenants"))
+
+	require.True(t, ns.ContainsFilter("tenants/acme/#"))
+	require.True(t, ns.ContainsFilter("tenants/acme/+/temp"))
+	require.False(t, ns.ContainsFilter("tenants/+/room1"))
+	require.False(t, ns.ContainsFilter("#"))
+
+	require.True(t, ns.OwnsClient("acme-sensor-1"))
+	require.False(t, ns.OwnsClient("globex-sensor-1"))
+	require.False(t, ns.OwnsClient("acmeco-sensor-1"))
+
+	root := &Namespace{Name: "root"}
+	require.True(t, root.ContainsFilter("#"))
+	require.True(t, root.OwnsClient("globex-sensor-1"))
+	require.True(t, root.Contains(ns))
+	require.False(t, ns.Contains(root))
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	require.Error(t, r.Delegate(Namespace{Name: "acme", Prefix: "tenants/+", ClientPrefix: "acme-"}, "t1"))
+	require.Error(t, r.Delegate(Namespace{Name: "acme", Prefix: "tenants/acme", ClientPrefix: "acme-"}, ""))
+	// the namespace must own its clients, by a prefix ending with a separator
+	require.Error(t, r.Delegate(Namespace{Name: "acme", Prefix: "tenants/acme"}, "t1"))
+	require.Error(t, r.Delegate(Namespace{Name: "acme", Prefix: "tenants/acme", ClientPrefix: "acme"}, "t1"))
+	require.NoError(t, r.Delegate(Namespace{Name: "acme", Prefix: "tenants/acme", ClientPrefix: "acme-"}, "t1"))
+
+	ns, err := r.Resolve("t1")
+	require.NoError(t, err)
+	require.Equal(t, "acme", ns.Name)
+	_, err = r.Resolve("t2")
+	require.Equal(t, ErrUnknownToken, err)
+
+	require.Equal(t, ErrOutOfScope, r.DelegateFrom("t1", Namespace{Name: "globex", Prefix: "tenants/globex", ClientPrefix: "acme-"}, "t2"))
+	require.Equal(t, ErrOutOfScope, r.DelegateFrom("t1", Namespace{Name: "site1", Prefix: "tenants/acme/site1", ClientPrefix: "globex-"}, "t2"))
+	require.NoError(t, r.DelegateFrom("t1", Namespace{Name: "site1", Prefix: "tenants/acme/site1", ClientPrefix: "acme-site1-"}, "t2"))
+	require.NoError(t, r.DelegateFrom("t2", Namespace{Name: "line1", Prefix: "tenants/acme/site1/line1", ClientPrefix: "acme-site1-"}, "t3"))
+
+	ns, err = r.Resolve("t3")
+	require.NoError(t, err)
+	require.Equal(t, "site1", ns.Parent().Name)
+
+	// revoking a token revokes the delegated ones
+	require.NoError(t, r.Revoke("t1"))
+	for _, token := range []string{"t1", "t2", "t3"} {
+		_, err = r.Resolve(token)
+		require.Equal(t, ErrUnknownToken, err)
+	}
+	require.Equal(t, ErrUnknownToken, r.Revoke("t1"))
+}
+
+func TestRegistryACL(t *testing.T) {
+	r := NewRegistry()
+	require.NoError(t, r.Delegate(Namespace{Name: "acme", Prefix: "tenants/acme", ClientPrefix: "acme-"}, "t1"))
+	require.NoError(t, r.DelegateFrom("t1", Namespace{Name: "site1", Prefix: "tenants/acme/site1", ClientPrefix: "acme-site1-"}, "t2"))
+	require.True(t, r.Check("acme-site1-d1", "", acl.Publish, "tenants/acme/site2/x"))
+
+	_, ok, err := r.ACL("t1")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// the rules stay in the namespace
+	require.Equal(t, ErrOutOfScope, r.SetACL("t2", acl.Config{Rules: []acl.Rule{{Action: acl.Allow, Topics: []string{"tenants/acme/#"}}}}))
+	require.Error(t, r.SetACL("t2", acl.Config{Default: "maybe"}))
+
+	cfg := acl.Config{Default: acl.Allow, Rules: []acl.Rule{{Action: acl.Deny, Access: "publish", Topics: []string{"tenants/acme/site2/#"}}}}
+	require.NoError(t, r.SetACL("t1", cfg))
+	got, ok, err := r.ACL("t1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, cfg, got)
+
+	require.False(t, r.Check("acme-site1-d1", "", acl.Publish, "tenants/acme/site2/x"))
+	require.True(t, r.Check("acme-site1-d1", "", acl.Publish, "tenants/acme/site1/x"))
+	require.True(t, r.Check("globex-d1", "", acl.Publish, "tenants/acme/site2/x"))
+
+	// the delegated namespace cannot allow what its parent denies
+	require.NoError(t, r.SetACL("t2", acl.Config{Default: acl.Allow}))
+	require.False(t, r.Check("acme-site1-d1", "", acl.Publish, "tenants/acme/site2/x"))
+	require.NoError(t, r.SetACL("t2", acl.Config{Rules: []acl.Rule{{Action: acl.Allow, Topics: []string{"tenants/acme/site1/#"}}}}))
+	require.True(t, r.Check("acme-site1-d1", "", acl.Subscribe, "tenants/acme/site1/a/#"))
+	require.False(t, r.Check("acme-site1-d1", "", acl.Subscribe, "tenants/acme/site3/#"))
+
+	// the rules of a revoked namespace are gone with it
+	require.NoError(t, r.Revoke("t1"))
+	require.True(t, r.Check("acme-site1-d1", "", acl.Publish, "tenants/acme/site2/x"))
+}
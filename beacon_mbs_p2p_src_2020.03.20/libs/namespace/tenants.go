@@ -0,0 +1,188 @@
+package namespace
+
+import (
+	"fmt"
+	"strings"
+)
+
+const sharePrefix = "$share/"
+
+// Tenant is the topic namespace of a tenant: its clients publish and subscribe below Prefix
+// without knowing it, the prefix is prepended to their topics and stripped from the deliveries.
+type Tenant struct {
+	Name   string `json:"name" yaml:"name"`
+	Prefix string `json:"prefix" yaml:"prefix"`
+}
+
+// SharedTopics is a bridge rule across the namespaces: the topics of Filter are left out of the
+// namespaces of the Tenants, all of them if it's empty, so they're shared with the other tenants
+// and the clients without one. Publish and Subscribe pick the direction shared, the other one stays
+// in the namespace.
+type SharedTopics struct {
+	Filter    string   `json:"filter" yaml:"filter"`
+	Tenants   []string `json:"tenants" yaml:"tenants"`
+	Publish   bool     `json:"publish" yaml:"publish"`
+	Subscribe bool     `json:"subscribe" yaml:"subscribe"`
+}
+
+// TenantConfig assigns the tenants by the address of the listener the clients connect to and by
+// their username, the username wins.
+type TenantConfig struct {
+	Tenants   []Tenant          `json:"tenants" yaml:"tenants"`
+	Listeners map[string]string `json:"listeners" yaml:"listeners"`
+	Users     map[string]string `json:"users" yaml:"users"`
+	Shared    []SharedTopics    `json:"shared" yaml:"shared"`
+}
+
+// Tenants maps the topics of the clients of the tenants to their namespaces, it's read-only once
+// built.
+type Tenants struct {
+	listeners map[string]*Tenant
+	users     map[string]*Tenant
+	shared    []SharedTopics
+}
+
+// NewTenants checks the config, the prefixes of two tenants cannot be nested or the tenants would
+// see the topics of each other.
+func NewTenants(cfg TenantConfig) (*Tenants, error) {
+	byName := make(map[string]*Tenant, len(cfg.Tenants))
+	for i := range cfg.Tenants {
+		tn := &cfg.Tenants[i]
+		if len(tn.Name) == 0 || byName[tn.Name] != nil {
+			return nil, fmt.Errorf("namespace/tenants/NewTenants: missing or duplicate tenant name %q", tn.Name)
+		}
+		if len(tn.Prefix) == 0 || strings.HasPrefix(tn.Prefix, "$") {
+			return nil, fmt.Errorf("namespace/tenants/NewTenants: invalid prefix %q of the tenant %s", tn.Prefix, tn.Name)
+		}
+		if err := validatePrefix(tn.Prefix); err != nil {
+			return nil, err
+		}
+		for _, other := range byName {
+			a, b := &Namespace{Prefix: tn.Prefix}, &Namespace{Prefix: other.Prefix}
+			if a.ContainsTopic(b.Prefix) || b.ContainsTopic(a.Prefix) {
+				return nil, fmt.Errorf("namespace/tenants/NewTenants: the prefixes of the tenants %s and %s are nested", tn.Name, other.Name)
+			}
+		}
+		byName[tn.Name] = tn
+	}
+
+	t := &Tenants{
+		listeners: make(map[string]*Tenant, len(cfg.Listeners)),
+		users:     make(map[string]*Tenant, len(cfg.Users)),
+	}
+	for addr, name := range cfg.Listeners {
+		if t.listeners[addr] = byName[name]; t.listeners[addr] == nil {
+			return nil, fmt.Errorf("namespace/tenants/NewTenants: unknown tenant %q of the listener %s", name, addr)
+		}
+	}
+	for user, name := range cfg.Users {
+		if t.users[user] = byName[name]; t.users[user] == nil {
+			return nil, fmt.Errorf("namespace/tenants/NewTenants: unknown tenant %q of the user %s", name, user)
+		}
+	}
+	for _, s := range cfg.Shared {
+		if len(s.Filter) == 0 || strings.HasPrefix(s.Filter, "$") {
+			return nil, fmt.Errorf("namespace/tenants/NewTenants: invalid shared filter %q", s.Filter)
+		}
+		for _, name := range s.Tenants {
+			if byName[name] == nil {
+				return nil, fmt.Errorf("namespace/tenants/NewTenants: unknown tenant %q of the shared filter %s", name, s.Filter)
+			}
+		}
+		t.shared = append(t.shared, s)
+	}
+	return t, nil
+}
+
+// Resolve returns the tenant of the client, nil if it has none.
+func (t *Tenants) Resolve(listener string, username string) *Tenant {
+	if tn, ok := t.users[username]; ok && len(username) > 0 {
+		return tn
+	}
+	return t.listeners[listener]
+}
+
+// PublishTopic returns the topic of the publish of a client of the tenant in the namespace.
+func (t *Tenants) PublishTopic(tn *Tenant, topic string) string {
+	if tn == nil || t.isShared(tn, topic, true) {
+		return topic
+	}
+	return tn.Prefix + "/" + topic
+}
+
+// SubscribeFilter returns the filter of a subscription of a client of the tenant in the namespace,
+// the share group of a shared subscription is kept.
+func (t *Tenants) SubscribeFilter(tn *Tenant, filter string) string {
+	if tn == nil {
+		return filter
+	}
+	group := ""
+	if strings.HasPrefix(filter, sharePrefix) {
+		i := strings.IndexByte(filter[len(sharePrefix):], '/')
+		if i < 0 {
+			return filter
+		}
+		group, filter = filter[:len(sharePrefix)+i+1], filter[len(sharePrefix)+i+1:]
+	}
+	if t.isShared(tn, filter, false) {
+		return group + filter
+	}
+	return group + tn.Prefix + "/" + filter
+}
+
+// DeliveryTopic returns the topic of a delivery to a client of the tenant, without the prefix of
+// the namespace. The shared topics are delivered as they are.
+func (t *Tenants) DeliveryTopic(tn *Tenant, topic string) string {
+	if tn == nil || !strings.HasPrefix(topic, tn.Prefix+"/") {
+		return topic
+	}
+	return topic[len(tn.Prefix)+1:]
+}
+
+// isShared reports whether a shared rule of the tenant covers all the topics of the filter, in the
+// direction of the publishes or of the subscriptions.
+func (t *Tenants) isShared(tn *Tenant, filter string, publish bool) bool {
+	for _, s := range t.shared {
+		if (publish && !s.Publish) || (!publish && !s.Subscribe) || !s.applies(tn) {
+			continue
+		}
+		if filterCovers(s.Filter, filter) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SharedTopics) applies(tn *Tenant) bool {
+	if len(s.Tenants) == 0 {
+		return true
+	}
+	for _, name := range s.Tenants {
+		if name == tn.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// filterCovers reports whether all the topics matched by the filter are matched by the rule too,
+// the filter may be a topic.
+func filterCovers(rule string, filter string) bool {
+	r, f := strings.Split(rule, "/"), strings.Split(filter, "/")
+	for i, level := range r {
+		if level == "#" {
+			return true
+		}
+		if i >= len(f) {
+			return false
+		}
+		switch {
+		case f[i] == "#":
+			return false
+		case level == "+":
+		case level != f[i]:
+			return false
+		}
+	}
+	return len(r) == len(f)
+}
@@ -0,0 +1,56 @@
+package namespace
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenants(t *testing.T) {
+	tenants, err := NewTenants(TenantConfig{
+		Tenants:   []Tenant{{Name: "acme", Prefix: "t/acme"}, {Name: "globex", Prefix: "t/globex"}},
+		Listeners: map[string]string{"0.0.0.0:1884": "acme"},
+		Users:     map[string]string{"globex-app": "globex"},
+		Shared: []SharedTopics{
+			{Filter: "global/news/#", Subscribe: true},
+			{Filter: "partners/+/orders", Tenants: []string{"acme"}, Publish: true, Subscribe: true},
+		},
+	})
+	require.NoError(t, err)
+
+	acme := tenants.Resolve("0.0.0.0:1884", "")
+	require.Equal(t, "acme", acme.Name)
+	globex := tenants.Resolve("0.0.0.0:1884", "globex-app")
+	require.Equal(t, "globex", globex.Name)
+	require.Nil(t, tenants.Resolve("0.0.0.0:1883", "someone"))
+
+	require.Equal(t, "t/acme/room1/temp", tenants.PublishTopic(acme, "room1/temp"))
+	require.Equal(t, "room1/temp", tenants.PublishTopic(nil, "room1/temp"))
+	require.Equal(t, "room1/temp", tenants.DeliveryTopic(acme, "t/acme/room1/temp"))
+	require.Equal(t, "global/news/a", tenants.DeliveryTopic(acme, "global/news/a"))
+
+	require.Equal(t, "t/acme/#", tenants.SubscribeFilter(acme, "#"))
+	require.Equal(t, "$share/g/t/acme/room1/+", tenants.SubscribeFilter(acme, "$share/g/room1/+"))
+
+	// the news are read from the shared topics but published in the namespace
+	require.Equal(t, "global/news/+", tenants.SubscribeFilter(acme, "global/news/+"))
+	require.Equal(t, "global/news", tenants.SubscribeFilter(globex, "global/news"))
+	require.Equal(t, "t/acme/global/#", tenants.SubscribeFilter(acme, "global/#"))
+	require.Equal(t, "t/acme/global/news/a", tenants.PublishTopic(acme, "global/news/a"))
+
+	// the orders are shared by acme only
+	require.Equal(t, "partners/x/orders", tenants.PublishTopic(acme, "partners/x/orders"))
+	require.Equal(t, "t/acme/partners/+/+", tenants.SubscribeFilter(acme, "partners/+/+"))
+	require.Equal(t, "t/globex/partners/x/orders", tenants.PublishTopic(globex, "partners/x/orders"))
+}
+
+func TestTenantsInvalid(t *testing.T) {
+	_, err := NewTenants(TenantConfig{Tenants: []Tenant{{Name: "a", Prefix: "t/a"}, {Name: "b", Prefix: "t/a/b"}}})
+	require.Error(t, err)
+	_, err = NewTenants(TenantConfig{Tenants: []Tenant{{Name: "a", Prefix: "t/+"}}})
+	require.Error(t, err)
+	_, err = NewTenants(TenantConfig{Tenants: []Tenant{{Name: "a", Prefix: "t/a"}}, Users: map[string]string{"u": "b"}})
+	require.Error(t, err)
+	_, err = NewTenants(TenantConfig{Shared: []SharedTopics{{Filter: "x/#", Tenants: []string{"b"}}}})
+	require.Error(t, err)
+}
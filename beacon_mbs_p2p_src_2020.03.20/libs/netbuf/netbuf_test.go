@@ -0,0 +1,210 @@
+package netbuf
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+)
+
+// countReader counts the reads of its source, and returns at most max bytes per read.
+type countReader struct {
+	r     io.Reader
+	max   int
+	reads int
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	c.reads++
+	if len(p) > c.max {
+		p = p[:c.max]
+	}
+	return c.r.Read(p)
+}
+
+func publish(topic string, payload []byte, qos byte) *packets.PublishPacket {
+	p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	p.TopicName = topic
+	p.Payload = payload
+	p.Qos = qos
+	if qos > 0 {
+		p.MessageID = 7
+	}
+	return p
+}
+
+func TestPool(t *testing.T) {
+	for _, n := range []int{0, 1, 512, 513, 4096, 65536} {
+		b := Get(n)
+		require.Len(t, *b, n)
+		c, ok := class(n)
+		require.True(t, ok)
+		require.Equal(t, 1<<(c+minClassBits), cap(*b))
+		Put(b)
+	}
+	b := Get(1<<maxClassBits + 1)
+	require.Len(t, *b, 1<<maxClassBits+1)
+	Put(b)
+}
+
+func TestReadPacket(t *testing.T) {
+	var stream bytes.Buffer
+	payload := bytes.Repeat([]byte("21.5;"), 400)
+	require.NoError(t, publish("sensors/s1/temp", payload, 1).Write(&stream))
+	sub := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
+	sub.MessageID = 3
+	sub.Topics = []string{"a/+", "b/#"}
+	sub.Qoss = []byte{0, 1}
+	require.NoError(t, sub.Write(&stream))
+	require.NoError(t, publish("sensors/s2/temp", []byte("20"), 0).Write(&stream))
+
+	src := &countReader{r: &stream, max: 1 << 20}
+	r := NewReader(src, 4096)
+
+	cp, err := ReadPacket(r)
+	require.NoError(t, err)
+	p := cp.(*packets.PublishPacket)
+
+	// the body goes back to the pool, the packet doesn't share it
+	b := Get(len(payload))
+	for i := range *b {
+		(*b)[i] = 0
+	}
+	Put(b)
+	require.Equal(t, "sensors/s1/temp", p.TopicName)
+	require.Equal(t, payload, p.Payload)
+	require.Equal(t, uint16(7), p.MessageID)
+
+	cp, err = ReadPacket(r)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/+", "b/#"}, cp.(*packets.SubscribePacket).Topics)
+	cp, err = ReadPacket(r)
+	require.NoError(t, err)
+	require.Equal(t, []byte("20"), cp.(*packets.PublishPacket).Payload)
+
+	// the first byte of the stream is read on its own, the rest of it at once
+	require.Equal(t, 2, src.reads)
+	require.Zero(t, r.Buffered())
+	require.Nil(t, r.buf)
+
+	_, err = ReadPacket(r)
+	require.Equal(t, io.EOF, err)
+
+	_, err = ReadPacket(bytes.NewReader([]byte{0x30, 0xFF, 0xFF, 0xFF, 0xFF}))
+	require.Equal(t, ErrMalformed, err)
+}
+
+func TestReaderPartialReads(t *testing.T) {
+	var stream bytes.Buffer
+	for i := 0; i < 10; i++ {
+		require.NoError(t, publish("a/b", bytes.Repeat([]byte{byte(i)}, 100), 0).Write(&stream))
+	}
+	r := NewReader(&countReader{r: &stream, max: 7}, 512)
+	for i := 0; i < 10; i++ {
+		cp, err := ReadPacket(r)
+		require.NoError(t, err)
+		require.Equal(t, bytes.Repeat([]byte{byte(i)}, 100), cp.(*packets.PublishPacket).Payload)
+	}
+	r.Release()
+}
+
+func TestWriter(t *testing.T) {
+	var w Writer
+	small := []byte("header")
+	large := bytes.Repeat([]byte("x"), 3*chunkSize)
+	shared := bytes.Repeat([]byte("y"), 1000)
+
+	var want []byte
+	for i := 0; i < 3; i++ {
+		_, _ = w.Write(small)
+		_, _ = w.Write(large)
+		w.WriteShared(shared)
+		want = append(want, small...)
+		want = append(want, large...)
+		want = append(want, shared...)
+	}
+	require.Equal(t, len(want), w.Buffered())
+
+	var got bytes.Buffer
+	n, err := w.Flush(&got)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(want)), n)
+	require.Equal(t, want, got.Bytes())
+	require.Zero(t, w.Buffered())
+	require.Empty(t, w.chunks)
+
+	// a network connection gets the pending bytes in a vectored write
+	server, client := tcpPair(t)
+	defer server.Close()
+	defer client.Close()
+	w.WriteShared(shared)
+	_, _ = w.Write(small)
+	n, err = w.Flush(client)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(shared)+len(small)), n)
+	buf := make([]byte, n)
+	_, err = io.ReadFull(server, buf)
+	require.NoError(t, err)
+	require.Equal(t, append(append([]byte(nil), shared...), small...), buf)
+}
+
+func tcpPair(tb testing.TB) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(tb, err)
+	defer l.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+	client, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(tb, err)
+	server := <-accepted
+	require.NotNil(tb, server)
+	return server, client
+}
+
+// BenchmarkReadPacket reads the publishes of a publisher from a loopback connection, with paho
+// reading each byte of the fixed header on its own and allocating each body, and with a Reader.
+func BenchmarkReadPacket(b *testing.B) {
+	var encoded bytes.Buffer
+	_ = publish("sensors/s1/temp", bytes.Repeat([]byte("21.5;"), 50), 1).Write(&encoded)
+	frame := encoded.Bytes()
+
+	for _, bc := range []struct {
+		name string
+		read func(r io.Reader) (packets.ControlPacket, error)
+		wrap func(c net.Conn) io.Reader
+	}{
+		{"paho", packets.ReadPacket, func(c net.Conn) io.Reader { return c }},
+		{"netbuf", ReadPacket, func(c net.Conn) io.Reader { return NewReader(c, 4096) }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			server, client := tcpPair(b)
+			defer server.Close()
+			go func() {
+				batch := bytes.Repeat(frame, 64)
+				for {
+					if _, err := client.Write(batch); err != nil {
+						return
+					}
+				}
+			}()
+			defer client.Close()
+
+			r := bc.wrap(server)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(frame)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := bc.read(r); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+// Package netbuf pools the buffers of the connections, so the packets read and written at high
+// message rates don't allocate per packet: a Reader borrows a buffer only while the bytes of a
+// packet are pending, and a Writer gathers the packets written to a connection into one vectored
+// write (writev).
+package netbuf
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// The size classes of the pooled buffers are the powers of 2 from 512 bytes to 64KB.
+const (
+	minClassBits = 9
+	maxClassBits = 16
+)
+
+var pools [maxClassBits - minClassBits + 1]sync.Pool
+
+// Get returns a buffer of n bytes from the pool of its size class, its content is undefined. The
+// buffers over the largest class are allocated, Put drops them.
+func Get(n int) *[]byte {
+	c, ok := class(n)
+	if !ok {
+		b := make([]byte, n)
+		return &b
+	}
+	if b, ok := pools[c].Get().(*[]byte); ok {
+		*b = (*b)[:n]
+		return b
+	}
+	b := make([]byte, n, 1<<(c+minClassBits))
+	return &b
+}
+
+// Put returns the buffer to the pool, it must not be used anymore.
+func Put(b *[]byte) {
+	c, ok := class(cap(*b))
+	if !ok || cap(*b) != 1<<(c+minClassBits) {
+		return
+	}
+	pools[c].Put(b)
+}
+
+// class returns the size class of the buffers of n bytes, false if n is over the largest one.
+func class(n int) (int, bool) {
+	if n > 1<<maxClassBits {
+		return 0, false
+	}
+	if n <= 1<<minClassBits {
+		return 0, true
+	}
+	return bits.Len(uint(n-1)) - minClassBits, true
+}
@@ -0,0 +1,129 @@
+package netbuf
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// ErrMalformed is the error of a packet whose remaining length is over 4 bytes.
+var ErrMalformed = errors.New("netbuf: malformed remaining length")
+
+// Reader buffers the reads of a connection with a pooled buffer it holds only while bytes are
+// pending. Once the buffer is drained it goes back to the pool, and the first read after it goes
+// straight to the source, into the slice of the caller: a connection blocked reading the first
+// byte of its next packet holds no buffer, and the rest of the packet is read at once instead of
+// one read per byte of its fixed header.
+type Reader struct {
+	src  io.Reader
+	size int
+
+	buf     *[]byte
+	r, w    int
+	drained bool
+}
+
+// NewReader returns the reader of src with buffers of size bytes.
+func NewReader(src io.Reader, size int) *Reader {
+	return &Reader{src: src, size: size, drained: true}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if r.buf == nil {
+		if r.drained || len(p) >= r.size {
+			r.drained = false
+			return r.src.Read(p)
+		}
+		r.buf = Get(r.size)
+		n, err := r.src.Read(*r.buf)
+		if n == 0 {
+			r.release()
+			return 0, err
+		}
+		r.r, r.w = 0, n
+	}
+
+	n := copy(p, (*r.buf)[r.r:r.w])
+	r.r += n
+	if r.r == r.w {
+		r.release()
+		r.drained = true
+	}
+	return n, nil
+}
+
+// Buffered returns the number of bytes read from the source and not from the reader yet.
+func (r *Reader) Buffered() int {
+	return r.w - r.r
+}
+
+// Release returns the buffer to the pool, the bytes it holds are dropped. The reader must not be
+// read anymore.
+func (r *Reader) Release() {
+	r.release()
+}
+
+func (r *Reader) release() {
+	if r.buf != nil {
+		Put(r.buf)
+		r.buf = nil
+	}
+	r.r, r.w = 0, 0
+}
+
+// ReadPacket reads a 3.1.1 packet like packets.ReadPacket does, its body in a pooled buffer: paho
+// copies the fields and the payload out of the body as it unpacks them, the buffer is returned to
+// the pool once the packet is unpacked.
+func ReadPacket(r io.Reader) (packets.ControlPacket, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return nil, err
+	}
+	fh := packets.FixedHeader{
+		MessageType: b[0] >> 4,
+		Dup:         (b[0]>>3)&0x01 > 0,
+		Qos:         (b[0] >> 1) & 0x03,
+		Retain:      b[0]&0x01 > 0,
+	}
+
+	var err error
+	if fh.RemainingLength, err = readLength(r); err != nil {
+		return nil, err
+	}
+	cp, err := packets.NewControlPacketWithHeader(fh)
+	if err != nil {
+		return nil, err
+	}
+
+	body := Get(fh.RemainingLength)
+	defer Put(body)
+	if _, err := io.ReadFull(r, *body); err != nil {
+		return nil, err
+	}
+	if err := cp.Unpack(bytes.NewReader(*body)); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// readLength reads the remaining length of the fixed header, a variable byte integer.
+func readLength(r io.Reader) (int, error) {
+	var b [1]byte
+	v, shift := 0, uint(0)
+	for i := 0; i < 4; i++ {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		v |= int(b[0]&0x7F) << shift
+		if b[0]&0x80 == 0 {
+			return v, nil
+		}
+		shift += 7
+	}
+	return 0, ErrMalformed
+}
@@ -0,0 +1,110 @@
+package netbuf
+
+import (
+	"io"
+	"net"
+	"syscall"
+)
+
+const (
+	// The size of the pooled chunks the written bytes are copied into
+	chunkSize = 4096
+
+	// The shared slices shorter than this are copied, a vectored write of many tiny slices is
+	// slower than the copy
+	minShared = 256
+)
+
+// Writer gathers the packets written to a connection until Flush writes them at once, in one
+// vectored write (writev) if the connection supports it. The bytes written are copied into pooled
+// chunks, the shared slices such as the payloads are written from where they are. The zero value
+// is ready to use, it's not safe for concurrent use.
+type Writer struct {
+	segs   [][]byte
+	chunks []*[]byte
+	// open is set if the last segment ends the last chunk, the next Write extends it
+	open bool
+	n    int
+}
+
+// Write copies p to the pending bytes, it never fails.
+func (w *Writer) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		if len(w.chunks) == 0 || len(*w.chunks[len(w.chunks)-1]) == chunkSize {
+			c := Get(chunkSize)
+			*c = (*c)[:0]
+			w.chunks = append(w.chunks, c)
+			w.open = false
+		}
+		c := w.chunks[len(w.chunks)-1]
+		start := len(*c)
+		k := copy((*c)[start:chunkSize], p)
+		*c = (*c)[:start+k]
+		if w.open {
+			last := len(w.segs) - 1
+			w.segs[last] = w.segs[last][:len(w.segs[last])+k]
+		} else {
+			w.segs = append(w.segs, (*c)[start:start+k])
+			w.open = true
+		}
+		p = p[k:]
+	}
+	w.n += written
+	return written, nil
+}
+
+// WriteShared adds p to the pending bytes without copying it, p must not change until the writer
+// is flushed. It's meant for the payloads, which are never changed once published.
+func (w *Writer) WriteShared(p []byte) {
+	if len(p) < minShared {
+		_, _ = w.Write(p)
+		return
+	}
+	w.segs = append(w.segs, p)
+	w.open = false
+	w.n += len(p)
+}
+
+// Buffered returns the number of bytes pending.
+func (w *Writer) Buffered() int {
+	return w.n
+}
+
+// Flush writes the pending bytes to dst: in one vectored write if dst is a network connection,
+// and copied to one buffer written at once otherwise, so a TLS connection sends one record. The
+// chunks go back to the pool, even if the write fails.
+func (w *Writer) Flush(dst io.Writer) (int64, error) {
+	if w.n == 0 {
+		return 0, nil
+	}
+	defer w.Reset()
+
+	if _, ok := dst.(syscall.Conn); ok || len(w.segs) == 1 {
+		bufs := net.Buffers(w.segs)
+		return bufs.WriteTo(dst)
+	}
+	b := Get(w.n)
+	defer Put(b)
+	off := 0
+	for _, s := range w.segs {
+		off += copy((*b)[off:], s)
+	}
+	n, err := dst.Write(*b)
+	return int64(n), err
+}
+
+// Reset drops the pending bytes and returns the chunks to the pool.
+func (w *Writer) Reset() {
+	for i := range w.segs {
+		w.segs[i] = nil
+	}
+	w.segs = w.segs[:0]
+	for i, c := range w.chunks {
+		Put(c)
+		w.chunks[i] = nil
+	}
+	w.chunks = w.chunks[:0]
+	w.open = false
+	w.n = 0
+}
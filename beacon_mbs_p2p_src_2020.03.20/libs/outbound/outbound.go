@@ -0,0 +1,221 @@
+// Package outbound queues the messages delivered to a subscriber, so a slow subscriber doesn't
+// stall the publishers fanning the messages out to the others. A writer takes the messages in
+// order, the QoS 1 and 2 ones within a window of messages waiting for their acknowledgement; the
+// policy of the queue decides what happens to a message delivered while the queue is full.
+package outbound
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Policy is what the queue does with a message pushed while it's full.
+type Policy string
+
+const (
+	// Block makes the publisher wait until there is room, up to the block timeout
+	Block Policy = "block"
+	// DropOldest drops the message at the head of the queue to make room
+	DropOldest Policy = "drop_oldest"
+	// DropNewest drops the message pushed
+	DropNewest Policy = "drop_newest"
+	// Disconnect refuses the message with ErrSlowConsumer, the subscriber is disconnected
+	Disconnect Policy = "disconnect"
+)
+
+const defaultMaxQueue = 1000
+
+var ErrSlowConsumer = errors.New("outbound: the queue of the subscriber is full")
+
+type Config struct {
+	// MaxQueue is the number of the messages waiting to be written, 1000 if it's 0.
+	MaxQueue int `json:"max_queue" yaml:"max_queue"`
+	// MaxInflight is the number of the QoS 1 and 2 messages written and not acknowledged yet, 0 is
+	// unlimited.
+	MaxInflight int `json:"max_inflight" yaml:"max_inflight"`
+	// Policy is DropNewest if it's empty.
+	Policy Policy `json:"policy" yaml:"policy"`
+	// BlockTimeout bounds the wait of the Block policy, the message is dropped once it has
+	// elapsed; 0 waits until there is room.
+	BlockTimeout time.Duration `json:"block_timeout" yaml:"block_timeout"`
+}
+
+// Validate checks the sizes are not negative and the policy is known.
+func (c Config) Validate() error {
+	if c.MaxQueue < 0 || c.MaxInflight < 0 || c.BlockTimeout < 0 {
+		return errors.New("outbound/outbound/Validate: the limits cannot be negative")
+	}
+	switch c.Policy {
+	case "", Block, DropOldest, DropNewest, Disconnect:
+		return nil
+	default:
+		return fmt.Errorf("outbound/outbound/Validate: unknown policy %q", c.Policy)
+	}
+}
+
+// Stats are the messages of a queue.
+type Stats struct {
+	Queued   int    `json:"queued"`
+	Inflight int    `json:"inflight"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+type item struct {
+	value    interface{}
+	windowed bool
+}
+
+// Queue is the outbound queue of a subscriber, it's safe for the concurrent publishers and the
+// writer.
+type Queue struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	cfg  Config
+
+	items    []item
+	inflight int
+	closed   bool
+	dropped  uint64
+}
+
+func New(cfg Config) *Queue {
+	if cfg.MaxQueue == 0 {
+		cfg.MaxQueue = defaultMaxQueue
+	}
+	if len(cfg.Policy) == 0 {
+		cfg.Policy = DropNewest
+	}
+	q := &Queue{cfg: cfg}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Push queues the value, windowed if it takes a slot of the inflight window once it's written. It
+// returns the values dropped to make room or the value itself if it's dropped, and
+// ErrSlowConsumer if the Disconnect policy refuses it. The values pushed to a closed queue are
+// dropped.
+func (q *Queue) Push(v interface{}, windowed bool) ([]interface{}, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return []interface{}{v}, nil
+	}
+
+	var dropped []interface{}
+	if len(q.items) >= q.cfg.MaxQueue {
+		switch q.cfg.Policy {
+		case Block:
+			if !q.waitRoom() {
+				q.dropped++
+				return []interface{}{v}, nil
+			}
+		case DropOldest:
+			dropped = append(dropped, q.items[0].value)
+			q.items[0] = item{}
+			q.items = q.items[1:]
+			q.dropped++
+		case Disconnect:
+			q.dropped++
+			return nil, ErrSlowConsumer
+		default:
+			q.dropped++
+			return []interface{}{v}, nil
+		}
+	}
+
+	q.items = append(q.items, item{value: v, windowed: windowed})
+	q.cond.Broadcast()
+	return dropped, nil
+}
+
+// waitRoom waits until the queue has room, it reports false if the queue is closed or the block
+// timeout has elapsed first.
+func (q *Queue) waitRoom() bool {
+	expired := false
+	if q.cfg.BlockTimeout > 0 {
+		timer := time.AfterFunc(q.cfg.BlockTimeout, func() {
+			q.mu.Lock()
+			expired = true
+			q.mu.Unlock()
+			q.cond.Broadcast()
+		})
+		defer timer.Stop()
+	}
+	for len(q.items) >= q.cfg.MaxQueue && !q.closed && !expired {
+		q.cond.Wait()
+	}
+	return !q.closed && len(q.items) < q.cfg.MaxQueue
+}
+
+// Pop returns the value at the head of the queue, waiting for it and for a slot of the inflight
+// window if it's windowed. It returns false once the queue is closed.
+func (q *Queue) Pop() (interface{}, bool, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for !q.closed && (len(q.items) == 0 || q.items[0].windowed && q.cfg.MaxInflight > 0 && q.inflight >= q.cfg.MaxInflight) {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil, false, false
+	}
+
+	it := q.items[0]
+	q.items[0] = item{}
+	q.items = q.items[1:]
+	if it.windowed {
+		q.inflight++
+	}
+	q.cond.Broadcast()
+	return it.value, it.windowed, true
+}
+
+// Ready reports whether Pop would return at once: a value is queued and, if it's windowed, the
+// inflight window has a slot for it.
+func (q *Queue) Ready() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.items) > 0 && !(q.items[0].windowed && q.cfg.MaxInflight > 0 && q.inflight >= q.cfg.MaxInflight)
+}
+
+// Release frees the slot of the inflight window of an acknowledged value, or of a value which
+// couldn't be written.
+func (q *Queue) Release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.inflight > 0 {
+		q.inflight--
+		q.cond.Broadcast()
+	}
+}
+
+// Close wakes the publishers and the writer waiting on the queue up, and returns the values left
+// in the queue.
+func (q *Queue) Close() []interface{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	left := make([]interface{}, 0, len(q.items))
+	for _, it := range q.items {
+		left = append(left, it.value)
+	}
+	q.items = nil
+	q.cond.Broadcast()
+	return left
+}
+
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return Stats{Queued: len(q.items), Inflight: q.inflight, Dropped: q.dropped}
+}
@@ -0,0 +1,86 @@
+package outbound
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueuePolicies(t *testing.T) {
+	q := New(Config{MaxQueue: 2, Policy: DropOldest})
+	for i := 1; i <= 3; i++ {
+		dropped, err := q.Push(i, false)
+		require.NoError(t, err)
+		if i == 3 {
+			require.Equal(t, []interface{}{1}, dropped)
+		}
+	}
+	v, _, ok := q.Pop()
+	require.True(t, ok)
+	require.Equal(t, 2, v)
+
+	q = New(Config{MaxQueue: 1})
+	_, err := q.Push(1, false)
+	require.NoError(t, err)
+	dropped, err := q.Push(2, false)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{2}, dropped)
+	require.Equal(t, Stats{Queued: 1, Dropped: 1}, q.Stats())
+
+	q = New(Config{MaxQueue: 1, Policy: Disconnect})
+	_, err = q.Push(1, false)
+	require.NoError(t, err)
+	_, err = q.Push(2, false)
+	require.Equal(t, ErrSlowConsumer, err)
+	require.Equal(t, []interface{}{1}, q.Close())
+
+	// the blocked publisher drops the message once the timeout has elapsed
+	q = New(Config{MaxQueue: 1, Policy: Block, BlockTimeout: 20 * time.Millisecond})
+	_, err = q.Push(1, false)
+	require.NoError(t, err)
+	dropped, err = q.Push(2, false)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{2}, dropped)
+
+	require.Error(t, Config{Policy: "drop_all"}.Validate())
+	require.Error(t, Config{MaxInflight: -1}.Validate())
+}
+
+func TestQueueBlockAndWindow(t *testing.T) {
+	q := New(Config{MaxQueue: 1, MaxInflight: 1, Policy: Block})
+	_, err := q.Push(1, true)
+	require.NoError(t, err)
+
+	pushed := make(chan struct{})
+	go func() {
+		_, _ = q.Push(2, true)
+		close(pushed)
+	}()
+
+	require.True(t, q.Ready())
+	v, windowed, ok := q.Pop()
+	require.True(t, ok)
+	require.True(t, windowed)
+	require.Equal(t, 1, v)
+	<-pushed
+	require.False(t, q.Ready())
+
+	// the second message waits for the first one to be acknowledged
+	popped := make(chan interface{})
+	go func() {
+		v, _, _ := q.Pop()
+		popped <- v
+	}()
+	select {
+	case <-popped:
+		t.Fatal("popped past the inflight window")
+	case <-time.After(20 * time.Millisecond):
+	}
+	q.Release()
+	require.Equal(t, 2, <-popped)
+
+	require.Empty(t, q.Close())
+	_, _, ok = q.Pop()
+	require.False(t, ok)
+}
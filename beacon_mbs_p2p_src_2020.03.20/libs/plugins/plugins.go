@@ -0,0 +1,287 @@
+// Package plugins intercepts the messages going through the broker. The plugins are registered by
+// name like the topics and auth providers, the broker runs the ones picked with WithPlugins in
+// the order they are given.
+package plugins
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	plugins = make(map[string]Plugin)
+)
+
+// ErrVetoed is returned by the Chain when a plugin refuses a connect, a subscription or a publish
+// without telling why.
+var ErrVetoed = errors.New("plugins: vetoed")
+
+// Client is the connected client a hook is invoked for.
+type Client struct {
+	ClientID        string
+	Username        string
+	RemoteAddr      string
+	Listener        string
+	ProtocolVersion byte
+}
+
+// Message is a publish of a client, the OnPublish hooks may rewrite its Topic and Payload.
+type Message struct {
+	Topic   string
+	Payload []byte
+	Qos     byte
+	Retain  bool
+}
+
+// Plugin is registered by name, it implements any of the hook interfaces below, the hooks it
+// doesn't implement are skipped.
+type Plugin interface {
+	Close() error
+}
+
+// ConnectHook is invoked on each CONNECT once the credentials are accepted, an error refuses the
+// connect as not authorized.
+type ConnectHook interface {
+	OnConnect(c Client) error
+}
+
+// SubscribeHook is invoked on each filter of a SUBSCRIBE once the ACL allows it, an error refuses
+// the filter.
+type SubscribeHook interface {
+	OnSubscribe(c Client, filter string, qos byte) error
+}
+
+// PublishHook is invoked on each PUBLISH of a client once the ACL allows it, it may rewrite the
+// topic and the payload of m, an error drops the publish.
+type PublishHook interface {
+	OnPublish(c Client, m *Message) error
+}
+
+// DeliverHook is invoked on each message written to a subscriber, m must not be modified.
+type DeliverHook interface {
+	OnDeliver(c Client, m *Message)
+}
+
+// ForwardHook is invoked on each message forwarded to the peer nodes, it may rewrite the payload
+// of m, such as to encrypt it; an error keeps the message from the peers. The local subscribers
+// get the message as published.
+type ForwardHook interface {
+	OnForward(m *Message) error
+}
+
+// ReceiveHook is invoked on each message forwarded by a peer node before it's delivered to the
+// local subscribers, it may rewrite the payload of m, such as to decrypt it; an error drops the
+// message.
+type ReceiveHook interface {
+	OnReceive(m *Message) error
+}
+
+// DisconnectHook is invoked once a connected client is closed.
+type DisconnectHook interface {
+	OnDisconnect(c Client)
+}
+
+// Register makes a plugin available by the provided name.
+// If a Register is called twice with the same name or if the plugin is nil,
+// it panics.
+func Register(name string, plugin Plugin) {
+	if plugin == nil {
+		panic("plugins: Register plugin is nil")
+	}
+
+	if _, dup := plugins[name]; dup {
+		panic("plugins: Register called twice for plugin " + name)
+	}
+
+	plugins[name] = plugin
+}
+
+func Unregister(name string) {
+	delete(plugins, name)
+}
+
+// Chain runs the hooks of the plugins in order, a nil Chain runs none.
+type Chain struct {
+	names      []string
+	connect    []ConnectHook
+	subscribe  []SubscribeHook
+	publish    []PublishHook
+	deliver    []DeliverHook
+	forward    []ForwardHook
+	receive    []ReceiveHook
+	disconnect []DisconnectHook
+	all        []Plugin
+}
+
+// NewChain looks up the plugins by name, in the order their hooks run.
+func NewChain(names ...string) (*Chain, error) {
+	ch := &Chain{}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		p, ok := plugins[name]
+		if !ok {
+			return nil, fmt.Errorf("plugins: unknown plugin %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("plugins: plugin %q is given twice", name)
+		}
+		seen[name] = true
+
+		ch.names = append(ch.names, name)
+		ch.all = append(ch.all, p)
+		if h, ok := p.(ConnectHook); ok {
+			ch.connect = append(ch.connect, h)
+		}
+		if h, ok := p.(SubscribeHook); ok {
+			ch.subscribe = append(ch.subscribe, h)
+		}
+		if h, ok := p.(PublishHook); ok {
+			ch.publish = append(ch.publish, h)
+		}
+		if h, ok := p.(DeliverHook); ok {
+			ch.deliver = append(ch.deliver, h)
+		}
+		if h, ok := p.(ForwardHook); ok {
+			ch.forward = append(ch.forward, h)
+		}
+		if h, ok := p.(ReceiveHook); ok {
+			ch.receive = append(ch.receive, h)
+		}
+		if h, ok := p.(DisconnectHook); ok {
+			ch.disconnect = append(ch.disconnect, h)
+		}
+	}
+	return ch, nil
+}
+
+// Names returns the names of the plugins in the chain.
+func (ch *Chain) Names() []string {
+	if ch == nil {
+		return nil
+	}
+	return append([]string(nil), ch.names...)
+}
+
+// Connect runs the OnConnect hooks, it stops at the first error.
+func (ch *Chain) Connect(c Client) error {
+	if ch == nil {
+		return nil
+	}
+	for _, h := range ch.connect {
+		if err := h.OnConnect(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Subscribe runs the OnSubscribe hooks, it stops at the first error.
+func (ch *Chain) Subscribe(c Client, filter string, qos byte) error {
+	if ch == nil {
+		return nil
+	}
+	for _, h := range ch.subscribe {
+		if err := h.OnSubscribe(c, filter, qos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HasPublish tells whether any plugin intercepts the publishes, so the broker skips building the
+// Message otherwise.
+func (ch *Chain) HasPublish() bool {
+	return ch != nil && len(ch.publish) > 0
+}
+
+// Publish runs the OnPublish hooks, each one sees the message as rewritten by the previous ones,
+// it stops at the first error.
+func (ch *Chain) Publish(c Client, m *Message) error {
+	if ch == nil {
+		return nil
+	}
+	for _, h := range ch.publish {
+		if err := h.OnPublish(c, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HasDeliver tells whether any plugin observes the deliveries.
+func (ch *Chain) HasDeliver() bool {
+	return ch != nil && len(ch.deliver) > 0
+}
+
+// Deliver runs the OnDeliver hooks.
+func (ch *Chain) Deliver(c Client, m *Message) {
+	if ch == nil {
+		return
+	}
+	for _, h := range ch.deliver {
+		h.OnDeliver(c, m)
+	}
+}
+
+// HasForward tells whether any plugin intercepts the messages forwarded to the peer nodes.
+func (ch *Chain) HasForward() bool {
+	return ch != nil && len(ch.forward) > 0
+}
+
+// Forward runs the OnForward hooks, each one sees the payload as rewritten by the previous ones,
+// it stops at the first error.
+func (ch *Chain) Forward(m *Message) error {
+	if ch == nil {
+		return nil
+	}
+	for _, h := range ch.forward {
+		if err := h.OnForward(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HasReceive tells whether any plugin intercepts the messages forwarded by the peer nodes.
+func (ch *Chain) HasReceive() bool {
+	return ch != nil && len(ch.receive) > 0
+}
+
+// Receive runs the OnReceive hooks in the reverse order of the OnForward ones, so the payload
+// rewritten by a chain on the way out is restored by the same chain on the way in. It stops at
+// the first error.
+func (ch *Chain) Receive(m *Message) error {
+	if ch == nil {
+		return nil
+	}
+	for i := len(ch.receive) - 1; i >= 0; i-- {
+		if err := ch.receive[i].OnReceive(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Disconnect runs the OnDisconnect hooks.
+func (ch *Chain) Disconnect(c Client) {
+	if ch == nil {
+		return
+	}
+	for _, h := range ch.disconnect {
+		h.OnDisconnect(c)
+	}
+}
+
+// Close closes the plugins of the chain, it returns the first error.
+func (ch *Chain) Close() error {
+	if ch == nil {
+		return nil
+	}
+	var first error
+	for _, p := range ch.all {
+		if err := p.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
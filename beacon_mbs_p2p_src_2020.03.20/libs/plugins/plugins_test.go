@@ -0,0 +1,86 @@
+package plugins
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type prefixPlugin struct {
+	delivered int
+	closed    bool
+}
+
+func (p *prefixPlugin) OnPublish(c Client, m *Message) error {
+	if len(m.Payload) == 0 {
+		return ErrVetoed
+	}
+	m.Topic = c.ClientID + "/" + m.Topic
+	return nil
+}
+
+func (p *prefixPlugin) OnDeliver(c Client, m *Message) { p.delivered++ }
+
+func (p *prefixPlugin) Close() error {
+	p.closed = true
+	return nil
+}
+
+type upperPlugin struct{}
+
+func (upperPlugin) OnPublish(c Client, m *Message) error {
+	m.Payload = []byte(strings.ToUpper(string(m.Payload)))
+	return nil
+}
+
+func (upperPlugin) OnSubscribe(c Client, filter string, qos byte) error {
+	if filter == "#" {
+		return errors.New("wildcard subscriptions are not allowed")
+	}
+	return nil
+}
+
+func (upperPlugin) Close() error { return nil }
+
+func TestChain(t *testing.T) {
+	prefix := &prefixPlugin{}
+	Register("prefix", prefix)
+	Register("upper", upperPlugin{})
+	defer Unregister("prefix")
+	defer Unregister("upper")
+
+	require.Panics(t, func() { Register("prefix", prefix) })
+	_, err := NewChain("prefix", "missing")
+	require.Error(t, err)
+	_, err = NewChain("prefix", "prefix")
+	require.Error(t, err)
+
+	ch, err := NewChain("prefix", "upper")
+	require.NoError(t, err)
+	require.Equal(t, []string{"prefix", "upper"}, ch.Names())
+	require.True(t, ch.HasPublish())
+	require.True(t, ch.HasDeliver())
+
+	c := Client{ClientID: "c1"}
+	m := &Message{Topic: "a/b", Payload: []byte("hi")}
+	require.NoError(t, ch.Publish(c, m))
+	require.Equal(t, "c1/a/b", m.Topic)
+	require.Equal(t, "HI", string(m.Payload))
+	require.Equal(t, ErrVetoed, ch.Publish(c, &Message{Topic: "a"}))
+
+	require.NoError(t, ch.Subscribe(c, "a/+", 1))
+	require.Error(t, ch.Subscribe(c, "#", 1))
+	require.NoError(t, ch.Connect(c))
+
+	ch.Deliver(c, m)
+	require.Equal(t, 1, prefix.delivered)
+	require.NoError(t, ch.Close())
+	require.True(t, prefix.closed)
+
+	var none *Chain
+	require.False(t, none.HasPublish())
+	require.NoError(t, none.Publish(c, m))
+	none.Disconnect(c)
+}
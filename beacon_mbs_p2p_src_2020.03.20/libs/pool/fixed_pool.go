@@ -1,11 +1,21 @@
 package pool
 
+import (
+	"sync/atomic"
+)
+
 const staticChannelSize = 32 //The static channel size for single task queue
 
+// DefaultPriorityBurst is the number of the priority tasks a worker runs in a row before it runs
+// a waiting task of the normal queue.
+const DefaultPriorityBurst = 16
+
 type FixedWorkPool struct {
-	maxWorkers uint16
-	metrics    *FixedWorkPoolMetrics
-	taskQueue  []chan func()
+	maxWorkers    uint16
+	metrics       *FixedWorkPoolMetrics
+	taskQueue     []chan func()
+	priorityQueue []chan func()
+	priorityBurst int32
 }
 
 func NewFixedWorkPool(maxWorkers uint16) *FixedWorkPool {
@@ -16,9 +26,11 @@ func NewFixedWorkPool(maxWorkers uint16) *FixedWorkPool {
 
 	// taskQueue is unbuffered since items are always removed immediately.
 	pool := &FixedWorkPool{
-		metrics:    new(FixedWorkPoolMetrics),
-		maxWorkers: maxWorkers,
-		taskQueue:  make([]chan func(), maxWorkers),
+		metrics:       new(FixedWorkPoolMetrics),
+		maxWorkers:    maxWorkers,
+		taskQueue:     make([]chan func(), maxWorkers),
+		priorityQueue: make([]chan func(), maxWorkers),
+		priorityBurst: DefaultPriorityBurst,
 	}
 	// Start the task dispatcher.
 	pool.dispatch()
@@ -29,7 +41,8 @@ func NewFixedWorkPool(maxWorkers uint16) *FixedWorkPool {
 func (f *FixedWorkPool) dispatch() {
 	for i := uint16(0); i < f.maxWorkers; i++ {
 		f.taskQueue[i] = make(chan func(), staticChannelSize)
-		f.executeTask(f.taskQueue[i])
+		f.priorityQueue[i] = make(chan func(), staticChannelSize)
+		f.executeTask(f.taskQueue[i], f.priorityQueue[i])
 	}
 }
 
@@ -46,12 +59,67 @@ func (f *FixedWorkPool) SubmitTask(task func()) {
 	}
 }
 
-func (f *FixedWorkPool) executeTask(taskChannel chan func()) {
+// SubmitKeyedTask queues the task to the worker of the key, the tasks of a key run one at a time in
+// the order they're submitted. A busy key holds up the other tasks of its worker.
+func (f *FixedWorkPool) SubmitKeyedTask(key uint64, task func()) {
+	if task != nil {
+		f.metrics.increasingTaskSubmitted()
+		f.taskQueue[key%uint64(f.maxWorkers)] <- task
+	} else {
+		f.metrics.increasingNilTaskSubmitted()
+	}
+}
+
+// SubmitPriorityTask queues the task ahead of the ones submitted by SubmitTask, such as the
+// control packets which must not wait behind the large publishes.
+func (f *FixedWorkPool) SubmitPriorityTask(task func()) {
+	if task != nil {
+		f.metrics.increasingPriorityTaskSubmitted()
+		f.priorityQueue[f.getTaskChannelId()] <- task
+	} else {
+		f.metrics.increasingNilTaskSubmitted()
+	}
+}
+
+// SetPriorityBurst sets the number of the priority tasks a worker runs in a row before it runs a
+// waiting normal task, so a flood of priority tasks doesn't starve the normal ones. Zero or less
+// runs the queues in turn.
+func (f *FixedWorkPool) SetPriorityBurst(n int) {
+	if n < 1 {
+		n = 1
+	}
+	atomic.StoreInt32(&f.priorityBurst, int32(n))
+}
+
+func (f *FixedWorkPool) executeTask(taskChannel chan func(), priorityChannel chan func()) {
 	go func() {
 		var task func()
 		var ok bool
+		burst := int32(0)
 		for {
-			task, ok = <-taskChannel
+			task, ok = nil, false
+			// The normal task goes first once the priority ones have run their burst.
+			if burst >= atomic.LoadInt32(&f.priorityBurst) {
+				select {
+				case task, ok = <-taskChannel:
+					burst = 0
+				default:
+				}
+			}
+			if task == nil {
+				select {
+				case task, ok = <-priorityChannel:
+					burst++
+				default:
+					select {
+					case task, ok = <-priorityChannel:
+						burst++
+					case task, ok = <-taskChannel:
+						burst = 0
+					}
+				}
+			}
+
 			if ok && task != nil {
 				// Execute the task.
 				task()
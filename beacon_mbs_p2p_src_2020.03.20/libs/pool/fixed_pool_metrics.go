@@ -13,6 +13,7 @@ type FixedWorkPoolMetrics struct {
 	numOfFailedTaskExecuted         uint64
 	numOfSucceedTaskExecuted        uint64
 	numOfPublishPacketTaskSubmitted uint64
+	numOfPriorityTaskSubmitted      uint64
 }
 
 func (f *FixedWorkPoolMetrics) IncreasingPublishPacketTaskSubmitted() {
@@ -23,6 +24,10 @@ func (f *FixedWorkPoolMetrics) increasingTaskSubmitted() {
 	atomic.AddUint64(&f.numOfTaskSubmitted, 1)
 }
 
+func (f *FixedWorkPoolMetrics) increasingPriorityTaskSubmitted() {
+	atomic.AddUint64(&f.numOfPriorityTaskSubmitted, 1)
+}
+
 func (f *FixedWorkPoolMetrics) increasingNilTaskSubmitted() {
 	atomic.AddUint64(&f.numOTNilTaskSubmitted, 1)
 }
@@ -36,11 +41,12 @@ func (f *FixedWorkPoolMetrics) increasingSucceedTaskExecuted() {
 }
 
 func (f *FixedWorkPoolMetrics) MetricsInfo() string {
-	return fmt.Sprintf(`{"task submitted (packet with client)":"%s","task submitted nil":%d,"task executed failed":%d,"task executed succeed":"%s","task submitted (publish-packet from broker) ":"%s"}`,
+	return fmt.Sprintf(`{"task submitted (packet with client)":"%s","task submitted nil":%d,"task executed failed":%d,"task executed succeed":"%s","task submitted (publish-packet from broker) ":"%s","task submitted (priority)":"%s"}`,
 		humanize.Comma(int64(f.numOfTaskSubmitted)),
 		f.numOTNilTaskSubmitted,
 		f.numOfFailedTaskExecuted,
 		humanize.Comma(int64(f.numOfSucceedTaskExecuted)),
 		humanize.Comma(int64(f.numOfPublishPacketTaskSubmitted)),
+		humanize.Comma(int64(f.numOfPriorityTaskSubmitted)),
 	)
 }
@@ -30,3 +30,70 @@ func TestFixedWorkerPools(t *testing.T) {
 	assert.EqualValues(t, sum, int32(fwp.metrics.numOfTaskSubmitted+fwp.metrics.numOTNilTaskSubmitted))
 	assert.EqualValues(t, sum, int32(fwp.metrics.numOfSucceedTaskExecuted+fwp.metrics.numOfFailedTaskExecuted))
 }
+
+func TestFixedWorkPoolPriority(t *testing.T) {
+	fwp := NewFixedWorkPool(1)
+	fwp.SetPriorityBurst(2)
+
+	var mu sync.Mutex
+	var order []string
+	var done sync.WaitGroup
+	task := func(name string) func() {
+		done.Add(1)
+		return func() {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			done.Done()
+		}
+	}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done.Add(1)
+	fwp.SubmitTask(func() {
+		close(started)
+		<-release
+		done.Done()
+	})
+	<-started
+
+	fwp.SubmitTask(task("n1"))
+	fwp.SubmitTask(task("n2"))
+	fwp.SubmitPriorityTask(task("p1"))
+	fwp.SubmitPriorityTask(task("p2"))
+	fwp.SubmitPriorityTask(task("p3"))
+	close(release)
+	done.Wait()
+
+	// the priority tasks run first, though a normal task runs after each burst of two
+	assert.Equal(t, []string{"p1", "p2", "n1", "p3", "n2"}, order)
+	assert.EqualValues(t, 3, fwp.metrics.numOfPriorityTaskSubmitted)
+}
+
+func TestFixedWorkPoolKeyed(t *testing.T) {
+	fwp := NewFixedWorkPool(4)
+
+	var mu sync.Mutex
+	order := make(map[uint64][]int)
+	var done sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		key, n := uint64(i%3), i
+		done.Add(1)
+		fwp.SubmitKeyedTask(key, func() {
+			mu.Lock()
+			order[key] = append(order[key], n)
+			mu.Unlock()
+			done.Done()
+		})
+	}
+	done.Wait()
+
+	// the tasks of each key ran in the order they were submitted
+	for key, list := range order {
+		for i := 1; i < len(list); i++ {
+			assert.Less(t, list[i-1], list[i], "key %d", key)
+		}
+	}
+	assert.Len(t, order, 3)
+}
@@ -0,0 +1,145 @@
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Tenant is the share of the broker given to the clients whose id starts with the client prefix:
+// its own workers for the fan-out of their packets, and a budget of the bytes of their packets
+// waiting for the workers. A burst of the tenant fills its queues and its budget only, the packets
+// beyond the budget are dropped while the other tenants keep going.
+type Tenant struct {
+	Name         string `json:"name"`
+	ClientPrefix string `json:"client_prefix"`
+	Workers      uint16 `json:"workers"`
+	MemoryBudget int64  `json:"memory_budget"`
+}
+
+type TenantStats struct {
+	Name         string `json:"name"`
+	Workers      uint16 `json:"workers"`
+	MemoryBudget int64  `json:"memory_budget"`
+	MemoryUsed   int64  `json:"memory_used"`
+	Rejected     uint64 `json:"rejected"`
+}
+
+type TenantPool struct {
+	tenant   Tenant
+	pool     *FixedWorkPool
+	used     int64
+	rejected uint64
+}
+
+func newTenantPool(t Tenant) *TenantPool {
+	return &TenantPool{
+		tenant: t,
+		pool:   NewFixedWorkPool(t.Workers),
+	}
+}
+
+func (p *TenantPool) Name() string {
+	return p.tenant.Name
+}
+
+// Submit queues the task of the given size, it returns false without queuing it if the size does
+// not fit in the budget left.
+func (p *TenantPool) Submit(size int64, task func()) bool {
+	return p.submit(size, task, p.pool.SubmitTask)
+}
+
+// SubmitKeyed queues the task like Submit, to the worker of the key like
+// FixedWorkPool.SubmitKeyedTask.
+func (p *TenantPool) SubmitKeyed(size int64, key uint64, task func()) bool {
+	return p.submit(size, task, func(t func()) {
+		p.pool.SubmitKeyedTask(key, t)
+	})
+}
+
+func (p *TenantPool) submit(size int64, task func(), queue func(func())) bool {
+	if used := atomic.AddInt64(&p.used, size); p.tenant.MemoryBudget > 0 && used > p.tenant.MemoryBudget {
+		atomic.AddInt64(&p.used, -size)
+		atomic.AddUint64(&p.rejected, 1)
+		return false
+	}
+
+	queue(func() {
+		defer atomic.AddInt64(&p.used, -size)
+		task()
+	})
+	return true
+}
+
+func (p *TenantPool) Metrics() *FixedWorkPoolMetrics {
+	return p.pool.Metrics()
+}
+
+func (p *TenantPool) Stats() TenantStats {
+	return TenantStats{
+		Name:         p.tenant.Name,
+		Workers:      p.pool.maxWorkers,
+		MemoryBudget: p.tenant.MemoryBudget,
+		MemoryUsed:   atomic.LoadInt64(&p.used),
+		Rejected:     atomic.LoadUint64(&p.rejected),
+	}
+}
+
+// TenantPools finds the pool of a client, the longest client prefix wins. The tenants are fixed
+// when the broker starts, the workers of a pool are never stopped.
+type TenantPools struct {
+	pools []*TenantPool
+}
+
+func NewTenantPools(tenants []Tenant) (*TenantPools, error) {
+	p := &TenantPools{}
+	names := make(map[string]bool, len(tenants))
+	for _, t := range tenants {
+		if len(t.Name) == 0 {
+			return nil, errors.New("pool/tenant_pool/NewTenantPools: tenant name cannot be empty")
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("pool/tenant_pool/NewTenantPools: duplicate tenant %s", t.Name)
+		}
+		if t.MemoryBudget < 0 {
+			return nil, fmt.Errorf("pool/tenant_pool/NewTenantPools: negative memory budget for tenant %s", t.Name)
+		}
+		names[t.Name] = true
+		p.pools = append(p.pools, newTenantPool(t))
+	}
+
+	sort.SliceStable(p.pools, func(i, j int) bool {
+		return len(p.pools[i].tenant.ClientPrefix) > len(p.pools[j].tenant.ClientPrefix)
+	})
+	return p, nil
+}
+
+// Lookup returns the pool of the tenant of the client, nil if the client belongs to none.
+func (p *TenantPools) Lookup(clientID string) *TenantPool {
+	for _, tp := range p.pools {
+		if strings.HasPrefix(clientID, tp.tenant.ClientPrefix) {
+			return tp
+		}
+	}
+	return nil
+}
+
+// Pools returns the pools of the tenants sorted by name.
+func (p *TenantPools) Pools() []*TenantPool {
+	list := make([]*TenantPool, len(p.pools))
+	copy(list, p.pools)
+	sort.Slice(list, func(i, j int) bool { return list[i].tenant.Name < list[j].tenant.Name })
+	return list
+}
+
+// Stats returns the stats of the tenants sorted by name.
+func (p *TenantPools) Stats() []TenantStats {
+	pools := p.Pools()
+	list := make([]TenantStats, 0, len(pools))
+	for _, tp := range pools {
+		list = append(list, tp.Stats())
+	}
+	return list
+}
@@ -0,0 +1,49 @@
+package pool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantPoolsLookup(t *testing.T) {
+	_, err := NewTenantPools([]Tenant{{Name: "a"}, {Name: "a"}})
+	require.Error(t, err)
+	_, err = NewTenantPools([]Tenant{{ClientPrefix: "a-"}})
+	require.Error(t, err)
+
+	p, err := NewTenantPools([]Tenant{
+		{Name: "acme", ClientPrefix: "acme-", Workers: 1},
+		{Name: "acme-lab", ClientPrefix: "acme-lab-", Workers: 1},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, "acme", p.Lookup("acme-1").Name())
+	require.Equal(t, "acme-lab", p.Lookup("acme-lab-1").Name())
+	require.Nil(t, p.Lookup("other"))
+}
+
+func TestTenantPoolBudget(t *testing.T) {
+	p, err := NewTenantPools([]Tenant{{Name: "acme", ClientPrefix: "acme-", Workers: 1, MemoryBudget: 100}})
+	require.NoError(t, err)
+	tp := p.Lookup("acme-1")
+
+	block := make(chan struct{})
+	done := make(chan struct{}, 2)
+	task := func() {
+		<-block
+		done <- struct{}{}
+	}
+
+	require.True(t, tp.Submit(60, task))
+	require.True(t, tp.Submit(40, task))
+	require.False(t, tp.Submit(1, task))
+	require.Equal(t, TenantStats{Name: "acme", Workers: 1, MemoryBudget: 100, MemoryUsed: 100, Rejected: 1}, tp.Stats())
+
+	close(block)
+	<-done
+	<-done
+	require.Eventually(t, func() bool { return tp.Stats().MemoryUsed == 0 }, time.Second, time.Millisecond)
+	require.True(t, tp.Submit(100, func() {}))
+}
@@ -0,0 +1,144 @@
+// Package qosreport counts what happens to the messages delivered to the subscriptions of each
+// filter: the delivery attempts, the retransmissions of the publishers (DUP), the QoS 1 and 2
+// deliveries acknowledged by the subscribers and the ones which were lost. The counts are reported
+// per period, so the reliability of each class of topics can be followed over time.
+package qosreport
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+)
+
+const defaultMaxFilters = 1024
+
+// OtherFilters counts the filters beyond the limit of the recorder.
+const OtherFilters = "*"
+
+type FilterStats struct {
+	Filter string `json:"filter"`
+	// Attempts is the number of messages handed to the subscriptions of the filter.
+	Attempts uint64 `json:"attempts"`
+	// Retransmits is the number of attempts of messages sent again by their publisher (DUP).
+	Retransmits uint64 `json:"retransmits"`
+	// Acknowledged is the number of QoS 1 and 2 deliveries acknowledged by the subscriber.
+	Acknowledged uint64 `json:"acknowledged"`
+	// Drops is the number of deliveries which could not be written, or were left unacknowledged
+	// when the connection of the subscriber closed.
+	Drops uint64 `json:"drops"`
+	// DeliveryRate is the part of the attempts which were not dropped, 1 if there was none.
+	DeliveryRate float64 `json:"delivery_rate"`
+}
+
+type Report struct {
+	Start   time.Time     `json:"start"`
+	End     time.Time     `json:"end"`
+	Filters []FilterStats `json:"filters"`
+}
+
+type Recorder struct {
+	mu         sync.Mutex
+	clock      clock.Clock
+	maxFilters int
+	start      time.Time
+	filters    map[string]*FilterStats
+}
+
+// New returns a recorder counting up to maxFilters filters per period, 1024 if it's 0, the other
+// ones are counted together as OtherFilters. The wall clock is used if c is nil.
+func New(maxFilters int, c clock.Clock) *Recorder {
+	if maxFilters <= 0 {
+		maxFilters = defaultMaxFilters
+	}
+	c = clock.OrReal(c)
+	return &Recorder{
+		clock:      c,
+		maxFilters: maxFilters,
+		start:      c.Now(),
+		filters:    make(map[string]*FilterStats),
+	}
+}
+
+// stats is called with the lock held.
+func (r *Recorder) stats(filter string) *FilterStats {
+	s, ok := r.filters[filter]
+	if ok {
+		return s
+	}
+	if len(r.filters) >= r.maxFilters {
+		filter = OtherFilters
+		if s, ok = r.filters[filter]; ok {
+			return s
+		}
+	}
+	s = &FilterStats{Filter: filter}
+	r.filters[filter] = s
+	return s
+}
+
+// Attempt counts a message handed to a subscription of the filter, dup is the DUP flag the
+// publisher sent it with.
+func (r *Recorder) Attempt(filter string, dup bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.stats(filter)
+	s.Attempts++
+	if dup {
+		s.Retransmits++
+	}
+}
+
+func (r *Recorder) Acknowledged(filter string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats(filter).Acknowledged++
+}
+
+func (r *Recorder) Dropped(filter string, n uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats(filter).Drops += n
+}
+
+// Report returns the counts of the current period, by filter.
+func (r *Recorder) Report() Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.report()
+}
+
+// Take returns the counts of the current period and starts a new one.
+func (r *Recorder) Take() Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := r.report()
+	r.start = report.End
+	r.filters = make(map[string]*FilterStats)
+	return report
+}
+
+func (r *Recorder) report() Report {
+	report := Report{
+		Start:   r.start,
+		End:     r.clock.Now(),
+		Filters: make([]FilterStats, 0, len(r.filters)),
+	}
+	for _, s := range r.filters {
+		fs := *s
+		fs.DeliveryRate = 1
+		if fs.Attempts > 0 {
+			delivered := float64(fs.Attempts) - float64(fs.Drops)
+			if delivered < 0 {
+				delivered = 0
+			}
+			fs.DeliveryRate = delivered / float64(fs.Attempts)
+		}
+		report.Filters = append(report.Filters, fs)
+	}
+	sort.Slice(report.Filters, func(i, j int) bool { return report.Filters[i].Filter < report.Filters[j].Filter })
+	return report
+}
@@ -0,0 +1,42 @@
+package qosreport
+
+import (
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	mock := clock.NewMock(time.Unix(1584662400, 0))
+	r := New(2, mock)
+
+	for i := 0; i < 4; i++ {
+		r.Attempt("sensors/+/temp", i == 3)
+	}
+	r.Acknowledged("sensors/+/temp")
+	r.Acknowledged("sensors/+/temp")
+	r.Dropped("sensors/+/temp", 1)
+	r.Attempt("alarms/#", false)
+	// beyond the limit
+	r.Attempt("logs/#", false)
+	r.Dropped("audit/#", 1)
+
+	mock.Add(time.Minute)
+	report := r.Take()
+	require.Equal(t, time.Unix(1584662400, 0), report.Start)
+	require.Equal(t, time.Minute, report.End.Sub(report.Start))
+	require.Equal(t, []FilterStats{
+		{Filter: OtherFilters, Attempts: 1, Drops: 1, DeliveryRate: 0},
+		{Filter: "alarms/#", Attempts: 1, DeliveryRate: 1},
+		{Filter: "sensors/+/temp", Attempts: 4, Retransmits: 1, Acknowledged: 2, Drops: 1, DeliveryRate: 0.75},
+	}, report.Filters)
+
+	// a new period starts
+	mock.Add(time.Minute)
+	report = r.Report()
+	require.Empty(t, report.Filters)
+	require.Equal(t, time.Unix(1584662400, 0).Add(time.Minute), report.Start)
+}
@@ -0,0 +1,95 @@
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics/matcher"
+)
+
+// ErrPayloadTooLarge is returned for a payload over the limit of its topic.
+var ErrPayloadTooLarge = errors.New("quota/payload: the payload is over the limit of its topic")
+
+// PayloadLimit bounds the size of the payloads published to the topics matched by the filter,
+// whoever the client is.
+type PayloadLimit struct {
+	Filter     string `json:"filter" yaml:"filter"`
+	MaxPayload int    `json:"max_payload" yaml:"max_payload"`
+}
+
+type payloadLimit struct {
+	PayloadLimit
+	literals  int
+	wildcards int
+}
+
+// PayloadLimits holds the payload limits by filter. The limit of a topic is the one of the most
+// specific filter matching it: the filter with the most levels without wildcard, then the fewest
+// wildcards, then the first configured. A nil PayloadLimits limits nothing.
+type PayloadLimits struct {
+	limits []payloadLimit
+}
+
+// NewPayloadLimits returns the limits by filter, nil if there are none.
+func NewPayloadLimits(limits []PayloadLimit) (*PayloadLimits, error) {
+	if len(limits) == 0 {
+		return nil, nil
+	}
+
+	p := &PayloadLimits{}
+	seen := make(map[string]bool, len(limits))
+	for _, l := range limits {
+		if err := matcher.ValidFilter([]byte(l.Filter)); err != nil {
+			return nil, fmt.Errorf("quota/payload/NewPayloadLimits: invalid filter %q => %v", l.Filter, err)
+		}
+		if l.MaxPayload <= 0 {
+			return nil, fmt.Errorf("quota/payload/NewPayloadLimits: the limit of %q must be positive", l.Filter)
+		}
+		if seen[l.Filter] {
+			return nil, fmt.Errorf("quota/payload/NewPayloadLimits: duplicated filter %q", l.Filter)
+		}
+		seen[l.Filter] = true
+
+		pl := payloadLimit{PayloadLimit: l}
+		for _, level := range strings.Split(l.Filter, matcher.SEP) {
+			if level == matcher.SWC || level == matcher.MWC {
+				pl.wildcards++
+			} else {
+				pl.literals++
+			}
+		}
+		p.limits = append(p.limits, pl)
+	}
+
+	sort.SliceStable(p.limits, func(i, j int) bool {
+		a, b := p.limits[i], p.limits[j]
+		if a.literals != b.literals {
+			return a.literals > b.literals
+		}
+		return a.wildcards < b.wildcards
+	})
+	return p, nil
+}
+
+// Limit returns the limit of the topic and its filter, false if no filter matches the topic.
+func (p *PayloadLimits) Limit(topic string) (PayloadLimit, bool) {
+	if p == nil {
+		return PayloadLimit{}, false
+	}
+	for _, l := range p.limits {
+		if ok, _ := matcher.Match([]byte(l.Filter), []byte(topic)); ok {
+			return l.PayloadLimit, true
+		}
+	}
+	return PayloadLimit{}, false
+}
+
+// Check returns ErrPayloadTooLarge if the payload is over the limit of the topic.
+func (p *PayloadLimits) Check(topic string, size int) error {
+	if l, ok := p.Limit(topic); ok && size > l.MaxPayload {
+		return ErrPayloadTooLarge
+	}
+	return nil
+}
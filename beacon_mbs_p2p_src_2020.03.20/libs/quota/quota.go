@@ -0,0 +1,226 @@
+// Package quota limits what a client may do on its connection: the rates of its publishes, the
+// QoS 1 and 2 publishes in flight, its subscriptions and the size of its payloads. The limits are
+// set for all the clients, the auth providers may override them by username.
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+)
+
+// Action is what the broker does with a client over its limits.
+type Action string
+
+const (
+	// Throttle slows the client down: its connection isn't read until it's back under the rates
+	// and the inflight limit, the oversized payloads and the extra subscriptions are refused.
+	Throttle Action = "throttle"
+	// Disconnect closes the client, with the reason code for a 5.0 client.
+	Disconnect Action = "disconnect"
+)
+
+// Limits of a client, the zero values are unlimited.
+type Limits struct {
+	// PublishRate is the number of publishes per second, a second worth of them may be sent at once.
+	PublishRate float64 `json:"publish_rate" yaml:"publish_rate"`
+	// ByteRate is the number of payload bytes per second.
+	ByteRate float64 `json:"byte_rate" yaml:"byte_rate"`
+	// MaxInflight is the number of the QoS 1 and 2 publishes received and not acknowledged yet.
+	MaxInflight int `json:"max_inflight" yaml:"max_inflight"`
+	// MaxSubscriptions is the number of the filters subscribed at once.
+	MaxSubscriptions int `json:"max_subscriptions" yaml:"max_subscriptions"`
+	// MaxPayload is the size of a payload in bytes.
+	MaxPayload int `json:"max_payload" yaml:"max_payload"`
+	// Action is Throttle if it's empty.
+	Action Action `json:"action" yaml:"action"`
+}
+
+// Validate checks the limits are not negative and the action is known.
+func (l Limits) Validate() error {
+	if l.PublishRate < 0 || l.ByteRate < 0 || l.MaxInflight < 0 || l.MaxSubscriptions < 0 || l.MaxPayload < 0 {
+		return errors.New("quota/Validate: the limits cannot be negative")
+	}
+	switch l.Action {
+	case "", Throttle, Disconnect:
+		return nil
+	default:
+		return fmt.Errorf("quota/Validate: unknown action %q", l.Action)
+	}
+}
+
+// Unlimited reports whether none of the limits is set.
+func (l Limits) Unlimited() bool {
+	return l.PublishRate == 0 && l.ByteRate == 0 && l.MaxInflight == 0 && l.MaxSubscriptions == 0 && l.MaxPayload == 0
+}
+
+// Override returns the limits with the ones set in o, the ones o leaves zero are kept.
+func (l Limits) Override(o Limits) Limits {
+	if o.PublishRate != 0 {
+		l.PublishRate = o.PublishRate
+	}
+	if o.ByteRate != 0 {
+		l.ByteRate = o.ByteRate
+	}
+	if o.MaxInflight != 0 {
+		l.MaxInflight = o.MaxInflight
+	}
+	if o.MaxSubscriptions != 0 {
+		l.MaxSubscriptions = o.MaxSubscriptions
+	}
+	if o.MaxPayload != 0 {
+		l.MaxPayload = o.MaxPayload
+	}
+	if o.Action != "" {
+		l.Action = o.Action
+	}
+	return l
+}
+
+// Disconnects reports whether the client is disconnected over its limits, rather than throttled.
+func (l Limits) Disconnects() bool {
+	return l.Action == Disconnect
+}
+
+// Limiter enforces the limits of a client, a nil Limiter allows everything.
+type Limiter struct {
+	limits Limits
+	clock  clock.Clock
+
+	// The token buckets of the rates, a second worth of tokens at most. A publish takes its tokens
+	// even when there are not enough, the debt is the time the client has to wait.
+	mu         sync.Mutex
+	last       time.Time
+	msgTokens  float64
+	byteTokens float64
+
+	inflight chan struct{}
+}
+
+// NewLimiter returns the Limiter of the limits, nil if they are unlimited.
+func NewLimiter(l Limits, c clock.Clock) *Limiter {
+	if l.Unlimited() {
+		return nil
+	}
+	c = clock.OrReal(c)
+	lm := &Limiter{
+		limits:     l,
+		clock:      c,
+		last:       c.Now(),
+		msgTokens:  l.PublishRate,
+		byteTokens: l.ByteRate,
+	}
+	if l.MaxInflight > 0 {
+		lm.inflight = make(chan struct{}, l.MaxInflight)
+	}
+	return lm
+}
+
+// Limits returns the limits enforced, zero for a nil Limiter.
+func (lm *Limiter) Limits() Limits {
+	if lm == nil {
+		return Limits{}
+	}
+	return lm.limits
+}
+
+// Reserve takes the tokens of a publish of the payload size, it returns how long the client has
+// to wait to be back under its rates, 0 if it isn't over them.
+func (lm *Limiter) Reserve(payload int) time.Duration {
+	if lm == nil || (lm.limits.PublishRate == 0 && lm.limits.ByteRate == 0) {
+		return 0
+	}
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	now := lm.clock.Now()
+	elapsed := now.Sub(lm.last).Seconds()
+	lm.last = now
+
+	var wait time.Duration
+	if rate := lm.limits.PublishRate; rate > 0 {
+		lm.msgTokens = refill(lm.msgTokens, rate, elapsed) - 1
+		wait = debt(lm.msgTokens, rate)
+	}
+	if rate := lm.limits.ByteRate; rate > 0 {
+		lm.byteTokens = refill(lm.byteTokens, rate, elapsed) - float64(payload)
+		if d := debt(lm.byteTokens, rate); d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+func refill(tokens, rate, elapsed float64) float64 {
+	tokens += rate * elapsed
+	if tokens > rate {
+		tokens = rate
+	}
+	return tokens
+}
+
+func debt(tokens, rate float64) time.Duration {
+	if tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tokens / rate * float64(time.Second))
+}
+
+// AllowPayload reports whether the payload size is under the limit.
+func (lm *Limiter) AllowPayload(size int) bool {
+	return lm == nil || lm.limits.MaxPayload == 0 || size <= lm.limits.MaxPayload
+}
+
+// AllowSubscriptions reports whether the client may hold the number of subscriptions.
+func (lm *Limiter) AllowSubscriptions(n int) bool {
+	return lm == nil || lm.limits.MaxSubscriptions == 0 || n <= lm.limits.MaxSubscriptions
+}
+
+// TryAcquire takes a slot of the inflight publishes, it returns false if they are all taken.
+func (lm *Limiter) TryAcquire() bool {
+	if lm == nil || lm.inflight == nil {
+		return true
+	}
+	select {
+	case lm.inflight <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Acquire waits for a slot of the inflight publishes, it returns false if done is closed first.
+func (lm *Limiter) Acquire(done <-chan struct{}) bool {
+	if lm == nil || lm.inflight == nil {
+		return true
+	}
+	select {
+	case lm.inflight <- struct{}{}:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// Release frees the slot taken by TryAcquire or Acquire.
+func (lm *Limiter) Release() {
+	if lm == nil || lm.inflight == nil {
+		return
+	}
+	select {
+	case <-lm.inflight:
+	default:
+	}
+}
+
+// Inflight returns the number of the inflight publishes.
+func (lm *Limiter) Inflight() int {
+	if lm == nil || lm.inflight == nil {
+		return 0
+	}
+	return len(lm.inflight)
+}
@@ -0,0 +1,92 @@
+package quota
+
+import (
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimits(t *testing.T) {
+	require.NoError(t, Limits{}.Validate())
+	require.Error(t, Limits{MaxPayload: -1}.Validate())
+	require.Error(t, Limits{Action: "ban"}.Validate())
+	require.True(t, Limits{}.Unlimited())
+	require.Nil(t, NewLimiter(Limits{Action: Disconnect}, nil))
+
+	l := Limits{PublishRate: 10, MaxPayload: 1024}.Override(Limits{MaxPayload: 64, Action: Disconnect})
+	require.Equal(t, Limits{PublishRate: 10, MaxPayload: 64, Action: Disconnect}, l)
+	require.True(t, l.Disconnects())
+}
+
+func TestLimiterRates(t *testing.T) {
+	mock := clock.NewMock(time.Unix(0, 0))
+	lm := NewLimiter(Limits{PublishRate: 2, ByteRate: 100}, mock)
+
+	require.Zero(t, lm.Reserve(10))
+	require.Zero(t, lm.Reserve(10))
+	// a third publish in the same second waits half a second
+	require.Equal(t, 500*time.Millisecond, lm.Reserve(10))
+
+	mock.Add(2 * time.Second)
+	// the large payload is let through, then waited off at the byte rate
+	require.Equal(t, time.Second, lm.Reserve(200))
+
+	var none *Limiter
+	require.Zero(t, none.Reserve(1<<20))
+	require.True(t, none.AllowPayload(1<<20))
+}
+
+func TestLimiterInflight(t *testing.T) {
+	lm := NewLimiter(Limits{MaxInflight: 2, MaxSubscriptions: 1, MaxPayload: 8}, nil)
+	require.True(t, lm.TryAcquire())
+	require.True(t, lm.TryAcquire())
+	require.False(t, lm.TryAcquire())
+	require.Equal(t, 2, lm.Inflight())
+
+	done := make(chan struct{})
+	close(done)
+	require.False(t, lm.Acquire(done))
+	lm.Release()
+	require.True(t, lm.Acquire(nil))
+
+	require.True(t, lm.AllowPayload(8))
+	require.False(t, lm.AllowPayload(9))
+	require.True(t, lm.AllowSubscriptions(1))
+	require.False(t, lm.AllowSubscriptions(2))
+}
+
+func TestPayloadLimits(t *testing.T) {
+	none, err := NewPayloadLimits(nil)
+	require.NoError(t, err)
+	require.NoError(t, none.Check("a", 1<<20))
+
+	_, err = NewPayloadLimits([]PayloadLimit{{Filter: "a/#/b", MaxPayload: 1}})
+	require.Error(t, err)
+	_, err = NewPayloadLimits([]PayloadLimit{{Filter: "a/#", MaxPayload: 0}})
+	require.Error(t, err)
+
+	p, err := NewPayloadLimits([]PayloadLimit{
+		{Filter: "#", MaxPayload: 1024},
+		{Filter: "telemetry/#", MaxPayload: 4096},
+		{Filter: "files/+/upload", MaxPayload: 5 << 20},
+		{Filter: "telemetry/+/raw", MaxPayload: 64},
+	})
+	require.NoError(t, err)
+
+	l, ok := p.Limit("telemetry/d1/raw")
+	require.True(t, ok)
+	require.Equal(t, "telemetry/+/raw", l.Filter)
+	l, _ = p.Limit("telemetry/d1/temp")
+	require.Equal(t, "telemetry/#", l.Filter)
+	l, _ = p.Limit("files/f1/upload")
+	require.Equal(t, 5<<20, l.MaxPayload)
+	l, _ = p.Limit("other")
+	require.Equal(t, "#", l.Filter)
+
+	require.NoError(t, p.Check("telemetry/d1/temp", 4096))
+	require.Equal(t, ErrPayloadTooLarge, p.Check("telemetry/d1/temp", 4097))
+	require.Equal(t, ErrPayloadTooLarge, p.Check("telemetry/d1/raw", 65))
+}
@@ -0,0 +1,180 @@
+//go:build raft
+// +build raft
+
+package raftrepl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+const (
+	defaultApplyTimeout = 5 * time.Second
+
+	raftMaxPool       = 3
+	raftRetainSnaps   = 2
+	raftTransportWait = 10 * time.Second
+)
+
+// Config is the Raft node of a broker of the HA pair.
+type Config struct {
+	// NodeID is the id of the node in the cluster, Addr the host:port of its Raft transport
+	NodeID string
+	Addr   string
+	// Dir holds the log, the stable store and the snapshots
+	Dir string
+	// Bootstrap starts the cluster with the Peers, on the first node only, once
+	Bootstrap bool
+	// Peers are the Raft addresses of the nodes by their id, the node itself included
+	Peers map[string]string
+	// ApplyTimeout bounds a commit, 5s if it's zero
+	ApplyTimeout time.Duration
+}
+
+// Node is the Raft log of the FSM, built with the "raft" tag only.
+type Node struct {
+	raft    *raft.Raft
+	store   *raftboltdb.BoltStore
+	timeout time.Duration
+}
+
+var _ Log = (*Node)(nil)
+
+// Open starts the Raft node of the FSM, it's set as the log of the FSM. The appliers are
+// registered before, the log replays its entries to them.
+func Open(cfg Config, fsm *FSM) (*Node, error) {
+	if len(cfg.NodeID) == 0 || len(cfg.Addr) == 0 || len(cfg.Dir) == 0 {
+		return nil, errors.New("raftrepl/raft/Open: the node id, address and directory are required")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, err
+	}
+
+	rc := raft.DefaultConfig()
+	rc.LocalID = raft.ServerID(cfg.NodeID)
+
+	addr, err := net.ResolveTCPAddr("tcp", cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("raftrepl/raft/Open: invalid address %s => %v", cfg.Addr, err)
+	}
+	transport, err := raft.NewTCPTransport(cfg.Addr, addr, raftMaxPool, raftTransportWait, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := raft.NewFileSnapshotStore(cfg.Dir, raftRetainSnaps, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	store, err := raftboltdb.NewBoltStore(filepath.Join(cfg.Dir, "raft.db"))
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := raft.NewRaft(rc, raftFSM{fsm: fsm}, store, store, snapshots, transport)
+	if err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+	if cfg.Bootstrap {
+		servers := make([]raft.Server, 0, len(cfg.Peers))
+		for id, a := range cfg.Peers {
+			servers = append(servers, raft.Server{ID: raft.ServerID(id), Address: raft.ServerAddress(a)})
+		}
+		// the cluster is bootstrapped once, the later starts find it in the log
+		if err := r.BootstrapCluster(raft.Configuration{Servers: servers}).Error(); err != nil && err != raft.ErrCantBootstrap {
+			_ = r.Shutdown().Error()
+			_ = store.Close()
+			return nil, err
+		}
+	}
+
+	timeout := cfg.ApplyTimeout
+	if timeout <= 0 {
+		timeout = defaultApplyTimeout
+	}
+	n := &Node{raft: r, store: store, timeout: timeout}
+	fsm.SetLog(n)
+	return n, nil
+}
+
+// Commit replicates the command to a quorum, it returns once the FSM of the node has applied it.
+func (n *Node) Commit(kind string, cmd []byte) error {
+	data, err := Encode(kind, cmd)
+	if err != nil {
+		return err
+	}
+	f := n.raft.Apply(data, n.timeout)
+	if err := f.Error(); err != nil {
+		return fmt.Errorf("raftrepl/raft/Commit: %v", err)
+	}
+	if err, ok := f.Response().(error); ok && err != nil {
+		return err
+	}
+	return nil
+}
+
+func (n *Node) Leader() bool {
+	return n.raft.State() == raft.Leader
+}
+
+// LeaderCh receives true when the node becomes the leader, false when it loses the leadership.
+func (n *Node) LeaderCh() <-chan bool {
+	return n.raft.LeaderCh()
+}
+
+func (n *Node) Close() error {
+	err := n.raft.Shutdown().Error()
+	if cerr := n.store.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// raftFSM adapts the FSM to raft.FSM, the error of a command is the response of its entry.
+type raftFSM struct {
+	fsm *FSM
+}
+
+func (r raftFSM) Apply(l *raft.Log) interface{} {
+	if l.Type != raft.LogCommand {
+		return nil
+	}
+	return r.fsm.Apply(l.Data)
+}
+
+// Snapshot takes the state at once, raft applies the next commands while it's persisted.
+func (r raftFSM) Snapshot() (raft.FSMSnapshot, error) {
+	var buf bytes.Buffer
+	if err := r.fsm.WriteSnapshot(&buf); err != nil {
+		return nil, err
+	}
+	return raftSnapshot{data: buf.Bytes()}, nil
+}
+
+func (r raftFSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	return r.fsm.RestoreSnapshot(rc)
+}
+
+type raftSnapshot struct {
+	data []byte
+}
+
+func (s raftSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := sink.Write(s.data); err != nil {
+		_ = sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s raftSnapshot) Release() {}
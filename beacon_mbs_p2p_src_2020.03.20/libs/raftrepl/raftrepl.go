@@ -0,0 +1,187 @@
+// Package raftrepl commits the state of the providers through a replicated log, so the standby
+// broker of an HA pair holds the same retained messages, sessions and subscriptions as the active
+// one and takes over without losing them.
+//
+// The providers commit their changes through the log of the FSM and change their state when the FSM applies
+// the command, on every node in the same order. The Raft log, built on hashicorp/raft, is built
+// with the "raft" tag only; LocalLog applies the commands at once, for a single node.
+//
+// The providers are registered as "raft" before the broker picks them, on both nodes:
+//
+//	fsm := raftrepl.NewFSM()
+//	topics.RegisterRaftTopicsProvider(fsm)
+//	sessions.RegisterRaftSessionProvider(fsm)
+//	node, err := raftrepl.Open(raftrepl.Config{NodeID: "a", Addr: ":7000", Dir: "raft", ...}, fsm)
+//	b, err := broker_core_module.NewBroker(broker_core_module.WithTopicsManager("raft"), broker_core_module.WithSessionsManager("raft"), ...)
+package raftrepl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Log is the replicated log the providers commit their changes to. Commit returns once the command
+// is applied by the FSM of the node, or fails if the node is not the leader.
+type Log interface {
+	Commit(kind string, cmd []byte) error
+	Leader() bool
+}
+
+// Applier is the replicated state of a provider, registered in the FSM by its kind.
+type Applier interface {
+	// Apply applies the committed command, in the order of the log
+	Apply(cmd []byte) error
+	// Snapshot returns the state, Restore replaces the state with the one of a snapshot
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+// entry is the command of a log entry, by the kind of its applier.
+type entry struct {
+	Kind string `json:"kind"`
+	Cmd  []byte `json:"cmd"`
+}
+
+// FSM dispatches the committed commands to the appliers of their kind, the appliers commit their
+// commands through its log.
+type FSM struct {
+	mu       sync.RWMutex
+	appliers map[string]Applier
+	log      Log
+}
+
+func NewFSM() *FSM {
+	return &FSM{appliers: make(map[string]Applier)}
+}
+
+// Register adds the applier of the kind, it panics if the kind is registered twice.
+func (f *FSM) Register(kind string, a Applier) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, dup := f.appliers[kind]; dup {
+		panic("raftrepl: Register called twice for kind " + kind)
+	}
+	f.appliers[kind] = a
+}
+
+// SetLog sets the log the commands are committed to, the appliers are registered before the log
+// replays its entries.
+func (f *FSM) SetLog(l Log) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.log = l
+}
+
+// Commit commits the command of the applier of the kind through the log.
+func (f *FSM) Commit(kind string, cmd []byte) error {
+	f.mu.RLock()
+	l := f.log
+	f.mu.RUnlock()
+	if l == nil {
+		return errors.New("raftrepl/raftrepl/Commit: the log is not open")
+	}
+	return l.Commit(kind, cmd)
+}
+
+// Leader reports whether the node is the leader of the log, the one the clients are connected to.
+func (f *FSM) Leader() bool {
+	f.mu.RLock()
+	l := f.log
+	f.mu.RUnlock()
+	return l != nil && l.Leader()
+}
+
+// Encode returns the data of the log entry of the command.
+func Encode(kind string, cmd []byte) ([]byte, error) {
+	return json.Marshal(entry{Kind: kind, Cmd: cmd})
+}
+
+// Apply applies the data of a log entry.
+func (f *FSM) Apply(data []byte) error {
+	var e entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return fmt.Errorf("raftrepl/raftrepl/Apply: invalid entry => %v", err)
+	}
+
+	f.mu.RLock()
+	a, ok := f.appliers[e.Kind]
+	f.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("raftrepl/raftrepl/Apply: no applier for kind %q", e.Kind)
+	}
+	return a.Apply(e.Cmd)
+}
+
+// WriteSnapshot writes the states of all the appliers to w.
+func (f *FSM) WriteSnapshot(w io.Writer) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	states := make(map[string][]byte, len(f.appliers))
+	for kind, a := range f.appliers {
+		data, err := a.Snapshot()
+		if err != nil {
+			return fmt.Errorf("raftrepl/raftrepl/WriteSnapshot: snapshot %s error => %v", kind, err)
+		}
+		states[kind] = data
+	}
+	return json.NewEncoder(w).Encode(states)
+}
+
+// RestoreSnapshot replaces the states of the appliers with the ones of the snapshot, the appliers
+// missing from it are left as they are.
+func (f *FSM) RestoreSnapshot(r io.Reader) error {
+	var states map[string][]byte
+	if err := json.NewDecoder(r).Decode(&states); err != nil {
+		return fmt.Errorf("raftrepl/raftrepl/RestoreSnapshot: invalid snapshot => %v", err)
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for kind, data := range states {
+		a, ok := f.appliers[kind]
+		if !ok {
+			continue
+		}
+		if err := a.Restore(data); err != nil {
+			return fmt.Errorf("raftrepl/raftrepl/RestoreSnapshot: restore %s error => %v", kind, err)
+		}
+	}
+	return nil
+}
+
+// LocalLog is the log of a single node, it applies the commands at once.
+type LocalLog struct {
+	mu  sync.Mutex
+	fsm *FSM
+}
+
+// NewLocalLog returns the log of the FSM, it's set as the log of the FSM.
+func NewLocalLog(fsm *FSM) *LocalLog {
+	l := &LocalLog{fsm: fsm}
+	fsm.SetLog(l)
+	return l
+}
+
+// Commit applies the commands one at a time, like the log.
+func (l *LocalLog) Commit(kind string, cmd []byte) error {
+	data, err := Encode(kind, cmd)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.fsm.Apply(data)
+}
+
+func (l *LocalLog) Leader() bool {
+	return true
+}
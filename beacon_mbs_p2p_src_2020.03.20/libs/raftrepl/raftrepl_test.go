@@ -0,0 +1,63 @@
+package raftrepl
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testApplier struct {
+	cmds []string
+}
+
+func (a *testApplier) Apply(cmd []byte) error {
+	if string(cmd) == "fail" {
+		return errors.New("failed")
+	}
+	a.cmds = append(a.cmds, string(cmd))
+	return nil
+}
+
+func (a *testApplier) Snapshot() ([]byte, error) {
+	return []byte(strings.Join(a.cmds, ",")), nil
+}
+
+func (a *testApplier) Restore(data []byte) error {
+	a.cmds = strings.Split(string(data), ",")
+	return nil
+}
+
+func TestFSM(t *testing.T) {
+	fsm := NewFSM()
+	topics, sessions := &testApplier{}, &testApplier{}
+	fsm.Register("topics", topics)
+	fsm.Register("sessions", sessions)
+	require.Panics(t, func() { fsm.Register("topics", &testApplier{}) })
+
+	log := NewLocalLog(fsm)
+	require.True(t, log.Leader())
+	require.NoError(t, log.Commit("topics", []byte("a")))
+	require.NoError(t, log.Commit("sessions", []byte("b")))
+	require.NoError(t, log.Commit("topics", []byte("c")))
+	require.EqualError(t, log.Commit("topics", []byte("fail")), "failed")
+	require.Error(t, log.Commit("unknown", []byte("d")))
+	require.Equal(t, []string{"a", "c"}, topics.cmds)
+	require.Equal(t, []string{"b"}, sessions.cmds)
+
+	var buf bytes.Buffer
+	require.NoError(t, fsm.WriteSnapshot(&buf))
+
+	// the standby restores the snapshot
+	standby := NewFSM()
+	restoredTopics, restoredSessions := &testApplier{}, &testApplier{}
+	standby.Register("topics", restoredTopics)
+	standby.Register("sessions", restoredSessions)
+	require.NoError(t, standby.RestoreSnapshot(&buf))
+	require.Equal(t, []string{"a", "c"}, restoredTopics.cmds)
+	require.Equal(t, []string{"b"}, restoredSessions.cmds)
+
+	require.Error(t, standby.RestoreSnapshot(strings.NewReader("not json")))
+}
@@ -0,0 +1,186 @@
+// Package receipts follows the QoS 1 and 2 deliveries of a publish until they're all acknowledged
+// or the timeout, then emits the receipt of the publish: how many subscribers were targeted, have
+// acknowledged it, or failed.
+package receipts
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+)
+
+const defaultTimeout = 30 * time.Second
+
+type Config struct {
+	// Filters select the publishes with a receipt
+	Filters []string `json:"filters" yaml:"filters"`
+	// Topic of the receipts, %c is replaced by the client id of the publisher
+	Topic string `json:"topic" yaml:"topic"`
+	// Timeout emits the receipt of the deliveries still unacknowledged, 30 seconds if it's 0
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+}
+
+func (c *Config) Validate() error {
+	if len(c.Filters) == 0 {
+		return errors.New("receipts/receipts/Validate: no filter")
+	}
+	for _, f := range c.Filters {
+		if err := topics.ValidateTopicFilter([]byte(f)); err != nil {
+			return fmt.Errorf("receipts/receipts/Validate: %v", err)
+		}
+	}
+	if err := topics.ValidatePublishTopic([]byte(strings.Replace(c.Topic, "%c", "c", -1))); err != nil {
+		return fmt.Errorf("receipts/receipts/Validate: invalid receipt topic => %v", err)
+	}
+	if c.Timeout < 0 {
+		return errors.New("receipts/receipts/Validate: negative timeout")
+	}
+	return nil
+}
+
+// Matches reports whether the publishes to the topic have a receipt.
+func (c *Config) Matches(topic string) bool {
+	for _, f := range c.Filters {
+		if ok, _ := topics.MatchTopic([]byte(f), []byte(topic)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ReceiptTopic returns the topic of the receipts of the publisher.
+func (c *Config) ReceiptTopic(clientID string) string {
+	return strings.Replace(c.Topic, "%c", clientID, -1)
+}
+
+type Receipt struct {
+	Topic     string    `json:"topic"`
+	ClientID  string    `json:"client_id"`
+	MessageID uint16    `json:"message_id"`
+	Published time.Time `json:"published"`
+	Completed time.Time `json:"completed"`
+
+	Targets      int  `json:"targets"`
+	Acknowledged int  `json:"acknowledged"`
+	Failed       int  `json:"failed"`
+	TimedOut     bool `json:"timed_out"`
+}
+
+// Pending is the receipt of a publish being delivered, its methods do nothing on nil.
+type Pending struct {
+	t        *Tracker
+	deadline time.Time
+	sealed   bool
+	done     bool
+	r        Receipt
+}
+
+// Tracker holds the pending receipts, emit is called once per receipt without a lock held.
+type Tracker struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	emit    func(Receipt)
+	pending map[*Pending]struct{}
+}
+
+func NewTracker(timeout time.Duration, emit func(Receipt)) *Tracker {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Tracker{timeout: timeout, emit: emit, pending: make(map[*Pending]struct{})}
+}
+
+// Start begins the receipt of the publish, the deliveries are added until it's sealed.
+func (t *Tracker) Start(topic string, clientID string, messageID uint16, now time.Time) *Pending {
+	p := &Pending{
+		t:        t,
+		deadline: now.Add(t.timeout),
+		r:        Receipt{Topic: topic, ClientID: clientID, MessageID: messageID, Published: now},
+	}
+	t.mu.Lock()
+	t.pending[p] = struct{}{}
+	t.mu.Unlock()
+	return p
+}
+
+// Add counts a delivery waiting for its acknowledgement.
+func (p *Pending) Add() {
+	if p == nil {
+		return
+	}
+	p.t.mu.Lock()
+	if !p.done {
+		p.r.Targets++
+	}
+	p.t.mu.Unlock()
+}
+
+func (p *Pending) Ack(now time.Time) {
+	p.update(now, func() { p.r.Acknowledged++ })
+}
+
+func (p *Pending) Fail(now time.Time) {
+	p.update(now, func() { p.r.Failed++ })
+}
+
+// Seal ends the deliveries of the publish, the receipt is emitted once they're all settled.
+func (p *Pending) Seal(now time.Time) {
+	p.update(now, func() { p.sealed = true })
+}
+
+func (p *Pending) update(now time.Time, f func()) {
+	if p == nil {
+		return
+	}
+	t := p.t
+	t.mu.Lock()
+	if p.done {
+		t.mu.Unlock()
+		return
+	}
+	f()
+	complete := p.sealed && p.r.Acknowledged+p.r.Failed >= p.r.Targets
+	if complete {
+		p.done = true
+		p.r.Completed = now
+		delete(t.pending, p)
+	}
+	r := p.r
+	t.mu.Unlock()
+
+	if complete {
+		t.emit(r)
+	}
+}
+
+// Expire emits the receipts past their timeout.
+func (t *Tracker) Expire(now time.Time) {
+	var expired []Receipt
+	t.mu.Lock()
+	for p := range t.pending {
+		if now.Before(p.deadline) {
+			continue
+		}
+		p.done = true
+		p.r.TimedOut = true
+		p.r.Completed = now
+		delete(t.pending, p)
+		expired = append(expired, p.r)
+	}
+	t.mu.Unlock()
+
+	for _, r := range expired {
+		t.emit(r)
+	}
+}
+
+// Len returns the number of pending receipts.
+func (t *Tracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
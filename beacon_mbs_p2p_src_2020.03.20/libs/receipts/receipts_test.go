@@ -0,0 +1,59 @@
+package receipts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracker(t *testing.T) {
+	var emitted []Receipt
+	tr := NewTracker(time.Second, func(r Receipt) { emitted = append(emitted, r) })
+	now := time.Unix(1584700000, 0)
+
+	p := tr.Start("a/b", "c1", 7, now)
+	p.Add()
+	p.Add()
+	p.Ack(now)
+	p.Seal(now)
+	require.Empty(t, emitted)
+	p.Fail(now)
+	require.Len(t, emitted, 1)
+	require.Equal(t, 2, emitted[0].Targets)
+	require.Equal(t, 1, emitted[0].Acknowledged)
+	require.Equal(t, 1, emitted[0].Failed)
+	require.False(t, emitted[0].TimedOut)
+	p.Ack(now)
+	require.Len(t, emitted, 1)
+
+	// no QoS 1 or 2 subscriber, the receipt is emitted when sealed
+	tr.Start("a/b", "c1", 8, now).Seal(now)
+	require.Len(t, emitted, 2)
+	require.Equal(t, 0, emitted[1].Targets)
+
+	p = tr.Start("a/b", "c1", 9, now)
+	p.Add()
+	p.Seal(now)
+	tr.Expire(now.Add(time.Second / 2))
+	require.Len(t, emitted, 2)
+	tr.Expire(now.Add(time.Second))
+	require.Len(t, emitted, 3)
+	require.True(t, emitted[2].TimedOut)
+	require.Equal(t, 0, tr.Len())
+
+	var nilPending *Pending
+	nilPending.Add()
+	nilPending.Ack(now)
+}
+
+func TestConfig(t *testing.T) {
+	cfg := Config{Filters: []string{"orders/#"}, Topic: "receipts/%c"}
+	require.NoError(t, cfg.Validate())
+	require.True(t, cfg.Matches("orders/1"))
+	require.False(t, cfg.Matches("stock/1"))
+	require.Equal(t, "receipts/c1", cfg.ReceiptTopic("c1"))
+
+	require.Error(t, (&Config{Filters: []string{"orders/#"}, Topic: "receipts/+"}).Validate())
+	require.Error(t, (&Config{Topic: "receipts"}).Validate())
+}
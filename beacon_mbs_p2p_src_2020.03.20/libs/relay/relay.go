@@ -0,0 +1,149 @@
+// Package relay caps and accounts the traffic a broker relays between two peers which cannot
+// reach each other directly, such as two brokers behind NATs when the hole punching fails. Each
+// link (source, target) gets its own token bucket, so one busy pair cannot starve the others.
+package relay
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+)
+
+const defaultMaxLinks = 256
+
+var ErrTooManyLinks = errors.New("relay: too many relayed links")
+
+type Config struct {
+	// Rate is the bytes per second relayed for each link, 0 is unlimited.
+	Rate int64 `json:"rate"`
+	// Burst is the bytes a link may send at once, Rate if it's 0.
+	Burst int64 `json:"burst"`
+	// MaxLinks is the number of links relayed at the same time, 256 if it's 0.
+	MaxLinks int `json:"max_links"`
+	// IdleTimeout forgets the links idle for longer, they are kept if it's 0.
+	IdleTimeout time.Duration `json:"idle_timeout"`
+}
+
+type LinkStats struct {
+	Source   string    `json:"source"`
+	Target   string    `json:"target"`
+	Messages uint64    `json:"messages"`
+	Bytes    uint64    `json:"bytes"`
+	Dropped  uint64    `json:"dropped"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+type linkKey struct {
+	source string
+	target string
+}
+
+type link struct {
+	stats  LinkStats
+	tokens float64
+	last   time.Time
+}
+
+type Relay struct {
+	mu    sync.Mutex
+	cfg   Config
+	clock clock.Clock
+	links map[linkKey]*link
+}
+
+// New returns a relay with the config, the wall clock is used if c is nil.
+func New(cfg Config, c clock.Clock) (*Relay, error) {
+	if cfg.Rate < 0 || cfg.Burst < 0 || cfg.MaxLinks < 0 || cfg.IdleTimeout < 0 {
+		return nil, errors.New("relay/relay/New: negative limit")
+	}
+	if cfg.Burst == 0 {
+		cfg.Burst = cfg.Rate
+	}
+	if cfg.MaxLinks == 0 {
+		cfg.MaxLinks = defaultMaxLinks
+	}
+
+	return &Relay{
+		cfg:   cfg,
+		clock: clock.OrReal(c),
+		links: make(map[linkKey]*link),
+	}, nil
+}
+
+func (r *Relay) Config() Config {
+	return r.cfg
+}
+
+// Allow reports whether the n bytes from the source to the target may be relayed now, and
+// accounts them. The refused messages are counted as dropped.
+func (r *Relay) Allow(source string, target string, n int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	key := linkKey{source: source, target: target}
+	l, ok := r.links[key]
+	if !ok {
+		r.expire(now)
+		if len(r.links) >= r.cfg.MaxLinks {
+			return false, ErrTooManyLinks
+		}
+		l = &link{
+			stats:  LinkStats{Source: source, Target: target},
+			tokens: float64(r.cfg.Burst),
+			last:   now,
+		}
+		r.links[key] = l
+	}
+	l.stats.LastSeen = now
+
+	if r.cfg.Rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * float64(r.cfg.Rate)
+		if l.tokens > float64(r.cfg.Burst) {
+			l.tokens = float64(r.cfg.Burst)
+		}
+		l.last = now
+
+		if float64(n) > l.tokens {
+			l.stats.Dropped++
+			return false, nil
+		}
+		l.tokens -= float64(n)
+	}
+
+	l.stats.Messages++
+	l.stats.Bytes += uint64(n)
+	return true, nil
+}
+
+func (r *Relay) expire(now time.Time) {
+	if r.cfg.IdleTimeout == 0 {
+		return
+	}
+	for key, l := range r.links {
+		if now.Sub(l.stats.LastSeen) > r.cfg.IdleTimeout {
+			delete(r.links, key)
+		}
+	}
+}
+
+// Stats returns the accounting of the links sorted by source and target.
+func (r *Relay) Stats() []LinkStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := make([]LinkStats, 0, len(r.links))
+	for _, l := range r.links {
+		list = append(list, l.stats)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Source != list[j].Source {
+			return list[i].Source < list[j].Source
+		}
+		return list[i].Target < list[j].Target
+	})
+	return list
+}
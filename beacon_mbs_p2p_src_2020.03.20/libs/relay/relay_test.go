@@ -0,0 +1,55 @@
+package relay
+
+import (
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelayRate(t *testing.T) {
+	mock := clock.NewMock(time.Unix(1584662400, 0))
+	r, err := New(Config{Rate: 100}, mock)
+	require.NoError(t, err)
+
+	ok, err := r.Allow("a", "b", 60)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, _ = r.Allow("a", "b", 60)
+	require.False(t, ok)
+	// the other links have their own budget
+	ok, _ = r.Allow("c", "b", 60)
+	require.True(t, ok)
+
+	mock.Add(200 * time.Millisecond)
+	ok, _ = r.Allow("a", "b", 60)
+	require.True(t, ok)
+
+	require.Equal(t, []LinkStats{
+		{Source: "a", Target: "b", Messages: 2, Bytes: 120, Dropped: 1, LastSeen: mock.Now()},
+		{Source: "c", Target: "b", Messages: 1, Bytes: 60, LastSeen: mock.Now().Add(-200 * time.Millisecond)},
+	}, r.Stats())
+}
+
+func TestRelayLinks(t *testing.T) {
+	mock := clock.NewMock(time.Unix(1584662400, 0))
+	r, err := New(Config{MaxLinks: 1, IdleTimeout: time.Minute}, mock)
+	require.NoError(t, err)
+
+	ok, err := r.Allow("a", "b", 1<<20)
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, err = r.Allow("a", "c", 1)
+	require.Equal(t, ErrTooManyLinks, err)
+
+	mock.Add(2 * time.Minute)
+	ok, err = r.Allow("a", "c", 1)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Len(t, r.Stats(), 1)
+
+	_, err = New(Config{Rate: -1}, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,94 @@
+package replay
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrReplayed = errors.New("replay: nonce has already been used")
+	ErrStale    = errors.New("replay: timestamp is too old")
+	ErrFuture   = errors.New("replay: timestamp is in the future")
+	ErrNoNonce  = errors.New("replay: nonce cannot be empty")
+)
+
+// Token is the anti-replay data of a CONNECT, supplied by the auth provider (for example, parsed from
+// a signed token carried in the password). IssuedAt is optional, the nonce is checked alone if
+// it's zero.
+type Token struct {
+	Nonce    string
+	IssuedAt time.Time
+}
+
+// Guard remembers the nonces seen within the window, a nonce is rejected if it shows up again
+// before it expires. A token older than the window is rejected as stale, since its nonce may have
+// been forgotten already. Skew is the tolerance for the clocks of the clients running ahead.
+type Guard struct {
+	mu     sync.Mutex
+	window time.Duration
+	skew   time.Duration
+	seen   map[string]time.Time
+
+	lastSweep time.Time
+}
+
+func NewGuard(window time.Duration, skew time.Duration) *Guard {
+	return &Guard{
+		window: window,
+		skew:   skew,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// Check accepts the token once, and records its nonce until it expires.
+func (g *Guard) Check(token Token, now time.Time) error {
+	if len(token.Nonce) == 0 {
+		return ErrNoNonce
+	}
+
+	expire := now.Add(g.window)
+	if !token.IssuedAt.IsZero() {
+		if token.IssuedAt.After(now.Add(g.skew)) {
+			return ErrFuture
+		}
+		if now.Sub(token.IssuedAt) > g.window {
+			return ErrStale
+		}
+		expire = token.IssuedAt.Add(g.window + g.skew)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.sweep(now)
+
+	if exp, ok := g.seen[token.Nonce]; ok && now.Before(exp) {
+		return ErrReplayed
+	}
+	g.seen[token.Nonce] = expire
+
+	return nil
+}
+
+// Len returns the number of remembered nonces.
+func (g *Guard) Len() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return len(g.seen)
+}
+
+// The expired nonces are dropped at most once per window.
+func (g *Guard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < g.window {
+		return
+	}
+	g.lastSweep = now
+
+	for nonce, exp := range g.seen {
+		if !now.Before(exp) {
+			delete(g.seen, nonce)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package replay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGuardCheck(t *testing.T) {
+	g := NewGuard(time.Minute, 5*time.Second)
+	now := time.Now()
+
+	require.Equal(t, ErrNoNonce, g.Check(Token{}, now))
+
+	require.NoError(t, g.Check(Token{Nonce: "n1", IssuedAt: now}, now))
+	require.Equal(t, ErrReplayed, g.Check(Token{Nonce: "n1", IssuedAt: now}, now.Add(time.Second)))
+
+	require.Equal(t, ErrStale, g.Check(Token{Nonce: "n2", IssuedAt: now.Add(-2 * time.Minute)}, now))
+	require.Equal(t, ErrFuture, g.Check(Token{Nonce: "n3", IssuedAt: now.Add(time.Minute)}, now))
+	require.NoError(t, g.Check(Token{Nonce: "n4", IssuedAt: now.Add(3 * time.Second)}, now))
+
+	// without timestamp, the nonce alone is remembered for the window
+	require.NoError(t, g.Check(Token{Nonce: "n5"}, now))
+	require.Equal(t, ErrReplayed, g.Check(Token{Nonce: "n5"}, now.Add(30*time.Second)))
+	require.Equal(t, 3, g.Len())
+}
+
+func TestGuardSweep(t *testing.T) {
+	g := NewGuard(time.Minute, 0)
+	now := time.Now()
+
+	require.NoError(t, g.Check(Token{Nonce: "n1"}, now))
+	require.NoError(t, g.Check(Token{Nonce: "n2", IssuedAt: now}, now))
+
+	later := now.Add(2 * time.Minute)
+	require.NoError(t, g.Check(Token{Nonce: "n1"}, later))
+	require.Equal(t, 1, g.Len())
+}
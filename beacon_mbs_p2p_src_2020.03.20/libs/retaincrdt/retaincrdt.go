@@ -0,0 +1,121 @@
+// Package retaincrdt replicates the retained messages between the brokers as a last-writer-wins
+// map: each retained message is stamped with a hybrid logical clock by the broker it's published
+// to, and a broker keeps the message with the greatest stamp whatever the order the messages come
+// in. The brokers accept the retained publishes during a partition and converge once they exchange
+// their entries again. A message with an empty payload is a tombstone, it removes the retained
+// message and is kept a while so an older message cannot bring it back.
+package retaincrdt
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+	"awesomeProject/beacon/mqtt_network/libs/hlc"
+)
+
+type Entry struct {
+	Topic   string        `json:"topic"`
+	Payload []byte        `json:"payload,omitempty"`
+	Qos     byte          `json:"qos"`
+	Stamp   hlc.Timestamp `json:"stamp"`
+}
+
+// Deleted reports whether the entry is a tombstone.
+func (e Entry) Deleted() bool {
+	return len(e.Payload) == 0
+}
+
+type Map struct {
+	mu      sync.Mutex
+	hlc     *hlc.Clock
+	wall    clock.Clock
+	entries map[string]Entry
+}
+
+// New returns the map of the node, the wall clock is used if c is nil.
+func New(node string, c clock.Clock) *Map {
+	c = clock.OrReal(c)
+	return &Map{
+		hlc:     hlc.New(node, c, 0),
+		wall:    c,
+		entries: make(map[string]Entry),
+	}
+}
+
+// Set stamps a retained message published to this broker, it always wins over the entries known
+// so far. The returned entry is the one to send to the other brokers.
+func (m *Map) Set(topic string, payload []byte, qos byte) Entry {
+	e := Entry{Topic: topic, Payload: payload, Qos: qos, Stamp: m.hlc.Now()}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[topic] = e
+	return e
+}
+
+// Merge applies the entries received from another broker, and returns those which won and need
+// to be applied to the retained messages. The entries stamped too far ahead of the wall clock are
+// skipped, an error counts them.
+func (m *Map) Merge(entries []Entry) ([]Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var won []Entry
+	skipped := 0
+	for _, e := range entries {
+		if _, err := m.hlc.Update(e.Stamp); err != nil {
+			skipped++
+			continue
+		}
+		if cur, ok := m.entries[e.Topic]; ok && !cur.Stamp.Less(e.Stamp) {
+			continue
+		}
+		m.entries[e.Topic] = e
+		won = append(won, e)
+	}
+	if skipped > 0 {
+		return won, fmt.Errorf("retaincrdt/retaincrdt/Merge: %d entries stamped ahead of the clock", skipped)
+	}
+	return won, nil
+}
+
+func (m *Map) Get(topic string) (Entry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[topic]
+	return e, ok
+}
+
+// Entries returns all the entries, the tombstones included, by topic.
+func (m *Map) Entries() []Entry {
+	m.mu.Lock()
+	list := make([]Entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		list = append(list, e)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Topic < list[j].Topic })
+	return list
+}
+
+// Prune forgets the tombstones older than age, and returns how many there were. A broker which
+// has been away for longer may bring the removed messages back.
+func (m *Map) Prune(age time.Duration) int {
+	before := m.wall.Now().Add(-age).UnixNano()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pruned := 0
+	for topic, e := range m.entries {
+		if e.Deleted() && e.Stamp.Wall < before {
+			delete(m.entries, topic)
+			pruned++
+		}
+	}
+	return pruned
+}
@@ -0,0 +1,75 @@
+package retaincrdt
+
+import (
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConverge(t *testing.T) {
+	mock := clock.NewMock(time.Unix(1584662400, 0))
+	eu := New("eu", mock)
+	us := New("us", mock)
+
+	// both regions accept the retained publishes during a partition
+	e1 := eu.Set("sensors/1", []byte("eu-1"), 1)
+	mock.Add(time.Second)
+	u1 := us.Set("sensors/1", []byte("us-1"), 0)
+	u2 := us.Set("sensors/2", []byte("us-2"), 0)
+	mock.Add(time.Second)
+	e2 := eu.Set("sensors/2", nil, 0)
+
+	// the entries are exchanged in any order once the partition heals
+	won, err := eu.Merge([]Entry{u2, u1})
+	require.NoError(t, err)
+	require.Equal(t, []Entry{u1}, won)
+	won, err = us.Merge([]Entry{e2, e1})
+	require.NoError(t, err)
+	require.Equal(t, []Entry{e2}, won)
+
+	require.Equal(t, eu.Entries(), us.Entries())
+	got, ok := us.Get("sensors/1")
+	require.True(t, ok)
+	require.Equal(t, []byte("us-1"), got.Payload)
+	got, ok = us.Get("sensors/2")
+	require.True(t, ok)
+	require.True(t, got.Deleted())
+
+	// merging again changes nothing
+	won, err = us.Merge(eu.Entries())
+	require.NoError(t, err)
+	require.Empty(t, won)
+
+	// a local write wins over all the entries seen, even from a clock ahead
+	ahead := Entry{Topic: "sensors/3", Payload: []byte("ahead"), Stamp: e1.Stamp}
+	ahead.Stamp.Wall += int64(30 * time.Second)
+	_, err = eu.Merge([]Entry{ahead})
+	require.NoError(t, err)
+	local := eu.Set("sensors/3", []byte("local"), 0)
+	require.True(t, ahead.Stamp.Less(local.Stamp))
+
+	// the entries too far ahead are skipped
+	far := Entry{Topic: "sensors/4", Payload: []byte("far"), Stamp: e1.Stamp}
+	far.Stamp.Wall += int64(time.Hour)
+	won, err = eu.Merge([]Entry{far})
+	require.Error(t, err)
+	require.Empty(t, won)
+}
+
+func TestPrune(t *testing.T) {
+	mock := clock.NewMock(time.Unix(1584662400, 0))
+	m := New("eu", mock)
+
+	m.Set("a", nil, 0)
+	m.Set("b", []byte("b"), 0)
+	mock.Add(time.Hour)
+	m.Set("c", nil, 0)
+
+	require.Equal(t, 1, m.Prune(30*time.Minute))
+	require.Len(t, m.Entries(), 2)
+	_, ok := m.Get("a")
+	require.False(t, ok)
+}
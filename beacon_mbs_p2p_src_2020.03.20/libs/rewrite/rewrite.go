@@ -0,0 +1,274 @@
+// Package rewrite maps the topics of the inbound publishes and the filters of the subscriptions
+// to other ones, so the topic layout may change without touching every client. The rules are
+// tried in order and the first rule whose pattern matches rewrites the topic.
+//
+// A template pattern is a filter whose wildcards may be named, +id captures a level and #rest the
+// remaining levels, and the target refers to them as $id or ${id}:
+//
+//	legacy/+id/temp -> devices/$id/temperature
+//
+// A regex pattern is matched against the whole topic, and the target refers to its groups as $1
+// or ${name}. The filters of the subscriptions are rewritten the same way, their wildcards being
+// captured like any level, so legacy/+/temp becomes devices/+/temperature.
+package rewrite
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Access byte
+
+const (
+	Publish Access = 1 << iota
+	Subscribe
+)
+
+type Rule struct {
+	// Access is publish, subscribe or both if it's empty.
+	Access string `json:"access" yaml:"access"`
+	// From is the pattern of the topics rewritten, a template or a regex if Regex is set.
+	From  string `json:"from" yaml:"from"`
+	To    string `json:"to" yaml:"to"`
+	Regex bool   `json:"regex" yaml:"regex"`
+}
+
+type Config struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+type rule struct {
+	access Access
+	to     string
+
+	// the levels of a template, or the regex
+	levels []string
+	re     *regexp.Regexp
+}
+
+type Engine struct {
+	mu      sync.RWMutex
+	path    string
+	modTime time.Time
+	rules   []rule
+}
+
+// New returns an engine with the rules of the config.
+func New(cfg Config) (*Engine, error) {
+	rules, err := compile(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{rules: rules}, nil
+}
+
+// Load returns an engine with the rules of the JSON or YAML file.
+func Load(path string) (*Engine, error) {
+	e := &Engine{path: path}
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reload reads the file of the rules again, the current rules are kept if it's invalid. The
+// publishes and the subscriptions are rewritten by the new rules from then on, the subscriptions
+// made already are kept as they were rewritten.
+func (e *Engine) Reload() error {
+	if len(e.path) == 0 {
+		return errors.New("rewrite/rewrite/Reload: the rules were not loaded from a file")
+	}
+	fi, err := os.Stat(e.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(e.path)
+	if err != nil {
+		return err
+	}
+
+	// JSON is YAML too
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("rewrite/rewrite/Reload: %v", err)
+	}
+	rules, err := compile(cfg)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.rules = rules
+	e.modTime = fi.ModTime()
+	e.mu.Unlock()
+	return nil
+}
+
+// Refresh reloads the file of the rules if it has changed since it was read, it reports whether
+// it was reloaded.
+func (e *Engine) Refresh() (bool, error) {
+	if len(e.path) == 0 {
+		return false, nil
+	}
+	fi, err := os.Stat(e.path)
+	if err != nil {
+		return false, err
+	}
+	e.mu.RLock()
+	changed := !fi.ModTime().Equal(e.modTime)
+	e.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	return true, e.Reload()
+}
+
+func compile(cfg Config) ([]rule, error) {
+	var rules []rule
+	for i, r := range cfg.Rules {
+		cr := rule{to: r.To}
+		switch r.Access {
+		case "publish":
+			cr.access = Publish
+		case "subscribe":
+			cr.access = Subscribe
+		case "", "all":
+			cr.access = Publish | Subscribe
+		default:
+			return nil, fmt.Errorf("rewrite/rewrite/compile: rule %d: unknown access %q", i, r.Access)
+		}
+		if len(r.From) == 0 || len(r.To) == 0 {
+			return nil, fmt.Errorf("rewrite/rewrite/compile: rule %d has no from or no to", i)
+		}
+
+		if r.Regex {
+			re, err := regexp.Compile("^(?:" + r.From + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("rewrite/rewrite/compile: rule %d: %v", i, err)
+			}
+			cr.re = re
+		} else {
+			levels, err := compileTemplate(r.From, r.To)
+			if err != nil {
+				return nil, fmt.Errorf("rewrite/rewrite/compile: rule %d: %v", i, err)
+			}
+			cr.levels = levels
+		}
+		rules = append(rules, cr)
+	}
+	return rules, nil
+}
+
+// compileTemplate checks the wildcards of the template, and that the target refers to its names
+// only.
+func compileTemplate(from string, to string) ([]string, error) {
+	levels := strings.Split(from, topics.SEP)
+	names := make(map[string]bool)
+	for i, level := range levels {
+		switch {
+		case strings.HasPrefix(level, topics.MWC):
+			if i != len(levels)-1 {
+				return nil, fmt.Errorf("# is not the last level of %q", from)
+			}
+			names[level[1:]] = true
+		case strings.HasPrefix(level, topics.SWC):
+			names[level[1:]] = true
+		case strings.ContainsAny(level, topics.MWC+topics.SWC):
+			return nil, fmt.Errorf("invalid level %q of %q", level, from)
+		}
+	}
+
+	var unknown []string
+	os.Expand(to, func(name string) string {
+		if len(name) == 0 || !names[name] {
+			unknown = append(unknown, name)
+		}
+		return ""
+	})
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("%q refers to %q, not a wildcard of %q", to, unknown[0], from)
+	}
+	return levels, nil
+}
+
+// Publish returns the topic of the publish rewritten, false if no rule rewrites it.
+func (e *Engine) Publish(topic string) (string, bool) {
+	rewritten, ok := e.rewrite(Publish, topic)
+	if !ok || topics.ValidatePublishTopic([]byte(rewritten)) != nil {
+		return topic, false
+	}
+	return rewritten, true
+}
+
+// Subscribe returns the filter of the subscription rewritten, without its share group, false if no
+// rule rewrites it.
+func (e *Engine) Subscribe(filter string) (string, bool) {
+	rewritten, ok := e.rewrite(Subscribe, filter)
+	if !ok || topics.ValidateTopicFilter([]byte(rewritten)) != nil {
+		return filter, false
+	}
+	return rewritten, true
+}
+
+// rewrite applies the first rule whose pattern matches the topic.
+func (e *Engine) rewrite(access Access, topic string) (string, bool) {
+	e.mu.RLock()
+	rules := e.rules
+	e.mu.RUnlock()
+
+	for _, r := range rules {
+		if r.access&access == 0 {
+			continue
+		}
+		if r.re != nil {
+			if r.re.MatchString(topic) {
+				return r.re.ReplaceAllString(topic, r.to), true
+			}
+			continue
+		}
+		if values, ok := matchTemplate(r.levels, topic); ok {
+			return os.Expand(r.to, func(name string) string { return values[name] }), true
+		}
+	}
+	return topic, false
+}
+
+// matchTemplate returns the levels captured by the wildcards of the template, the wildcards don't
+// match the $ topics at the first level.
+func matchTemplate(levels []string, topic string) (map[string]string, bool) {
+	parts := strings.Split(topic, topics.SEP)
+	values := make(map[string]string)
+	for i, level := range levels {
+		wildcard := strings.HasPrefix(level, topics.SWC) || strings.HasPrefix(level, topics.MWC)
+		if wildcard && i == 0 && len(parts) > 0 && strings.HasPrefix(parts[0], "$") {
+			return nil, false
+		}
+		if strings.HasPrefix(level, topics.MWC) {
+			values[level[1:]] = strings.Join(parts[i:], topics.SEP)
+			return values, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		switch {
+		case strings.HasPrefix(level, topics.SWC):
+			if parts[i] == topics.MWC {
+				return nil, false
+			}
+			values[level[1:]] = parts[i]
+		case level != parts[i]:
+			return nil, false
+		}
+	}
+	return values, len(parts) == len(levels)
+}
@@ -0,0 +1,90 @@
+package rewrite
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngineRewrite(t *testing.T) {
+	e, err := New(Config{
+		Rules: []Rule{
+			{From: "legacy/+id/temp", To: "devices/$id/temperature"},
+			{Access: "publish", From: "legacy/+id/#rest", To: "devices/${id}/raw/$rest"},
+			{From: `old/(?P<site>\w+)-(\d+)`, To: "sites/${site}/rooms/$2", Regex: true},
+		},
+	})
+	require.NoError(t, err)
+
+	topic, ok := e.Publish("legacy/d1/temp")
+	require.True(t, ok)
+	require.Equal(t, "devices/d1/temperature", topic)
+
+	topic, ok = e.Publish("legacy/d1/a/b")
+	require.True(t, ok)
+	require.Equal(t, "devices/d1/raw/a/b", topic)
+
+	topic, ok = e.Publish("old/paris-12")
+	require.True(t, ok)
+	require.Equal(t, "sites/paris/rooms/12", topic)
+
+	topic, ok = e.Publish("devices/d1/temp")
+	require.False(t, ok)
+	require.Equal(t, "devices/d1/temp", topic)
+
+	// the wildcards of the filters are captured like the other levels
+	filter, ok := e.Subscribe("legacy/+/temp")
+	require.True(t, ok)
+	require.Equal(t, "devices/+/temperature", filter)
+
+	// the publish only rule doesn't rewrite the subscriptions, and + doesn't capture #
+	_, ok = e.Subscribe("legacy/d1/a/b")
+	require.False(t, ok)
+	_, ok = e.Subscribe("legacy/#")
+	require.False(t, ok)
+
+	_, err = New(Config{Rules: []Rule{{From: "legacy/+id/temp", To: "devices/$name"}}})
+	require.Error(t, err)
+	_, err = New(Config{Rules: []Rule{{From: "legacy/#/temp", To: "devices"}}})
+	require.Error(t, err)
+	_, err = New(Config{Rules: []Rule{{From: "legacy/(", To: "devices", Regex: true}}})
+	require.Error(t, err)
+}
+
+func TestEngineRefresh(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rewrite")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rewrite.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte("rules:\n  - from: a/+x\n    to: b/$x\n"), 0600))
+	e, err := Load(path)
+	require.NoError(t, err)
+
+	topic, _ := e.Publish("a/1")
+	require.Equal(t, "b/1", topic)
+
+	reloaded, err := e.Refresh()
+	require.NoError(t, err)
+	require.False(t, reloaded)
+
+	// an invalid file keeps the current rules
+	require.NoError(t, ioutil.WriteFile(path, []byte("rules:\n  - from: a/+x\n    to: b/$y\n"), 0600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	_, err = e.Refresh()
+	require.Error(t, err)
+	topic, _ = e.Publish("a/1")
+	require.Equal(t, "b/1", topic)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"rules": [{"from": "a/+x", "to": "c/$x"}]}`), 0600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second)))
+	reloaded, err = e.Refresh()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	topic, _ = e.Publish("a/1")
+	require.Equal(t, "c/1", topic)
+}
@@ -0,0 +1,105 @@
+// Package sampling selects a stable fraction of the topics matching a filter, so the analytics
+// get a sample of the traffic instead of the whole firehose.
+package sampling
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+)
+
+// Definition samples the topics matching Filter at Rate, the messages of the sampled topics are
+// mirrored under Topic, e.g. `sensors/room1/temp` to `analytics/sample/sensors/room1/temp`. With an
+// empty Topic the messages only go to the sample sinks.
+type Definition struct {
+	Filter string  `json:"filter"`
+	Topic  string  `json:"topic,omitempty"`
+	Rate   float64 `json:"rate"`
+}
+
+func (d *Definition) Validate() error {
+	if len(d.Filter) == 0 {
+		return fmt.Errorf("sampling/sampling/Validate: the filter cannot be empty")
+	}
+	if strings.ContainsAny(d.Topic, "#+") {
+		return fmt.Errorf("sampling/sampling/Validate: topic [%s] cannot contain wildcards", d.Topic)
+	}
+	if d.Rate <= 0 || d.Rate > 1 || math.IsNaN(d.Rate) {
+		return fmt.Errorf("sampling/sampling/Validate: the rate %v is not within (0, 1]", d.Rate)
+	}
+	return nil
+}
+
+// MirrorTopic returns the topic the sampled message of the topic is mirrored to.
+func (d *Definition) MirrorTopic(topic string) string {
+	return d.Topic + "/" + topic
+}
+
+type sampler struct {
+	def       Definition
+	threshold uint64
+}
+
+// Sampler holds the definitions, a topic is sampled by each of the definitions independently.
+type Sampler struct {
+	samplers []sampler
+}
+
+func New(definitions ...Definition) (*Sampler, error) {
+	s := &Sampler{}
+	for _, def := range definitions {
+		if err := def.Validate(); err != nil {
+			return nil, err
+		}
+		s.samplers = append(s.samplers, sampler{def: def, threshold: threshold(def.Rate)})
+	}
+	return s, nil
+}
+
+func threshold(rate float64) uint64 {
+	return uint64(rate * (1 << 32))
+}
+
+// Selected reports whether the topic is in the sample of the rate. It depends on the topic only,
+// so all the messages of a topic are sampled or none, on every broker, and the sample of a rate
+// includes the sample of any lower rate.
+func Selected(topic string, rate float64) bool {
+	return selected(topic, threshold(rate))
+}
+
+func selected(topic string, threshold uint64) bool {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(topic))
+	return uint64(h.Sum32()) < threshold
+}
+
+// Each calls fn with the definitions sampling the topic.
+func (s *Sampler) Each(topic string, fn func(def *Definition)) {
+	if s == nil {
+		return
+	}
+	for i := range s.samplers {
+		sp := &s.samplers[i]
+		if match, err := topics.MatchTopic([]byte(sp.def.Filter), []byte(topic)); err != nil || !match {
+			continue
+		}
+		if selected(topic, sp.threshold) {
+			fn(&sp.def)
+		}
+	}
+}
+
+// Definitions returns a copy of the definitions.
+func (s *Sampler) Definitions() []Definition {
+	if s == nil {
+		return nil
+	}
+	defs := make([]Definition, 0, len(s.samplers))
+	for _, sp := range s.samplers {
+		defs = append(defs, sp.def)
+	}
+	return defs
+}
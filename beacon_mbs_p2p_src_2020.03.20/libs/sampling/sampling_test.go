@@ -0,0 +1,64 @@
+package sampling
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelected(t *testing.T) {
+	sampled, nested := 0, 0
+	for i := 0; i < 100000; i++ {
+		topic := fmt.Sprintf("sensors/%d/temp", i)
+		if Selected(topic, 0.01) {
+			sampled++
+			// the 1% sample is within the 10% one
+			require.True(t, Selected(topic, 0.1))
+		}
+		if Selected(topic, 0.1) {
+			nested++
+		}
+	}
+	require.InDelta(t, 1000, sampled, 200)
+	require.InDelta(t, 10000, nested, 600)
+
+	require.True(t, Selected("sensors/1/temp", 1))
+}
+
+func TestSampler(t *testing.T) {
+	_, err := New(Definition{Filter: "sensors/#", Rate: 1.5})
+	require.Error(t, err)
+	_, err = New(Definition{Filter: "sensors/#", Topic: "analytics/#", Rate: 0.5})
+	require.Error(t, err)
+	_, err = New(Definition{Topic: "analytics", Rate: 0.5})
+	require.Error(t, err)
+
+	s, err := New(
+		Definition{Filter: "sensors/+/temp", Topic: "analytics/sample", Rate: 1},
+		Definition{Filter: "sensors/#", Rate: 0.5},
+	)
+	require.NoError(t, err)
+	require.Len(t, s.Definitions(), 2)
+
+	var got []string
+	s.Each("sensors/1/temp", func(def *Definition) {
+		got = append(got, def.Filter)
+		if len(def.Topic) > 0 {
+			require.Equal(t, "analytics/sample/sensors/1/temp", def.MirrorTopic("sensors/1/temp"))
+		}
+	})
+	require.Contains(t, got, "sensors/+/temp")
+	require.Equal(t, Selected("sensors/1/temp", 0.5), len(got) == 2)
+
+	got = got[:0]
+	s.Each("devices/1/state", func(def *Definition) {
+		got = append(got, def.Filter)
+	})
+	require.Empty(t, got)
+
+	var none *Sampler
+	none.Each("sensors/1/temp", func(*Definition) {
+		t.Fatal("a nil sampler samples nothing")
+	})
+}
@@ -0,0 +1,177 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronExpr is a standard 5 fields cron expression (minute hour day-of-month month day-of-week),
+// each field is a bitmap of the allowed values. The descriptors @yearly, @monthly, @weekly,
+// @daily and @hourly are accepted too, and "@every <duration>" for the fixed intervals.
+type cronExpr struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+
+	every time.Duration
+}
+
+type cronField struct {
+	min, max int
+}
+
+var (
+	cronFields = []cronField{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+func parseCron(spec string) (*cronExpr, error) {
+	spec = strings.TrimSpace(spec)
+
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil {
+			return nil, fmt.Errorf("schedule/cron/parseCron: invalid interval %q => %v", spec, err)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("schedule/cron/parseCron: interval %q is shorter than one second", spec)
+		}
+		return &cronExpr{every: d}, nil
+	}
+
+	if d, ok := cronDescriptors[spec]; ok {
+		spec = d
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("schedule/cron/parseCron: expected %d fields, found %d in %q", len(cronFields), len(fields), spec)
+	}
+
+	bits := make([]uint64, len(fields))
+	for i, f := range fields {
+		b, err := parseCronField(f, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("schedule/cron/parseCron: invalid field %q in %q => %v", f, spec, err)
+		}
+		bits[i] = b
+	}
+
+	return &cronExpr{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}, nil
+}
+
+// Parses the comma separated list of "*", "a", "a-b" with an optional "/step".
+func parseCronField(field string, r cronField) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s < 1 {
+				return 0, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			step = s
+			part = part[:i]
+		}
+
+		lo, hi := r.min, r.max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			v, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, err
+			}
+			lo, hi = v, v
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				hi = r.max
+			}
+		}
+
+		// Sunday is both 0 and 7 for the day of week
+		if r.max == 6 && hi == 7 {
+			if lo == 7 {
+				lo, hi = 0, 0
+			} else {
+				hi = 6
+				bits |= 1
+			}
+		}
+
+		if lo < r.min || hi > r.max || lo > hi {
+			return 0, fmt.Errorf("value out of range [%d-%d]", r.min, r.max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+// next returns the first activation strictly after t.
+func (c *cronExpr) next(t time.Time) time.Time {
+	if c.every > 0 {
+		return t.Add(c.every).Truncate(time.Second)
+	}
+
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// Five years is long enough to find any valid date (such as Feb 29).
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatch(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+// As the classic cron, if both day fields are restricted the day matches either of them.
+func (c *cronExpr) dayMatch(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
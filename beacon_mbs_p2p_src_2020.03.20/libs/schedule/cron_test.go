@@ -0,0 +1,44 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, spec := range []string{"* * * * *", "*/5 0-6 1,15 * 1-5", "0 12 * * 7", "@daily", "@every 30s"} {
+		_, err := parseCron(spec)
+		require.NoError(t, err, spec)
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "@every 10ms", "@sometimes"} {
+		_, err := parseCron(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	base := time.Date(2020, 3, 20, 10, 17, 30, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2020, 3, 20, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2020, 3, 20, 10, 30, 0, 0, time.UTC)},
+		{"0 9 * * *", time.Date(2020, 3, 21, 9, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2020, 3, 20, 11, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2020, 3, 22, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * 1", time.Date(2020, 3, 23, 0, 0, 0, 0, time.UTC)},
+		{"@every 1m", time.Date(2020, 3, 20, 10, 18, 30, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		c, err := parseCron(tt.spec)
+		require.NoError(t, err, tt.spec)
+		require.Equal(t, tt.next, c.next(base), tt.spec)
+	}
+}
@@ -0,0 +1,209 @@
+package schedule
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/atomicfile"
+	"awesomeProject/beacon/mqtt_network/libs/backup"
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+)
+
+// Entry is a recurring publish.
+type Entry struct {
+	ID      string `json:"id"`
+	Cron    string `json:"cron"`
+	Topic   string `json:"topic"`
+	Payload []byte `json:"payload"`
+	Qos     byte   `json:"qos"`
+	Retain  bool   `json:"retain"`
+
+	expr *cronExpr
+	next time.Time
+}
+
+// Next is the time of the next publish.
+func (e *Entry) Next() time.Time {
+	return e.next
+}
+
+// Scheduler keeps the entries and persists them to a JSON file (if the path is not empty) each
+// time they change, so the entries survive the broker restarts.
+type Scheduler struct {
+	mu      sync.Mutex
+	path    string
+	clock   clock.Clock
+	entries map[string]*Entry
+}
+
+// NewScheduler loads the entries persisted in the file, a missing file is an empty schedule.
+// The wall clock is used if c is nil.
+func NewScheduler(path string, c clock.Clock) (*Scheduler, error) {
+	s := &Scheduler{
+		path:    path,
+		clock:   clock.OrReal(c),
+		entries: make(map[string]*Entry),
+	}
+
+	if len(path) == 0 {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Entry
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("schedule/schedule/NewScheduler: invalid schedule file %s => %v", path, err)
+	}
+
+	now := s.clock.Now()
+	for i := range list {
+		e := list[i]
+		if err := e.prepare(now); err != nil {
+			return nil, err
+		}
+		s.entries[e.ID] = &e
+	}
+
+	return s, nil
+}
+
+func (e *Entry) prepare(now time.Time) error {
+	if len(e.ID) == 0 || len(e.Topic) == 0 {
+		return fmt.Errorf("schedule/schedule/prepare: id and topic cannot be empty")
+	}
+
+	if strings.ContainsAny(e.Topic, "+#") {
+		return fmt.Errorf("schedule/schedule/prepare: topic %s cannot contain wildcards", e.Topic)
+	}
+
+	if e.Qos > 2 {
+		return fmt.Errorf("schedule/schedule/prepare: Invalid QoS %d", e.Qos)
+	}
+
+	expr, err := parseCron(e.Cron)
+	if err != nil {
+		return err
+	}
+
+	e.expr = expr
+	e.next = expr.next(now)
+
+	return nil
+}
+
+// Add registers the entry, replacing the one with the same ID.
+func (s *Scheduler) Add(entry Entry) error {
+	if err := entry.prepare(s.clock.Now()); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, exist := s.entries[entry.ID]
+	s.entries[entry.ID] = &entry
+
+	if err := s.save(); err != nil {
+		if exist {
+			s.entries[entry.ID] = old
+		} else {
+			delete(s.entries, entry.ID)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *Scheduler) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, exist := s.entries[id]
+	if !exist {
+		return fmt.Errorf("schedule/schedule/Remove: No entry found for id %s", id)
+	}
+	delete(s.entries, id)
+
+	if err := s.save(); err != nil {
+		s.entries[id] = old
+		return err
+	}
+
+	return nil
+}
+
+func (s *Scheduler) Entries() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list()
+}
+
+// Due returns the entries whose activation time has come, and moves them to their next one.
+func (s *Scheduler) Due(now time.Time) []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []Entry
+	for _, e := range s.entries {
+		if e.next.IsZero() || now.Before(e.next) {
+			continue
+		}
+		due = append(due, *e)
+		e.next = e.expr.next(now)
+	}
+
+	sort.Slice(due, func(i, j int) bool { return due[i].ID < due[j].ID })
+
+	return due
+}
+
+func (s *Scheduler) list() []Entry {
+	list := make([]Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	return list
+}
+
+// Snapshot returns the entries as they are persisted, for the backups.
+func (s *Scheduler) Snapshot() (backup.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(s.list(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return backup.Bytes(data), nil
+}
+
+// Writes to a temporary file then renames it, the file is never left half written.
+func (s *Scheduler) save() error {
+	if len(s.path) == 0 {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(s.path, data)
+}
@@ -0,0 +1,93 @@
+package schedule
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedulerAdd(t *testing.T) {
+	s, err := NewScheduler("", nil)
+	require.NoError(t, err)
+
+	require.Error(t, s.Add(Entry{ID: "hb", Cron: "@every 1m", Topic: "heartbeat/+"}))
+	require.Error(t, s.Add(Entry{ID: "hb", Cron: "@every 1m", Topic: "heartbeat", Qos: 3}))
+	require.Error(t, s.Add(Entry{ID: "hb", Cron: "every minute", Topic: "heartbeat"}))
+	require.Error(t, s.Add(Entry{Cron: "@every 1m", Topic: "heartbeat"}))
+
+	require.NoError(t, s.Add(Entry{ID: "hb", Cron: "@every 1m", Topic: "heartbeat"}))
+	require.NoError(t, s.Add(Entry{ID: "hb", Cron: "@every 2m", Topic: "heartbeat"}))
+	require.Len(t, s.Entries(), 1)
+	require.Equal(t, "@every 2m", s.Entries()[0].Cron)
+
+	require.NoError(t, s.Remove("hb"))
+	require.Error(t, s.Remove("hb"))
+	require.Len(t, s.Entries(), 0)
+}
+
+func TestSchedulerDue(t *testing.T) {
+	s, err := NewScheduler("", nil)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Add(Entry{ID: "hb", Cron: "@every 1s", Topic: "heartbeat"}))
+	require.NoError(t, s.Add(Entry{ID: "refresh", Cron: "@every 1h", Topic: "config/refresh"}))
+
+	now := time.Now()
+	require.Len(t, s.Due(now), 0)
+
+	due := s.Due(now.Add(2 * time.Second))
+	require.Len(t, due, 1)
+	require.Equal(t, "hb", due[0].ID)
+
+	// moved to the next activation
+	require.Len(t, s.Due(now.Add(2*time.Second)), 0)
+}
+
+func TestSchedulerMockClock(t *testing.T) {
+	m := clock.NewMock(time.Date(2020, 3, 20, 10, 0, 30, 0, time.UTC))
+	s, err := NewScheduler("", m)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Add(Entry{ID: "hb", Cron: "*/5 * * * *", Topic: "heartbeat"}))
+	require.Equal(t, time.Date(2020, 3, 20, 10, 5, 0, 0, time.UTC), s.Entries()[0].Next())
+
+	m.Add(4 * time.Minute)
+	require.Len(t, s.Due(m.Now()), 0)
+	m.Add(time.Minute)
+	require.Len(t, s.Due(m.Now()), 1)
+}
+
+func TestSchedulerPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "schedule")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "schedule.json")
+
+	s, err := NewScheduler(path, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.Add(Entry{ID: "hb", Cron: "*/5 * * * *", Topic: "heartbeat", Payload: []byte("alive"), Qos: 1, Retain: true}))
+	require.NoError(t, s.Add(Entry{ID: "refresh", Cron: "@daily", Topic: "config/refresh"}))
+	require.NoError(t, s.Remove("refresh"))
+
+	s, err = NewScheduler(path, nil)
+	require.NoError(t, err)
+
+	entries := s.Entries()
+	require.Len(t, entries, 1)
+	require.Equal(t, "hb", entries[0].ID)
+	require.Equal(t, []byte("alive"), entries[0].Payload)
+	require.Equal(t, byte(1), entries[0].Qos)
+	require.True(t, entries[0].Retain)
+	require.False(t, entries[0].Next().IsZero())
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("not json"), 0600))
+	_, err = NewScheduler(path, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,54 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+func init() {
+	Register(KindJSONSchema, newJSONSchema)
+}
+
+// jsonSchema checks the payloads against a compiled JSON Schema, the compiled schema is safe for
+// concurrent use.
+type jsonSchema struct {
+	schema *jsonschema.Schema
+}
+
+// newJSONSchema compiles the inline schema of the binding, or the one of its file. The $ref of
+// the schema may point to the files next to it.
+func newJSONSchema(b Binding) (Validator, error) {
+	var (
+		s   *jsonschema.Schema
+		err error
+	)
+	switch {
+	case len(b.Schema) > 0:
+		s, err = jsonschema.CompileString("schema.json", b.Schema)
+	case len(b.File) > 0:
+		s, err = jsonschema.Compile(b.File)
+	default:
+		return nil, errors.New("schema/jsonschema: the binding has no schema nor file")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &jsonSchema{schema: s}, nil
+}
+
+func (j *jsonSchema) Validate(payload []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("schema/jsonschema: trailing data after the JSON value")
+	}
+	return j.schema.Validate(v)
+}
@@ -0,0 +1,44 @@
+package schema
+
+import (
+	"errors"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+func init() {
+	Register(KindProtobuf, newProtobuf)
+}
+
+// protobufMessage checks the payloads are the wire format of a Protobuf message, with its required
+// fields set. The message type is looked up in the global registry, where the generated code of
+// the message registers it once its package is linked in the broker.
+type protobufMessage struct {
+	messageType protoreflect.MessageType
+}
+
+func newProtobuf(b Binding) (Validator, error) {
+	if len(b.Message) == 0 {
+		return nil, errors.New("schema/protobuf: the binding has no message")
+	}
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(b.Message))
+	if err != nil {
+		return nil, err
+	}
+	return &protobufMessage{messageType: mt}, nil
+}
+
+// Validate decodes the payload, the unknown fields are refused since they're most likely garbage
+// read as fields.
+func (p *protobufMessage) Validate(payload []byte) error {
+	m := p.messageType.New().Interface()
+	if err := proto.Unmarshal(payload, m); err != nil {
+		return err
+	}
+	if len(m.ProtoReflect().GetUnknown()) > 0 {
+		return errors.New("schema/protobuf: the payload has unknown fields")
+	}
+	return nil
+}
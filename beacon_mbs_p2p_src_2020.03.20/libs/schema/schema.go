@@ -0,0 +1,156 @@
+// Package schema validates the payloads of the publishes against the schema bound to the prefix of
+// their topic, so the garbage published by a buggy device never reaches the consumers. The
+// validators are registered by kind like the compression codecs: a JSON Schema, and a Protobuf
+// message type registered by the generated code linked in the broker, are registered by default.
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// The kinds of the validators registered by default.
+const (
+	// KindJSONSchema validates the JSON payloads against the JSON Schema of the binding
+	KindJSONSchema = "json_schema"
+	// KindProtobuf validates the payloads as the wire format of the Protobuf message of the binding
+	KindProtobuf = "protobuf"
+)
+
+// ErrInvalid is the error of a payload not matching the schema of its topic.
+var ErrInvalid = errors.New("schema: the payload doesn't match the schema of the topic")
+
+var (
+	factories = make(map[string]Factory)
+)
+
+// Validator checks a payload, it must be safe for concurrent use.
+type Validator interface {
+	Validate(payload []byte) error
+}
+
+// Factory returns the validator of the binding.
+type Factory func(b Binding) (Validator, error)
+
+// Register makes a kind of validator available by the provided name.
+// If a Register is called twice with the same name or if the factory is nil,
+// it panics.
+func Register(kind string, f Factory) {
+	if f == nil {
+		panic("schema: Register factory is nil")
+	}
+
+	if _, dup := factories[kind]; dup {
+		panic("schema: Register called twice for kind " + kind)
+	}
+
+	factories[kind] = f
+}
+
+func Unregister(kind string) {
+	delete(factories, kind)
+}
+
+// Binding binds the topics starting with Prefix to a schema of the kind: the JSON Schema document of
+// Schema, or of the file at File, or the full name of the Protobuf Message.
+type Binding struct {
+	Prefix  string `json:"prefix" yaml:"prefix"`
+	Kind    string `json:"kind" yaml:"kind"`
+	Schema  string `json:"schema" yaml:"schema"`
+	File    string `json:"file" yaml:"file"`
+	Message string `json:"message" yaml:"message"`
+}
+
+// Stats are the counters of the payloads of a binding.
+type Stats struct {
+	Prefix    string `json:"prefix"`
+	Kind      string `json:"kind"`
+	Validated uint64 `json:"validated"`
+	Invalid   uint64 `json:"invalid"`
+}
+
+// bound is a binding with its validator and its counters.
+type bound struct {
+	Binding
+	validator Validator
+
+	validated uint64
+	invalid   uint64
+}
+
+// Set validates the payloads with the binding of the longest prefix of their topic.
+type Set struct {
+	// The bindings in the order of the config, and by the longest prefix first
+	bindings []*bound
+	byPrefix []*bound
+}
+
+// New returns the set of the bindings, their kinds must be registered.
+func New(bindings []Binding) (*Set, error) {
+	s := &Set{}
+	prefixes := make(map[string]bool, len(bindings))
+	for _, b := range bindings {
+		if len(b.Prefix) == 0 {
+			return nil, errors.New("schema/New: a binding has no prefix")
+		}
+		if prefixes[b.Prefix] {
+			return nil, fmt.Errorf("schema/New: the prefix %q has two bindings", b.Prefix)
+		}
+		prefixes[b.Prefix] = true
+
+		f, ok := factories[b.Kind]
+		if !ok {
+			return nil, fmt.Errorf("schema: unknown kind %q", b.Kind)
+		}
+		v, err := f(b)
+		if err != nil {
+			return nil, fmt.Errorf("schema/New: the schema of the prefix %q => %v", b.Prefix, err)
+		}
+		s.bindings = append(s.bindings, &bound{Binding: b, validator: v})
+	}
+	s.byPrefix = append([]*bound(nil), s.bindings...)
+	sort.SliceStable(s.byPrefix, func(i, j int) bool {
+		return len(s.byPrefix[i].Prefix) > len(s.byPrefix[j].Prefix)
+	})
+	return s, nil
+}
+
+// Validate checks the payload against the schema of the topic, the error wraps ErrInvalid. The
+// topics bound to no schema are valid.
+func (s *Set) Validate(topic string, payload []byte) error {
+	if s == nil {
+		return nil
+	}
+	for _, b := range s.byPrefix {
+		if !strings.HasPrefix(topic, b.Prefix) {
+			continue
+		}
+		atomic.AddUint64(&b.validated, 1)
+		if err := b.validator.Validate(payload); err != nil {
+			atomic.AddUint64(&b.invalid, 1)
+			return fmt.Errorf("%w: %s %s => %v", ErrInvalid, b.Kind, b.Prefix, err)
+		}
+		return nil
+	}
+	return nil
+}
+
+// Stats returns the counters of the bindings, in the order of the config.
+func (s *Set) Stats() []Stats {
+	if s == nil {
+		return nil
+	}
+	list := make([]Stats, 0, len(s.bindings))
+	for _, b := range s.bindings {
+		list = append(list, Stats{
+			Prefix:    b.Prefix,
+			Kind:      b.Kind,
+			Validated: atomic.LoadUint64(&b.validated),
+			Invalid:   atomic.LoadUint64(&b.invalid),
+		})
+	}
+	return list
+}
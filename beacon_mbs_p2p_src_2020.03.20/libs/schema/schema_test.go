@@ -0,0 +1,71 @@
+package schema
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const readingSchema = `{
+	"type": "object",
+	"properties": {"temp": {"type": "number"}, "unit": {"enum": ["C", "F"]}},
+	"required": ["temp"]
+}`
+
+func TestJSONSchema(t *testing.T) {
+	s, err := New([]Binding{
+		{Prefix: "sensors/", Kind: KindJSONSchema, Schema: readingSchema},
+		{Prefix: "sensors/raw/", Kind: KindJSONSchema, Schema: `{"type": "string"}`},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, s.Validate("sensors/1", []byte(`{"temp": 21.5, "unit": "C"}`)))
+	require.True(t, errors.Is(s.Validate("sensors/1", []byte(`{"unit": "C"}`)), ErrInvalid))
+	require.True(t, errors.Is(s.Validate("sensors/1", []byte(`{"temp": "hot"}`)), ErrInvalid))
+	require.True(t, errors.Is(s.Validate("sensors/1", []byte(`{"temp": 1} garbage`)), ErrInvalid))
+	require.True(t, errors.Is(s.Validate("sensors/1", []byte{0xff, 0x00}), ErrInvalid))
+
+	// the longest prefix wins, the topics bound to no schema are valid
+	require.NoError(t, s.Validate("sensors/raw/1", []byte(`"21.5"`)))
+	require.NoError(t, s.Validate("other", []byte{0xff}))
+
+	stats := s.Stats()
+	require.Len(t, stats, 2)
+	require.Equal(t, Stats{Prefix: "sensors/", Kind: KindJSONSchema, Validated: 5, Invalid: 4}, stats[0])
+	require.EqualValues(t, 1, stats[1].Validated)
+
+	var none *Set
+	require.NoError(t, none.Validate("sensors/1", nil))
+}
+
+func TestProtobuf(t *testing.T) {
+	_, err := New([]Binding{{Prefix: "pb/", Kind: KindProtobuf, Message: "acme.Missing"}})
+	require.Error(t, err)
+
+	s, err := New([]Binding{{Prefix: "pb/", Kind: KindProtobuf, Message: "google.protobuf.Duration"}})
+	require.NoError(t, err)
+
+	payload, err := proto.Marshal(durationpb.New(3 * time.Second))
+	require.NoError(t, err)
+	require.NoError(t, s.Validate("pb/uptime", payload))
+	require.True(t, errors.Is(s.Validate("pb/uptime", []byte{0xff, 0xff}), ErrInvalid))
+	// a field number unknown to the message
+	require.True(t, errors.Is(s.Validate("pb/uptime", []byte{0x28, 0x01}), ErrInvalid))
+}
+
+func TestBindings(t *testing.T) {
+	_, err := New([]Binding{{Kind: KindJSONSchema, Schema: `{}`}})
+	require.Error(t, err)
+	_, err = New([]Binding{{Prefix: "a/", Kind: "avro"}})
+	require.Error(t, err)
+	_, err = New([]Binding{{Prefix: "a/", Kind: KindJSONSchema}})
+	require.Error(t, err)
+	_, err = New([]Binding{{Prefix: "a/", Kind: KindJSONSchema, Schema: `{`}})
+	require.Error(t, err)
+	_, err = New([]Binding{{Prefix: "a/", Kind: KindJSONSchema, Schema: `{}`}, {Prefix: "a/", Kind: KindJSONSchema, Schema: `{}`}})
+	require.Error(t, err)
+}
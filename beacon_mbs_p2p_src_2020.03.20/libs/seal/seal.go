@@ -0,0 +1,96 @@
+// Package seal encrypts and authenticates the messages with a key derived from a pre-shared
+// secret: the messages between the peer nodes, so a node without the secret of the cluster can
+// neither read nor inject them, and the payloads of the sensitive topics, which the nodes without
+// the secret of the topic forward without reading them.
+package seal
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// MinSecret is the length of the shortest secret accepted.
+const MinSecret = 16
+
+// version starts the sealed messages, a message of another version cannot be opened.
+const version = byte(1)
+
+// Overhead is the length a message grows by once sealed.
+const Overhead = 1 + chacha20poly1305.NonceSizeX + chacha20poly1305.Overhead
+
+var (
+	// ErrShortSecret is returned for a secret shorter than MinSecret
+	ErrShortSecret = fmt.Errorf("seal: the secret must be at least %d bytes", MinSecret)
+	// ErrOpen is returned for a message which is not sealed with the key, or was tampered with
+	ErrOpen = errors.New("seal: the message cannot be opened")
+)
+
+// Sealer seals and opens the messages with the key derived from its secret for a purpose, the same
+// secret gives another key for another purpose. It's safe for concurrent use.
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// New derives the key of the purpose from the secret.
+func New(secret []byte, purpose string) (*Sealer, error) {
+	if len(secret) < MinSecret {
+		return nil, ErrShortSecret
+	}
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte(purpose)), key); err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+// LoadSecret reads the secret of the file, without the spaces and the line breaks around it.
+func LoadSecret(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secret := bytes.TrimSpace(data)
+	if len(secret) < MinSecret {
+		return nil, fmt.Errorf("seal/seal/LoadSecret: %s => %v", path, ErrShortSecret)
+	}
+	return secret, nil
+}
+
+// Seal encrypts the message, ad is authenticated with it but not encrypted, it must be given
+// again to open the message.
+func (s *Sealer) Seal(msg []byte, ad []byte) []byte {
+	out := make([]byte, 1+chacha20poly1305.NonceSizeX, Overhead+len(msg))
+	out[0] = version
+	nonce := out[1:]
+	if _, err := rand.Read(nonce); err != nil {
+		// the random nonces are what keeps the messages apart, there's no sealing without them
+		panic("seal: read random error: " + err.Error())
+	}
+	return s.aead.Seal(out, nonce, msg, ad)
+}
+
+// Open decrypts the sealed message, ErrOpen if it's not sealed with the key and ad.
+func (s *Sealer) Open(sealed []byte, ad []byte) ([]byte, error) {
+	if len(sealed) < Overhead || sealed[0] != version {
+		return nil, ErrOpen
+	}
+	nonce := sealed[1 : 1+chacha20poly1305.NonceSizeX]
+	msg, err := s.aead.Open(nil, nonce, sealed[1+chacha20poly1305.NonceSizeX:], ad)
+	if err != nil {
+		return nil, ErrOpen
+	}
+	return msg, nil
+}
@@ -0,0 +1,88 @@
+package seal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"awesomeProject/beacon/mqtt_network/libs/plugins"
+
+	"github.com/stretchr/testify/require"
+)
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestSealOpen(t *testing.T) {
+	_, err := New([]byte("short"), "p2p")
+	require.Equal(t, ErrShortSecret, err)
+
+	s, err := New(secret, "p2p")
+	require.NoError(t, err)
+	sealed := s.Seal([]byte("hello"), []byte{4})
+	require.Len(t, sealed, len("hello")+Overhead)
+	require.NotEqual(t, sealed, s.Seal([]byte("hello"), []byte{4}))
+
+	msg, err := s.Open(sealed, []byte{4})
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), msg)
+
+	// another ad, another purpose or a flipped bit cannot be opened
+	_, err = s.Open(sealed, []byte{8})
+	require.Equal(t, ErrOpen, err)
+	other, err := New(secret, "bridge")
+	require.NoError(t, err)
+	_, err = other.Open(sealed, []byte{4})
+	require.Equal(t, ErrOpen, err)
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = s.Open(tampered, []byte{4})
+	require.Equal(t, ErrOpen, err)
+	_, err = s.Open(sealed[:Overhead-1], []byte{4})
+	require.Equal(t, ErrOpen, err)
+
+	dir, err := ioutil.TempDir("", "seal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "secret")
+	require.NoError(t, ioutil.WriteFile(path, append(secret, '\n'), 0600))
+	loaded, err := LoadSecret(path)
+	require.NoError(t, err)
+	require.Equal(t, secret, loaded)
+	require.NoError(t, ioutil.WriteFile(path, []byte("short\n"), 0600))
+	_, err = LoadSecret(path)
+	require.Error(t, err)
+}
+
+func TestTopicPlugin(t *testing.T) {
+	_, err := NewTopicPlugin(TopicSecret{Filter: "a/#/b", Secret: secret})
+	require.Error(t, err)
+
+	p, err := NewTopicPlugin(TopicSecret{Filter: "health/#", Secret: secret})
+	require.NoError(t, err)
+	plugins.Register("seal", p)
+	defer plugins.Unregister("seal")
+	ch, err := plugins.NewChain("seal")
+	require.NoError(t, err)
+	require.True(t, ch.HasForward())
+	require.True(t, ch.HasReceive())
+
+	m := &plugins.Message{Topic: "health/p1", Payload: []byte("72bpm")}
+	require.NoError(t, ch.Forward(m))
+	require.False(t, bytes.Contains(m.Payload, []byte("72bpm")))
+	sealed := m.Payload
+
+	require.NoError(t, ch.Receive(m))
+	require.Equal(t, []byte("72bpm"), m.Payload)
+
+	// sealed for another topic
+	require.Error(t, ch.Receive(&plugins.Message{Topic: "health/p2", Payload: sealed}))
+
+	// the other topics go through as they are
+	m = &plugins.Message{Topic: "weather", Payload: []byte("sunny")}
+	require.NoError(t, ch.Forward(m))
+	require.Equal(t, []byte("sunny"), m.Payload)
+	require.NoError(t, ch.Receive(m))
+	require.Equal(t, []byte("sunny"), m.Payload)
+}
@@ -0,0 +1,88 @@
+package seal
+
+import (
+	"fmt"
+
+	"awesomeProject/beacon/mqtt_network/libs/plugins"
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+)
+
+var (
+	_ plugins.ForwardHook = (*TopicPlugin)(nil)
+	_ plugins.ReceiveHook = (*TopicPlugin)(nil)
+)
+
+// TopicSecret is the secret of the topics of a filter, shared by the nodes allowed to read them.
+type TopicSecret struct {
+	Filter string
+	Secret []byte
+}
+
+type topicSealer struct {
+	filter []byte
+	sealer *Sealer
+}
+
+// TopicPlugin seals the payloads of the topics of its filters forwarded to the peer nodes, and
+// opens the ones forwarded by the peers. A node without the plugin, or without the secret of a
+// topic, forwards and delivers its payloads sealed. Register it with plugins.Register and pick it
+// with WithPlugins on each node allowed to read the topics. The retained messages replicated to
+// the peers and the sessions handed over are not forwarded publishes, they're sealed by the peer
+// secret only.
+type TopicPlugin struct {
+	sealers []topicSealer
+}
+
+// NewTopicPlugin checks the filters and derives the key of each one, the first filter matching a
+// topic picks its key.
+func NewTopicPlugin(secrets ...TopicSecret) (*TopicPlugin, error) {
+	p := &TopicPlugin{}
+	for _, s := range secrets {
+		if err := topics.ValidateTopicFilter([]byte(s.Filter)); err != nil {
+			return nil, fmt.Errorf("seal/topics/NewTopicPlugin: %s => %v", s.Filter, err)
+		}
+		sealer, err := New(s.Secret, "topic "+s.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("seal/topics/NewTopicPlugin: %s => %v", s.Filter, err)
+		}
+		p.sealers = append(p.sealers, topicSealer{filter: []byte(s.Filter), sealer: sealer})
+	}
+	return p, nil
+}
+
+// sealer returns the sealer of the first filter matching the topic, nil if none does.
+func (p *TopicPlugin) sealer(topic string) *Sealer {
+	for _, s := range p.sealers {
+		if ok, _ := topics.MatchTopic(s.filter, []byte(topic)); ok {
+			return s.sealer
+		}
+	}
+	return nil
+}
+
+// OnForward seals the payload, the topic is authenticated with it so the payload cannot be
+// replayed to another topic.
+func (p *TopicPlugin) OnForward(m *plugins.Message) error {
+	if s := p.sealer(m.Topic); s != nil {
+		m.Payload = s.Seal(m.Payload, []byte(m.Topic))
+	}
+	return nil
+}
+
+// OnReceive opens the payload, a payload which cannot be opened is dropped.
+func (p *TopicPlugin) OnReceive(m *plugins.Message) error {
+	s := p.sealer(m.Topic)
+	if s == nil {
+		return nil
+	}
+	payload, err := s.Open(m.Payload, []byte(m.Topic))
+	if err != nil {
+		return fmt.Errorf("seal/topics/OnReceive: %s => %v", m.Topic, err)
+	}
+	m.Payload = payload
+	return nil
+}
+
+func (p *TopicPlugin) Close() error {
+	return nil
+}
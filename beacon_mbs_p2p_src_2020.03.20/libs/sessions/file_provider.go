@@ -0,0 +1,294 @@
+package sessions
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"awesomeProject/beacon/mqtt_network/libs/atomicfile"
+	"awesomeProject/beacon/mqtt_network/libs/storecheck"
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+)
+
+var _ TheSessionsProvider = (*fileProvider)(nil)
+var _ storecheck.Checker = (*fileProvider)(nil)
+
+const (
+	sessionFileExt = ".json"
+	// the session files which don't decode are renamed with the extension by the repair
+	quarantineExt = ".quarantined"
+)
+
+// RegisterFileSessionProvider registers the provider persisting the sessions to the directory as
+// "file", the sessions found in it are loaded. The files which don't decode are left to the
+// consistency check.
+func RegisterFileSessionProvider(dir string) error {
+	p, err := NewFileProvider(dir)
+	if err != nil {
+		return err
+	}
+	Register("file", p)
+	return nil
+}
+
+func UnRegisterFileSessionProvider() {
+	Unregister("file")
+}
+
+// fileProvider keeps the sessions in memory like the mem provider, and writes each session to a
+// JSON file of the directory when it's saved, so the persistent sessions survive a restart. A
+// session is only written by Save, the clean sessions never are.
+type fileProvider struct {
+	mu      sync.RWMutex
+	dir     string
+	sessMap map[string]*Session
+
+	// the saves are serialized, an older state never replaces a newer one
+	saveMu sync.Mutex
+
+	// the errors of the session files which didn't decode by file name, until they're quarantined
+	broken map[string]error
+}
+
+func NewFileProvider(dir string) (*fileProvider, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	p := &fileProvider{
+		dir:     dir,
+		sessMap: make(map[string]*Session),
+		broken:  make(map[string]error),
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), sessionFileExt) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var r sessionRecord
+		if err := json.Unmarshal(data, &r); err != nil {
+			p.broken[fi.Name()] = err
+			continue
+		}
+		if filepath.Base(p.path(r.ID)) != fi.Name() {
+			p.broken[fi.Name()] = fmt.Errorf("the file holds the session of %q", r.ID)
+			continue
+		}
+		p.sessMap[r.ID] = restoreSession(r)
+	}
+	return p, nil
+}
+
+// The client ids may hold any character, the file names are encoded.
+func (p *fileProvider) path(id string) string {
+	return filepath.Join(p.dir, base64.RawURLEncoding.EncodeToString([]byte(id))+sessionFileExt)
+}
+
+func (p *fileProvider) New(id string) (*Session, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sessMap[id] = &Session{id: id}
+	return p.sessMap[id], nil
+}
+
+func (p *fileProvider) Get(id string) (*Session, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	sess, ok := p.sessMap[id]
+	if !ok {
+		return nil, fmt.Errorf("sessions/file_provider/Get: No session found for key %s", id)
+	}
+
+	return sess, nil
+}
+
+// Del removes the session and its file, after the save in progress so it's not written back.
+func (p *fileProvider) Del(id string) {
+	p.saveMu.Lock()
+	defer p.saveMu.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.sessMap, id)
+	_ = os.Remove(p.path(id))
+}
+
+// Save writes the session to its file, through a temporary file synced before it's renamed so a
+// crash never leaves it half written.
+func (p *fileProvider) Save(id string) error {
+	p.saveMu.Lock()
+	defer p.saveMu.Unlock()
+
+	// looked up under saveMu, a session deleted meanwhile is not written back
+	p.mu.RLock()
+	sess, ok := p.sessMap[id]
+	p.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("sessions/file_provider/Save: No session found for key %s", id)
+	}
+
+	return p.write(sess.record())
+}
+
+// write writes the record to the file of its session, saveMu is held by the caller.
+func (p *fileProvider) write(r sessionRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(p.path(r.ID), data)
+}
+
+// CheckConsistency verifies the session files. The files which didn't decode are quarantined, the
+// subscriptions which can't be subscribed again and the inflight messages no acknowledgement can
+// match are removed from their session. Nothing is changed unless repair is set.
+func (p *fileProvider) CheckConsistency(repair bool) (*storecheck.Report, error) {
+	report := storecheck.NewReport("sessions/file")
+	action := storecheck.ActionReported
+	if repair {
+		action = storecheck.ActionRepaired
+	}
+
+	p.saveMu.Lock()
+	defer p.saveMu.Unlock()
+
+	names := make([]string, 0, len(p.broken))
+	for name := range p.broken {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		report.Checked++
+		detail := p.broken[name].Error()
+		if !repair {
+			report.Add(name, storecheck.KindUndecodable, storecheck.ActionReported, detail)
+			continue
+		}
+		path := filepath.Join(p.dir, name)
+		if err := os.Rename(path, path+quarantineExt); err != nil {
+			return nil, err
+		}
+		delete(p.broken, name)
+		report.Add(name, storecheck.KindUndecodable, storecheck.ActionQuarantined, detail)
+	}
+
+	p.mu.RLock()
+	ids := make([]string, 0, len(p.sessMap))
+	for id := range p.sessMap {
+		ids = append(ids, id)
+	}
+	p.mu.RUnlock()
+	sort.Strings(ids)
+	for _, id := range ids {
+		p.mu.RLock()
+		sess, ok := p.sessMap[id]
+		p.mu.RUnlock()
+		if !ok {
+			continue
+		}
+
+		report.Checked++
+		r := sess.record()
+		if !checkRecord(&r, report, action) || !repair {
+			continue
+		}
+		sess.mu.Lock()
+		sess.load(r)
+		sess.mu.Unlock()
+		if err := p.write(r); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// checkRecord reports the dangling subscriptions of the record, their filter or their QoS is
+// invalid, and its orphaned inflight messages, without a valid QoS or packet id. It removes them
+// from the record and reports whether it did.
+func checkRecord(r *sessionRecord, report *storecheck.Report, action string) bool {
+	changed := false
+
+	filters := make([]string, 0, len(r.Topics))
+	for filter := range r.Topics {
+		filters = append(filters, filter)
+	}
+	sort.Strings(filters)
+	for _, filter := range filters {
+		err := topics.ValidateTopicFilter([]byte(filter))
+		if qos := r.Topics[filter]; err == nil && !topics.ValidQos(qos) {
+			err = fmt.Errorf("invalid QoS %d", qos)
+		}
+		if err != nil {
+			report.Add(r.ID+"/"+filter, storecheck.KindDanglingSubscription, action, err.Error())
+			delete(r.Topics, filter)
+			changed = true
+		}
+	}
+
+	seen := make(map[uint16]bool, len(r.Inflight))
+	inflight := r.Inflight[:0]
+	for _, m := range r.Inflight {
+		detail := ""
+		switch {
+		case m.Qos != topics.QosAtLeastOnce && m.Qos != topics.QosExactlyOnce:
+			detail = fmt.Sprintf("invalid QoS %d", m.Qos)
+		case m.MessageID == 0:
+			detail = "no packet id"
+		case seen[m.MessageID]:
+			detail = fmt.Sprintf("packet id %d used twice", m.MessageID)
+		}
+		if len(detail) > 0 {
+			report.Add(fmt.Sprintf("%s/inflight/%d", r.ID, m.MessageID), storecheck.KindOrphanedInflight, action, detail)
+			changed = true
+			continue
+		}
+		seen[m.MessageID] = true
+		inflight = append(inflight, m)
+	}
+	r.Inflight = inflight
+
+	return changed
+}
+
+func (p *fileProvider) Count() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return len(p.sessMap)
+}
+
+func (p *fileProvider) IDs() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	ids := make([]string, 0, len(p.sessMap))
+	for id := range p.sessMap {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (p *fileProvider) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sessMap = make(map[string]*Session)
+	return nil
+}
@@ -0,0 +1,128 @@
+package sessions
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"awesomeProject/beacon/mqtt_network/libs/storecheck"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, err := NewFileProvider(dir)
+	require.NoError(t, err)
+
+	connect := newConnectMessage()
+	connect.ClientIdentifier = "devices/d1"
+	connect.CleanSession = false
+	sess, err := p.New(connect.ClientIdentifier)
+	require.NoError(t, err)
+	require.NoError(t, sess.Initialize(connect))
+	require.NoError(t, sess.AddTopic("devices/d1/cmd/#", 1))
+	sess.AddInflight(Message{Filter: "devices/d1/cmd/#", Topic: "devices/d1/cmd/reboot", Qos: 1, MessageID: 3, Payload: []byte("now")})
+	sess.Enqueue(Message{Filter: "devices/d1/cmd/#", Topic: "devices/d1/cmd/update", Qos: 2, Payload: []byte("v2")}, 0)
+	require.NoError(t, p.Save(connect.ClientIdentifier))
+
+	// a clean session is never written
+	_, err = p.New("clean")
+	require.NoError(t, err)
+
+	// after a restart
+	p, err = NewFileProvider(dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"devices/d1"}, p.IDs())
+
+	restored, err := p.Get("devices/d1")
+	require.NoError(t, err)
+	require.Equal(t, "devices/d1", restored.ID())
+	require.False(t, restored.CleanSession())
+	filters, qosList, err := restored.Topics()
+	require.NoError(t, err)
+	require.Equal(t, []string{"devices/d1/cmd/#"}, filters)
+	require.Equal(t, []byte{1}, qosList)
+
+	inflight := restored.Inflight()
+	require.Len(t, inflight, 1)
+	require.Equal(t, uint16(3), inflight[0].MessageID)
+	require.Equal(t, []byte("now"), inflight[0].Payload)
+	queue := restored.TakeQueue()
+	require.Len(t, queue, 1)
+	require.Equal(t, "devices/d1/cmd/update", queue[0].Topic)
+
+	p.Del("devices/d1")
+	p, err = NewFileProvider(dir)
+	require.NoError(t, err)
+	require.Equal(t, 0, p.Count())
+}
+
+func TestFileProviderCheckConsistency(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, err := NewFileProvider(dir)
+	require.NoError(t, err)
+	connect := newConnectMessage()
+	connect.ClientIdentifier = "d1"
+	connect.CleanSession = false
+	sess, err := p.New("d1")
+	require.NoError(t, err)
+	require.NoError(t, sess.Initialize(connect))
+	require.NoError(t, sess.AddTopic("d1/cmd/#", 1))
+	require.NoError(t, sess.AddTopic("d1/#/bad", 1))
+	sess.AddInflight(
+		Message{Filter: "d1/cmd/#", Topic: "d1/cmd/a", Qos: 1, MessageID: 1},
+		Message{Filter: "d1/cmd/#", Topic: "d1/cmd/b", Qos: 1, MessageID: 1},
+		Message{Filter: "d1/cmd/#", Topic: "d1/cmd/c", Qos: 0, MessageID: 2},
+	)
+	require.NoError(t, p.Save("d1"))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0600))
+
+	// the broken file doesn't stop the restart, the check reports it
+	p, err = NewFileProvider(dir)
+	require.NoError(t, err)
+	report, err := p.CheckConsistency(false)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Checked)
+	kinds := make([]string, 0, len(report.Issues))
+	for _, issue := range report.Issues {
+		require.Equal(t, storecheck.ActionReported, issue.Action)
+		kinds = append(kinds, issue.Kind)
+	}
+	require.Equal(t, []string{
+		storecheck.KindUndecodable,
+		storecheck.KindDanglingSubscription,
+		storecheck.KindOrphanedInflight,
+		storecheck.KindOrphanedInflight,
+	}, kinds)
+
+	report, err = p.CheckConsistency(true)
+	require.NoError(t, err)
+	require.Len(t, report.Issues, 4)
+	require.Equal(t, storecheck.ActionQuarantined, report.Issues[0].Action)
+	require.Equal(t, storecheck.ActionRepaired, report.Issues[1].Action)
+	_, err = os.Stat(filepath.Join(dir, "broken.json"+quarantineExt))
+	require.NoError(t, err)
+
+	// the repaired session is written back
+	p, err = NewFileProvider(dir)
+	require.NoError(t, err)
+	report, err = p.CheckConsistency(false)
+	require.NoError(t, err)
+	require.True(t, report.Clean())
+	restored, err := p.Get("d1")
+	require.NoError(t, err)
+	filters, _, err := restored.Topics()
+	require.NoError(t, err)
+	require.Equal(t, []string{"d1/cmd/#"}, filters)
+	inflight := restored.Inflight()
+	require.Len(t, inflight, 1)
+	require.Equal(t, "d1/cmd/a", inflight[0].Topic)
+}
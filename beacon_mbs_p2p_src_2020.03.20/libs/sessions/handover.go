@@ -0,0 +1,36 @@
+package sessions
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Export returns the state of the session as it's persisted, so another broker takes the session
+// over with Import.
+func (s *Session) Export() ([]byte, error) {
+	return json.Marshal(s.record())
+}
+
+// Import adds the session of the client exported by another broker, replacing the session of the
+// same id. The session is saved, its client hasn't connected yet.
+func (m *Manager) Import(id string, data []byte) (*Session, error) {
+	var r sessionRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("sessions/handover/Import: invalid session => %v", err)
+	}
+	if len(r.ID) == 0 || r.ID != id {
+		return nil, fmt.Errorf("sessions/handover/Import: the session is not the one of %s", id)
+	}
+
+	s, err := m.New(r.ID)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.load(r)
+	s.mu.Unlock()
+	if err := m.Save(r.ID); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
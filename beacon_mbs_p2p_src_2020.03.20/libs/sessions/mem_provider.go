@@ -57,10 +57,27 @@ func (m *memProvider) Save(id string) error {
 }
 
 func (m *memProvider) Count() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	return len(m.sessMap)
 }
 
+func (m *memProvider) IDs() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make([]string, 0, len(m.sessMap))
+	for id := range m.sessMap {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
 func (m *memProvider) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	m.sessMap = make(map[string]*Session)
 	return nil
 }
@@ -0,0 +1,207 @@
+package sessions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"awesomeProject/beacon/mqtt_network/libs/atomicfile"
+	"awesomeProject/beacon/mqtt_network/libs/backup"
+)
+
+// packetIDLease is the number of packet ids issued between two writes of the store.
+const packetIDLease = 1024
+
+var ErrNoPacketID = errors.New("sessions: all the packet ids are inflight")
+
+// PacketIDs issues the packet ids of the messages sent by the broker to a client, skipping the
+// ones still inflight. The persisted allocators lease the ids by blocks: the end of the current
+// block is written to the store before its first id is issued, and an allocator restored after a
+// restart starts after it, so it never issues again an id the client may still hold inflight.
+type PacketIDs struct {
+	mu       sync.Mutex
+	store    *PacketIDStore
+	clientID string
+	next     uint16
+	leased   int
+	inflight map[uint16]struct{}
+}
+
+// NewPacketIDs returns an allocator which is not persisted, for the clean sessions.
+func NewPacketIDs() *PacketIDs {
+	return &PacketIDs{
+		next:     1,
+		leased:   -1,
+		inflight: make(map[uint16]struct{}),
+	}
+}
+
+// Next returns a packet id which is not inflight, and marks it inflight until it's released.
+func (p *PacketIDs) Next() (uint16, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.inflight) >= 0xFFFF {
+		return 0, ErrNoPacketID
+	}
+
+	for {
+		id := p.next
+		p.next++
+		if p.next == 0 {
+			p.next = 1
+		}
+		if _, ok := p.inflight[id]; ok {
+			continue
+		}
+
+		if p.leased == 0 {
+			if err := p.store.save(p.clientID, advance(id, packetIDLease)); err != nil {
+				p.next = id
+				return 0, err
+			}
+			p.leased = packetIDLease
+		}
+		if p.leased > 0 {
+			p.leased--
+		}
+
+		p.inflight[id] = struct{}{}
+		return id, nil
+	}
+}
+
+// Reserve marks the id inflight, for the messages of a restored session sent again with their id.
+func (p *PacketIDs) Reserve(id uint16) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.inflight[id] = struct{}{}
+}
+
+// Release frees the id once its flow is completed (PUBACK, PUBCOMP).
+func (p *PacketIDs) Release(id uint16) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.inflight, id)
+}
+
+func (p *PacketIDs) Inflight() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.inflight)
+}
+
+// advance returns the id n ids after the id, 0 is not a packet id.
+func advance(id uint16, n int) uint16 {
+	v := (int(id)-1+n)%0xFFFF + 1
+	return uint16(v)
+}
+
+// PacketIDStore persists where the allocators of the persistent sessions resume, by client id. It
+// is written to a JSON file (if the path is not empty) each time an allocator leases a new block.
+type PacketIDStore struct {
+	mu    sync.Mutex
+	path  string
+	marks map[string]uint16
+}
+
+// NewPacketIDStore loads the store persisted in the file, a missing file is an empty store.
+func NewPacketIDStore(path string) (*PacketIDStore, error) {
+	s := &PacketIDStore{
+		path:  path,
+		marks: make(map[string]uint16),
+	}
+
+	if len(path) == 0 {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &s.marks); err != nil {
+		return nil, fmt.Errorf("sessions/packet_id/NewPacketIDStore: invalid packet id file %s => %v", path, err)
+	}
+	return s, nil
+}
+
+// Allocator returns the allocator of the persistent session of the client, resuming after the
+// last block leased to it.
+func (s *PacketIDStore) Allocator(clientID string) *PacketIDs {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := NewPacketIDs()
+	p.store = s
+	p.clientID = clientID
+	p.leased = 0
+	if mark, ok := s.marks[clientID]; ok && mark != 0 {
+		p.next = mark
+	}
+	return p
+}
+
+// Delete forgets the client, its session is clean.
+func (s *PacketIDStore) Delete(clientID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.marks[clientID]; !ok {
+		return nil
+	}
+	delete(s.marks, clientID)
+	return s.write()
+}
+
+func (s *PacketIDStore) save(clientID string, mark uint16) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, oldExist := s.marks[clientID]
+	s.marks[clientID] = mark
+	if err := s.write(); err != nil {
+		if oldExist {
+			s.marks[clientID] = old
+		} else {
+			delete(s.marks, clientID)
+		}
+		return err
+	}
+	return nil
+}
+
+// Snapshot returns the leased blocks as they are persisted, for the backups.
+func (s *PacketIDStore) Snapshot() (backup.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.Marshal(s.marks)
+	if err != nil {
+		return nil, err
+	}
+	return backup.Bytes(data), nil
+}
+
+func (s *PacketIDStore) write() error {
+	if len(s.path) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(s.marks)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(s.path, data)
+}
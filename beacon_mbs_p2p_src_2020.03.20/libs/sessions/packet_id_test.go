@@ -0,0 +1,88 @@
+package sessions
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacketIDsSkipInflight(t *testing.T) {
+	p := NewPacketIDs()
+	p.next = 0xFFFE
+
+	id, err := p.Next()
+	require.NoError(t, err)
+	require.Equal(t, uint16(0xFFFE), id)
+	id, err = p.Next()
+	require.NoError(t, err)
+	require.Equal(t, uint16(0xFFFF), id)
+	// 0 is skipped on the wrap
+	id, err = p.Next()
+	require.NoError(t, err)
+	require.Equal(t, uint16(1), id)
+
+	p.Release(0xFFFF)
+	p.next = 0xFFFE
+	id, err = p.Next()
+	require.NoError(t, err)
+	require.Equal(t, uint16(0xFFFF), id)
+	require.Equal(t, 3, p.Inflight())
+
+	p = NewPacketIDs()
+	for i := 0; i < 0xFFFF; i++ {
+		_, err = p.Next()
+		require.NoError(t, err)
+	}
+	_, err = p.Next()
+	require.Equal(t, ErrNoPacketID, err)
+}
+
+func TestPacketIDStoreResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "packet_ids.json")
+
+	s, err := NewPacketIDStore(path)
+	require.NoError(t, err)
+	p := s.Allocator("c1")
+	for i := 1; i <= 10; i++ {
+		id, err := p.Next()
+		require.NoError(t, err)
+		require.Equal(t, uint16(i), id)
+	}
+
+	// restarted, the ids issued before are not issued again
+	s, err = NewPacketIDStore(path)
+	require.NoError(t, err)
+	p = s.Allocator("c1")
+	id, err := p.Next()
+	require.NoError(t, err)
+	require.Equal(t, uint16(1+packetIDLease), id)
+
+	for i := 1; i < packetIDLease; i++ {
+		_, err = p.Next()
+		require.NoError(t, err)
+	}
+	s, err = NewPacketIDStore(path)
+	require.NoError(t, err)
+	id, err = s.Allocator("c1").Next()
+	require.NoError(t, err)
+	require.Equal(t, uint16(1+2*packetIDLease), id)
+
+	require.NoError(t, s.Delete("c1"))
+	s, err = NewPacketIDStore(path)
+	require.NoError(t, err)
+	id, err = s.Allocator("c1").Next()
+	require.NoError(t, err)
+	require.Equal(t, uint16(1), id)
+}
+
+func TestPacketIDAdvance(t *testing.T) {
+	require.Equal(t, uint16(1025), advance(1, 1024))
+	require.Equal(t, uint16(1), advance(0xFFFF, 1))
+	require.Equal(t, uint16(1024), advance(0xFFFF, 1024))
+}
@@ -0,0 +1,57 @@
+package sessions
+
+import "sort"
+
+// The QoS 2 publishes received from the client go through these states, by packet id: the first
+// PUBLISH is delivered and its id is recorded, the PUBREC is sent; the PUBLISH sent again with the
+// same id is a duplicate, it's acknowledged again but not delivered; the PUBREL forgets the id, the
+// PUBCOMP completes the flow and the id may carry a new message. The ids are persisted with the
+// session, so a duplicate is still detected by a broker restarted meanwhile.
+
+// ReceiveQos2 records the packet id of a QoS 2 publish, it returns false if the id is already
+// recorded, the publish is a duplicate which is not delivered again.
+func (s *Session) ReceiveQos2(id uint16) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.received[id]; ok {
+		return false
+	}
+	if s.received == nil {
+		s.received = make(map[uint16]struct{})
+	}
+	s.received[id] = struct{}{}
+	return true
+}
+
+// ReleaseQos2 forgets the packet id on the PUBREL, it returns false if the id was not recorded.
+func (s *Session) ReleaseQos2(id uint16) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.received[id]; !ok {
+		return false
+	}
+	delete(s.received, id)
+	return true
+}
+
+// ReceivedQos2 returns the packet ids of the QoS 2 publishes waiting for their PUBREL, in order.
+func (s *Session) ReceivedQos2() []uint16 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.receivedList()
+}
+
+func (s *Session) receivedList() []uint16 {
+	if len(s.received) == 0 {
+		return nil
+	}
+	ids := make([]uint16, 0, len(s.received))
+	for id := range s.received {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
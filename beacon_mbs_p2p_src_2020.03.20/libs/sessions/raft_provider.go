@@ -0,0 +1,218 @@
+package sessions
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"awesomeProject/beacon/mqtt_network/libs/raftrepl"
+)
+
+// raftKind is the kind of the commands of the provider in the replicated log.
+const raftKind = "sessions"
+
+var (
+	_ TheSessionsProvider = (*raftProvider)(nil)
+	_ raftrepl.Applier    = (*raftProvider)(nil)
+)
+
+// raftProvider keeps the sessions in memory like the mem provider, and commits each session
+// through the replicated log when it's saved, so the standby node holds the persistent sessions
+// when it takes over. The sessions created on this node are live, the committed state of the
+// others replaces their copy here.
+type raftProvider struct {
+	mu      sync.RWMutex
+	fsm     *raftrepl.FSM
+	sessMap map[string]*Session
+	live    map[string]bool
+	// The sessions saved once at least, the clean ones are never replicated
+	committed map[string]bool
+
+	// the saves are serialized, an older state is never committed after a newer one
+	saveMu sync.Mutex
+}
+
+// raftCommand is a command of the provider, the record of a saved session or the id of a deleted
+// one.
+type raftCommand struct {
+	Save *sessionRecord `json:"save,omitempty"`
+	Del  string         `json:"del,omitempty"`
+}
+
+// RegisterRaftSessionProvider registers the provider committing through the log of the FSM as
+// "raft".
+func RegisterRaftSessionProvider(fsm *raftrepl.FSM) {
+	Register("raft", NewRaftProvider(fsm))
+}
+
+func UnRegisterRaftSessionProvider() {
+	Unregister("raft")
+}
+
+func NewRaftProvider(fsm *raftrepl.FSM) *raftProvider {
+	p := &raftProvider{
+		fsm:       fsm,
+		sessMap:   make(map[string]*Session),
+		live:      make(map[string]bool),
+		committed: make(map[string]bool),
+	}
+	fsm.Register(raftKind, p)
+	return p
+}
+
+func (p *raftProvider) commit(cmd raftCommand) error {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	return p.fsm.Commit(raftKind, data)
+}
+
+func (p *raftProvider) New(id string) (*Session, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sessMap[id] = &Session{id: id}
+	p.live[id] = true
+	return p.sessMap[id], nil
+}
+
+func (p *raftProvider) Get(id string) (*Session, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	sess, ok := p.sessMap[id]
+	if !ok {
+		return nil, fmt.Errorf("sessions/raft_provider/Get: No session found for key %s", id)
+	}
+
+	return sess, nil
+}
+
+// Del removes the session here, and from the other nodes once the deletion is committed; only the
+// leader commits.
+func (p *raftProvider) Del(id string) {
+	p.mu.Lock()
+	delete(p.sessMap, id)
+	delete(p.live, id)
+	p.mu.Unlock()
+
+	_ = p.commit(raftCommand{Del: id})
+}
+
+// Save commits the state of the session, the clean sessions are never saved.
+func (p *raftProvider) Save(id string) error {
+	p.mu.RLock()
+	sess, ok := p.sessMap[id]
+	p.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("sessions/raft_provider/Save: No session found for key %s", id)
+	}
+
+	p.saveMu.Lock()
+	defer p.saveMu.Unlock()
+
+	r := sess.record()
+	if err := p.commit(raftCommand{Save: &r}); err != nil {
+		return fmt.Errorf("sessions/raft_provider/Save: commit error: %v", err)
+	}
+	return nil
+}
+
+func (p *raftProvider) Count() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return len(p.sessMap)
+}
+
+func (p *raftProvider) IDs() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	ids := make([]string, 0, len(p.sessMap))
+	for id := range p.sessMap {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (p *raftProvider) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sessMap = make(map[string]*Session)
+	p.live = make(map[string]bool)
+	p.committed = make(map[string]bool)
+	return nil
+}
+
+// Apply applies a committed command, the session of a live one is the source of the command, it's
+// kept.
+func (p *raftProvider) Apply(data []byte) error {
+	var cmd raftCommand
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return fmt.Errorf("sessions/raft_provider/Apply: invalid command => %v", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case cmd.Save != nil:
+		p.committed[cmd.Save.ID] = true
+		if !p.live[cmd.Save.ID] {
+			p.sessMap[cmd.Save.ID] = restoreSession(*cmd.Save)
+		}
+	case len(cmd.Del) > 0:
+		delete(p.sessMap, cmd.Del)
+		delete(p.live, cmd.Del)
+		delete(p.committed, cmd.Del)
+	}
+	return nil
+}
+
+// Snapshot returns the records of the committed sessions, the live ones as they are now.
+func (p *raftProvider) Snapshot() ([]byte, error) {
+	p.mu.RLock()
+	sessions := make([]*Session, 0, len(p.committed))
+	for id := range p.committed {
+		if s, ok := p.sessMap[id]; ok {
+			sessions = append(sessions, s)
+		}
+	}
+	p.mu.RUnlock()
+
+	records := make([]sessionRecord, 0, len(sessions))
+	for _, s := range sessions {
+		records = append(records, s.record())
+	}
+	return json.Marshal(records)
+}
+
+// Restore replaces the sessions with the ones of the snapshot, the live ones are kept.
+func (p *raftProvider) Restore(data []byte) error {
+	var records []sessionRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("sessions/raft_provider/Restore: invalid snapshot => %v", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sessMap := make(map[string]*Session, len(records))
+	for id, s := range p.sessMap {
+		if p.live[id] {
+			sessMap[id] = s
+		}
+	}
+	committed := make(map[string]bool, len(records))
+	for _, r := range records {
+		committed[r.ID] = true
+		if !p.live[r.ID] {
+			sessMap[r.ID] = restoreSession(r)
+		}
+	}
+	p.sessMap, p.committed = sessMap, committed
+	return nil
+}
@@ -0,0 +1,81 @@
+package sessions
+
+import (
+	"bytes"
+	"testing"
+
+	"awesomeProject/beacon/mqtt_network/libs/raftrepl"
+
+	"github.com/stretchr/testify/require"
+)
+
+// pairLog applies the commands to the FSM of the active node and of the standby, like the log of
+// an HA pair.
+type pairLog struct {
+	active, standby *raftrepl.FSM
+}
+
+func (l *pairLog) Commit(kind string, cmd []byte) error {
+	data, err := raftrepl.Encode(kind, cmd)
+	if err != nil {
+		return err
+	}
+	if err := l.active.Apply(data); err != nil {
+		return err
+	}
+	return l.standby.Apply(data)
+}
+
+func (l *pairLog) Leader() bool {
+	return true
+}
+
+func TestRaftProvider(t *testing.T) {
+	log := &pairLog{active: raftrepl.NewFSM(), standby: raftrepl.NewFSM()}
+	log.active.SetLog(log)
+	log.standby.SetLog(log)
+	active := NewRaftProvider(log.active)
+	standby := NewRaftProvider(log.standby)
+
+	connect := newConnectMessage()
+	connect.ClientIdentifier = "devices/d1"
+	connect.CleanSession = false
+	sess, err := active.New(connect.ClientIdentifier)
+	require.NoError(t, err)
+	require.NoError(t, sess.Initialize(connect))
+	require.NoError(t, sess.AddTopic("devices/d1/cmd/#", 1))
+	sess.Enqueue(Message{Filter: "devices/d1/cmd/#", Topic: "devices/d1/cmd/update", Qos: 1, Payload: []byte("v2")}, 0)
+	require.NoError(t, active.Save(connect.ClientIdentifier))
+
+	// a clean session is never replicated
+	_, err = active.New("clean")
+	require.NoError(t, err)
+
+	// the active session is the source, it's kept
+	kept, err := active.Get("devices/d1")
+	require.NoError(t, err)
+	require.True(t, kept == sess)
+
+	replica, err := standby.Get("devices/d1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"devices/d1"}, standby.IDs())
+	topics, qoss, err := replica.Topics()
+	require.NoError(t, err)
+	require.Equal(t, []string{"devices/d1/cmd/#"}, topics)
+	require.Equal(t, []byte{1}, qoss)
+	require.Equal(t, 1, replica.Queued())
+
+	// a new node restores the snapshot of the active one
+	var buf bytes.Buffer
+	require.NoError(t, log.active.WriteSnapshot(&buf))
+	fsm := raftrepl.NewFSM()
+	joined := NewRaftProvider(fsm)
+	raftrepl.NewLocalLog(fsm)
+	require.NoError(t, fsm.RestoreSnapshot(&buf))
+	require.Equal(t, []string{"devices/d1"}, joined.IDs())
+
+	active.Del("devices/d1")
+	_, err = standby.Get("devices/d1")
+	require.Error(t, err)
+	require.Equal(t, 0, standby.Count())
+}
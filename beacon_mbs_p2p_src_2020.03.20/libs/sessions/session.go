@@ -12,6 +12,32 @@ const (
 	defaultQueueSize = 16
 )
 
+// Message is a QoS 1 or 2 message of a persistent session, queued while its client is offline or
+// left inflight by its last connection.
+type Message struct {
+	// Filter is the subscription of the session the message matched
+	Filter    string `json:"filter"`
+	Topic     string `json:"topic"`
+	Qos       byte   `json:"qos"`
+	MessageID uint16 `json:"message_id,omitempty"`
+	Payload   []byte `json:"payload"`
+	// Codec compressed the payload, it's empty if the payload is not compressed
+	Codec string `json:"codec,omitempty"`
+	// Released is set once the client has received the QoS 2 message (PUBREC), the PUBREL is sent
+	// again instead of the message
+	Released bool `json:"released,omitempty"`
+}
+
+// sessionRecord is the persisted state of a session.
+type sessionRecord struct {
+	ID       string          `json:"id"`
+	Topics   map[string]byte `json:"topics"`
+	Inflight []Message       `json:"inflight,omitempty"`
+	Queue    []Message       `json:"queue,omitempty"`
+	// Received holds the packet ids of the QoS 2 publishes waiting for their PUBREL
+	Received []uint16 `json:"received,omitempty"`
+}
+
 type Session struct {
 	// connectMessage is the CONNECT message
 	connectMessage *packets.ConnectPacket
@@ -25,6 +51,17 @@ type Session struct {
 	// topics stores all the topics for this session/client
 	topics map[string]byte
 
+	// PacketIDs issues the packet ids of the messages sent to the client, it lasts as long as the
+	// session
+	PacketIDs *PacketIDs
+
+	// The messages unacknowledged by the last connection, and the ones queued since
+	inflight []Message
+	queue    []Message
+
+	// The packet ids of the QoS 2 publishes received from the client, until their PUBREL
+	received map[uint16]struct{}
+
 	initialized bool
 
 	// Serialize access to this session
@@ -121,7 +158,12 @@ func (s *Session) Topics() ([]string, []byte, error) {
 	return topicList, qosList, nil
 }
 
+// ID returns the client id, a session restored from the disk has no CONNECT until its client
+// connects again.
 func (s *Session) ID() string {
+	if s.connectMessage == nil {
+		return s.id
+	}
 	return s.connectMessage.ClientIdentifier
 }
 
@@ -129,19 +171,132 @@ func (s *Session) WillFlag() bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	return s.connectMessage.WillFlag
+	return s.connectMessage != nil && s.connectMessage.WillFlag
 }
 
 func (s *Session) SetWillFlag(v bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.connectMessage.WillFlag = v
+	if s.connectMessage != nil {
+		s.connectMessage.WillFlag = v
+	}
 }
 
 func (s *Session) CleanSession() bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	return s.connectMessage.CleanSession
+	return s.connectMessage != nil && s.connectMessage.CleanSession
+}
+
+// Enqueue queues the message for the offline client. The oldest message is dropped, and true is
+// returned, if the queue already holds max messages, max 0 is unlimited.
+func (s *Session) Enqueue(m Message, max int) bool {
+	_, dropped := s.Push(m, max)
+	return dropped
+}
+
+// Push queues the message like Enqueue, and returns the oldest message if it was dropped.
+func (s *Session) Push(m Message, max int) (Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var oldest Message
+	dropped := false
+	if max > 0 && len(s.queue) >= max {
+		oldest = s.queue[0]
+		copy(s.queue, s.queue[1:])
+		s.queue = s.queue[:len(s.queue)-1]
+		dropped = true
+	}
+	s.queue = append(s.queue, m)
+	return oldest, dropped
+}
+
+// TakeQueue returns the queued messages in their order, and empties the queue.
+func (s *Session) TakeQueue() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue := s.queue
+	s.queue = nil
+	return queue
+}
+
+func (s *Session) Queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.queue)
+}
+
+// AddInflight keeps the messages the closed connection has left unacknowledged, they are sent
+// again to the next one.
+func (s *Session) AddInflight(messages ...Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inflight = append(s.inflight, messages...)
+}
+
+// Inflight returns a copy of the unacknowledged messages.
+func (s *Session) Inflight() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Message(nil), s.inflight...)
+}
+
+// TakeInflight returns the unacknowledged messages, and forgets them.
+func (s *Session) TakeInflight() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inflight := s.inflight
+	s.inflight = nil
+	return inflight
+}
+
+func (s *Session) record() sessionRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := sessionRecord{
+		ID:       s.ID(),
+		Topics:   make(map[string]byte, len(s.topics)),
+		Inflight: append([]Message(nil), s.inflight...),
+		Queue:    append([]Message(nil), s.queue...),
+		Received: s.receivedList(),
+	}
+	for k, v := range s.topics {
+		r.Topics[k] = v
+	}
+	return r
+}
+
+// restoreSession returns the session of the record, its client hasn't connected yet.
+func restoreSession(r sessionRecord) *Session {
+	s := &Session{}
+	s.load(r)
+	return s
+}
+
+// load sets the state of the record, the lock is held by the caller if the session is shared.
+func (s *Session) load(r sessionRecord) {
+	s.id = r.ID
+	s.topics = r.Topics
+	s.inflight = r.Inflight
+	s.queue = r.Queue
+	s.received = nil
+	s.initialized = true
+	if s.topics == nil {
+		s.topics = make(map[string]byte)
+	}
+	if len(r.Received) > 0 {
+		s.received = make(map[uint16]struct{}, len(r.Received))
+		for _, id := range r.Received {
+			s.received[id] = struct{}{}
+		}
+	}
 }
@@ -5,6 +5,8 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+
+	"awesomeProject/beacon/mqtt_network/libs/storecheck"
 )
 
 var (
@@ -20,6 +22,7 @@ type TheSessionsProvider interface {
 	Del(id string)
 	Save(id string) error
 	Count() int
+	IDs() []string
 	Close() error
 }
 
@@ -78,6 +81,20 @@ func (m *Manager) Count() int {
 	return m.tsp.Count()
 }
 
+// IDs returns the client ids of the sessions.
+func (m *Manager) IDs() []string {
+	return m.tsp.IDs()
+}
+
+// CheckConsistency checks the persisted state of the provider, a nil report is returned
+// if the provider persists nothing.
+func (m *Manager) CheckConsistency(repair bool) (*storecheck.Report, error) {
+	if c, ok := m.tsp.(storecheck.Checker); ok {
+		return c.CheckConsistency(repair)
+	}
+	return nil, nil
+}
+
 func (m *Manager) Close() error {
 	return m.tsp.Close()
 }
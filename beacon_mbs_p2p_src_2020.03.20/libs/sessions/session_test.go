@@ -1,6 +1,8 @@
 package sessions
 
 import (
+	"strconv"
+	"sync"
 	"testing"
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
@@ -54,6 +56,33 @@ func TestSessionRetainMessage(t *testing.T) {
 	require.Equal(t, sess.Retained, msg)
 }
 
+func TestSessionQueue(t *testing.T) {
+	sess := &Session{}
+	require.NoError(t, sess.Initialize(newConnectMessage()))
+
+	require.False(t, sess.Enqueue(Message{Topic: "a/1", Qos: 1}, 2))
+	require.False(t, sess.Enqueue(Message{Topic: "a/2", Qos: 1}, 2))
+	// the oldest one is dropped
+	require.True(t, sess.Enqueue(Message{Topic: "a/3", Qos: 2}, 2))
+	require.Equal(t, 2, sess.Queued())
+	oldest, dropped := sess.Push(Message{Topic: "a/4", Qos: 1}, 3)
+	require.False(t, dropped)
+	oldest, dropped = sess.Push(Message{Topic: "a/5", Qos: 1}, 3)
+	require.True(t, dropped)
+	require.Equal(t, "a/2", oldest.Topic)
+
+	queue := sess.TakeQueue()
+	require.Len(t, queue, 3)
+	require.Equal(t, "a/3", queue[0].Topic)
+	require.Equal(t, "a/5", queue[2].Topic)
+	require.Equal(t, 0, sess.Queued())
+
+	sess.AddInflight(Message{Topic: "a/4", Qos: 2, MessageID: 7, Released: true})
+	require.Len(t, sess.Inflight(), 1)
+	require.Len(t, sess.TakeInflight(), 1)
+	require.Empty(t, sess.Inflight())
+}
+
 func newConnectMessage() *packets.ConnectPacket {
 	msg := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
 	msg.WillQos = 1
@@ -77,3 +106,92 @@ func newPublishMessage(pktId uint16, qos byte) *packets.PublishPacket {
 	msg.Payload = []byte("abc")
 	return msg
 }
+
+func TestSessionQos2(t *testing.T) {
+	sess := &Session{}
+	require.NoError(t, sess.Initialize(newConnectMessage()))
+
+	require.True(t, sess.ReceiveQos2(7))
+	require.True(t, sess.ReceiveQos2(3))
+	// the PUBLISH sent again before the PUBREL
+	require.False(t, sess.ReceiveQos2(7))
+	require.Equal(t, []uint16{3, 7}, sess.ReceivedQos2())
+
+	// a restarted broker still knows the duplicates
+	restored := restoreSession(sess.record())
+	require.False(t, restored.ReceiveQos2(3))
+	require.True(t, restored.ReleaseQos2(3))
+	require.False(t, restored.ReleaseQos2(3))
+	require.Equal(t, []uint16{7}, restored.ReceivedQos2())
+
+	// the id carries a new message once released
+	require.True(t, restored.ReceiveQos2(3))
+}
+
+func TestSessionHandover(t *testing.T) {
+	sess := &Session{}
+	require.NoError(t, sess.Initialize(newConnectMessage()))
+	require.NoError(t, sess.AddTopic("a/+", 1))
+	require.NoError(t, sess.AddTopic("b/#", 2))
+	sess.Enqueue(Message{Filter: "a/+", Topic: "a/1", Qos: 1, Payload: []byte("queued")}, 10)
+	sess.AddInflight(Message{Filter: "b/#", Topic: "b/1", Qos: 2, MessageID: 7, Released: true})
+	require.True(t, sess.ReceiveQos2(9))
+
+	data, err := sess.Export()
+	require.NoError(t, err)
+
+	m := &Manager{tsp: NewMemProvider()}
+	_, err = m.Import("another", data)
+	require.Error(t, err)
+	got, err := m.Import(sess.ID(), data)
+	require.NoError(t, err)
+	require.Equal(t, sess.ID(), got.ID())
+
+	stored, err := m.Get(sess.ID())
+	require.NoError(t, err)
+	require.True(t, stored == got)
+
+	topicList, qosList, err := got.Topics()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(topicList))
+	for i, topic := range topicList {
+		require.Equal(t, sess.topics[topic], qosList[i])
+	}
+	require.Equal(t, sess.Inflight(), got.Inflight())
+	require.Equal(t, []uint16{9}, got.ReceivedQos2())
+	require.Equal(t, 1, got.Queued())
+	require.Equal(t, "queued", string(got.TakeQueue()[0].Payload))
+
+	// the connection of the client resumes the imported session
+	require.Error(t, got.Initialize(newConnectMessage()))
+	require.NoError(t, got.Update(newConnectMessage()))
+
+	_, err = m.Import("", []byte(`{"topics":{}}`))
+	require.Error(t, err)
+	_, err = m.Import(sess.ID(), []byte(`{`))
+	require.Error(t, err)
+}
+
+func TestMemProviderCount(t *testing.T) {
+	p := NewMemProvider()
+
+	// the $SYS statistics count the sessions while the clients connect
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_, _ = p.New(strconv.Itoa(i))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		require.True(t, p.Count() <= 100)
+	}
+	wg.Wait()
+	require.Equal(t, 100, p.Count())
+
+	p.Del("0")
+	require.Equal(t, 99, p.Count())
+	require.NoError(t, p.Close())
+	require.Equal(t, 0, p.Count())
+}
@@ -0,0 +1,310 @@
+package simulate
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	mrand "math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/latency"
+	"awesomeProject/beacon/mqtt_network/libs/mqttclient"
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+)
+
+const latencySamples = 4096
+
+// Report is the outcome of a run. Expected counts, for each successful publish, the subscribers
+// connected and matching its topic at that time, so a subscriber churned while the message was in
+// flight may count as a drop.
+type Report struct {
+	Elapsed       time.Duration   `json:"elapsed"`
+	Published     uint64          `json:"published"`
+	PublishErrors uint64          `json:"publish_errors"`
+	Expected      uint64          `json:"expected"`
+	Received      uint64          `json:"received"`
+	Dropped       uint64          `json:"dropped"`
+	Reconnects    uint64          `json:"reconnects"`
+	PublishRate   float64         `json:"publish_rate"` // messages per second
+	ReceiveRate   float64         `json:"receive_rate"`
+	Latency       latency.Summary `json:"latency"`
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("elapsed %s, published %d (%.1f/s, %d errors), received %d/%d (%.1f/s, %d dropped), reconnects %d, latency p50 %s p90 %s p99 %s max %s",
+		r.Elapsed, r.Published, r.PublishRate, r.PublishErrors,
+		r.Received, r.Expected, r.ReceiveRate, r.Dropped, r.Reconnects,
+		r.Latency.P50, r.Latency.P90, r.Latency.P99, r.Latency.Max,
+	)
+}
+
+type simSubscriber struct {
+	client  *mqttclient.Client
+	filters []string
+	ready   int32
+	active  int32
+}
+
+// The client restores the subscriptions before calling OnConnect, so the subscriber is active
+// again only once it can receive.
+func (s *simSubscriber) onConnect(*mqttclient.Client) {
+	if atomic.LoadInt32(&s.ready) == 1 {
+		atomic.StoreInt32(&s.active, 1)
+	}
+}
+
+func (s *simSubscriber) matches(topic string) bool {
+	if atomic.LoadInt32(&s.active) == 0 {
+		return false
+	}
+	for _, filter := range s.filters {
+		if ok, _ := topics.MatchTopic([]byte(filter), []byte(topic)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type runner struct {
+	scenario *Scenario
+	runID    []byte
+
+	published     uint64
+	publishErrors uint64
+	expected      uint64
+	received      uint64
+	reconnects    uint64
+
+	latency     *latency.Recorder
+	subscribers []*simSubscriber
+	publishers  []*mqttclient.Client
+}
+
+// Run connects the clients of the scenario, publishes for its duration (or until the context is
+// done), and reports the metrics.
+func Run(ctx context.Context, scenario *Scenario) (*Report, error) {
+	if err := scenario.Validate(); err != nil {
+		return nil, err
+	}
+
+	r := &runner{
+		scenario: scenario,
+		runID:    make([]byte, 8),
+		latency:  latency.NewRecorder(latencySamples),
+	}
+	if _, err := rand.Read(r.runID); err != nil {
+		return nil, err
+	}
+	defer r.close()
+
+	if err := r.connect(); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-time.After(time.Duration(scenario.Warmup)):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(scenario.Duration))
+	defer cancel()
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for g, p := range scenario.Publishers {
+		for i := 0; i < p.Count; i++ {
+			wg.Add(1)
+			go func(c *mqttclient.Client, p Publishers, id int, seed int64) {
+				defer wg.Done()
+				r.publish(ctx, c, p, id, seed)
+			}(r.publishers[r.publisherIndex(g, i)], p, i, start.UnixNano()+int64(g*1000+i))
+		}
+	}
+
+	if scenario.Churn != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.churn(ctx)
+		}()
+	}
+
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	// The messages in flight are given a moment to arrive.
+	time.Sleep(time.Second)
+
+	report := &Report{
+		Elapsed:       elapsed,
+		Published:     atomic.LoadUint64(&r.published),
+		PublishErrors: atomic.LoadUint64(&r.publishErrors),
+		Expected:      atomic.LoadUint64(&r.expected),
+		Received:      atomic.LoadUint64(&r.received),
+		Reconnects:    atomic.LoadUint64(&r.reconnects),
+		Latency:       r.latency.Summary(),
+	}
+	if report.Expected > report.Received {
+		report.Dropped = report.Expected - report.Received
+	}
+	if s := elapsed.Seconds(); s > 0 {
+		report.PublishRate = float64(report.Published) / s
+		report.ReceiveRate = float64(report.Received) / s
+	}
+
+	return report, nil
+}
+
+func (r *runner) publisherIndex(group int, i int) int {
+	n := 0
+	for g := 0; g < group; g++ {
+		n += r.scenario.Publishers[g].Count
+	}
+	return n + i
+}
+
+func (r *runner) newClient(kind string, group int, i int, onConnect func(*mqttclient.Client)) (*mqttclient.Client, error) {
+	return mqttclient.New(mqttclient.Options{
+		Brokers:      []string{r.scenario.Broker},
+		ClientID:     fmt.Sprintf("sim-%s-%s-%d-%d", hex.EncodeToString(r.runID[:4]), kind, group, i),
+		CleanSession: true,
+		OnConnect:    onConnect,
+	})
+}
+
+func (r *runner) connect() error {
+	for g, sub := range r.scenario.Subscribers {
+		for i := 0; i < sub.Count; i++ {
+			s := &simSubscriber{}
+			c, err := r.newClient("sub", g, i, s.onConnect)
+			if err != nil {
+				return err
+			}
+			s.client = c
+			r.subscribers = append(r.subscribers, s)
+
+			if err := c.Connect(); err != nil {
+				return fmt.Errorf("simulate/runner/connect: connect subscriber error => %v", err)
+			}
+
+			for _, f := range sub.Filters {
+				filter := expand(f, i)
+				if err := c.Subscribe(filter, sub.Qos, r.onMessage); err != nil {
+					return fmt.Errorf("simulate/runner/connect: subscribe %s error => %v", filter, err)
+				}
+				s.filters = append(s.filters, filter)
+			}
+			atomic.StoreInt32(&s.ready, 1)
+			atomic.StoreInt32(&s.active, 1)
+		}
+	}
+
+	for g, p := range r.scenario.Publishers {
+		for i := 0; i < p.Count; i++ {
+			c, err := r.newClient("pub", g, i, nil)
+			if err != nil {
+				return err
+			}
+			r.publishers = append(r.publishers, c)
+
+			if err := c.Connect(); err != nil {
+				return fmt.Errorf("simulate/runner/connect: connect publisher error => %v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *runner) close() {
+	for _, s := range r.subscribers {
+		s.client.Close()
+	}
+	for _, c := range r.publishers {
+		c.Close()
+	}
+}
+
+// The messages of the other runs (or other publishers of the broker) are ignored.
+func (r *runner) onMessage(_ string, payload []byte) {
+	if len(payload) < headerSize || string(payload[:8]) != string(r.runID) {
+		return
+	}
+
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(payload[8:headerSize])))
+	r.latency.Observe(time.Since(sent))
+	atomic.AddUint64(&r.received, 1)
+}
+
+func (r *runner) publish(ctx context.Context, c *mqttclient.Client, p Publishers, id int, seed int64) {
+	rnd := mrand.New(mrand.NewSource(seed))
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / p.Rate))
+	defer ticker.Stop()
+
+	for n := 0; ; n++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		topic := expand(p.Topics[n%len(p.Topics)], id)
+		payload := make([]byte, p.PayloadSize)
+		copy(payload, r.runID)
+		binary.BigEndian.PutUint64(payload[8:headerSize], uint64(time.Now().UnixNano()))
+
+		// Counted before publishing, the message may arrive before Publish returns.
+		expected := uint64(0)
+		for _, s := range r.subscribers {
+			if s.matches(topic) {
+				expected++
+			}
+		}
+
+		if err := c.Publish(topic, pickQos(p.Qos, rnd.Float64()), p.Retain, payload); err != nil {
+			atomic.AddUint64(&r.publishErrors, 1)
+			continue
+		}
+		atomic.AddUint64(&r.published, 1)
+		atomic.AddUint64(&r.expected, expected)
+	}
+}
+
+// The churned subscribers are inactive until their subscriptions are restored, so the messages
+// published meanwhile are not expected. The churned publishers fail to publish meanwhile.
+func (r *runner) churn(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(r.scenario.Churn.Interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, s := range r.subscribers {
+			if mrand.Float64() >= r.scenario.Churn.Fraction {
+				continue
+			}
+			atomic.StoreInt32(&s.active, 0)
+			s.client.Close()
+			_ = s.client.Connect()
+			atomic.AddUint64(&r.reconnects, 1)
+		}
+
+		for _, c := range r.publishers {
+			if mrand.Float64() >= r.scenario.Churn.Fraction {
+				continue
+			}
+			c.Close()
+			_ = c.Connect()
+			atomic.AddUint64(&r.reconnects, 1)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+// Package simulate drives a broker over real sockets from a scenario file (publishers at a rate on
+// some topics, subscribers on some filters, a QoS mix and connection churn), and reports the
+// throughput, the delivery latency and the drops.
+package simulate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+)
+
+const (
+	// The payload carries the run id and the publish time
+	headerSize = 16
+
+	defaultPayloadSize = 64
+)
+
+// Duration is a time.Duration written as a string ("30s", "1m") in the scenario file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	s, err := strconv.Unquote(string(data))
+	if err != nil {
+		return fmt.Errorf("simulate/scenario: duration must be a string such as \"30s\", found %s", data)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(time.Duration(d).String())), nil
+}
+
+// Publishers is a group of identical publishers. In the topics, "{id}" is replaced by the index
+// of the publisher in the group, each publisher goes through its topics in turn.
+type Publishers struct {
+	Count       int       `json:"count"`
+	Rate        float64   `json:"rate"` // messages per second, per publisher
+	Topics      []string  `json:"topics"`
+	Qos         []float64 `json:"qos"` // weights of QoS 0, 1 and 2, QoS 0 only if empty
+	PayloadSize int       `json:"payload_size"`
+	Retain      bool      `json:"retain"`
+}
+
+// Subscribers is a group of identical subscribers, "{id}" in the filters is replaced by the index
+// of the subscriber in the group.
+type Subscribers struct {
+	Count   int      `json:"count"`
+	Filters []string `json:"filters"`
+	Qos     byte     `json:"qos"`
+}
+
+// Churn disconnects and reconnects the fraction of the clients at each interval.
+type Churn struct {
+	Interval Duration `json:"interval"`
+	Fraction float64  `json:"fraction"`
+}
+
+type Scenario struct {
+	Broker      string        `json:"broker"` // such as tcp://127.0.0.1:1883
+	Duration    Duration      `json:"duration"`
+	Warmup      Duration      `json:"warmup"` // time given to the subscribers before publishing
+	Publishers  []Publishers  `json:"publishers"`
+	Subscribers []Subscribers `json:"subscribers"`
+	Churn       *Churn        `json:"churn,omitempty"`
+}
+
+// LoadScenario reads and validates the scenario file.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Scenario
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("simulate/scenario/LoadScenario: invalid scenario file %s => %v", path, err)
+	}
+
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Validate checks the scenario and fills the defaults.
+func (s *Scenario) Validate() error {
+	if len(s.Broker) == 0 {
+		return fmt.Errorf("simulate/scenario/Validate: broker address cannot be empty")
+	}
+	if s.Duration <= 0 {
+		return fmt.Errorf("simulate/scenario/Validate: duration must be positive")
+	}
+	if len(s.Publishers) == 0 {
+		return fmt.Errorf("simulate/scenario/Validate: no publisher")
+	}
+
+	for i := range s.Publishers {
+		p := &s.Publishers[i]
+		if p.Count < 1 || p.Rate <= 0 {
+			return fmt.Errorf("simulate/scenario/Validate: publishers %d need a positive count and rate", i)
+		}
+		if len(p.Topics) == 0 {
+			return fmt.Errorf("simulate/scenario/Validate: publishers %d have no topic", i)
+		}
+		for _, topic := range p.Topics {
+			if len(topic) == 0 || strings.ContainsAny(topic, "+#") {
+				return fmt.Errorf("simulate/scenario/Validate: publish topic %q cannot be empty or contain wildcards", topic)
+			}
+		}
+		if len(p.Qos) == 0 {
+			p.Qos = []float64{1}
+		}
+		if len(p.Qos) > 3 {
+			return fmt.Errorf("simulate/scenario/Validate: publishers %d have more than 3 QoS weights", i)
+		}
+		total := 0.0
+		for _, w := range p.Qos {
+			if w < 0 {
+				return fmt.Errorf("simulate/scenario/Validate: publishers %d have a negative QoS weight", i)
+			}
+			total += w
+		}
+		if total == 0 {
+			return fmt.Errorf("simulate/scenario/Validate: publishers %d have no QoS weight", i)
+		}
+		if p.PayloadSize == 0 {
+			p.PayloadSize = defaultPayloadSize
+		}
+		if p.PayloadSize < headerSize {
+			return fmt.Errorf("simulate/scenario/Validate: payload size cannot be less than %d", headerSize)
+		}
+	}
+
+	for i, sub := range s.Subscribers {
+		if sub.Count < 1 || len(sub.Filters) == 0 {
+			return fmt.Errorf("simulate/scenario/Validate: subscribers %d need a positive count and a filter", i)
+		}
+		if sub.Qos > 2 {
+			return fmt.Errorf("simulate/scenario/Validate: subscribers %d have an invalid QoS %d", i, sub.Qos)
+		}
+		for _, filter := range sub.Filters {
+			if err := checkFilter(filter); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.Churn != nil && (s.Churn.Interval <= 0 || s.Churn.Fraction <= 0 || s.Churn.Fraction > 1) {
+		return fmt.Errorf("simulate/scenario/Validate: churn needs a positive interval and a fraction in (0-1]")
+	}
+
+	return nil
+}
+
+func checkFilter(filter string) error {
+	if len(filter) == 0 {
+		return fmt.Errorf("simulate/scenario/checkFilter: filter cannot be empty")
+	}
+	for rem := []byte(expand(filter, 0)); len(rem) > 0; {
+		var err error
+		if _, rem, err = topics.NextTopicLevel(rem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func expand(template string, id int) string {
+	return strings.Replace(template, "{id}", strconv.Itoa(id), -1)
+}
+
+// pickQos picks the QoS by its weight, r is in [0-1).
+func pickQos(weights []float64, r float64) byte {
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+
+	acc := 0.0
+	for qos, w := range weights {
+		acc += w / total
+		if r < acc {
+			return byte(qos)
+		}
+	}
+
+	// Rounding, the last QoS having a weight
+	for qos := len(weights) - 1; qos > 0; qos-- {
+		if weights[qos] > 0 {
+			return byte(qos)
+		}
+	}
+	return 0
+}
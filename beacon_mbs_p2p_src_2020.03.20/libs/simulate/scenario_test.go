@@ -0,0 +1,83 @@
+package simulate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const scenarioJSON = `{
+	"broker": "tcp://127.0.0.1:1883",
+	"duration": "30s",
+	"warmup": "1s",
+	"publishers": [
+		{"count": 10, "rate": 5, "topics": ["sim/{id}/temp", "sim/{id}/hum"], "qos": [7, 3]}
+	],
+	"subscribers": [
+		{"count": 2, "filters": ["sim/+/temp"], "qos": 1},
+		{"count": 10, "filters": ["sim/{id}/#"]}
+	],
+	"churn": {"interval": "5s", "fraction": 0.1}
+}`
+
+func TestLoadScenario(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simulate")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "scenario.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(scenarioJSON), 0600))
+
+	s, err := LoadScenario(path)
+	require.NoError(t, err)
+	require.Equal(t, Duration(30*time.Second), s.Duration)
+	require.Equal(t, Duration(5*time.Second), s.Churn.Interval)
+	require.Equal(t, defaultPayloadSize, s.Publishers[0].PayloadSize)
+	require.Equal(t, "sim/3/temp", expand(s.Publishers[0].Topics[0], 3))
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"broker": "tcp://127.0.0.1:1883", "duration": 30}`), 0600))
+	_, err = LoadScenario(path)
+	require.Error(t, err)
+}
+
+func TestScenarioValidate(t *testing.T) {
+	valid := func() *Scenario {
+		return &Scenario{
+			Broker:      "tcp://127.0.0.1:1883",
+			Duration:    Duration(time.Second),
+			Publishers:  []Publishers{{Count: 1, Rate: 1, Topics: []string{"sim/{id}"}}},
+			Subscribers: []Subscribers{{Count: 1, Filters: []string{"sim/#"}}},
+		}
+	}
+	require.NoError(t, valid().Validate())
+
+	for _, change := range []func(s *Scenario){
+		func(s *Scenario) { s.Broker = "" },
+		func(s *Scenario) { s.Duration = 0 },
+		func(s *Scenario) { s.Publishers = nil },
+		func(s *Scenario) { s.Publishers[0].Rate = 0 },
+		func(s *Scenario) { s.Publishers[0].Topics = []string{"sim/+"} },
+		func(s *Scenario) { s.Publishers[0].Qos = []float64{0, 0} },
+		func(s *Scenario) { s.Publishers[0].Qos = []float64{1, 1, 1, 1} },
+		func(s *Scenario) { s.Publishers[0].PayloadSize = headerSize - 1 },
+		func(s *Scenario) { s.Subscribers[0].Filters = []string{"sim/#/x"} },
+		func(s *Scenario) { s.Subscribers[0].Qos = 3 },
+		func(s *Scenario) { s.Churn = &Churn{Interval: Duration(time.Second), Fraction: 2} },
+	} {
+		s := valid()
+		change(s)
+		require.Error(t, s.Validate())
+	}
+}
+
+func TestPickQos(t *testing.T) {
+	require.Equal(t, byte(0), pickQos([]float64{1}, 0.99))
+	require.Equal(t, byte(0), pickQos([]float64{7, 3}, 0.69))
+	require.Equal(t, byte(1), pickQos([]float64{7, 3}, 0.7))
+	require.Equal(t, byte(2), pickQos([]float64{0, 0, 1}, 0))
+	require.Equal(t, byte(1), pickQos([]float64{1, 1, 0}, 0.9999999999999999))
+}
@@ -0,0 +1,57 @@
+package statelog
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+const defaultStreamBuffer = 1024
+
+// Handler streams the events after the sequence of the "since" query parameter as JSON lines,
+// then follows the log until the request is gone. A consumer too far behind gets 410 Gone, and
+// should rebuild its mirror of the broker state.
+func Handler(l *Log) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var since uint64
+		if v := r.URL.Query().Get("since"); len(v) > 0 {
+			var err error
+			if since, err = strconv.ParseUint(v, 10, 64); err != nil {
+				http.Error(w, "invalid since", http.StatusBadRequest)
+				return
+			}
+		}
+
+		s, err := l.Follow(since, defaultStreamBuffer)
+		if err == ErrTruncated {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer s.Close()
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		flusher, _ := w.(http.Flusher)
+		enc := json.NewEncoder(w)
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e, ok := <-s.Events():
+				if !ok {
+					return
+				}
+				if err := enc.Encode(e); err != nil {
+					return
+				}
+				// the events already waiting go out with this one
+				if len(s.Events()) == 0 && flusher != nil {
+					flusher.Flush()
+				}
+			}
+		}
+	})
+}
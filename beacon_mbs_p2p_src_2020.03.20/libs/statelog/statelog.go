@@ -0,0 +1,263 @@
+// Package statelog records the state changes of the broker, the subscriptions, the retained
+// messages, the sessions and the config, as an append-only log of events numbered in order. An
+// external system keeps a mirror of the broker state by replaying the log and following it; a
+// consumer which falls behind the kept events resumes from its last sequence number, or rebuilds
+// its mirror.
+package statelog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Kind of a state change.
+type Kind string
+
+const (
+	Subscribed     Kind = "subscribed"
+	Unsubscribed   Kind = "unsubscribed"
+	Retained       Kind = "retained"
+	RetainCleared  Kind = "retain_cleared"
+	SessionCreated Kind = "session_created"
+	SessionResumed Kind = "session_resumed"
+	SessionClosed  Kind = "session_closed"
+	SessionRemoved Kind = "session_removed"
+	ConfigChanged  Kind = "config_changed"
+)
+
+// DefaultSize is the number of the events kept in memory for the consumers catching up.
+const DefaultSize = 10000
+
+var (
+	// ErrLagged closes the stream of a consumer which didn't keep up with the log.
+	ErrLagged = errors.New("statelog: the consumer fell behind the log")
+	// ErrTruncated is returned for a sequence number older than the events kept.
+	ErrTruncated = errors.New("statelog: the events since the sequence are not kept anymore")
+)
+
+// Event is a state change, Seq numbers the events from 1 without gaps.
+type Event struct {
+	Seq      uint64            `json:"seq"`
+	Time     time.Time         `json:"time"`
+	Kind     Kind              `json:"kind"`
+	ClientID string            `json:"client_id,omitempty"`
+	Topic    string            `json:"topic,omitempty"`
+	Qos      byte              `json:"qos,omitempty"`
+	Data     map[string]string `json:"data,omitempty"`
+}
+
+// Config of the log, Size events are kept in memory, DefaultSize if it's 0. The events are also
+// appended to the file at Path, as JSON lines, if it's set; the log goes on from its last event.
+type Config struct {
+	Size int    `json:"size" yaml:"size"`
+	Path string `json:"path" yaml:"path"`
+}
+
+// Log is the log of the state changes. A nil Log records nothing.
+type Log struct {
+	mu      sync.Mutex
+	ring    []Event
+	start   int
+	count   int
+	next    uint64
+	file    *os.File
+	streams map[*Stream]struct{}
+}
+
+// Open opens the log of the config, the events of its file are loaded.
+func Open(cfg Config) (*Log, error) {
+	if cfg.Size < 0 {
+		return nil, errors.New("statelog/statelog/Open: the size cannot be negative")
+	}
+	if cfg.Size == 0 {
+		cfg.Size = DefaultSize
+	}
+	l := &Log{ring: make([]Event, cfg.Size), next: 1, streams: make(map[*Stream]struct{})}
+	if len(cfg.Path) == 0 {
+		return l, nil
+	}
+
+	f, err := os.OpenFile(cfg.Path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("statelog/statelog/Open: open %s => %v", cfg.Path, err)
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for scanner.Scan() {
+		var e Event
+		// a line torn by a crash is the last one, it's skipped
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Seq < l.next {
+			continue
+		}
+		l.push(e)
+		l.next = e.Seq + 1
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("statelog/statelog/Open: read %s => %v", cfg.Path, err)
+	}
+	l.file = f
+	return l, nil
+}
+
+func (l *Log) push(e Event) {
+	i := (l.start + l.count) % len(l.ring)
+	l.ring[i] = e
+	if l.count < len(l.ring) {
+		l.count++
+	} else {
+		l.start = (l.start + 1) % len(l.ring)
+	}
+}
+
+// Append records the state change and returns its sequence number, the consumers following the
+// log get it at once.
+func (l *Log) Append(e Event) uint64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.Seq = l.next
+	l.next++
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	l.push(e)
+	if l.file != nil {
+		if line, err := json.Marshal(e); err == nil {
+			_, _ = l.file.Write(append(line, '\n'))
+		}
+	}
+	for s := range l.streams {
+		s.send(e)
+	}
+	return e.Seq
+}
+
+// Last returns the sequence number of the last event, 0 if there is none.
+func (l *Log) Last() uint64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.next - 1
+}
+
+// Since returns the events after the sequence number, ErrTruncated if some of them are not kept
+// anymore.
+func (l *Log) Since(seq uint64) ([]Event, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.since(seq)
+}
+
+func (l *Log) since(seq uint64) ([]Event, error) {
+	if l.count == 0 || seq >= l.next-1 {
+		return nil, nil
+	}
+	first := l.ring[l.start].Seq
+	if seq+1 < first {
+		return nil, ErrTruncated
+	}
+	skip := int(seq + 1 - first)
+	events := make([]Event, 0, l.count-skip)
+	for i := skip; i < l.count; i++ {
+		events = append(events, l.ring[(l.start+i)%len(l.ring)])
+	}
+	return events, nil
+}
+
+// Follow returns a stream of the events after the sequence number, the events kept first, then
+// the events as they're appended. The stream is closed with ErrLagged if more than buffer events
+// are waiting for the consumer.
+func (l *Log) Follow(seq uint64, buffer int) (*Stream, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	events, err := l.since(seq)
+	if err != nil {
+		return nil, err
+	}
+	if buffer < len(events) {
+		buffer = len(events)
+	}
+	s := &Stream{log: l, c: make(chan Event, buffer+1)}
+	for _, e := range events {
+		s.c <- e
+	}
+	l.streams[s] = struct{}{}
+	return s, nil
+}
+
+// Close closes the file of the log and the streams.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for s := range l.streams {
+		s.end(nil)
+	}
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Stream is a consumer following the log.
+type Stream struct {
+	log  *Log
+	c    chan Event
+	err  error
+	done bool
+}
+
+// Events returns the channel of the events, it's closed when the stream ends.
+func (s *Stream) Events() <-chan Event {
+	return s.c
+}
+
+// Err returns why the stream ended, ErrLagged if the consumer fell behind.
+func (s *Stream) Err() error {
+	s.log.mu.Lock()
+	defer s.log.mu.Unlock()
+	return s.err
+}
+
+// Close stops following the log.
+func (s *Stream) Close() {
+	s.log.mu.Lock()
+	defer s.log.mu.Unlock()
+	s.end(nil)
+}
+
+// send is called with the lock of the log held.
+func (s *Stream) send(e Event) {
+	select {
+	case s.c <- e:
+	default:
+		s.end(ErrLagged)
+	}
+}
+
+// end is called with the lock of the log held.
+func (s *Stream) end(err error) {
+	if s.done {
+		return
+	}
+	s.done = true
+	s.err = err
+	delete(s.log.streams, s)
+	close(s.c)
+}
@@ -0,0 +1,99 @@
+package statelog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statelog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.jsonl")
+
+	l, err := Open(Config{Size: 3, Path: path})
+	require.NoError(t, err)
+	for _, topic := range []string{"a", "b", "c", "d"} {
+		l.Append(Event{Kind: Subscribed, ClientID: "c1", Topic: topic, Qos: 1})
+	}
+	require.EqualValues(t, 4, l.Last())
+
+	events, err := l.Since(2)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, "c", events[0].Topic)
+	_, err = l.Since(0)
+	require.Equal(t, ErrTruncated, err)
+	require.NoError(t, l.Close())
+
+	// the log goes on from the file
+	l, err = Open(Config{Size: 3, Path: path})
+	require.NoError(t, err)
+	defer l.Close()
+	require.EqualValues(t, 4, l.Last())
+	require.EqualValues(t, 5, l.Append(Event{Kind: Unsubscribed, ClientID: "c1", Topic: "a"}))
+
+	var none *Log
+	require.Zero(t, none.Append(Event{Kind: Retained}))
+}
+
+func TestFollow(t *testing.T) {
+	l, err := Open(Config{Size: 10})
+	require.NoError(t, err)
+	l.Append(Event{Kind: SessionCreated, ClientID: "c1"})
+
+	s, err := l.Follow(0, 1)
+	require.NoError(t, err)
+	l.Append(Event{Kind: SessionClosed, ClientID: "c1"})
+	require.Equal(t, SessionCreated, (<-s.Events()).Kind)
+	require.Equal(t, SessionClosed, (<-s.Events()).Kind)
+
+	// a consumer which doesn't keep up is dropped
+	for i := 0; i < 3; i++ {
+		l.Append(Event{Kind: Retained, Topic: "t"})
+	}
+	for range s.Events() {
+	}
+	require.Equal(t, ErrLagged, s.Err())
+}
+
+func TestHandler(t *testing.T) {
+	l, err := Open(Config{Size: 10})
+	require.NoError(t, err)
+	l.Append(Event{Kind: Subscribed, ClientID: "c1", Topic: "a"})
+	l.Append(Event{Kind: Retained, Topic: "a"})
+
+	server := httptest.NewServer(Handler(l))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?since=1")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadBytes('\n')
+	require.NoError(t, err)
+	var e Event
+	require.NoError(t, json.Unmarshal(line, &e))
+	require.EqualValues(t, 2, e.Seq)
+
+	l.Append(Event{Kind: ConfigChanged, Data: map[string]string{"acl": "reloaded"}})
+	line, err = r.ReadBytes('\n')
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(line, &e))
+	require.Equal(t, ConfigChanged, e.Kind)
+
+	bad, err := http.Get(server.URL + "?since=x")
+	require.NoError(t, err)
+	bad.Body.Close()
+	require.Equal(t, http.StatusBadRequest, bad.StatusCode)
+}
@@ -0,0 +1,88 @@
+package storecheck
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+)
+
+const (
+	// The issue kinds found by the consistency check
+	KindChecksum             = "checksum"
+	KindUndecodable          = "undecodable"
+	KindOrphanedInflight     = "orphaned_inflight"
+	KindDanglingSubscription = "dangling_subscription"
+
+	// The actions taken for an issue
+	ActionReported    = "reported"
+	ActionRepaired    = "repaired"
+	ActionQuarantined = "quarantined"
+
+	checksumSize = 4
+)
+
+var (
+	ErrChecksum = errors.New("storecheck: record checksum mismatch")
+	ErrTooShort = errors.New("storecheck: record too short")
+
+	table = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Checker is implemented by the providers persisting their state, the check runs on startup
+// before the broker accepts any connection. If repair is set, the broken records are repaired
+// or quarantined, otherwise they are only reported.
+type Checker interface {
+	CheckConsistency(repair bool) (*Report, error)
+}
+
+type Issue struct {
+	Key    string `json:"key"`
+	Kind   string `json:"kind"`
+	Action string `json:"action"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type Report struct {
+	Store   string  `json:"store"`
+	Checked int     `json:"checked"`
+	Issues  []Issue `json:"issues"`
+}
+
+func NewReport(store string) *Report {
+	return &Report{Store: store, Issues: make([]Issue, 0)}
+}
+
+func (r *Report) Add(key string, kind string, action string, detail string) {
+	r.Issues = append(r.Issues, Issue{Key: key, Kind: kind, Action: action, Detail: detail})
+}
+
+func (r *Report) Clean() bool {
+	return len(r.Issues) == 0
+}
+
+func (r *Report) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// EncodeRecord prefixes the data with its checksum.
+func EncodeRecord(data []byte) []byte {
+	rec := make([]byte, checksumSize+len(data))
+	binary.BigEndian.PutUint32(rec, crc32.Checksum(data, table))
+	copy(rec[checksumSize:], data)
+	return rec
+}
+
+// DecodeRecord verifies the checksum of the record and returns its data.
+func DecodeRecord(rec []byte) ([]byte, error) {
+	if len(rec) < checksumSize {
+		return nil, ErrTooShort
+	}
+
+	data := rec[checksumSize:]
+	if binary.BigEndian.Uint32(rec) != crc32.Checksum(data, table) {
+		return nil, ErrChecksum
+	}
+
+	return data, nil
+}
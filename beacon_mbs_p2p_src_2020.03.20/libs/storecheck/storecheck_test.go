@@ -0,0 +1,36 @@
+package storecheck
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordEncodeDecode(t *testing.T) {
+	rec := EncodeRecord([]byte("sport/tennis"))
+	require.Equal(t, len("sport/tennis")+checksumSize, len(rec))
+
+	data, err := DecodeRecord(rec)
+	require.NoError(t, err)
+	require.Equal(t, "sport/tennis", string(data))
+
+	rec[len(rec)-1] ^= 0xff
+	_, err = DecodeRecord(rec)
+	require.Equal(t, ErrChecksum, err)
+
+	_, err = DecodeRecord([]byte{1, 2})
+	require.Equal(t, ErrTooShort, err)
+}
+
+func TestReport(t *testing.T) {
+	r := NewReport("topics/bolt")
+	require.True(t, r.Clean())
+
+	r.Checked = 2
+	r.Add("sport/tennis", KindChecksum, ActionQuarantined, "")
+	require.False(t, r.Clean())
+
+	data, err := r.Marshal()
+	require.NoError(t, err)
+	require.JSONEq(t, `{"store":"topics/bolt","checked":2,"issues":[{"key":"sport/tennis","kind":"checksum","action":"quarantined"}]}`, string(data))
+}
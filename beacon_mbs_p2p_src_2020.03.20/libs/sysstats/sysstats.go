@@ -0,0 +1,130 @@
+// Package sysstats counts the traffic of the broker and renders its statistics as the $SYS topic
+// tree read by the MQTT dashboards, with the topic names of the other brokers.
+package sysstats
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const Prefix = "$SYS/broker/"
+
+// Counters counts the MQTT packets and bytes of the client connections since the start.
+type Counters struct {
+	messagesReceived uint64
+	messagesSent     uint64
+	publishReceived  uint64
+	publishSent      uint64
+	bytesReceived    uint64
+	bytesSent        uint64
+	subscriptions    int64
+}
+
+func New() *Counters {
+	return &Counters{}
+}
+
+// Received counts a packet read from a client.
+func (c *Counters) Received(bytes int, publish bool) {
+	atomic.AddUint64(&c.messagesReceived, 1)
+	atomic.AddUint64(&c.bytesReceived, uint64(bytes))
+	if publish {
+		atomic.AddUint64(&c.publishReceived, 1)
+	}
+}
+
+// Sent counts a packet written to a client.
+func (c *Counters) Sent(bytes int, publish bool) {
+	atomic.AddUint64(&c.messagesSent, 1)
+	atomic.AddUint64(&c.bytesSent, uint64(bytes))
+	if publish {
+		atomic.AddUint64(&c.publishSent, 1)
+	}
+}
+
+// Subscribed adds n to the subscriptions of the clients, n is negative for the removed ones.
+func (c *Counters) Subscribed(n int) {
+	atomic.AddInt64(&c.subscriptions, int64(n))
+}
+
+// Stats is the state of the broker at a time.
+type Stats struct {
+	Uptime           time.Duration
+	ClientsConnected int
+	ClientsTotal     int
+	Retained         int
+	Subscriptions    int64
+	// TopicNodes are the nodes of the subscription trie, a node for each topic level of the
+	// filters
+	TopicNodes       int
+	MessagesReceived uint64
+	MessagesSent     uint64
+	PublishReceived  uint64
+	PublishSent      uint64
+	BytesReceived    uint64
+	BytesSent        uint64
+}
+
+// Fill sets the counted fields of the stats.
+func (c *Counters) Fill(s *Stats) {
+	s.Subscriptions = atomic.LoadInt64(&c.subscriptions)
+	s.MessagesReceived = atomic.LoadUint64(&c.messagesReceived)
+	s.MessagesSent = atomic.LoadUint64(&c.messagesSent)
+	s.PublishReceived = atomic.LoadUint64(&c.publishReceived)
+	s.PublishSent = atomic.LoadUint64(&c.publishSent)
+	s.BytesReceived = atomic.LoadUint64(&c.bytesReceived)
+	s.BytesSent = atomic.LoadUint64(&c.bytesSent)
+}
+
+type Topic struct {
+	Name  string
+	Value string
+}
+
+// Topics returns the $SYS topics of the stats and their payloads.
+func Topics(s Stats) []Topic {
+	clientsTotal := s.ClientsTotal
+	if clientsTotal < s.ClientsConnected {
+		clientsTotal = s.ClientsConnected
+	}
+
+	return []Topic{
+		{Prefix + "uptime", strconv.FormatInt(int64(s.Uptime/time.Second), 10) + " seconds"},
+		{Prefix + "clients/connected", strconv.Itoa(s.ClientsConnected)},
+		{Prefix + "clients/disconnected", strconv.Itoa(clientsTotal - s.ClientsConnected)},
+		{Prefix + "clients/total", strconv.Itoa(clientsTotal)},
+		{Prefix + "messages/received", strconv.FormatUint(s.MessagesReceived, 10)},
+		{Prefix + "messages/sent", strconv.FormatUint(s.MessagesSent, 10)},
+		{Prefix + "publish/messages/received", strconv.FormatUint(s.PublishReceived, 10)},
+		{Prefix + "publish/messages/sent", strconv.FormatUint(s.PublishSent, 10)},
+		{Prefix + "bytes/received", strconv.FormatUint(s.BytesReceived, 10)},
+		{Prefix + "bytes/sent", strconv.FormatUint(s.BytesSent, 10)},
+		{Prefix + "retained messages/count", strconv.Itoa(s.Retained)},
+		{Prefix + "subscriptions/count", strconv.FormatInt(s.Subscriptions, 10)},
+		{Prefix + "subscriptions/topic nodes", strconv.Itoa(s.TopicNodes)},
+	}
+}
+
+// Tracker keeps the last published payload of each topic, so only the changed ones are published
+// again.
+type Tracker struct {
+	last map[string]string
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{last: make(map[string]string)}
+}
+
+// Changed returns the topics whose payload differs from the last one, and remembers them.
+func (t *Tracker) Changed(topics []Topic) []Topic {
+	var changed []Topic
+	for _, topic := range topics {
+		if last, ok := t.last[topic.Name]; ok && last == topic.Value {
+			continue
+		}
+		t.last[topic.Name] = topic.Value
+		changed = append(changed, topic)
+	}
+	return changed
+}
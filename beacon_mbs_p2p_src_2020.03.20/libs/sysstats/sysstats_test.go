@@ -0,0 +1,42 @@
+package sysstats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopics(t *testing.T) {
+	c := New()
+	c.Received(10, true)
+	c.Received(2, false)
+	c.Sent(12, true)
+	c.Subscribed(3)
+	c.Subscribed(-1)
+
+	s := Stats{Uptime: 90 * time.Second, ClientsConnected: 2, ClientsTotal: 5, Retained: 7, TopicNodes: 4}
+	c.Fill(&s)
+	require.Equal(t, uint64(2), s.MessagesReceived)
+	require.Equal(t, uint64(1), s.PublishReceived)
+	require.Equal(t, uint64(12), s.BytesReceived)
+	require.Equal(t, int64(2), s.Subscriptions)
+
+	values := make(map[string]string)
+	for _, topic := range Topics(s) {
+		values[topic.Name] = topic.Value
+	}
+	require.Equal(t, "90 seconds", values["$SYS/broker/uptime"])
+	require.Equal(t, "3", values["$SYS/broker/clients/disconnected"])
+	require.Equal(t, "7", values["$SYS/broker/retained messages/count"])
+	require.Equal(t, "12", values["$SYS/broker/bytes/sent"])
+	require.Equal(t, "4", values["$SYS/broker/subscriptions/topic nodes"])
+
+	tracker := NewTracker()
+	require.Len(t, tracker.Changed(Topics(s)), len(Topics(s)))
+	require.Empty(t, tracker.Changed(Topics(s)))
+	s.Uptime += time.Minute
+	changed := tracker.Changed(Topics(s))
+	require.Len(t, changed, 1)
+	require.Equal(t, "150 seconds", changed[0].Value)
+}
@@ -0,0 +1,432 @@
+package topiclog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// a record is its body length and the crc32 of its body, then the body: the time, the QoS and
+	// the payload
+	recordHeader = 8
+	recordFixed  = 9
+	// an index entry is the position and the time of a record
+	indexEntry = 16
+
+	logExt   = ".log"
+	indexExt = ".idx"
+)
+
+var errTorn = errors.New("topiclog/segment: torn record")
+
+// segment is a log file and its index, from the base offset. The last segment of a topic is the
+// active one, its files are kept open for the appends.
+type segment struct {
+	base  uint64
+	count uint64
+	size  int64
+	last  time.Time
+
+	log *os.File
+	idx *os.File
+}
+
+// topicLog is the log of a topic, its segments are in their base order.
+type topicLog struct {
+	mu       sync.Mutex
+	dir      string
+	topic    string
+	cfg      Config
+	segments []*segment
+	last     time.Time
+	closed   bool
+}
+
+func segmentName(base uint64, ext string) string {
+	return fmt.Sprintf("%020d%s", base, ext)
+}
+
+func openTopicLog(dir string, topic string, cfg Config) (*topicLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("topiclog/segment/openTopicLog: create %s => %v", dir, err)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("topiclog/segment/openTopicLog: read %s => %v", dir, err)
+	}
+
+	t := &topicLog{dir: dir, topic: topic, cfg: cfg}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, logExt) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, logExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		t.segments = append(t.segments, &segment{base: base, size: e.Size()})
+	}
+	sort.Slice(t.segments, func(i, j int) bool { return t.segments[i].base < t.segments[j].base })
+
+	if len(t.segments) == 0 {
+		t.segments = append(t.segments, &segment{})
+	}
+	for _, s := range t.segments[:len(t.segments)-1] {
+		if err := t.loadIndex(s); err != nil {
+			return nil, err
+		}
+	}
+	active := t.segments[len(t.segments)-1]
+	if err := t.recover(active); err != nil {
+		return nil, err
+	}
+	if err := t.openActive(active); err != nil {
+		return nil, err
+	}
+	t.last = active.last
+	return t, nil
+}
+
+// loadIndex reads the count and the last time of a sealed segment from its index.
+func (t *topicLog) loadIndex(s *segment) error {
+	f, err := os.Open(filepath.Join(t.dir, segmentName(s.base, indexExt)))
+	if err != nil {
+		return fmt.Errorf("topiclog/segment/loadIndex: open index => %v", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("topiclog/segment/loadIndex: stat index => %v", err)
+	}
+	s.count = uint64(fi.Size() / indexEntry)
+	if s.count > 0 {
+		_, s.last, err = readEntry(f, s.count-1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recover rebuilds the index of the active segment from its records, a record torn by a crash and
+// the records after it are truncated.
+func (t *topicLog) recover(s *segment) error {
+	path := filepath.Join(t.dir, segmentName(s.base, logExt))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("topiclog/segment/recover: open log => %v", err)
+	}
+	defer f.Close()
+
+	var index []byte
+	var pos int64
+	r := bufio.NewReader(f)
+	s.count = 0
+	for {
+		rec, n, err := readRecord(r)
+		if err == io.EOF || err == errTorn {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("topiclog/segment/recover: read log => %v", err)
+		}
+		index = appendEntry(index, pos, rec.Time)
+		pos += n
+		s.count++
+		s.last = rec.Time
+	}
+	if err := f.Truncate(pos); err != nil {
+		return fmt.Errorf("topiclog/segment/recover: truncate log => %v", err)
+	}
+	s.size = pos
+
+	idxPath := filepath.Join(t.dir, segmentName(s.base, indexExt))
+	if err := ioutil.WriteFile(idxPath, index, 0644); err != nil {
+		return fmt.Errorf("topiclog/segment/recover: write index => %v", err)
+	}
+	return nil
+}
+
+func (t *topicLog) openActive(s *segment) error {
+	var err error
+	s.log, err = os.OpenFile(filepath.Join(t.dir, segmentName(s.base, logExt)), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("topiclog/segment/openActive: open log => %v", err)
+	}
+	s.idx, err = os.OpenFile(filepath.Join(t.dir, segmentName(s.base, indexExt)), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		s.log.Close()
+		return fmt.Errorf("topiclog/segment/openActive: open index => %v", err)
+	}
+	return nil
+}
+
+func (s *segment) closeFiles() error {
+	if s.log == nil {
+		return nil
+	}
+	err := s.log.Close()
+	if e := s.idx.Close(); err == nil {
+		err = e
+	}
+	s.log, s.idx = nil, nil
+	return err
+}
+
+func (t *topicLog) append(qos byte, payload []byte, now time.Time) (uint64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return 0, ErrClosed
+	}
+
+	s := t.segments[len(t.segments)-1]
+	if s.size >= t.cfg.SegmentBytes && s.count > 0 {
+		next := &segment{base: s.base + s.count}
+		if err := t.openActive(next); err != nil {
+			return 0, err
+		}
+		_ = s.closeFiles()
+		t.segments = append(t.segments, next)
+		s = next
+	}
+
+	// the times of a topic never go back, so the index is searched by time
+	if now.Before(t.last) {
+		now = t.last
+	}
+	rec := encodeRecord(qos, payload, now)
+	if _, err := s.log.Write(rec); err != nil {
+		_ = s.log.Truncate(s.size)
+		return 0, fmt.Errorf("topiclog/segment/append: write log => %v", err)
+	}
+	if _, err := s.idx.Write(appendEntry(nil, s.size, now)); err != nil {
+		_ = s.log.Truncate(s.size)
+		_ = s.idx.Truncate(int64(s.count * indexEntry))
+		return 0, fmt.Errorf("topiclog/segment/append: write index => %v", err)
+	}
+	if t.cfg.Sync {
+		if err := s.log.Sync(); err != nil {
+			return 0, fmt.Errorf("topiclog/segment/append: sync log => %v", err)
+		}
+	}
+
+	offset := s.base + s.count
+	s.size += int64(len(rec))
+	s.count++
+	s.last = now
+	t.last = now
+	return offset, nil
+}
+
+// view is a segment as seen by a replay, the records past its count may be appended meanwhile.
+type view struct {
+	base  uint64
+	count uint64
+	last  time.Time
+}
+
+func (t *topicLog) views() []view {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	views := make([]view, 0, len(t.segments))
+	for _, s := range t.segments {
+		views = append(views, view{base: s.base, count: s.count, last: s.last})
+	}
+	return views
+}
+
+func (t *topicLog) replay(q Query, fn func(Record) error) error {
+	sent := 0
+	for _, v := range t.views() {
+		if v.count == 0 {
+			continue
+		}
+		if q.Since.IsZero() && v.base+v.count <= q.Offset {
+			continue
+		}
+		if !q.Since.IsZero() && v.last.Before(q.Since) {
+			continue
+		}
+		n, err := t.replaySegment(v, q, q.Limit-sent, fn)
+		if err != nil {
+			return err
+		}
+		sent += n
+		if q.Limit > 0 && sent >= q.Limit {
+			return nil
+		}
+	}
+	return nil
+}
+
+// replaySegment calls fn with the records of the segment selected by the query, at most limit of
+// them if it's positive.
+func (t *topicLog) replaySegment(v view, q Query, limit int, fn func(Record) error) (int, error) {
+	idx, err := os.Open(filepath.Join(t.dir, segmentName(v.base, indexExt)))
+	if err != nil {
+		return 0, fmt.Errorf("topiclog/segment/replaySegment: open index => %v", err)
+	}
+	defer idx.Close()
+
+	var first uint64
+	if q.Since.IsZero() {
+		if q.Offset > v.base {
+			first = q.Offset - v.base
+		}
+	} else {
+		var searchErr error
+		first = uint64(sort.Search(int(v.count), func(i int) bool {
+			_, at, err := readEntry(idx, uint64(i))
+			if err != nil {
+				searchErr = err
+				return true
+			}
+			return !at.Before(q.Since)
+		}))
+		if searchErr != nil {
+			return 0, searchErr
+		}
+	}
+	if first >= v.count {
+		return 0, nil
+	}
+	pos, _, err := readEntry(idx, first)
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.Open(filepath.Join(t.dir, segmentName(v.base, logExt)))
+	if err != nil {
+		return 0, fmt.Errorf("topiclog/segment/replaySegment: open log => %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(pos, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("topiclog/segment/replaySegment: seek log => %v", err)
+	}
+
+	r := bufio.NewReader(f)
+	n := 0
+	for i := first; i < v.count; i++ {
+		if limit > 0 && n >= limit {
+			break
+		}
+		rec, _, err := readRecord(r)
+		if err != nil {
+			return n, fmt.Errorf("topiclog/segment/replaySegment: read record %d => %v", v.base+i, err)
+		}
+		rec.Offset = v.base + i
+		rec.Topic = t.topic
+		if err := fn(rec); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// prune drops the sealed segments whose last record is before the cutoff.
+func (t *topicLog) prune(cutoff time.Time) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for len(t.segments) > 1 && t.segments[0].last.Before(cutoff) {
+		s := t.segments[0]
+		for _, ext := range []string{logExt, indexExt} {
+			if err := os.Remove(filepath.Join(t.dir, segmentName(s.base, ext))); err != nil && !os.IsNotExist(err) {
+				return n, fmt.Errorf("topiclog/segment/prune: remove segment => %v", err)
+			}
+		}
+		t.segments = t.segments[1:]
+		n++
+	}
+	return n, nil
+}
+
+func (t *topicLog) stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := Stats{Topic: t.topic, Segments: len(t.segments), First: t.segments[0].base}
+	for _, seg := range t.segments {
+		s.Bytes += seg.size
+	}
+	active := t.segments[len(t.segments)-1]
+	s.Next = active.base + active.count
+	return s
+}
+
+func (t *topicLog) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	return t.segments[len(t.segments)-1].closeFiles()
+}
+
+func encodeRecord(qos byte, payload []byte, now time.Time) []byte {
+	body := recordFixed + len(payload)
+	buf := make([]byte, recordHeader+body)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(body))
+	binary.BigEndian.PutUint64(buf[8:16], uint64(now.UnixNano()))
+	buf[16] = qos
+	copy(buf[17:], payload)
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(buf[recordHeader:]))
+	return buf
+}
+
+// readRecord reads the next record and its size, errTorn if it's truncated or corrupted.
+func readRecord(r *bufio.Reader) (Record, int64, error) {
+	var header [recordHeader]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return Record{}, 0, io.EOF
+		}
+		return Record{}, 0, errTorn
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size < recordFixed {
+		return Record{}, 0, errTorn
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return Record{}, 0, errTorn
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:8]) {
+		return Record{}, 0, errTorn
+	}
+	rec := Record{
+		Time:    time.Unix(0, int64(binary.BigEndian.Uint64(body[0:8]))),
+		Qos:     body[8],
+		Payload: body[recordFixed:],
+	}
+	return rec, int64(recordHeader) + int64(size), nil
+}
+
+func appendEntry(index []byte, pos int64, at time.Time) []byte {
+	var e [indexEntry]byte
+	binary.BigEndian.PutUint64(e[0:8], uint64(pos))
+	binary.BigEndian.PutUint64(e[8:16], uint64(at.UnixNano()))
+	return append(index, e[:]...)
+}
+
+func readEntry(idx io.ReaderAt, i uint64) (int64, time.Time, error) {
+	var e [indexEntry]byte
+	if _, err := idx.ReadAt(e[:], int64(i*indexEntry)); err != nil {
+		return 0, time.Time{}, fmt.Errorf("topiclog/segment/readEntry: read index entry %d => %v", i, err)
+	}
+	return int64(binary.BigEndian.Uint64(e[0:8])), time.Unix(0, int64(binary.BigEndian.Uint64(e[8:16]))), nil
+}
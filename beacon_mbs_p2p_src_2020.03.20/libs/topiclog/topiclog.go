@@ -0,0 +1,254 @@
+// Package topiclog keeps the publishes of the configured topic filters in an append-only log per
+// topic, so the subscribers can replay them since a time or an offset. The log of a topic is split
+// in segment files, each with an index of the position and the time of its records; the old
+// segments are dropped after the retention.
+package topiclog
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics/matcher"
+)
+
+const (
+	// DefaultSegmentBytes is the size from which a new segment is started.
+	DefaultSegmentBytes = 64 << 20
+)
+
+var ErrClosed = errors.New("topiclog: the log is closed")
+
+// Config of the log, the publishes on the topics matching the Filters are logged in Dir.
+type Config struct {
+	Dir     string   `json:"dir" yaml:"dir"`
+	Filters []string `json:"filters" yaml:"filters"`
+	// SegmentBytes is the size of a segment, DefaultSegmentBytes if it's 0
+	SegmentBytes int64 `json:"segment_bytes" yaml:"segment_bytes"`
+	// Retention drops the segments whose last record is older, 0 keeps them
+	Retention time.Duration `json:"retention" yaml:"retention"`
+	// Sync flushes each record to the disk before the append returns
+	Sync bool `json:"sync" yaml:"sync"`
+}
+
+func (c *Config) Validate() error {
+	if len(c.Dir) == 0 {
+		return errors.New("topiclog/topiclog/Validate: missing log directory")
+	}
+	if len(c.Filters) == 0 {
+		return errors.New("topiclog/topiclog/Validate: no topic filter to log")
+	}
+	for _, f := range c.Filters {
+		if err := matcher.ValidFilter([]byte(f)); err != nil {
+			return fmt.Errorf("topiclog/topiclog/Validate: invalid filter %q => %v", f, err)
+		}
+	}
+	if c.SegmentBytes < 0 || c.Retention < 0 {
+		return errors.New("topiclog/topiclog/Validate: the segment size and the retention cannot be negative")
+	}
+	return nil
+}
+
+// Record is a logged publish, its offset is its position in the log of its topic.
+type Record struct {
+	Offset  uint64
+	Time    time.Time
+	Topic   string
+	Qos     byte
+	Payload []byte
+}
+
+// Query selects the records of the topics matching the filter, from the offset or since the time
+// if it's set. Limit bounds the records of each topic, 0 is unlimited.
+type Query struct {
+	Filter string
+	Offset uint64
+	Since  time.Time
+	Limit  int
+}
+
+// Log is the log of the topics matching the filters of its config.
+type Log struct {
+	cfg Config
+
+	mu     sync.Mutex
+	topics map[string]*topicLog
+	closed bool
+}
+
+// Open opens the log in the directory of the config, the logs of the topics found there are
+// recovered: a record torn by a crash is truncated.
+func Open(cfg Config) (*Log, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.SegmentBytes == 0 {
+		cfg.SegmentBytes = DefaultSegmentBytes
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("topiclog/topiclog/Open: create %s => %v", cfg.Dir, err)
+	}
+
+	l := &Log{cfg: cfg, topics: make(map[string]*topicLog)}
+	entries, err := ioutil.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("topiclog/topiclog/Open: read %s => %v", cfg.Dir, err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		topic, err := base64.RawURLEncoding.DecodeString(e.Name())
+		if err != nil {
+			continue
+		}
+		tl, err := openTopicLog(filepath.Join(cfg.Dir, e.Name()), string(topic), cfg)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		l.topics[string(topic)] = tl
+	}
+	return l, nil
+}
+
+// Matches reports whether the publishes on the topic are logged.
+func (l *Log) Matches(topic string) bool {
+	if l == nil {
+		return false
+	}
+	for _, f := range l.cfg.Filters {
+		if ok, _ := matcher.Match([]byte(f), []byte(topic)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Append logs the publish on the topic and returns its offset.
+func (l *Log) Append(topic string, qos byte, payload []byte, now time.Time) (uint64, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return 0, ErrClosed
+	}
+	tl, ok := l.topics[topic]
+	if !ok {
+		var err error
+		dir := filepath.Join(l.cfg.Dir, base64.RawURLEncoding.EncodeToString([]byte(topic)))
+		if tl, err = openTopicLog(dir, topic, l.cfg); err != nil {
+			l.mu.Unlock()
+			return 0, err
+		}
+		l.topics[topic] = tl
+	}
+	l.mu.Unlock()
+
+	return tl.append(qos, payload, now)
+}
+
+// Replay calls fn with the records selected by the query, the topics in their order and the
+// records of each topic in the order they were logged. It stops at the first error of fn.
+func (l *Log) Replay(q Query, fn func(Record) error) error {
+	if err := matcher.ValidFilter([]byte(q.Filter)); err != nil {
+		return fmt.Errorf("topiclog/topiclog/Replay: invalid filter %q => %v", q.Filter, err)
+	}
+
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return ErrClosed
+	}
+	var logs []*topicLog
+	for topic, tl := range l.topics {
+		if ok, _ := matcher.Match([]byte(q.Filter), []byte(topic)); ok {
+			logs = append(logs, tl)
+		}
+	}
+	l.mu.Unlock()
+
+	sort.Slice(logs, func(i, j int) bool { return logs[i].topic < logs[j].topic })
+	for _, tl := range logs {
+		if err := tl.replay(q, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune drops the segments whose last record is older than the retention, the active segment of
+// each topic is kept. It returns the number of segments dropped.
+func (l *Log) Prune(now time.Time) (int, error) {
+	if l.cfg.Retention == 0 {
+		return 0, nil
+	}
+	l.mu.Lock()
+	logs := make([]*topicLog, 0, len(l.topics))
+	for _, tl := range l.topics {
+		logs = append(logs, tl)
+	}
+	l.mu.Unlock()
+
+	n := 0
+	for _, tl := range logs {
+		dropped, err := tl.prune(now.Add(-l.cfg.Retention))
+		n += dropped
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Stats of a topic log.
+type Stats struct {
+	Topic    string `json:"topic"`
+	First    uint64 `json:"first"`
+	Next     uint64 `json:"next"`
+	Segments int    `json:"segments"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// Stats returns the stats of the topic logs, by topic.
+func (l *Log) Stats() []Stats {
+	l.mu.Lock()
+	logs := make([]*topicLog, 0, len(l.topics))
+	for _, tl := range l.topics {
+		logs = append(logs, tl)
+	}
+	l.mu.Unlock()
+
+	stats := make([]Stats, 0, len(logs))
+	for _, tl := range logs {
+		stats = append(stats, tl.stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Topic < stats[j].Topic })
+	return stats
+}
+
+// Close closes the files of the log.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+
+	var first error
+	for _, tl := range l.topics {
+		if err := tl.close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
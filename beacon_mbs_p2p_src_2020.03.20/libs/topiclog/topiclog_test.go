@@ -0,0 +1,108 @@
+package topiclog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func collect(t *testing.T, l *Log, q Query) []Record {
+	var got []Record
+	require.NoError(t, l.Replay(q, func(r Record) error {
+		got = append(got, r)
+		return nil
+	}))
+	return got
+}
+
+func TestLogReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "topiclog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.Error(t, (&Config{Dir: dir}).Validate())
+	l, err := Open(Config{Dir: dir, Filters: []string{"telemetry/#"}, SegmentBytes: 64})
+	require.NoError(t, err)
+	require.True(t, l.Matches("telemetry/cam1"))
+	require.False(t, l.Matches("commands/cam1"))
+
+	start := time.Unix(1000, 0)
+	for i := 0; i < 10; i++ {
+		off, err := l.Append("telemetry/cam1", 1, []byte(fmt.Sprintf("frame-%d", i)), start.Add(time.Duration(i)*time.Second))
+		require.NoError(t, err)
+		require.EqualValues(t, i, off)
+	}
+	_, err = l.Append("telemetry/cam2", 0, []byte("hello"), start)
+	require.NoError(t, err)
+
+	got := collect(t, l, Query{Filter: "telemetry/cam1", Offset: 7})
+	require.Len(t, got, 3)
+	require.EqualValues(t, 7, got[0].Offset)
+	require.Equal(t, "frame-7", string(got[0].Payload))
+
+	got = collect(t, l, Query{Filter: "telemetry/+", Since: start.Add(8 * time.Second)})
+	require.Len(t, got, 2)
+	require.Equal(t, "frame-8", string(got[0].Payload))
+
+	got = collect(t, l, Query{Filter: "telemetry/#", Limit: 2})
+	require.Len(t, got, 3)
+	require.Equal(t, "telemetry/cam2", got[2].Topic)
+
+	stats := l.Stats()
+	require.Len(t, stats, 2)
+	require.Greater(t, stats[0].Segments, 1)
+	require.EqualValues(t, 10, stats[0].Next)
+	require.NoError(t, l.Close())
+
+	// the records survive a reopen, and the offsets go on
+	l, err = Open(Config{Dir: dir, Filters: []string{"telemetry/#"}, SegmentBytes: 64, Retention: time.Minute})
+	require.NoError(t, err)
+	defer l.Close()
+	off, err := l.Append("telemetry/cam1", 1, []byte("frame-10"), start.Add(10*time.Second))
+	require.NoError(t, err)
+	require.EqualValues(t, 10, off)
+	require.Len(t, collect(t, l, Query{Filter: "telemetry/cam1"}), 11)
+
+	n, err := l.Prune(start.Add(time.Minute + 5*time.Second))
+	require.NoError(t, err)
+	require.Greater(t, n, 0)
+	got = collect(t, l, Query{Filter: "telemetry/cam1"})
+	require.EqualValues(t, 10, got[len(got)-1].Offset)
+	require.Greater(t, got[0].Offset, uint64(0))
+}
+
+func TestLogRecoverTorn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "topiclog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := Config{Dir: dir, Filters: []string{"#"}}
+	l, err := Open(cfg)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = l.Append("a", 1, []byte("payload"), time.Unix(int64(i), 0))
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	// a crash in the middle of the last record
+	logs, err := filepath.Glob(filepath.Join(dir, "*", "*"+logExt))
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	fi, err := os.Stat(logs[0])
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(logs[0], fi.Size()-3))
+
+	l, err = Open(cfg)
+	require.NoError(t, err)
+	defer l.Close()
+	require.Len(t, collect(t, l, Query{Filter: "a"}), 2)
+	off, err := l.Append("a", 1, []byte("payload"), time.Unix(5, 0))
+	require.NoError(t, err)
+	require.EqualValues(t, 2, off)
+}
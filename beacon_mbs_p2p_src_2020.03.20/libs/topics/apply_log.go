@@ -0,0 +1,247 @@
+package topics
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+var (
+	_ MutationLog = (*memProvider)(nil)
+	_ MutationLog = (*boltProvider)(nil)
+)
+
+// The mutations waiting for the writer, and the most applied in one version of the trie
+const (
+	defaultApplyQueue = 1024
+	defaultApplyBatch = 256
+)
+
+// MutationKind is the kind of a mutation of the apply log.
+type MutationKind byte
+
+const (
+	MutationSubscribe MutationKind = iota + 1
+	MutationUnsubscribe
+	MutationRetain
+)
+
+// Mutation is an entry of the apply log of the memory provider. The subscribes, the unsubscribes
+// and the retains are applied by a single writer in the order of their sequence numbers, so the
+// observers may replicate them to the peers as they are. The expired retained messages purged by
+// the sweeper are not logged, each broker expires them on its own.
+type Mutation struct {
+	Seq  uint64
+	Kind MutationKind
+	// The filter of a subscription, or the topic of a retained message
+	Topic      []byte
+	Qos        byte
+	Subscriber Subscriber
+	Message    *packets.PublishPacket
+	Deadline   time.Time
+}
+
+// MutationLog is implemented by the providers applying their mutations through an ordered apply
+// log. The observers are called by the writer with each mutation applied, in order; they must
+// neither block nor change the provider.
+type MutationLog interface {
+	OnMutation(fn func(Mutation))
+	AppliedSeq() uint64
+}
+
+// applyEntry is a mutation waiting for the writer, and its result once it's applied.
+type applyEntry struct {
+	mutation Mutation
+	// the share group and the filter without its prefix, of a subscription
+	group  string
+	filter []byte
+
+	qos byte
+	// whether the subscriber was subscribed to the filter already
+	existed  bool
+	replaced *packets.PublishPacket
+	err      error
+	done     chan struct{}
+}
+
+// applyLog feeds the writer of the memory provider. The writer is started by the first mutation
+// and stopped by Close, a later mutation starts it again.
+type applyLog struct {
+	mu      sync.Mutex
+	entries chan *applyEntry
+	stopped chan struct{}
+
+	// the sequence number of the last mutation applied, changed by the writer only
+	seq uint64
+
+	omu       sync.Mutex
+	observers atomic.Value
+}
+
+// submit hands the entries to the writer in their order, and waits until they are applied.
+func (m *memProvider) submit(entries ...*applyEntry) {
+	m.log.mu.Lock()
+	if m.log.entries == nil {
+		m.log.entries = make(chan *applyEntry, defaultApplyQueue)
+		m.log.stopped = make(chan struct{})
+		go m.write(m.log.entries, m.log.stopped)
+	}
+	for _, e := range entries {
+		e.done = make(chan struct{})
+		m.log.entries <- e
+	}
+	m.log.mu.Unlock()
+
+	for _, e := range entries {
+		<-e.done
+	}
+}
+
+// stopWriter stops the writer once the submitted mutations are applied.
+func (m *memProvider) stopWriter() {
+	m.log.mu.Lock()
+	defer m.log.mu.Unlock()
+
+	if m.log.entries == nil {
+		return
+	}
+	close(m.log.entries)
+	<-m.log.stopped
+	m.log.entries, m.log.stopped = nil, nil
+}
+
+// write is the single writer of the provider: it takes the mutations waiting in order, applies
+// the subscriptions of a batch to one new version of the trie and publishes it once, so the
+// readers matching the published version never wait for it.
+func (m *memProvider) write(entries chan *applyEntry, stopped chan struct{}) {
+	defer close(stopped)
+
+	batch := make([]*applyEntry, 0, defaultApplyBatch)
+	for e := range entries {
+		batch = append(batch[:0], e)
+	more:
+		for len(batch) < defaultApplyBatch {
+			select {
+			case e, ok := <-entries:
+				if !ok {
+					break more
+				}
+				batch = append(batch, e)
+			default:
+				break more
+			}
+		}
+		m.applyBatch(batch)
+	}
+}
+
+func (m *memProvider) applyBatch(batch []*applyEntry) {
+	var (
+		root  *subscribeNode
+		fresh freshNodes
+	)
+	changed := false
+	observers, _ := m.log.observers.Load().([]func(Mutation))
+
+	for i, e := range batch {
+		switch e.mutation.Kind {
+		case MutationSubscribe, MutationUnsubscribe:
+			if root == nil {
+				root = m.root().clone()
+				fresh = freshNodes{root: struct{}{}}
+			}
+			e.err = m.applySubscription(root, e, fresh)
+			if e.err != nil {
+				// the failed entry may have left copied or empty nodes in the version
+				root, fresh = m.rebuildBatch(batch[:i])
+			}
+			changed = changed || e.err == nil
+		case MutationRetain:
+			e.replaced, e.err = m.applyRetain(e.mutation.Message, e.mutation.Deadline)
+		}
+		if e.err != nil {
+			continue
+		}
+		e.mutation.Seq = atomic.AddUint64(&m.log.seq, 1)
+		for _, fn := range observers {
+			fn(e.mutation)
+		}
+	}
+
+	if changed {
+		m.subscribeRoot.Store(root)
+		m.clearMatchCache()
+	}
+	for _, e := range batch {
+		close(e.done)
+	}
+}
+
+// rebuildBatch builds the version again from the published one with the subscriptions of the batch
+// applied already, so the entries that failed leave nothing behind.
+func (m *memProvider) rebuildBatch(applied []*applyEntry) (*subscribeNode, freshNodes) {
+	root := m.root().clone()
+	fresh := freshNodes{root: struct{}{}}
+	for _, e := range applied {
+		if e.err == nil && e.mutation.Kind != MutationRetain {
+			// it was applied to the same trie before, it doesn't fail now
+			_ = m.applySubscription(root, e, fresh)
+		}
+	}
+	return root, fresh
+}
+
+// applySubscription changes the version of the trie being built, the nodes it copied already are
+// changed in place.
+func (m *memProvider) applySubscription(root *subscribeNode, e *applyEntry, fresh freshNodes) error {
+	sub := e.mutation.Subscriber
+	if e.mutation.Kind == MutationUnsubscribe {
+		if len(e.group) > 0 {
+			return root.groupSubscriberRemove(e.filter, sub, e.group)
+		}
+		return root.keyedSubscriberRemove(e.filter, sub)
+	}
+	var err error
+	if len(e.group) > 0 {
+		e.existed, err = root.groupSubscriberInsert(e.filter, e.qos, sub, e.group, fresh)
+	} else {
+		e.existed, err = root.keyedSubscriberInsert(e.filter, e.qos, sub, m.subscriberSetMax, fresh)
+	}
+	return err
+}
+
+// OnMutation adds the observer of the mutations applied from now on.
+func (m *memProvider) OnMutation(fn func(Mutation)) {
+	m.log.omu.Lock()
+	defer m.log.omu.Unlock()
+
+	observers, _ := m.log.observers.Load().([]func(Mutation))
+	m.log.observers.Store(append(append([]func(Mutation){}, observers...), fn))
+}
+
+// AppliedSeq returns the sequence number of the last mutation applied, 0 if there is none.
+func (m *memProvider) AppliedSeq() uint64 {
+	return atomic.LoadUint64(&m.log.seq)
+}
+
+// OnMutation follows the mutations of the trie in memory, the ones persisted by the provider.
+func (p *boltProvider) OnMutation(fn func(Mutation)) {
+	p.mem.OnMutation(fn)
+}
+
+func (p *boltProvider) AppliedSeq() uint64 {
+	return p.mem.AppliedSeq()
+}
+
+// OnMutation adds the observer of the mutations of the provider, an error if the provider has no
+// apply log.
+func (m *Manager) OnMutation(fn func(Mutation)) error {
+	if l, ok := m.ttp.(MutationLog); ok {
+		l.OnMutation(fn)
+		return nil
+	}
+	return errors.New("topics/apply_log/OnMutation: the provider has no apply log")
+}
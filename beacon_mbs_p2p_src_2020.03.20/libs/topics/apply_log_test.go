@@ -0,0 +1,129 @@
+package topics
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemProviderApplyLog(t *testing.T) {
+	p := NewMemProvider()
+	defer p.Close()
+
+	var applied []Mutation
+	p.OnMutation(func(m Mutation) {
+		applied = append(applied, m)
+	})
+
+	_, err := p.Subscribe([]byte("a/+"), 1, testSubscriber("s1"))
+	require.NoError(t, err)
+	require.Error(t, p.Unsubscribe([]byte("b"), testSubscriber("s1")))
+	msg := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	msg.TopicName = "a/b"
+	msg.Payload = []byte("x")
+	require.NoError(t, p.Retain(msg))
+	require.NoError(t, p.Unsubscribe([]byte("a/+"), testSubscriber("s1")))
+
+	// the failed mutations are not logged
+	require.Equal(t, 3, len(applied))
+	require.Equal(t, uint64(3), p.AppliedSeq())
+	for i, kind := range []MutationKind{MutationSubscribe, MutationRetain, MutationUnsubscribe} {
+		require.Equal(t, uint64(i+1), applied[i].Seq)
+		require.Equal(t, kind, applied[i].Kind)
+	}
+	require.Equal(t, "a/+", string(applied[0].Topic))
+	require.Equal(t, testSubscriber("s1"), applied[0].Subscriber)
+	require.True(t, applied[1].Message == msg)
+}
+
+func TestMemProviderApplyLogConcurrent(t *testing.T) {
+	p := NewMemProvider()
+	defer p.Close()
+
+	var seqs []uint64
+	p.OnMutation(func(m Mutation) {
+		seqs = append(seqs, m.Seq)
+	})
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				_, err := p.Subscribe([]byte(fmt.Sprintf("w/%d/%d", w, i)), 1, testSubscriber(fmt.Sprint(w)))
+				require.NoError(t, err)
+			}
+		}(w)
+	}
+	var subs []Subscriber
+	var qoss []byte
+	for i := 0; i < 100; i++ {
+		require.NoError(t, p.Subscribers([]byte("w/0/0"), 1, &subs, &qoss))
+	}
+	wg.Wait()
+
+	require.Equal(t, 800, len(seqs))
+	for i, seq := range seqs {
+		require.Equal(t, uint64(i+1), seq)
+	}
+	for w := 0; w < 8; w++ {
+		require.NoError(t, p.Subscribers([]byte(fmt.Sprintf("w/%d/99", w)), 1, &subs, &qoss))
+		require.Equal(t, []Subscriber{testSubscriber(fmt.Sprint(w))}, subs)
+	}
+}
+
+func TestMemProviderApplyLogClose(t *testing.T) {
+	p := NewMemProvider()
+	_, err := p.Subscribe([]byte("a"), 1, testSubscriber("s1"))
+	require.NoError(t, err)
+	require.NoError(t, p.Close())
+
+	// a mutation after Close starts the writer again
+	_, err = p.Subscribe([]byte("a"), 1, testSubscriber("s2"))
+	require.NoError(t, err)
+	var subs []Subscriber
+	var qoss []byte
+	require.NoError(t, p.Subscribers([]byte("a"), 1, &subs, &qoss))
+	require.Equal(t, []Subscriber{testSubscriber("s2")}, subs)
+	require.Equal(t, uint64(2), p.AppliedSeq())
+	require.NoError(t, p.Close())
+}
+
+func TestMemProviderApplyBatchFailed(t *testing.T) {
+	p := NewMemProvider(WithSubscriberSets(1))
+	defer p.Close()
+	_, err := p.Subscribe([]byte("x/y"), 1, testSubscriber("s0"))
+	require.NoError(t, err)
+	v1 := p.root()
+
+	entry := func(kind MutationKind, filter string, sub string) *applyEntry {
+		return &applyEntry{
+			mutation: Mutation{Kind: kind, Topic: []byte(filter), Qos: 1, Subscriber: testSubscriber(sub)},
+			filter:   []byte(filter),
+			qos:      1,
+			done:     make(chan struct{}),
+		}
+	}
+	batch := []*applyEntry{
+		entry(MutationSubscribe, "a", "s1"),
+		entry(MutationSubscribe, "x/y", "s2"),
+		entry(MutationUnsubscribe, "x/y", "s3"),
+		entry(MutationSubscribe, "a/b", "s4"),
+	}
+	p.applyBatch(batch)
+	require.NoError(t, batch[0].err)
+	require.Equal(t, ErrTooManySubscribers, batch[1].err)
+	require.Error(t, batch[2].err)
+	require.NoError(t, batch[3].err)
+
+	// the nodes the failed entries copied are not in the version
+	root := p.root()
+	require.True(t, root.subscribeNodesMap["x"] == v1.subscribeNodesMap["x"])
+	require.Equal(t, 1, root.subscribeNodesMap["a"].subSet.len())
+	require.Equal(t, 1, root.subscribeNodesMap["a"].subscribeNodesMap["b"].subSet.len())
+	require.Equal(t, uint64(3), p.AppliedSeq())
+}
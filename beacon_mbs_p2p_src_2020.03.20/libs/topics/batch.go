@@ -0,0 +1,195 @@
+package topics
+
+import (
+	"fmt"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/storecheck"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	_ BatchProvider = (*memProvider)(nil)
+	_ BatchProvider = (*boltProvider)(nil)
+	_ BatchProvider = (*compositeProvider)(nil)
+)
+
+// Subscription is a subscription inserted by SubscribeBatch.
+type Subscription struct {
+	Filter     []byte
+	Qos        byte
+	Subscriber Subscriber
+}
+
+// BatchProvider is implemented by the providers inserting many subscriptions at once, such as the
+// subscriptions restored when the clients of the persistent sessions reconnect together after a
+// restart. The granted QoS and the error of each subscription are returned in its order, a failed
+// subscription doesn't fail the others.
+type BatchProvider interface {
+	SubscribeBatch(subs []Subscription) ([]byte, []error)
+}
+
+// subscribeEach inserts the subscriptions one by one, for the providers without batches.
+func subscribeEach(p TheTopicsProvider, subs []Subscription) ([]byte, []error) {
+	granted := make([]byte, len(subs))
+	errs := make([]error, len(subs))
+	for i, s := range subs {
+		granted[i], errs[i] = p.Subscribe(s.Filter, s.Qos, s.Subscriber)
+	}
+	return granted, errs
+}
+
+// SubscribeBatch hands the subscriptions to the writer of the apply log together: the writer
+// inserts them into as few versions of the trie as its batches allow, the nodes shared by the
+// filters are copied once for a batch and the match cache is cleared once.
+func (m *memProvider) SubscribeBatch(subs []Subscription) ([]byte, []error) {
+	granted := make([]byte, len(subs))
+	errs := make([]error, len(subs))
+
+	entries := make([]*applyEntry, 0, len(subs))
+	index := make([]int, 0, len(subs))
+	for i, s := range subs {
+		granted[i] = QosFailure
+		if !ValidQos(s.Qos) {
+			errs[i] = fmt.Errorf("topics/batch/SubscribeBatch: Invalid QoS %d", s.Qos)
+			continue
+		}
+		if s.Subscriber == nil {
+			errs[i] = fmt.Errorf("topics/batch/SubscribeBatch: Subscriber cannot be nil")
+			continue
+		}
+		if err := ValidateTopicFilter(s.Filter); err != nil {
+			errs[i] = err
+			continue
+		}
+		group, filter, _, err := ParseSharedFilter(s.Filter)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		entries = append(entries, &applyEntry{
+			mutation: Mutation{Kind: MutationSubscribe, Topic: s.Filter, Qos: s.Qos, Subscriber: s.Subscriber},
+			group:    group,
+			filter:   filter,
+			qos:      s.Qos,
+		})
+		index = append(index, i)
+	}
+
+	m.submit(entries...)
+	for j, e := range entries {
+		i := index[j]
+		if errs[i] = e.err; e.err == nil {
+			granted[i] = e.qos
+		}
+	}
+	return granted, errs
+}
+
+// SubscribeBatch inserts the subscriptions into the trie as one batch, and persists the ones of
+// the persistent subscribers in a single transaction.
+func (p *boltProvider) SubscribeBatch(subs []Subscription) ([]byte, []error) {
+	granted, errs := p.mem.SubscribeBatch(subs)
+
+	type persisted struct {
+		i int
+		k []byte
+	}
+	var keys []persisted
+	p.mu.Lock()
+	for i, s := range subs {
+		ps, ok := s.Subscriber.(PersistentSubscriber)
+		if errs[i] != nil || !ok {
+			continue
+		}
+		k := subscriptionKey(string(s.Filter), ps.SubscriberKey())
+		if r, ok := p.restored[string(k)]; ok && r != s.Subscriber {
+			_ = p.mem.Unsubscribe(s.Filter, r)
+			delete(p.restored, string(k))
+		}
+		keys = append(keys, persisted{i: i, k: k})
+	}
+	p.mu.Unlock()
+	if len(keys) == 0 {
+		return granted, errs
+	}
+
+	err := p.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(subscriptionsBucket)
+		for _, s := range keys {
+			if err := b.Put(s.k, storecheck.EncodeRecord([]byte{granted[s.i]})); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for _, s := range keys {
+			granted[s.i] = QosFailure
+			errs[s.i] = fmt.Errorf("topics/batch/SubscribeBatch: persist error: %v", err)
+		}
+	}
+	return granted, errs
+}
+
+// SubscribeBatch groups the subscriptions by the provider of their route, each provider gets its
+// own batch.
+func (c *compositeProvider) SubscribeBatch(subs []Subscription) ([]byte, []error) {
+	granted := make([]byte, len(subs))
+	errs := make([]error, len(subs))
+
+	var order []TheTopicsProvider
+	groups := make(map[TheTopicsProvider][]int)
+	for i, s := range subs {
+		_, filter, _, _ := ParseSharedFilter(s.Filter)
+		p := c.route(filter)
+		if _, ok := groups[p]; !ok {
+			order = append(order, p)
+		}
+		groups[p] = append(groups[p], i)
+	}
+
+	for _, p := range order {
+		idx := groups[p]
+		batch := make([]Subscription, len(idx))
+		for j, i := range idx {
+			batch[j] = subs[i]
+		}
+		var g []byte
+		var e []error
+		if bp, ok := p.(BatchProvider); ok {
+			g, e = bp.SubscribeBatch(batch)
+		} else {
+			g, e = subscribeEach(p, batch)
+		}
+		for j, i := range idx {
+			granted[i], errs[i] = g[j], e[j]
+		}
+	}
+	return granted, errs
+}
+
+// SubscribeBatch inserts the subscriptions as one batch if the provider supports it, one by one
+// otherwise.
+func (m *Manager) SubscribeBatch(subs []Subscription) ([]byte, []error) {
+	start := time.Now()
+	var (
+		granted []byte
+		errs    []error
+	)
+	if bp, ok := m.ttp.(BatchProvider); ok {
+		granted, errs = bp.SubscribeBatch(subs)
+	} else {
+		granted, errs = subscribeEach(m.ttp, subs)
+	}
+
+	if m.sink != nil && len(subs) > 0 {
+		// the batch is shared by its subscriptions
+		elapsed := time.Since(start) / time.Duration(len(subs))
+		for i, s := range subs {
+			m.sink.ObserveSubscribe(string(s.Filter), elapsed, errs[i])
+		}
+	}
+	return granted, errs
+}
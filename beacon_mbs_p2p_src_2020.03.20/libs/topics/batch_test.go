@@ -0,0 +1,80 @@
+package topics
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemProviderSubscribeBatch(t *testing.T) {
+	p := NewMemProvider()
+	_, err := p.Subscribe([]byte("a/+"), 1, testSubscriber("s0"))
+	require.NoError(t, err)
+	v1 := p.root()
+
+	granted, errs := p.SubscribeBatch([]Subscription{
+		{Filter: []byte("a/+"), Qos: 2, Subscriber: testSubscriber("s1")},
+		{Filter: []byte("a/b/#"), Qos: 1, Subscriber: testSubscriber("s2")},
+		{Filter: []byte("a/#/b"), Qos: 1, Subscriber: testSubscriber("s3")},
+		{Filter: []byte("$share/g/a/+"), Qos: 0, Subscriber: testSubscriber("w1")},
+		{Filter: []byte("a/+"), Qos: 1, Subscriber: nil},
+	})
+	require.Equal(t, []byte{2, 1, QosFailure, 0, QosFailure}, granted)
+	require.NoError(t, errs[0])
+	require.NoError(t, errs[1])
+	require.Error(t, errs[2])
+	require.NoError(t, errs[3])
+	require.Error(t, errs[4])
+
+	var subs []Subscriber
+	var qoss []byte
+	require.NoError(t, p.Subscribers([]byte("a/b"), 2, &subs, &qoss))
+	require.ElementsMatch(t, []Subscriber{testSubscriber("s0"), testSubscriber("s1"), testSubscriber("w1")}, subs)
+	require.NoError(t, p.Subscribers([]byte("a/b/c"), 2, &subs, &qoss))
+	require.Equal(t, []Subscriber{testSubscriber("s2")}, subs)
+
+	// the previous version is left as it was
+	subs = subs[:0]
+	require.NoError(t, v1.subscriberMatch([]byte("a/b"), 2, &subs, &qoss))
+	require.Equal(t, []Subscriber{testSubscriber("s0")}, subs)
+}
+
+func TestCompositeProviderSubscribeBatch(t *testing.T) {
+	fallback, state := NewMemProvider(), NewMemProvider()
+	c, err := NewCompositeProvider(fallback, Route{Filter: "state/#", Provider: state})
+	require.NoError(t, err)
+	m := &Manager{ttp: c}
+
+	_, errs := m.SubscribeBatch([]Subscription{
+		{Filter: []byte("state/+"), Qos: 1, Subscriber: keyedSubscriber("c1")},
+		{Filter: []byte("#"), Qos: 0, Subscriber: keyedSubscriber("c2")},
+		{Filter: []byte("$share/g/state/x"), Qos: 1, Subscriber: keyedSubscriber("c3")},
+	})
+	require.Equal(t, []error{nil, nil, nil}, errs)
+	require.Len(t, state.root().subscribeNodesMap["state"].subscribeNodesMap, 2)
+	require.Len(t, fallback.root().subscribeNodesMap, 1)
+}
+
+// 10000 clients restore their subscriptions after a restart, one by one or as one batch.
+func BenchmarkMemProviderRestoreSubscriptions(b *testing.B) {
+	subs := make([]Subscription, 10000)
+	for i := range subs {
+		subs[i] = Subscription{
+			Filter:     []byte(fmt.Sprintf("devices/%d/cmd/#", i)),
+			Qos:        1,
+			Subscriber: keyedSubscriber(fmt.Sprintf("client%d", i)),
+		}
+	}
+
+	b.Run("each", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = subscribeEach(NewMemProvider(), subs)
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = NewMemProvider().SubscribeBatch(subs)
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package topics
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// 10000 filters under the same parent, one of them and the wildcards match the topic.
+func BenchmarkMatchWide(b *testing.B) {
+	p := NewMemProvider()
+	defer p.Close()
+	for i := 0; i < 10000; i++ {
+		if _, err := p.Subscribe([]byte(fmt.Sprintf("fleet/vehicle%d/position", i)), 1, testSubscriber(fmt.Sprint(i))); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for _, filter := range []string{"fleet/+/position", "fleet/#"} {
+		if _, err := p.Subscribe([]byte(filter), 1, testSubscriber(filter)); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	var subs []Subscriber
+	var qoss []byte
+	topic := []byte("fleet/vehicle5000/position")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = p.Subscribers(topic, 1, &subs, &qoss)
+	}
+}
+
+// A topic of 32 levels, matched by the filters with a wildcard at each level.
+func BenchmarkMatchDeep(b *testing.B) {
+	levels := make([]string, 32)
+	for i := range levels {
+		levels[i] = fmt.Sprintf("l%d", i)
+	}
+
+	p := NewMemProvider()
+	defer p.Close()
+	for i := range levels {
+		filter := append(append([]string{}, levels[:i]...), SWC)
+		filter = append(filter, levels[i+1:]...)
+		if _, err := p.Subscribe([]byte(strings.Join(filter, SEP)), 1, testSubscriber(fmt.Sprint(i))); err != nil {
+			b.Fatal(err)
+		}
+		prefix := append(append([]string{}, levels[:i]...), MWC)
+		if _, err := p.Subscribe([]byte(strings.Join(prefix, SEP)), 1, testSubscriber(fmt.Sprint(-i-1))); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	var subs []Subscriber
+	var qoss []byte
+	topic := []byte(strings.Join(levels, SEP))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = p.Subscribers(topic, 1, &subs, &qoss)
+	}
+}
+
+// The publishes are matched in parallel while each tenth operation subscribes or unsubscribes.
+func BenchmarkConcurrentSubPub(b *testing.B) {
+	p := newBenchProvider(b)
+	defer p.Close()
+
+	var n int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var subs []Subscriber
+		var qoss []byte
+		for pb.Next() {
+			i := atomic.AddInt64(&n, 1)
+			if i%10 != 0 {
+				_ = p.Subscribers([]byte(fmt.Sprintf("site%d/device%d/temp", i%10, i%1000)), 1, &subs, &qoss)
+				continue
+			}
+			filter := []byte(fmt.Sprintf("site%d/churn%d/+", i%10, i%100))
+			if i%20 == 0 {
+				_, _ = p.Subscribe(filter, 1, testSubscriber("churn"))
+			} else {
+				_ = p.Unsubscribe(filter, testSubscriber("churn"))
+			}
+		}
+	})
+}
+
+// The topic of BenchmarkMatchWide matched without building the lists.
+func BenchmarkMatchEachWide(b *testing.B) {
+	p := NewMemProvider()
+	defer p.Close()
+	for i := 0; i < 10000; i++ {
+		if _, err := p.Subscribe([]byte(fmt.Sprintf("fleet/vehicle%d/position", i)), 1, testSubscriber(fmt.Sprint(i))); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for _, filter := range []string{"fleet/+/position", "fleet/#"} {
+		if _, err := p.Subscribe([]byte(filter), 1, testSubscriber(filter)); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	n := 0
+	count := func(sub Subscriber, qos byte) bool {
+		n++
+		return true
+	}
+	topic := []byte("fleet/vehicle5000/position")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = p.MatchEach(topic, 1, count)
+	}
+}
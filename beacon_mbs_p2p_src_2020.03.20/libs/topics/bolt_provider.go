@@ -0,0 +1,531 @@
+package topics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/backup"
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+	"awesomeProject/beacon/mqtt_network/libs/storecheck"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	bolt "go.etcd.io/bbolt"
+)
+
+const keySep = "\x00"
+
+var (
+	retainedBucket       = []byte("retained")
+	retainedExpiryBucket = []byte("retained_expiry")
+	subscriptionsBucket  = []byte("subscriptions")
+)
+
+var (
+	_ TheTopicsProvider       = (*boltProvider)(nil)
+	_ ExpiringProvider        = (*boltProvider)(nil)
+	_ ExpiryNotifyingProvider = (*boltProvider)(nil)
+	_ ReplacingProvider       = (*boltProvider)(nil)
+
+	_ backup.Snapshotter = (*boltProvider)(nil)
+)
+
+// PersistentSubscriber is implemented by the subscribers whose subscriptions are persisted, the
+// key identifies the subscriber across the restarts of the broker, such as its client id.
+type PersistentSubscriber interface {
+	SubscriberKey() string
+}
+
+// RestoredSubscriber holds a persisted subscription in the trie after a restart, until the
+// subscriber with the same key subscribes to the filter again and takes its place. Its ID is the
+// key. The publishing paths have nobody to deliver to for it.
+type RestoredSubscriber struct {
+	Key string
+}
+
+func (r *RestoredSubscriber) SubscriberKey() string {
+	return r.Key
+}
+
+func (r *RestoredSubscriber) ID() string {
+	return r.Key
+}
+
+// OnPublish drops the message, the subscriber is not back yet.
+func (r *RestoredSubscriber) OnPublish(message *packets.PublishPacket) error {
+	return nil
+}
+
+// boltProvider keeps the subscription trie and the retained messages in memory like memProvider,
+// and writes each change through to a BoltDB file, the trie is rebuilt from it on startup. Only
+// the subscribers implementing PersistentSubscriber are persisted.
+type boltProvider struct {
+	mem *memProvider
+	db  *bolt.DB
+
+	// Restored subscribers by filter and key
+	mu       sync.Mutex
+	restored map[string]*RestoredSubscriber
+
+	// Retained messages writes
+	rmu sync.Mutex
+
+	smu  sync.Mutex
+	stop chan struct{}
+}
+
+// RegisterBoltTopicsProvider opens the BoltDB file at the path, creating it if needed, and
+// registers the provider as "bolt".
+func RegisterBoltTopicsProvider(path string) error {
+	p, err := NewBoltProvider(path)
+	if err != nil {
+		return err
+	}
+	Register("bolt", p)
+	return nil
+}
+
+func UnRegisterBoltTopicsProvider() {
+	Unregister("bolt")
+}
+
+func NewBoltProvider(path string) (*boltProvider, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("topics/bolt_provider/NewBoltProvider: open %s error: %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(retainedBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(retainedExpiryBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(subscriptionsBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	p := &boltProvider{
+		mem:      NewMemProvider(),
+		db:       db,
+		restored: make(map[string]*RestoredSubscriber),
+	}
+	if err := p.load(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// load rebuilds the trie, the records which cannot be decoded are skipped, CheckConsistency
+// reports them. The messages which have expired meanwhile are purged by the sweeper.
+func (p *boltProvider) load() error {
+	return p.db.View(func(tx *bolt.Tx) error {
+		expiry := tx.Bucket(retainedExpiryBucket)
+		err := tx.Bucket(retainedBucket).ForEach(func(k, v []byte) error {
+			msg, err := decodeRetained(v)
+			if err != nil {
+				return nil
+			}
+			var deadline time.Time
+			if d := expiry.Get(k); d != nil {
+				if deadline, err = decodeDeadline(d); err != nil {
+					return nil
+				}
+			}
+			return p.mem.RetainUntil(msg, deadline)
+		})
+		if err != nil {
+			return err
+		}
+
+		// the subscriptions are inserted as one batch
+		var (
+			subs []Subscription
+			keys []string
+		)
+		err = tx.Bucket(subscriptionsBucket).ForEach(func(k, v []byte) error {
+			filter, key, qos, err := decodeSubscription(k, v)
+			if err != nil {
+				return nil
+			}
+			subs = append(subs, Subscription{Filter: []byte(filter), Qos: qos, Subscriber: &RestoredSubscriber{Key: key}})
+			keys = append(keys, string(k))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		_, errs := p.mem.SubscribeBatch(subs)
+		for i, s := range subs {
+			if errs[i] == nil {
+				p.restored[keys[i]] = s.Subscriber.(*RestoredSubscriber)
+			}
+		}
+		return nil
+	})
+}
+
+func subscriptionKey(filter string, key string) []byte {
+	return []byte(filter + keySep + key)
+}
+
+func decodeSubscription(k, v []byte) (string, string, byte, error) {
+	i := bytes.Index(k, []byte(keySep))
+	if i < 0 {
+		return "", "", 0, errors.New("topics/bolt_provider/decodeSubscription: no key separator")
+	}
+	data, err := storecheck.DecodeRecord(v)
+	if err != nil {
+		return "", "", 0, err
+	}
+	if len(data) != 1 || !ValidQos(data[0]) {
+		return "", "", 0, errors.New("topics/bolt_provider/decodeSubscription: invalid QoS")
+	}
+	return string(k[:i]), string(k[i+len(keySep):]), data[0], nil
+}
+
+// The retained messages are stored as PUBLISH packets in the MQTT wire format.
+func encodeRetained(message *packets.PublishPacket) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := message.Write(&buf); err != nil {
+		return nil, err
+	}
+	return storecheck.EncodeRecord(buf.Bytes()), nil
+}
+
+func decodeRetained(v []byte) (*packets.PublishPacket, error) {
+	data, err := storecheck.DecodeRecord(v)
+	if err != nil {
+		return nil, err
+	}
+	cp, err := packets.ReadPacket(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	msg, ok := cp.(*packets.PublishPacket)
+	if !ok {
+		return nil, errors.New("topics/bolt_provider/decodeRetained: not a publish packet")
+	}
+	return msg, nil
+}
+
+// The deadlines are stored in nanoseconds since the epoch.
+func encodeDeadline(deadline time.Time) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(deadline.UnixNano()))
+	return storecheck.EncodeRecord(buf[:])
+}
+
+func decodeDeadline(v []byte) (time.Time, error) {
+	data, err := storecheck.DecodeRecord(v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(data) != 8 {
+		return time.Time{}, errors.New("topics/bolt_provider/decodeDeadline: invalid deadline")
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data))), nil
+}
+
+func (p *boltProvider) Subscribe(topic []byte, qos byte, sub Subscriber) (byte, error) {
+	res, err := p.SubscribeResult(topic, qos, sub)
+	return res.Qos, err
+}
+
+// SubscribeResult subscribes like Subscribe. The subscription loaded on startup, or restored, for
+// the key of the subscriber is the one it made before the restart, so it existed.
+func (p *boltProvider) SubscribeResult(topic []byte, qos byte, sub Subscriber) (SubscribeResult, error) {
+	res, err := p.mem.SubscribeResult(topic, qos, sub)
+	if err != nil {
+		return res, err
+	}
+
+	ps, ok := sub.(PersistentSubscriber)
+	if !ok {
+		return res, nil
+	}
+
+	k := subscriptionKey(string(topic), ps.SubscriberKey())
+	p.mu.Lock()
+	if r, ok := p.restored[string(k)]; ok && r != sub {
+		_ = p.mem.Unsubscribe(topic, r)
+		delete(p.restored, string(k))
+		res.Existed = true
+	}
+	p.mu.Unlock()
+
+	err = p.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(subscriptionsBucket).Put(k, storecheck.EncodeRecord([]byte{res.Qos}))
+	})
+	if err != nil {
+		return SubscribeResult{Qos: QosFailure}, fmt.Errorf("topics/bolt_provider/Subscribe: persist error: %v", err)
+	}
+	return res, nil
+}
+
+func (p *boltProvider) Unsubscribe(topic []byte, sub Subscriber) error {
+	if err := p.mem.Unsubscribe(topic, sub); err != nil {
+		return err
+	}
+
+	ps, ok := sub.(PersistentSubscriber)
+	if !ok {
+		return nil
+	}
+
+	k := subscriptionKey(string(topic), ps.SubscriberKey())
+	p.mu.Lock()
+	delete(p.restored, string(k))
+	p.mu.Unlock()
+
+	return p.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(subscriptionsBucket).Delete(k)
+	})
+}
+
+func (p *boltProvider) Subscribers(topic []byte, qos byte, subList *[]Subscriber, qosList *[]byte) error {
+	return p.mem.Subscribers(topic, qos, subList, qosList)
+}
+
+func (p *boltProvider) Retain(message *packets.PublishPacket) error {
+	return p.RetainUntil(message, time.Time{})
+}
+
+func (p *boltProvider) SetClock(c clock.Clock) {
+	p.mem.SetClock(c)
+}
+
+// RetainUntil persists the deadline beside the message, so the message still expires after a
+// restart.
+func (p *boltProvider) RetainUntil(message *packets.PublishPacket, deadline time.Time) error {
+	_, err := p.RetainReplace(message, deadline)
+	return err
+}
+
+// RetainReplace writes the message to the file, then swaps it in the trie. The retained messages
+// are written one at a time so the file and the trie change in the same order.
+func (p *boltProvider) RetainReplace(message *packets.PublishPacket, deadline time.Time) (*packets.PublishPacket, error) {
+	topic := []byte(message.TopicName)
+	if err := ValidatePublishTopic(topic); err != nil {
+		return nil, err
+	}
+
+	p.rmu.Lock()
+	defer p.rmu.Unlock()
+
+	if len(message.Payload) == 0 {
+		err := p.db.Update(func(tx *bolt.Tx) error {
+			if err := tx.Bucket(retainedExpiryBucket).Delete(topic); err != nil {
+				return err
+			}
+			return tx.Bucket(retainedBucket).Delete(topic)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("topics/bolt_provider/RetainReplace: persist error: %v", err)
+		}
+		return p.mem.RetainReplace(message, deadline)
+	}
+
+	rec, err := encodeRetained(message)
+	if err != nil {
+		return nil, err
+	}
+	err = p.db.Update(func(tx *bolt.Tx) error {
+		if deadline.IsZero() {
+			if err := tx.Bucket(retainedExpiryBucket).Delete(topic); err != nil {
+				return err
+			}
+		} else if err := tx.Bucket(retainedExpiryBucket).Put(topic, encodeDeadline(deadline)); err != nil {
+			return err
+		}
+		return tx.Bucket(retainedBucket).Put(topic, rec)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("topics/bolt_provider/RetainReplace: persist error: %v", err)
+	}
+
+	return p.mem.RetainReplace(message, deadline)
+}
+
+func (p *boltProvider) Retained(topic []byte, messages *[]*packets.PublishPacket) error {
+	return p.mem.Retained(topic, messages)
+}
+
+func (p *boltProvider) RetainedDeadline(topic string) (time.Time, bool) {
+	return p.mem.RetainedDeadline(topic)
+}
+
+// SweepRetained removes the expired retained messages from the trie and the file, it returns how
+// many were removed. A record is kept if it has been retained again since it was swept.
+func (p *boltProvider) SweepRetained() int {
+	removed := p.mem.sweepRetained()
+	if len(removed) == 0 {
+		return 0
+	}
+
+	_ = p.db.Update(func(tx *bolt.Tx) error {
+		expiry := tx.Bucket(retainedExpiryBucket)
+		for topic, deadline := range removed {
+			d, err := decodeDeadline(expiry.Get([]byte(topic)))
+			if err != nil || !d.Equal(deadline) {
+				continue
+			}
+			if err := expiry.Delete([]byte(topic)); err != nil {
+				return err
+			}
+			if err := tx.Bucket(retainedBucket).Delete([]byte(topic)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return len(removed)
+}
+
+// NotifyExpired sets the handler of the retained messages purged by the sweeper, they're deleted
+// from the file too.
+func (p *boltProvider) NotifyExpired(fn func(message *packets.PublishPacket)) {
+	p.mem.NotifyExpired(fn)
+}
+
+func (p *boltProvider) StartSweeper(interval time.Duration) {
+	p.smu.Lock()
+	defer p.smu.Unlock()
+
+	if p.stop == nil {
+		p.stop = startSweeper(p.mem.clock, interval, func() { p.SweepRetained() })
+	}
+}
+
+// CheckConsistency verifies every record of the file, the broken ones are deleted if repair is
+// set, the trie never held them.
+func (p *boltProvider) CheckConsistency(repair bool) (*storecheck.Report, error) {
+	report := storecheck.NewReport("topics/bolt")
+	action := storecheck.ActionReported
+	if repair {
+		action = storecheck.ActionRepaired
+	}
+
+	check := func(tx *bolt.Tx, name []byte, decode func(k, v []byte) error) error {
+		var broken [][]byte
+		err := tx.Bucket(name).ForEach(func(k, v []byte) error {
+			report.Checked++
+			if err := decode(k, v); err != nil {
+				kind := storecheck.KindUndecodable
+				if errors.Is(err, storecheck.ErrChecksum) {
+					kind = storecheck.KindChecksum
+				}
+				report.Add(string(name)+"/"+strings.Replace(string(k), keySep, "/", 1), kind, action, err.Error())
+				broken = append(broken, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil || !repair {
+			return err
+		}
+		for _, k := range broken {
+			if err := tx.Bucket(name).Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	fn := func(tx *bolt.Tx) error {
+		err := check(tx, retainedBucket, func(k, v []byte) error {
+			_, err := decodeRetained(v)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		err = check(tx, retainedExpiryBucket, func(k, v []byte) error {
+			_, err := decodeDeadline(v)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return check(tx, subscriptionsBucket, func(k, v []byte) error {
+			_, _, _, err := decodeSubscription(k, v)
+			return err
+		})
+	}
+
+	var err error
+	if repair {
+		err = p.db.Update(fn)
+	} else {
+		err = p.db.View(fn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// boltSnapshot holds a read transaction, the writes of the provider go on meanwhile.
+type boltSnapshot struct {
+	tx *bolt.Tx
+	db *bolt.DB
+}
+
+func (s *boltSnapshot) WriteTo(w io.Writer) (int64, error) {
+	return s.tx.WriteTo(w)
+}
+
+func (s *boltSnapshot) Close() error {
+	err := s.tx.Rollback()
+	if s.db != nil {
+		_ = s.db.Close()
+	}
+	return err
+}
+
+// Snapshot returns a copy of the BoltDB file as of now. A write which grows the file waits until
+// the snapshot is closed, the snapshot is meant to be written out right away.
+func (p *boltProvider) Snapshot() (backup.Snapshot, error) {
+	tx, err := p.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return &boltSnapshot{tx: tx}, nil
+}
+
+// OpenBoltSnapshot returns a copy of the BoltDB file of a stopped broker, the file is locked while
+// the broker runs and the open fails after a second.
+func OpenBoltSnapshot(path string) (backup.Snapshot, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("topics/bolt_provider/OpenBoltSnapshot: open %s error: %v", path, err)
+	}
+	tx, err := db.Begin(false)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &boltSnapshot{tx: tx, db: db}, nil
+}
+
+func (p *boltProvider) Close() error {
+	p.smu.Lock()
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+	p.smu.Unlock()
+
+	_ = p.mem.Close()
+	return p.db.Close()
+}
@@ -0,0 +1,201 @@
+package topics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+	"awesomeProject/beacon/mqtt_network/libs/storecheck"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+type keyedSubscriber string
+
+func (k keyedSubscriber) SubscriberKey() string {
+	return string(k)
+}
+
+func (k keyedSubscriber) ID() string {
+	return string(k)
+}
+
+func (k keyedSubscriber) OnPublish(message *packets.PublishPacket) error {
+	return nil
+}
+
+func newQos1RetainedPacket(topic string, payload string) *packets.PublishPacket {
+	msg := newRetainedPacket(topic, payload)
+	msg.Qos = 1
+	msg.MessageID = 1
+	return msg
+}
+
+func TestBoltProviderRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "topics")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "topics.db")
+
+	p, err := NewBoltProvider(path)
+	require.NoError(t, err)
+
+	_, err = p.Subscribe([]byte("a/+"), 1, keyedSubscriber("c1"))
+	require.NoError(t, err)
+	_, err = p.Subscribe([]byte("a/#"), 0, keyedSubscriber("c2"))
+	require.NoError(t, err)
+	require.NoError(t, p.Unsubscribe([]byte("a/#"), keyedSubscriber("c2")))
+	// not persisted
+	_, err = p.Subscribe([]byte("a/#"), 0, testSubscriber("volatile"))
+	require.NoError(t, err)
+
+	require.NoError(t, p.Retain(newQos1RetainedPacket("a/b", "1")))
+	require.NoError(t, p.Retain(newQos1RetainedPacket("a/b", "2")))
+	require.NoError(t, p.Retain(newRetainedPacket("a/c", "3")))
+	require.NoError(t, p.Retain(newRetainedPacket("a/c", "")))
+	require.NoError(t, p.Close())
+
+	p, err = NewBoltProvider(path)
+	require.NoError(t, err)
+	defer p.Close()
+
+	var msgs []*packets.PublishPacket
+	require.NoError(t, p.Retained([]byte("a/#"), &msgs))
+	require.Len(t, msgs, 1)
+	require.Equal(t, "a/b", msgs[0].TopicName)
+	require.Equal(t, []byte("2"), msgs[0].Payload)
+	require.Equal(t, byte(1), msgs[0].Qos)
+
+	var subs []Subscriber
+	var qoss []byte
+	require.NoError(t, p.Subscribers([]byte("a/b"), 1, &subs, &qoss))
+	require.Len(t, subs, 1)
+	require.Equal(t, &RestoredSubscriber{Key: "c1"}, subs[0])
+
+	// the subscriber takes the place of the restored one
+	_, err = p.Subscribe([]byte("a/+"), 0, keyedSubscriber("c1"))
+	require.NoError(t, err)
+	require.NoError(t, p.Subscribers([]byte("a/b"), 1, &subs, &qoss))
+	require.Equal(t, []Subscriber{keyedSubscriber("c1")}, subs)
+
+	report, err := p.CheckConsistency(false)
+	require.NoError(t, err)
+	require.True(t, report.Clean())
+	require.Equal(t, 2, report.Checked)
+}
+
+func TestBoltProviderCheckConsistency(t *testing.T) {
+	dir, err := ioutil.TempDir("", "topics")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, err := NewBoltProvider(filepath.Join(dir, "topics.db"))
+	require.NoError(t, err)
+	defer p.Close()
+
+	require.NoError(t, p.Retain(newRetainedPacket("a/b", "1")))
+	require.NoError(t, p.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(retainedBucket).Put([]byte("a/c"), []byte("garbage")); err != nil {
+			return err
+		}
+		return tx.Bucket(subscriptionsBucket).Put([]byte("a/#"), storecheck.EncodeRecord([]byte{0}))
+	}))
+
+	report, err := p.CheckConsistency(false)
+	require.NoError(t, err)
+	require.Len(t, report.Issues, 2)
+	require.Equal(t, storecheck.KindChecksum, report.Issues[0].Kind)
+	require.Equal(t, storecheck.ActionReported, report.Issues[0].Action)
+
+	report, err = p.CheckConsistency(true)
+	require.NoError(t, err)
+	require.Len(t, report.Issues, 2)
+	require.Equal(t, storecheck.ActionRepaired, report.Issues[1].Action)
+
+	report, err = p.CheckConsistency(false)
+	require.NoError(t, err)
+	require.True(t, report.Clean())
+}
+
+func TestBoltProviderRetainExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "topics")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "topics.db")
+
+	mock := clock.NewMock(time.Unix(1584662400, 0))
+	p, err := NewBoltProvider(path)
+	require.NoError(t, err)
+	p.SetClock(mock)
+
+	require.NoError(t, p.RetainUntil(newRetainedPacket("a/b", "1"), mock.Now().Add(time.Minute)))
+	require.NoError(t, p.RetainUntil(newRetainedPacket("a/c", "2"), mock.Now().Add(time.Hour)))
+	require.NoError(t, p.Close())
+
+	// the deadlines are kept across the restart
+	p, err = NewBoltProvider(path)
+	require.NoError(t, err)
+	defer p.Close()
+	p.SetClock(mock)
+	mock.Add(2 * time.Minute)
+
+	var msgs []*packets.PublishPacket
+	require.NoError(t, p.Retained([]byte("a/#"), &msgs))
+	require.Len(t, msgs, 1)
+	require.Equal(t, "a/c", msgs[0].TopicName)
+	deadline, ok := p.RetainedDeadline("a/c")
+	require.True(t, ok)
+	require.Equal(t, time.Unix(1584662400, 0).Add(time.Hour), deadline)
+
+	require.Equal(t, 1, p.SweepRetained())
+	err = p.db.View(func(tx *bolt.Tx) error {
+		require.Nil(t, tx.Bucket(retainedBucket).Get([]byte("a/b")))
+		require.Nil(t, tx.Bucket(retainedExpiryBucket).Get([]byte("a/b")))
+		require.NotNil(t, tx.Bucket(retainedExpiryBucket).Get([]byte("a/c")))
+		return nil
+	})
+	require.NoError(t, err)
+
+	report, err := p.CheckConsistency(false)
+	require.NoError(t, err)
+	require.True(t, report.Clean())
+}
+
+func TestBoltProviderSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "topics")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, err := NewBoltProvider(filepath.Join(dir, "topics.db"))
+	require.NoError(t, err)
+	require.NoError(t, p.Retain(newRetainedPacket("a/b", "1")))
+
+	s, err := p.Snapshot()
+	require.NoError(t, err)
+	copyPath := filepath.Join(dir, "copy.db")
+	f, err := os.Create(copyPath)
+	require.NoError(t, err)
+	_, err = s.WriteTo(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, s.Close())
+	require.NoError(t, p.Retain(newRetainedPacket("a/c", "2")))
+
+	// the file of the running provider is locked
+	_, err = OpenBoltSnapshot(filepath.Join(dir, "topics.db"))
+	require.Error(t, err)
+	require.NoError(t, p.Close())
+
+	p, err = NewBoltProvider(copyPath)
+	require.NoError(t, err)
+	defer p.Close()
+	var msgs []*packets.PublishPacket
+	require.NoError(t, p.Retained([]byte("a/#"), &msgs))
+	require.Len(t, msgs, 1)
+	require.Equal(t, "a/b", msgs[0].TopicName)
+}
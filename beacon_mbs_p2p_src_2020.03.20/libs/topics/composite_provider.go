@@ -0,0 +1,310 @@
+package topics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+	"awesomeProject/beacon/mqtt_network/libs/storecheck"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+var (
+	_ TheTopicsProvider       = (*compositeProvider)(nil)
+	_ ExpiringProvider        = (*compositeProvider)(nil)
+	_ ExpiryNotifyingProvider = (*compositeProvider)(nil)
+	_ ReplacingProvider       = (*compositeProvider)(nil)
+)
+
+// Route sends the topics under the filter to the provider, the filter is a topic prefix
+// followed by the multi-level wildcard (such as "state/#").
+type Route struct {
+	Filter   string
+	Provider TheTopicsProvider
+}
+
+type compositeRoute struct {
+	prefix   string
+	provider TheTopicsProvider
+}
+
+// compositeProvider routes the topic prefixes to different providers, so the persistence cost is
+// paid only for the prefixes where durability matters (e.g. "state/#" to a persistent provider
+// and "telemetry/#" to the memory only one). The longest matching route wins, the topics matched
+// by no route go to the fallback provider.
+//
+// A retained message is stored by the provider of its topic. A subscription is stored by the
+// provider of the longest route containing all the topics of its filter, the filters spanning
+// several routes (such as "#" or "+/x") are stored by the fallback provider.
+//
+// The expiry and the replacement of the retained messages go to the provider of their topic, the
+// composite keeps the deadlines of the providers which are not ExpiringProvider. It has no apply
+// log: the providers order their own mutations only, so Manager.OnMutation refuses it.
+type compositeProvider struct {
+	// Sorted by descending prefix length
+	routes   []compositeRoute
+	fallback TheTopicsProvider
+
+	clock   clock.Clock
+	expiry  retainExpiry
+	expired func(message *packets.PublishPacket)
+}
+
+// NewCompositeProvider returns a provider routing the topic prefixes to the providers of the
+// routes, register it with Register to use it by name.
+func NewCompositeProvider(fallback TheTopicsProvider, routes ...Route) (*compositeProvider, error) {
+	if fallback == nil {
+		return nil, fmt.Errorf("topics/composite_provider/NewCompositeProvider: fallback provider cannot be nil")
+	}
+
+	c := &compositeProvider{fallback: fallback}
+	seen := make(map[string]bool, len(routes))
+	for _, r := range routes {
+		if r.Provider == nil {
+			return nil, fmt.Errorf("topics/composite_provider/NewCompositeProvider: provider of route %s cannot be nil", r.Filter)
+		}
+
+		prefix := strings.TrimSuffix(r.Filter, SEP+MWC)
+		if prefix == r.Filter || len(prefix) == 0 || strings.ContainsAny(prefix, _WC) {
+			return nil, fmt.Errorf("topics/composite_provider/NewCompositeProvider: route %s is not a topic prefix followed by /#", r.Filter)
+		}
+
+		if seen[prefix] {
+			return nil, fmt.Errorf("topics/composite_provider/NewCompositeProvider: duplicated route %s", r.Filter)
+		}
+		seen[prefix] = true
+
+		c.routes = append(c.routes, compositeRoute{prefix: prefix, provider: r.Provider})
+	}
+
+	sort.SliceStable(c.routes, func(i, j int) bool { return len(c.routes[i].prefix) > len(c.routes[j].prefix) })
+
+	return c, nil
+}
+
+// The filter "a/b/#" matches "a/b" too, so the prefix itself is under the route.
+func underPrefix(topic string, prefix string) bool {
+	return topic == prefix || strings.HasPrefix(topic, prefix+SEP)
+}
+
+// Both the topic names and the filters are routed by their leading levels, the wildcards can never
+// be under a prefix since the prefixes have no wildcard.
+func (c *compositeProvider) route(topic []byte) TheTopicsProvider {
+	t := string(topic)
+	for _, r := range c.routes {
+		if underPrefix(t, r.prefix) {
+			return r.provider
+		}
+	}
+	return c.fallback
+}
+
+// The distinct providers, the fallback one first.
+func (c *compositeProvider) providers() []TheTopicsProvider {
+	list := []TheTopicsProvider{c.fallback}
+	for _, r := range c.routes {
+		dup := false
+		for _, p := range list {
+			if p == r.provider {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			list = append(list, r.provider)
+		}
+	}
+	return list
+}
+
+// The shared subscriptions are routed by their filter without the share group.
+func (c *compositeProvider) Subscribe(topic []byte, qos byte, subscriber Subscriber) (byte, error) {
+	_, filter, _, _ := ParseSharedFilter(topic)
+	return c.route(filter).Subscribe(topic, qos, subscriber)
+}
+
+func (c *compositeProvider) Unsubscribe(topic []byte, subscriber Subscriber) error {
+	_, filter, _, _ := ParseSharedFilter(topic)
+	return c.route(filter).Unsubscribe(topic, subscriber)
+}
+
+// The topic may be matched by the filters stored in any route containing it (not only the
+// longest one) and in the fallback provider, the subscribers of all of them are merged.
+func (c *compositeProvider) Subscribers(topic []byte, qos byte, subList *[]Subscriber, qosList *[]byte) error {
+	if !ValidQos(qos) {
+		return fmt.Errorf("topics/composite_provider/Subscribers: Invalid QoS %d", qos)
+	}
+
+	*subList = (*subList)[0:0]
+	*qosList = (*qosList)[0:0]
+
+	t := string(topic)
+	var subs []Subscriber
+	var qoss []byte
+	for _, p := range c.providers() {
+		if p != c.fallback && !c.holdsFiltersOf(p, t) {
+			continue
+		}
+
+		if err := p.Subscribers(topic, qos, &subs, &qoss); err != nil {
+			return err
+		}
+		*subList = append(*subList, subs...)
+		*qosList = append(*qosList, qoss...)
+	}
+
+	return nil
+}
+
+func (c *compositeProvider) holdsFiltersOf(p TheTopicsProvider, topic string) bool {
+	for _, r := range c.routes {
+		if r.provider == p && underPrefix(topic, r.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compositeProvider) Retain(message *packets.PublishPacket) error {
+	return c.RetainUntil(message, time.Time{})
+}
+
+// SetClock sets the clock of the providers keeping the deadlines, and of the deadlines kept by the
+// composite.
+func (c *compositeProvider) SetClock(cl clock.Clock) {
+	c.clock = cl
+	for _, p := range c.providers() {
+		if e, ok := p.(ExpiringProvider); ok {
+			e.SetClock(cl)
+		}
+	}
+}
+
+// RetainUntil retains the message in the provider of its topic until the deadline, the composite
+// keeps the deadline if the provider can't.
+func (c *compositeProvider) RetainUntil(message *packets.PublishPacket, deadline time.Time) error {
+	p := c.route([]byte(message.TopicName))
+	if e, ok := p.(ExpiringProvider); ok {
+		return e.RetainUntil(message, deadline)
+	}
+	if err := p.Retain(message); err != nil {
+		return err
+	}
+	c.expiry.set(message.TopicName, deadline)
+	return nil
+}
+
+// RetainReplace retains the message like RetainUntil and returns the message it replaced, the swap
+// is atomic if the provider of the topic is a ReplacingProvider.
+func (c *compositeProvider) RetainReplace(message *packets.PublishPacket, deadline time.Time) (*packets.PublishPacket, error) {
+	if r, ok := c.route([]byte(message.TopicName)).(ReplacingProvider); ok {
+		return r.RetainReplace(message, deadline)
+	}
+
+	var previous []*packets.PublishPacket
+	if err := c.Retained([]byte(message.TopicName), &previous); err != nil {
+		return nil, err
+	}
+	if err := c.RetainUntil(message, deadline); err != nil {
+		return nil, err
+	}
+	if len(previous) > 0 {
+		return previous[0], nil
+	}
+	return nil, nil
+}
+
+func (c *compositeProvider) RetainedDeadline(topic string) (time.Time, bool) {
+	if e, ok := c.route([]byte(topic)).(ExpiringProvider); ok {
+		return e.RetainedDeadline(topic)
+	}
+	return c.expiry.get(topic)
+}
+
+// StartSweeper starts the sweepers of the providers keeping the deadlines, the messages expired in
+// the other ones are dropped when they are matched.
+func (c *compositeProvider) StartSweeper(interval time.Duration) {
+	for _, p := range c.providers() {
+		if e, ok := p.(ExpiringProvider); ok {
+			e.StartSweeper(interval)
+		}
+	}
+}
+
+func (c *compositeProvider) NotifyExpired(fn func(message *packets.PublishPacket)) {
+	c.expired = fn
+	for _, p := range c.providers() {
+		if n, ok := p.(ExpiryNotifyingProvider); ok {
+			n.NotifyExpired(fn)
+		}
+	}
+}
+
+// The filter may span several routes, so every provider is asked and only the messages it owns
+// are kept. A provider shared by several routes (or being the fallback too) may have messages
+// written before the routes changed, those are skipped as well.
+func (c *compositeProvider) Retained(topic []byte, messages *[]*packets.PublishPacket) error {
+	for _, p := range c.providers() {
+		var list []*packets.PublishPacket
+		if err := p.Retained(topic, &list); err != nil {
+			return err
+		}
+
+		owned := list[:0]
+		for _, msg := range list {
+			if c.route([]byte(msg.TopicName)) == p {
+				owned = append(owned, msg)
+			}
+		}
+		if _, ok := p.(ExpiringProvider); !ok {
+			c.expiry.drop(clock.OrReal(c.clock).Now(), p, &owned, c.expired)
+		}
+		*messages = append(*messages, owned...)
+	}
+
+	return nil
+}
+
+// CheckConsistency checks the providers persisting their state, the issues are merged into one
+// report.
+func (c *compositeProvider) CheckConsistency(repair bool) (*storecheck.Report, error) {
+	var report *storecheck.Report
+	for _, p := range c.providers() {
+		checker, ok := p.(storecheck.Checker)
+		if !ok {
+			continue
+		}
+
+		r, err := checker.CheckConsistency(repair)
+		if err != nil {
+			return nil, err
+		}
+		if r == nil {
+			continue
+		}
+
+		if report == nil {
+			report = storecheck.NewReport("composite")
+		}
+		report.Checked += r.Checked
+		for _, issue := range r.Issues {
+			issue.Key = r.Store + ":" + issue.Key
+			report.Issues = append(report.Issues, issue)
+		}
+	}
+
+	return report, nil
+}
+
+func (c *compositeProvider) Close() error {
+	var first error
+	for _, p := range c.providers() {
+		if err := p.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,148 @@
+package topics
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompositeProviderRoutes(t *testing.T) {
+	_, err := NewCompositeProvider(nil)
+	require.Error(t, err)
+	_, err = NewCompositeProvider(NewMemProvider(), Route{Filter: "state", Provider: NewMemProvider()})
+	require.Error(t, err)
+	_, err = NewCompositeProvider(NewMemProvider(), Route{Filter: "state/+/#", Provider: NewMemProvider()})
+	require.Error(t, err)
+	_, err = NewCompositeProvider(NewMemProvider(), Route{Filter: "state/#", Provider: nil})
+	require.Error(t, err)
+
+	fallback, state, critical := NewMemProvider(), NewMemProvider(), NewMemProvider()
+	c, err := NewCompositeProvider(fallback,
+		Route{Filter: "state/#", Provider: state},
+		Route{Filter: "state/critical/#", Provider: critical},
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, c.Retain(newRetainedPacket("state/d1", "s1")))
+	require.NoError(t, c.Retain(newRetainedPacket("state/critical/d1", "c1")))
+	require.NoError(t, c.Retain(newRetainedPacket("telemetry/d1", "t1")))
+
+	var list []*packets.PublishPacket
+	require.NoError(t, state.Retained([]byte("#"), &list))
+	require.Len(t, list, 1)
+	require.Equal(t, "state/d1", list[0].TopicName)
+
+	list = nil
+	require.NoError(t, critical.Retained([]byte("#"), &list))
+	require.Len(t, list, 1)
+	require.Equal(t, "state/critical/d1", list[0].TopicName)
+
+	list = nil
+	require.NoError(t, c.Retained([]byte("#"), &list))
+	require.Len(t, list, 3)
+
+	list = nil
+	require.NoError(t, c.Retained([]byte("state/+/d1"), &list))
+	require.Len(t, list, 1)
+	require.Equal(t, "c1", string(list[0].Payload))
+
+	require.NoError(t, c.Retain(newRetainedPacket("state/d1", "")))
+	list = nil
+	require.NoError(t, c.Retained([]byte("state/#"), &list))
+	require.Len(t, list, 1)
+}
+
+func TestCompositeProviderSubscribers(t *testing.T) {
+	fallback, state, critical := NewMemProvider(), NewMemProvider(), NewMemProvider()
+	c, err := NewCompositeProvider(fallback,
+		Route{Filter: "state/#", Provider: state},
+		Route{Filter: "state/critical/#", Provider: critical},
+	)
+	require.NoError(t, err)
+
+	subs := map[string]string{
+		"all":      "#",
+		"state":    "state/#",
+		"level":    "state/+/d1",
+		"critical": "state/critical/#",
+		"cross":    "+/critical/d1",
+	}
+	for sub, filter := range subs {
+		_, err := c.Subscribe([]byte(filter), QosAtLeastOnce, testSubscriber(sub))
+		require.NoError(t, err)
+	}
+
+	var subList []Subscriber
+	var qosList []byte
+	require.NoError(t, fallback.Subscribers([]byte("state/critical/d1"), QosAtLeastOnce, &subList, &qosList))
+	require.Len(t, subList, 2)
+
+	matched := func(topic string) []string {
+		require.NoError(t, c.Subscribers([]byte(topic), QosAtLeastOnce, &subList, &qosList))
+		require.Len(t, qosList, len(subList))
+		names := make([]string, 0, len(subList))
+		for _, s := range subList {
+			names = append(names, s.ID())
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	require.Equal(t, []string{"all", "critical", "cross", "level", "state"}, matched("state/critical/d1"))
+	require.Equal(t, []string{"all", "state"}, matched("state/d1"))
+	require.Equal(t, []string{"all"}, matched("telemetry/d1"))
+
+	require.NoError(t, c.Unsubscribe([]byte("state/+/d1"), testSubscriber("level")))
+	require.Equal(t, []string{"all", "critical", "cross", "state"}, matched("state/critical/d1"))
+}
+
+// plainProvider hides the optional interfaces of the provider.
+type plainProvider struct {
+	TheTopicsProvider
+}
+
+func TestCompositeProviderExpiry(t *testing.T) {
+	mock := clock.NewMock(time.Unix(1584662400, 0))
+	fallback, legacy := NewMemProvider(), NewMemProvider()
+	c, err := NewCompositeProvider(fallback, Route{Filter: "legacy/#", Provider: plainProvider{legacy}})
+	require.NoError(t, err)
+	m := &Manager{ttp: c}
+	m.SetClock(mock)
+	var expired []string
+	m.NotifyExpired(func(message *packets.PublishPacket) {
+		expired = append(expired, message.TopicName)
+	})
+
+	// the expiry goes to the provider of the topic, or is kept by the composite
+	require.NoError(t, m.RetainWithExpiry(newRetainedPacket("state/d1", "s1"), time.Minute))
+	require.NoError(t, m.RetainWithExpiry(newRetainedPacket("legacy/d1", "l1"), time.Minute))
+	deadline, ok := fallback.RetainedDeadline("state/d1")
+	require.True(t, ok)
+	require.Equal(t, mock.Now().Add(time.Minute), deadline)
+	left, ok := m.RetainedExpiry("legacy/d1")
+	require.True(t, ok)
+	require.Equal(t, time.Minute, left)
+
+	replaced, err := m.RetainReplace(newRetainedPacket("legacy/d1", "l2"), time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "l1", string(replaced.Payload))
+
+	mock.Add(2 * time.Minute)
+	var list []*packets.PublishPacket
+	require.NoError(t, m.Retained([]byte("#"), &list))
+	require.Empty(t, list)
+	require.Equal(t, []string{"legacy/d1"}, expired)
+
+	// the expired message has been removed from its provider
+	list = nil
+	require.NoError(t, legacy.Retained([]byte("#"), &list))
+	require.Empty(t, list)
+
+	// the composite has no apply log
+	require.Error(t, m.OnMutation(func(Mutation) {}))
+}
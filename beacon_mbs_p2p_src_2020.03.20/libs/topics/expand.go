@@ -0,0 +1,137 @@
+package topics
+
+import (
+	"container/list"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// KnownTopic is a concrete topic matched by a filter, it's known either because it has a retained
+// message or because it was published recently.
+type KnownTopic struct {
+	Topic       string    `json:"topic"`
+	Retained    bool      `json:"retained"`
+	LastPublish time.Time `json:"last_publish,omitempty"`
+	Publishes   uint64    `json:"publishes,omitempty"`
+}
+
+type recentTopic struct {
+	topic     string
+	last      time.Time
+	publishes uint64
+}
+
+// recentTopics keeps the most recently published topics, the least recently published one is
+// dropped once the limit is reached.
+type recentTopics struct {
+	mu     sync.Mutex
+	limit  int
+	order  *list.List
+	topics map[string]*list.Element
+}
+
+func newRecentTopics(limit int) *recentTopics {
+	return &recentTopics{
+		limit:  limit,
+		order:  list.New(),
+		topics: make(map[string]*list.Element),
+	}
+}
+
+func (r *recentTopics) observe(topic string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.topics[topic]; ok {
+		rt := e.Value.(*recentTopic)
+		rt.last = now
+		rt.publishes++
+		r.order.MoveToFront(e)
+		return
+	}
+
+	r.topics[topic] = r.order.PushFront(&recentTopic{topic: topic, last: now, publishes: 1})
+	for r.order.Len() > r.limit {
+		e := r.order.Back()
+		delete(r.topics, e.Value.(*recentTopic).topic)
+		r.order.Remove(e)
+	}
+}
+
+func (r *recentTopics) match(filter []byte) []recentTopic {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var matched []recentTopic
+	for e := r.order.Front(); e != nil; e = e.Next() {
+		rt := e.Value.(*recentTopic)
+		if ok, _ := MatchTopic(filter, []byte(rt.topic)); ok {
+			matched = append(matched, *rt)
+		}
+	}
+	return matched
+}
+
+// SetRecentTopics keeps the last limit published topics (reported by ObservePublish) for Expand,
+// a limit of 0 disables it.
+func (m *Manager) SetRecentTopics(limit int) {
+	if limit <= 0 {
+		m.recent = nil
+		return
+	}
+	m.recent = newRecentTopics(limit)
+}
+
+// ObservePublish records the topic as recently published.
+func (m *Manager) ObservePublish(topic string, now time.Time) {
+	if m.recent != nil {
+		m.recent.observe(topic, now)
+	}
+}
+
+// Expand returns the concrete topics currently known to be matched by the filter, from the
+// retained messages and the recently published topics, sorted by topic. A topic published long
+// ago without a retained message is unknown, so an empty result does not prove the filter can
+// never match.
+func (m *Manager) Expand(filter []byte) ([]KnownTopic, error) {
+	if len(filter) == 0 {
+		return nil, errors.New("topic_provider: filter cannot be empty")
+	}
+	if err := checkFilter(filter); err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]*KnownTopic)
+
+	var retainedList []*packets.PublishPacket
+	if err := m.Retained(filter, &retainedList); err != nil {
+		return nil, err
+	}
+	for _, msg := range retainedList {
+		known[msg.TopicName] = &KnownTopic{Topic: msg.TopicName, Retained: true}
+	}
+
+	if m.recent != nil {
+		for _, rt := range m.recent.match(filter) {
+			kt, ok := known[rt.topic]
+			if !ok {
+				kt = &KnownTopic{Topic: rt.topic}
+				known[rt.topic] = kt
+			}
+			kt.LastPublish = rt.last
+			kt.Publishes = rt.publishes
+		}
+	}
+
+	list := make([]KnownTopic, 0, len(known))
+	for _, kt := range known {
+		list = append(list, *kt)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Topic < list[j].Topic })
+
+	return list, nil
+}
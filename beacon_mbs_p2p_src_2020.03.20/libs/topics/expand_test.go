@@ -0,0 +1,49 @@
+package topics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpand(t *testing.T) {
+	m := &Manager{ttp: NewMemProvider()}
+
+	_, err := m.Expand(nil)
+	require.Error(t, err)
+	_, err = m.Expand([]byte("devices/#/state"))
+	require.Error(t, err)
+
+	require.NoError(t, m.Retain(newRetainedPacket("devices/d1/state", "on")))
+	require.NoError(t, m.Retain(newRetainedPacket("devices/d2/config", "{}")))
+
+	// The recent topics are not kept until enabled
+	now := time.Unix(1584662400, 0)
+	m.ObservePublish("devices/d3/state", now)
+
+	list, err := m.Expand([]byte("devices/+/state"))
+	require.NoError(t, err)
+	require.Equal(t, []KnownTopic{{Topic: "devices/d1/state", Retained: true}}, list)
+
+	m.SetRecentTopics(2)
+	m.ObservePublish("devices/d1/state", now)
+	m.ObservePublish("devices/d3/state", now)
+	m.ObservePublish("devices/d3/state", now.Add(time.Second))
+	m.ObservePublish("other/d3/state", now)
+
+	list, err = m.Expand([]byte("devices/+/state"))
+	require.NoError(t, err)
+	require.Equal(t, []KnownTopic{
+		{Topic: "devices/d1/state", Retained: true},
+		{Topic: "devices/d3/state", LastPublish: now.Add(time.Second), Publishes: 2},
+	}, list)
+
+	list, err = m.Expand([]byte("#"))
+	require.NoError(t, err)
+	require.Len(t, list, 4)
+
+	list, err = m.Expand([]byte("sensors/#"))
+	require.NoError(t, err)
+	require.Len(t, list, 0)
+}
@@ -0,0 +1,68 @@
+package topics
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrFilterLimit is the error of a subscription whose filter is past the filter limits.
+var ErrFilterLimit = errors.New("topics/filter_limits: the topic filter is past the limits")
+
+// FilterLimits bounds the topic filters of the subscriptions of the memory provider, so a client
+// can't grow the subscription trie with pathological filters, deep or long or made of wildcards.
+// The limits apply to the filter of a shared subscription without its $share prefix. 0 is
+// unlimited.
+type FilterLimits struct {
+	MaxLevels    int `json:"max_levels" yaml:"max_levels"`
+	MaxLength    int `json:"max_length" yaml:"max_length"`
+	MaxWildcards int `json:"max_wildcards" yaml:"max_wildcards"`
+}
+
+func (l FilterLimits) Validate() error {
+	if l.MaxLevels < 0 || l.MaxLength < 0 || l.MaxWildcards < 0 {
+		return errors.New("topics/filter_limits/Validate: the limits can't be negative")
+	}
+	return nil
+}
+
+func (l FilterLimits) enabled() bool {
+	return l.MaxLevels > 0 || l.MaxLength > 0 || l.MaxWildcards > 0
+}
+
+// Check returns an error wrapping ErrFilterLimit if the filter is past one of the limits.
+func (l FilterLimits) Check(filter []byte) error {
+	if l.MaxLength > 0 && len(filter) > l.MaxLength {
+		return fmt.Errorf("%w: filter of %d bytes, the max is %d", ErrFilterLimit, len(filter), l.MaxLength)
+	}
+
+	levels, wildcards := 1, 0
+	for i, c := range filter {
+		switch c {
+		case '/':
+			levels++
+		case MWC[0], SWC[0]:
+			// the wildcards occupy an entire level, ValidateTopicFilter checked it
+			if i == 0 || filter[i-1] == '/' {
+				wildcards++
+			}
+		}
+	}
+	if l.MaxLevels > 0 && levels > l.MaxLevels {
+		return fmt.Errorf("%w: filter of %d levels, the max is %d", ErrFilterLimit, levels, l.MaxLevels)
+	}
+	if l.MaxWildcards > 0 && wildcards > l.MaxWildcards {
+		return fmt.Errorf("%w: filter of %d wildcards, the max is %d", ErrFilterLimit, wildcards, l.MaxWildcards)
+	}
+	return nil
+}
+
+// WithFilterLimits bounds the filters of the subscriptions, it's ignored if no limit is set. A
+// subscription past the limits is refused with an error wrapping ErrFilterLimit, the restored
+// subscriptions too.
+func WithFilterLimits(l FilterLimits) MemOption {
+	return func(m *memProvider) {
+		if l.enabled() {
+			m.filterLimits = &l
+		}
+	}
+}
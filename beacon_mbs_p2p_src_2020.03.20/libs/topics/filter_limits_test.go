@@ -0,0 +1,43 @@
+package topics
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterLimitsCheck(t *testing.T) {
+	l := FilterLimits{MaxLevels: 3, MaxLength: 16, MaxWildcards: 1}
+
+	require.NoError(t, l.Check([]byte("a/b/c")))
+	require.NoError(t, l.Check([]byte("a/+/c")))
+	require.NoError(t, l.Check([]byte("#")))
+	require.True(t, errors.Is(l.Check([]byte("a/b/c/d")), ErrFilterLimit))
+	require.True(t, errors.Is(l.Check([]byte("abcdefghijklmnopq")), ErrFilterLimit))
+	require.True(t, errors.Is(l.Check([]byte("+/+")), ErrFilterLimit))
+
+	require.NoError(t, FilterLimits{}.Check([]byte("+/+/+/+/+/#")))
+	require.Error(t, FilterLimits{MaxLevels: -1}.Validate())
+}
+
+func TestFilterLimitsSubscribe(t *testing.T) {
+	p := NewMemProvider(WithFilterLimits(FilterLimits{MaxLevels: 2, MaxWildcards: 1}))
+
+	qos, err := p.Subscribe([]byte("a/+"), 1, testSubscriber("sub1"))
+	require.NoError(t, err)
+	require.Equal(t, byte(1), qos)
+
+	qos, err = p.Subscribe([]byte("a/b/c"), 1, testSubscriber("sub1"))
+	require.True(t, errors.Is(err, ErrFilterLimit))
+	require.Equal(t, byte(QosFailure), qos)
+
+	_, err = p.Subscribe([]byte("+/+"), 1, testSubscriber("sub1"))
+	require.True(t, errors.Is(err, ErrFilterLimit))
+
+	// the limits apply to the filter of the shared subscription
+	_, err = p.Subscribe([]byte("$share/group/a/+"), 1, testSubscriber("sub2"))
+	require.NoError(t, err)
+	_, err = p.Subscribe([]byte("$share/group/a/b/c"), 1, testSubscriber("sub2"))
+	require.True(t, errors.Is(err, ErrFilterLimit))
+}
@@ -0,0 +1,130 @@
+//go:build go1.18
+// +build go1.18
+
+package topics
+
+import (
+	"testing"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics/matcher"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// The edge cases of the wildcards and of the separators, the seeds of the fuzz targets
+var fuzzSeeds = [][2]string{
+	{"#", "a"},
+	{"+", "a"},
+	{"a/+/c", "a/b/c"},
+	{"a/#", "a"},
+	{"a/#", "a/b/c"},
+	{"#/foo", "a/foo"},
+	{"++", "a"},
+	{"a/b#", "a/b"},
+	{"/a", "/a"},
+	{"+/a", "/a"},
+	{"a//b", "a//b"},
+	{"a/", "a"},
+	{"$SYS/#", "$SYS/uptime"},
+	{"+/uptime", "$SYS/uptime"},
+	{"$share/g/a/+", "a/b"},
+	{"$share//a", "a"},
+	{"", ""},
+}
+
+// nextTopicLevel always returns an error or a shorter remainder.
+func FuzzNextTopicLevel(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s[0]))
+	}
+	f.Fuzz(func(t *testing.T, topic []byte) {
+		for rem := topic; len(rem) > 0; {
+			_, next, err := nextTopicLevel(rem)
+			if err != nil {
+				return
+			}
+			if len(next) >= len(rem) {
+				t.Fatalf("nextTopicLevel(%q) returned the remainder %q", rem, next)
+			}
+			rem = next
+		}
+	})
+}
+
+// The subscription trie matches the topics like matcher.Match, and is empty once the subscription
+// is removed.
+func FuzzSubscriptionTrie(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s[0], s[1])
+	}
+	f.Fuzz(func(t *testing.T, filter string, topic string) {
+		p := NewMemProvider()
+		defer p.Close()
+		if _, err := p.Subscribe([]byte(filter), 1, testSubscriber("s")); err != nil {
+			return
+		}
+
+		if ValidatePublishTopic([]byte(topic)) == nil {
+			var subs []Subscriber
+			var qoss []byte
+			if err := p.Subscribers([]byte(topic), 1, &subs, &qoss); err != nil {
+				t.Fatalf("Subscribers(%q) => %v", topic, err)
+			}
+			_, rest, _, _ := ParseSharedFilter([]byte(filter))
+			want, err := matcher.Match(rest, []byte(topic))
+			if err != nil {
+				t.Fatalf("Match(%q, %q) => %v", rest, topic, err)
+			}
+			if got := len(subs) > 0; got != want {
+				t.Fatalf("filter %q, topic %q: matched %v, want %v", filter, topic, got, want)
+			}
+		}
+
+		if err := p.Unsubscribe([]byte(filter), testSubscriber("s")); err != nil {
+			t.Fatalf("Unsubscribe(%q) => %v", filter, err)
+		}
+		if root := p.root(); len(root.subscribeNodesMap) > 0 || root.subSet.len() > 0 {
+			t.Fatalf("filter %q: the trie is not empty once unsubscribed", filter)
+		}
+	})
+}
+
+// The retained messages are found by the filters matching their topic, and the trie is empty once
+// the message is cleared. A filter ending with # matches its parent level too [MQTT-4.7.1-2].
+func FuzzRetainMatch(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s[1], s[0])
+	}
+	f.Fuzz(func(t *testing.T, topic string, filter string) {
+		p := NewMemProvider()
+		defer p.Close()
+		msg := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		msg.TopicName = topic
+		msg.Payload = []byte("x")
+		if err := p.Retain(msg); err != nil {
+			return
+		}
+
+		if ValidateTopicFilter([]byte(filter)) == nil {
+			var list []*packets.PublishPacket
+			if err := p.Retained([]byte(filter), &list); err != nil {
+				t.Fatalf("Retained(%q) => %v", filter, err)
+			}
+			if want, _ := matcher.Match([]byte(filter), []byte(topic)); want && len(list) == 0 {
+				t.Fatalf("filter %q doesn't find the message of %q", filter, topic)
+			}
+		}
+
+		msg.Payload = nil
+		if err := p.Retain(msg); err != nil {
+			t.Fatalf("clear %q => %v", topic, err)
+		}
+		var list []*packets.PublishPacket
+		if err := p.Retained([]byte(MWC), &list); err != nil || len(list) > 0 {
+			t.Fatalf("topic %q: the message is still retained once cleared", topic)
+		}
+		if len(p.retainedRoot.retainNodesMap) > 0 {
+			t.Fatalf("topic %q: the trie is not empty once cleared", topic)
+		}
+	})
+}
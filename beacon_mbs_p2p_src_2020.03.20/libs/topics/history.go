@@ -0,0 +1,116 @@
+package topics
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// HistoryDepth is the number of the retained message versions kept for the topics matched by the
+// filter. If several filters match a topic, the longest one wins.
+type HistoryDepth struct {
+	Filter string `json:"filter"`
+	Depth  int    `json:"depth"`
+}
+
+// RetainedVersion is a version of the retained message of a topic, Message is nil if the version
+// removed the retained message.
+type RetainedVersion struct {
+	Version uint64                 `json:"version"`
+	Time    time.Time              `json:"time"`
+	Message *packets.PublishPacket `json:"-"`
+}
+
+type topicHistory struct {
+	version  uint64
+	versions []RetainedVersion
+}
+
+type retainHistory struct {
+	mu     sync.RWMutex
+	depths []HistoryDepth
+	topics map[string]*topicHistory
+}
+
+func newRetainHistory(depths []HistoryDepth) (*retainHistory, error) {
+	for _, d := range depths {
+		if d.Depth < 1 {
+			return nil, errors.New("topics/history/newRetainHistory: depth must be positive for filter " + d.Filter)
+		}
+		if err := checkFilter([]byte(d.Filter)); err != nil {
+			return nil, err
+		}
+	}
+
+	return &retainHistory{
+		depths: depths,
+		topics: make(map[string]*topicHistory),
+	}, nil
+}
+
+func (h *retainHistory) depth(topic string) int {
+	depth, longest := 0, -1
+	for _, d := range h.depths {
+		if ok, _ := MatchTopic([]byte(d.Filter), []byte(topic)); ok && len(d.Filter) > longest {
+			depth, longest = d.Depth, len(d.Filter)
+		}
+	}
+	return depth
+}
+
+func (h *retainHistory) record(message *packets.PublishPacket, now time.Time) {
+	depth := h.depth(message.TopicName)
+	if depth == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	th, ok := h.topics[message.TopicName]
+	if !ok {
+		th = &topicHistory{}
+		h.topics[message.TopicName] = th
+	}
+
+	th.version++
+	v := RetainedVersion{Version: th.version, Time: now}
+	if len(message.Payload) > 0 {
+		v.Message = message
+	}
+
+	th.versions = append(th.versions, v)
+	if n := len(th.versions); n > depth {
+		th.versions = append(th.versions[:0], th.versions[n-depth:]...)
+	}
+}
+
+// Returns the versions newest first.
+func (h *retainHistory) get(topic string) []RetainedVersion {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	th, ok := h.topics[topic]
+	if !ok {
+		return nil
+	}
+
+	list := make([]RetainedVersion, len(th.versions))
+	for i, v := range th.versions {
+		list[len(list)-1-i] = v
+	}
+	return list
+}
+
+// checkFilter walks the levels of the filter, MatchTopic only finds the invalid levels it reaches.
+func checkFilter(filter []byte) error {
+	for rem := filter; len(rem) > 0; {
+		var err error
+		if _, rem, err = nextTopicLevel(rem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package topics
+
+import (
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func newRetainedPacket(topic string, payload string) *packets.PublishPacket {
+	msg := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	msg.TopicName = topic
+	msg.Retain = true
+	msg.Payload = []byte(payload)
+	return msg
+}
+
+func TestRetainHistory(t *testing.T) {
+	m := &Manager{ttp: NewMemProvider()}
+
+	_, err := m.RetainHistory([]byte("devices/d1/state"))
+	require.Error(t, err)
+
+	require.Error(t, m.SetRetainHistory(HistoryDepth{Filter: "devices/#", Depth: 0}))
+	require.Error(t, m.SetRetainHistory(HistoryDepth{Filter: "devices/#/state", Depth: 1}))
+	require.NoError(t, m.SetRetainHistory(
+		HistoryDepth{Filter: "devices/#", Depth: 2},
+		HistoryDepth{Filter: "devices/+/state", Depth: 3},
+	))
+
+	for _, payload := range []string{"v1", "v2", "v3", "v4"} {
+		require.NoError(t, m.Retain(newRetainedPacket("devices/d1/state", payload)))
+		require.NoError(t, m.Retain(newRetainedPacket("devices/d1/config", payload)))
+		require.NoError(t, m.Retain(newRetainedPacket("other/d1/state", payload)))
+	}
+	require.NoError(t, m.Retain(newRetainedPacket("devices/d1/state", "")))
+
+	versions, err := m.RetainHistory([]byte("devices/d1/state"))
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	require.Equal(t, uint64(5), versions[0].Version)
+	require.Nil(t, versions[0].Message)
+	require.Equal(t, "v4", string(versions[1].Message.Payload))
+	require.Equal(t, "v3", string(versions[2].Message.Payload))
+
+	versions, err = m.RetainHistory([]byte("devices/d1/config"))
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.Equal(t, "v4", string(versions[0].Message.Payload))
+
+	versions, err = m.RetainHistory([]byte("other/d1/state"))
+	require.NoError(t, err)
+	require.Len(t, versions, 0)
+
+	require.NoError(t, m.SetRetainHistory())
+	_, err = m.RetainHistory([]byte("devices/d1/state"))
+	require.Error(t, err)
+}
+
+func TestRetainKeepsPublishedFlags(t *testing.T) {
+	m := &Manager{ttp: NewMemProvider()}
+
+	msg := newRetainedPacket("devices/d1/state", "on")
+	msg.Qos = QosAtLeastOnce
+	msg.Dup = true
+	require.NoError(t, m.Retain(msg))
+
+	// The delivery paths may change the packet afterwards
+	msg.Retain = false
+	msg.Qos = QosAtMostOnce
+
+	var list []*packets.PublishPacket
+	require.NoError(t, m.Retained([]byte("devices/#"), &list))
+	require.Len(t, list, 1)
+	require.True(t, list[0].Retain)
+	require.False(t, list[0].Dup)
+	require.Equal(t, QosAtLeastOnce, list[0].Qos)
+}
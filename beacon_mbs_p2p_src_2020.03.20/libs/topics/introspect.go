@@ -0,0 +1,231 @@
+package topics
+
+import (
+	"errors"
+	"sort"
+)
+
+var (
+	_ IntrospectingProvider = (*memProvider)(nil)
+	_ IntrospectingProvider = (*boltProvider)(nil)
+	_ IntrospectingProvider = (*compositeProvider)(nil)
+)
+
+var errNotIntrospecting = errors.New("topics/introspect: the provider cannot be introspected")
+
+// SubscriptionInfo is a subscription of the trie, the shared ones with their $share/<group>/
+// prefix.
+type SubscriptionInfo struct {
+	Filter     string
+	Qos        byte
+	Subscriber Subscriber
+}
+
+// IntrospectingProvider is implemented by the providers counting the state of their trie, for the
+// admin API, the $SYS topics and the tests. The counts are the ones of the current version, the
+// subscriptions changed meanwhile may be missed.
+type IntrospectingProvider interface {
+	// SubscriptionCount counts the subscriptions, each member of a share group is one
+	SubscriptionCount() int
+	// TopicNodeCount counts the nodes of the subscription trie, a node for each topic level of
+	// the filters
+	TopicNodeCount() int
+	// RetainedCount counts the retained messages, the expired ones not purged yet too
+	RetainedCount() int
+	// Subscriptions returns the subscriptions of the filter, the filter is not matched: "a/+"
+	// returns the subscriptions to "a/+" only. The shared subscriptions of any group are returned
+	// for the filter without its $share/<group>/ prefix, the ones of the group with it.
+	Subscriptions(filter []byte) []SubscriptionInfo
+}
+
+func (m *memProvider) SubscriptionCount() int {
+	return m.root().count()
+}
+
+func (m *memProvider) TopicNodeCount() int {
+	return m.root().nodes() - 1
+}
+
+func (m *memProvider) RetainedCount() int {
+	m.rmu.RLock()
+	defer m.rmu.RUnlock()
+	return m.retainedRoot.count()
+}
+
+func (m *memProvider) Subscriptions(filter []byte) []SubscriptionInfo {
+	group, f, _, err := ParseSharedFilter(filter)
+	if err != nil || ValidateTopicFilter(f) != nil {
+		return nil
+	}
+
+	n := m.root()
+	for rem := f; len(rem) > 0; {
+		level, next, err := nextTopicLevel(rem)
+		if err != nil {
+			return nil
+		}
+		if n = n.subscribeNodesMap[string(level)]; n == nil {
+			return nil
+		}
+		rem = next
+	}
+
+	var infos []SubscriptionInfo
+	n.subscriptions(string(f), group, &infos)
+	return infos
+}
+
+// count counts the subscriptions of the node and of the next levels.
+func (s *subscribeNode) count() int {
+	n := s.subSet.len()
+	for _, g := range s.sharedGroups {
+		n += len(g.subList)
+	}
+	for _, next := range s.subscribeNodesMap {
+		n += next.count()
+	}
+	return n
+}
+
+// nodes counts the node and the nodes of the next levels.
+func (s *subscribeNode) nodes() int {
+	n := 1
+	for _, next := range s.subscribeNodesMap {
+		n += next.nodes()
+	}
+	return n
+}
+
+// subscriptions appends the subscriptions of the node, the ones of the share group only if it's
+// not empty.
+func (s *subscribeNode) subscriptions(filter string, group string, infos *[]SubscriptionInfo) {
+	if len(group) == 0 {
+		if s.subSet != nil {
+			s.subSet.each(func(e setEntry) bool {
+				*infos = append(*infos, SubscriptionInfo{Filter: filter, Qos: e.qos, Subscriber: e.sub})
+				return true
+			})
+		}
+	}
+
+	groups := make([]string, 0, len(s.sharedGroups))
+	for name := range s.sharedGroups {
+		if len(group) == 0 || name == group {
+			groups = append(groups, name)
+		}
+	}
+	sort.Strings(groups)
+	for _, name := range groups {
+		g := s.sharedGroups[name]
+		for i, sub := range g.subList {
+			*infos = append(*infos, SubscriptionInfo{Filter: SharePrefix + name + SEP + filter, Qos: g.qosList[i], Subscriber: sub})
+		}
+	}
+}
+
+// count counts the retained messages of the node and of the next levels.
+func (r *retainNode) count() int {
+	n := 0
+	if r.message != nil {
+		n++
+	}
+	for _, next := range r.retainNodesMap {
+		n += next.count()
+	}
+	return n
+}
+
+func (p *boltProvider) SubscriptionCount() int {
+	return p.mem.SubscriptionCount()
+}
+
+func (p *boltProvider) TopicNodeCount() int {
+	return p.mem.TopicNodeCount()
+}
+
+func (p *boltProvider) RetainedCount() int {
+	return p.mem.RetainedCount()
+}
+
+func (p *boltProvider) Subscriptions(filter []byte) []SubscriptionInfo {
+	return p.mem.Subscriptions(filter)
+}
+
+// SubscriptionCount sums the subscriptions of the providers which count them.
+func (c *compositeProvider) SubscriptionCount() int {
+	n := 0
+	for _, p := range c.providers() {
+		if ip, ok := p.(IntrospectingProvider); ok {
+			n += ip.SubscriptionCount()
+		}
+	}
+	return n
+}
+
+// TopicNodeCount sums the nodes of the tries of the providers which count them.
+func (c *compositeProvider) TopicNodeCount() int {
+	n := 0
+	for _, p := range c.providers() {
+		if ip, ok := p.(IntrospectingProvider); ok {
+			n += ip.TopicNodeCount()
+		}
+	}
+	return n
+}
+
+// RetainedCount sums the retained messages of the providers which count them.
+func (c *compositeProvider) RetainedCount() int {
+	n := 0
+	for _, p := range c.providers() {
+		if ip, ok := p.(IntrospectingProvider); ok {
+			n += ip.RetainedCount()
+		}
+	}
+	return n
+}
+
+// Subscriptions returns the subscriptions of the filter from the provider of the filter, like
+// Subscribe stores them.
+func (c *compositeProvider) Subscriptions(filter []byte) []SubscriptionInfo {
+	_, f, _, _ := ParseSharedFilter(filter)
+	if ip, ok := c.route(f).(IntrospectingProvider); ok {
+		return ip.Subscriptions(filter)
+	}
+	return nil
+}
+
+// SubscriptionCount counts the subscriptions of the provider.
+func (m *Manager) SubscriptionCount() (int, error) {
+	ip, ok := m.ttp.(IntrospectingProvider)
+	if !ok {
+		return 0, errNotIntrospecting
+	}
+	return ip.SubscriptionCount(), nil
+}
+
+// TopicNodeCount counts the nodes of the subscription trie of the provider.
+func (m *Manager) TopicNodeCount() (int, error) {
+	ip, ok := m.ttp.(IntrospectingProvider)
+	if !ok {
+		return 0, errNotIntrospecting
+	}
+	return ip.TopicNodeCount(), nil
+}
+
+// RetainedCount counts the retained messages of the provider.
+func (m *Manager) RetainedCount() (int, error) {
+	ip, ok := m.ttp.(IntrospectingProvider)
+	if !ok {
+		return 0, errNotIntrospecting
+	}
+	return ip.RetainedCount(), nil
+}
+
+// Subscriptions returns the subscriptions of the filter, see IntrospectingProvider.
+func (m *Manager) Subscriptions(filter []byte) ([]SubscriptionInfo, error) {
+	ip, ok := m.ttp.(IntrospectingProvider)
+	if !ok {
+		return nil, errNotIntrospecting
+	}
+	return ip.Subscriptions(filter), nil
+}
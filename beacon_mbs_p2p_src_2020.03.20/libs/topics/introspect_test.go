@@ -0,0 +1,79 @@
+package topics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntrospect(t *testing.T) {
+	p := NewMemProvider(WithSubscriberSets(0))
+	m := &Manager{ttp: p}
+	for _, s := range []struct {
+		filter string
+		sub    Subscriber
+	}{
+		{"a/+", testSubscriber("s1")},
+		{"a/+", keyedSubscriber("c1")},
+		{"a/b/#", testSubscriber("s2")},
+		{"$share/g/a/+", testSubscriber("w1")},
+		{"$share/g/a/+", testSubscriber("w2")},
+		{"$share/h/a/+", testSubscriber("w3")},
+	} {
+		_, err := m.Subscribe([]byte(s.filter), 1, s.sub)
+		require.NoError(t, err)
+	}
+	require.NoError(t, m.Retain(newRetainedPacket("a/b", "1")))
+	require.NoError(t, m.Retain(newRetainedPacket("a/b/c", "2")))
+
+	n, err := m.SubscriptionCount()
+	require.NoError(t, err)
+	require.Equal(t, 6, n)
+	// a, a/+, a/b, a/b/#
+	n, err = m.TopicNodeCount()
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+	n, err = m.RetainedCount()
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	infos, err := m.Subscriptions([]byte("a/+"))
+	require.NoError(t, err)
+	require.Len(t, infos, 5)
+	require.Contains(t, infos, SubscriptionInfo{Filter: "a/+", Qos: 1, Subscriber: keyedSubscriber("c1")})
+	require.Equal(t, SubscriptionInfo{Filter: "$share/h/a/+", Qos: 1, Subscriber: testSubscriber("w3")}, infos[4])
+
+	infos, err = m.Subscriptions([]byte("$share/g/a/+"))
+	require.NoError(t, err)
+	require.Equal(t, []SubscriptionInfo{
+		{Filter: "$share/g/a/+", Qos: 1, Subscriber: testSubscriber("w1")},
+		{Filter: "$share/g/a/+", Qos: 1, Subscriber: testSubscriber("w2")},
+	}, infos)
+
+	// the filter is not matched
+	infos, err = m.Subscriptions([]byte("a/b"))
+	require.NoError(t, err)
+	require.Empty(t, infos)
+	infos, err = m.Subscriptions([]byte("x/#"))
+	require.NoError(t, err)
+	require.Empty(t, infos)
+
+	require.NoError(t, m.Unsubscribe([]byte("a/b/#"), testSubscriber("s2")))
+	n, _ = m.SubscriptionCount()
+	require.Equal(t, 5, n)
+	n, _ = m.TopicNodeCount()
+	require.Equal(t, 2, n)
+
+	// the composite provider sums its providers
+	route := NewMemProvider()
+	c, err := NewCompositeProvider(p, Route{Filter: "x/#", Provider: route})
+	require.NoError(t, err)
+	cm := &Manager{ttp: c}
+	_, err = cm.Subscribe([]byte("x/y"), 0, testSubscriber("s4"))
+	require.NoError(t, err)
+	n, _ = cm.SubscriptionCount()
+	require.Equal(t, 6, n)
+	infos, err = cm.Subscriptions([]byte("x/y"))
+	require.NoError(t, err)
+	require.Equal(t, []SubscriptionInfo{{Filter: "x/y", Subscriber: testSubscriber("s4")}}, infos)
+}
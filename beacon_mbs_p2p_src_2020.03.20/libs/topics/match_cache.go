@@ -0,0 +1,196 @@
+package topics
+
+import (
+	"container/list"
+	"sync"
+)
+
+// The estimated bytes of an entry besides its topic and its subscribers, and of each subscriber
+const (
+	matchEntryOverhead  = 128
+	matchSubscriberSize = 16
+)
+
+// WithMatchCache caches the subscribers matched by the publish topics, so the trie isn't walked
+// again for the topics published repeatedly. The least recently used topics are evicted past
+// maxEntries or past maxBytes of estimated memory, 0 is unlimited but one of them must be set. The
+// cache is emptied by Subscribe and Unsubscribe, a member of the share groups is still picked by
+// each match.
+func WithMatchCache(maxEntries int, maxBytes int) MemOption {
+	return func(m *memProvider) {
+		if maxEntries > 0 || maxBytes > 0 {
+			m.matchCache = newMatchCache(maxEntries, maxBytes)
+		}
+	}
+}
+
+// matchEntry is the match of a topic in a version of the trie.
+type matchEntry struct {
+	topic  string
+	root   *subscribeNode
+	subs   []Subscriber
+	groups []*sharedGroup
+	size   int
+}
+
+type matchCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int
+	bytes      int
+	lru        *list.List
+	entries    map[string]*list.Element
+
+	hits   uint64
+	misses uint64
+}
+
+func newMatchCache(maxEntries int, maxBytes int) *matchCache {
+	return &matchCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// get returns the match of the topic in the version of the trie, an entry of an older version is
+// a miss.
+func (c *matchCache) get(topic []byte, root *subscribeNode) (*matchEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[string(topic)]
+	if !ok || el.Value.(*matchEntry).root != root {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(el)
+	return el.Value.(*matchEntry), true
+}
+
+func (c *matchCache) put(e *matchEntry) {
+	e.size = matchEntryOverhead + len(e.topic) + matchSubscriberSize*(len(e.subs)+len(e.groups))
+	if c.maxBytes > 0 && e.size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[e.topic]; ok {
+		c.remove(el)
+	}
+	c.entries[e.topic] = c.lru.PushFront(e)
+	c.bytes += e.size
+
+	for (c.maxEntries > 0 && c.lru.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *matchCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*matchEntry)
+	delete(c.entries, e.topic)
+	c.bytes -= e.size
+}
+
+func (c *matchCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.bytes = 0
+}
+
+// MatchCacheStats are the counters of the match cache.
+type MatchCacheStats struct {
+	Entries int    `json:"entries"`
+	Bytes   int    `json:"bytes"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+}
+
+func (c *matchCache) stats() MatchCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return MatchCacheStats{Entries: c.lru.Len(), Bytes: c.bytes, Hits: c.hits, Misses: c.misses}
+}
+
+// MatchCacheStats returns the counters of the match cache, false if it's disabled.
+func (m *memProvider) MatchCacheStats() (MatchCacheStats, bool) {
+	if m.matchCache == nil {
+		return MatchCacheStats{}, false
+	}
+	return m.matchCache.stats(), true
+}
+
+// MatchCacheStats returns the counters of the match cache of the provider, false if it has none.
+func (m *Manager) MatchCacheStats() (MatchCacheStats, bool) {
+	if p, ok := m.ttp.(*memProvider); ok {
+		return p.MatchCacheStats()
+	}
+	return MatchCacheStats{}, false
+}
+
+// cachedMatch returns the subscribers of the topic from the cache, the match is cached on a miss.
+func (m *memProvider) cachedMatch(topic []byte, qos byte, subList *[]Subscriber, qosList *[]byte) error {
+	root := m.root()
+	e, ok := m.matchCache.get(topic, root)
+	if !ok {
+		var nodes []*subscribeNode
+		if err := root.nodesMatch(topic, &nodes); err != nil {
+			return err
+		}
+
+		e = &matchEntry{topic: string(topic), root: root}
+		var qoss []byte
+		for _, n := range nodes {
+			if n.subSet != nil {
+				n.subSet.match(qos, &e.subs, &qoss)
+			}
+			for _, g := range n.sharedGroups {
+				e.groups = append(e.groups, g)
+			}
+		}
+		m.matchCache.put(e)
+	}
+
+	*subList = append(*subList, e.subs...)
+	for _, g := range e.groups {
+		*subList = append(*subList, g.pick())
+	}
+	for range *subList {
+		*qosList = append(*qosList, qos)
+	}
+	return nil
+}
+
+// nodesMatch returns the nodes whose subscribers match the topic, like subscriberMatch.
+func (s *subscribeNode) nodesMatch(topic []byte, nodes *[]*subscribeNode) error {
+	if len(topic) == 0 {
+		*nodes = append(*nodes, s)
+		return nil
+	}
+
+	ntl, rem, err := nextTopicLevel(topic)
+	if err != nil {
+		return err
+	}
+
+	level := string(ntl)
+
+	for k, n := range s.subscribeNodesMap {
+		if k == MWC {
+			*nodes = append(*nodes, n)
+		} else if k == SWC || k == level {
+			if err := n.nodesMatch(rem, nodes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package topics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchCache(t *testing.T) {
+	p := NewMemProvider(WithMatchCache(2, 0))
+
+	_, err := p.Subscribe([]byte("sensors/+/temp"), 1, testSubscriber("s1"))
+	require.NoError(t, err)
+	_, err = p.Subscribe([]byte("$share/g/sensors/#"), 1, testSubscriber("w1"))
+	require.NoError(t, err)
+	_, err = p.Subscribe([]byte("$share/g/sensors/#"), 1, testSubscriber("w2"))
+	require.NoError(t, err)
+
+	var subs []Subscriber
+	var qoss []byte
+	got := make(map[Subscriber]int)
+	for i := 0; i < 4; i++ {
+		require.NoError(t, p.Subscribers([]byte("sensors/s1/temp"), 1, &subs, &qoss))
+		require.Len(t, subs, 2)
+		require.Equal(t, []byte{1, 1}, qoss)
+		for _, s := range subs {
+			got[s]++
+		}
+	}
+	// the share group members are still taken in turn
+	require.Equal(t, map[Subscriber]int{testSubscriber("s1"): 4, testSubscriber("w1"): 2, testSubscriber("w2"): 2}, got)
+
+	stats, ok := p.MatchCacheStats()
+	require.True(t, ok)
+	require.Equal(t, MatchCacheStats{Entries: 1, Bytes: stats.Bytes, Hits: 3, Misses: 1}, stats)
+
+	// a new subscription is matched at once
+	_, err = p.Subscribe([]byte("sensors/s1/#"), 0, testSubscriber("s2"))
+	require.NoError(t, err)
+	require.NoError(t, p.Subscribers([]byte("sensors/s1/temp"), 0, &subs, &qoss))
+	require.Len(t, subs, 3)
+	require.Contains(t, subs, testSubscriber("s2"))
+
+	require.NoError(t, p.Unsubscribe([]byte("sensors/+/temp"), testSubscriber("s1")))
+	require.NoError(t, p.Subscribers([]byte("sensors/s1/temp"), 0, &subs, &qoss))
+	require.NotContains(t, subs, testSubscriber("s1"))
+
+	// the least recently used topic is evicted
+	for _, topic := range []string{"sensors/s1/temp", "sensors/s2/temp", "sensors/s3/temp"} {
+		require.NoError(t, p.Subscribers([]byte(topic), 0, &subs, &qoss))
+	}
+	stats, _ = p.MatchCacheStats()
+	require.Equal(t, 2, stats.Entries)
+
+	// an entry above the memory cap is not cached
+	p = NewMemProvider(WithMatchCache(0, matchEntryOverhead))
+	_, err = p.Subscribe([]byte("a/b"), 0, testSubscriber("s1"))
+	require.NoError(t, err)
+	require.NoError(t, p.Subscribers([]byte("a/b"), 0, &subs, &qoss))
+	require.Equal(t, []Subscriber{testSubscriber("s1")}, subs)
+	stats, _ = p.MatchCacheStats()
+	require.Equal(t, 0, stats.Entries)
+
+	_, ok = NewMemProvider().MatchCacheStats()
+	require.False(t, ok)
+}
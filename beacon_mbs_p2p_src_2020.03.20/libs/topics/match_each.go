@@ -0,0 +1,188 @@
+package topics
+
+import (
+	"fmt"
+	"time"
+)
+
+var (
+	_ MatchingProvider = (*memProvider)(nil)
+	_ MatchingProvider = (*boltProvider)(nil)
+	_ MatchingProvider = (*compositeProvider)(nil)
+)
+
+// MatchFunc is called with each subscriber matched by a topic and the QoS it's granted, like the
+// lists of Subscribers. It returns false to stop the matching.
+type MatchFunc func(sub Subscriber, qos byte) bool
+
+// MatchingProvider is implemented by the providers matching the subscribers without building their
+// lists, so the caller delivers, or batches, each one as it's matched and may stop early. fn is
+// called in the order of Subscribers, one member of each share group, while the subscriber sets of
+// the keyed subscribers are read locked: it must not subscribe or unsubscribe.
+type MatchingProvider interface {
+	MatchEach(topic []byte, qos byte, fn MatchFunc) error
+}
+
+func (m *memProvider) MatchEach(topic []byte, qos byte, fn MatchFunc) error {
+	if !ValidQos(qos) {
+		return fmt.Errorf("topics/match_each/MatchEach: Invalid QoS %d", qos)
+	}
+	if m.matchCache != nil {
+		return m.cachedMatchEach(topic, qos, fn)
+	}
+	_, err := m.root().matchEach(topic, qos, fn)
+	return err
+}
+
+// cachedMatchEach calls fn with the subscribers of the cached match of the topic, like cachedMatch.
+// A missed topic is matched by cachedMatch, which caches it.
+func (m *memProvider) cachedMatchEach(topic []byte, qos byte, fn MatchFunc) error {
+	e, ok := m.matchCache.get(topic, m.root())
+	if !ok {
+		var subs []Subscriber
+		var qoss []byte
+		if err := m.cachedMatch(topic, qos, &subs, &qoss); err != nil {
+			return err
+		}
+		for _, sub := range subs {
+			if !fn(sub, qos) {
+				return nil
+			}
+		}
+		return nil
+	}
+
+	for _, sub := range e.subs {
+		if !fn(sub, qos) {
+			return nil
+		}
+	}
+	for _, g := range e.groups {
+		if !fn(g.pick(), qos) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// matchEach calls fn with the subscribers matched by the topic, like subscriberMatch. It returns
+// false once fn has stopped the matching.
+func (s *subscribeNode) matchEach(topic []byte, qos byte, fn MatchFunc) (bool, error) {
+	if len(topic) == 0 {
+		return s.eachQos(qos, fn), nil
+	}
+
+	ntl, rem, err := nextTopicLevel(topic)
+	if err != nil {
+		return false, err
+	}
+
+	level := string(ntl)
+	for k, n := range s.subscribeNodesMap {
+		if k == MWC {
+			if !n.eachQos(qos, fn) {
+				return false, nil
+			}
+		} else if k == SWC || k == level {
+			if more, err := n.matchEach(rem, qos, fn); !more || err != nil {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// eachQos calls fn with the subscribers of the node, like matchQos. It returns false once fn has
+// stopped the matching.
+func (s *subscribeNode) eachQos(qos byte, fn MatchFunc) bool {
+	if s.subSet != nil && !s.subSet.each(func(e setEntry) bool { return fn(e.sub, qos) }) {
+		return false
+	}
+	for _, g := range s.sharedGroups {
+		if !fn(g.pick(), qos) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *boltProvider) MatchEach(topic []byte, qos byte, fn MatchFunc) error {
+	return p.mem.MatchEach(topic, qos, fn)
+}
+
+// MatchEach calls fn with the subscribers of the providers holding the filters of the topic, the
+// fallback first, like Subscribers.
+func (c *compositeProvider) MatchEach(topic []byte, qos byte, fn MatchFunc) error {
+	if !ValidQos(qos) {
+		return fmt.Errorf("topics/match_each/MatchEach: Invalid QoS %d", qos)
+	}
+
+	t := string(topic)
+	stopped := false
+	stop := func(sub Subscriber, qos byte) bool {
+		if !fn(sub, qos) {
+			stopped = true
+		}
+		return !stopped
+	}
+	for _, p := range c.providers() {
+		if p != c.fallback && !c.holdsFiltersOf(p, t) {
+			continue
+		}
+		if err := matchEach(p, topic, qos, stop); err != nil || stopped {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchEach calls fn with the subscribers of the provider, from the lists of Subscribers if it's
+// not a MatchingProvider.
+func matchEach(p TheTopicsProvider, topic []byte, qos byte, fn MatchFunc) error {
+	if mp, ok := p.(MatchingProvider); ok {
+		return mp.MatchEach(topic, qos, fn)
+	}
+
+	var subs []Subscriber
+	var qoss []byte
+	if err := p.Subscribers(topic, qos, &subs, &qoss); err != nil {
+		return err
+	}
+	for i, sub := range subs {
+		if !fn(sub, qoss[i]) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// MatchEach calls fn with each subscriber matched by the topic, see MatchingProvider. The providers
+// which are not MatchingProvider match the lists of Subscribers.
+func (m *Manager) MatchEach(topic []byte, qos byte, fn MatchFunc) error {
+	if m.sink == nil {
+		return matchEach(m.ttp, topic, qos, fn)
+	}
+
+	n := 0
+	start := time.Now()
+	err := matchEach(m.ttp, topic, qos, func(sub Subscriber, qos byte) bool {
+		n++
+		return fn(sub, qos)
+	})
+	m.sink.ObserveMatch(string(topic), n, time.Since(start), err)
+	return err
+}
+
+// MatchEachFrom calls fn with each subscriber matched by the topic like MatchEach, except the no
+// local subscriptions of the publisher, like SubscribersFrom.
+func (m *Manager) MatchEachFrom(topic []byte, qos byte, publisher string, fn MatchFunc) error {
+	if len(publisher) == 0 {
+		return m.MatchEach(topic, qos, fn)
+	}
+	return m.MatchEach(topic, qos, func(sub Subscriber, qos byte) bool {
+		if os, ok := sub.(OptionsSubscriber); ok && os.SubOptions().NoLocal && os.SubscriberKey() == publisher {
+			return true
+		}
+		return fn(sub, qos)
+	})
+}
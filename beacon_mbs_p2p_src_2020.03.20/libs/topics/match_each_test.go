@@ -0,0 +1,68 @@
+package topics
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchEach(t *testing.T) {
+	state := NewMemProvider()
+	composite, err := NewCompositeProvider(NewMemProvider(), Route{Filter: "state/#", Provider: state})
+	require.NoError(t, err)
+
+	for _, m := range []*Manager{
+		{ttp: NewMemProvider()},
+		{ttp: NewMemProvider(WithSubscriberSets(0))},
+		{ttp: NewMemProvider(WithMatchCache(16, 0))},
+		{ttp: composite},
+	} {
+		for i, filter := range []string{"sport/tennis/#", "sport/+/player1", "#", "$share/g/sport/tennis/player1", "state/#"} {
+			_, err := m.Subscribe([]byte(filter), 1, keyedSubscriber(fmt.Sprintf("c%d", i)))
+			require.NoError(t, err)
+		}
+
+		for _, topic := range []string{"sport/tennis/player1", "state/d1"} {
+			var subs []Subscriber
+			var qoss []byte
+			require.NoError(t, m.Subscribers([]byte(topic), 1, &subs, &qoss))
+
+			// twice, a match cache is filled by the first one
+			for i := 0; i < 2; i++ {
+				var each []Subscriber
+				require.NoError(t, m.MatchEach([]byte(topic), 1, func(sub Subscriber, qos byte) bool {
+					require.Equal(t, byte(1), qos)
+					each = append(each, sub)
+					return true
+				}))
+				require.ElementsMatch(t, subs, each)
+			}
+		}
+
+		// the matching stops once fn returns false
+		n := 0
+		require.NoError(t, m.MatchEach([]byte("sport/tennis/player1"), 1, func(sub Subscriber, qos byte) bool {
+			n++
+			return n < 2
+		}))
+		require.Equal(t, 2, n)
+
+		require.Error(t, m.MatchEach([]byte("sport/tennis/player1"), 3, func(Subscriber, byte) bool { return true }))
+	}
+}
+
+func TestMatchEachFrom(t *testing.T) {
+	m := &Manager{ttp: NewMemProvider()}
+	_, err := m.Subscribe([]byte("chat/#"), 1, &optionsSubscriber{key: "c1", options: SubOptions{NoLocal: true}})
+	require.NoError(t, err)
+	_, err = m.Subscribe([]byte("chat/+"), 1, &optionsSubscriber{key: "c2", options: SubOptions{NoLocal: true}})
+	require.NoError(t, err)
+
+	var keys []string
+	require.NoError(t, m.MatchEachFrom([]byte("chat/room"), 1, "c1", func(sub Subscriber, qos byte) bool {
+		keys = append(keys, sub.(*optionsSubscriber).key)
+		return true
+	}))
+	require.Equal(t, []string{"c2"}, keys)
+}
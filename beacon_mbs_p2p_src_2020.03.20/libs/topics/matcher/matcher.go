@@ -0,0 +1,143 @@
+// Package matcher matches the MQTT topics against the topic filters. It has no dependency on the
+// broker or on the packet types, the topics providers are built on it and other projects may reuse
+// it as is.
+//
+// A filter is a topic whose levels may be the wildcards: "+" matches exactly one level, "#" as
+// the last level matches any number of levels below its parent, though not the parent itself.
+package matcher
+
+import (
+	"fmt"
+)
+
+const (
+	// MWC is the multi-level wildcard
+	MWC = "#"
+
+	// SWC is the single level wildcard
+	SWC = "+"
+
+	// SEP is the topic level separator
+	SEP = "/"
+)
+
+const (
+	stateCHR byte = iota // Regular character
+	stateMWC             // Multi-level wildcard
+	stateSWC             // Single-level wildcard
+	stateSYS             // System level topic ($)
+)
+
+// NextLevel splits the first level off the topic or the filter, it returns the level and the
+// remaining levels, nil once the last level is returned. The wildcards are checked to occupy their
+// entire level, and "#" to be the last one.
+func NextLevel(topic []byte) ([]byte, []byte, error) {
+	s := stateCHR
+
+	for i, c := range topic {
+		switch c {
+		case '/':
+			if s == stateMWC {
+				return nil, nil, fmt.Errorf("topics/matcher/NextLevel: Multi-level wildcard found in topic and it's not at the last level")
+			}
+
+			if i == 0 {
+				return []byte(SWC), topic[i+1:], nil
+			}
+
+			return topic[:i], topic[i+1:], nil
+
+		case '#':
+			if i != 0 {
+				return nil, nil, fmt.Errorf("topics/matcher/NextLevel: Wildcard character '#' must occupy entire topic level")
+			}
+
+			s = stateMWC
+
+		case '+':
+			if i != 0 {
+				return nil, nil, fmt.Errorf("topics/matcher/NextLevel: Wildcard character '+' must occupy entire topic level")
+			}
+
+			s = stateSWC
+
+		case '$':
+			s = stateSYS
+
+		default:
+			if s == stateMWC || s == stateSWC {
+				return nil, nil, fmt.Errorf("topics/matcher/NextLevel: Wildcard characters '#' and '+' must occupy entire topic level")
+			}
+
+			s = stateCHR
+		}
+	}
+
+	// If we got here that means we didn't hit the separator along the way, so the
+	// topic is either empty, or does not contain a separator. Either way, we return
+	// the full topic
+	return topic, nil, nil
+}
+
+// Match reports whether the filter matches the publish topic. It walks the levels the same way
+// Trie.Match does, so a filter matches here if and only if its values are returned by the Trie.
+func Match(filter []byte, topic []byte) (bool, error) {
+	// If the topic is empty, it's a match only if the filter is exhausted too.
+	if len(topic) == 0 {
+		return len(filter) == 0, nil
+	}
+
+	if len(filter) == 0 {
+		return false, nil
+	}
+
+	tl, trem, err := NextLevel(topic)
+	if err != nil {
+		return false, err
+	}
+
+	fl, frem, err := NextLevel(filter)
+	if err != nil {
+		return false, err
+	}
+
+	level := string(fl)
+
+	// If the level is "#", the rest of the topic is matched
+	if level == MWC {
+		return true, nil
+	}
+
+	if level == SWC || level == string(tl) {
+		return Match(frem, trem)
+	}
+
+	return false, nil
+}
+
+// ValidTopic reports an error if the publish topic is empty or has a wildcard.
+func ValidTopic(topic []byte) error {
+	if len(topic) == 0 {
+		return fmt.Errorf("topics/matcher/ValidTopic: the topic is empty")
+	}
+	for _, c := range topic {
+		if c == '+' || c == '#' {
+			return fmt.Errorf("topics/matcher/ValidTopic: the topic %q has a wildcard", topic)
+		}
+	}
+	return nil
+}
+
+// ValidFilter reports an error if the filter is empty or has a misplaced wildcard.
+func ValidFilter(filter []byte) error {
+	if len(filter) == 0 {
+		return fmt.Errorf("topics/matcher/ValidFilter: the filter is empty")
+	}
+	for rem := filter; len(rem) > 0; {
+		var err error
+		if _, rem, err = NextLevel(rem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package matcher
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/#", "a/b/c", true},
+		{"a/#", "a", false},
+		{"#", "a/b", true},
+		{"+/b", "a/b", true},
+		{"a/b", "a/c", false},
+	}
+	for _, c := range cases {
+		ok, err := Match([]byte(c.filter), []byte(c.topic))
+		require.NoError(t, err)
+		require.Equal(t, c.match, ok, c.filter+" "+c.topic)
+	}
+
+	_, err := Match([]byte("a/#/b"), []byte("a/x/b"))
+	require.Error(t, err)
+	require.Error(t, ValidFilter([]byte("a/b+")))
+	require.Error(t, ValidFilter(nil))
+	require.Error(t, ValidTopic([]byte("a/+")))
+	require.NoError(t, ValidTopic([]byte("a/b")))
+}
+
+func TestTrie(t *testing.T) {
+	tr := New()
+	for _, f := range []string{"a/b", "a/+", "a/#", "#", "b/c"} {
+		added, err := tr.Insert(f, f)
+		require.NoError(t, err)
+		require.True(t, added)
+	}
+	added, err := tr.Insert("a/b", "a/b")
+	require.NoError(t, err)
+	require.False(t, added)
+	added, err = tr.Insert("a/b", "other")
+	require.NoError(t, err)
+	require.True(t, added)
+	_, err = tr.Insert("a/#/b", "x")
+	require.Error(t, err)
+	require.Equal(t, 6, tr.Len())
+
+	match := func(topic string) []string {
+		values, err := tr.Match(topic)
+		require.NoError(t, err)
+		var got []string
+		for _, v := range values {
+			got = append(got, v.(string))
+		}
+		sort.Strings(got)
+		return got
+	}
+	require.Equal(t, []string{"#", "a/#", "a/+", "a/b", "other"}, match("a/b"))
+	require.Equal(t, []string{"#", "a/#"}, match("a/b/c"))
+	require.Equal(t, []string{"#"}, match("a"))
+	_, err = tr.Match("a/+")
+	require.Error(t, err)
+
+	// every matching filter agrees with Match
+	tr.Walk(func(filter string, value interface{}) bool {
+		ok, err := Match([]byte(filter), []byte("a/b"))
+		require.NoError(t, err)
+		require.True(t, ok || filter == "b/c", filter)
+		return true
+	})
+
+	require.True(t, tr.Remove("a/b", "other"))
+	require.False(t, tr.Remove("a/b", "other"))
+	require.False(t, tr.Remove("x/y", "x/y"))
+	require.True(t, tr.Remove("b/c", "b/c"))
+	require.Equal(t, 4, tr.Len())
+
+	var filters []string
+	tr.Walk(func(filter string, value interface{}) bool {
+		filters = append(filters, filter)
+		return len(filters) < 3
+	})
+	require.Equal(t, []string{"#", "a/#", "a/+"}, filters)
+	_, ok := tr.root.children["b"]
+	require.False(t, ok)
+}
@@ -0,0 +1,184 @@
+package matcher
+
+import (
+	"sort"
+	"sync"
+)
+
+// Trie holds the values by topic filter, one node per level, and returns the values of the
+// filters matching a publish topic. The values are compared with ==, so they must be comparable.
+// A Trie is safe for concurrent use.
+type Trie struct {
+	mu   sync.RWMutex
+	root *node
+	size int
+}
+
+type node struct {
+	// The filter as inserted, set on the nodes holding values
+	filter   string
+	values   []interface{}
+	children map[string]*node
+}
+
+func newNode() *node {
+	return &node{children: make(map[string]*node)}
+}
+
+// New returns an empty Trie.
+func New() *Trie {
+	return &Trie{root: newNode()}
+}
+
+// Len returns the number of the values held, counted once per filter.
+func (t *Trie) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.size
+}
+
+// Insert adds the value to the filter, it returns false if the value is already held by it.
+func (t *Trie) Insert(filter string, value interface{}) (bool, error) {
+	if err := ValidFilter([]byte(filter)); err != nil {
+		return false, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := t.root
+	for rem := []byte(filter); len(rem) > 0; {
+		level, next, err := NextLevel(rem)
+		if err != nil {
+			return false, err
+		}
+		child, ok := n.children[string(level)]
+		if !ok {
+			child = newNode()
+			n.children[string(level)] = child
+		}
+		n, rem = child, next
+	}
+
+	for _, v := range n.values {
+		if v == value {
+			return false, nil
+		}
+	}
+	n.filter = filter
+	n.values = append(n.values, value)
+	t.size++
+	return true, nil
+}
+
+// Remove removes the value from the filter, it returns false if the filter doesn't hold it. The
+// levels left without values are pruned.
+func (t *Trie) Remove(filter string, value interface{}) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.root.remove([]byte(filter), value) {
+		return false
+	}
+	t.size--
+	return true
+}
+
+func (n *node) remove(filter []byte, value interface{}) bool {
+	if len(filter) == 0 {
+		for i, v := range n.values {
+			if v == value {
+				n.values = append(n.values[:i], n.values[i+1:]...)
+				return true
+			}
+		}
+		return false
+	}
+
+	level, rem, err := NextLevel(filter)
+	if err != nil {
+		return false
+	}
+	child, ok := n.children[string(level)]
+	if !ok || !child.remove(rem, value) {
+		return false
+	}
+	if len(child.values) == 0 && len(child.children) == 0 {
+		delete(n.children, string(level))
+	}
+	return true
+}
+
+// Match returns the values of the filters matching the publish topic, a value held by several of
+// them is returned once per filter.
+func (t *Trie) Match(topic string) ([]interface{}, error) {
+	if err := ValidTopic([]byte(topic)); err != nil {
+		return nil, err
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var values []interface{}
+	if err := t.root.match([]byte(topic), &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (n *node) match(topic []byte, values *[]interface{}) error {
+	if len(topic) == 0 {
+		*values = append(*values, n.values...)
+		return nil
+	}
+
+	level, rem, err := NextLevel(topic)
+	if err != nil {
+		return err
+	}
+
+	if child, ok := n.children[MWC]; ok {
+		*values = append(*values, child.values...)
+	}
+	if child, ok := n.children[SWC]; ok {
+		if err := child.match(rem, values); err != nil {
+			return err
+		}
+	}
+	if string(level) == SWC {
+		return nil
+	}
+	if child, ok := n.children[string(level)]; ok {
+		return child.match(rem, values)
+	}
+	return nil
+}
+
+// Walk calls fn with each filter and value held, the filters in lexical order of their levels,
+// it stops once fn returns false. fn must not change the Trie.
+func (t *Trie) Walk(fn func(filter string, value interface{}) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	t.root.walk(fn)
+}
+
+func (n *node) walk(fn func(filter string, value interface{}) bool) bool {
+	for _, v := range n.values {
+		if !fn(n.filter, v) {
+			return false
+		}
+	}
+
+	keys := make([]string, 0, len(n.children))
+	for level := range n.children {
+		keys = append(keys, level)
+	}
+	sort.Strings(keys)
+	for _, level := range keys {
+		if !n.children[level].walk(fn) {
+			return false
+		}
+	}
+	return true
+}
@@ -2,8 +2,12 @@ package topics
 
 import (
 	"fmt"
-	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+	"awesomeProject/beacon/mqtt_network/libs/topics/matcher"
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
 )
@@ -15,22 +19,48 @@ const (
 	QosFailure = 0x80
 )
 
-var _ TheTopicsProvider = (*memProvider)(nil)
+var (
+	_ TheTopicsProvider       = (*memProvider)(nil)
+	_ ExpiringProvider        = (*memProvider)(nil)
+	_ ExpiryNotifyingProvider = (*memProvider)(nil)
+	_ ReplacingProvider       = (*memProvider)(nil)
+)
 
+// The subscription trie is copy-on-write: the writer of the apply log copies the nodes on the
+// paths of the filters and publishes the new root, so Subscribers matches a version of the trie
+// without any lock, however many publishers there are. The mutations are serialized by the apply
+// log rather than by a lock the subscribers would queue on.
 type memProvider struct {
-	// Sub/unsub mutex
-	smu sync.RWMutex
-	// Subscription tree
-	subscribeRoot *subscribeNode
+	// The ordered log of the subscribes, the unsubscribes and the retains, and its single writer
+	log applyLog
+	// Subscription tree, the *subscribeNode root of the current version
+	subscribeRoot atomic.Value
 
-	// Retained message mutex
+	// Retained message mutex, the readers share it with the writer and the sweeper
 	rmu sync.RWMutex
 	// Retained messages topic tree
 	retainedRoot *retainNode
+	// The retained messages counted against the limits, nil if the store has none
+	retainAccount *retainAccount
+
+	// The expired retained messages are skipped, and purged by the sweeper which passes them to
+	// onExpired, if it's set
+	clock     clock.Clock
+	stop      chan struct{}
+	onExpired func(message *packets.PublishPacket)
+
+	// The most subscribers of a filter, 0 is unlimited, see WithSubscriberSets
+	subscriberSetMax int
+
+	// The subscribers matched by the publish topics, see WithMatchCache
+	matchCache *matchCache
+
+	// The bounds of the subscription filters, nil if there are none
+	filterLimits *FilterLimits
 }
 
-func RegisterMemTopicsProvider() {
-	Register("mem", NewMemProvider())
+func RegisterMemTopicsProvider(opts ...MemOption) {
+	Register("mem", NewMemProvider(opts...))
 }
 
 func UnRegisterMemTopicsProvider() {
@@ -41,94 +71,284 @@ func UnRegisterMemTopicsProvider() {
 // TopicsProvider interface. memProvider is a hidden struct that stores the topic
 // subscriptions and retained messages in memory. The content is not persistend so
 // when the server goes, everything will be gone. Use with care.
-func NewMemProvider() *memProvider {
-	return &memProvider{
-		subscribeRoot: newSubscribeNode(),
-		retainedRoot:  newRetainNode(),
+func NewMemProvider(opts ...MemOption) *memProvider {
+	m := &memProvider{
+		retainedRoot: newRetainNode(),
+		clock:        clock.Real,
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.subscribeRoot.Store(newSubscribeNode())
+	return m
+}
+
+func (m *memProvider) root() *subscribeNode {
+	return m.subscribeRoot.Load().(*subscribeNode)
 }
 
 func ValidQos(qos byte) bool {
 	return qos == QosAtMostOnce || qos == QosAtLeastOnce || qos == QosExactlyOnce
 }
 
-func (m *memProvider) Subscribe(topic []byte, qos byte, sub interface{}) (byte, error) {
+func (m *memProvider) Subscribe(topic []byte, qos byte, sub Subscriber) (byte, error) {
+	res, err := m.SubscribeResult(topic, qos, sub)
+	return res.Qos, err
+}
+
+// SubscribeResult subscribes like Subscribe, and reports whether the subscription existed.
+func (m *memProvider) SubscribeResult(topic []byte, qos byte, sub Subscriber) (SubscribeResult, error) {
 	if !ValidQos(qos) {
-		return QosFailure, fmt.Errorf("topics/mem_provider/Subscribe: Invalid QoS %d", qos)
+		return SubscribeResult{Qos: QosFailure}, fmt.Errorf("topics/mem_provider/Subscribe: Invalid QoS %d", qos)
 	}
 
 	if sub == nil {
-		return QosFailure, fmt.Errorf("topics/mem_provider/Subscribe: Subscriber cannot be nil")
+		return SubscribeResult{Qos: QosFailure}, fmt.Errorf("topics/mem_provider/Subscribe: Subscriber cannot be nil")
 	}
 
-	m.smu.Lock()
-	defer m.smu.Unlock()
-
-	if qos > QosExactlyOnce {
-		qos = QosExactlyOnce
+	if err := ValidateTopicFilter(topic); err != nil {
+		return SubscribeResult{Qos: QosFailure}, err
 	}
 
-	if err := m.subscribeRoot.subscriberInsert(topic, qos, sub); err != nil {
-		return QosFailure, err
+	group, filter, _, err := ParseSharedFilter(topic)
+	if err != nil {
+		return SubscribeResult{Qos: QosFailure}, err
+	}
+	if m.filterLimits != nil {
+		if err := m.filterLimits.Check(filter); err != nil {
+			return SubscribeResult{Qos: QosFailure}, err
+		}
 	}
 
-	return qos, nil
+	e := &applyEntry{
+		mutation: Mutation{Kind: MutationSubscribe, Topic: topic, Qos: qos, Subscriber: sub},
+		group:    group,
+		filter:   filter,
+		qos:      qos,
+	}
+	m.submit(e)
+	if e.err != nil {
+		return SubscribeResult{Qos: QosFailure}, e.err
+	}
+	return SubscribeResult{Qos: qos, Existed: e.existed}, nil
 }
 
-func (m *memProvider) Unsubscribe(topic []byte, sub interface{}) error {
-	m.smu.Lock()
-	defer m.smu.Unlock()
+func (m *memProvider) Unsubscribe(topic []byte, sub Subscriber) error {
+	group, filter, _, err := ParseSharedFilter(topic)
+	if err != nil {
+		return err
+	}
+
+	e := &applyEntry{
+		mutation: Mutation{Kind: MutationUnsubscribe, Topic: topic, Subscriber: sub},
+		group:    group,
+		filter:   filter,
+	}
+	m.submit(e)
+	return e.err
+}
 
-	return m.subscribeRoot.subscriberRemove(topic, sub)
+// clearMatchCache frees the matches of the older versions, they are not returned anyway.
+func (m *memProvider) clearMatchCache() {
+	if m.matchCache != nil {
+		m.matchCache.clear()
+	}
 }
 
 // Returned values will be invalidated by the next Subscribers call
-func (m *memProvider) Subscribers(topic []byte, qos byte, subList *[]interface{}, qosList *[]byte) error {
+func (m *memProvider) Subscribers(topic []byte, qos byte, subList *[]Subscriber, qosList *[]byte) error {
 	if !ValidQos(qos) {
 		return fmt.Errorf("topics/mem_provide/Subscribers: Invalid QoS %d", qos)
 	}
 
-	m.smu.RLock()
-	defer m.smu.RUnlock()
-
 	*subList = (*subList)[0:0]
 	*qosList = (*qosList)[0:0]
 
-	return m.subscribeRoot.subscriberMatch(topic, qos, subList, qosList)
+	if m.matchCache != nil {
+		return m.cachedMatch(topic, qos, subList, qosList)
+	}
+	return m.root().subscriberMatch(topic, qos, subList, qosList)
 }
 
 func (m *memProvider) Retain(message *packets.PublishPacket) error {
+	return m.RetainUntil(message, time.Time{})
+}
+
+// SetClock sets the clock the retained messages expire with, the wall clock by default.
+func (m *memProvider) SetClock(c clock.Clock) {
+	m.clock = clock.OrReal(c)
+}
+
+// RetainUntil retains the message until the deadline, a zero deadline never expires.
+func (m *memProvider) RetainUntil(message *packets.PublishPacket, deadline time.Time) error {
+	_, err := m.RetainReplace(message, deadline)
+	return err
+}
+
+// RetainReplace retains the message like RetainUntil and returns the message it replaced, nil if
+// there was none or it had expired.
+func (m *memProvider) RetainReplace(message *packets.PublishPacket, deadline time.Time) (*packets.PublishPacket, error) {
+	topic := []byte(message.TopicName)
+	if err := ValidatePublishTopic(topic); err != nil {
+		return nil, err
+	}
+
+	e := &applyEntry{mutation: Mutation{Kind: MutationRetain, Topic: topic, Message: message, Deadline: deadline}}
+	m.submit(e)
+	return e.replaced, e.err
+}
+
+// applyRetain retains the message for the writer of the apply log.
+func (m *memProvider) applyRetain(message *packets.PublishPacket, deadline time.Time) (*packets.PublishPacket, error) {
+	topic := []byte(message.TopicName)
+
 	m.rmu.Lock()
 	defer m.rmu.Unlock()
+	now := m.clock.Now()
+
+	var previous []*packets.PublishPacket
+	if err := m.retainedRoot.retainMatchAt(topic, now, &previous); err != nil {
+		return nil, err
+	}
+	var replaced *packets.PublishPacket
+	if len(previous) > 0 {
+		replaced = previous[0]
+	}
 
 	// So apparently, at least according to the MQTT Conformance/Interoperability
 	// Testing, that a payload of 0 means delete the retain message.
 	// https://eclipse.org/paho/clients/testing/
 	if len(message.Payload) == 0 {
-		return m.retainedRoot.retainRemove([]byte(message.TopicName))
+		if m.retainAccount != nil {
+			m.retainAccount.remove(message.TopicName)
+		}
+		return replaced, m.retainedRoot.retainRemove(topic)
 	}
 
-	return m.retainedRoot.retainInsert([]byte(message.TopicName), message)
+	if m.retainAccount != nil {
+		evicted, err := m.retainAccount.admit(message.TopicName, len(message.Payload))
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range evicted {
+			_ = m.retainedRoot.retainRemove([]byte(t))
+		}
+	}
+	return replaced, m.retainedRoot.retainInsertUntil(topic, message, deadline)
 }
 
+// Retained skips the expired messages, they are purged by the sweeper.
 func (m *memProvider) Retained(topic []byte, messages *[]*packets.PublishPacket) error {
 	m.rmu.RLock()
 	defer m.rmu.RUnlock()
 
-	return m.retainedRoot.retainMatch(topic, messages)
+	return m.retainedRoot.retainMatchAt(topic, m.clock.Now(), messages)
 }
 
+// RetainedDeadline returns the deadline of the retained message of the topic, false if it has
+// none, it has expired or it never expires.
+func (m *memProvider) RetainedDeadline(topic string) (time.Time, bool) {
+	m.rmu.RLock()
+	defer m.rmu.RUnlock()
+
+	return m.retainedRoot.retainDeadline([]byte(topic), m.clock.Now())
+}
+
+// SweepRetained removes the expired retained messages, it returns how many were removed.
+func (m *memProvider) SweepRetained() int {
+	return len(m.sweepRetained())
+}
+
+// sweepRetained returns the deadlines of the removed messages by topic, the messages are passed to
+// the expired handler once the lock is released.
+func (m *memProvider) sweepRetained() map[string]time.Time {
+	m.rmu.Lock()
+	removed := make(map[string]time.Time)
+	var expired []*packets.PublishPacket
+	if m.retainedRoot != nil {
+		m.retainedRoot.retainSweep("", m.clock.Now(), removed, &expired)
+	}
+	if m.retainAccount != nil {
+		for topic := range removed {
+			m.retainAccount.remove(topic)
+		}
+	}
+	onExpired := m.onExpired
+	m.rmu.Unlock()
+
+	if onExpired != nil {
+		for _, message := range expired {
+			onExpired(message)
+		}
+	}
+	return removed
+}
+
+// NotifyExpired sets the handler of the retained messages purged by the sweeper.
+func (m *memProvider) NotifyExpired(fn func(message *packets.PublishPacket)) {
+	m.rmu.Lock()
+	defer m.rmu.Unlock()
+
+	m.onExpired = fn
+}
+
+// StartSweeper purges the expired retained messages every interval until the provider is closed,
+// it does nothing if the sweeper is running already.
+func (m *memProvider) StartSweeper(interval time.Duration) {
+	m.rmu.Lock()
+	defer m.rmu.Unlock()
+
+	if m.stop == nil {
+		m.stop = startSweeper(m.clock, interval, func() { m.SweepRetained() })
+	}
+}
+
+// startSweeper calls sweep every interval until the returned channel is closed.
+func startSweeper(c clock.Clock, interval time.Duration, sweep func()) chan struct{} {
+	stop := make(chan struct{})
+	ticker := c.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C():
+				sweep()
+			}
+		}
+	}()
+	return stop
+}
+
+// Close stops the sweeper and empties the trees, nothing of the memory provider outlives it. The
+// provider stays usable, a late call finds no subscription and no retained message.
 func (m *memProvider) Close() error {
-	m.subscribeRoot = nil
-	m.retainedRoot = nil
+	m.stopWriter()
+
+	m.rmu.Lock()
+	defer m.rmu.Unlock()
+
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+	m.subscribeRoot.Store(newSubscribeNode())
+	m.clearMatchCache()
+	m.retainedRoot = newRetainNode()
+	if m.retainAccount != nil {
+		m.retainAccount = newRetainAccount(m.retainAccount.limits)
+	}
 	return nil
 }
 
 // subscription nodes
 type subscribeNode struct {
-	// If this is the end of the topic string, then add subscribers here
-	subList []interface{}
-	qosList []byte
+	// If this is the end of the topic string, then add subscribers here, by ID
+	subSet *subscriberSet
+
+	// Shared subscriptions to this topic by share group
+	sharedGroups map[string]*sharedGroup
 
 	// Otherwise add the next topic level here
 	subscribeNodesMap map[string]*subscribeNode
@@ -140,25 +360,76 @@ func newSubscribeNode() *subscribeNode {
 	}
 }
 
-func (s *subscribeNode) subscriberInsert(topic []byte, qos byte, sub interface{}) error {
-	// If there's no more topic levels, that means we are at the matching subscribeNode
-	// to insert the subscriber. So let's see if there's such subscriber,
-	// if so, update it. Otherwise insert it.
-	if len(topic) == 0 {
-		// Let's see if the subscriber is already on the list. If yes, update
-		// QoS and then return.
-		for i := range s.subList {
-			if equal(s.subList[i], sub) {
-				s.qosList[i] = qos
-				return nil
-			}
+// clone copies the node before it's changed, the readers keep matching the previous version. The
+// children are shared until they are cloned too.
+func (s *subscribeNode) clone() *subscribeNode {
+	c := &subscribeNode{
+		subSet:            s.subSet.clone(),
+		subscribeNodesMap: make(map[string]*subscribeNode, len(s.subscribeNodesMap)),
+	}
+	for level, n := range s.subscribeNodesMap {
+		c.subscribeNodesMap[level] = n
+	}
+	if s.sharedGroups != nil {
+		c.sharedGroups = make(map[string]*sharedGroup, len(s.sharedGroups))
+		for name, g := range s.sharedGroups {
+			c.sharedGroups[name] = g
 		}
+	}
+	return c
+}
 
-		// Otherwise add.
-		s.subList = append(s.subList, sub)
-		s.qosList = append(s.qosList, qos)
+// freshNodes holds the nodes copied by a batch of inserts, they belong to the version being built
+// and are changed in place by the next inserts of the batch instead of being copied again.
+type freshNodes map[*subscribeNode]struct{}
 
-		return nil
+// insertChild returns the child of the level to insert into, a new node or a copy of the present
+// one, unless the batch has copied it already.
+func (s *subscribeNode) insertChild(level string, batch freshNodes) *subscribeNode {
+	n, ok := s.subscribeNodesMap[level]
+	if ok {
+		if _, fresh := batch[n]; fresh {
+			return n
+		}
+		n = n.clone()
+	} else {
+		n = newSubscribeNode()
+	}
+	s.subscribeNodesMap[level] = n
+	if batch != nil {
+		batch[n] = struct{}{}
+	}
+	return n
+}
+
+// empty reports whether the node has neither subscribers nor next levels.
+func (s *subscribeNode) empty() bool {
+	return s.subSet.len() == 0 && len(s.sharedGroups) == 0 && len(s.subscribeNodesMap) == 0
+}
+
+func (s *subscribeNode) subscriberInsert(topic []byte, qos byte, sub Subscriber) error {
+	_, err := s.keyedSubscriberInsert(topic, qos, sub, 0, nil)
+	return err
+}
+
+// groupSubscriberInsert inserts a member of the share group, existed is whether the subscriber was
+// subscribed already and only its QoS is updated. The nodes copied already by the batch of the
+// insert, if any, are changed in place.
+func (s *subscribeNode) groupSubscriberInsert(topic []byte, qos byte, sub Subscriber, group string, batch freshNodes) (existed bool, err error) {
+	// If there's no more topic levels, that means we are at the matching subscribeNode
+	// to insert the subscriber.
+	if len(topic) == 0 {
+		if s.sharedGroups == nil {
+			s.sharedGroups = make(map[string]*sharedGroup)
+		}
+		g, ok := s.sharedGroups[group]
+		if !ok {
+			g = &sharedGroup{}
+		} else {
+			g = g.clone()
+		}
+		s.sharedGroups[group] = g
+		return g.insert(qos, sub), nil
 	}
 
 	// Not the last level, so let's find or create the next level subscribeNode, and
@@ -167,45 +438,38 @@ func (s *subscribeNode) subscriberInsert(topic []byte, qos byte, sub interface{}
 	// ntl = next topic level
 	ntl, rem, err := nextTopicLevel(topic)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	level := string(ntl)
-
-	// Add subscribeNode if it doesn't already exist
-	n, ok := s.subscribeNodesMap[level]
-	if !ok {
-		n = newSubscribeNode()
-		s.subscribeNodesMap[level] = n
-	}
+	// Add subscribeNode if it doesn't already exist, or copy it
+	n := s.insertChild(string(ntl), batch)
 
-	return n.subscriberInsert(rem, qos, sub)
+	return n.groupSubscriberInsert(rem, qos, sub, group, batch)
 }
 
 // This remove implementation ignores the QoS, as long as the subscriber
 // matches then it's removed
-func (s *subscribeNode) subscriberRemove(topic []byte, sub interface{}) error {
+func (s *subscribeNode) subscriberRemove(topic []byte, sub Subscriber) error {
+	return s.keyedSubscriberRemove(topic, sub)
+}
+
+// groupSubscriberRemove removes a member of the share group, a nil subscriber removes the group.
+func (s *subscribeNode) groupSubscriberRemove(topic []byte, sub Subscriber, group string) error {
 	// If the topic is empty, it means we are at the final matching subscribeNode. If so,
-	// let's find the matching subscribers and remove them.
+	// let's find the matching subscriber and remove it.
 	if len(topic) == 0 {
-		// If subscriber == nil, then it's signal to remove ALL subscribers
-		if sub == nil {
-			s.subList = s.subList[0:0]
-			s.qosList = s.qosList[0:0]
-			return nil
+		g, ok := s.sharedGroups[group]
+		if ok {
+			g = g.clone()
+			s.sharedGroups[group] = g
 		}
-
-		// If we find the subscriber then remove it from the list. Technically
-		// we just overwrite the slot by shifting all other items up by one.
-		for i := range s.subList {
-			if equal(s.subList[i], sub) {
-				s.subList = append(s.subList[:i], s.subList[i+1:]...)
-				s.qosList = append(s.qosList[:i], s.qosList[i+1:]...)
-				return nil
-			}
+		if !ok || (sub != nil && !g.remove(sub)) {
+			return fmt.Errorf("topics/mem_provider/subscriberRemove: No topic found for subscriber")
 		}
-
-		return fmt.Errorf("topics/mem_provider/subscriberRemove: No topic found for subscriber")
+		if sub == nil || len(g.subList) == 0 {
+			delete(s.sharedGroups, group)
+		}
+		return nil
 	}
 
 	// Not the last level, so let's find the next level subscribeNode, and recursively
@@ -224,15 +488,17 @@ func (s *subscribeNode) subscriberRemove(topic []byte, sub interface{}) error {
 	if !ok {
 		return fmt.Errorf("topics/mem_provider/subscriberRemove: No topic found")
 	}
+	n = n.clone()
+	s.subscribeNodesMap[level] = n
 
 	// Remove the subscriber from the next level subscribeNode
-	if err := n.subscriberRemove(rem, sub); err != nil {
+	if err := n.groupSubscriberRemove(rem, sub, group); err != nil {
 		return err
 	}
 
 	// If there are no more subscribers and subscribeNode to the next level we just visited
 	// let's remove it
-	if len(n.subList) == 0 && len(n.subscribeNodesMap) == 0 {
+	if n.empty() {
 		delete(s.subscribeNodesMap, level)
 	}
 
@@ -243,7 +509,7 @@ func (s *subscribeNode) subscriberRemove(topic []byte, sub interface{}) error {
 // with no wildcards (publish topic), it returns a list of subscribers that subscribes
 // to the topic. For each of the level names, it's a match
 // - if there are subscribers to '#', then all the subscribers are added to result set
-func (s *subscribeNode) subscriberMatch(topic []byte, qos byte, subList *[]interface{}, qosList *[]byte) error {
+func (s *subscribeNode) subscriberMatch(topic []byte, qos byte, subList *[]Subscriber, qosList *[]byte) error {
 	// If the topic is empty, it means we are at the final matching subscribeNode. If so,
 	// let's find the subscribers that match the qos and append them to the list.
 	if len(topic) == 0 {
@@ -277,6 +543,8 @@ func (s *subscribeNode) subscriberMatch(topic []byte, qos byte, subList *[]inter
 type retainNode struct {
 	// If this is the end of the topic string, then add retained messages here
 	message *packets.PublishPacket
+	// The message expires at this time, never if it's zero
+	expires time.Time
 	// Otherwise add the next topic level here
 	retainNodesMap map[string]*retainNode
 }
@@ -287,13 +555,22 @@ func newRetainNode() *retainNode {
 	}
 }
 
+// expired tells if the message has expired at now, nothing expires at the zero time.
+func (r *retainNode) expired(now time.Time) bool {
+	return !now.IsZero() && !r.expires.IsZero() && !now.Before(r.expires)
+}
+
 func (r *retainNode) retainInsert(topic []byte, message *packets.PublishPacket) error {
+	return r.retainInsertUntil(topic, message, time.Time{})
+}
+
+// retainInsertUntil inserts the message expiring at the deadline, it replaces the message of the
+// topic if any.
+func (r *retainNode) retainInsertUntil(topic []byte, message *packets.PublishPacket, deadline time.Time) error {
 	// If there's no more topic levels, that means we are at the matching retainNode.
 	if len(topic) == 0 {
-		// Reuse the message if possible
-		if r.message == nil {
-			r.message = message
-		}
+		r.message = message
+		r.expires = deadline
 
 		return nil
 	}
@@ -316,7 +593,7 @@ func (r *retainNode) retainInsert(topic []byte, message *packets.PublishPacket)
 		r.retainNodesMap[level] = n
 	}
 
-	return n.retainInsert(rem, message)
+	return n.retainInsertUntil(rem, message, deadline)
 }
 
 // Remove the retained message for the supplied topic
@@ -325,6 +602,7 @@ func (r *retainNode) retainRemove(topic []byte) error {
 	// let's remove the buffer and message.
 	if len(topic) == 0 {
 		r.message = nil
+		r.expires = time.Time{}
 		return nil
 	}
 
@@ -350,8 +628,9 @@ func (r *retainNode) retainRemove(topic []byte) error {
 		return err
 	}
 
-	// If there are no more retainNode to the next level we just visited let's remove it
-	if len(n.retainNodesMap) == 0 {
+	// If the retainNode of the next level we just visited holds no message and no more
+	// retainNode, let's remove it
+	if n.message == nil && len(n.retainNodesMap) == 0 {
 		delete(r.retainNodesMap, level)
 	}
 
@@ -362,10 +641,15 @@ func (r *retainNode) retainRemove(topic []byte) error {
 // of a reverse match compare to match() since the supplied topic can contain
 // wildcards, whereas the retained message topic is a full (no wildcard) topic.
 func (r *retainNode) retainMatch(topic []byte, messageList *[]*packets.PublishPacket) error {
+	return r.retainMatchAt(topic, time.Time{}, messageList)
+}
+
+// retainMatchAt skips the messages expired at now.
+func (r *retainNode) retainMatchAt(topic []byte, now time.Time, messageList *[]*packets.PublishPacket) error {
 	// If the topic is empty, it means we are at the final matching retainNode. If so,
 	// add the retained msg to the list.
 	if len(topic) == 0 {
-		if r.message != nil {
+		if r.message != nil && !r.expired(now) {
 			*messageList = append(*messageList, r.message)
 		}
 		return nil
@@ -381,18 +665,18 @@ func (r *retainNode) retainMatch(topic []byte, messageList *[]*packets.PublishPa
 
 	if level == MWC {
 		// If '#', add all retained messages starting this node
-		r.allRetained(messageList)
+		r.allRetained(now, messageList)
 	} else if level == SWC {
 		// If '+', check all nodes at this level. Next levels must be matched.
 		for _, n := range r.retainNodesMap {
-			if err := n.retainMatch(rem, messageList); err != nil {
+			if err := n.retainMatchAt(rem, now, messageList); err != nil {
 				return err
 			}
 		}
 	} else {
 		// Otherwise, find the matching node, go to the next level
 		if n, ok := r.retainNodesMap[level]; ok {
-			if err := n.retainMatch(rem, messageList); err != nil {
+			if err := n.retainMatchAt(rem, now, messageList); err != nil {
 				return err
 			}
 		}
@@ -401,79 +685,62 @@ func (r *retainNode) retainMatch(topic []byte, messageList *[]*packets.PublishPa
 	return nil
 }
 
-func (r *retainNode) allRetained(messageList *[]*packets.PublishPacket) {
-	if r.message != nil {
+func (r *retainNode) allRetained(now time.Time, messageList *[]*packets.PublishPacket) {
+	if r.message != nil && !r.expired(now) {
 		*messageList = append(*messageList, r.message)
 	}
 
 	for _, n := range r.retainNodesMap {
-		n.allRetained(messageList)
+		n.allRetained(now, messageList)
 	}
 }
 
-const (
-	stateCHR byte = iota // Regular character
-	stateMWC             // Multi-level wildcard
-	stateSWC             // Single-level wildcard
-	//stateSEP             // Topic level separator
-	stateSYS // System level topic ($)
-)
-
-func NextTopicLevel(topic []byte) ([]byte, []byte, error) {
-	return nextTopicLevel(topic)
-}
-
-// Returns topic level, remaining topic levels and any errors
-func nextTopicLevel(topic []byte) ([]byte, []byte, error) {
-	s := stateCHR
-
-	for i, c := range topic {
-		switch c {
-		case '/':
-			if s == stateMWC {
-				return nil, nil, fmt.Errorf("topics/mem_provider/nextTopicLevel: Multi-level wildcard found in topic and it's not at the last level")
-			}
-
-			if i == 0 {
-				return []byte(SWC), topic[i+1:], nil
-			}
-
-			return topic[:i], topic[i+1:], nil
-
-		case '#':
-			if i != 0 {
-				return nil, nil, fmt.Errorf("topics/mem_provider/nextTopicLevel: Wildcard character '#' must occupy entire topic level")
-			}
-
-			s = stateMWC
-
-		case '+':
-			if i != 0 {
-				return nil, nil, fmt.Errorf("topics/mem_provider/nextTopicLevel: Wildcard character '+' must occupy entire topic level")
-			}
+// retainDeadline returns the deadline of the message of the topic, false if it never expires or
+// has expired at now.
+func (r *retainNode) retainDeadline(topic []byte, now time.Time) (time.Time, bool) {
+	if len(topic) == 0 {
+		return r.expires, r.message != nil && !r.expires.IsZero() && !r.expired(now)
+	}
 
-			s = stateSWC
+	ntl, rem, err := nextTopicLevel(topic)
+	if err != nil {
+		return time.Time{}, false
+	}
 
-		case '$':
-			/*if i == 0 {
-				return nil, nil, fmt.Errorf("topics/mem_provider/nextTopicLevel: Cannot publish to topics containing '$' character")
-			}*/
+	n, ok := r.retainNodesMap[string(ntl)]
+	if !ok {
+		return time.Time{}, false
+	}
+	return n.retainDeadline(rem, now)
+}
 
-			s = stateSYS
+// retainSweep removes the messages expired at now under the node, their deadlines are added to
+// removed by topic. The nodes left empty are removed too.
+func (r *retainNode) retainSweep(prefix string, now time.Time, removed map[string]time.Time, expired *[]*packets.PublishPacket) {
+	for level, n := range r.retainNodesMap {
+		topic := prefix + level
+		if n.message != nil && n.expired(now) {
+			removed[topic] = n.expires
+			*expired = append(*expired, n.message)
+			n.message = nil
+			n.expires = time.Time{}
+		}
 
-		default:
-			if s == stateMWC || s == stateSWC {
-				return nil, nil, fmt.Errorf("topics/mem_provider/nextTopicLevel: Wildcard characters '#' and '+' must occupy entire topic level")
-			}
+		n.retainSweep(topic+SEP, now, removed, expired)
 
-			s = stateCHR
+		if n.message == nil && len(n.retainNodesMap) == 0 {
+			delete(r.retainNodesMap, level)
 		}
 	}
+}
+
+func NextTopicLevel(topic []byte) ([]byte, []byte, error) {
+	return nextTopicLevel(topic)
+}
 
-	// If we got here that means we didn't hit the separator along the way, so the
-	// topic is either empty, or does not contain a separator. Either way, we return
-	// the full topic
-	return topic, nil, nil
+// Returns topic level, remaining topic levels and any errors
+func nextTopicLevel(topic []byte) ([]byte, []byte, error) {
+	return matcher.NextLevel(topic)
 }
 
 // The QoS of the payload messages sent in response to a subscription must be the
@@ -485,77 +752,13 @@ func nextTopicLevel(topic []byte) ([]byte, []byte, error) {
 // due to the QoS granted is lower than the published message QoS. For example,
 // if the client is granted only QoS 0, and the publish message is QoS 1, then this
 // client is not to be send the published message.
-func (s *subscribeNode) matchQos(qos byte, subList *[]interface{}, qosList *[]byte) {
-	for _, sub := range s.subList {
-		// If the published QoS is higher than the subscriber QoS, then we skip the
-		// subscriber. Otherwise, add to the list.
-		// if qos >= this.qos[i] {
-		*subList = append(*subList, sub)
-		*qosList = append(*qosList, qos)
-		// }
+func (s *subscribeNode) matchQos(qos byte, subList *[]Subscriber, qosList *[]byte) {
+	if s.subSet != nil {
+		s.subSet.match(qos, subList, qosList)
 	}
-}
-
-func Equal(k1, k2 interface{}) bool {
-	return equal(k1, k2)
-}
-
-func equal(k1, k2 interface{}) bool {
-	if reflect.TypeOf(k1) != reflect.TypeOf(k2) {
-		return false
-	}
-
-	if reflect.ValueOf(k1).Kind() == reflect.Func {
-		return &k1 == &k2
-	}
-
-	if k1 == k2 {
-		return true
-	}
-
-	switch k1 := k1.(type) {
-	case string:
-		return k1 == k2.(string)
-
-	case int64:
-		return k1 == k2.(int64)
-
-	case int32:
-		return k1 == k2.(int32)
-
-	case int16:
-		return k1 == k2.(int16)
-
-	case int8:
-		return k1 == k2.(int8)
-
-	case int:
-		return k1 == k2.(int)
-
-	case float32:
-		return k1 == k2.(float32)
-
-	case float64:
-		return k1 == k2.(float64)
-
-	case uint:
-		return k1 == k2.(uint)
-
-	case uint8:
-		return k1 == k2.(uint8)
-
-	case uint16:
-		return k1 == k2.(uint16)
-
-	case uint32:
-		return k1 == k2.(uint32)
-
-	case uint64:
-		return k1 == k2.(uint64)
-
-	case uintptr:
-		return k1 == k2.(uintptr)
+	// One member of each share group
+	for _, g := range s.sharedGroups {
+		*subList = append(*subList, g.pick())
+		*qosList = append(*qosList, qos)
 	}
-
-	return false
 }
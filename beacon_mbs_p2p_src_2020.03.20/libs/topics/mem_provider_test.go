@@ -1,12 +1,24 @@
 package topics
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
 	"github.com/stretchr/testify/require"
 )
 
+// testSubscriber is a subscriber of the tests, its name is its ID.
+type testSubscriber string
+
+func (s testSubscriber) ID() string {
+	return string(s)
+}
+
+func (s testSubscriber) OnPublish(message *packets.PublishPacket) error {
+	return nil
+}
+
 func TestNextTopicLevelSuccess(t *testing.T) {
 	topics := [][]byte{
 		[]byte("sport/tennis/player1/#"),
@@ -82,136 +94,136 @@ func TestSubscribeNodeInsert1(t *testing.T) {
 	n := newSubscribeNode()
 	topic := []byte("sport/tennis/player1/#")
 
-	err := n.subscriberInsert(topic, 1, "sub1")
+	err := n.subscriberInsert(topic, 1, testSubscriber("sub1"))
 	require.NoError(t, err)
 	require.Equal(t, 1, len(n.subscribeNodesMap))
-	require.Equal(t, 0, len(n.subList))
+	require.Equal(t, 0, n.subSet.len())
 
 	n2, ok := n.subscribeNodesMap["sport"]
 	require.True(t, ok)
 	require.Equal(t, 1, len(n2.subscribeNodesMap))
-	require.Equal(t, 0, len(n2.subList))
+	require.Equal(t, 0, n2.subSet.len())
 
 	n3, ok := n2.subscribeNodesMap["tennis"]
 	require.True(t, ok)
 	require.Equal(t, 1, len(n3.subscribeNodesMap))
-	require.Equal(t, 0, len(n3.subList))
+	require.Equal(t, 0, n3.subSet.len())
 
 	n4, ok := n3.subscribeNodesMap["player1"]
 	require.True(t, ok)
 	require.Equal(t, 1, len(n4.subscribeNodesMap))
-	require.Equal(t, 0, len(n4.subList))
+	require.Equal(t, 0, n4.subSet.len())
 
 	n5, ok := n4.subscribeNodesMap["#"]
 	require.True(t, ok)
 	require.Equal(t, 0, len(n5.subscribeNodesMap))
-	require.Equal(t, 1, len(n5.subList))
-	require.Equal(t, "sub1", n5.subList[0].(string))
+	require.Equal(t, 1, n5.subSet.len())
+	require.Equal(t, testSubscriber("sub1"), n5.subSet.buckets[setBucket("sub1")]["sub1"].sub)
 }
 
 func TestSubscribeNodeInsert2(t *testing.T) {
 	n := newSubscribeNode()
 	topic := []byte("#")
 
-	err := n.subscriberInsert(topic, 1, "sub1")
+	err := n.subscriberInsert(topic, 1, testSubscriber("sub1"))
 	require.NoError(t, err)
 	require.Equal(t, 1, len(n.subscribeNodesMap))
-	require.Equal(t, 0, len(n.subList))
+	require.Equal(t, 0, n.subSet.len())
 
 	n2, ok := n.subscribeNodesMap["#"]
 	require.True(t, ok)
 	require.Equal(t, 0, len(n2.subscribeNodesMap))
-	require.Equal(t, 1, len(n2.subList))
-	require.Equal(t, "sub1", n2.subList[0].(string))
+	require.Equal(t, 1, n2.subSet.len())
+	require.Equal(t, testSubscriber("sub1"), n2.subSet.buckets[setBucket("sub1")]["sub1"].sub)
 }
 
 func TestSubscribeNodeInsert3(t *testing.T) {
 	n := newSubscribeNode()
 	topic := []byte("+/tennis/#")
 
-	err := n.subscriberInsert(topic, 1, "sub1")
+	err := n.subscriberInsert(topic, 1, testSubscriber("sub1"))
 	require.NoError(t, err)
 	require.Equal(t, 1, len(n.subscribeNodesMap))
-	require.Equal(t, 0, len(n.subList))
+	require.Equal(t, 0, n.subSet.len())
 
 	n2, ok := n.subscribeNodesMap["+"]
 	require.True(t, ok)
 	require.Equal(t, 1, len(n2.subscribeNodesMap))
-	require.Equal(t, 0, len(n2.subList))
+	require.Equal(t, 0, n2.subSet.len())
 
 	n3, ok := n2.subscribeNodesMap["tennis"]
 	require.True(t, ok)
 	require.Equal(t, 1, len(n3.subscribeNodesMap))
-	require.Equal(t, 0, len(n3.subList))
+	require.Equal(t, 0, n3.subSet.len())
 
 	n4, ok := n3.subscribeNodesMap["#"]
 	require.True(t, ok)
 	require.Equal(t, 0, len(n4.subscribeNodesMap))
-	require.Equal(t, 1, len(n4.subList))
-	require.Equal(t, "sub1", n4.subList[0].(string))
+	require.Equal(t, 1, n4.subSet.len())
+	require.Equal(t, testSubscriber("sub1"), n4.subSet.buckets[setBucket("sub1")]["sub1"].sub)
 }
 
 func TestSubscribeNodeInsert4(t *testing.T) {
 	n := newSubscribeNode()
 	topic := []byte("/finance")
 
-	err := n.subscriberInsert(topic, 1, "sub1")
+	err := n.subscriberInsert(topic, 1, testSubscriber("sub1"))
 
 	require.NoError(t, err)
 	require.Equal(t, 1, len(n.subscribeNodesMap))
-	require.Equal(t, 0, len(n.subList))
+	require.Equal(t, 0, n.subSet.len())
 	n2, ok := n.subscribeNodesMap["+"]
 
 	require.True(t, ok)
 	require.Equal(t, 1, len(n2.subscribeNodesMap))
-	require.Equal(t, 0, len(n2.subList))
+	require.Equal(t, 0, n2.subSet.len())
 
 	n3, ok := n2.subscribeNodesMap["finance"]
 	require.True(t, ok)
 	require.Equal(t, 0, len(n3.subscribeNodesMap))
-	require.Equal(t, 1, len(n3.subList))
-	require.Equal(t, "sub1", n3.subList[0].(string))
+	require.Equal(t, 1, n3.subSet.len())
+	require.Equal(t, testSubscriber("sub1"), n3.subSet.buckets[setBucket("sub1")]["sub1"].sub)
 }
 
 func TestSubscribeNodeInsertDup(t *testing.T) {
 	n := newSubscribeNode()
 	topic := []byte("/finance")
 
-	err := n.subscriberInsert(topic, 1, "sub1")
-	err = n.subscriberInsert(topic, 1, "sub1")
+	err := n.subscriberInsert(topic, 1, testSubscriber("sub1"))
+	err = n.subscriberInsert(topic, 1, testSubscriber("sub1"))
 	require.NoError(t, err)
 	require.Equal(t, 1, len(n.subscribeNodesMap))
-	require.Equal(t, 0, len(n.subList))
+	require.Equal(t, 0, n.subSet.len())
 
 	n2, ok := n.subscribeNodesMap["+"]
 	require.True(t, ok)
 	require.Equal(t, 1, len(n2.subscribeNodesMap))
-	require.Equal(t, 0, len(n2.subList))
+	require.Equal(t, 0, n2.subSet.len())
 
 	n3, ok := n2.subscribeNodesMap["finance"]
 	require.True(t, ok)
 	require.Equal(t, 0, len(n3.subscribeNodesMap))
-	require.Equal(t, 1, len(n3.subList))
-	require.Equal(t, "sub1", n3.subList[0].(string))
+	require.Equal(t, 1, n3.subSet.len())
+	require.Equal(t, testSubscriber("sub1"), n3.subSet.buckets[setBucket("sub1")]["sub1"].sub)
 }
 
 func TestSubscribeNodeRemove1(t *testing.T) {
 	n := newSubscribeNode()
 	topic := []byte("sport/tennis/player1/#")
 
-	require.NoError(t, n.subscriberInsert(topic, 1, "sub1"))
-	err := n.subscriberRemove([]byte("sport/tennis/player1/#"), "sub1")
+	require.NoError(t, n.subscriberInsert(topic, 1, testSubscriber("sub1")))
+	err := n.subscriberRemove([]byte("sport/tennis/player1/#"), testSubscriber("sub1"))
 	require.NoError(t, err)
 	require.Equal(t, 0, len(n.subscribeNodesMap))
-	require.Equal(t, 0, len(n.subList))
+	require.Equal(t, 0, n.subSet.len())
 }
 
 func TestSubscribeNodeRemove2(t *testing.T) {
 	n := newSubscribeNode()
 	topic := []byte("sport/tennis/player1/#")
 
-	require.NoError(t, n.subscriberInsert(topic, 1, "sub1"))
-	err := n.subscriberRemove([]byte("sport/tennis/player1"), "sub1")
+	require.NoError(t, n.subscriberInsert(topic, 1, testSubscriber("sub1")))
+	err := n.subscriberRemove([]byte("sport/tennis/player1"), testSubscriber("sub1"))
 	require.Error(t, err)
 }
 
@@ -219,20 +231,20 @@ func TestSubscribeNodeRemove3(t *testing.T) {
 	n := newSubscribeNode()
 	topic := []byte("sport/tennis/player1/#")
 
-	require.NoError(t, n.subscriberInsert(topic, 1, "sub1"))
-	require.NoError(t, n.subscriberInsert(topic, 1, "sub2"))
+	require.NoError(t, n.subscriberInsert(topic, 1, testSubscriber("sub1")))
+	require.NoError(t, n.subscriberInsert(topic, 1, testSubscriber("sub2")))
 	err := n.subscriberRemove([]byte("sport/tennis/player1/#"), nil)
 	require.NoError(t, err)
 	require.Equal(t, 0, len(n.subscribeNodesMap))
-	require.Equal(t, 0, len(n.subList))
+	require.Equal(t, 0, n.subSet.len())
 }
 
 func TestSubscribeNodeMatch1(t *testing.T) {
 	n := newSubscribeNode()
 	topic := []byte("sport/tennis/player1/#")
-	require.NoError(t, n.subscriberInsert(topic, 1, "sub1"))
+	require.NoError(t, n.subscriberInsert(topic, 1, testSubscriber("sub1")))
 
-	subList := make([]interface{}, 0, 5)
+	subList := make([]Subscriber, 0, 5)
 	qosList := make([]byte, 0, 5)
 
 	err := n.subscriberMatch([]byte("sport/tennis/player1/angel"), 1, &subList, &qosList)
@@ -244,9 +256,9 @@ func TestSubscribeNodeMatch1(t *testing.T) {
 func TestSubscribeNodeMatch2(t *testing.T) {
 	n := newSubscribeNode()
 	topic := []byte("sport/tennis/player1/#")
-	require.NoError(t, n.subscriberInsert(topic, 1, "sub1"))
+	require.NoError(t, n.subscriberInsert(topic, 1, testSubscriber("sub1")))
 
-	subList := make([]interface{}, 0, 5)
+	subList := make([]Subscriber, 0, 5)
 	qosList := make([]byte, 0, 5)
 
 	err := n.subscriberMatch([]byte("sport/tennis/player1/angel"), 1, &subList, &qosList)
@@ -258,9 +270,9 @@ func TestSubscribeNodeMatch2(t *testing.T) {
 func TestSubscribeNodeMatch3(t *testing.T) {
 	n := newSubscribeNode()
 	topic := []byte("sport/tennis/player1/#")
-	require.NoError(t, n.subscriberInsert(topic, 2, "sub1"))
+	require.NoError(t, n.subscriberInsert(topic, 2, testSubscriber("sub1")))
 
-	subList := make([]interface{}, 0, 5)
+	subList := make([]Subscriber, 0, 5)
 	qosList := make([]byte, 0, 5)
 
 	err := n.subscriberMatch([]byte("sport/tennis/player1/angel"), 2, &subList, &qosList)
@@ -271,9 +283,9 @@ func TestSubscribeNodeMatch3(t *testing.T) {
 
 func TestSubscribeNodeMatch4(t *testing.T) {
 	n := newSubscribeNode()
-	require.NoError(t, n.subscriberInsert([]byte("sport/tennis/#"), 2, "sub1"))
+	require.NoError(t, n.subscriberInsert([]byte("sport/tennis/#"), 2, testSubscriber("sub1")))
 
-	subList := make([]interface{}, 0, 5)
+	subList := make([]Subscriber, 0, 5)
 	qosList := make([]byte, 0, 5)
 
 	err := n.subscriberMatch([]byte("sport/tennis/player1/angel"), 2, &subList, &qosList)
@@ -284,10 +296,10 @@ func TestSubscribeNodeMatch4(t *testing.T) {
 
 func TestSubscribeNodeMatch5(t *testing.T) {
 	n := newSubscribeNode()
-	require.NoError(t, n.subscriberInsert([]byte("sport/tennis/+/angel"), 1, "sub1"))
-	require.NoError(t, n.subscriberInsert([]byte("sport/tennis/player1/angel"), 1, "sub2"))
+	require.NoError(t, n.subscriberInsert([]byte("sport/tennis/+/angel"), 1, testSubscriber("sub1")))
+	require.NoError(t, n.subscriberInsert([]byte("sport/tennis/player1/angel"), 1, testSubscriber("sub2")))
 
-	subList := make([]interface{}, 0, 5)
+	subList := make([]Subscriber, 0, 5)
 	qosList := make([]byte, 0, 5)
 
 	err := n.subscriberMatch([]byte("sport/tennis/player1/angel"), 1, &subList, &qosList)
@@ -297,23 +309,23 @@ func TestSubscribeNodeMatch5(t *testing.T) {
 
 func TestSubscribeNodeMatch6(t *testing.T) {
 	n := newSubscribeNode()
-	require.NoError(t, n.subscriberInsert([]byte("sport/tennis/#"), 2, "sub1"))
-	require.NoError(t, n.subscriberInsert([]byte("sport/tennis"), 1, "sub2"))
+	require.NoError(t, n.subscriberInsert([]byte("sport/tennis/#"), 2, testSubscriber("sub1")))
+	require.NoError(t, n.subscriberInsert([]byte("sport/tennis"), 1, testSubscriber("sub2")))
 
-	subList := make([]interface{}, 0, 5)
+	subList := make([]Subscriber, 0, 5)
 	qosList := make([]byte, 0, 5)
 
 	err := n.subscriberMatch([]byte("sport/tennis/player1/angel"), 2, &subList, &qosList)
 	require.NoError(t, err)
 	require.Equal(t, 1, len(subList))
-	require.Equal(t, "sub1", subList[0])
+	require.Equal(t, testSubscriber("sub1"), subList[0])
 }
 
 func TestSubscribeNodeMatch7(t *testing.T) {
 	n := newSubscribeNode()
-	require.NoError(t, n.subscriberInsert([]byte("+/+"), 2, "sub1"))
+	require.NoError(t, n.subscriberInsert([]byte("+/+"), 2, testSubscriber("sub1")))
 
-	subList := make([]interface{}, 0, 5)
+	subList := make([]Subscriber, 0, 5)
 	qosList := make([]byte, 0, 5)
 
 	err := n.subscriberMatch([]byte("/finance"), 1, &subList, &qosList)
@@ -323,9 +335,9 @@ func TestSubscribeNodeMatch7(t *testing.T) {
 
 func TestSubscribeNodeMatch8(t *testing.T) {
 	n := newSubscribeNode()
-	require.NoError(t, n.subscriberInsert([]byte("/+"), 2, "sub1"))
+	require.NoError(t, n.subscriberInsert([]byte("/+"), 2, testSubscriber("sub1")))
 
-	subList := make([]interface{}, 0, 5)
+	subList := make([]Subscriber, 0, 5)
 	qosList := make([]byte, 0, 5)
 
 	err := n.subscriberMatch([]byte("/finance"), 1, &subList, &qosList)
@@ -335,9 +347,9 @@ func TestSubscribeNodeMatch8(t *testing.T) {
 
 func TestSubscribeNodeMatch9(t *testing.T) {
 	n := newSubscribeNode()
-	require.NoError(t, n.subscriberInsert([]byte("+"), 2, "sub1"))
+	require.NoError(t, n.subscriberInsert([]byte("+"), 2, testSubscriber("sub1")))
 
-	subList := make([]interface{}, 0, 5)
+	subList := make([]Subscriber, 0, 5)
 	qosList := make([]byte, 0, 5)
 
 	err := n.subscriberMatch([]byte("/finance"), 1, &subList, &qosList)
@@ -466,7 +478,7 @@ func TestMemTopicsSubscription(t *testing.T) {
 	mgr, err := NewManager("mem")
 	require.NoError(t, err)
 
-	qos, err := mgr.Subscribe([]byte("sports/tennis/+/stats"), 3, "sub1")
+	qos, err := mgr.Subscribe([]byte("sports/tennis/+/stats"), 3, testSubscriber("sub1"))
 	require.Error(t, err)
 	require.Equal(t, QosFailure, int(qos))
 
@@ -474,14 +486,14 @@ func TestMemTopicsSubscription(t *testing.T) {
 	require.Error(t, err)
 	require.Equal(t, QosFailure, int(qos))
 
-	qos, err = mgr.Subscribe([]byte("sports/tennis/+/stats"), QosExactlyOnce, "sub1")
+	qos, err = mgr.Subscribe([]byte("sports/tennis/+/stats"), QosExactlyOnce, testSubscriber("sub1"))
 	require.NoError(t, err)
 	require.Equal(t, 2, int(qos))
 
-	err = mgr.Unsubscribe([]byte("sports/tennis"), "sub1")
+	err = mgr.Unsubscribe([]byte("sports/tennis"), testSubscriber("sub1"))
 	require.Error(t, err)
 
-	subList := make([]interface{}, 5)
+	subList := make([]Subscriber, 5)
 	qosList := make([]byte, 5)
 
 	err = mgr.Subscribers([]byte("sports/tennis/angle/stats"), QosExactlyOnce, &subList, &qosList)
@@ -494,7 +506,7 @@ func TestMemTopicsSubscription(t *testing.T) {
 	require.Equal(t, 1, len(subList))
 	require.Equal(t, 1, int(qosList[0]))
 
-	err = mgr.Unsubscribe([]byte("sports/tennis/+/stats"), "sub1")
+	err = mgr.Unsubscribe([]byte("sports/tennis/+/stats"), testSubscriber("sub1"))
 	require.NoError(t, err)
 }
 
@@ -572,3 +584,365 @@ func newPublishMessageLarge(topic []byte, qos byte) *packets.PublishPacket {
 	msg.Payload = make([]byte, 1024*1024)
 	return msg
 }
+
+func TestParseSharedFilter(t *testing.T) {
+	group, filter, ok, err := ParseSharedFilter([]byte("$share/workers/jobs/#"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "workers", group)
+	require.Equal(t, []byte("jobs/#"), filter)
+
+	_, filter, ok, err = ParseSharedFilter([]byte("jobs/#"))
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Equal(t, []byte("jobs/#"), filter)
+
+	for _, f := range []string{"$share/", "$share//jobs", "$share/workers", "$share/workers/", "$share/a+/jobs"} {
+		_, _, ok, err = ParseSharedFilter([]byte(f))
+		require.True(t, ok)
+		require.Error(t, err, f)
+	}
+}
+
+func TestMemProviderSharedSubscriptions(t *testing.T) {
+	p := NewMemProvider()
+
+	_, err := p.Subscribe([]byte("$share/workers/jobs/+"), 1, testSubscriber("w1"))
+	require.NoError(t, err)
+	_, err = p.Subscribe([]byte("$share/workers/jobs/+"), 1, testSubscriber("w2"))
+	require.NoError(t, err)
+	_, err = p.Subscribe([]byte("$share/audit/jobs/#"), 1, testSubscriber("a1"))
+	require.NoError(t, err)
+	_, err = p.Subscribe([]byte("jobs/+"), 1, testSubscriber("s1"))
+	require.NoError(t, err)
+
+	var subs []Subscriber
+	var qoss []byte
+	got := make(map[Subscriber]int)
+	for i := 0; i < 4; i++ {
+		require.NoError(t, p.Subscribers([]byte("jobs/j1"), 1, &subs, &qoss))
+		// one member of each group and the regular subscriber
+		require.Len(t, subs, 3)
+		for _, s := range subs {
+			got[s]++
+		}
+	}
+	require.Equal(t, map[Subscriber]int{testSubscriber("w1"): 2, testSubscriber("w2"): 2, testSubscriber("a1"): 4, testSubscriber("s1"): 4}, got)
+
+	require.NoError(t, p.Unsubscribe([]byte("$share/workers/jobs/+"), testSubscriber("w1")))
+	require.Error(t, p.Unsubscribe([]byte("$share/workers/jobs/+"), testSubscriber("w1")))
+	require.NoError(t, p.Subscribers([]byte("jobs/j1"), 1, &subs, &qoss))
+	require.Contains(t, subs, testSubscriber("w2"))
+
+	require.NoError(t, p.Unsubscribe([]byte("$share/workers/jobs/+"), testSubscriber("w2")))
+	require.NoError(t, p.Unsubscribe([]byte("$share/audit/jobs/#"), testSubscriber("a1")))
+	require.NoError(t, p.Unsubscribe([]byte("jobs/+"), testSubscriber("s1")))
+	require.Len(t, p.root().subscribeNodesMap, 0)
+
+	_, err = p.Subscribe([]byte("$share/workers"), 1, testSubscriber("w1"))
+	require.Error(t, err)
+}
+
+type shareMember struct {
+	name      string
+	priority  int
+	weight    int
+	available bool
+}
+
+func (m *shareMember) SharePriority() (int, int) {
+	return m.priority, m.weight
+}
+
+func (m *shareMember) ShareAvailable() bool {
+	return m.available
+}
+
+func (m *shareMember) ID() string {
+	return m.name
+}
+
+func (m *shareMember) OnPublish(message *packets.PublishPacket) error {
+	return nil
+}
+
+func TestMemProviderSharePriority(t *testing.T) {
+	p := NewMemProvider()
+
+	p1 := &shareMember{name: "p1", priority: 1, weight: 3, available: true}
+	p2 := &shareMember{name: "p2", priority: 1, available: true}
+	standby := &shareMember{name: "standby", available: true}
+	for _, m := range []*shareMember{p1, p2, standby} {
+		_, err := p.Subscribe([]byte("$share/workers/jobs/+"), 1, m)
+		require.NoError(t, err)
+	}
+
+	deliveries := func(n int) map[string]int {
+		var subs []Subscriber
+		var qoss []byte
+		got := make(map[string]int)
+		for i := 0; i < n; i++ {
+			require.NoError(t, p.Subscribers([]byte("jobs/j1"), 1, &subs, &qoss))
+			require.Len(t, subs, 1)
+			got[subs[0].(*shareMember).name]++
+		}
+		return got
+	}
+
+	// the primaries by their weight
+	require.Equal(t, map[string]int{"p1": 6, "p2": 2}, deliveries(8))
+
+	// the standby member while the primaries are saturated
+	p1.available = false
+	require.Equal(t, map[string]int{"p2": 4}, deliveries(4))
+	p2.available = false
+	require.Equal(t, map[string]int{"standby": 4}, deliveries(4))
+
+	// the highest priority still when none is available
+	standby.available = false
+	got := deliveries(4)
+	require.Equal(t, 4, got["p1"]+got["p2"])
+}
+
+func TestMemProviderSubscribersVersion(t *testing.T) {
+	p := NewMemProvider()
+
+	_, err := p.Subscribe([]byte("a/+"), 1, testSubscriber("s1"))
+	require.NoError(t, err)
+	_, err = p.Subscribe([]byte("$share/g/a/+"), 1, testSubscriber("w1"))
+	require.NoError(t, err)
+	v1 := p.root()
+
+	_, err = p.Subscribe([]byte("a/+"), 1, testSubscriber("s2"))
+	require.NoError(t, err)
+	_, err = p.Subscribe([]byte("$share/g/a/+"), 1, testSubscriber("w2"))
+	require.NoError(t, err)
+	require.NoError(t, p.Unsubscribe([]byte("a/+"), testSubscriber("s1")))
+
+	// the previous version is left as it was
+	var subs []Subscriber
+	var qoss []byte
+	require.NoError(t, v1.subscriberMatch([]byte("a/b"), 1, &subs, &qoss))
+	require.Equal(t, []Subscriber{testSubscriber("s1"), testSubscriber("w1")}, subs)
+
+	subs = subs[:0]
+	require.NoError(t, p.Subscribers([]byte("a/b"), 1, &subs, &qoss))
+	require.Len(t, subs, 2)
+	require.Equal(t, testSubscriber("s2"), subs[0])
+
+	// a failed change publishes nothing
+	require.Error(t, p.Unsubscribe([]byte("a/+/c"), testSubscriber("s2")))
+	require.Len(t, p.root().subscribeNodesMap["a"].subscribeNodesMap["+"].subscribeNodesMap, 0)
+}
+
+func TestMemProviderConcurrentSubscribers(t *testing.T) {
+	p := NewMemProvider()
+	_, err := p.Subscribe([]byte("a/#"), 1, testSubscriber("always"))
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			filter := []byte(fmt.Sprintf("a/%d/+", i%10))
+			sub := testSubscriber(fmt.Sprint(i))
+			_, _ = p.Subscribe(filter, 1, sub)
+			_ = p.Unsubscribe(filter, sub)
+		}
+	}()
+
+	var subs []Subscriber
+	var qoss []byte
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		require.NoError(t, p.Subscribers([]byte("a/1/x"), 1, &subs, &qoss))
+		require.Contains(t, subs, testSubscriber("always"))
+	}
+}
+
+// newBenchProvider subscribes to 1000 filters under 10 first levels.
+func newBenchProvider(b *testing.B) *memProvider {
+	p := NewMemProvider()
+	for i := 0; i < 1000; i++ {
+		filter := fmt.Sprintf("site%d/device%d/+", i%10, i)
+		if _, err := p.Subscribe([]byte(filter), 1, testSubscriber(fmt.Sprint(i))); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if _, err := p.Subscribe([]byte("site1/#"), 1, testSubscriber("all")); err != nil {
+		b.Fatal(err)
+	}
+	return p
+}
+
+func BenchmarkMemProviderSubscribers(b *testing.B) {
+	p := newBenchProvider(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var subs []Subscriber
+		var qoss []byte
+		for pb.Next() {
+			_ = p.Subscribers([]byte("site1/device11/temp"), 1, &subs, &qoss)
+		}
+	})
+}
+
+// The subscriptions change while the messages are matched, the matches never wait for them.
+func BenchmarkMemProviderSubscribersWithChurn(b *testing.B) {
+	p := newBenchProvider(b)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			filter := []byte(fmt.Sprintf("site%d/churn/+", i%10))
+			_, _ = p.Subscribe(filter, 1, testSubscriber("churn"))
+			_ = p.Unsubscribe(filter, testSubscriber("churn"))
+		}
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var subs []Subscriber
+		var qoss []byte
+		for pb.Next() {
+			_ = p.Subscribers([]byte("site1/device11/temp"), 1, &subs, &qoss)
+		}
+	})
+}
+
+func TestMemProviderSubscriberSets(t *testing.T) {
+	p := NewMemProvider(WithSubscriberSets(2))
+	filter := []byte("sports/tennis/+")
+
+	_, err := p.Subscribe(filter, 1, keyedSubscriber("c1"))
+	require.NoError(t, err)
+	_, err = p.Subscribe(filter, 0, keyedSubscriber("c2"))
+	require.NoError(t, err)
+	// the same key is updated, a new one is over the bound
+	_, err = p.Subscribe(filter, 2, keyedSubscriber("c1"))
+	require.NoError(t, err)
+	_, err = p.Subscribe(filter, 1, keyedSubscriber("c3"))
+	require.Equal(t, ErrTooManySubscribers, err)
+	// the members of the share groups are not bounded
+	_, err = p.Subscribe([]byte("$share/g/sports/tennis/+"), 1, testSubscriber("w1"))
+	require.NoError(t, err)
+
+	var subs []Subscriber
+	var qoss []byte
+	require.NoError(t, p.Subscribers([]byte("sports/tennis/anzel"), 1, &subs, &qoss))
+	require.ElementsMatch(t, []Subscriber{keyedSubscriber("c1"), keyedSubscriber("c2"), testSubscriber("w1")}, subs)
+
+	require.NoError(t, p.Unsubscribe(filter, keyedSubscriber("c1")))
+	require.Error(t, p.Unsubscribe(filter, keyedSubscriber("c1")))
+	require.NoError(t, p.Unsubscribe(filter, keyedSubscriber("c2")))
+	require.NoError(t, p.Subscribers([]byte("sports/tennis/anzel"), 1, &subs, &qoss))
+	require.Equal(t, []Subscriber{testSubscriber("w1")}, subs)
+
+	require.NoError(t, p.Unsubscribe([]byte("$share/g/sports/tennis/+"), testSubscriber("w1")))
+	require.Empty(t, p.root().subscribeNodesMap)
+}
+
+func TestMemProviderSubscriberSetVersions(t *testing.T) {
+	p := NewMemProvider(WithSubscriberSets(200))
+	filter := []byte("a/+")
+
+	subs := make([]Subscription, 200)
+	for i := range subs {
+		subs[i] = Subscription{Filter: filter, Qos: 1, Subscriber: keyedSubscriber(fmt.Sprintf("c%d", i))}
+	}
+	_, errs := p.SubscribeBatch(subs)
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	v1 := p.root()
+
+	// the previous version keeps its subscribers, the buckets changed are copied
+	for i := 0; i < 100; i++ {
+		require.NoError(t, p.Unsubscribe(filter, keyedSubscriber(fmt.Sprintf("c%d", i))))
+	}
+	var list []Subscriber
+	var qoss []byte
+	require.NoError(t, v1.subscriberMatch([]byte("a/b"), 1, &list, &qoss))
+	require.Len(t, list, 200)
+	require.NoError(t, p.Subscribers([]byte("a/b"), 1, &list, &qoss))
+	require.Len(t, list, 100)
+	require.NotContains(t, list, keyedSubscriber("c0"))
+
+	// a failed batch leaves the subscribers as they were
+	_, errs = p.SubscribeBatch(subs)
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	v2 := p.root()
+	_, errs = p.SubscribeBatch([]Subscription{
+		{Filter: filter, Qos: 1, Subscriber: keyedSubscriber("c200")},
+		{Filter: filter, Qos: 1, Subscriber: keyedSubscriber("c201")},
+	})
+	require.Equal(t, []error{ErrTooManySubscribers, ErrTooManySubscribers}, errs)
+	require.True(t, p.root() == v2)
+	require.NoError(t, p.Subscribers([]byte("a/b"), 1, &list, &qoss))
+	require.Len(t, list, 200)
+	require.NotContains(t, list, keyedSubscriber("c200"))
+}
+
+func TestMemProviderSubscriberByID(t *testing.T) {
+	p := NewMemProvider()
+	filter := []byte("sports/tennis/+")
+
+	var got []string
+	f1 := &FuncSubscriber{SubscriberID: "f1", Fn: func(m *packets.PublishPacket) error {
+		got = append(got, m.TopicName)
+		return nil
+	}}
+	f2 := &FuncSubscriber{SubscriberID: "f2", Fn: func(m *packets.PublishPacket) error { return nil }}
+	_, err := p.Subscribe(filter, 1, f1)
+	require.NoError(t, err)
+	_, err = p.Subscribe(filter, 1, f2)
+	require.NoError(t, err)
+
+	var subs []Subscriber
+	var qoss []byte
+	require.NoError(t, p.Subscribers([]byte("sports/tennis/anzel"), 1, &subs, &qoss))
+	require.ElementsMatch(t, []Subscriber{f1, f2}, subs)
+	for _, sub := range subs {
+		require.NoError(t, sub.OnPublish(&packets.PublishPacket{TopicName: "sports/tennis/anzel"}))
+	}
+	require.Equal(t, []string{"sports/tennis/anzel"}, got)
+
+	// the subscriber of the same ID replaces it, the previous one is no longer subscribed
+	f1b := &FuncSubscriber{SubscriberID: "f1", Fn: f1.Fn}
+	_, err = p.Subscribe(filter, 1, f1b)
+	require.NoError(t, err)
+	require.Error(t, p.Unsubscribe(filter, f1))
+	require.NoError(t, p.Unsubscribe(filter, f1b))
+	require.NoError(t, p.Unsubscribe(filter, f2))
+	require.Empty(t, p.root().subscribeNodesMap)
+}
+
+// One client subscribes and unsubscribes to a filter of 20000 subscribers.
+func BenchmarkMemProviderSubscribeLargeFilter(b *testing.B) {
+	p := NewMemProvider()
+	filter := []byte("popular/topic")
+	for i := 0; i < 20000; i++ {
+		_, err := p.Subscribe(filter, 1, keyedSubscriber(fmt.Sprintf("client%d", i)))
+		require.NoError(b, err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = p.Subscribe(filter, 1, keyedSubscriber("churn"))
+		_ = p.Unsubscribe(filter, keyedSubscriber("churn"))
+	}
+}
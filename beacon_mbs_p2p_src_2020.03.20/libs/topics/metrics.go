@@ -0,0 +1,102 @@
+package topics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// MetricsSink receives the outcome and the duration of the provider operations made through the
+// Manager, so every provider is instrumented the same way without doing it itself. It's called on
+// the publishing path, it must not block.
+type MetricsSink interface {
+	ObserveSubscribe(filter string, d time.Duration, err error)
+	ObserveUnsubscribe(filter string, d time.Duration, err error)
+	// ObserveMatch is called for the subscribers lookup of a published topic.
+	ObserveMatch(topic string, subscribers int, d time.Duration, err error)
+	ObserveRetain(topic string, d time.Duration, err error)
+}
+
+// SetMetricsSink reports the provider operations to the sink, nil stops reporting them.
+func (m *Manager) SetMetricsSink(sink MetricsSink) {
+	m.sink = sink
+}
+
+type OpStats struct {
+	Calls       uint64 `json:"calls"`
+	Errors      uint64 `json:"errors"`
+	TotalMicros uint64 `json:"total_us"`
+	MaxMicros   uint64 `json:"max_us"`
+}
+
+type opCounter struct {
+	calls  uint64
+	errors uint64
+	total  uint64
+	max    uint64
+}
+
+func (c *opCounter) observe(d time.Duration, err error) {
+	atomic.AddUint64(&c.calls, 1)
+	if err != nil {
+		atomic.AddUint64(&c.errors, 1)
+	}
+	us := uint64(d / time.Microsecond)
+	atomic.AddUint64(&c.total, us)
+	for {
+		max := atomic.LoadUint64(&c.max)
+		if us <= max || atomic.CompareAndSwapUint64(&c.max, max, us) {
+			return
+		}
+	}
+}
+
+func (c *opCounter) stats() OpStats {
+	return OpStats{
+		Calls:       atomic.LoadUint64(&c.calls),
+		Errors:      atomic.LoadUint64(&c.errors),
+		TotalMicros: atomic.LoadUint64(&c.total),
+		MaxMicros:   atomic.LoadUint64(&c.max),
+	}
+}
+
+// StatsSink counts the calls, the errors and the time of each operation since it was created.
+type StatsSink struct {
+	subscribe   opCounter
+	unsubscribe opCounter
+	match       opCounter
+	retain      opCounter
+	matched     uint64
+}
+
+var _ MetricsSink = (*StatsSink)(nil)
+
+func NewStatsSink() *StatsSink {
+	return &StatsSink{}
+}
+
+func (s *StatsSink) ObserveSubscribe(filter string, d time.Duration, err error) {
+	s.subscribe.observe(d, err)
+}
+
+func (s *StatsSink) ObserveUnsubscribe(filter string, d time.Duration, err error) {
+	s.unsubscribe.observe(d, err)
+}
+
+func (s *StatsSink) ObserveMatch(topic string, subscribers int, d time.Duration, err error) {
+	s.match.observe(d, err)
+	atomic.AddUint64(&s.matched, uint64(subscribers))
+}
+
+func (s *StatsSink) ObserveRetain(topic string, d time.Duration, err error) {
+	s.retain.observe(d, err)
+}
+
+// Stats returns the counts by operation, and the number of subscribers found by the matches.
+func (s *StatsSink) Stats() (map[string]OpStats, uint64) {
+	return map[string]OpStats{
+		"subscribe":   s.subscribe.stats(),
+		"unsubscribe": s.unsubscribe.stats(),
+		"match":       s.match.stats(),
+		"retain":      s.retain.stats(),
+	}, atomic.LoadUint64(&s.matched)
+}
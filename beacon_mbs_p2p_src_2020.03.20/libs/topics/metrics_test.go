@@ -0,0 +1,33 @@
+package topics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsSink(t *testing.T) {
+	sink := NewStatsSink()
+	m := &Manager{ttp: NewMemProvider()}
+	m.SetMetricsSink(sink)
+
+	_, err := m.Subscribe([]byte("a/+"), 1, testSubscriber("s1"))
+	require.NoError(t, err)
+	_, err = m.Subscribe([]byte("a/#"), 1, testSubscriber("s2"))
+	require.NoError(t, err)
+	require.Error(t, m.Unsubscribe([]byte("b"), testSubscriber("s1")))
+
+	var subs []Subscriber
+	var qoss []byte
+	require.NoError(t, m.Subscribers([]byte("a/b"), 1, &subs, &qoss))
+	require.NoError(t, m.Retain(newRetainedPacket("a/b", "1")))
+
+	stats, matched := sink.Stats()
+	require.Equal(t, uint64(2), stats["subscribe"].Calls)
+	require.Equal(t, uint64(1), stats["unsubscribe"].Calls)
+	require.Equal(t, uint64(1), stats["unsubscribe"].Errors)
+	require.Equal(t, uint64(1), stats["match"].Calls)
+	require.Equal(t, uint64(1), stats["retain"].Calls)
+	require.Equal(t, uint64(2), matched)
+	require.True(t, stats["subscribe"].MaxMicros <= stats["subscribe"].TotalMicros)
+}
@@ -0,0 +1,344 @@
+package topics
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+	"awesomeProject/beacon/mqtt_network/libs/raftrepl"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// raftKind is the kind of the commands of the provider in the replicated log.
+const raftKind = "topics"
+
+var (
+	_ TheTopicsProvider       = (*raftProvider)(nil)
+	_ ExpiringProvider        = (*raftProvider)(nil)
+	_ ExpiryNotifyingProvider = (*raftProvider)(nil)
+	_ RestoringProvider       = (*raftProvider)(nil)
+
+	_ raftrepl.Applier = (*raftProvider)(nil)
+)
+
+// raftProvider keeps the subscription trie and the retained messages in memory like memProvider,
+// and commits the retained messages and the subscriptions of the PersistentSubscriber through the
+// replicated log, so the standby node holds them when it takes over. The retained messages change
+// when the log applies them, on every node alike; the subscriptions of the other nodes are held by
+// RestoredSubscriber until their subscriber subscribes here.
+type raftProvider struct {
+	mem *memProvider
+	fsm *raftrepl.FSM
+
+	mu sync.Mutex
+	// The committed subscriptions by subscriptionKey
+	subs map[string]snapshotSubscription
+	// The subscriptions of the subscribers of this node, and the restored ones of the others
+	live     map[string]bool
+	restored map[string]*RestoredSubscriber
+}
+
+// raftCommand is a command of the provider, one of the fields is set.
+type raftCommand struct {
+	Retain      *snapshotRetained     `json:"retain,omitempty"`
+	Subscribe   *snapshotSubscription `json:"subscribe,omitempty"`
+	Unsubscribe *snapshotSubscription `json:"unsubscribe,omitempty"`
+}
+
+// raftState is the snapshot of the provider.
+type raftState struct {
+	Retained      []snapshotRetained     `json:"retained"`
+	Subscriptions []snapshotSubscription `json:"subscriptions"`
+}
+
+// RegisterRaftTopicsProvider registers the provider committing through the log of the FSM as
+// "raft".
+func RegisterRaftTopicsProvider(fsm *raftrepl.FSM) {
+	Register("raft", NewRaftProvider(fsm))
+}
+
+func UnRegisterRaftTopicsProvider() {
+	Unregister("raft")
+}
+
+func NewRaftProvider(fsm *raftrepl.FSM) *raftProvider {
+	p := &raftProvider{
+		mem:      NewMemProvider(),
+		fsm:      fsm,
+		subs:     make(map[string]snapshotSubscription),
+		live:     make(map[string]bool),
+		restored: make(map[string]*RestoredSubscriber),
+	}
+	fsm.Register(raftKind, p)
+	return p
+}
+
+func (p *raftProvider) commit(cmd raftCommand) error {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	return p.fsm.Commit(raftKind, data)
+}
+
+// Subscribe subscribes in the trie of this node, the subscription of a PersistentSubscriber is
+// committed too, it replaces the restored one of its key.
+func (p *raftProvider) Subscribe(topic []byte, qos byte, sub Subscriber) (byte, error) {
+	granted, err := p.mem.Subscribe(topic, qos, sub)
+	if err != nil {
+		return granted, err
+	}
+	ps, ok := sub.(PersistentSubscriber)
+	if !ok {
+		return granted, nil
+	}
+
+	s := snapshotSubscription{Filter: string(topic), Qos: granted, Key: ps.SubscriberKey()}
+	k := string(subscriptionKey(s.Filter, s.Key))
+	p.mu.Lock()
+	wasLive := p.live[k]
+	p.live[k] = true
+	r, wasRestored := p.restored[k]
+	if wasRestored {
+		_ = p.mem.Unsubscribe(topic, r)
+		delete(p.restored, k)
+	}
+	p.mu.Unlock()
+
+	if err := p.commit(raftCommand{Subscribe: &s}); err != nil {
+		// the subscription is not replicated, this node doesn't keep it either, a subscription
+		// committed before stays
+		p.mu.Lock()
+		if !wasLive {
+			_ = p.mem.Unsubscribe(topic, sub)
+			delete(p.live, k)
+		}
+		if wasRestored {
+			if _, err := p.mem.Subscribe(topic, p.subs[k].Qos, r); err == nil {
+				p.restored[k] = r
+			}
+		}
+		p.mu.Unlock()
+		return QosFailure, fmt.Errorf("topics/raft_provider/Subscribe: commit error: %v", err)
+	}
+	return granted, nil
+}
+
+func (p *raftProvider) Unsubscribe(topic []byte, sub Subscriber) error {
+	if err := p.mem.Unsubscribe(topic, sub); err != nil {
+		return err
+	}
+	ps, ok := sub.(PersistentSubscriber)
+	if !ok {
+		return nil
+	}
+
+	s := snapshotSubscription{Filter: string(topic), Key: ps.SubscriberKey()}
+	p.mu.Lock()
+	delete(p.live, string(subscriptionKey(s.Filter, s.Key)))
+	p.mu.Unlock()
+
+	return p.commit(raftCommand{Unsubscribe: &s})
+}
+
+func (p *raftProvider) Subscribers(topic []byte, qos byte, subList *[]Subscriber, qosList *[]byte) error {
+	return p.mem.Subscribers(topic, qos, subList, qosList)
+}
+
+func (p *raftProvider) Retain(message *packets.PublishPacket) error {
+	return p.RetainUntil(message, time.Time{})
+}
+
+func (p *raftProvider) SetClock(c clock.Clock) {
+	p.mem.SetClock(c)
+}
+
+// RetainUntil commits the message with its deadline, it's retained once the log applies it.
+func (p *raftProvider) RetainUntil(message *packets.PublishPacket, deadline time.Time) error {
+	if err := ValidatePublishTopic([]byte(message.TopicName)); err != nil {
+		return err
+	}
+	r := &snapshotRetained{Topic: message.TopicName, Qos: message.Qos, Payload: message.Payload}
+	if !deadline.IsZero() {
+		r.ExpiresAt = &deadline
+	}
+	if err := p.commit(raftCommand{Retain: r}); err != nil {
+		return fmt.Errorf("topics/raft_provider/RetainUntil: commit error: %v", err)
+	}
+	return nil
+}
+
+func (p *raftProvider) Retained(topic []byte, messages *[]*packets.PublishPacket) error {
+	return p.mem.Retained(topic, messages)
+}
+
+func (p *raftProvider) RetainedDeadline(topic string) (time.Time, bool) {
+	return p.mem.RetainedDeadline(topic)
+}
+
+// StartSweeper purges the expired messages of this node, every node sweeps its own copy since the
+// deadlines are replicated.
+func (p *raftProvider) StartSweeper(interval time.Duration) {
+	p.mem.StartSweeper(interval)
+}
+
+// NotifyExpired sets the handler of the expired retained messages, it's only called on the leader
+// so the expiry is handled once.
+func (p *raftProvider) NotifyExpired(fn func(message *packets.PublishPacket)) {
+	if fn == nil {
+		p.mem.NotifyExpired(nil)
+		return
+	}
+	p.mem.NotifyExpired(func(message *packets.PublishPacket) {
+		if p.fsm.Leader() {
+			fn(message)
+		}
+	})
+}
+
+// RestoreSubscription commits the subscription, it's held by a RestoredSubscriber on every node.
+func (p *raftProvider) RestoreSubscription(filter []byte, qos byte, key string) error {
+	if !ValidQos(qos) {
+		return fmt.Errorf("topics/raft_provider/RestoreSubscription: Invalid QoS %d", qos)
+	}
+	return p.commit(raftCommand{Subscribe: &snapshotSubscription{Filter: string(filter), Qos: qos, Key: key}})
+}
+
+// Apply applies a committed command.
+func (p *raftProvider) Apply(data []byte) error {
+	var cmd raftCommand
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return fmt.Errorf("topics/raft_provider/Apply: invalid command => %v", err)
+	}
+
+	switch {
+	case cmd.Retain != nil:
+		return p.applyRetain(cmd.Retain)
+	case cmd.Subscribe != nil:
+		return p.applySubscribe(*cmd.Subscribe)
+	case cmd.Unsubscribe != nil:
+		p.applyUnsubscribe(*cmd.Unsubscribe)
+	}
+	return nil
+}
+
+func (p *raftProvider) applyRetain(r *snapshotRetained) error {
+	msg := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	msg.TopicName = r.Topic
+	msg.Qos = r.Qos
+	msg.Payload = r.Payload
+	var deadline time.Time
+	if r.ExpiresAt != nil {
+		deadline = *r.ExpiresAt
+	}
+	return p.mem.RetainUntil(msg, deadline)
+}
+
+// applySubscribe keeps the subscription, it's held by a RestoredSubscriber unless its subscriber
+// is connected to this node.
+func (p *raftProvider) applySubscribe(s snapshotSubscription) error {
+	k := string(subscriptionKey(s.Filter, s.Key))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.subs[k] = s
+	if p.live[k] {
+		return nil
+	}
+	r, ok := p.restored[k]
+	if !ok {
+		r = &RestoredSubscriber{Key: s.Key}
+	}
+	if _, err := p.mem.Subscribe([]byte(s.Filter), s.Qos, r); err != nil {
+		return err
+	}
+	p.restored[k] = r
+	return nil
+}
+
+func (p *raftProvider) applyUnsubscribe(s snapshotSubscription) {
+	k := string(subscriptionKey(s.Filter, s.Key))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.subs, k)
+	if r, ok := p.restored[k]; ok {
+		_ = p.mem.Unsubscribe([]byte(s.Filter), r)
+		delete(p.restored, k)
+	}
+}
+
+// Snapshot returns the retained messages and the committed subscriptions.
+func (p *raftProvider) Snapshot() ([]byte, error) {
+	var retained []*packets.PublishPacket
+	if err := p.mem.Retained([]byte(MWC), &retained); err != nil {
+		return nil, err
+	}
+	state := raftState{Retained: make([]snapshotRetained, 0, len(retained))}
+	for _, msg := range retained {
+		r := snapshotRetained{Topic: msg.TopicName, Qos: msg.Qos, Payload: msg.Payload}
+		if deadline, ok := p.mem.RetainedDeadline(msg.TopicName); ok {
+			r.ExpiresAt = &deadline
+		}
+		state.Retained = append(state.Retained, r)
+	}
+
+	p.mu.Lock()
+	for _, s := range p.subs {
+		state.Subscriptions = append(state.Subscriptions, s)
+	}
+	p.mu.Unlock()
+	return json.Marshal(state)
+}
+
+// Restore replaces the retained messages and the committed subscriptions with the ones of the
+// snapshot, the subscriptions of the subscribers of this node are kept.
+func (p *raftProvider) Restore(data []byte) error {
+	var state raftState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("topics/raft_provider/Restore: invalid snapshot => %v", err)
+	}
+
+	var current []*packets.PublishPacket
+	if err := p.mem.Retained([]byte(MWC), &current); err != nil {
+		return err
+	}
+	for _, msg := range current {
+		removed := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		removed.TopicName = msg.TopicName
+		if err := p.mem.Retain(removed); err != nil {
+			return err
+		}
+	}
+	for i := range state.Retained {
+		if err := p.applyRetain(&state.Retained[i]); err != nil {
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	for k, s := range p.subs {
+		if r, ok := p.restored[k]; ok {
+			_ = p.mem.Unsubscribe([]byte(s.Filter), r)
+			delete(p.restored, k)
+		}
+	}
+	p.subs = make(map[string]snapshotSubscription, len(state.Subscriptions))
+	p.mu.Unlock()
+
+	for _, s := range state.Subscriptions {
+		if err := p.applySubscribe(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *raftProvider) Close() error {
+	return p.mem.Close()
+}
@@ -0,0 +1,118 @@
+package topics
+
+import (
+	"bytes"
+	"testing"
+
+	"awesomeProject/beacon/mqtt_network/libs/raftrepl"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+)
+
+// pairLog applies the commands to the FSM of the active node and of the standby, like the log of
+// an HA pair.
+type pairLog struct {
+	active, standby *raftrepl.FSM
+}
+
+func (l *pairLog) Commit(kind string, cmd []byte) error {
+	data, err := raftrepl.Encode(kind, cmd)
+	if err != nil {
+		return err
+	}
+	if err := l.active.Apply(data); err != nil {
+		return err
+	}
+	return l.standby.Apply(data)
+}
+
+func (l *pairLog) Leader() bool {
+	return true
+}
+
+type testKeyedSubscriber string
+
+func (s testKeyedSubscriber) SubscriberKey() string {
+	return string(s)
+}
+
+func (s testKeyedSubscriber) ID() string {
+	return string(s)
+}
+
+func (s testKeyedSubscriber) OnPublish(message *packets.PublishPacket) error {
+	return nil
+}
+
+func TestRaftProvider(t *testing.T) {
+	log := &pairLog{active: raftrepl.NewFSM(), standby: raftrepl.NewFSM()}
+	log.active.SetLog(log)
+	log.standby.SetLog(log)
+	active := NewRaftProvider(log.active)
+	standby := NewRaftProvider(log.standby)
+
+	msg := newPublishMessageLarge([]byte("sensors/1/temp"), 1)
+	require.NoError(t, active.Retain(msg))
+
+	var retained []*packets.PublishPacket
+	require.NoError(t, standby.Retained([]byte("sensors/+/temp"), &retained))
+	require.Len(t, retained, 1)
+	require.Equal(t, msg.Payload, retained[0].Payload)
+
+	// the subscription of the persistent subscriber is held on the standby
+	sub := testKeyedSubscriber("c1")
+	_, err := active.Subscribe([]byte("sensors/#"), 1, sub)
+	require.NoError(t, err)
+	require.Equal(t, []Subscriber{sub}, raftSubscribers(t, active, "sensors/1/temp"))
+	subs := raftSubscribers(t, standby, "sensors/1/temp")
+	require.Len(t, subs, 1)
+	require.Equal(t, &RestoredSubscriber{Key: "c1"}, subs[0])
+
+	// a new node restores the snapshot of the standby
+	var buf bytes.Buffer
+	require.NoError(t, log.standby.WriteSnapshot(&buf))
+	fsm := raftrepl.NewFSM()
+	joined := NewRaftProvider(fsm)
+	raftrepl.NewLocalLog(fsm)
+	require.NoError(t, fsm.RestoreSnapshot(&buf))
+	retained = nil
+	require.NoError(t, joined.Retained([]byte("#"), &retained))
+	require.Len(t, retained, 1)
+	require.Len(t, raftSubscribers(t, joined, "sensors/1/temp"), 1)
+
+	// the subscriber takes over on the standby
+	_, err = standby.Subscribe([]byte("sensors/#"), 1, sub)
+	require.NoError(t, err)
+	require.Equal(t, []Subscriber{sub}, raftSubscribers(t, standby, "sensors/1/temp"))
+
+	// the removal of the retained message and of a restored subscription are replicated
+	removed := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	removed.TopicName = "sensors/1/temp"
+	require.NoError(t, active.Retain(removed))
+	retained = nil
+	require.NoError(t, standby.Retained([]byte("#"), &retained))
+	require.Empty(t, retained)
+
+	_, err = standby.Subscribe([]byte("alerts/#"), 0, testKeyedSubscriber("c2"))
+	require.NoError(t, err)
+	require.Len(t, raftSubscribers(t, active, "alerts/1"), 1)
+	require.NoError(t, standby.Unsubscribe([]byte("alerts/#"), testKeyedSubscriber("c2")))
+	require.Empty(t, raftSubscribers(t, active, "alerts/1"))
+
+	// a subscription which fails to commit is not kept, the restored one stays
+	fsm.SetLog(nil)
+	_, err = joined.Subscribe([]byte("sensors/#"), 1, sub)
+	require.Error(t, err)
+	require.Equal(t, []Subscriber{&RestoredSubscriber{Key: "c1"}}, raftSubscribers(t, joined, "sensors/1/temp"))
+	_, err = joined.Subscribe([]byte("alerts/#"), 1, sub)
+	require.Error(t, err)
+	require.Empty(t, raftSubscribers(t, joined, "alerts/1"))
+}
+
+func raftSubscribers(t *testing.T, p *raftProvider, topic string) []Subscriber {
+	var subs []Subscriber
+	var qoss []byte
+	require.NoError(t, p.Subscribers([]byte(topic), 1, &subs, &qoss))
+	return subs
+}
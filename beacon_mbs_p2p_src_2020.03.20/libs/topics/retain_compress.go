@@ -0,0 +1,45 @@
+package topics
+
+import (
+	"awesomeProject/beacon/mqtt_network/libs/compress"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// SetCompressor compresses the retained payloads over its threshold, they are decompressed by
+// Retained. The payloads retained compressed before are decompressed even if it's nil.
+func (m *Manager) SetCompressor(c *compress.Compressor) {
+	m.compressor = c
+}
+
+// RetainedStored returns the retained messages like Retained, with their payloads as stored: the
+// compressed ones are not decompressed, for the callers which only count them.
+func (m *Manager) RetainedStored(topic []byte, messages *[]*packets.PublishPacket) error {
+	if err := m.ttp.Retained(topic, messages); err != nil {
+		return err
+	}
+	if _, ok := m.ttp.(ExpiringProvider); !ok {
+		m.dropExpired(messages)
+	}
+	return nil
+}
+
+// unframeRetained decompresses the compressed payloads of the messages, the stored messages are
+// copied rather than changed. The messages which cannot be decompressed are dropped.
+func (m *Manager) unframeRetained(messages []*packets.PublishPacket) []*packets.PublishPacket {
+	list := messages[:0]
+	for _, msg := range messages {
+		if msg == nil || !compress.Framed(msg.Payload) {
+			list = append(list, msg)
+			continue
+		}
+		payload, err := m.compressor.Unframe(msg.Payload)
+		if err != nil {
+			continue
+		}
+		cp := *msg
+		cp.Payload = payload
+		list = append(list, &cp)
+	}
+	return list
+}
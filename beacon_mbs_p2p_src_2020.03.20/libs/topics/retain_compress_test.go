@@ -0,0 +1,44 @@
+package topics
+
+import (
+	"strings"
+	"testing"
+
+	"awesomeProject/beacon/mqtt_network/libs/compress"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetainCompressed(t *testing.T) {
+	c, err := compress.New(compress.Config{Threshold: 1024})
+	require.NoError(t, err)
+	m := &Manager{ttp: NewMemProvider()}
+	m.SetCompressor(c)
+
+	image := strings.Repeat("pixel", 1000)
+	require.NoError(t, m.Retain(newRetainedPacket("images/cam1", image)))
+	require.NoError(t, m.Retain(newRetainedPacket("images/cam2", "tiny")))
+
+	// the payloads are stored compressed, and delivered as published
+	var stored []*packets.PublishPacket
+	require.NoError(t, m.RetainedStored([]byte("images/cam1"), &stored))
+	require.Len(t, stored, 1)
+	require.Less(t, len(stored[0].Payload), len(image))
+
+	var list []*packets.PublishPacket
+	require.NoError(t, m.Retained([]byte("images/#"), &list))
+	require.Len(t, list, 2)
+	for _, msg := range list {
+		if msg.TopicName == "images/cam1" {
+			require.Equal(t, image, string(msg.Payload))
+		} else {
+			require.Equal(t, "tiny", string(msg.Payload))
+		}
+	}
+
+	replaced, err := m.RetainReplace(newRetainedPacket("images/cam1", "off"), 0)
+	require.NoError(t, err)
+	require.Equal(t, image, string(replaced.Payload))
+	require.EqualValues(t, 1, c.Stats().Compressed)
+}
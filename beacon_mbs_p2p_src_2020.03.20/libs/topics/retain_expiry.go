@@ -0,0 +1,205 @@
+package topics
+
+import (
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+	"awesomeProject/beacon/mqtt_network/libs/compress"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// retainExpiry keeps the deadlines of the expiring retained messages by topic for the providers
+// which are not ExpiringProvider, the other retained messages never expire.
+type retainExpiry struct {
+	mu        sync.Mutex
+	deadlines map[string]time.Time
+}
+
+func (e *retainExpiry) set(topic string, deadline time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if deadline.IsZero() {
+		delete(e.deadlines, topic)
+		return
+	}
+	if e.deadlines == nil {
+		e.deadlines = make(map[string]time.Time)
+	}
+	e.deadlines[topic] = deadline
+}
+
+func (e *retainExpiry) get(topic string) (time.Time, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	deadline, ok := e.deadlines[topic]
+	return deadline, ok
+}
+
+// SetClock sets the clock the message expiry is measured with, the wall clock by default.
+func (m *Manager) SetClock(c clock.Clock) {
+	m.clock = c
+	if p, ok := m.ttp.(ExpiringProvider); ok {
+		p.SetClock(c)
+	}
+}
+
+// SetRetainTTL sets how long the retained messages published without a message expiry are kept,
+// 0 keeps them forever.
+func (m *Manager) SetRetainTTL(ttl time.Duration) {
+	m.ttl = ttl
+}
+
+// StartRetainSweeper purges the expired retained messages of the provider every interval, the
+// providers which are not ExpiringProvider drop them when they are matched only.
+func (m *Manager) StartRetainSweeper(interval time.Duration) {
+	if p, ok := m.ttp.(ExpiringProvider); ok {
+		p.StartSweeper(interval)
+	}
+}
+
+// ExpiryNotifyingProvider is implemented by the ExpiringProviders which report the retained
+// messages purged by their sweeper.
+type ExpiryNotifyingProvider interface {
+	NotifyExpired(fn func(message *packets.PublishPacket))
+}
+
+// NotifyExpired calls fn with each retained message dropped once it has expired, by the sweeper of
+// the provider or when it's matched, its payload decompressed. It's set before the messages are
+// retained, fn must not retain.
+func (m *Manager) NotifyExpired(fn func(message *packets.PublishPacket)) {
+	m.expired = func(message *packets.PublishPacket) {
+		if list := m.unframeRetained([]*packets.PublishPacket{message}); len(list) > 0 {
+			fn(list[0])
+		}
+	}
+	if p, ok := m.ttp.(ExpiryNotifyingProvider); ok {
+		p.NotifyExpired(m.expired)
+	}
+}
+
+// RetainWithExpiry retains the message like Retain, it is dropped once the expiry has elapsed.
+// An expiry of 0 is the retained TTL, the message never expires if it's not set. A negative
+// expiry never expires.
+func (m *Manager) RetainWithExpiry(message *packets.PublishPacket, expiry time.Duration) error {
+	_, err := m.RetainReplace(message, expiry)
+	return err
+}
+
+// RetainReplace retains the message like RetainWithExpiry and returns the retained message it
+// replaced, nil if there was none. The swap is atomic if the provider is a ReplacingProvider.
+func (m *Manager) RetainReplace(message *packets.PublishPacket, expiry time.Duration) (replaced *packets.PublishPacket, err error) {
+	msg := *message
+	msg.Retain = true
+	msg.Dup = false
+
+	if expiry == 0 {
+		expiry = m.ttl
+	}
+	var deadline time.Time
+	if expiry > 0 && len(msg.Payload) > 0 {
+		deadline = clock.OrReal(m.clock).Now().Add(expiry)
+	}
+
+	if m.sink != nil {
+		start := time.Now()
+		defer func() { m.sink.ObserveRetain(msg.TopicName, time.Since(start), err) }()
+	}
+
+	// the history keeps the payload as published
+	published := msg
+	msg.Payload = m.compressor.FrameTopic(msg.TopicName, msg.Payload)
+	defer func() {
+		if replaced != nil && compress.Framed(replaced.Payload) {
+			if list := m.unframeRetained([]*packets.PublishPacket{replaced}); len(list) > 0 {
+				replaced = list[0]
+			}
+		}
+	}()
+
+	if p, ok := m.ttp.(ReplacingProvider); ok {
+		if replaced, err = p.RetainReplace(&msg, deadline); err != nil {
+			return nil, err
+		}
+	} else {
+		var previous []*packets.PublishPacket
+		if err := m.Retained([]byte(msg.TopicName), &previous); err != nil {
+			return nil, err
+		}
+		if len(previous) > 0 {
+			replaced = previous[0]
+		}
+
+		if p, ok := m.ttp.(ExpiringProvider); ok {
+			if err := p.RetainUntil(&msg, deadline); err != nil {
+				return nil, err
+			}
+		} else {
+			if err := m.ttp.Retain(&msg); err != nil {
+				return nil, err
+			}
+			m.expiry.set(msg.TopicName, deadline)
+		}
+	}
+
+	if m.history != nil {
+		m.history.record(&published, time.Now())
+	}
+	return replaced, nil
+}
+
+// RetainedExpiry returns the time left to the retained message of the topic, false if it never
+// expires.
+func (m *Manager) RetainedExpiry(topic string) (time.Duration, bool) {
+	var deadline time.Time
+	var ok bool
+	if p, isExpiring := m.ttp.(ExpiringProvider); isExpiring {
+		deadline, ok = p.RetainedDeadline(topic)
+	} else {
+		deadline, ok = m.expiry.get(topic)
+	}
+	if !ok {
+		return 0, false
+	}
+	left := deadline.Sub(clock.OrReal(m.clock).Now())
+	if left < 0 {
+		left = 0
+	}
+	return left, true
+}
+
+// dropExpired removes the expired messages from the list, and from the provider.
+func (m *Manager) dropExpired(messages *[]*packets.PublishPacket) {
+	m.expiry.drop(clock.OrReal(m.clock).Now(), m.ttp, messages, m.expired)
+}
+
+// drop removes the messages expired at now from the list, and from the provider holding them.
+// expired is called with each message removed, if it's set.
+func (e *retainExpiry) drop(now time.Time, p TheTopicsProvider, messages *[]*packets.PublishPacket, expired func(message *packets.PublishPacket)) {
+	list := (*messages)[:0]
+	for _, msg := range *messages {
+		deadline, ok := e.get(msg.TopicName)
+		if !ok || now.Before(deadline) {
+			list = append(list, msg)
+			continue
+		}
+
+		// the empty retained message removes it, unless a new one has replaced it meanwhile
+		e.mu.Lock()
+		removed := false
+		if d, ok := e.deadlines[msg.TopicName]; ok && d.Equal(deadline) {
+			delete(e.deadlines, msg.TopicName)
+			empty := *msg
+			empty.Payload = nil
+			removed = p.Retain(&empty) == nil
+		}
+		e.mu.Unlock()
+		if removed && expired != nil {
+			expired(msg)
+		}
+	}
+	*messages = list
+}
@@ -0,0 +1,135 @@
+package topics
+
+import (
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetainExpiry(t *testing.T) {
+	mock := clock.NewMock(time.Unix(1584662400, 0))
+	m := &Manager{ttp: NewMemProvider()}
+	m.SetClock(mock)
+
+	require.NoError(t, m.RetainWithExpiry(newRetainedPacket("devices/d1/state", "on"), time.Minute))
+	require.NoError(t, m.RetainWithExpiry(newRetainedPacket("devices/d2/state", "off"), 0))
+
+	var list []*packets.PublishPacket
+	require.NoError(t, m.Retained([]byte("devices/+/state"), &list))
+	require.Len(t, list, 2)
+
+	mock.Add(20 * time.Second)
+	left, ok := m.RetainedExpiry("devices/d1/state")
+	require.True(t, ok)
+	require.Equal(t, 40*time.Second, left)
+	_, ok = m.RetainedExpiry("devices/d2/state")
+	require.False(t, ok)
+
+	mock.Add(time.Minute)
+	list = list[:0]
+	require.NoError(t, m.Retained([]byte("devices/+/state"), &list))
+	require.Len(t, list, 1)
+	require.Equal(t, "devices/d2/state", list[0].TopicName)
+
+	// the expired message has been removed from the provider
+	list = list[:0]
+	require.NoError(t, m.ttp.Retained([]byte("devices/d1/state"), &list))
+	require.Empty(t, list)
+	_, ok = m.RetainedExpiry("devices/d1/state")
+	require.False(t, ok)
+
+	// retaining again without expiry clears the deadline
+	require.NoError(t, m.RetainWithExpiry(newRetainedPacket("devices/d1/state", "on"), time.Second))
+	require.NoError(t, m.Retain(newRetainedPacket("devices/d1/state", "on")))
+	mock.Add(time.Minute)
+	list = list[:0]
+	require.NoError(t, m.Retained([]byte("devices/d1/state"), &list))
+	require.Len(t, list, 1)
+}
+
+func TestMemProviderRetainExpiry(t *testing.T) {
+	mock := clock.NewMock(time.Unix(1584662400, 0))
+	p := NewMemProvider()
+	p.SetClock(mock)
+	defer p.Close()
+
+	require.NoError(t, p.RetainUntil(newRetainedPacket("a/b/c", "1"), mock.Now().Add(time.Minute)))
+	require.NoError(t, p.RetainUntil(newRetainedPacket("a/d", "2"), mock.Now().Add(time.Hour)))
+	require.NoError(t, p.Retain(newRetainedPacket("e", "3")))
+
+	mock.Add(time.Minute)
+	var list []*packets.PublishPacket
+	require.NoError(t, p.Retained([]byte("#"), &list))
+	require.Len(t, list, 2)
+	_, ok := p.RetainedDeadline("a/b/c")
+	require.False(t, ok)
+
+	// an expired message is replaced by the next one
+	require.NoError(t, p.RetainUntil(newRetainedPacket("a/d", "4"), mock.Now().Add(time.Second)))
+	mock.Add(time.Hour)
+	require.NoError(t, p.RetainUntil(newRetainedPacket("a/d", "5"), time.Time{}))
+	list = list[:0]
+	require.NoError(t, p.Retained([]byte("a/d"), &list))
+	require.Len(t, list, 1)
+	require.Equal(t, []byte("5"), list[0].Payload)
+
+	// the sweeper removes the expired messages and their empty nodes
+	p.StartSweeper(time.Minute)
+	mock.Add(time.Minute)
+	require.Eventually(t, func() bool {
+		p.rmu.RLock()
+		defer p.rmu.RUnlock()
+		_, ok := p.retainedRoot.retainNodesMap["a"].retainNodesMap["b"]
+		return !ok
+	}, time.Second, time.Millisecond)
+	require.Equal(t, 0, p.SweepRetained())
+}
+
+func TestRetainTTL(t *testing.T) {
+	mock := clock.NewMock(time.Unix(1584662400, 0))
+	m := &Manager{ttp: NewMemProvider()}
+	m.SetClock(mock)
+	m.SetRetainTTL(time.Hour)
+
+	require.NoError(t, m.Retain(newRetainedPacket("a", "1")))
+	require.NoError(t, m.RetainWithExpiry(newRetainedPacket("b", "2"), time.Minute))
+	left, ok := m.RetainedExpiry("a")
+	require.True(t, ok)
+	require.Equal(t, time.Hour, left)
+
+	mock.Add(time.Minute)
+	var list []*packets.PublishPacket
+	require.NoError(t, m.Retained([]byte("+"), &list))
+	require.Len(t, list, 1)
+	require.Equal(t, "a", list[0].TopicName)
+}
+
+func TestNotifyExpired(t *testing.T) {
+	mock := clock.NewMock(time.Unix(1584662400, 0))
+	m := &Manager{ttp: NewMemProvider()}
+	m.SetClock(mock)
+	defer m.ttp.Close()
+
+	var expired []string
+	m.NotifyExpired(func(message *packets.PublishPacket) {
+		expired = append(expired, message.TopicName+"="+string(message.Payload))
+	})
+
+	require.NoError(t, m.RetainWithExpiry(newRetainedPacket("a/b", "1"), time.Minute))
+	require.NoError(t, m.RetainWithExpiry(newRetainedPacket("a/c", "2"), time.Hour))
+	require.NoError(t, m.Retain(newRetainedPacket("a/d", "3")))
+
+	mock.Add(time.Minute)
+	require.Equal(t, 1, m.ttp.(*memProvider).SweepRetained())
+	require.Equal(t, []string{"a/b=1"}, expired)
+
+	// the replaced messages are not expired
+	require.NoError(t, m.RetainWithExpiry(newRetainedPacket("a/c", "4"), time.Hour))
+	mock.Add(time.Hour)
+	require.Equal(t, 1, m.ttp.(*memProvider).SweepRetained())
+	require.Equal(t, []string{"a/b=1", "a/c=4"}, expired)
+}
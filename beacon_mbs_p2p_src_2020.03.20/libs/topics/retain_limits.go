@@ -0,0 +1,228 @@
+package topics
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+)
+
+// RetainEviction is what the retained store does with a new message past its limits.
+type RetainEviction string
+
+const (
+	// RetainRejectNew refuses the new message, the retained ones are kept
+	RetainRejectNew RetainEviction = "reject_new"
+	// RetainEvictOldest removes the messages retained the longest ago until the new one fits
+	RetainEvictOldest RetainEviction = "evict_oldest"
+	// RetainEvictLargest removes the largest messages until the new one fits
+	RetainEvictLargest RetainEviction = "evict_largest"
+)
+
+var (
+	ErrRetainLimit           = errors.New("topics/retain_limits: the retained store is full")
+	ErrRetainPayloadTooLarge = errors.New("topics/retain_limits: the retained payload is too large")
+)
+
+// RetainLimits caps the retained store of the memory provider, so a publisher retaining on
+// countless topics can't exhaust the memory of the broker. The size of a message is the one of its
+// topic and its payload as stored, compressed or not, like RetainStats. 0 is unlimited.
+type RetainLimits struct {
+	MaxMessages int            `json:"max_messages" yaml:"max_messages"`
+	MaxBytes    int64          `json:"max_bytes" yaml:"max_bytes"`
+	MaxPayload  int            `json:"max_payload" yaml:"max_payload"`
+	Eviction    RetainEviction `json:"eviction" yaml:"eviction"`
+}
+
+// Validate checks the eviction policy, an empty one rejects the new messages.
+func (l RetainLimits) Validate() error {
+	switch l.Eviction {
+	case "", RetainRejectNew, RetainEvictOldest, RetainEvictLargest:
+	default:
+		return fmt.Errorf("topics/retain_limits/Validate: unknown eviction policy %q", l.Eviction)
+	}
+	if l.MaxMessages < 0 || l.MaxBytes < 0 || l.MaxPayload < 0 {
+		return errors.New("topics/retain_limits/Validate: the limits can't be negative")
+	}
+	return nil
+}
+
+func (l RetainLimits) enabled() bool {
+	return l.MaxMessages > 0 || l.MaxBytes > 0 || l.MaxPayload > 0
+}
+
+// WithRetainLimits caps the retained store, it's ignored if no limit is set. A message retained
+// past the limits is refused with ErrRetainLimit, or the retained messages picked by the eviction
+// policy are removed to make room for it; a payload over MaxPayload is refused with
+// ErrRetainPayloadTooLarge whatever the policy. The evictions are not in the apply log, like the
+// sweeps.
+func WithRetainLimits(l RetainLimits) MemOption {
+	return func(m *memProvider) {
+		if l.enabled() {
+			m.retainAccount = newRetainAccount(l)
+		}
+	}
+}
+
+// RetainLimitStats are the retained store and the counters of its limits.
+type RetainLimitStats struct {
+	Messages int    `json:"messages"`
+	Bytes    int64  `json:"bytes"`
+	Rejected uint64 `json:"rejected"`
+	Evicted  uint64 `json:"evicted"`
+	TooLarge uint64 `json:"too_large"`
+}
+
+// retainEntry is a retained message in the account, seq orders the messages by retain time.
+type retainEntry struct {
+	topic string
+	size  int64
+	seq   uint64
+	index int
+}
+
+// retainHeap keeps the next message to evict first.
+type retainHeap struct {
+	entries []*retainEntry
+	largest bool
+}
+
+func (h *retainHeap) Len() int { return len(h.entries) }
+
+func (h *retainHeap) Less(i, j int) bool {
+	a, b := h.entries[i], h.entries[j]
+	if h.largest && a.size != b.size {
+		return a.size > b.size
+	}
+	return a.seq < b.seq
+}
+
+func (h *retainHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].index = i
+	h.entries[j].index = j
+}
+
+func (h *retainHeap) Push(x interface{}) {
+	e := x.(*retainEntry)
+	e.index = len(h.entries)
+	h.entries = append(h.entries, e)
+}
+
+func (h *retainHeap) Pop() interface{} {
+	n := len(h.entries)
+	e := h.entries[n-1]
+	h.entries[n-1] = nil
+	h.entries = h.entries[:n-1]
+	return e
+}
+
+// retainAccount counts the retained messages by topic against the limits, it's guarded by the
+// retained message mutex of the provider.
+type retainAccount struct {
+	limits  RetainLimits
+	entries map[string]*retainEntry
+	order   retainHeap
+	seq     uint64
+	bytes   int64
+
+	rejected uint64
+	evicted  uint64
+	tooLarge uint64
+}
+
+func newRetainAccount(l RetainLimits) *retainAccount {
+	return &retainAccount{
+		limits:  l,
+		entries: make(map[string]*retainEntry),
+		order:   retainHeap{largest: l.Eviction == RetainEvictLargest},
+	}
+}
+
+func (a *retainAccount) add(e *retainEntry) {
+	a.entries[e.topic] = e
+	a.bytes += e.size
+	heap.Push(&a.order, e)
+}
+
+func (a *retainAccount) remove(topic string) *retainEntry {
+	e, ok := a.entries[topic]
+	if !ok {
+		return nil
+	}
+	delete(a.entries, topic)
+	a.bytes -= e.size
+	heap.Remove(&a.order, e.index)
+	return e
+}
+
+func (a *retainAccount) full(size int64) bool {
+	return (a.limits.MaxMessages > 0 && len(a.entries)+1 > a.limits.MaxMessages) ||
+		(a.limits.MaxBytes > 0 && a.bytes+size > a.limits.MaxBytes)
+}
+
+// admit makes room for the message of the topic, it returns the topics of the messages to evict.
+// The message replaced on the topic is not counted against the limits, it's kept in the account if
+// the message is refused.
+func (a *retainAccount) admit(topic string, payload int) ([]string, error) {
+	if a.limits.MaxPayload > 0 && payload > a.limits.MaxPayload {
+		a.tooLarge++
+		return nil, ErrRetainPayloadTooLarge
+	}
+	size := int64(len(topic) + payload)
+	if a.limits.MaxBytes > 0 && size > a.limits.MaxBytes {
+		a.tooLarge++
+		return nil, ErrRetainPayloadTooLarge
+	}
+
+	previous := a.remove(topic)
+	if a.full(size) && (a.limits.Eviction == "" || a.limits.Eviction == RetainRejectNew) {
+		if previous != nil {
+			a.add(previous)
+		}
+		a.rejected++
+		return nil, ErrRetainLimit
+	}
+
+	var evicted []string
+	for a.full(size) && a.order.Len() > 0 {
+		e := heap.Pop(&a.order).(*retainEntry)
+		delete(a.entries, e.topic)
+		a.bytes -= e.size
+		a.evicted++
+		evicted = append(evicted, e.topic)
+	}
+	a.seq++
+	a.add(&retainEntry{topic: topic, size: size, seq: a.seq})
+	return evicted, nil
+}
+
+func (a *retainAccount) stats() RetainLimitStats {
+	return RetainLimitStats{
+		Messages: len(a.entries),
+		Bytes:    a.bytes,
+		Rejected: a.rejected,
+		Evicted:  a.evicted,
+		TooLarge: a.tooLarge,
+	}
+}
+
+// RetainLimitStats returns the retained store and the counters of its limits, false if the store
+// has no limit.
+func (m *memProvider) RetainLimitStats() (RetainLimitStats, bool) {
+	m.rmu.RLock()
+	defer m.rmu.RUnlock()
+
+	if m.retainAccount == nil {
+		return RetainLimitStats{}, false
+	}
+	return m.retainAccount.stats(), true
+}
+
+// RetainLimitStats returns the counters of the limits of the retained store of the provider, false
+// if it has none.
+func (m *Manager) RetainLimitStats() (RetainLimitStats, bool) {
+	if p, ok := m.ttp.(*memProvider); ok {
+		return p.RetainLimitStats()
+	}
+	return RetainLimitStats{}, false
+}
@@ -0,0 +1,96 @@
+package topics
+
+import (
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func retainedPacket(topic string, payload string) *packets.PublishPacket {
+	p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	p.TopicName = topic
+	p.Payload = []byte(payload)
+	p.Retain = true
+	return p
+}
+
+func retainedTopics(t *testing.T, p *memProvider) map[string]string {
+	var list []*packets.PublishPacket
+	require.NoError(t, p.Retained([]byte(MWC), &list))
+	got := make(map[string]string)
+	for _, msg := range list {
+		got[msg.TopicName] = string(msg.Payload)
+	}
+	return got
+}
+
+func TestRetainLimits(t *testing.T) {
+	p := NewMemProvider(WithRetainLimits(RetainLimits{MaxMessages: 2, MaxPayload: 8}))
+
+	require.NoError(t, p.Retain(retainedPacket("a", "1")))
+	require.NoError(t, p.Retain(retainedPacket("b", "2")))
+	require.Equal(t, ErrRetainLimit, p.Retain(retainedPacket("c", "3")))
+	require.Equal(t, ErrRetainPayloadTooLarge, p.Retain(retainedPacket("a", "123456789")))
+
+	// a retained message is still replaced and cleared when the store is full
+	require.NoError(t, p.Retain(retainedPacket("a", "11")))
+	require.NoError(t, p.Retain(retainedPacket("b", "")))
+	require.NoError(t, p.Retain(retainedPacket("c", "3")))
+	require.Equal(t, map[string]string{"a": "11", "c": "3"}, retainedTopics(t, p))
+
+	stats, ok := p.RetainLimitStats()
+	require.True(t, ok)
+	require.Equal(t, RetainLimitStats{Messages: 2, Bytes: 5, Rejected: 1, TooLarge: 1}, stats)
+
+	_, ok = NewMemProvider().RetainLimitStats()
+	require.False(t, ok)
+}
+
+func TestRetainLimitsEviction(t *testing.T) {
+	p := NewMemProvider(WithRetainLimits(RetainLimits{MaxMessages: 3, Eviction: RetainEvictOldest}))
+
+	require.NoError(t, p.Retain(retainedPacket("a", "1")))
+	require.NoError(t, p.Retain(retainedPacket("b", "2")))
+	require.NoError(t, p.Retain(retainedPacket("c", "3")))
+	// retained again, a is the newest
+	require.NoError(t, p.Retain(retainedPacket("a", "4")))
+	require.NoError(t, p.Retain(retainedPacket("d", "5")))
+	require.Equal(t, map[string]string{"a": "4", "c": "3", "d": "5"}, retainedTopics(t, p))
+
+	p = NewMemProvider(WithRetainLimits(RetainLimits{MaxBytes: 20, Eviction: RetainEvictLargest}))
+	require.NoError(t, p.Retain(retainedPacket("a", "12345678")))
+	require.NoError(t, p.Retain(retainedPacket("b", "12")))
+	require.NoError(t, p.Retain(retainedPacket("c", "1234")))
+	require.NoError(t, p.Retain(retainedPacket("d", "123456")))
+	require.Equal(t, map[string]string{"b": "12", "c": "1234", "d": "123456"}, retainedTopics(t, p))
+
+	stats, _ := p.RetainLimitStats()
+	require.Equal(t, RetainLimitStats{Messages: 3, Bytes: 15, Evicted: 1}, stats)
+	require.Equal(t, ErrRetainPayloadTooLarge, p.Retain(retainedPacket("e", "123456789012345678901")))
+
+	require.NoError(t, p.Close())
+	stats, _ = p.RetainLimitStats()
+	require.Equal(t, RetainLimitStats{}, stats)
+
+	require.Error(t, RetainLimits{Eviction: "evict_random"}.Validate())
+}
+
+func TestRetainLimitsNested(t *testing.T) {
+	p := NewMemProvider(WithRetainLimits(RetainLimits{MaxMessages: 2}))
+
+	// clearing a/b keeps the message of its parent a, and its account
+	require.NoError(t, p.Retain(retainedPacket("a", "1")))
+	require.NoError(t, p.Retain(retainedPacket("a/b", "2")))
+	require.NoError(t, p.Retain(retainedPacket("a/b", "")))
+	require.Equal(t, map[string]string{"a": "1"}, retainedTopics(t, p))
+
+	var list []*packets.PublishPacket
+	require.NoError(t, p.Retained([]byte("a"), &list))
+	require.Len(t, list, 1)
+
+	stats, _ := p.RetainLimitStats()
+	require.Equal(t, 1, stats.Messages)
+	require.NoError(t, p.Retain(retainedPacket("c", "3")))
+	require.Equal(t, map[string]string{"a": "1", "c": "3"}, retainedTopics(t, p))
+}
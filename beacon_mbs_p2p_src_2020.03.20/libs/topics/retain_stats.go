@@ -0,0 +1,36 @@
+package topics
+
+import (
+	"strings"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// RetainStats counts the retained messages under a top-level topic, Bytes is the size of their
+// topics and payloads as stored, compressed or not.
+type RetainStats struct {
+	Messages int   `json:"messages"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// RetainStats returns the retained messages stored by top-level topic (the first level of their
+// topic, such as "sensors" for "sensors/d1/temp"), the expired ones are not counted.
+func (m *Manager) RetainStats() (map[string]RetainStats, error) {
+	var list []*packets.PublishPacket
+	if err := m.RetainedStored([]byte(MWC), &list); err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]RetainStats)
+	for _, msg := range list {
+		level := msg.TopicName
+		if i := strings.Index(level, SEP); i >= 0 {
+			level = level[:i]
+		}
+		s := stats[level]
+		s.Messages++
+		s.Bytes += int64(len(msg.TopicName) + len(msg.Payload))
+		stats[level] = s
+	}
+	return stats, nil
+}
@@ -0,0 +1,43 @@
+package topics
+
+import (
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetainReplace(t *testing.T) {
+	m := &Manager{ttp: NewMemProvider()}
+
+	replaced, err := m.RetainReplace(newRetainedPacket("sensors/d1/temp", "20"), 0)
+	require.NoError(t, err)
+	require.Nil(t, replaced)
+
+	// the new message replaces the previous one
+	replaced, err = m.RetainReplace(newRetainedPacket("sensors/d1/temp", "21"), 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("20"), replaced.Payload)
+
+	var list []*packets.PublishPacket
+	require.NoError(t, m.Retained([]byte("sensors/d1/temp"), &list))
+	require.Len(t, list, 1)
+	require.Equal(t, []byte("21"), list[0].Payload)
+
+	require.NoError(t, m.Retain(newRetainedPacket("sensors/d2/temp", "19.5")))
+	require.NoError(t, m.Retain(newRetainedPacket("alarms", "none")))
+	stats, err := m.RetainStats()
+	require.NoError(t, err)
+	require.Equal(t, map[string]RetainStats{
+		"sensors": {Messages: 2, Bytes: 15 + 2 + 15 + 4},
+		"alarms":  {Messages: 1, Bytes: 6 + 4},
+	}, stats)
+
+	// the empty message clears it
+	replaced, err = m.RetainReplace(newRetainedPacket("sensors/d1/temp", ""), 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("21"), replaced.Payload)
+	stats, err = m.RetainStats()
+	require.NoError(t, err)
+	require.Equal(t, 1, stats["sensors"].Messages)
+}
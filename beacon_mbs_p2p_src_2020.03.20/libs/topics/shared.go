@@ -0,0 +1,153 @@
+package topics
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+)
+
+// SharePrefix starts the filters of the shared subscriptions, $share/<group>/<filter>: each message
+// matching the filter goes to one subscriber of the group only.
+const SharePrefix = "$share/"
+
+// ParseSharedFilter splits a shared subscription filter into its group and its filter, ok is false
+// if the filter is not a shared one.
+func ParseSharedFilter(filter []byte) (group string, rest []byte, ok bool, err error) {
+	if !bytes.HasPrefix(filter, []byte(SharePrefix)) {
+		return "", filter, false, nil
+	}
+
+	name := filter[len(SharePrefix):]
+	i := bytes.IndexByte(name, '/')
+	if i <= 0 {
+		return "", nil, true, fmt.Errorf("topics/shared/ParseSharedFilter: missing share group name in %q", filter)
+	}
+	if bytes.ContainsAny(name[:i], _WC) {
+		return "", nil, true, fmt.Errorf("topics/shared/ParseSharedFilter: invalid share group name in %q", filter)
+	}
+	if len(name[i+1:]) == 0 {
+		return "", nil, true, fmt.Errorf("topics/shared/ParseSharedFilter: missing filter in %q", filter)
+	}
+
+	return string(name[:i]), name[i+1:], true, nil
+}
+
+// ShareMember is implemented by the members of a share group with a priority: the messages go to
+// the available members of the highest priority, in turn by their weight. The members of a lower
+// priority are standby ones, they only get the messages while no member above is available. The
+// other members have the priority 0 and the weight 1, and are always available.
+type ShareMember interface {
+	// SharePriority returns the priority of the member and its weight among the members of the
+	// same priority, a weight below 1 counts as 1.
+	SharePriority() (priority int, weight int)
+
+	// ShareAvailable reports whether the member takes messages now, it's false while the member is
+	// saturated or offline. It's called by the concurrent matches.
+	ShareAvailable() bool
+}
+
+func sharePriority(sub Subscriber) (int, int, bool) {
+	m, ok := sub.(ShareMember)
+	if !ok {
+		return 0, 1, true
+	}
+	priority, weight := m.SharePriority()
+	if weight < 1 {
+		weight = 1
+	}
+	return priority, weight, m.ShareAvailable()
+}
+
+// sharedGroup holds the members of a share group subscribed to the same filter, the messages are
+// handed to them in turn.
+type sharedGroup struct {
+	subList []Subscriber
+	qosList []byte
+	next    uint32
+
+	// Whether a member has a priority, the turn is plain otherwise
+	prioritized bool
+}
+
+// clone copies the group before it's changed, the turn goes on from where it was.
+func (g *sharedGroup) clone() *sharedGroup {
+	return &sharedGroup{
+		subList:     append([]Subscriber(nil), g.subList...),
+		qosList:     append([]byte(nil), g.qosList...),
+		next:        atomic.LoadUint32(&g.next),
+		prioritized: g.prioritized,
+	}
+}
+
+// insert adds the member, or replaces the member with its ID and then returns true.
+func (g *sharedGroup) insert(qos byte, sub Subscriber) bool {
+	if _, ok := sub.(ShareMember); ok {
+		g.prioritized = true
+	}
+
+	id := sub.ID()
+	for i := range g.subList {
+		if g.subList[i].ID() == id {
+			g.subList[i] = sub
+			g.qosList[i] = qos
+			return true
+		}
+	}
+
+	g.subList = append(g.subList, sub)
+	g.qosList = append(g.qosList, qos)
+	return false
+}
+
+// remove removes the member if it's still the one subscribed.
+func (g *sharedGroup) remove(sub Subscriber) bool {
+	for i := range g.subList {
+		if g.subList[i] == sub {
+			g.subList = append(g.subList[:i], g.subList[i+1:]...)
+			g.qosList = append(g.qosList[:i], g.qosList[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// pick returns the next member in turn, it's called by the concurrent matches of a version.
+func (g *sharedGroup) pick() Subscriber {
+	n := atomic.AddUint32(&g.next, 1) - 1
+	if !g.prioritized {
+		return g.subList[int(n%uint32(len(g.subList)))]
+	}
+
+	// The highest priority of the available members, of all of them if none is available
+	top, anyAvailable, total := 0, false, 0
+	for i, sub := range g.subList {
+		priority, _, available := sharePriority(sub)
+		if i == 0 || (available && !anyAvailable) || (available == anyAvailable && priority > top) {
+			top, anyAvailable = priority, available
+		}
+	}
+	for _, sub := range g.subList {
+		priority, weight, available := sharePriority(sub)
+		if priority == top && available == anyAvailable {
+			total += weight
+		}
+	}
+
+	// the members may change their availability meanwhile
+	if total == 0 {
+		return g.subList[int(n%uint32(len(g.subList)))]
+	}
+
+	k := int(n % uint32(total))
+	for _, sub := range g.subList {
+		priority, weight, available := sharePriority(sub)
+		if priority != top || available != anyAvailable {
+			continue
+		}
+		if k < weight {
+			return sub
+		}
+		k -= weight
+	}
+	return g.subList[0]
+}
@@ -0,0 +1,217 @@
+package topics
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// SnapshotVersion is the version of the snapshot format written by WriteSnapshot. The snapshot is
+// JSON lines: a header with the version, then a line for each retained message and each
+// subscription, so a snapshot is written and read without holding it in memory.
+const SnapshotVersion = 1
+
+// ErrSubscriptionsSkipped is returned by RestoreSnapshot once the rest of the snapshot is
+// restored, if the provider cannot hold some of the subscriptions until their subscribers come
+// back.
+var ErrSubscriptionsSkipped = errors.New("topics/snapshot: the provider cannot restore some of the subscriptions")
+
+var (
+	_ RestoringProvider = (*memProvider)(nil)
+	_ RestoringProvider = (*boltProvider)(nil)
+	_ RestoringProvider = (*compositeProvider)(nil)
+)
+
+// RestoringProvider is implemented by the providers holding a restored subscription for its
+// subscriber, a RestoredSubscriber, until the subscriber subscribes to the filter again and takes
+// its place.
+type RestoringProvider interface {
+	// RestoreSubscription fails with ErrSubscriptionsSkipped if the provider cannot hold the
+	// subscription.
+	RestoreSubscription(filter []byte, qos byte, key string) error
+}
+
+type snapshotHeader struct {
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+}
+
+// snapshotRecord is a line of the snapshot, a retained message or a subscription.
+type snapshotRecord struct {
+	Retained     *snapshotRetained     `json:"retained,omitempty"`
+	Subscription *snapshotSubscription `json:"subscription,omitempty"`
+}
+
+type snapshotRetained struct {
+	Topic   string `json:"topic"`
+	Qos     byte   `json:"qos"`
+	Payload []byte `json:"payload"`
+	// ExpiresAt is the deadline of the message, nil if it never expires
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// snapshotSubscription is the subscription of a persistent subscriber, by its key.
+type snapshotSubscription struct {
+	Filter string `json:"filter"`
+	Qos    byte   `json:"qos"`
+	Key    string `json:"key"`
+}
+
+// WriteSnapshot writes the retained messages and the subscriptions of the persistent subscribers,
+// such as the clients of the persistent sessions, to w. The other subscribers cannot be restored,
+// they're skipped; the $ topics too, since they're published by the broker.
+func (m *Manager) WriteSnapshot(w io.Writer) error {
+	now := clock.OrReal(m.clock).Now()
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	if err := enc.Encode(snapshotHeader{Version: SnapshotVersion, Time: now}); err != nil {
+		return err
+	}
+
+	var retained []*packets.PublishPacket
+	if err := m.Retained([]byte(MWC), &retained); err != nil {
+		return err
+	}
+	for _, msg := range retained {
+		r := &snapshotRetained{Topic: msg.TopicName, Qos: msg.Qos, Payload: msg.Payload}
+		if left, ok := m.RetainedExpiry(msg.TopicName); ok {
+			at := now.Add(left)
+			r.ExpiresAt = &at
+		}
+		if err := enc.Encode(snapshotRecord{Retained: r}); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	walkErr := m.WalkSubscriptions(func(filter string, qos byte, subscriber Subscriber) bool {
+		ps, ok := subscriber.(PersistentSubscriber)
+		if !ok {
+			return true
+		}
+		s := &snapshotSubscription{Filter: filter, Qos: qos, Key: ps.SubscriberKey()}
+		err = enc.Encode(snapshotRecord{Subscription: s})
+		return err == nil
+	})
+	if walkErr != nil {
+		return walkErr
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// RestoreSnapshot retains the messages of the snapshot, the expired ones are skipped, and restores
+// its subscriptions for their subscribers. It's meant for a broker no client is connected to yet,
+// a restored subscription is added besides the one of a connected subscriber.
+func (m *Manager) RestoreSnapshot(r io.Reader) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return fmt.Errorf("topics/snapshot/RestoreSnapshot: read the header error: %v", err)
+	}
+	if header.Version != SnapshotVersion {
+		return fmt.Errorf("topics/snapshot/RestoreSnapshot: unsupported snapshot version %d", header.Version)
+	}
+
+	rp, restoring := m.ttp.(RestoringProvider)
+	skipped := false
+	for line := 2; ; line++ {
+		var rec snapshotRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("topics/snapshot/RestoreSnapshot: line %d: %v", line, err)
+		}
+
+		switch {
+		case rec.Retained != nil:
+			if err := m.restoreRetained(rec.Retained); err != nil {
+				return fmt.Errorf("topics/snapshot/RestoreSnapshot: line %d: %v", line, err)
+			}
+		case rec.Subscription != nil:
+			s := rec.Subscription
+			if !restoring {
+				skipped = true
+				continue
+			}
+			err := rp.RestoreSubscription([]byte(s.Filter), s.Qos, s.Key)
+			if err == ErrSubscriptionsSkipped {
+				skipped = true
+			} else if err != nil {
+				return fmt.Errorf("topics/snapshot/RestoreSnapshot: line %d: %v", line, err)
+			}
+		}
+	}
+	if skipped {
+		return ErrSubscriptionsSkipped
+	}
+	return nil
+}
+
+func (m *Manager) restoreRetained(r *snapshotRetained) error {
+	if err := ValidatePublishTopic([]byte(r.Topic)); err != nil {
+		return err
+	}
+	if !ValidQos(r.Qos) {
+		return fmt.Errorf("invalid QoS %d", r.Qos)
+	}
+
+	// a message without a deadline never expires, whatever the retained TTL is
+	expiry := time.Duration(-1)
+	if r.ExpiresAt != nil {
+		if expiry = r.ExpiresAt.Sub(clock.OrReal(m.clock).Now()); expiry <= 0 {
+			return nil
+		}
+	}
+	msg := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	msg.TopicName = r.Topic
+	msg.Qos = r.Qos
+	msg.Payload = r.Payload
+	return m.RetainWithExpiry(msg, expiry)
+}
+
+// RestoreSubscription holds the subscription in the set of its filter, the subscriber with the key
+// as ID replaces it once it subscribes. The shared subscriptions are skipped.
+func (m *memProvider) RestoreSubscription(filter []byte, qos byte, key string) error {
+	group, _, _, err := ParseSharedFilter(filter)
+	if err != nil {
+		return err
+	}
+	if len(group) > 0 {
+		return ErrSubscriptionsSkipped
+	}
+	_, err = m.Subscribe(filter, qos, &RestoredSubscriber{Key: key})
+	return err
+}
+
+// RestoreSubscription persists the subscription like the ones loaded on startup, the subscriber
+// with the same key takes its place once it subscribes.
+func (p *boltProvider) RestoreSubscription(filter []byte, qos byte, key string) error {
+	r := &RestoredSubscriber{Key: key}
+	if _, err := p.Subscribe(filter, qos, r); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.restored[string(subscriptionKey(string(filter), key))] = r
+	p.mu.Unlock()
+	return nil
+}
+
+// RestoreSubscription restores the subscription in the provider of its filter, like Subscribe.
+func (c *compositeProvider) RestoreSubscription(filter []byte, qos byte, key string) error {
+	_, f, _, _ := ParseSharedFilter(filter)
+	rp, ok := c.route(f).(RestoringProvider)
+	if !ok {
+		return ErrSubscriptionsSkipped
+	}
+	return rp.RestoreSubscription(filter, qos, key)
+}
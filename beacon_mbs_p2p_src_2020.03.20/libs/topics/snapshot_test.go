@@ -0,0 +1,75 @@
+package topics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	mock := clock.NewMock(time.Unix(1584662400, 0))
+	src := &Manager{ttp: NewMemProvider(WithSubscriberSets(0))}
+	src.SetClock(mock)
+
+	require.NoError(t, src.Retain(newQos1RetainedPacket("devices/d1/state", "on")))
+	require.NoError(t, src.RetainWithExpiry(newRetainedPacket("devices/d2/state", "off"), time.Minute))
+	require.NoError(t, src.RetainWithExpiry(newRetainedPacket("devices/d3/state", "off"), time.Second))
+	_, err := src.Subscribe([]byte("devices/#"), 1, keyedSubscriber("c1"))
+	require.NoError(t, err)
+	_, err = src.Subscribe([]byte("$share/g/devices/+/state"), 0, keyedSubscriber("c2"))
+	require.NoError(t, err)
+	// not persistent, not in the snapshot
+	_, err = src.Subscribe([]byte("devices/#"), 0, testSubscriber("volatile"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, src.WriteSnapshot(&buf))
+
+	dir, err := ioutil.TempDir("", "topics")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	p, err := NewBoltProvider(filepath.Join(dir, "topics.db"))
+	require.NoError(t, err)
+	defer p.Close()
+	dst := &Manager{ttp: p}
+	dst.SetClock(mock)
+
+	mock.Add(2 * time.Second)
+	require.NoError(t, dst.RestoreSnapshot(bytes.NewReader(buf.Bytes())))
+
+	var list []*packets.PublishPacket
+	require.NoError(t, dst.Retained([]byte("devices/+/state"), &list))
+	require.Len(t, list, 2)
+	left, ok := dst.RetainedExpiry("devices/d2/state")
+	require.True(t, ok)
+	require.Equal(t, 58*time.Second, left)
+	_, ok = dst.RetainedExpiry("devices/d1/state")
+	require.False(t, ok)
+
+	var subs []Subscriber
+	var qoss []byte
+	require.NoError(t, dst.Subscribers([]byte("devices/d1/state"), 1, &subs, &qoss))
+	require.Len(t, subs, 2)
+
+	// the subscriber takes the place of its restored subscription
+	_, err = dst.Subscribe([]byte("devices/#"), 1, keyedSubscriber("c1"))
+	require.NoError(t, err)
+	subs, qoss = subs[:0], qoss[:0]
+	require.NoError(t, dst.Subscribers([]byte("devices/d1/state"), 1, &subs, &qoss))
+	require.Len(t, subs, 2)
+	require.Contains(t, subs, keyedSubscriber("c1"))
+
+	// the mem provider holds no shared restored subscription
+	mem := &Manager{ttp: NewMemProvider(WithSubscriberSets(0))}
+	require.Equal(t, ErrSubscriptionsSkipped, mem.RestoreSnapshot(bytes.NewReader(buf.Bytes())))
+
+	require.Error(t, dst.RestoreSnapshot(bytes.NewReader([]byte(`{"version":2}`))))
+}
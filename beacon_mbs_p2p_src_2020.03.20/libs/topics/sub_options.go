@@ -0,0 +1,110 @@
+package topics
+
+import (
+	"fmt"
+)
+
+// The retain handling of a subscription, whether the retained messages are sent when it's made.
+const (
+	// RetainSend sends the retained messages at each subscribe
+	RetainSend = byte(0)
+	// RetainSendNew sends the retained messages only if the subscription did not exist
+	RetainSendNew = byte(1)
+	// RetainDontSend never sends the retained messages
+	RetainDontSend = byte(2)
+)
+
+// MaxSubscriptionIdentifier is the largest subscription identifier, a variable byte integer.
+const MaxSubscriptionIdentifier = 268435455
+
+// SubOptions are the MQTT 5 options of a subscription. The 3.1.1 subscriptions have the QoS only.
+type SubOptions struct {
+	Qos               byte
+	NoLocal           bool
+	RetainAsPublished bool
+	RetainHandling    byte
+	// Identifier is the subscription identifier sent back with the messages matching it, 0 if none
+	Identifier int
+}
+
+// ParseSubOptions parses the subscription options byte of a SUBSCRIBE, the reserved bits must be
+// zero.
+func ParseSubOptions(options byte) (SubOptions, error) {
+	o := SubOptions{
+		Qos:               options & 0x03,
+		NoLocal:           options&0x04 != 0,
+		RetainAsPublished: options&0x08 != 0,
+		RetainHandling:    (options >> 4) & 0x03,
+	}
+	if options&0xc0 != 0 {
+		return SubOptions{}, fmt.Errorf("topics/sub_options/ParseSubOptions: reserved bits set in 0x%02x", options)
+	}
+	if !ValidQos(o.Qos) {
+		return SubOptions{}, fmt.Errorf("topics/sub_options/ParseSubOptions: invalid QoS %d", o.Qos)
+	}
+	if o.RetainHandling > RetainDontSend {
+		return SubOptions{}, fmt.Errorf("topics/sub_options/ParseSubOptions: invalid retain handling %d", o.RetainHandling)
+	}
+	return o, nil
+}
+
+// Byte returns the subscription options byte of the options, without the identifier.
+func (o SubOptions) Byte() byte {
+	b := o.Qos | o.RetainHandling<<4
+	if o.NoLocal {
+		b |= 0x04
+	}
+	if o.RetainAsPublished {
+		b |= 0x08
+	}
+	return b
+}
+
+// SendRetained reports whether the retained messages are sent when the subscription is made,
+// existed is whether the subscriber had subscribed to the filter before.
+func (o SubOptions) SendRetained(existed bool) bool {
+	switch o.RetainHandling {
+	case RetainDontSend:
+		return false
+	case RetainSendNew:
+		return !existed
+	default:
+		return true
+	}
+}
+
+// OptionsSubscriber is implemented by the subscribers with MQTT 5 options, the subscribers
+// without them have the default options.
+type OptionsSubscriber interface {
+	SubscriberKey() string
+	SubOptions() SubOptions
+}
+
+// SubscribersFrom returns the subscribers of the topic like Subscribers, without the No Local
+// subscriptions of the publisher identified by its subscriber key.
+func (m *Manager) SubscribersFrom(topic []byte, qos byte, publisher string, subList *[]Subscriber, qosList *[]byte) error {
+	if err := m.Subscribers(topic, qos, subList, qosList); err != nil {
+		return err
+	}
+	if len(publisher) == 0 {
+		return nil
+	}
+
+	subs, qoss := *subList, *qosList
+	n := 0
+	for i, sub := range subs {
+		if os, ok := sub.(OptionsSubscriber); ok && os.SubOptions().NoLocal && os.SubscriberKey() == publisher {
+			continue
+		}
+		subs[n] = sub
+		if i < len(qoss) {
+			qoss[n] = qoss[i]
+		}
+		n++
+	}
+	*subList = subs[:n]
+	if len(qoss) > n {
+		*qosList = qoss[:n]
+	}
+	return nil
+}
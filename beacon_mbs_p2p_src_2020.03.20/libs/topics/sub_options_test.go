@@ -0,0 +1,56 @@
+package topics
+
+import (
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+)
+
+type optionsSubscriber struct {
+	key     string
+	options SubOptions
+}
+
+func (s *optionsSubscriber) SubscriberKey() string                          { return s.key }
+func (s *optionsSubscriber) ID() string                                     { return s.key }
+func (s *optionsSubscriber) OnPublish(message *packets.PublishPacket) error { return nil }
+func (s *optionsSubscriber) SubOptions() SubOptions                         { return s.options }
+
+func TestParseSubOptions(t *testing.T) {
+	o, err := ParseSubOptions(0x2d)
+	require.NoError(t, err)
+	require.Equal(t, SubOptions{Qos: 1, NoLocal: true, RetainAsPublished: true, RetainHandling: RetainDontSend}, o)
+	require.Equal(t, byte(0x2d), o.Byte())
+
+	require.False(t, o.SendRetained(false))
+	require.True(t, SubOptions{RetainHandling: RetainSendNew}.SendRetained(false))
+	require.False(t, SubOptions{RetainHandling: RetainSendNew}.SendRetained(true))
+
+	_, err = ParseSubOptions(0x40)
+	require.Error(t, err)
+	_, err = ParseSubOptions(0x03)
+	require.Error(t, err)
+	_, err = ParseSubOptions(0x30)
+	require.Error(t, err)
+}
+
+func TestSubscribersFrom(t *testing.T) {
+	m := &Manager{ttp: NewMemProvider()}
+	local := &optionsSubscriber{key: "sensor", options: SubOptions{Qos: 1, NoLocal: true}}
+	other := &optionsSubscriber{key: "panel", options: SubOptions{Qos: 1, NoLocal: true}}
+	_, err := m.Subscribe([]byte("home/+"), 1, local)
+	require.NoError(t, err)
+	_, err = m.Subscribe([]byte("home/#"), 1, other)
+	require.NoError(t, err)
+
+	var subs []Subscriber
+	var qoss []byte
+	require.NoError(t, m.SubscribersFrom([]byte("home/temp"), 1, "sensor", &subs, &qoss))
+	require.Equal(t, []Subscriber{other}, subs)
+	require.Len(t, qoss, 1)
+
+	// the messages of the broker itself have no publisher
+	require.NoError(t, m.SubscribersFrom([]byte("home/temp"), 1, "", &subs, &qoss))
+	require.Len(t, subs, 2)
+}
@@ -0,0 +1,54 @@
+package topics
+
+import (
+	"time"
+)
+
+var (
+	_ ResultProvider = (*memProvider)(nil)
+	_ ResultProvider = (*boltProvider)(nil)
+	_ ResultProvider = (*compositeProvider)(nil)
+)
+
+// SubscribeResult is the result of a subscribe: the granted QoS, and whether the subscriber was
+// subscribed to the filter already, so only its QoS is updated. The retain handling of MQTT 5
+// sends the retained messages to the new subscriptions only with RetainSendNew.
+type SubscribeResult struct {
+	Qos     byte
+	Existed bool
+}
+
+// ResultProvider is implemented by the providers reporting whether a subscription existed.
+type ResultProvider interface {
+	SubscribeResult(topic []byte, qos byte, subscriber Subscriber) (SubscribeResult, error)
+}
+
+// SubscribeResult subscribes like Subscribe and reports whether the subscription existed. The
+// providers which are not ResultProvider report every subscription as a new one, the caller may
+// know better, such as the client keeping its own subscriptions.
+func (m *Manager) SubscribeResult(topic []byte, qos byte, subscriber Subscriber) (SubscribeResult, error) {
+	rp, ok := m.ttp.(ResultProvider)
+	if !ok {
+		granted, err := m.Subscribe(topic, qos, subscriber)
+		return SubscribeResult{Qos: granted}, err
+	}
+	if m.sink == nil {
+		return rp.SubscribeResult(topic, qos, subscriber)
+	}
+
+	start := time.Now()
+	res, err := rp.SubscribeResult(topic, qos, subscriber)
+	m.sink.ObserveSubscribe(string(topic), time.Since(start), err)
+	return res, err
+}
+
+// SubscribeResult subscribes in the provider of the filter, like Subscribe.
+func (c *compositeProvider) SubscribeResult(topic []byte, qos byte, subscriber Subscriber) (SubscribeResult, error) {
+	_, filter, _, _ := ParseSharedFilter(topic)
+	p := c.route(filter)
+	if rp, ok := p.(ResultProvider); ok {
+		return rp.SubscribeResult(topic, qos, subscriber)
+	}
+	granted, err := p.Subscribe(topic, qos, subscriber)
+	return SubscribeResult{Qos: granted}, err
+}
@@ -0,0 +1,68 @@
+package topics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeResultMem(t *testing.T) {
+	for _, m := range []*Manager{
+		{ttp: NewMemProvider()},
+		{ttp: NewMemProvider(WithSubscriberSets(0))},
+	} {
+		for _, filter := range []string{"sport/tennis/#", "$share/g/sport/tennis/#"} {
+			res, err := m.SubscribeResult([]byte(filter), 1, keyedSubscriber("c1"))
+			require.NoError(t, err)
+			require.Equal(t, SubscribeResult{Qos: 1}, res)
+
+			// the same subscriber only updates its QoS
+			res, err = m.SubscribeResult([]byte(filter), 2, keyedSubscriber("c1"))
+			require.NoError(t, err)
+			require.Equal(t, SubscribeResult{Qos: 2, Existed: true}, res)
+
+			res, err = m.SubscribeResult([]byte(filter), 0, keyedSubscriber("c2"))
+			require.NoError(t, err)
+			require.False(t, res.Existed)
+
+			require.NoError(t, m.Unsubscribe([]byte(filter), keyedSubscriber("c1")))
+			res, err = m.SubscribeResult([]byte(filter), 1, keyedSubscriber("c1"))
+			require.NoError(t, err)
+			require.False(t, res.Existed)
+		}
+
+		res, err := m.SubscribeResult([]byte("sport/#"), 3, keyedSubscriber("c1"))
+		require.Error(t, err)
+		require.Equal(t, byte(QosFailure), res.Qos)
+	}
+}
+
+func TestSubscribeResultBoltRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "topics")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "topics.db")
+
+	p, err := NewBoltProvider(path)
+	require.NoError(t, err)
+	res, err := p.SubscribeResult([]byte("sport/tennis/#"), 1, keyedSubscriber("c1"))
+	require.NoError(t, err)
+	require.False(t, res.Existed)
+	require.NoError(t, p.Close())
+
+	// the subscription loaded on startup is the one of the resumed session
+	p, err = NewBoltProvider(path)
+	require.NoError(t, err)
+	defer p.Close()
+	m := &Manager{ttp: p}
+	res, err = m.SubscribeResult([]byte("sport/tennis/#"), 1, keyedSubscriber("c1"))
+	require.NoError(t, err)
+	require.Equal(t, SubscribeResult{Qos: 1, Existed: true}, res)
+
+	res, err = m.SubscribeResult([]byte("sport/tennis/#"), 1, keyedSubscriber("c2"))
+	require.NoError(t, err)
+	require.False(t, res.Existed)
+}
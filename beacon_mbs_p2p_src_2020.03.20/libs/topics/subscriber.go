@@ -0,0 +1,34 @@
+package topics
+
+import (
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// Subscriber is subscribed to the filters of the topics providers, such as the subscription of an
+// MQTT client. The subscribers of a filter are kept in a set by ID, so subscribing and
+// unsubscribing take the same time however many subscribers the filter has; a subscriber with the
+// ID of another one replaces it. The members of a share group are kept in the list of their group,
+// a member with the ID of another one replaces it too.
+//
+// A subscriber is removed only by itself, compared with ==, so the subscriber it replaced can't
+// remove it: the subscribers must be comparable, such as pointers.
+type Subscriber interface {
+	ID() string
+	// OnPublish is called with the messages matched by the filter, by the publishers handing the
+	// messages to their subscribers themselves
+	OnPublish(message *packets.PublishPacket) error
+}
+
+// FuncSubscriber subscribes a function, which can't be compared, under an ID.
+type FuncSubscriber struct {
+	SubscriberID string
+	Fn           func(message *packets.PublishPacket) error
+}
+
+func (f *FuncSubscriber) ID() string {
+	return f.SubscriberID
+}
+
+func (f *FuncSubscriber) OnPublish(message *packets.PublishPacket) error {
+	return f.Fn(message)
+}
@@ -0,0 +1,189 @@
+package topics
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrTooManySubscribers = errors.New("topics/subscriber_set: too many subscribers to the filter")
+
+// MemOption configures the memProvider.
+type MemOption func(*memProvider)
+
+// WithSubscriberSets bounds the subscribers in the set of a filter, 0 is unlimited. The members of
+// the share groups are not counted.
+func WithSubscriberSets(max int) MemOption {
+	return func(m *memProvider) {
+		m.subscriberSetMax = max
+	}
+}
+
+type setEntry struct {
+	sub Subscriber
+	qos byte
+}
+
+// The buckets of a subscriber set, a change copies the bucket of its key only
+const setBuckets = 64
+
+// subscriberSet holds the subscribers of a filter by ID, in buckets by the hash of their ID. Like
+// the share groups, a set is copied with its node before it's changed, but the copies share the
+// buckets: a change copies the bucket of its key, once for the version being built, and the
+// matches of the previous versions keep seeing theirs.
+type subscriberSet struct {
+	n       int
+	buckets [setBuckets]map[string]setEntry
+	// the buckets copied by this set already, they are changed in place
+	owned uint64
+}
+
+func newSubscriberSet() *subscriberSet {
+	return &subscriberSet{}
+}
+
+// clone copies the set with its node, the buckets are shared until they are changed.
+func (s *subscriberSet) clone() *subscriberSet {
+	if s == nil {
+		return nil
+	}
+	return &subscriberSet{n: s.n, buckets: s.buckets}
+}
+
+func (s *subscriberSet) len() int {
+	if s == nil {
+		return 0
+	}
+	return s.n
+}
+
+// setBucket hashes the key with FNV-1a.
+func setBucket(key string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % setBuckets)
+}
+
+// bucket returns the bucket i to change, it's copied unless the set copied it already.
+func (s *subscriberSet) bucket(i int) map[string]setEntry {
+	if s.owned&(1<<uint(i)) == 0 {
+		b := make(map[string]setEntry, len(s.buckets[i])+1)
+		for key, e := range s.buckets[i] {
+			b[key] = e
+		}
+		s.buckets[i] = b
+		s.owned |= 1 << uint(i)
+	}
+	return s.buckets[i]
+}
+
+// insert sets the subscriber of the key, existed is whether the key had a subscriber already, such
+// as the previous connection of the client or a RestoredSubscriber.
+func (s *subscriberSet) insert(key string, qos byte, sub Subscriber, max int) (existed bool, err error) {
+	i := setBucket(key)
+	_, existed = s.buckets[i][key]
+	if !existed && max > 0 && s.n >= max {
+		return false, ErrTooManySubscribers
+	}
+	s.bucket(i)[key] = setEntry{sub: sub, qos: qos}
+	if !existed {
+		s.n++
+	}
+	return existed, nil
+}
+
+// remove removes the subscriber of the key if it's still the one subscribed.
+func (s *subscriberSet) remove(key string, sub Subscriber) bool {
+	i := setBucket(key)
+	e, ok := s.buckets[i][key]
+	if !ok || e.sub != sub {
+		return false
+	}
+	delete(s.bucket(i), key)
+	s.n--
+	return true
+}
+
+// each calls fn with the subscribers until it returns false, it returns false then.
+func (s *subscriberSet) each(fn func(e setEntry) bool) bool {
+	for _, b := range s.buckets {
+		for _, e := range b {
+			if !fn(e) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s *subscriberSet) match(qos byte, subList *[]Subscriber, qosList *[]byte) {
+	s.each(func(e setEntry) bool {
+		*subList = append(*subList, e.sub)
+		*qosList = append(*qosList, qos)
+		return true
+	})
+}
+
+// keyedSubscriberInsert inserts the subscriber in the set of the filter, the nodes on the path are
+// copied like groupSubscriberInsert does.
+func (s *subscribeNode) keyedSubscriberInsert(topic []byte, qos byte, sub Subscriber, max int, batch freshNodes) (bool, error) {
+	if len(topic) == 0 {
+		if s.subSet == nil {
+			s.subSet = newSubscriberSet()
+		}
+		return s.subSet.insert(sub.ID(), qos, sub, max)
+	}
+
+	ntl, rem, err := nextTopicLevel(topic)
+	if err != nil {
+		return false, err
+	}
+
+	n := s.insertChild(string(ntl), batch)
+
+	return n.keyedSubscriberInsert(rem, qos, sub, max, batch)
+}
+
+// keyedSubscriberRemove removes the subscriber from the set of the filter, a nil subscriber removes
+// them all. The nodes left empty are removed.
+func (s *subscribeNode) keyedSubscriberRemove(topic []byte, sub Subscriber) error {
+	if len(topic) == 0 {
+		if sub == nil {
+			s.subSet = nil
+			return nil
+		}
+		if s.subSet == nil || !s.subSet.remove(sub.ID(), sub) {
+			return fmt.Errorf("topics/subscriber_set/keyedSubscriberRemove: No topic found for subscriber")
+		}
+		if s.subSet.len() == 0 {
+			s.subSet = nil
+		}
+		return nil
+	}
+
+	ntl, rem, err := nextTopicLevel(topic)
+	if err != nil {
+		return err
+	}
+
+	level := string(ntl)
+
+	n, ok := s.subscribeNodesMap[level]
+	if !ok {
+		return fmt.Errorf("topics/subscriber_set/keyedSubscriberRemove: No topic found")
+	}
+	n = n.clone()
+	s.subscribeNodesMap[level] = n
+
+	if err := n.keyedSubscriberRemove(rem, sub); err != nil {
+		return err
+	}
+
+	if n.empty() {
+		delete(s.subscribeNodesMap, level)
+	}
+
+	return nil
+}
@@ -0,0 +1,12 @@
+package topics
+
+import (
+	"awesomeProject/beacon/mqtt_network/libs/topics/matcher"
+)
+
+// MatchTopic reports whether the topic filter (which can contain wildcards) matches the
+// publish topic. It walks the levels the same way subscriberMatch() walks the subscription
+// tree, so a filter matches here if and only if its subscribers would be returned.
+func MatchTopic(filter []byte, topic []byte) (bool, error) {
+	return matcher.Match(filter, topic)
+}
@@ -0,0 +1,45 @@
+package topics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchTopic(t *testing.T) {
+	cases := []struct {
+		filter string
+		topic  string
+		match  bool
+	}{
+		{"sport/tennis/player1/#", "sport/tennis/player1/ranking", true},
+		{"sport/tennis/player1/#", "sport/tennis/player1/score/wimbledon", true},
+		{"sport/tennis/player1/#", "sport/tennis/player2/ranking", false},
+		{"sport/+/player1", "sport/tennis/player1", true},
+		{"sport/+/player1", "sport/tennis/player1/ranking", false},
+		{"+/+", "sport/tennis", true},
+		{"#", "sport/tennis", true},
+		{"sport/tennis", "sport/tennis", true},
+		{"sport/tennis", "sport", false},
+		{"sport", "sport/tennis", false},
+		{"/finance", "/finance", true},
+	}
+
+	for _, c := range cases {
+		match, err := MatchTopic([]byte(c.filter), []byte(c.topic))
+		require.NoError(t, err)
+		require.Equal(t, c.match, match, "%s => %s", c.filter, c.topic)
+
+		// Must be consistent with the subscription tree
+		n := newSubscribeNode()
+		require.NoError(t, n.subscriberInsert([]byte(c.filter), 1, testSubscriber("sub1")))
+
+		subList := make([]Subscriber, 0)
+		qosList := make([]byte, 0)
+		require.NoError(t, n.subscriberMatch([]byte(c.topic), 1, &subList, &qosList))
+		require.Equal(t, c.match, len(subList) == 1, "%s => %s", c.filter, c.topic)
+	}
+
+	_, err := MatchTopic([]byte("sport/tennis#"), []byte("sport/tennis"))
+	require.Error(t, err)
+}
@@ -1,20 +1,28 @@
 package topics
 
 import (
+	"errors"
 	"fmt"
+	"time"
+
+	"awesomeProject/beacon/mqtt_network/libs/backup"
+	"awesomeProject/beacon/mqtt_network/libs/clock"
+	"awesomeProject/beacon/mqtt_network/libs/compress"
+	"awesomeProject/beacon/mqtt_network/libs/storecheck"
+	"awesomeProject/beacon/mqtt_network/libs/topics/matcher"
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
 )
 
 const (
 	// MWC is the multi-level wildcard
-	MWC = "#"
+	MWC = matcher.MWC
 
 	// SWC is the single level wildcard
-	SWC = "+"
+	SWC = matcher.SWC
 
 	// SEP is the topic level separator
-	SEP = "/"
+	SEP = matcher.SEP
 
 	// SYS is the starting character of the system level topics
 	SYS = "$"
@@ -28,14 +36,29 @@ var (
 )
 
 type TheTopicsProvider interface {
-	Subscribe(topic []byte, qos byte, subscriber interface{}) (byte, error)
-	Unsubscribe(topic []byte, subscriber interface{}) error
-	Subscribers(topic []byte, qos byte, subList *[]interface{}, qosList *[]byte) error
+	Subscribe(topic []byte, qos byte, subscriber Subscriber) (byte, error)
+	Unsubscribe(topic []byte, subscriber Subscriber) error
+	Subscribers(topic []byte, qos byte, subList *[]Subscriber, qosList *[]byte) error
 	Retain(message *packets.PublishPacket) error
 	Retained(topic []byte, messages *[]*packets.PublishPacket) error
 	Close() error
 }
 
+// ExpiringProvider is implemented by the providers keeping the retained messages until a deadline,
+// the expired messages are skipped by Retained and purged by the sweeper.
+type ExpiringProvider interface {
+	SetClock(c clock.Clock)
+	RetainUntil(message *packets.PublishPacket, deadline time.Time) error
+	RetainedDeadline(topic string) (time.Time, bool)
+	StartSweeper(interval time.Duration)
+}
+
+// ReplacingProvider is implemented by the providers swapping the retained message of a topic at
+// once, the message replaced is returned.
+type ReplacingProvider interface {
+	RetainReplace(message *packets.PublishPacket, deadline time.Time) (*packets.PublishPacket, error)
+}
+
 func Register(name string, provider TheTopicsProvider) {
 	if provider == nil {
 		panic("topic_provider: Register provider is nil")
@@ -53,7 +76,16 @@ func Unregister(name string) {
 }
 
 type Manager struct {
-	ttp TheTopicsProvider
+	ttp     TheTopicsProvider
+	history *retainHistory
+	recent  *recentTopics
+	expiry  retainExpiry
+	clock   clock.Clock
+	ttl     time.Duration
+	sink    MetricsSink
+	expired func(message *packets.PublishPacket)
+
+	compressor *compress.Compressor
 }
 
 func NewManager(providerName string) (*Manager, error) {
@@ -65,24 +97,93 @@ func NewManager(providerName string) (*Manager, error) {
 	return &Manager{ttp: p}, nil
 }
 
-func (m *Manager) Subscribe(topic []byte, qos byte, subscriber interface{}) (byte, error) {
-	return m.ttp.Subscribe(topic, qos, subscriber)
+func (m *Manager) Subscribe(topic []byte, qos byte, subscriber Subscriber) (byte, error) {
+	if m.sink == nil {
+		return m.ttp.Subscribe(topic, qos, subscriber)
+	}
+
+	start := time.Now()
+	granted, err := m.ttp.Subscribe(topic, qos, subscriber)
+	m.sink.ObserveSubscribe(string(topic), time.Since(start), err)
+	return granted, err
 }
 
-func (m *Manager) Unsubscribe(topic []byte, subscriber interface{}) error {
-	return m.ttp.Unsubscribe(topic, subscriber)
+func (m *Manager) Unsubscribe(topic []byte, subscriber Subscriber) error {
+	if m.sink == nil {
+		return m.ttp.Unsubscribe(topic, subscriber)
+	}
+
+	start := time.Now()
+	err := m.ttp.Unsubscribe(topic, subscriber)
+	m.sink.ObserveUnsubscribe(string(topic), time.Since(start), err)
+	return err
 }
 
-func (m *Manager) Subscribers(topic []byte, qos byte, subList *[]interface{}, qosList *[]byte) error {
-	return m.ttp.Subscribers(topic, qos, subList, qosList)
+func (m *Manager) Subscribers(topic []byte, qos byte, subList *[]Subscriber, qosList *[]byte) error {
+	if m.sink == nil {
+		return m.ttp.Subscribers(topic, qos, subList, qosList)
+	}
+
+	start := time.Now()
+	err := m.ttp.Subscribers(topic, qos, subList, qosList)
+	m.sink.ObserveMatch(string(topic), len(*subList), time.Since(start), err)
+	return err
 }
 
+// Retain stores a copy of the message, so the QoS and the flags it was published with are kept
+// whatever is done to the packet by the delivery paths. It expires after the retained TTL if set.
 func (m *Manager) Retain(message *packets.PublishPacket) error {
-	return m.ttp.Retain(message)
+	return m.RetainWithExpiry(message, 0)
 }
 
 func (m *Manager) Retained(topic []byte, messages *[]*packets.PublishPacket) error {
-	return m.ttp.Retained(topic, messages)
+	n := len(*messages)
+	if err := m.RetainedStored(topic, messages); err != nil {
+		return err
+	}
+	*messages = append((*messages)[:n], m.unframeRetained((*messages)[n:])...)
+	return nil
+}
+
+// SetRetainHistory keeps the previous versions of the retained messages for the topics matched by
+// the filters, the history is kept in memory only.
+func (m *Manager) SetRetainHistory(depths ...HistoryDepth) error {
+	if len(depths) == 0 {
+		m.history = nil
+		return nil
+	}
+
+	h, err := newRetainHistory(depths)
+	if err != nil {
+		return err
+	}
+	m.history = h
+	return nil
+}
+
+// RetainHistory returns the kept versions of the retained message of the topic, newest first.
+func (m *Manager) RetainHistory(topic []byte) ([]RetainedVersion, error) {
+	if m.history == nil {
+		return nil, errors.New("topic_provider: retained message history is not enabled")
+	}
+	return m.history.get(string(topic)), nil
+}
+
+// CheckConsistency checks the persisted state of the provider, a nil report is returned
+// if the provider persists nothing.
+func (m *Manager) CheckConsistency(repair bool) (*storecheck.Report, error) {
+	if c, ok := m.ttp.(storecheck.Checker); ok {
+		return c.CheckConsistency(repair)
+	}
+	return nil, nil
+}
+
+// Snapshot returns a copy of the persisted state of the provider, for the backups.
+func (m *Manager) Snapshot() (backup.Snapshot, error) {
+	if s, ok := m.ttp.(backup.Snapshotter); ok {
+		return s.Snapshot()
+	}
+	return nil, errors.New("topics/topic_provider/Snapshot: the provider is not persisted")
 }
 
 func (m *Manager) Close() error {
@@ -0,0 +1,68 @@
+package topics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// MaxTopicLength is the length in bytes of the longest topic or filter, the longest UTF-8 string
+// of the MQTT packets.
+const MaxTopicLength = 65535
+
+// checkTopicString checks what the topics and the filters share: not empty, not too long, valid
+// UTF-8 without the null character.
+func checkTopicString(s []byte) error {
+	if len(s) == 0 {
+		return errors.New("cannot be empty")
+	}
+	if len(s) > MaxTopicLength {
+		return fmt.Errorf("longer than %d bytes", MaxTopicLength)
+	}
+	if !utf8.Valid(s) {
+		return errors.New("invalid UTF-8")
+	}
+	if bytes.IndexByte(s, 0) >= 0 {
+		return errors.New("contains the null character")
+	}
+	return nil
+}
+
+// ValidatePublishTopic checks the topic name of a publish, it cannot hold a wildcard.
+func ValidatePublishTopic(topic []byte) error {
+	if err := checkTopicString(topic); err != nil {
+		return fmt.Errorf("topics/validate/ValidatePublishTopic: topic %q %v", topic, err)
+	}
+	if bytes.ContainsAny(topic, _WC) {
+		return fmt.Errorf("topics/validate/ValidatePublishTopic: topic %q contains a wildcard", topic)
+	}
+	return nil
+}
+
+// ValidateTopicFilter checks the filter of a subscription, a shared one included: the wildcards
+// occupy entire levels, and '#' is the last one.
+func ValidateTopicFilter(filter []byte) error {
+	if err := checkTopicString(filter); err != nil {
+		return fmt.Errorf("topics/validate/ValidateTopicFilter: filter %q %v", filter, err)
+	}
+
+	_, rest, _, err := ParseSharedFilter(filter)
+	if err != nil {
+		return err
+	}
+
+	levels := bytes.Split(rest, []byte(SEP))
+	for i, level := range levels {
+		if !bytes.ContainsAny(level, _WC) {
+			continue
+		}
+		if len(level) != 1 {
+			return fmt.Errorf("topics/validate/ValidateTopicFilter: filter %q has a wildcard not occupying an entire level", filter)
+		}
+		if level[0] == MWC[0] && i != len(levels)-1 {
+			return fmt.Errorf("topics/validate/ValidateTopicFilter: filter %q has '#' before its last level", filter)
+		}
+	}
+	return nil
+}
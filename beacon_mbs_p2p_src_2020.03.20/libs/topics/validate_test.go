@@ -0,0 +1,44 @@
+package topics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTopicFilter(t *testing.T) {
+	for _, f := range []string{"#", "+", "a/+/c", "a/#", "/a", "a//b", "$share/g/a/+", "$SYS/#"} {
+		require.NoError(t, ValidateTopicFilter([]byte(f)), f)
+	}
+	for _, f := range []string{"", "a/#/c", "a#", "a/b+", "a\x00b", "a/\xff", "$share/g+/a", "$share/g/"} {
+		require.Error(t, ValidateTopicFilter([]byte(f)), f)
+	}
+	require.Error(t, ValidateTopicFilter(bytes.Repeat([]byte("a"), MaxTopicLength+1)))
+}
+
+func TestValidatePublishTopic(t *testing.T) {
+	for _, topic := range []string{"a", "a/b", "/a/", "$SYS/broker"} {
+		require.NoError(t, ValidatePublishTopic([]byte(topic)), topic)
+	}
+	for _, topic := range []string{"", "a/+", "#", "a\x00", "\xc3\x28"} {
+		require.Error(t, ValidatePublishTopic([]byte(topic)), topic)
+	}
+}
+
+func TestMemProviderRejectsInvalidTopics(t *testing.T) {
+	m := NewMemProvider()
+
+	_, err := m.Subscribe([]byte("a/#/b"), QosAtMostOnce, testSubscriber("sub"))
+	require.Error(t, err)
+
+	msg := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	msg.TopicName = "a/+"
+	msg.Payload = []byte("x")
+	require.Error(t, m.Retain(msg))
+
+	var retained []*packets.PublishPacket
+	require.NoError(t, m.Retained([]byte("#"), &retained))
+	require.Empty(t, retained)
+}
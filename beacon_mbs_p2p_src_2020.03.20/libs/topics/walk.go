@@ -0,0 +1,99 @@
+package topics
+
+import (
+	"errors"
+	"sort"
+)
+
+var (
+	_ WalkingProvider = (*memProvider)(nil)
+	_ WalkingProvider = (*boltProvider)(nil)
+	_ WalkingProvider = (*compositeProvider)(nil)
+)
+
+// WalkFunc is called for each subscription of the trie with its filter, the shared subscriptions
+// with their $share/<group>/ prefix. The walk stops once it returns false.
+type WalkFunc func(filter string, qos byte, subscriber Subscriber) bool
+
+// WalkingProvider is implemented by the providers enumerating their subscriptions, for the
+// inspection of the broker.
+type WalkingProvider interface {
+	WalkSubscriptions(fn WalkFunc)
+}
+
+// WalkSubscriptions walks the current version of the trie, the filters in order. The subscriptions
+// changed meanwhile are not seen.
+func (m *memProvider) WalkSubscriptions(fn WalkFunc) {
+	m.root().walk("", true, fn)
+}
+
+// walk calls fn for the subscriptions of the node and of the next levels, the root has no filter.
+func (s *subscribeNode) walk(filter string, root bool, fn WalkFunc) bool {
+	if s.subSet != nil && !s.subSet.each(func(e setEntry) bool { return fn(filter, e.qos, e.sub) }) {
+		return false
+	}
+	if len(s.sharedGroups) > 0 {
+		groups := make([]string, 0, len(s.sharedGroups))
+		for name := range s.sharedGroups {
+			groups = append(groups, name)
+		}
+		sort.Strings(groups)
+		for _, name := range groups {
+			g := s.sharedGroups[name]
+			for i, sub := range g.subList {
+				if !fn(SharePrefix+name+SEP+filter, g.qosList[i], sub) {
+					return false
+				}
+			}
+		}
+	}
+
+	levels := make([]string, 0, len(s.subscribeNodesMap))
+	for level := range s.subscribeNodesMap {
+		levels = append(levels, level)
+	}
+	sort.Strings(levels)
+	for _, level := range levels {
+		next := level
+		if !root {
+			next = filter + SEP + level
+		}
+		if !s.subscribeNodesMap[level].walk(next, false, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *boltProvider) WalkSubscriptions(fn WalkFunc) {
+	p.mem.WalkSubscriptions(fn)
+}
+
+// WalkSubscriptions walks the subscriptions of each provider, the fallback one first.
+func (c *compositeProvider) WalkSubscriptions(fn WalkFunc) {
+	stopped := false
+	for _, p := range c.providers() {
+		w, ok := p.(WalkingProvider)
+		if !ok {
+			continue
+		}
+		w.WalkSubscriptions(func(filter string, qos byte, sub Subscriber) bool {
+			stopped = !fn(filter, qos, sub)
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+// WalkSubscriptions calls fn for each subscription of the provider, an error is returned if the
+// provider cannot enumerate them.
+func (m *Manager) WalkSubscriptions(fn WalkFunc) error {
+	w, ok := m.ttp.(WalkingProvider)
+	if !ok {
+		return errors.New("topics/walk/WalkSubscriptions: the provider cannot enumerate its subscriptions")
+	}
+	w.WalkSubscriptions(fn)
+	return nil
+}
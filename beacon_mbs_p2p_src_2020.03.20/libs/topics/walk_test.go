@@ -0,0 +1,40 @@
+package topics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalkSubscriptions(t *testing.T) {
+	p := NewMemProvider(WithSubscriberSets(0))
+	for _, s := range []struct {
+		filter string
+		sub    Subscriber
+	}{
+		{"a/+", testSubscriber("s1")},
+		{"a/b/#", keyedSubscriber("c1")},
+		{"$share/g/a/+", testSubscriber("w1")},
+		{"#", testSubscriber("s3")},
+	} {
+		_, err := p.Subscribe([]byte(s.filter), 1, s.sub)
+		require.NoError(t, err)
+	}
+
+	var filters []string
+	p.WalkSubscriptions(func(filter string, qos byte, sub Subscriber) bool {
+		require.Equal(t, byte(1), qos)
+		filters = append(filters, filter)
+		return true
+	})
+	require.Equal(t, []string{"#", "a/+", "$share/g/a/+", "a/b/#"}, filters)
+
+	// the walk stops
+	n := 0
+	m := &Manager{ttp: p}
+	require.NoError(t, m.WalkSubscriptions(func(string, byte, Subscriber) bool {
+		n++
+		return n < 2
+	}))
+	require.Equal(t, 2, n)
+}
@@ -2,6 +2,7 @@ package topics_p2p
 
 import (
 	"fmt"
+	"reflect"
 	"sync"
 
 	"awesomeProject/beacon/mqtt_network/libs/topics"
@@ -66,6 +67,19 @@ func (m *memProvider4P2P) Brokers4P2P(topic []byte, brokerList *[]interface{}) e
 	return m.subscribeRoot4P2P.subscriber4P2PMatch(topic, brokerList)
 }
 
+// RemoveBroker4P2P removes the broker from all the topics, it returns the number of topics it
+// was subscribed to.
+func (m *memProvider4P2P) RemoveBroker4P2P(broker interface{}) int {
+	if broker == nil {
+		return 0
+	}
+
+	m.smu4P2P.Lock()
+	defer m.smu4P2P.Unlock()
+
+	return m.subscribeRoot4P2P.subscriber4P2PPurge(broker)
+}
+
 func (m *memProvider4P2P) BrokerTopics4P2PSimpleAction(action SimpleTopicAction) error {
 	return m.brokerTopics4P2PSimpleAction(action)
 }
@@ -87,10 +101,22 @@ func (m *memProvider4P2P) BrokerTopics4P2PSimpleActionFromJSON(data []byte) erro
 	return err
 }
 
+// sameBroker compares the brokers of the topic actions, the ones decoded into a map or a slice
+// never match.
+func sameBroker(b1, b2 interface{}) bool {
+	if t := reflect.TypeOf(b1); t != nil && !t.Comparable() {
+		return false
+	}
+	if t := reflect.TypeOf(b2); t != nil && !t.Comparable() {
+		return false
+	}
+	return b1 == b2
+}
+
 // Let's see if the broker is already on the list.
 func checkExist(brokerList []interface{}, broker interface{}) bool {
 	for i := range brokerList {
-		if topics.Equal(brokerList[i], broker) {
+		if sameBroker(brokerList[i], broker) {
 			return true
 		}
 	}
@@ -204,7 +230,7 @@ func (s *subscribeNode4P2P) subscriber4P2PInsert(topic []byte, broker interface{
 	if len(topic) == 0 {
 		// Let's see if the broker is already on the list. If yes return.
 		for i := range s.brokerList {
-			if topics.Equal(s.brokerList[i], broker) {
+			if sameBroker(s.brokerList[i], broker) {
 				return nil
 			}
 		}
@@ -250,7 +276,7 @@ func (s *subscribeNode4P2P) subscriber4P2PRemove(topic []byte, broker interface{
 		// If we find the brokers then remove it from the list. Technically
 		// we just overwrite the slot by shifting all other items up by one.
 		for i := range s.brokerList {
-			if topics.Equal(s.brokerList[i], broker) {
+			if sameBroker(s.brokerList[i], broker) {
 				s.brokerList = append(s.brokerList[:i], s.brokerList[i+1:]...)
 				return nil
 			}
@@ -290,6 +316,28 @@ func (s *subscribeNode4P2P) subscriber4P2PRemove(topic []byte, broker interface{
 	return nil
 }
 
+// subscriber4P2PPurge removes the broker from this subscribeNode4P2P and all the levels below it,
+// the levels left without brokers are removed.
+func (s *subscribeNode4P2P) subscriber4P2PPurge(broker interface{}) int {
+	removed := 0
+	for i := range s.brokerList {
+		if sameBroker(s.brokerList[i], broker) {
+			s.brokerList = append(s.brokerList[:i], s.brokerList[i+1:]...)
+			removed++
+			break
+		}
+	}
+
+	for level, n := range s.subscribeNodes4P2PMap {
+		removed += n.subscriber4P2PPurge(broker)
+		if len(n.brokerList) == 0 && len(n.subscribeNodes4P2PMap) == 0 {
+			delete(s.subscribeNodes4P2PMap, level)
+		}
+	}
+
+	return removed
+}
+
 // subscriber4P2PMatch() returns all the brokers that are subscribed to the topic. Given a topic
 // with no wildcards (publish topic), it returns a list of brokers that subscribes
 // to the topic. For each of the level names, it's a match
@@ -351,7 +351,7 @@ func TestMemTopicsActions4P2P(t *testing.T) {
 	aeList := make([]ActionElement, 0, 5)
 	aeList = append(aeList, aE1, aE2, aE3)
 
-	tas := TopicActions{hex.EncodeToString([]byte("broker3")), aeList}
+	tas := TopicActions{hex.EncodeToString([]byte("broker3")), hex.EncodeToString([]byte("node3")), aeList}
 	dataD, errD := mgr.BrokerTopics4P2PActionsToJSON(tas)
 	require.NoError(t, errD)
 	jsonD, errJ := tas.Marshal()
@@ -364,3 +364,25 @@ func TestMemTopicsActions4P2P(t *testing.T) {
 	err = mgr.BrokerTopics4P2PActions(tas)
 	require.NoError(t, err)
 }
+
+func TestMemRemoveBroker4P2P(t *testing.T) {
+	p := NewMemProvider4P2P()
+
+	require.NoError(t, p.Subscribe4P2P([]byte("sports/tennis/+/stats"), "broker1"))
+	require.NoError(t, p.Subscribe4P2P([]byte("sports/tennis/#"), "broker1"))
+	require.NoError(t, p.Subscribe4P2P([]byte("sports/tennis/#"), "broker2"))
+	require.NoError(t, p.Subscribe4P2P([]byte("sports/golf"), "broker1"))
+
+	require.Equal(t, 3, p.RemoveBroker4P2P("broker1"))
+	require.Equal(t, 0, p.RemoveBroker4P2P("broker1"))
+	require.Equal(t, 0, p.RemoveBroker4P2P(nil))
+
+	brokers := make([]interface{}, 0, 4)
+	require.NoError(t, p.Brokers4P2P([]byte("sports/tennis/anzel/stats"), &brokers))
+	require.Equal(t, []interface{}{"broker2"}, brokers)
+
+	// the levels of broker1 only are pruned
+	require.Len(t, p.subscribeRoot4P2P.subscribeNodes4P2PMap, 1)
+	require.Len(t, p.subscribeRoot4P2P.subscribeNodes4P2PMap["sports"].subscribeNodes4P2PMap, 1)
+	require.Len(t, p.subscribeRoot4P2P.subscribeNodes4P2PMap["sports"].subscribeNodes4P2PMap["tennis"].subscribeNodes4P2PMap, 1)
+}
@@ -12,6 +12,7 @@ type TheTopicsProvider4P2P interface {
 	Subscribe4P2P(topic []byte, broker interface{}) error
 	Unsubscribe4P2P(topic []byte, broker interface{}) error
 	Brokers4P2P(topic []byte, brokerList *[]interface{}) error
+	RemoveBroker4P2P(broker interface{}) int
 	BrokerTopics4P2PSimpleAction(action SimpleTopicAction) error
 	BrokerTopics4P2PSimpleActionToJSON(action SimpleTopicAction) ([]byte, error)
 	BrokerTopics4P2PSimpleActionFromJSON(data []byte) error
@@ -62,6 +63,11 @@ func (m *Manager4P2P) Brokers4P2P(topic []byte, brokerList *[]interface{}) error
 	return m.ttp.Brokers4P2P(topic, brokerList)
 }
 
+// RemoveBroker4P2P forgets all the topics of a broker which has left the network.
+func (m *Manager4P2P) RemoveBroker4P2P(broker interface{}) int {
+	return m.ttp.RemoveBroker4P2P(broker)
+}
+
 func (m *Manager4P2P) BrokerTopics4P2PSimpleAction(action SimpleTopicAction) error {
 	return m.ttp.BrokerTopics4P2PSimpleAction(action)
 }
@@ -0,0 +1,67 @@
+//go:build otel
+// +build otel
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type otelTracer struct {
+	t trace.Tracer
+}
+
+// OTel adapts the OpenTelemetry tracer, built with the "otel" tag only.
+func OTel(t trace.Tracer) Tracer {
+	return otelTracer{t: t}
+}
+
+func (o otelTracer) Start(name string, parent SpanContext) Span {
+	ctx := context.Background()
+	if parent.Valid() {
+		var flags trace.TraceFlags
+		if parent.Sampled {
+			flags = trace.FlagsSampled
+		}
+		ctx = trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID(parent.TraceID),
+			SpanID:     trace.SpanID(parent.SpanID),
+			TraceFlags: flags,
+			Remote:     true,
+		}))
+	}
+	_, span := o.t.Start(ctx, name)
+	return otelSpan{s: span}
+}
+
+type otelSpan struct {
+	s trace.Span
+}
+
+func (o otelSpan) Context() SpanContext {
+	sc := o.s.SpanContext()
+	return SpanContext{TraceID: sc.TraceID(), SpanID: sc.SpanID(), Sampled: sc.IsSampled()}
+}
+
+func (o otelSpan) SetAttribute(key string, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		o.s.SetAttributes(attribute.String(key, v))
+	case bool:
+		o.s.SetAttributes(attribute.Bool(key, v))
+	case int:
+		o.s.SetAttributes(attribute.Int(key, v))
+	case int64:
+		o.s.SetAttributes(attribute.Int64(key, v))
+	default:
+		o.s.SetAttributes(attribute.String(key, fmt.Sprint(v)))
+	}
+}
+
+func (o otelSpan) End() {
+	o.s.End()
+}
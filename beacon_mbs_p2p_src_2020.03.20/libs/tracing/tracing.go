@@ -0,0 +1,79 @@
+// Package tracing traces the messages through the pipeline of the broker and across the peer
+// brokers. The spans are opened through the Tracer interface, an OpenTelemetry tracer is adapted
+// by OTel with the "otel" build tag. The span context travels as a W3C traceparent, in the user
+// properties of the 5.0 publishes and in the batches forwarded to the peer brokers. The bridges
+// speak 3.1.1 and carry none.
+package tracing
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Header is the user property of the span context, named after the W3C header.
+const Header = "traceparent"
+
+var ErrInvalidTraceparent = errors.New("tracing: invalid traceparent")
+
+// SpanContext identifies a span across the process boundaries.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// Valid reports whether the trace id and the span id are set.
+func (s SpanContext) Valid() bool {
+	return s.TraceID != [16]byte{} && s.SpanID != [8]byte{}
+}
+
+// String returns the traceparent of the span context, such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func (s SpanContext) String() string {
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(s.TraceID[:]), hex.EncodeToString(s.SpanID[:]), flags)
+}
+
+// Parse reads a traceparent of version 00, the fields of the later versions past the flags are
+// ignored.
+func Parse(traceparent string) (SpanContext, error) {
+	var s SpanContext
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return s, ErrInvalidTraceparent
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return s, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(s.TraceID[:], []byte(parts[1])); err != nil {
+		return s, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(s.SpanID[:], []byte(parts[2])); err != nil {
+		return s, ErrInvalidTraceparent
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return s, ErrInvalidTraceparent
+	}
+	if !s.Valid() {
+		return s, ErrInvalidTraceparent
+	}
+	s.Sampled = flags[0]&1 == 1
+	return s, nil
+}
+
+type Span interface {
+	Context() SpanContext
+	SetAttribute(key string, value interface{})
+	End()
+}
+
+type Tracer interface {
+	// Start opens a span, the child of the parent if it's valid, a root span otherwise.
+	Start(name string, parent SpanContext) Span
+}
@@ -0,0 +1,35 @@
+package tracing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceparent(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	s, err := Parse(traceparent)
+	require.NoError(t, err)
+	require.True(t, s.Valid())
+	require.True(t, s.Sampled)
+	require.Equal(t, byte(0x4b), s.TraceID[0])
+	require.Equal(t, traceparent, s.String())
+
+	// a later version may add fields past the flags
+	s, err = Parse("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	require.NoError(t, err)
+	require.False(t, s.Sampled)
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+	} {
+		_, err := Parse(invalid)
+		require.Equal(t, ErrInvalidTraceparent, err, invalid)
+	}
+}
@@ -0,0 +1,117 @@
+package transform
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+func gzipInflate(payload []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return ioutil.ReadAll(zr)
+}
+
+func base64Decode(payload []byte) ([]byte, error) {
+	buf := make([]byte, base64.StdEncoding.DecodedLen(len(payload)))
+	n, err := base64.StdEncoding.Decode(buf, bytes.TrimSpace(payload))
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func cborToJSON(payload []byte) ([]byte, error) {
+	var v interface{}
+	if err := cbor.Unmarshal(payload, &v); err != nil {
+		return nil, err
+	}
+
+	v, err := jsonCompatible(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
+
+func jsonToCbor(payload []byte) ([]byte, error) {
+	var v interface{}
+
+	d := json.NewDecoder(bytes.NewReader(payload))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	return cbor.Marshal(cborCompatible(v))
+}
+
+// The CBOR maps may be keyed by any type, but the JSON objects are keyed by strings only.
+func jsonCompatible(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			var key string
+			switch kt := k.(type) {
+			case string:
+				key = kt
+			case uint64, int64, bool, float64:
+				key = fmt.Sprintf("%v", kt)
+			default:
+				return nil, fmt.Errorf("transform/steps/jsonCompatible: unsupported map key type %T", k)
+			}
+			c, err := jsonCompatible(e)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = c
+		}
+		return m, nil
+	case []interface{}:
+		for i, e := range t {
+			c, err := jsonCompatible(e)
+			if err != nil {
+				return nil, err
+			}
+			t[i] = c
+		}
+		return t, nil
+	case cbor.Tag:
+		return jsonCompatible(t.Content)
+	}
+	return v, nil
+}
+
+// Keeps the JSON integers as CBOR integers instead of floats.
+func cborCompatible(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = cborCompatible(e)
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = cborCompatible(e)
+		}
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		if f, err := t.Float64(); err == nil {
+			return f
+		}
+		return t.String()
+	}
+	return v
+}
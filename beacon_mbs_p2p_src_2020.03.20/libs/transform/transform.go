@@ -0,0 +1,226 @@
+package transform
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"awesomeProject/beacon/mqtt_network/libs/topics"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+const (
+	// GzipInflate decompresses a gzip payload
+	GzipInflate = "gzip_inflate"
+
+	// Base64Decode decodes a standard base64 payload
+	Base64Decode = "base64_decode"
+
+	// CborToJSON converts a CBOR payload to JSON
+	CborToJSON = "cbor_to_json"
+
+	// JSONToCbor converts a JSON payload to CBOR
+	JSONToCbor = "json_to_cbor"
+)
+
+var (
+	stepsMu sync.RWMutex
+	steps   = make(map[string]Step)
+)
+
+// Step transforms a payload, it must not modify the input slice.
+type Step func(payload []byte) ([]byte, error)
+
+func init() {
+	RegisterStep(GzipInflate, gzipInflate)
+	RegisterStep(Base64Decode, base64Decode)
+	RegisterStep(CborToJSON, cborToJSON)
+	RegisterStep(JSONToCbor, jsonToCbor)
+}
+
+// RegisterStep makes a transformation step available by the provided name.
+// If a RegisterStep is called twice with the same name or if the step is nil,
+// it panics.
+func RegisterStep(name string, step Step) {
+	if step == nil {
+		panic("transform: RegisterStep step is nil")
+	}
+
+	stepsMu.Lock()
+	defer stepsMu.Unlock()
+
+	if _, dup := steps[name]; dup {
+		panic("transform: RegisterStep called twice for step " + name)
+	}
+
+	steps[name] = step
+}
+
+func UnregisterStep(name string) {
+	stepsMu.Lock()
+	defer stepsMu.Unlock()
+
+	delete(steps, name)
+}
+
+// Pipeline is an ordered list of steps applied to the payloads published on the topics
+// matching the filter. If Deliver is set, the payload written to the subscribers is the
+// transformed one, otherwise the pipeline only feeds the processing stages (rule-engine,
+// sinks) through Manager.Apply, and the subscribers receive the original payload.
+type Pipeline struct {
+	Filter  string   `json:"filter"`
+	Steps   []string `json:"steps"`
+	Deliver bool     `json:"deliver"`
+
+	seq   uint64
+	funcs []Step
+}
+
+func (p *Pipeline) run(payload []byte) ([]byte, error) {
+	var err error
+	for i, f := range p.funcs {
+		payload, err = f(payload)
+		if err != nil {
+			return nil, fmt.Errorf("transform/transform/run: step [%s] of filter [%s] failed => %v", p.Steps[i], p.Filter, err)
+		}
+	}
+	return payload, nil
+}
+
+// ID keys the pipeline in the topic tree, there's one pipeline by filter.
+func (p *Pipeline) ID() string {
+	return p.Filter
+}
+
+// OnPublish runs the pipeline on the payload of the message.
+func (p *Pipeline) OnPublish(message *packets.PublishPacket) error {
+	payload, err := p.run(message.Payload)
+	if err != nil {
+		return err
+	}
+	message.Payload = payload
+	return nil
+}
+
+// Manager keeps the pipelines in a topic tree, so the pipelines of a topic are found
+// the same way the subscribers of a topic are.
+type Manager struct {
+	mu        sync.RWMutex
+	seq       uint64
+	pipelines map[string]*Pipeline
+	tree      topics.TheTopicsProvider
+}
+
+func NewManager() *Manager {
+	return &Manager{
+		pipelines: make(map[string]*Pipeline),
+		tree:      topics.NewMemProvider(),
+	}
+}
+
+// Add registers the pipeline for its filter, replacing the existing one of the same filter.
+func (m *Manager) Add(pipeline Pipeline) error {
+	if len(pipeline.Steps) == 0 {
+		return fmt.Errorf("transform/transform/Add: No step found for filter [%s]", pipeline.Filter)
+	}
+
+	p := &Pipeline{
+		Filter:  pipeline.Filter,
+		Steps:   append([]string(nil), pipeline.Steps...),
+		Deliver: pipeline.Deliver,
+	}
+
+	stepsMu.RLock()
+	for _, name := range p.Steps {
+		f, ok := steps[name]
+		if !ok {
+			stepsMu.RUnlock()
+			return fmt.Errorf("transform/transform/Add: unknown step %q", name)
+		}
+		p.funcs = append(p.funcs, f)
+	}
+	stepsMu.RUnlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if old, ok := m.pipelines[p.Filter]; ok {
+		if err := m.tree.Unsubscribe([]byte(old.Filter), old); err != nil {
+			return err
+		}
+		delete(m.pipelines, old.Filter)
+	}
+
+	if _, err := m.tree.Subscribe([]byte(p.Filter), topics.QosAtMostOnce, p); err != nil {
+		return err
+	}
+
+	m.seq++
+	p.seq = m.seq
+	m.pipelines[p.Filter] = p
+
+	return nil
+}
+
+func (m *Manager) Remove(filter string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.pipelines[filter]
+	if !ok {
+		return fmt.Errorf("transform/transform/Remove: No pipeline found for filter [%s]", filter)
+	}
+	delete(m.pipelines, filter)
+
+	return m.tree.Unsubscribe([]byte(filter), p)
+}
+
+func (m *Manager) Pipelines() []Pipeline {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	list := make([]Pipeline, 0, len(m.pipelines))
+	for _, p := range m.pipelines {
+		list = append(list, Pipeline{Filter: p.Filter, Steps: p.Steps, Deliver: p.Deliver})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Filter < list[j].Filter })
+
+	return list
+}
+
+// Apply runs the pipelines matching the topic on the payload, in the order they were added.
+// If delivery is set, only the pipelines marked with Deliver are applied. The returned bool
+// reports whether any pipeline matched, the original payload is returned if none did. The
+// publishes are transformed concurrently, only Add and Remove lock them out.
+func (m *Manager) Apply(topic string, payload []byte, delivery bool) ([]byte, bool, error) {
+	var subList []topics.Subscriber
+	var qosList []byte
+	m.mu.RLock()
+	err := m.tree.Subscribers([]byte(topic), topics.QosAtMostOnce, &subList, &qosList)
+	matched := make([]*Pipeline, 0, len(subList))
+	for _, sub := range subList {
+		if p, ok := sub.(*Pipeline); ok && (!delivery || p.Deliver) {
+			matched = append(matched, p)
+		}
+	}
+	m.mu.RUnlock()
+
+	if err != nil {
+		return payload, false, err
+	}
+
+	if len(matched) == 0 {
+		return payload, false, nil
+	}
+
+	sort.Slice(matched, func(i, j int) bool { return matched[i].seq < matched[j].seq })
+
+	for _, p := range matched {
+		if payload, err = p.run(payload); err != nil {
+			return nil, true, err
+		}
+	}
+
+	return payload, true, nil
+}
@@ -0,0 +1,80 @@
+package transform
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformSteps(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte(`{"temp":21}`))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	out, err := gzipInflate(buf.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, `{"temp":21}`, string(out))
+
+	out, err = base64Decode([]byte(base64.StdEncoding.EncodeToString([]byte("abc"))))
+	require.NoError(t, err)
+	require.Equal(t, "abc", string(out))
+
+	data, err := cbor.Marshal(map[interface{}]interface{}{"temp": 21, 7: []interface{}{"a", true}})
+	require.NoError(t, err)
+
+	out, err = cborToJSON(data)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"temp":21,"7":["a",true]}`, string(out))
+
+	data, err = jsonToCbor([]byte(`{"temp":21,"ratio":0.5}`))
+	require.NoError(t, err)
+
+	out, err = cborToJSON(data)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"temp":21,"ratio":0.5}`, string(out))
+
+	_, err = gzipInflate([]byte("not gzip"))
+	require.Error(t, err)
+}
+
+func TestTransformManager(t *testing.T) {
+	m := NewManager()
+
+	require.Error(t, m.Add(Pipeline{Filter: "sensors/#"}))
+	require.Error(t, m.Add(Pipeline{Filter: "sensors/#", Steps: []string{"unknown"}}))
+
+	require.NoError(t, m.Add(Pipeline{Filter: "sensors/+/raw", Steps: []string{Base64Decode}}))
+	require.NoError(t, m.Add(Pipeline{Filter: "sensors/#", Steps: []string{CborToJSON}, Deliver: true}))
+	require.Equal(t, 2, len(m.Pipelines()))
+
+	data, err := cbor.Marshal(map[string]interface{}{"temp": 21})
+	require.NoError(t, err)
+
+	out, matched, err := m.Apply("sensors/room1/raw", []byte(base64.StdEncoding.EncodeToString(data)), false)
+	require.NoError(t, err)
+	require.True(t, matched)
+	require.JSONEq(t, `{"temp":21}`, string(out))
+
+	out, matched, err = m.Apply("sensors/room1", data, true)
+	require.NoError(t, err)
+	require.True(t, matched)
+	require.JSONEq(t, `{"temp":21}`, string(out))
+
+	out, matched, err = m.Apply("devices/room1", data, false)
+	require.NoError(t, err)
+	require.False(t, matched)
+	require.Equal(t, data, out)
+
+	require.NoError(t, m.Remove("sensors/#"))
+	require.Error(t, m.Remove("sensors/#"))
+
+	_, matched, err = m.Apply("sensors/room1", data, true)
+	require.NoError(t, err)
+	require.False(t, matched)
+}